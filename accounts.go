@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BalanceChangeType is what moved an account's balance
+type BalanceChangeType string
+
+const (
+	BalanceDeposit    BalanceChangeType = "deposit"
+	BalanceWithdrawal BalanceChangeType = "withdrawal"
+	BalanceTrade      BalanceChangeType = "trade"
+	BalanceFee        BalanceChangeType = "fee"
+	// BalanceRebate pays a maker a negative maker fee
+	BalanceRebate BalanceChangeType = "rebate"
+	// BalanceRealizedPnL and BalanceFunding settle perpetual positions
+	BalanceRealizedPnL BalanceChangeType = "realized_pnl"
+	BalanceFunding     BalanceChangeType = "funding"
+	// BalanceLiquidationFee, BalanceInsurance and BalanceADL settle liquidations
+	BalanceLiquidationFee BalanceChangeType = "liquidation_fee"
+	BalanceInsurance      BalanceChangeType = "insurance"
+	BalanceADL            BalanceChangeType = "adl"
+	// BalanceExercise and BalanceAssignment settle options at expiry
+	BalanceExercise   BalanceChangeType = "exercise"
+	BalanceAssignment BalanceChangeType = "assignment"
+	// BalanceCommission pays out a share of a fee, such as to a referrer
+	BalanceCommission BalanceChangeType = "commission"
+	// BalanceTransfer moves a balance between an account and its parent
+	BalanceTransfer BalanceChangeType = "transfer"
+)
+
+// Account holds an owner's balances, one per asset. Its ID is the owner name
+// the account's orders are placed under.
+type Account struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+	// ReferrerID is the account that referred this one, if any
+	ReferrerID string `json:"referrer_id,omitempty"`
+	// ParentID is the account this one is a sub-account of, if any
+	ParentID string `json:"parent_id,omitempty" since:"6"`
+	// SharesParent is set on sub-accounts that trade on their parent's
+	// balances, positions and limits instead of keeping their own
+	SharesParent bool `json:"shares_parent,omitempty" since:"6"`
+	// PositionLimits are the account's own position limits by symbol, in
+	// place of -position-limits
+	PositionLimits map[string]int `json:"position_limits,omitempty" since:"6"`
+	// Balances are read from the ledger, which is the only place they are kept
+	Balances  map[string]float64 `json:"balances"`
+	CreatedAt time.Time          `json:"created_at"`
+}
+
+// BalanceChange is one ledger entry seen from its account, with the balance it left
+type BalanceChange struct {
+	Sequence      int64             `json:"sequence"`
+	TransactionID string            `json:"transaction_id"`
+	AccountID     string            `json:"account_id"`
+	Asset         string            `json:"asset"`
+	Type          BalanceChangeType `json:"type"`
+	// Amount is signed: withdrawals, purchases paid for and fees are negative
+	Amount  float64 `json:"amount"`
+	Balance float64 `json:"balance"`
+	// Reference is the trade a trade or fee change belongs to
+	Reference string    `json:"reference,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CreateAccountRequest is the body for opening an account. Without an ID,
+// one is generated.
+type CreateAccountRequest struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+	// ReferrerID names an open account that referred this one
+	ReferrerID string `json:"referrer_id,omitempty"`
+	// ParentID opens the account as a sub-account of an open account
+	ParentID       string         `json:"parent_id,omitempty"`
+	SharesParent   bool           `json:"shares_parent,omitempty"`
+	PositionLimits map[string]int `json:"position_limits,omitempty"`
+}
+
+// validate requires a parent to share and positive position limits of the
+// account's own, which a sub-account sharing its parent's cannot have
+func (req CreateAccountRequest) validate() []FieldError {
+	var errs []FieldError
+	if req.SharesParent && req.ParentID == "" {
+		errs = append(errs, fieldError("parent_id", "required", "a sub-account sharing its parent's balances needs a parent_id"))
+	}
+	if req.SharesParent && len(req.PositionLimits) > 0 {
+		errs = append(errs, fieldError("position_limits", "excluded", "a sub-account sharing its parent's balances trades on its parent's position limits"))
+	}
+	for symbol, limit := range req.PositionLimits {
+		if limit <= 0 {
+			errs = append(errs, fieldError("position_limits", "gt", "position_limits must be positive (received: %d for %s)", limit, symbol))
+			break
+		}
+	}
+	return errs
+}
+
+// BalanceRequest is the body for a deposit or withdrawal
+type BalanceRequest struct {
+	Asset  string  `json:"asset" validate:"required"`
+	Amount float64 `json:"amount" validate:"gt=0"`
+}
+
+// AccountsResponse lists accounts, oldest first
+type AccountsResponse struct {
+	Accounts []Account `json:"accounts"`
+	Count    int       `json:"count"`
+}
+
+// BalanceHistoryResponse lists an account's balance changes, oldest first
+type BalanceHistoryResponse struct {
+	AccountID string          `json:"account_id"`
+	Changes   []BalanceChange `json:"changes"`
+	Count     int             `json:"count"`
+}
+
+// accountsMu guards the accounts and the ledger
+var (
+	accountsMu sync.Mutex
+	accounts   = make(map[string]*Account)
+)
+
+// createAccount opens an empty account, or returns false if the ID is taken.
+// The ledger's own accounts count as taken.
+func createAccount(req CreateAccountRequest) (Account, bool) {
+	id := req.ID
+	if id == "" {
+		id = generateOrderID()
+	}
+
+	accountsMu.Lock()
+	defer accountsMu.Unlock()
+	if _, ok := accounts[id]; ok || id == externalAccount || id == feeAccount || id == clearingAccount || id == insuranceAccount {
+		return Account{}, false
+	}
+	account := &Account{ID: id, Name: req.Name, ReferrerID: req.ReferrerID, ParentID: req.ParentID,
+		SharesParent: req.SharesParent, PositionLimits: req.PositionLimits, CreatedAt: engineClock.Now()}
+	accounts[id] = account
+	return account.snapshot(), true
+}
+
+// snapshot returns a copy of the account with its ledger balances. It must be
+// called with accountsMu held.
+func (a *Account) snapshot() Account {
+	account := *a
+	account.Balances = make(map[string]float64, len(ledgerBalances[a.ID]))
+	for asset, balance := range ledgerBalances[a.ID] {
+		account.Balances[asset] = balance
+	}
+	return account
+}
+
+// lookupAccount returns a copy of the account with the given ID
+func lookupAccount(id string) (Account, bool) {
+	accountsMu.Lock()
+	defer accountsMu.Unlock()
+
+	account, ok := accounts[id]
+	if !ok {
+		return Account{}, false
+	}
+	return account.snapshot(), true
+}
+
+// accountSnapshots returns every account, oldest first
+func accountSnapshots() []Account {
+	accountsMu.Lock()
+	list := make([]Account, 0, len(accounts))
+	for _, account := range accounts {
+		list = append(list, account.snapshot())
+	}
+	accountsMu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.Before(list[j].CreatedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// adjustBalance moves amount of asset into (or, when negative, out of) an
+// account from the external account. A withdrawal larger than the balance is
+// refused and changes nothing, and so is any movement on a sub-account that
+// shares its parent's balances.
+func adjustBalance(id, asset string, amount float64, kind BalanceChangeType) (BalanceChange, ErrorCode) {
+	accountsMu.Lock()
+	defer accountsMu.Unlock()
+
+	account, ok := accounts[id]
+	if !ok {
+		return BalanceChange{}, ErrCodeAccountNotFound
+	}
+	if account.SharesParent {
+		return BalanceChange{}, ErrCodeSharedSubAccount
+	}
+	if ledgerBalances[id][asset]+amount < 0 {
+		return BalanceChange{}, ErrCodeInsufficientFunds
+	}
+
+	entries := postLocked(kind, "", []posting{
+		{account: id, asset: asset, amount: amount},
+		{account: externalAccount, asset: asset, amount: -amount},
+	})
+	return entries[0].balanceChange(), ""
+}
+
+// balanceHistory returns an account's balance changes, optionally for one asset
+func balanceHistory(id, asset string) ([]BalanceChange, bool) {
+	accountsMu.Lock()
+	defer accountsMu.Unlock()
+
+	if _, ok := accounts[id]; !ok {
+		return nil, false
+	}
+	changes := make([]BalanceChange, 0)
+	for _, entry := range ledgerEntries {
+		if entry.Account == id && (asset == "" || entry.Asset == asset) {
+			changes = append(changes, entry.balanceChange())
+		}
+	}
+	return changes, true
+}
+
+// createAccountHandler opens an account with no balances
+func createAccountHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req CreateAccountRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if _, ok := lookupAccount(req.ReferrerID); req.ReferrerID != "" && !ok {
+		writeError(w, http.StatusNotFound, ErrCodeAccountNotFound, "Referrer not found",
+			"no account with ID '"+req.ReferrerID+"' to refer this one")
+		return
+	}
+	if _, ok := lookupAccount(req.ParentID); req.ParentID != "" && !ok {
+		writeError(w, http.StatusNotFound, ErrCodeAccountNotFound, "Parent not found",
+			"no account with ID '"+req.ParentID+"' to open this one under")
+		return
+	}
+	for symbol := range req.PositionLimits {
+		if _, ok := matcherFor(symbol); !ok {
+			writeError(w, http.StatusBadRequest, ErrCodeUnknownSymbol, "Unknown symbol",
+				"position_limits symbol '"+symbol+"' is not traded here")
+			return
+		}
+	}
+	account, ok := createAccount(req)
+	if !ok {
+		writeError(w, http.StatusConflict, ErrCodeAccountExists, "Account already exists",
+			"an account with ID '"+req.ID+"' is already open")
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(account)
+}
+
+// getAccountsHandler returns every account and its balances
+func getAccountsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	list := accountSnapshots()
+	json.NewEncoder(w).Encode(AccountsResponse{
+		Accounts: list,
+		Count:    len(list),
+	})
+}
+
+// getAccountHandler returns the balances of the account named in the path
+func getAccountHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id := r.PathValue("id")
+	account, ok := lookupAccount(id)
+	if !ok {
+		writeAccountNotFound(w, id)
+		return
+	}
+	json.NewEncoder(w).Encode(account)
+}
+
+// depositHandler credits the account named in the path
+func depositHandler(w http.ResponseWriter, r *http.Request) {
+	moveBalance(w, r, BalanceDeposit)
+}
+
+// withdrawHandler debits the account named in the path
+func withdrawHandler(w http.ResponseWriter, r *http.Request) {
+	moveBalance(w, r, BalanceWithdrawal)
+}
+
+// moveBalance applies a deposit or withdrawal request and writes the change
+func moveBalance(w http.ResponseWriter, r *http.Request, kind BalanceChangeType) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req BalanceRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	amount := req.Amount
+	if kind == BalanceWithdrawal {
+		amount = -amount
+	}
+
+	id := r.PathValue("id")
+	change, code := adjustBalance(id, req.Asset, amount, kind)
+	switch code {
+	case ErrCodeAccountNotFound:
+		writeAccountNotFound(w, id)
+		return
+	case ErrCodeInsufficientFunds:
+		writeError(w, http.StatusUnprocessableEntity, ErrCodeInsufficientFunds, "Insufficient funds",
+			"the account does not hold enough "+req.Asset+" to withdraw")
+		return
+	case ErrCodeSharedSubAccount:
+		writeSharedSubAccount(w)
+		return
+	}
+	json.NewEncoder(w).Encode(change)
+}
+
+// getBalanceHistoryHandler returns the balance changes of the account named in the path
+func getBalanceHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id := r.PathValue("id")
+	changes, ok := balanceHistory(id, r.URL.Query().Get("asset"))
+	if !ok {
+		writeAccountNotFound(w, id)
+		return
+	}
+	json.NewEncoder(w).Encode(BalanceHistoryResponse{
+		AccountID: id,
+		Changes:   changes,
+		Count:     len(changes),
+	})
+}
+
+// writeAccountNotFound reports an account ID the server does not know
+func writeAccountNotFound(w http.ResponseWriter, id string) {
+	writeError(w, http.StatusNotFound, ErrCodeAccountNotFound, "Account not found",
+		"no account with ID '"+id+"'")
+}
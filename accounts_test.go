@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// postAccount sends a JSON body to an accounts endpoint
+func postAccount(path, body string) *httptest.ResponseRecorder {
+	return serve(HTTPConfig{}, httptest.NewRequest("POST", "/api/v1/accounts"+path, strings.NewReader(body)))
+}
+
+func TestAccounts_DepositAndWithdraw(t *testing.T) {
+	setupTest()
+
+	response := postAccount("", `{"id":"alice","name":"Alice"}`)
+	var account Account
+	json.NewDecoder(response.Body).Decode(&account)
+	if response.Code != http.StatusCreated || account.ID != "alice" || len(account.Balances) != 0 {
+		t.Fatalf("Expected an empty account, got %d %+v", response.Code, account)
+	}
+
+	postAccount("/alice/deposit", `{"asset":"USD","amount":1000}`)
+	postAccount("/alice/deposit", `{"asset":"BTC","amount":2.5}`)
+	response = postAccount("/alice/withdraw", `{"asset":"USD","amount":250}`)
+	var change BalanceChange
+	json.NewDecoder(response.Body).Decode(&change)
+	if response.Code != http.StatusOK || change.Type != BalanceWithdrawal || change.Amount != -250 || change.Balance != 750 {
+		t.Errorf("Unexpected withdrawal %d %+v", response.Code, change)
+	}
+
+	// An overdraft is refused and leaves the balance alone
+	response = postAccount("/alice/withdraw", `{"asset":"USD","amount":750.01}`)
+	if result := decodeError(t, response); response.Code != http.StatusUnprocessableEntity || result.Error.Code != ErrCodeInsufficientFunds {
+		t.Errorf("Expected an overdraft to be refused, got %d %s", response.Code, result.Error.Code)
+	}
+
+	response = serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/accounts/alice", nil))
+	json.NewDecoder(response.Body).Decode(&account)
+	if account.Balances["USD"] != 750 || account.Balances["BTC"] != 2.5 {
+		t.Errorf("Unexpected balances %+v", account.Balances)
+	}
+
+	response = serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/accounts/alice/history?asset=USD", nil))
+	var history BalanceHistoryResponse
+	json.NewDecoder(response.Body).Decode(&history)
+	if history.Count != 2 || history.Changes[0].Type != BalanceDeposit || history.Changes[1].Balance != 750 {
+		t.Errorf("Expected the two USD changes, got %+v", history)
+	}
+}
+
+func TestAccounts_RefusesBadRequests(t *testing.T) {
+	setupTest()
+	postAccount("", `{"id":"alice"}`)
+
+	response := postAccount("", `{"id":"alice"}`)
+	if result := decodeError(t, response); response.Code != http.StatusConflict || result.Error.Code != ErrCodeAccountExists {
+		t.Errorf("Expected a duplicate ID to be refused, got %d %s", response.Code, result.Error.Code)
+	}
+	response = postAccount("/bob/deposit", `{"asset":"USD","amount":1}`)
+	if result := decodeError(t, response); response.Code != http.StatusNotFound || result.Error.Code != ErrCodeAccountNotFound {
+		t.Errorf("Expected an unknown account, got %d %s", response.Code, result.Error.Code)
+	}
+	response = postAccount("/alice/deposit", `{"asset":"USD","amount":-5}`)
+	if result := decodeError(t, response); response.Code != http.StatusBadRequest || result.Error.Code != ErrCodeValidationFailed {
+		t.Errorf("Expected a negative amount to fail validation, got %d %s", response.Code, result.Error.Code)
+	}
+
+	// Without an ID the account gets a generated one
+	response = postAccount("", `{}`)
+	var account Account
+	json.NewDecoder(response.Body).Decode(&account)
+	response = serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/accounts", nil))
+	var list AccountsResponse
+	json.NewDecoder(response.Body).Decode(&list)
+	if account.ID == "" || list.Count != 2 || list.Accounts[0].ID != "alice" {
+		t.Errorf("Expected alice and a generated account, got %+v", list)
+	}
+}
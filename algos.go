@@ -0,0 +1,412 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// AlgoSchedule decides how a parent order's quantity is spread over its horizon
+type AlgoSchedule string
+
+const (
+	// AlgoScheduleVWAP sizes each slice by the volume expected in its interval
+	AlgoScheduleVWAP AlgoSchedule = "vwap"
+	// AlgoScheduleTWAP sends the same quantity in every slice
+	AlgoScheduleTWAP AlgoSchedule = "twap"
+)
+
+// AlgoStatus is where a parent order is in its schedule
+type AlgoStatus string
+
+const (
+	AlgoStatusRunning   AlgoStatus = "running"
+	AlgoStatusFilled    AlgoStatus = "filled"
+	AlgoStatusExpired   AlgoStatus = "expired"
+	AlgoStatusCancelled AlgoStatus = "cancelled"
+)
+
+// defaultAlgoSlices is how many slices a parent order runs when it names none
+const defaultAlgoSlices = 10
+
+// AlgoRequest is the body for starting a parent order
+type AlgoRequest struct {
+	Symbol     string  `json:"symbol,omitempty"`
+	Side       Side    `json:"side" validate:"required,oneof=buy sell"`
+	Quantity   int     `json:"quantity" validate:"gt=0"`
+	LimitPrice float64 `json:"limit_price" validate:"gt=0"`
+	// Horizon is how long the schedule runs for, as a Go duration such as "30m"
+	Horizon  string       `json:"horizon" validate:"required,duration"`
+	Slices   int          `json:"slices,omitempty" validate:"min=0,max=1000"`
+	Schedule AlgoSchedule `json:"schedule,omitempty" validate:"omitempty,oneof=vwap twap"`
+	Owner    string       `json:"owner,omitempty"`
+	// VolumeProfile weights the slices of a VWAP schedule. Without one, the
+	// symbol's traded volume over the previous horizon is used.
+	VolumeProfile []float64 `json:"volume_profile,omitempty"`
+}
+
+// AlgoOrder is a parent order and its aggregate fill progress
+type AlgoOrder struct {
+	ID           string       `json:"id"`
+	Symbol       string       `json:"symbol"`
+	Side         Side         `json:"side"`
+	Quantity     int          `json:"quantity"`
+	LimitPrice   float64      `json:"limit_price"`
+	Schedule     AlgoSchedule `json:"schedule"`
+	Owner        string       `json:"owner,omitempty"`
+	Status       AlgoStatus   `json:"status"`
+	Slices       int          `json:"slices"`
+	SlicesSent   int          `json:"slices_sent"`
+	Filled       int          `json:"filled"`
+	AveragePrice float64      `json:"average_price,omitempty"`
+	// Plan is the quantity each slice aims to have filled by, cumulatively
+	Plan      []int     `json:"plan"`
+	ChildIDs  []string  `json:"child_ids"`
+	StartedAt time.Time `json:"started_at"`
+	EndsAt    time.Time `json:"ends_at"`
+
+	notional float64
+}
+
+// AlgosResponse lists parent orders, oldest first
+type AlgosResponse struct {
+	Algos []AlgoOrder `json:"algos"`
+	Count int         `json:"count"`
+}
+
+// algo is a running parent order; its mutex guards the order's progress
+type algo struct {
+	mu     sync.Mutex
+	order  AlgoOrder
+	cancel context.CancelFunc
+}
+
+var (
+	algosMu sync.Mutex
+	algos   = make(map[string]*algo)
+)
+
+// applyDefaults fills in the schedule and slice count and returns the horizon.
+// It must only be called once the request has been validated.
+func (req *AlgoRequest) applyDefaults() time.Duration {
+	if req.Schedule == "" {
+		req.Schedule = AlgoScheduleVWAP
+	}
+	req.Slices = req.sliceCount()
+
+	horizon, _ := time.ParseDuration(req.Horizon)
+	return horizon
+}
+
+// sliceCount is how many slices the request runs: the default when it names
+// none, and never more than one per unit of quantity
+func (req AlgoRequest) sliceCount() int {
+	slices := req.Slices
+	if slices == 0 {
+		slices = defaultAlgoSlices
+	}
+	if slices > req.Quantity && req.Quantity > 0 {
+		slices = req.Quantity
+	}
+	return slices
+}
+
+// validate checks a volume profile against the schedule and slice count
+func (req AlgoRequest) validate() []FieldError {
+	if len(req.VolumeProfile) == 0 {
+		return nil
+	}
+
+	var errs []FieldError
+	if req.Schedule == AlgoScheduleTWAP {
+		errs = append(errs, fieldError("volume_profile", "excluded", "volume_profile only applies to a vwap schedule"))
+	} else if slices := req.sliceCount(); len(req.VolumeProfile) != slices {
+		errs = append(errs, fieldError("volume_profile", "len", "volume_profile needs one weight per slice (%d, received %d)", slices, len(req.VolumeProfile)))
+	}
+	for _, weight := range req.VolumeProfile {
+		if weight < 0 {
+			errs = append(errs, fieldError("volume_profile", "min", "volume_profile weights cannot be negative"))
+			break
+		}
+	}
+	return errs
+}
+
+// historicalProfile weights the slices by the symbol's traded volume in the
+// matching intervals of the previous horizon
+func historicalProfile(symbol string, start time.Time, horizon time.Duration, slices int) []float64 {
+	profile := make([]float64, slices)
+	from := start.Add(-horizon)
+	interval := horizon / time.Duration(slices)
+
+	for _, trade := range tradeStore.List(symbol) {
+		if trade.CreatedAt.Before(from) || !trade.CreatedAt.Before(start) {
+			continue
+		}
+		slice := int(trade.CreatedAt.Sub(from) / interval)
+		if slice < slices {
+			profile[slice] += float64(trade.Quantity)
+		}
+	}
+	return profile
+}
+
+// planSlices turns slice weights into cumulative fill targets that end at
+// quantity. Equal or all-zero weights give a TWAP plan.
+func planSlices(quantity int, weights []float64) []int {
+	total := 0.0
+	for _, weight := range weights {
+		total += weight
+	}
+
+	plan := make([]int, len(weights))
+	cumulative := 0.0
+	for i, weight := range weights {
+		if total > 0 {
+			cumulative += weight / total
+		} else {
+			cumulative += 1 / float64(len(weights))
+		}
+		plan[i] = int(cumulative*float64(quantity) + 0.5)
+	}
+	plan[len(plan)-1] = quantity
+	return plan
+}
+
+// newAlgoOrder builds a parent order from a validated request
+func newAlgoOrder(req AlgoRequest, symbol string, horizon time.Duration) AlgoOrder {
+	start := engineClock.Now()
+
+	weights := req.VolumeProfile
+	if req.Schedule == AlgoScheduleVWAP && len(weights) == 0 {
+		weights = historicalProfile(symbol, start, horizon, req.Slices)
+	}
+	if req.Schedule == AlgoScheduleTWAP {
+		weights = make([]float64, req.Slices)
+	}
+
+	return AlgoOrder{
+		ID:         generateOrderID(),
+		Symbol:     symbol,
+		Side:       req.Side,
+		Quantity:   req.Quantity,
+		LimitPrice: req.LimitPrice,
+		Schedule:   req.Schedule,
+		Owner:      req.Owner,
+		Status:     AlgoStatusRunning,
+		Slices:     req.Slices,
+		Plan:       planSlices(req.Quantity, weights),
+		ChildIDs:   []string{},
+		StartedAt:  start,
+		EndsAt:     start.Add(horizon),
+	}
+}
+
+// sendSlice places the child order for the next slice. The child is an IOC
+// limit at the parent's limit price sized to catch up with the plan, so
+// anything a slice misses rolls into the next one.
+func (a *algo) sendSlice(m *matcher) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	parent := &a.order
+	if parent.Status != AlgoStatusRunning || parent.SlicesSent >= parent.Slices {
+		return
+	}
+
+	target := parent.Plan[parent.SlicesSent]
+	parent.SlicesSent++
+	if quantity := target - parent.Filled; quantity > 0 {
+		child := Order{
+			ID:          generateOrderID(),
+			Symbol:      parent.Symbol,
+			Side:        parent.Side,
+			Quantity:    quantity,
+			Price:       parent.LimitPrice,
+			Status:      OrderStatusPending,
+			CreatedAt:   engineClock.Now(),
+			Owner:       parent.Owner,
+			TimeInForce: TimeInForceIOC,
+		}
+		parent.ChildIDs = append(parent.ChildIDs, child.ID)
+
+		var fills []Trade
+		m.do(func() {
+			result := processOrder(child)
+			fills = tradeStore.TakerFills(child.ID, child.Quantity-result.Quantity)
+		})
+		for _, fill := range fills {
+			parent.Filled += fill.Quantity
+			parent.notional += fill.Price * float64(fill.Quantity)
+		}
+		if parent.Filled > 0 {
+			parent.AveragePrice = parent.notional / float64(parent.Filled)
+		}
+	}
+
+	switch {
+	case parent.Filled >= parent.Quantity:
+		parent.Status = AlgoStatusFilled
+	case parent.SlicesSent == parent.Slices:
+		parent.Status = AlgoStatusExpired
+	}
+}
+
+// run sends each slice at the start of its interval until the schedule ends
+// or ctx is cancelled
+func (a *algo) run(ctx context.Context, m *matcher) {
+	a.mu.Lock()
+	start, slices := a.order.StartedAt, a.order.Slices
+	interval := a.order.EndsAt.Sub(start) / time.Duration(slices)
+	a.mu.Unlock()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for slice := 0; slice < slices; slice++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		a.sendSlice(m)
+		if a.snapshot().Status != AlgoStatusRunning {
+			return
+		}
+		timer.Reset(time.Until(start.Add(time.Duration(slice+1) * interval)))
+	}
+}
+
+// snapshot returns a copy of the parent order's progress
+func (a *algo) snapshot() AlgoOrder {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	order := a.order
+	order.Plan = append([]int(nil), order.Plan...)
+	order.ChildIDs = append([]string(nil), order.ChildIDs...)
+	return order
+}
+
+// startAlgo registers a parent order and hands it to the background scheduler
+func startAlgo(order AlgoOrder, m *matcher) *algo {
+	ctx, cancel := context.WithCancel(context.Background())
+	a := &algo{order: order, cancel: cancel}
+
+	algosMu.Lock()
+	algos[order.ID] = a
+	algosMu.Unlock()
+
+	go a.run(ctx, m)
+	return a
+}
+
+// cancelAlgo stops a running parent order; children already sent are unaffected
+func cancelAlgo(id string) (AlgoOrder, bool) {
+	algosMu.Lock()
+	a, ok := algos[id]
+	algosMu.Unlock()
+	if !ok {
+		return AlgoOrder{}, false
+	}
+
+	a.cancel()
+	a.mu.Lock()
+	if a.order.Status == AlgoStatusRunning {
+		a.order.Status = AlgoStatusCancelled
+	}
+	a.mu.Unlock()
+	return a.snapshot(), true
+}
+
+// algoSnapshots returns every parent order, oldest first
+func algoSnapshots() []AlgoOrder {
+	algosMu.Lock()
+	list := make([]*algo, 0, len(algos))
+	for _, a := range algos {
+		list = append(list, a)
+	}
+	algosMu.Unlock()
+
+	orders := make([]AlgoOrder, 0, len(list))
+	for _, a := range list {
+		orders = append(orders, a.snapshot())
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		if !orders[i].StartedAt.Equal(orders[j].StartedAt) {
+			return orders[i].StartedAt.Before(orders[j].StartedAt)
+		}
+		return orders[i].ID < orders[j].ID
+	})
+	return orders
+}
+
+// startAlgoHandler starts a VWAP or TWAP parent order
+func startAlgoHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req AlgoRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	horizon := req.applyDefaults()
+
+	m, ok := matcherFor(req.Symbol)
+	if !ok {
+		writeError(w, http.StatusBadRequest, ErrCodeUnknownSymbol, "Unknown symbol",
+			"symbol '"+req.Symbol+"' is not traded here")
+		return
+	}
+
+	a := startAlgo(newAlgoOrder(req, m.symbol, horizon), m)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(a.snapshot())
+}
+
+// getAlgosHandler returns the progress of every parent order
+func getAlgosHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	orders := algoSnapshots()
+	json.NewEncoder(w).Encode(AlgosResponse{
+		Algos: orders,
+		Count: len(orders),
+	})
+}
+
+// getAlgoHandler returns the progress of the parent order named in the path
+func getAlgoHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id := r.PathValue("id")
+	algosMu.Lock()
+	a, ok := algos[id]
+	algosMu.Unlock()
+	if !ok {
+		writeAlgoNotFound(w, id)
+		return
+	}
+	json.NewEncoder(w).Encode(a.snapshot())
+}
+
+// cancelAlgoHandler stops the running parent order named in the path
+func cancelAlgoHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id := r.PathValue("id")
+	order, ok := cancelAlgo(id)
+	if !ok {
+		writeAlgoNotFound(w, id)
+		return
+	}
+	json.NewEncoder(w).Encode(order)
+}
+
+// writeAlgoNotFound reports a parent order ID the server does not know
+func writeAlgoNotFound(w http.ResponseWriter, id string) {
+	writeError(w, http.StatusNotFound, ErrCodeOrderNotFound, "Algo not found",
+		"no parent order with ID '"+id+"'")
+}
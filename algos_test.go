@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestAlgo builds a parent order without starting its scheduler, so the
+// test can send slices itself
+func newTestAlgo(side Side, quantity int, limit float64, plan []int) *algo {
+	return &algo{order: AlgoOrder{
+		ID:         generateOrderID(),
+		Side:       side,
+		Quantity:   quantity,
+		LimitPrice: limit,
+		Schedule:   AlgoScheduleTWAP,
+		Status:     AlgoStatusRunning,
+		Slices:     len(plan),
+		Plan:       plan,
+		ChildIDs:   []string{},
+	}}
+}
+
+func TestPlanSlices(t *testing.T) {
+	tests := []struct {
+		name     string
+		quantity int
+		weights  []float64
+		want     []int
+	}{
+		{"equal weights", 100, []float64{1, 1, 1, 1}, []int{25, 50, 75, 100}},
+		{"no volume falls back to equal", 10, []float64{0, 0, 0}, []int{3, 7, 10}},
+		{"volume profile", 100, []float64{1, 3, 0, 1}, []int{20, 80, 80, 100}},
+	}
+
+	for _, tt := range tests {
+		if got := planSlices(tt.quantity, tt.weights); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestHistoricalProfile(t *testing.T) {
+	setupTest()
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	clock := useDeterministicEngine(t, start.Add(-50*time.Minute))
+
+	trade := func(quantity int) {
+		processOrder(Order{ID: generateOrderID(), Symbol: "DEFAULT", Side: SideSell, Price: 100.0, Quantity: quantity, Status: OrderStatusPending, CreatedAt: clock.Now()})
+		processOrder(Order{ID: generateOrderID(), Symbol: "DEFAULT", Side: SideBuy, Price: 100.0, Quantity: quantity, Status: OrderStatusPending, CreatedAt: clock.Now()})
+	}
+	trade(1)
+	clock.Advance(30 * time.Minute)
+	trade(3)
+	// Volume from after the schedule starts says nothing about the past
+	clock.Advance(time.Hour)
+	trade(50)
+
+	if got := historicalProfile("DEFAULT", start, time.Hour, 2); !reflect.DeepEqual(got, []float64{1, 3}) {
+		t.Errorf("Expected profile [1 3], got %v", got)
+	}
+}
+
+func TestAlgoSlice_CatchesUpAfterMissedSlice(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+	a := newTestAlgo(SideBuy, 30, 101.0, []int{10, 20, 30})
+
+	placeOn(m, Order{ID: "ask-1", Side: SideSell, Price: 100.0, Quantity: 10})
+	a.sendSlice(m)
+
+	// Nothing rests at or under the limit, so the second child misses
+	placeOn(m, Order{ID: "ask-2", Side: SideSell, Price: 102.0, Quantity: 50})
+	a.sendSlice(m)
+
+	progress := a.snapshot()
+	if progress.Filled != 10 || progress.Status != AlgoStatusRunning {
+		t.Fatalf("Expected 10 filled and still running, got %+v", progress)
+	}
+
+	placeOn(m, Order{ID: "ask-3", Side: SideSell, Price: 101.0, Quantity: 50})
+	a.sendSlice(m)
+
+	progress = a.snapshot()
+	if progress.Filled != 30 || progress.Status != AlgoStatusFilled || progress.SlicesSent != 3 {
+		t.Errorf("Expected the last slice to catch up to 30, got %+v", progress)
+	}
+	if !approxEqual(progress.AveragePrice, (100.0*10+101.0*20)/30) {
+		t.Errorf("Expected average price %.4f, got %.4f", (100.0*10+101.0*20)/30, progress.AveragePrice)
+	}
+	if len(progress.ChildIDs) != 3 {
+		t.Errorf("Expected 3 child orders, got %v", progress.ChildIDs)
+	}
+
+	// IOC children never rest, so only the untouched asks remain
+	if len(m.book.bids.orders()) != 0 {
+		t.Errorf("Expected no resting child orders, got %d", len(m.book.bids.orders()))
+	}
+}
+
+func TestAlgoSlice_ExpiresUnfilled(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+	a := newTestAlgo(SideSell, 10, 100.0, []int{5, 10})
+
+	placeOn(m, Order{ID: "bid-1", Side: SideBuy, Price: 100.0, Quantity: 4})
+	a.sendSlice(m)
+	a.sendSlice(m)
+	a.sendSlice(m)
+
+	progress := a.snapshot()
+	if progress.Status != AlgoStatusExpired || progress.Filled != 4 || progress.SlicesSent != 2 {
+		t.Errorf("Expected the schedule to end with 4 filled, got %+v", progress)
+	}
+}
+
+// postAlgo calls an algo handler with a JSON body
+func postAlgo(handler http.HandlerFunc, body interface{}) *httptest.ResponseRecorder {
+	jsonData, _ := json.Marshal(body)
+	request := httptest.NewRequest("POST", "/api/v1/algos", bytes.NewBuffer(jsonData))
+	response := httptest.NewRecorder()
+	handler(response, request)
+	return response
+}
+
+func TestAlgoHandlers_StartReportAndCancel(t *testing.T) {
+	setupTest()
+
+	response := postAlgo(startAlgoHandler, AlgoRequest{
+		Side:       SideBuy,
+		Quantity:   100,
+		LimitPrice: 100.0,
+		Horizon:    "1h",
+		Slices:     4,
+		Schedule:   AlgoScheduleTWAP,
+	})
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d: %s", response.Code, response.Body.String())
+	}
+
+	var started AlgoOrder
+	json.NewDecoder(response.Body).Decode(&started)
+	t.Cleanup(func() { cancelAlgo(started.ID) })
+
+	if !reflect.DeepEqual(started.Plan, []int{25, 50, 75, 100}) || started.Symbol != "DEFAULT" {
+		t.Errorf("Unexpected parent order %+v", started)
+	}
+	if started.EndsAt.Sub(started.StartedAt) != time.Hour {
+		t.Errorf("Expected a one hour horizon, got %v", started.EndsAt.Sub(started.StartedAt))
+	}
+
+	response = serve(HTTPConfig{}, httptest.NewRequest("DELETE", "/api/v1/algos/"+started.ID, nil))
+	var cancelled AlgoOrder
+	json.NewDecoder(response.Body).Decode(&cancelled)
+	if cancelled.Status != AlgoStatusCancelled {
+		t.Errorf("Expected the parent order to be cancelled, got %s", cancelled.Status)
+	}
+
+	recorder := serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/algos/"+started.ID, nil))
+
+	var progress AlgoOrder
+	json.NewDecoder(recorder.Body).Decode(&progress)
+	if progress.ID != started.ID || progress.Status != AlgoStatusCancelled || progress.Filled != 0 {
+		t.Errorf("Unexpected progress %+v", progress)
+	}
+
+	recorder = serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/algos/missing", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown algo, got %d", recorder.Code)
+	}
+}
+
+func TestStartAlgoHandler_Validation(t *testing.T) {
+	setupTest()
+
+	requests := []AlgoRequest{
+		{Side: "hold", Quantity: 10, LimitPrice: 100.0, Horizon: "1h"},
+		{Side: SideBuy, Quantity: 0, LimitPrice: 100.0, Horizon: "1h"},
+		{Side: SideBuy, Quantity: 10, LimitPrice: 100.0, Horizon: "soon"},
+		{Side: SideBuy, Quantity: 10, LimitPrice: 100.0, Horizon: "1h", Schedule: "pov"},
+		{Side: SideBuy, Quantity: 10, LimitPrice: 100.0, Horizon: "1h", Slices: 2, VolumeProfile: []float64{1}},
+		{Side: SideBuy, Quantity: 10, LimitPrice: 100.0, Horizon: "1h", Slices: 2, Schedule: AlgoScheduleTWAP, VolumeProfile: []float64{1, 1}},
+	}
+	for _, req := range requests {
+		if response := postAlgo(startAlgoHandler, req); response.Code != http.StatusBadRequest {
+			t.Errorf("%+v: expected status 400, got %d", req, response.Code)
+		}
+	}
+
+	response := postAlgo(startAlgoHandler, AlgoRequest{Symbol: "NOPE", Side: SideBuy, Quantity: 10, LimitPrice: 100.0, Horizon: "1h"})
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown symbol, got %d", response.Code)
+	}
+	if len(algoSnapshots()) != 0 {
+		t.Errorf("Expected no parent orders to start")
+	}
+}
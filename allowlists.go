@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// inPrefixes reports whether addr is in any of prefixes
+func inPrefixes(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAddr is the address a request came from. Behind a trusted proxy it
+// is the nearest address in X-Forwarded-For that is not one, since the
+// proxies append the addresses they saw and a client can only write the
+// start of the list.
+func clientAddr(r *http.Request, trusted []netip.Prefix) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+	addr = addr.Unmap()
+	if !inPrefixes(trusted, addr) {
+		return addr
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		addr = hop.Unmap()
+		if !inPrefixes(trusted, addr) {
+			break
+		}
+	}
+	return addr
+}
+
+// requireAllowedIP refuses requests from API keys with an allowlist that come
+// from outside it, and records each refusal in the audit trail. Keys without
+// one, and tokens, may connect from anywhere.
+func requireAllowedIP(cfg HTTPConfig) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			allowed, ok := cfg.KeyAllowlists[requestAPIKey(r)]
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			addr := clientAddr(r, cfg.TrustedProxies)
+			if !addr.IsValid() || !inPrefixes(allowed, addr) {
+				recordAudit(AuditIPNotAllowed, r, addr.String(), "the address is not in the API key's allowlist")
+				writeError(w, http.StatusForbidden, ErrCodeIPNotAllowed, "IP not allowed",
+					"the API key may not be used from "+addr.String())
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// parsePrefixes reads CIDRs joined with +, such as 10.0.0.0/8+192.0.2.7.
+// A bare address stands for itself alone.
+func parsePrefixes(list string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range strings.Split(list, "+") {
+		item = strings.TrimSpace(item)
+		if addr, err := netip.ParseAddr(item); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an IP address or CIDR", item)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// parseAllowlists reads the -key-allowlists list of key=cidr+cidr entries and
+// the -trusted-proxies list into cfg
+func (cfg *HTTPConfig) parseAllowlists(list, proxies string) error {
+	for _, item := range splitList(list) {
+		key, networks, ok := strings.Cut(item, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return fmt.Errorf("-key-allowlists entry %q must be key=cidr+cidr", item)
+		}
+		prefixes, err := parsePrefixes(networks)
+		if err != nil {
+			return fmt.Errorf("-key-allowlists entry %q: %w", item, err)
+		}
+		if cfg.KeyAllowlists == nil {
+			cfg.KeyAllowlists = make(map[string][]netip.Prefix)
+		}
+		cfg.KeyAllowlists[strings.TrimSpace(key)] = prefixes
+	}
+
+	for _, item := range splitList(proxies) {
+		prefixes, err := parsePrefixes(item)
+		if err != nil {
+			return fmt.Errorf("-trusted-proxies: %w", err)
+		}
+		cfg.TrustedProxies = append(cfg.TrustedProxies, prefixes...)
+	}
+	return nil
+}
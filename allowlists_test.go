@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+// allowlistConfig binds desk to the office network and leaves ops unbound
+var allowlistConfig = HTTPConfig{
+	APIKeys:       []string{"desk", "ops"},
+	KeyAllowlists: map[string][]netip.Prefix{"desk": {netip.MustParsePrefix("10.0.0.0/8")}},
+}
+
+// callFrom sends a request with an API key from a client address
+func callFrom(cfg HTTPConfig, key, addr, method, path string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, nil)
+	request.Header.Set("X-API-Key", key)
+	request.RemoteAddr = addr
+	return serve(cfg, request)
+}
+
+func TestAllowlists_RefuseAndAuditOutsideAddresses(t *testing.T) {
+	setupTest()
+	if response := callFrom(allowlistConfig, "desk", "10.1.2.3:5000", "GET", "/api/v1/orders"); response.Code != http.StatusOK {
+		t.Errorf("Expected the office address allowed, got %d %s", response.Code, response.Body)
+	}
+	response := callFrom(allowlistConfig, "desk", "192.0.2.1:5000", "GET", "/api/v1/orders")
+	if result := decodeError(t, response); response.Code != http.StatusForbidden || result.Error.Code != ErrCodeIPNotAllowed {
+		t.Errorf("Expected 403 IP_NOT_ALLOWED, got %d %+v", response.Code, result.Error)
+	}
+	// A refused handshake never reaches the upgrade
+	if response := callFrom(allowlistConfig, "desk", "[2001:db8::1]:5000", "GET", "/api/v1/feed/private"); response.Code != http.StatusForbidden {
+		t.Errorf("Expected the WebSocket handshake refused, got %d", response.Code)
+	}
+	if response := callFrom(allowlistConfig, "ops", "192.0.2.1:5000", "GET", "/api/v1/orders"); response.Code != http.StatusOK {
+		t.Errorf("Expected a key without an allowlist let through, got %d", response.Code)
+	}
+
+	var audit AuditEventsResponse
+	json.NewDecoder(callFrom(allowlistConfig, "ops", "192.0.2.1:5000", "GET", "/api/v1/admin/audit?type=ip_not_allowed").Body).Decode(&audit)
+	if audit.Count != 2 {
+		t.Fatalf("Expected both refusals audited, got %+v", audit)
+	}
+	latest := audit.Events[0]
+	if latest.Type != AuditIPNotAllowed || latest.ClientIP != "2001:db8::1" || latest.Path != "/api/v1/feed/private" || latest.KeyID != auditKeyID("desk") {
+		t.Errorf("Expected the handshake audited first, got %+v", latest)
+	}
+	if len(latest.KeyID) != 12 || latest.KeyID == "desk" {
+		t.Errorf("Expected the key identified by its hash, got %q", latest.KeyID)
+	}
+}
+
+func TestClientAddr_TrustedProxies(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("172.16.0.0/12")}
+	tests := []struct {
+		name, remote, forwarded, want string
+	}{
+		{"direct", "192.0.2.1:5000", "", "192.0.2.1"},
+		{"direct ignores the header", "192.0.2.1:5000", "10.0.0.1", "192.0.2.1"},
+		{"one proxy", "172.16.0.2:5000", "10.0.0.1", "10.0.0.1"},
+		{"chain of proxies", "172.16.0.2:5000", "10.0.0.1, 172.16.0.3", "10.0.0.1"},
+		{"spoofed start of the list", "172.16.0.2:5000", "10.0.0.1, 192.0.2.9", "192.0.2.9"},
+		{"mapped IPv4", "[::ffff:192.0.2.1]:5000", "", "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.RemoteAddr = tt.remote
+		if tt.forwarded != "" {
+			request.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		if got := clientAddr(request, trusted).String(); got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestLoadConfig_KeyAllowlists(t *testing.T) {
+	cfg, err := loadConfig([]string{"-key-allowlists", "desk=10.1.2.3/8+192.0.2.7, algo=2001:db8::/32", "-trusted-proxies", "172.16.0.0/12"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]netip.Prefix{
+		"desk": {netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("192.0.2.7/32")},
+		"algo": {netip.MustParsePrefix("2001:db8::/32")},
+	}
+	if !reflect.DeepEqual(cfg.HTTP.KeyAllowlists, want) || !reflect.DeepEqual(cfg.HTTP.TrustedProxies, []netip.Prefix{netip.MustParsePrefix("172.16.0.0/12")}) {
+		t.Errorf("Expected %v behind 172.16.0.0/12, got %v behind %v", want, cfg.HTTP.KeyAllowlists, cfg.HTTP.TrustedProxies)
+	}
+	for _, args := range [][]string{{"-key-allowlists", "desk"}, {"-key-allowlists", "desk=office"}, {"-key-allowlists", "desk="}, {"-trusted-proxies", "10.0.0.0/33"}} {
+		if _, err := loadConfig(args); err == nil {
+			t.Errorf("Expected %v refused", args)
+		}
+	}
+}
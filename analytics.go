@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// analyticsDepthLevels is how many price levels per side the book imbalance is measured over
+const analyticsDepthLevels = 5
+
+// PriceAnalytics are reference prices derived from a symbol's depth as of a
+// sequence number. The prices are left out while either side of the book is empty.
+type PriceAnalytics struct {
+	Symbol   string `json:"symbol"`
+	Sequence int64  `json:"sequence"`
+
+	BestBid float64 `json:"best_bid,omitempty"`
+	BestAsk float64 `json:"best_ask,omitempty"`
+
+	// Mid is halfway between the best bid and ask
+	Mid float64 `json:"mid,omitempty"`
+	// Microprice weights each best price by the quantity on the other side
+	Microprice float64 `json:"microprice,omitempty"`
+	// Imbalance runs from -1 (all asks) to 1 (all bids) over the top levels
+	Imbalance float64 `json:"imbalance"`
+	// FairValue moves the mid towards the heavier side by half the spread times the imbalance
+	FairValue float64 `json:"fair_value,omitempty"`
+}
+
+// refreshPrices recomputes the symbol's price analytics from its book. It
+// runs on the matcher whenever the depth sequence advances.
+func (m *matcher) refreshPrices() {
+	book := m.book
+	prices := PriceAnalytics{Symbol: m.symbol, Sequence: book.sequence}
+
+	bidQuantity, bidDepth := topDepth(book.bids.head(analyticsDepthLevels), analyticsDepthLevels)
+	askQuantity, askDepth := topDepth(book.asks.head(analyticsDepthLevels), analyticsDepthLevels)
+	if total := bidDepth + askDepth; total > 0 {
+		prices.Imbalance = float64(bidDepth-askDepth) / float64(total)
+	}
+
+	if bidQuantity > 0 && askQuantity > 0 {
+		best, _ := book.bids.first()
+		bid := best.Price
+		best, _ = book.asks.first()
+		ask := best.Price
+		prices.BestBid, prices.BestAsk = bid, ask
+		prices.Mid = (bid + ask) / 2
+		prices.Microprice = (bid*float64(askQuantity) + ask*float64(bidQuantity)) / float64(bidQuantity+askQuantity)
+		prices.FairValue = prices.Mid + prices.Imbalance*(ask-bid)/2
+	}
+	m.prices = prices
+}
+
+// topDepth returns the quantity at the best price of a side that is in
+// priority order, and the total quantity over its best levels
+func topDepth(orders []Order, levels int) (best, total int) {
+	seen := 0
+	for i, order := range orders {
+		if i == 0 || order.Price != orders[i-1].Price {
+			if seen == levels {
+				break
+			}
+			seen++
+		}
+		if seen == 1 {
+			best += order.Quantity
+		}
+		total += order.Quantity
+	}
+	return best, total
+}
+
+// getPriceAnalyticsHandler returns a symbol's mid price, microprice and fair value
+func getPriceAnalyticsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	m, ok := matcherFor(r.URL.Query().Get("symbol"))
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrCodeUnknownSymbol, "Unknown symbol",
+			"symbol '"+r.URL.Query().Get("symbol")+"' is not traded here")
+		return
+	}
+
+	var prices PriceAnalytics
+	m.do(func() {
+		// Expiring stale orders publishes the change and refreshes the prices
+		expireOrders(m.book, engineClock.Now())
+		m.publishDepth()
+		prices = m.prices
+	})
+	json.NewEncoder(w).Encode(prices)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// getPrices calls the price analytics endpoint
+func getPrices(t *testing.T, target string) (PriceAnalytics, int) {
+	t.Helper()
+
+	request := httptest.NewRequest("GET", target, nil)
+	response := httptest.NewRecorder()
+	getPriceAnalyticsHandler(response, request)
+
+	var prices PriceAnalytics
+	json.NewDecoder(response.Body).Decode(&prices)
+	return prices, response.Code
+}
+
+func approxEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestPriceAnalytics_FromDepth(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+
+	placeOn(m, Order{ID: "bid-1", Side: SideBuy, Price: 99.0, Quantity: 10})
+	placeOn(m, Order{ID: "bid-2", Side: SideBuy, Price: 98.0, Quantity: 10})
+	placeOn(m, Order{ID: "ask-1", Side: SideSell, Price: 101.0, Quantity: 30})
+
+	prices, code := getPrices(t, "/api/v1/analytics/price")
+	if code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+
+	if prices.Symbol != "DEFAULT" || prices.Sequence != 3 {
+		t.Errorf("Expected DEFAULT at sequence 3, got %s at %d", prices.Symbol, prices.Sequence)
+	}
+	if prices.BestBid != 99.0 || prices.BestAsk != 101.0 || prices.Mid != 100.0 {
+		t.Errorf("Unexpected quotes %+v", prices)
+	}
+
+	// The heavy offer pulls the microprice towards the bid
+	if !approxEqual(prices.Microprice, 99.5) {
+		t.Errorf("Expected microprice 99.50, got %f", prices.Microprice)
+	}
+	if !approxEqual(prices.Imbalance, -0.2) || !approxEqual(prices.FairValue, 99.8) {
+		t.Errorf("Expected imbalance -0.2 and fair value 99.80, got %f and %f", prices.Imbalance, prices.FairValue)
+	}
+}
+
+func TestPriceAnalytics_RefreshedOnBookChange(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+
+	placeOn(m, Order{ID: "bid-1", Side: SideBuy, Price: 99.0, Quantity: 10})
+	placeOn(m, Order{ID: "ask-1", Side: SideSell, Price: 101.0, Quantity: 10})
+
+	var before PriceAnalytics
+	m.do(func() { before = m.prices })
+	if before.Mid != 100.0 || before.Imbalance != 0 {
+		t.Fatalf("Expected a balanced book at 100.00, got %+v", before)
+	}
+
+	placeOn(m, Order{ID: "sell-1", Side: SideSell, Price: 99.0, Quantity: 10})
+
+	var after PriceAnalytics
+	m.do(func() { after = m.prices })
+	if after.Sequence != before.Sequence+1 || after.Mid != 0 || after.Imbalance != -1 {
+		t.Errorf("Expected a one-sided book with no mid at the next sequence, got %+v", after)
+	}
+}
+
+func TestTopDepth_CountsBestLevels(t *testing.T) {
+	orders := []Order{
+		{Price: 100, Quantity: 1}, {Price: 100, Quantity: 2},
+		{Price: 99, Quantity: 3},
+		{Price: 98, Quantity: 4},
+	}
+
+	best, total := topDepth(orders, 2)
+	if best != 3 || total != 6 {
+		t.Errorf("Expected best 3 and total 6 over two levels, got %d and %d", best, total)
+	}
+
+	if best, total := topDepth(nil, analyticsDepthLevels); best != 0 || total != 0 {
+		t.Errorf("Expected an empty side to have no depth, got %d and %d", best, total)
+	}
+}
+
+func TestPriceAnalyticsHandler_UnknownSymbol(t *testing.T) {
+	setupTest()
+
+	if _, code := getPrices(t, "/api/v1/analytics/price?symbol=NOPE"); code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", code)
+	}
+}
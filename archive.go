@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ArchiveStore is where the archiver writes its files
+type ArchiveStore interface {
+	Put(ctx context.Context, name string, data []byte) error
+}
+
+// Archiver moves trades, closed orders and their events out of memory once
+// they are older than Retention
+type Archiver struct {
+	Store     ArchiveStore
+	Retention time.Duration
+}
+
+// ArchiveResult counts what one archive pass moved
+type ArchiveResult struct {
+	Trades int
+	Orders int
+	Events int
+}
+
+// archiveBatch is the history one pass has selected to archive
+type archiveBatch struct {
+	trades []Trade
+	orders []ClosedOrder
+	events []OrderEvent
+}
+
+// Run archives every interval until ctx is cancelled
+func (a *Archiver) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			result, err := a.ArchiveOnce(ctx, engineClock.Now())
+			if err != nil {
+				log.Printf("archiver: %v", err)
+			} else if result.Trades > 0 || result.Orders > 0 || result.Events > 0 {
+				log.Printf("archiver: archived %d trades, %d closed orders and %d order events", result.Trades, result.Orders, result.Events)
+			}
+		}
+	}
+}
+
+// ArchiveOnce writes everything older than the retention window to the store
+// and then trims it from memory. Nothing is trimmed unless every file was written.
+func (a *Archiver) ArchiveOnce(ctx context.Context, now time.Time) (ArchiveResult, error) {
+	cutoff := now.Add(-a.Retention)
+	batch := selectArchivable(cutoff)
+	if len(batch.trades) == 0 && len(batch.orders) == 0 && len(batch.events) == 0 {
+		return ArchiveResult{}, nil
+	}
+
+	stamp := cutoff.UTC().Format("20060102T150405.000000000Z")
+	if len(batch.trades) > 0 {
+		if err := writeArchive(ctx, a.Store, "trades-"+stamp+".jsonl.gz", batch.trades); err != nil {
+			return ArchiveResult{}, err
+		}
+	}
+	if len(batch.orders) > 0 {
+		if err := writeArchive(ctx, a.Store, "closed-orders-"+stamp+".jsonl.gz", batch.orders); err != nil {
+			return ArchiveResult{}, err
+		}
+	}
+	if len(batch.events) > 0 {
+		if err := writeArchive(ctx, a.Store, "order-events-"+stamp+".jsonl.gz", batch.events); err != nil {
+			return ArchiveResult{}, err
+		}
+	}
+
+	trimArchived(batch)
+	return ArchiveResult{Trades: len(batch.trades), Orders: len(batch.orders), Events: len(batch.events)}, nil
+}
+
+// writeArchive encodes records as gzipped JSON lines and puts them in the store
+func writeArchive[T any](ctx context.Context, store ArchiveStore, name string, records []T) error {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	encoder := json.NewEncoder(gz)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	if err := store.Put(ctx, name, buf.Bytes()); err != nil {
+		return fmt.Errorf("writing %s: %w", name, err)
+	}
+	return nil
+}
+
+// selectArchivable picks the trades made before cutoff, and the orders that
+// closed before it with every one of their events that every consumer group
+// has acknowledged
+func selectArchivable(cutoff time.Time) archiveBatch {
+	// Trades and closed orders are logged in time order, so the archivable
+	// ones are a prefix
+	batch := archiveBatch{trades: tradeStore.Before(cutoff), orders: closedStore.Before(cutoff)}
+	floor, held := consumerFloor()
+
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	// An order is closed once its latest event reaches a terminal status
+	closed := make(map[string]bool)
+	for _, event := range orderEvents {
+		closed[event.OrderID] = isTerminalStatus(event.To) && event.CreatedAt.Before(cutoff)
+	}
+	for _, event := range orderEvents {
+		if closed[event.OrderID] && (!held || event.Sequence <= floor) {
+			batch.events = append(batch.events, event)
+		}
+	}
+	return batch
+}
+
+// trimArchived drops an archived batch from the in-memory history. The logs
+// are copied so their old backing arrays can be freed.
+func trimArchived(batch archiveBatch) {
+	tradeStore.DropOldest(len(batch.trades))
+	readModel.archive(len(batch.trades))
+	closedStore.DropOldest(len(batch.orders))
+
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	archived := make(map[int]struct{}, len(batch.events))
+	for _, event := range batch.events {
+		archived[event.Sequence] = struct{}{}
+	}
+	kept := make([]OrderEvent, 0, len(orderEvents)-len(batch.events))
+	for _, event := range orderEvents {
+		if _, ok := archived[event.Sequence]; !ok {
+			kept = append(kept, event)
+		}
+	}
+	orderEvents = kept
+}
+
+// DiskStore writes archive files into a local directory
+type DiskStore struct {
+	Dir string
+}
+
+func (s DiskStore) Put(ctx context.Context, name string, data []byte) error {
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a partial archive is never left behind
+	path := filepath.Join(s.Dir, name)
+	if err := os.WriteFile(path+".tmp", data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// S3Store writes archive files to an S3-compatible bucket using path-style
+// URLs and Signature Version 4
+type S3Store struct {
+	Endpoint  string
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	Client    *http.Client
+}
+
+func (s S3Store) Put(ctx context.Context, name string, data []byte) error {
+	url := strings.TrimSuffix(s.Endpoint, "/") + "/" + s.Bucket + "/" + name
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/gzip")
+	// S3 refuses signatures far from its own clock, whatever the engine's says
+	s.sign(req, data, time.Now())
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("storage returned %s", resp.Status)
+	}
+	return nil
+}
+
+// sign adds the AWS Signature Version 4 headers for a single-chunk upload
+func (s S3Store) sign(req *http.Request, body []byte, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), day)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// newArchiveStore builds the store named by the config, if any
+func newArchiveStore(cfg ArchiveConfig) (ArchiveStore, bool) {
+	switch {
+	case cfg.S3Endpoint != "":
+		return S3Store{
+			Endpoint:  cfg.S3Endpoint,
+			Bucket:    cfg.S3Bucket,
+			Region:    cfg.S3Region,
+			AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		}, true
+	case cfg.Dir != "":
+		return DiskStore{Dir: cfg.Dir}, true
+	}
+	return nil, false
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingStore refuses every write
+type failingStore struct{}
+
+func (failingStore) Put(context.Context, string, []byte) error {
+	return errors.New("disk full")
+}
+
+// readArchive decodes every JSON line of a gzipped archive file
+func readArchive[T any](t *testing.T, path string) []T {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Expected archive %s, got %v", path, err)
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("Expected gzip data in %s, got %v", path, err)
+	}
+
+	var records []T
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		var record T
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("Expected a JSON line, got %v", err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// seedArchiveHistory trades once at start and once an hour later, and leaves
+// an order resting from start
+func seedArchiveHistory(t *testing.T, start time.Time) *ManualClock {
+	t.Helper()
+
+	clock := useDeterministicEngine(t, start)
+	processOrder(Order{ID: "old-ask", Side: SideSell, Price: 100.0, Quantity: 1, Status: OrderStatusPending, CreatedAt: clock.Now()})
+	processOrder(Order{ID: "old-bid", Side: SideBuy, Price: 100.0, Quantity: 1, Status: OrderStatusPending, CreatedAt: clock.Now()})
+	processOrder(Order{ID: "resting", Side: SideBuy, Price: 90.0, Quantity: 1, Status: OrderStatusPending, CreatedAt: clock.Now()})
+
+	clock.Advance(time.Hour)
+	processOrder(Order{ID: "new-ask", Side: SideSell, Price: 101.0, Quantity: 1, Status: OrderStatusPending, CreatedAt: clock.Now()})
+	processOrder(Order{ID: "new-bid", Side: SideBuy, Price: 101.0, Quantity: 1, Status: OrderStatusPending, CreatedAt: clock.Now()})
+	return clock
+}
+
+func TestArchiver_MovesOldHistoryToDisk(t *testing.T) {
+	setupTest()
+	start := time.Date(2024, 1, 1, 9, 30, 0, 0, time.UTC)
+	clock := seedArchiveHistory(t, start)
+	eventsBefore := len(orderEvents)
+
+	dir := t.TempDir()
+	archiver := &Archiver{Store: DiskStore{Dir: dir}, Retention: 30 * time.Minute}
+	result, err := archiver.ArchiveOnce(context.Background(), clock.Now())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// old-ask and old-bid each have accepted and filled events
+	if result.Trades != 1 || result.Orders != 2 || result.Events != 4 {
+		t.Fatalf("Expected 1 trade, 2 orders and 4 events archived, got %+v", result)
+	}
+
+	if len(tradeStore.List("")) != 1 || tradeStore.List("")[0].TakerID != "new-bid" {
+		t.Errorf("Expected only the recent trade in memory, got %+v", tradeStore.List(""))
+	}
+	if len(orderEvents) != eventsBefore-4 {
+		t.Errorf("Expected %d events left, got %d", eventsBefore-4, len(orderEvents))
+	}
+	for _, event := range orderEvents {
+		if event.OrderID == "old-ask" || event.OrderID == "old-bid" {
+			t.Errorf("Expected closed order events to be trimmed, found %+v", event)
+		}
+	}
+
+	stamp := "20240101T100000.000000000Z"
+	archivedTrades := readArchive[Trade](t, filepath.Join(dir, "trades-"+stamp+".jsonl.gz"))
+	if len(archivedTrades) != 1 || archivedTrades[0].TakerID != "old-bid" {
+		t.Errorf("Unexpected archived trades %+v", archivedTrades)
+	}
+	archivedEvents := readArchive[OrderEvent](t, filepath.Join(dir, "order-events-"+stamp+".jsonl.gz"))
+	if len(archivedEvents) != 4 {
+		t.Errorf("Expected 4 archived events, got %+v", archivedEvents)
+	}
+	archivedOrders := readArchive[ClosedOrder](t, filepath.Join(dir, "closed-orders-"+stamp+".jsonl.gz"))
+	if len(archivedOrders) != 2 || archivedOrders[0].ID != "old-ask" || archivedOrders[0].FilledQuantity != 1 {
+		t.Errorf("Unexpected archived orders %+v", archivedOrders)
+	}
+	if closed := closedStore.List(OrderHistoryFilter{}); len(closed) != 2 || closed[0].ID != "new-ask" {
+		t.Errorf("Expected only the recent closed orders in memory, got %+v", closed)
+	}
+
+	// Sequences keep counting after a trim
+	last := orderEvents[len(orderEvents)-1].Sequence
+	cancelOrder("", "resting")
+	if next := orderEvents[len(orderEvents)-2].Sequence; next != last+1 {
+		t.Errorf("Expected the next event to have sequence %d, got %d", last+1, next)
+	}
+}
+
+func TestArchiver_KeepsHistoryWhenStoreFails(t *testing.T) {
+	setupTest()
+	clock := seedArchiveHistory(t, time.Date(2024, 1, 1, 9, 30, 0, 0, time.UTC))
+	tradesBefore, eventsBefore := len(tradeStore.List("")), len(orderEvents)
+
+	archiver := &Archiver{Store: failingStore{}, Retention: time.Minute}
+	if _, err := archiver.ArchiveOnce(context.Background(), clock.Now().Add(time.Hour)); err == nil {
+		t.Fatal("Expected the store error to be returned")
+	}
+
+	if len(tradeStore.List("")) != tradesBefore || len(orderEvents) != eventsBefore {
+		t.Errorf("Expected nothing trimmed, got %d trades and %d events", len(tradeStore.List("")), len(orderEvents))
+	}
+}
+
+func TestS3Store_SignedPut(t *testing.T) {
+	var got *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	// Uploads are signed on the wall clock, not the engine's
+	useDeterministicEngine(t, time.Date(2024, 1, 1, 9, 30, 0, 0, time.UTC))
+	store := S3Store{Endpoint: server.URL, Bucket: "lob-archive", Region: "eu-west-1", AccessKey: "AKID", SecretKey: "secret"}
+	before := time.Now().UTC().Truncate(time.Second)
+	if err := store.Put(context.Background(), "trades-1.jsonl.gz", []byte("data")); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	after := time.Now().UTC()
+
+	if got.Method != http.MethodPut || got.URL.Path != "/lob-archive/trades-1.jsonl.gz" {
+		t.Errorf("Unexpected request %s %s", got.Method, got.URL.Path)
+	}
+	if !bytes.Equal(body, []byte("data")) {
+		t.Errorf("Unexpected body %q", body)
+	}
+	signed, err := time.Parse("20060102T150405Z", got.Header.Get("X-Amz-Date"))
+	if err != nil || signed.Before(before) || signed.After(after) || got.Header.Get("X-Amz-Content-Sha256") != sha256Hex([]byte("data")) {
+		t.Errorf("Unexpected signing headers %v", got.Header)
+	}
+
+	request := httptest.NewRequest(http.MethodPut, server.URL+"/lob-archive/trades-1.jsonl.gz", nil)
+	store.sign(request, []byte("data"), time.Date(2024, 1, 1, 9, 30, 0, 0, time.UTC))
+	if request.Header.Get("X-Amz-Date") != "20240101T093000Z" {
+		t.Errorf("Unexpected X-Amz-Date %q", request.Header.Get("X-Amz-Date"))
+	}
+	auth := request.Header.Get("Authorization")
+	prefix := "AWS4-HMAC-SHA256 Credential=AKID/20240101/eu-west-1/s3/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature="
+	if !strings.HasPrefix(auth, prefix) || len(auth) != len(prefix)+64 {
+		t.Errorf("Unexpected Authorization header %q", auth)
+	}
+}
+
+func TestLoadConfig_ArchiveNeedsBucket(t *testing.T) {
+	if _, err := loadConfig([]string{"-archive-s3-endpoint", "http://minio.local:9000"}); err == nil {
+		t.Error("Expected an error without -archive-s3-bucket")
+	}
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// MatchingMode says how a symbol's orders are matched
+type MatchingMode string
+
+const (
+	// MatchingContinuous matches every order the moment it arrives
+	MatchingContinuous MatchingMode = "continuous"
+	// MatchingBatch collects orders and matches them together at one
+	// clearing price at the end of each interval
+	MatchingBatch MatchingMode = "batch"
+)
+
+// batchAuction is a batch-auction symbol's state. Orders that arrive during an
+// interval wait, unseen, in pending until the auction at its end.
+type batchAuction struct {
+	interval time.Duration
+	pending  []Order
+	nextAt   time.Time
+	last     *AuctionResult
+	// published is the indicative uncross last sent on the public feed,
+	// without its time
+	published *IndicativeAuction
+}
+
+// AuctionResult is the outcome of one batch auction
+type AuctionResult struct {
+	At time.Time `json:"at"`
+	// Price is the uniform clearing price every trade printed at; zero when
+	// nothing crossed
+	Price  float64 `json:"price,omitempty"`
+	Volume int     `json:"volume"`
+	Trades int     `json:"trades"`
+	// Orders is how many orders were waiting for the auction
+	Orders int `json:"orders"`
+}
+
+// IndicativeAuction is where a call auction would uncross if it ran now
+type IndicativeAuction struct {
+	Symbol string       `json:"symbol"`
+	Phase  TradingPhase `json:"phase"`
+	// Price is the indicative uncrossing price and Volume what would trade at
+	// it; both are zero while the orders do not cross
+	Price  float64 `json:"price,omitempty"`
+	Volume int     `json:"volume"`
+	// Surplus is what would be left unmatched at the price, on SurplusSide
+	Surplus     int  `json:"surplus"`
+	SurplusSide Side `json:"surplus_side,omitempty"`
+	// Orders is how many orders are waiting for the auction
+	Orders int       `json:"orders"`
+	At     time.Time `json:"at"`
+}
+
+// AuctionStatus describes how a symbol is matched and, for batch auctions,
+// when the next one runs
+type AuctionStatus struct {
+	Symbol   string       `json:"symbol"`
+	Mode     MatchingMode `json:"mode"`
+	Interval string       `json:"interval,omitempty"`
+	// Pending is how many orders are waiting for the next auction
+	Pending int            `json:"pending"`
+	NextAt  *time.Time     `json:"next_at,omitempty"`
+	Last    *AuctionResult `json:"last,omitempty"`
+	// Indicative is where the call auction under way would uncross
+	Indicative *IndicativeAuction `json:"indicative,omitempty" since:"9"`
+}
+
+// startBatchAuctions switches each named symbol to batch auctions and runs
+// them at the end of every interval until ctx is done. A standby leaves the
+// auctions to its primary, whose results it replicates. On a virtual clock
+// the simulation runs them as it moves.
+func startBatchAuctions(ctx context.Context, intervals map[string]time.Duration) {
+	for symbol, interval := range intervals {
+		m, ok := matcherFor(symbol)
+		if !ok {
+			log.Printf("auctions: unknown symbol %q, auctions not started", symbol)
+			continue
+		}
+		m.do(func() {
+			m.book.auction = &batchAuction{interval: interval, nextAt: engineClock.Now().Add(interval)}
+		})
+		if simulatedClock == nil {
+			go runAuctions(ctx, m, interval)
+		}
+		log.Printf("auctions: %s matches in batch auctions every %s", m.symbol, interval)
+	}
+}
+
+// runAuctions runs the symbol's auction on every tick
+func runAuctions(ctx context.Context, m *matcher, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !standby.running.Load() {
+				m.do(func() {
+					// Only continuous trading runs the interval's auction
+					if m.book.phase() == PhaseContinuous {
+						runBatchAuction(m.book)
+					}
+				})
+			}
+		}
+	}
+}
+
+// collectForAuction queues an accepted order for the book's next auction. Only
+// plain limit orders take part, and an owner's orders may not cross each
+// other. It must run on the book's matcher.
+func collectForAuction(book *OrderBook, order Order) Order {
+	if (order.Type != "" && order.Type != OrderTypeLimit) || order.MinQuantity > 0 || order.AllOrNone {
+		rejectOrder(&order, ErrCodeBatchAuction)
+		return order
+	}
+	if crossesOwnOrder(book, order) || crossesOwnPending(book.auction.pending, order) {
+		rejectOrder(&order, ErrCodeSelfTrade)
+		return order
+	}
+	book.auction.pending = append(book.auction.pending, order)
+	return order
+}
+
+// crossesOwnPending reports whether an order would cross one of its owner's
+// orders that is waiting for the same auction
+func crossesOwnPending(pending []Order, order Order) bool {
+	if order.Owner == "" {
+		return false
+	}
+	for _, other := range pending {
+		if other.Owner == order.Owner && other.Side != order.Side && priceCrosses(order, other) {
+			return true
+		}
+	}
+	return false
+}
+
+// runBatchAuction ends the book's interval. The waiting orders join the book
+// in arrival order, every order that can trade at the clearing price does so
+// at that price, and IOC orders that are left are cancelled. It must run on
+// the book's matcher.
+func runBatchAuction(book *OrderBook) AuctionResult {
+	auction := book.auction
+	now := engineClock.Now()
+	result := AuctionResult{At: now, Orders: len(auction.pending)}
+	auction.nextAt = now.Add(auction.interval)
+
+	expireOrders(book, now)
+	var immediate []string
+	for _, order := range auction.pending {
+		if isExpired(order, now) {
+			if err := transitionOrder(&order, OrderStatusExpired, "expiry time reached"); err != nil {
+				logTransitionError(err)
+			}
+			continue
+		}
+		if order.TimeInForce == TimeInForceIOC {
+			immediate = append(immediate, order.ID)
+		}
+		addToOrderBook(order)
+	}
+	auction.pending = auction.pending[:0]
+
+	if price, volume := clearingPrice(book.bids.orders(), book.asks.orders(), book.lastPrice); volume > 0 {
+		result.Price, result.Volume = price, volume
+		result.Trades = uncross(book, price)
+	}
+	for _, id := range immediate {
+		cancelImmediate(book, id)
+	}
+
+	auction.last = &result
+	return result
+}
+
+// clearingPrice finds the price that trades the most quantity between two
+// priority-sorted sides. Ties go to the price that leaves the least unmatched
+// at it, then to the one nearest the reference: the last trade price, or the
+// middle of the tied prices when the symbol has not traded. It returns a zero
+// volume when the sides do not cross.
+func clearingPrice(bids, asks []Order, lastPrice float64) (float64, int) {
+	if len(bids) == 0 || len(asks) == 0 || bids[0].Price < asks[0].Price {
+		return 0, 0
+	}
+
+	prices := make([]float64, 0, len(bids)+len(asks))
+	for _, orders := range [][]Order{bids, asks} {
+		for _, order := range orders {
+			prices = append(prices, order.Price)
+		}
+	}
+	slices.Sort(prices)
+	prices = slices.Compact(prices)
+
+	// demand is what bids buy at each price and supply what asks sell
+	demand, supply := make([]int, len(prices)), make([]int, len(prices))
+	for _, order := range bids {
+		i, _ := slices.BinarySearch(prices, order.Price)
+		demand[i] += order.Quantity
+	}
+	for _, order := range asks {
+		i, _ := slices.BinarySearch(prices, order.Price)
+		supply[i] += order.Quantity
+	}
+	for i := len(prices) - 2; i >= 0; i-- {
+		demand[i] += demand[i+1]
+	}
+	for i := 1; i < len(prices); i++ {
+		supply[i] += supply[i-1]
+	}
+
+	best, bestImbalance := 0, 0
+	var tied []float64
+	for i, price := range prices {
+		volume := min(demand[i], supply[i])
+		imbalance := demand[i] - supply[i]
+		if imbalance < 0 {
+			imbalance = -imbalance
+		}
+		switch {
+		case volume > best || volume == best && imbalance < bestImbalance:
+			best, bestImbalance, tied = volume, imbalance, []float64{price}
+		case volume == best && imbalance == bestImbalance:
+			tied = append(tied, price)
+		}
+	}
+	if best == 0 {
+		return 0, 0
+	}
+
+	reference := lastPrice
+	if reference == 0 {
+		reference = (tied[0] + tied[len(tied)-1]) / 2
+	}
+	price := tied[0]
+	for _, candidate := range tied[1:] {
+		if math.Abs(candidate-reference) < math.Abs(price-reference) {
+			price = candidate
+		}
+	}
+	return price, best
+}
+
+// indicativeAuction works out where the book's call auction would uncross
+// if it ran now: the waiting orders join the resting ones, as they would,
+// and the clearing price is found the same way. It reports false outside
+// the pre-open and auction phases. It must run on the book's matcher.
+func indicativeAuction(book *OrderBook, symbol string) (IndicativeAuction, bool) {
+	phase := book.phase()
+	if book.auction == nil || (phase != PhasePreOpen && phase != PhaseAuction) {
+		return IndicativeAuction{}, false
+	}
+	now := engineClock.Now()
+	indicative := IndicativeAuction{Symbol: symbol, Phase: phase, Orders: len(book.auction.pending), At: now}
+
+	bids, asks := slices.Clone(book.bids.orders()), slices.Clone(book.asks.orders())
+	for _, order := range book.auction.pending {
+		switch {
+		case isExpired(order, now):
+		case order.Side == SideBuy:
+			bids = append(bids, order)
+		default:
+			asks = append(asks, order)
+		}
+	}
+	sortSide(bids, compareBids)
+	sortSide(asks, compareAsks)
+
+	price, volume := clearingPrice(bids, asks, book.lastPrice)
+	if volume == 0 {
+		return indicative, true
+	}
+	indicative.Price, indicative.Volume = price, volume
+
+	var demand, supply int
+	for _, order := range bids {
+		if order.Price >= price {
+			demand += order.Quantity
+		}
+	}
+	for _, order := range asks {
+		if order.Price <= price {
+			supply += order.Quantity
+		}
+	}
+	switch {
+	case demand > supply:
+		indicative.Surplus, indicative.SurplusSide = demand-supply, SideBuy
+	case supply > demand:
+		indicative.Surplus, indicative.SurplusSide = supply-demand, SideSell
+	}
+	return indicative, true
+}
+
+// publishIndicative announces on the public feed where the call auction
+// under way would uncross, whenever that changes. It runs on the matcher
+// goroutine after each command.
+func (m *matcher) publishIndicative() {
+	indicative, ok := indicativeAuction(m.book, m.symbol)
+	if !ok {
+		return
+	}
+	unstamped := indicative
+	unstamped.At = time.Time{}
+	if published := m.book.auction.published; published != nil && *published == unstamped {
+		return
+	}
+	m.book.auction.published = &unstamped
+	feed.auction(indicative)
+}
+
+// uncross fills the best bids against the best asks at the clearing price
+// until one side has nothing left that trades at it, and returns how many
+// trades it made. The older order of each pair is the maker.
+func uncross(book *OrderBook, price float64) int {
+	trades := 0
+	for {
+		buy, bidOK := book.bids.first()
+		sell, askOK := book.asks.first()
+		if !bidOK || !askOK || buy.Price < price || sell.Price > price {
+			break
+		}
+		maker, taker := buy, sell
+		if comparePriority(&sell, &buy) < 0 {
+			maker, taker = sell, buy
+		}
+
+		quantity := min(buy.Quantity, sell.Quantity)
+		trade := Trade{
+			ID:        generateTradeID(),
+			Symbol:    buy.Symbol,
+			MakerID:   maker.ID,
+			TakerID:   taker.ID,
+			Price:     price,
+			Quantity:  quantity,
+			CreatedAt: engineClock.Now(),
+
+			AggressorSide: taker.Side,
+		}
+		tickTrade(book, &trade)
+		settleTrade(&trade, maker.Owner, taker.Owner)
+		recordTrade(trade)
+		feed.trade(trade, maker, taker)
+		surveilTrade(trade, maker, taker)
+
+		fillAtTop(book, &book.bids, quantity, trade.ID)
+		fillAtTop(book, &book.asks, quantity, trade.ID)
+		trades++
+	}
+	return trades
+}
+
+// fillAtTop takes quantity from the first order on one side of the book,
+// removing it once it is filled
+func fillAtTop(book *OrderBook, side *bookSide, quantity int, tradeID string) {
+	handle, _ := side.best()
+	order := side.edit(handle)
+	order.Quantity -= quantity
+	order.FilledQuantity += quantity
+	adjustLevel(book, order.Side, order.Price, -quantity, filledOrders(*order))
+	publishL3(book, L3Execute, *order, 1, -quantity, tradeID)
+
+	if order.Quantity > 0 {
+		if err := transitionOrder(order, OrderStatusPartiallyFilled, "partially filled"); err != nil {
+			logTransitionError(err)
+		}
+		return
+	}
+	if err := transitionOrder(order, OrderStatusFilled, "fully filled"); err != nil {
+		logTransitionError(err)
+	}
+	side.remove(handle)
+}
+
+// cancelImmediate removes what is left of an IOC order after the auction it
+// took part in
+func cancelImmediate(book *OrderBook, orderID string) {
+	side, handle, ok := findResting(book, orderID)
+	if !ok {
+		return
+	}
+
+	order, position := takeResting(book, side, handle)
+	adjustLevel(book, order.Side, order.Price, -order.Quantity, -1)
+	publishL3(book, L3Delete, order, position, -order.Quantity, "")
+	cancelUnfilled(&order)
+}
+
+// auctionStatus describes the matcher's mode and auctions
+func (m *matcher) auctionStatus() AuctionStatus {
+	status := AuctionStatus{Symbol: m.symbol, Mode: MatchingContinuous}
+	m.do(func() {
+		auction := m.book.auction
+		if auction == nil {
+			return
+		}
+		next := auction.nextAt
+		status.Mode, status.Interval = MatchingBatch, auction.interval.String()
+		status.Pending, status.NextAt = len(auction.pending), &next
+		if auction.last != nil {
+			last := *auction.last
+			status.Last = &last
+		}
+		if indicative, ok := indicativeAuction(m.book, m.symbol); ok {
+			status.Indicative = &indicative
+		}
+	})
+	return status
+}
+
+// getAuctionHandler reports whether a symbol matches continuously or in batch
+// auctions, and how the last auction went
+func getAuctionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	m, ok := matcherFor(r.URL.Query().Get("symbol"))
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrCodeUnknownSymbol, "Unknown symbol",
+			"symbol '"+r.URL.Query().Get("symbol")+"' is not traded here")
+		return
+	}
+	json.NewEncoder(w).Encode(m.auctionStatus())
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// batchMatcher switches the default symbol to batch auctions that only run
+// when the test asks
+func batchMatcher(t *testing.T) *matcher {
+	t.Helper()
+	m, _ := matcherFor("")
+	m.do(func() {
+		m.book.auction = &batchAuction{interval: time.Second, nextAt: engineClock.Now().Add(time.Second)}
+	})
+	return m
+}
+
+// auctionNow runs the matcher's auction
+func auctionNow(m *matcher) AuctionResult {
+	var result AuctionResult
+	m.do(func() { result = runBatchAuction(m.book) })
+	return result
+}
+
+func TestBatchAuction_UniformClearingPrice(t *testing.T) {
+	setupTest()
+	m := batchMatcher(t)
+
+	placeOn(m, Order{ID: "ask-1", Symbol: "DEFAULT", Side: SideSell, Price: 99.0, Quantity: 3})
+	placeOn(m, Order{ID: "ask-2", Symbol: "DEFAULT", Side: SideSell, Price: 100.0, Quantity: 2})
+	placeOn(m, Order{ID: "bid-1", Symbol: "DEFAULT", Side: SideBuy, Price: 102.0, Quantity: 2})
+	placeOn(m, Order{ID: "bid-2", Symbol: "DEFAULT", Side: SideBuy, Price: 100.0, Quantity: 4})
+
+	// Nothing trades or shows on the book until the auction
+	if trades := tradeStore.List(""); len(trades) != 0 {
+		t.Fatalf("Expected no trades before the auction, got %+v", trades)
+	}
+	if snapshot := m.depthSnapshot(0); len(snapshot.Bids) != 0 || len(snapshot.Asks) != 0 {
+		t.Fatalf("Expected an empty book before the auction, got %+v", snapshot)
+	}
+	if status := m.auctionStatus(); status.Mode != MatchingBatch || status.Pending != 4 {
+		t.Errorf("Expected 4 orders waiting, got %+v", status)
+	}
+
+	result := auctionNow(m)
+	if result.Price != 100.0 || result.Volume != 5 || result.Orders != 4 {
+		t.Errorf("Expected 5 to clear at 100, got %+v", result)
+	}
+	trades := tradeStore.List("")
+	if len(trades) != result.Trades || len(trades) != 3 {
+		t.Fatalf("Expected 3 trades, got %+v", trades)
+	}
+	for _, trade := range trades {
+		if trade.Price != 100.0 {
+			t.Errorf("Expected every trade at the clearing price, got %+v", trade)
+		}
+	}
+	// The asks arrived first, so they made the liquidity
+	if trades[0].MakerID != "ask-1" || trades[0].TakerID != "bid-1" || trades[0].AggressorSide != SideBuy {
+		t.Errorf("Expected the earlier ask to be the maker, got %+v", trades[0])
+	}
+
+	snapshot := m.depthSnapshot(0)
+	if len(snapshot.Asks) != 0 || len(snapshot.Bids) != 1 || snapshot.Bids[0].Price != 100.0 || snapshot.Bids[0].Quantity != 1 {
+		t.Errorf("Expected 1 left bid at 100, got %+v", snapshot)
+	}
+	if status := m.auctionStatus(); status.Pending != 0 || status.Last == nil || status.Last.Volume != 5 {
+		t.Errorf("Expected the last auction in the status, got %+v", status)
+	}
+}
+
+func TestBatchAuction_OrdersAndCancels(t *testing.T) {
+	setupTest()
+	m := batchMatcher(t)
+
+	for _, order := range []Order{
+		{ID: "market", Symbol: "DEFAULT", Side: SideBuy, Type: OrderTypeMarket, Quantity: 1},
+		{ID: "aon", Symbol: "DEFAULT", Side: SideBuy, Price: 100.0, Quantity: 1, AllOrNone: true},
+	} {
+		order.Status = OrderStatusPending
+		var placed Order
+		m.do(func() { placed = processOrder(order) })
+		if placed.Status != OrderStatusRejected || placed.RejectReason != ErrCodeBatchAuction {
+			t.Errorf("%s: expected BATCH_AUCTION, got %+v", order.ID, placed)
+		}
+	}
+
+	placeOn(m, Order{ID: "own-ask", Symbol: "DEFAULT", Side: SideSell, Price: 100.0, Quantity: 1, Owner: "alice"})
+	var placed Order
+	m.do(func() {
+		placed = processOrder(Order{ID: "own-bid", Symbol: "DEFAULT", Side: SideBuy, Price: 101.0, Quantity: 1, Owner: "alice", Status: OrderStatusPending})
+	})
+	if placed.RejectReason != ErrCodeSelfTrade {
+		t.Errorf("Expected the crossing own order to be rejected, got %+v", placed)
+	}
+
+	// A waiting order can be cancelled before its auction
+	if _, code := cancelOnAnySymbol(context.Background(), "", "own-ask"); code != "" {
+		t.Fatal("Expected to cancel the waiting order")
+	}
+
+	// What is left of an IOC order after the auction is cancelled
+	placeOn(m, Order{ID: "ask", Symbol: "DEFAULT", Side: SideSell, Price: 100.0, Quantity: 1})
+	placeOn(m, Order{ID: "ioc", Symbol: "DEFAULT", Side: SideBuy, Price: 100.0, Quantity: 3, TimeInForce: TimeInForceIOC})
+	auctionNow(m)
+	if snapshot := m.depthSnapshot(0); len(snapshot.Bids) != 0 || len(snapshot.Asks) != 0 {
+		t.Errorf("Expected the IOC remainder to be cancelled, got %+v", snapshot)
+	}
+	closed := closedStore.List(OrderHistoryFilter{Status: OrderStatusCancelled})
+	if len(closed) != 2 || closed[1].ID != "ioc" || closed[1].FilledQuantity != 1 {
+		t.Errorf("Expected the IOC order to be cancelled after filling 1, got %+v", closed)
+	}
+}
+
+func TestClearingPrice(t *testing.T) {
+	orders := func(side Side, prices ...float64) []Order {
+		var orders []Order
+		for _, price := range prices {
+			orders = append(orders, Order{Side: side, Price: price, Quantity: 1})
+		}
+		return orders
+	}
+
+	cases := []struct {
+		name       string
+		bids, asks []Order
+		last       float64
+		price      float64
+		volume     int
+	}{
+		{"no cross", orders(SideBuy, 99), orders(SideSell, 100), 0, 0, 0},
+		{"least imbalance", orders(SideBuy, 101, 100), orders(SideSell, 100, 102), 0, 101, 1},
+		{"equally near prices keep the lowest", orders(SideBuy, 104), orders(SideSell, 100), 0, 100, 1},
+		{"nearest the last trade", orders(SideBuy, 104), orders(SideSell, 100), 103.5, 104, 1},
+	}
+	for _, tc := range cases {
+		price, volume := clearingPrice(tc.bids, tc.asks, tc.last)
+		if price != tc.price || volume != tc.volume {
+			t.Errorf("%s: expected %d at %v, got %d at %v", tc.name, tc.volume, tc.price, volume, price)
+		}
+	}
+}
+
+func TestGetAuctionHandler(t *testing.T) {
+	setupTest()
+
+	var status AuctionStatus
+	json.NewDecoder(serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/auctions", nil)).Body).Decode(&status)
+	if status.Symbol != "DEFAULT" || status.Mode != MatchingContinuous || status.NextAt != nil {
+		t.Errorf("Expected continuous matching, got %+v", status)
+	}
+
+	batchMatcher(t)
+	json.NewDecoder(serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/auctions", nil)).Body).Decode(&status)
+	if status.Mode != MatchingBatch || status.Interval != "1s" || status.NextAt == nil {
+		t.Errorf("Expected 1s batch auctions, got %+v", status)
+	}
+
+	if result := serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/auctions?symbol=XYZ", nil)); result.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown symbol, got %d", result.Code)
+	}
+}
+
+func TestLoadConfig_BatchAuctions(t *testing.T) {
+	cfg, err := loadConfig([]string{"-symbols", "BTC-USD,ETH-USD", "-batch-auctions", "btc-usd=100ms"})
+	if err != nil || len(cfg.Auctions) != 1 || cfg.Auctions["BTC-USD"] != 100*time.Millisecond {
+		t.Errorf("Expected BTC-USD auctions every 100ms, got %v (%v)", cfg.Auctions, err)
+	}
+	for _, list := range []string{"BTC-USD", "BTC-USD=soon", "BTC-USD=0s", "SOL-USD=1s"} {
+		if _, err := loadConfig([]string{"-symbols", "BTC-USD", "-batch-auctions", list}); err == nil {
+			t.Errorf("%s: expected an error", list)
+		}
+	}
+}
+
+// preOpen puts the default symbol in the pre-open
+func preOpen(t *testing.T) *matcher {
+	t.Helper()
+	m, _ := matcherFor("")
+	m.do(func() {
+		enterPhase(m.book, MarketStatus{Symbol: m.symbol, Phase: PhasePreOpen})
+	})
+	return m
+}
+
+func TestIndicativeAuction_PriceVolumeAndSurplus(t *testing.T) {
+	setupTest()
+	m := preOpen(t)
+	processOn(m, Order{ID: "bid-1", Symbol: "DEFAULT", Side: SideBuy, Price: 102.0, Quantity: 3})
+	processOn(m, Order{ID: "bid-2", Symbol: "DEFAULT", Side: SideBuy, Price: 100.0, Quantity: 2})
+	processOn(m, Order{ID: "ask-1", Symbol: "DEFAULT", Side: SideSell, Price: 99.0, Quantity: 2})
+	processOn(m, Order{ID: "ask-2", Symbol: "DEFAULT", Side: SideSell, Price: 101.0, Quantity: 2})
+
+	// 4 trades at 101, where 3 bid against 4 offered and 1 is left over
+	var status AuctionStatus
+	json.NewDecoder(serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/auctions", nil)).Body).Decode(&status)
+	indicative := status.Indicative
+	if indicative == nil || indicative.Phase != PhasePreOpen || indicative.Orders != 4 {
+		t.Fatalf("Expected an indicative uncross of the 4 waiting orders, got %+v", status)
+	}
+	if indicative.Price != 101.0 || indicative.Volume != 3 || indicative.Surplus != 1 || indicative.SurplusSide != SideSell {
+		t.Errorf("Expected 3 at 101 with 1 offered over, got %+v", indicative)
+	}
+	if trades := tradeStore.List(""); len(trades) != 0 {
+		t.Errorf("Expected nothing traded before the uncross, got %+v", trades)
+	}
+}
+
+func TestIndicativeAuction_PublishedOnChange(t *testing.T) {
+	setupTest()
+	server := httptest.NewServer(newServer(HTTPConfig{}))
+	defer server.Close()
+	public := dialFeed(t, server, "/api/v1/feed/public", "")
+	defer public.Close()
+	waitForFeeds(t, 1)
+
+	m := preOpen(t)
+	msg, raw := readFeed(t, public, FeedMessageAuction)
+	if msg.Auction == nil || msg.Auction.Orders != 0 || msg.Auction.Volume != 0 {
+		t.Errorf("Expected an empty auction announced with the pre-open, got %s", raw)
+	}
+	processOn(m, Order{ID: "bid", Symbol: "DEFAULT", Side: SideBuy, Price: 100.0, Quantity: 2})
+	processOn(m, Order{ID: "ask", Symbol: "DEFAULT", Side: SideSell, Price: 100.0, Quantity: 1})
+	readFeed(t, public, FeedMessageAuction)
+	msg, raw = readFeed(t, public, FeedMessageAuction)
+	if msg.Symbol != "DEFAULT" || msg.Auction.Price != 100.0 || msg.Auction.Volume != 1 || msg.Auction.Surplus != 1 || msg.Auction.SurplusSide != SideBuy {
+		t.Errorf("Expected 1 at 100 with a bid over, got %s", raw)
+	}
+
+	// A command that leaves the uncross where it was publishes nothing new
+	m.do(func() {})
+	public.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, raw, err := public.ReadMessage(); err == nil {
+		t.Errorf("Expected no repeated indicative uncross, got %s", raw)
+	}
+}
+
+func TestIndicativeAuction_OnlyDuringCallAuctions(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+	processOn(m, Order{ID: "ask", Symbol: "DEFAULT", Side: SideSell, Price: 100.0, Quantity: 1})
+	if status := m.auctionStatus(); status.Indicative != nil {
+		t.Errorf("Expected no indicative uncross in continuous trading, got %+v", status.Indicative)
+	}
+
+	// Batch auctions keep their orders unseen until they run
+	batchMatcher(t)
+	processOn(m, Order{ID: "bid", Symbol: "DEFAULT", Side: SideBuy, Price: 100.0, Quantity: 1})
+	if status := m.auctionStatus(); status.Indicative != nil {
+		t.Errorf("Expected no indicative uncross for batch auctions, got %+v", status.Indicative)
+	}
+}
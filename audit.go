@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// AuditEventType is what an audit event records
+type AuditEventType string
+
+const (
+	// AuditIPNotAllowed is a request from an address outside its API key's
+	// allowlist
+	AuditIPNotAllowed AuditEventType = "ip_not_allowed"
+	// AuditAuctionUncross is a call auction uncrossing at the random instant
+	// drawn within its uncross window
+	AuditAuctionUncross AuditEventType = "auction_uncross"
+)
+
+// AuditEvent is one security event worth an operator's attention, or a
+// decision the engine took by chance that it must be able to account for.
+// The engine's own events have no caller.
+type AuditEvent struct {
+	ID   string         `json:"id"`
+	Type AuditEventType `json:"type"`
+	// KeyID is the start of the hex SHA-256 of the API key, so events can be
+	// told apart without the key itself being logged
+	KeyID    string    `json:"key_id,omitempty"`
+	ClientIP string    `json:"client_ip,omitempty"`
+	Method   string    `json:"method,omitempty"`
+	Path     string    `json:"path,omitempty"`
+	Message  string    `json:"message"`
+	At       time.Time `json:"at"`
+}
+
+// AuditEventsResponse lists audit events, newest first
+type AuditEventsResponse struct {
+	Events []AuditEvent `json:"events"`
+	Count  int          `json:"count"`
+}
+
+// auditEventLimit is how many audit events are kept before the oldest go
+const auditEventLimit = 1000
+
+// auditEvents is guarded by auditMu
+var (
+	auditMu     sync.Mutex
+	auditEvents []AuditEvent
+)
+
+// auditKeyID identifies an API key in the audit trail without revealing it
+func auditKeyID(key string) string {
+	if key == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// recordAudit adds an event for a request to the audit trail and the log
+func recordAudit(kind AuditEventType, r *http.Request, clientIP, message string) {
+	event := AuditEvent{ID: generateOrderID(), Type: kind, KeyID: auditKeyID(requestAPIKey(r)), ClientIP: clientIP,
+		Method: r.Method, Path: r.URL.Path, Message: message, At: engineClock.Now()}
+	log.Printf("audit %s: key %s from %s on %s %s: %s", event.Type, event.KeyID, event.ClientIP, event.Method, event.Path, event.Message)
+	appendAudit(event)
+}
+
+// recordEngineAudit adds an event the engine raised on its own to the audit
+// trail and the log
+func recordEngineAudit(kind AuditEventType, message string) {
+	event := AuditEvent{ID: generateOrderID(), Type: kind, Message: message, At: engineClock.Now()}
+	log.Printf("audit %s: %s", event.Type, event.Message)
+	appendAudit(event)
+}
+
+// appendAudit keeps an event, dropping the oldest past auditEventLimit
+func appendAudit(event AuditEvent) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	auditEvents = append(auditEvents, event)
+	if n := len(auditEvents); n > auditEventLimit {
+		auditEvents = slices.Delete(auditEvents, 0, n-auditEventLimit)
+	}
+}
+
+// getAuditEventsHandler lists audit events, newest first, optionally only
+// those of one type
+func getAuditEventsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	kind := AuditEventType(r.URL.Query().Get("type"))
+	auditMu.Lock()
+	events := make([]AuditEvent, 0)
+	for i := len(auditEvents) - 1; i >= 0; i-- {
+		if kind == "" || auditEvents[i].Type == kind {
+			events = append(events, auditEvents[i])
+		}
+	}
+	auditMu.Unlock()
+
+	json.NewEncoder(w).Encode(AuditEventsResponse{Events: events, Count: len(events)})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultQueueDepth is how many commands a matcher holds waiting by default
+const defaultQueueDepth = 64
+
+// QueueConfig bounds the command queue in front of each matcher
+type QueueConfig struct {
+	// Depth is how many commands may wait for a matcher. New orders and mass
+	// quotes that find its queue full are refused rather than waiting.
+	Depth int
+	// RetryAfter is how long refused senders are told to wait
+	RetryAfter time.Duration
+}
+
+// MatcherQueue is one matcher's command queue and what it has refused
+type MatcherQueue struct {
+	Symbol  string `json:"symbol"`
+	Queued  int    `json:"queued"`
+	Depth   int    `json:"depth"`
+	Refused int64  `json:"refused"`
+}
+
+// MatcherQueuesResponse lists every matcher's queue
+type MatcherQueuesResponse struct {
+	Queues       []MatcherQueue `json:"queues"`
+	RetryAfterMs int64          `json:"retry_after_ms"`
+}
+
+// queueConfig is the queue every matcher is started with
+var queueConfig = QueueConfig{Depth: defaultQueueDepth, RetryAfter: time.Second}
+
+// busyDetails says why a matcher refused a command and when to try again
+func busyDetails(m *matcher) string {
+	return fmt.Sprintf("the %s matcher has %d commands waiting; retry after %s", m.symbol, cap(m.commands), queueConfig.RetryAfter)
+}
+
+// writeEngineBusy refuses a command the matcher had no room for, with the
+// wait in whole seconds in Retry-After
+func writeEngineBusy(w http.ResponseWriter, m *matcher) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(queueConfig.RetryAfter.Seconds()))))
+	writeError(w, http.StatusServiceUnavailable, ErrCodeEngineBusy, "Engine busy", busyDetails(m))
+}
+
+// getQueuesHandler lists how full every matcher's queue is
+func getQueuesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	response := MatcherQueuesResponse{Queues: []MatcherQueue{}, RetryAfterMs: queueConfig.RetryAfter.Milliseconds()}
+	for _, m := range allMatchers() {
+		response.Queues = append(response.Queues, MatcherQueue{
+			Symbol:  m.symbol,
+			Queued:  len(m.commands),
+			Depth:   cap(m.commands),
+			Refused: m.refused.Load(),
+		})
+	}
+	json.NewEncoder(w).Encode(response)
+}
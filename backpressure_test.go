@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fillQueue parks a matcher on a command and fills its queue behind it,
+// returning a func that lets them all run
+func fillQueue(t *testing.T, m *matcher) func() {
+	t.Helper()
+	release, started := make(chan struct{}), make(chan struct{})
+	go m.do(func() {
+		close(started)
+		<-release
+	})
+	<-started
+	for i := 0; i < cap(m.commands); i++ {
+		go m.do(func() {})
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(m.commands) < cap(m.commands) {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the queue to fill")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return func() { close(release) }
+}
+
+func TestBackpressure_RefusesOrdersWhileTheQueueIsFull(t *testing.T) {
+	setupTest()
+	queueConfig = QueueConfig{Depth: 2, RetryAfter: 1500 * time.Millisecond}
+	resetSymbols([]string{"BTC-USD"})
+	m, _ := matcherFor("BTC-USD")
+	release := fillQueue(t, m)
+
+	order := `{"symbol": "BTC-USD", "side": "buy", "price": 100, "quantity": 1}`
+	response := serve(HTTPConfig{}, httptest.NewRequest("POST", "/api/v1/orders", strings.NewReader(order)))
+	if result := decodeError(t, response); response.Code != http.StatusServiceUnavailable || result.Error.Code != ErrCodeEngineBusy {
+		t.Errorf("Expected the order refused as busy, got %d %s", response.Code, result.Error.Code)
+	}
+	if retry := response.Header().Get("Retry-After"); retry != "2" {
+		t.Errorf("Expected Retry-After rounded up to 2 seconds, got %q", retry)
+	}
+
+	quote := `{"symbol": "BTC-USD", "owner": "mm", "quotes": [{"side": "sell", "price": 101, "quantity": 1}]}`
+	response = serve(HTTPConfig{}, httptest.NewRequest("POST", "/api/v1/orders/mass-quote", strings.NewReader(quote)))
+	if response.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected the mass quote refused as busy, got %d", response.Code)
+	}
+	if _, failure := rpcPlaceOrder(context.Background(), json.RawMessage(order)); failure == nil || failure.Data.Code != ErrCodeEngineBusy {
+		t.Errorf("Expected the JSON-RPC order refused as busy, got %+v", failure)
+	}
+
+	// A cancel waits its turn instead
+	cancelled := make(chan ErrorCode)
+	go func() {
+		_, code := cancelOnAnySymbol(context.Background(), "BTC-USD", "missing")
+		cancelled <- code
+	}()
+	release()
+	if code := <-cancelled; code != ErrCodeOrderNotFound {
+		t.Errorf("Expected the cancel to run once the queue drained, got %s", code)
+	}
+
+	response = serve(HTTPConfig{}, httptest.NewRequest("POST", "/api/v1/orders", strings.NewReader(order)))
+	if response.Code != http.StatusOK {
+		t.Errorf("Expected the order placed once the queue drained, got %d: %s", response.Code, response.Body.String())
+	}
+	response = serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/admin/queues", nil))
+	var queues MatcherQueuesResponse
+	json.NewDecoder(response.Body).Decode(&queues)
+	if len(queues.Queues) != 1 || queues.Queues[0].Depth != 2 || queues.Queues[0].Refused != 3 || queues.RetryAfterMs != 1500 {
+		t.Errorf("Expected three refusals on a queue 2 deep, got %+v", queues)
+	}
+}
+
+func TestLoadConfig_Queue(t *testing.T) {
+	cfg, err := loadConfig([]string{"-queue-depth", "256", "-busy-retry-after", "250ms"})
+	if err != nil || cfg.Queue.Depth != 256 || cfg.Queue.RetryAfter != 250*time.Millisecond {
+		t.Fatalf("Unexpected queue config %+v (%v)", cfg.Queue, err)
+	}
+	if cfg, _ := loadConfig(nil); cfg.Queue.Depth != defaultQueueDepth || cfg.Queue.RetryAfter != time.Second {
+		t.Errorf("Unexpected default queue config %+v", cfg.Queue)
+	}
+	for _, args := range [][]string{{"-queue-depth", "0"}, {"-busy-retry-after", "0s"}} {
+		if _, err := loadConfig(args); err == nil {
+			t.Errorf("Expected an error for %v", args)
+		}
+	}
+}
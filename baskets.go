@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// BasketRequest is a set of orders on different symbols placed together.
+// Either every order passes its risk checks and goes on to match on its own
+// symbol, or none of them does.
+type BasketRequest struct {
+	// Owner places every order in the basket
+	Owner  string              `json:"owner,omitempty"`
+	Orders []PlaceOrderRequest `json:"orders"`
+}
+
+// basketOrderLimit is the most orders a basket may hold
+const basketOrderLimit = 20
+
+// validate checks each order as a placement would, and that the basket's
+// owner is the only one named
+func (req BasketRequest) validate() []FieldError {
+	var errs []FieldError
+	switch {
+	case len(req.Orders) == 0:
+		errs = append(errs, fieldError("orders", "required", "orders is required and cannot be empty"))
+	case len(req.Orders) > basketOrderLimit:
+		errs = append(errs, fieldError("orders", "max", "orders must hold at most %d orders (received: %d)", basketOrderLimit, len(req.Orders)))
+	}
+	for i, order := range req.Orders {
+		field := fmt.Sprintf("orders[%d]", i)
+		for _, err := range validateRequest(&order) {
+			errs = append(errs, FieldError{Field: field + "." + err.Field, Rule: err.Rule, Message: field + "." + err.Message})
+		}
+		if order.Owner != "" {
+			errs = append(errs, fieldError(field+".owner", "excluded", "%s.owner must be left out; the basket's owner places every order", field))
+		}
+	}
+	return errs
+}
+
+// BasketStatus is whether a basket's orders went on to match
+type BasketStatus string
+
+const (
+	BasketAccepted BasketStatus = "accepted"
+	BasketRejected BasketStatus = "rejected"
+)
+
+// BasketResponse is a placed basket's orders, in the order they were sent
+// and each in its state after matching, and the trades they made
+type BasketResponse struct {
+	BasketID string       `json:"basket_id"`
+	Status   BasketStatus `json:"status"`
+	Orders   []Order      `json:"orders"`
+	Trades   []Trade      `json:"trades"`
+}
+
+// BasketOrdersResponse is a basket's orders as they stand now, in the order
+// they were sent, and every trade they have made
+type BasketOrdersResponse struct {
+	BasketID string  `json:"basket_id"`
+	Orders   []Order `json:"orders"`
+	Trades   []Trade `json:"trades"`
+}
+
+// placeBasket screens every order of a basket with the matchers of all its
+// symbols held, so no book or account changes between one order's checks and
+// another's. If all pass, each is entered on its own matcher before that
+// matcher's next command; if one is refused, the others are rejected with
+// it. It returns the index of the refused order, or -1.
+func placeBasket(matchers []*matcher, orders []Order) int {
+	refused := -1
+	bySymbol := make(map[string]int, len(orders))
+	for i, order := range orders {
+		bySymbol[order.Symbol] = i
+	}
+
+	holdMatchers(matchers, func() {
+		now := engineClock.Now()
+		for i := range orders {
+			orders[i].Timestamps.AcceptedAt = now
+			stampArrival(&orders[i])
+			if refused >= 0 {
+				recordOrderEvent(orders[i].ID, "", orders[i].Status, "order accepted")
+				feed.order(orders[i], "order accepted")
+				rejectOrder(&orders[i], ErrCodeBasketRejected)
+				continue
+			}
+
+			// The orders before this one have passed, and would rest alongside it
+			book := bookFor(orders[i].Symbol)
+			book.basket = orders[:i]
+			var passed bool
+			orders[i], passed = screenBasketOrder(book, orders[i], now)
+			book.basket = nil
+			if !passed {
+				refused = i
+				for j := 0; j < i; j++ {
+					rejectOrder(&orders[j], ErrCodeBasketRejected)
+				}
+			}
+		}
+	}, func(m *matcher) {
+		i := bySymbol[m.symbol]
+		if refused < 0 {
+			orders[i] = enterOrder(m.book, orders[i])
+		}
+		stampMatched(&orders[i])
+		m.book.latency.add(latencyOf(orders[i]))
+	})
+	return refused
+}
+
+// screenBasketOrder runs an order's checks before it may trade, and also
+// refuses those a basket could not otherwise be sure of: orders that would
+// trade with their owner and pegs with nothing to price from
+func screenBasketOrder(book *OrderBook, order Order, now time.Time) (Order, bool) {
+	order, passed := screenOrder(book, order, now, true)
+	if !passed || shadowed(order.Symbol) || book.auction != nil || order.Type == OrderTypeTrailingStop {
+		return order, passed
+	}
+	if order.Type == OrderTypePegged {
+		bid, ask := referenceQuotes(book)
+		if _, ok := pegPrice(order, bid, ask); !ok {
+			rejectOrder(&order, ErrCodeNoReferencePrice)
+			return order, false
+		}
+	}
+	if crossesOwnOrder(book, order) {
+		rejectOrder(&order, ErrCodeSelfTrade)
+		return order, false
+	}
+	return order, true
+}
+
+// basketTrades returns the trades either side of which is one of orders
+func basketTrades(orders []Order) []Trade {
+	ids := make(map[string]bool, len(orders))
+	for _, order := range orders {
+		ids[order.ID] = true
+	}
+	trades := []Trade{}
+	for _, trade := range tradeStore.List("") {
+		if ids[trade.MakerID] || ids[trade.TakerID] {
+			trades = append(trades, trade)
+		}
+	}
+	return trades
+}
+
+// placeBasketHandler places a basket of orders across symbols atomically
+func placeBasketHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	receivedAt := engineClock.Now()
+
+	var req BasketRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	basketID := generateOrderID()
+	orders := make([]Order, len(req.Orders))
+	var matchers []*matcher
+	seen := make(map[string]int, len(req.Orders))
+	for i, placement := range req.Orders {
+		m, ok := matcherFor(placement.Symbol)
+		if !ok {
+			writeError(w, http.StatusBadRequest, ErrCodeUnknownSymbol, "Unknown symbol",
+				fmt.Sprintf("orders[%d]: symbol '%s' is not traded here", i, placement.Symbol))
+			return
+		}
+		if first, repeated := seen[m.symbol]; repeated {
+			writeError(w, http.StatusBadRequest, ErrCodeValidationFailed, "Validation failed", []FieldError{
+				fieldError(fmt.Sprintf("orders[%d].symbol", i), "unique", "orders[%d] is on %s like orders[%d]; a basket holds one order per symbol", i, m.symbol, first)})
+			return
+		}
+		seen[m.symbol] = i
+		matchers = append(matchers, m)
+
+		placement.Owner = req.Owner
+		orders[i] = newOrder(m.symbol, placement, receivedAt)
+		orders[i].BasketID = basketID
+	}
+
+	if refused := placeBasket(matchers, orders); refused >= 0 {
+		writeBasketRejection(w, orders[refused], refused)
+		return
+	}
+	writeBody(w, r, BasketResponse{BasketID: basketID, Status: BasketAccepted, Orders: orders, Trades: basketTrades(orders)})
+}
+
+// writeBasketRejection reports the order that kept a basket from being placed
+func writeBasketRejection(w http.ResponseWriter, order Order, index int) {
+	status := http.StatusUnprocessableEntity
+	if order.RejectReason == ErrCodeThrottled {
+		status = http.StatusTooManyRequests
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{
+		Error: APIError{
+			Code:    order.RejectReason,
+			Message: "Basket rejected",
+			Details: fmt.Sprintf("orders[%d] on %s was refused, so none of the basket's orders were placed: %s",
+				index, order.Symbol, rejectMessages[order.RejectReason]),
+		},
+		OrderID: order.ID,
+	})
+}
+
+// getBasketHandler returns the orders of the basket named in the path as they
+// stand now, and the trades they made
+func getBasketHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id := r.PathValue("id")
+	var orders []Order
+	for _, order := range collectOrders("") {
+		if order.BasketID == id {
+			orders = append(orders, order)
+		}
+	}
+	for _, closed := range closedStore.List(OrderHistoryFilter{}) {
+		if closed.BasketID == id {
+			orders = append(orders, closed.Order)
+		}
+	}
+	if len(orders) == 0 {
+		writeError(w, http.StatusNotFound, ErrCodeBasketNotFound, "Basket not found",
+			"no orders belong to basket '"+id+"'")
+		return
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].ArrivalSequence < orders[j].ArrivalSequence })
+	writeBody(w, r, BasketOrdersResponse{BasketID: id, Orders: orders, Trades: basketTrades(orders)})
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// postBasket places a basket through the server
+func postBasket(t *testing.T, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	request := httptest.NewRequest("POST", "/api/v1/baskets", strings.NewReader(body))
+	return serve(HTTPConfig{}, request)
+}
+
+func TestBasket_OrdersAndFillsShareTheBasketID(t *testing.T) {
+	setupSymbols("BTC-USD", "ETH-USD")
+	server := httptest.NewServer(newServer(HTTPConfig{DropCopyKeys: []string{"compliance-key"}}))
+	defer server.Close()
+	dropCopy := dialFeed(t, server, "/api/v1/feed/drop-copy", "compliance-key")
+	defer dropCopy.Close()
+	waitForFeeds(t, 1)
+
+	btc, _ := matcherFor("BTC-USD")
+	eth, _ := matcherFor("ETH-USD")
+	placeOn(btc, Order{ID: "btc-ask", Symbol: "BTC-USD", Side: SideSell, Price: 100, Quantity: 2, Owner: "bob"})
+	placeOn(eth, Order{ID: "eth-ask", Symbol: "ETH-USD", Side: SideSell, Price: 50, Quantity: 5, Owner: "bob"})
+
+	response, err := http.Post(server.URL+"/api/v1/baskets", "application/json", strings.NewReader(`{"owner":"alice","orders":[
+		{"symbol":"BTC-USD","side":"buy","price":100,"quantity":2},
+		{"symbol":"ETH-USD","side":"buy","price":50,"quantity":3}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	var placed BasketResponse
+	json.NewDecoder(response.Body).Decode(&placed)
+	if response.StatusCode != http.StatusOK || placed.Status != BasketAccepted || len(placed.Orders) != 2 || len(placed.Trades) != 2 {
+		t.Fatalf("Expected both orders placed and filled, got %d %+v", response.StatusCode, placed)
+	}
+	for i, symbol := range []string{"BTC-USD", "ETH-USD"} {
+		order := placed.Orders[i]
+		if order.Symbol != symbol || order.BasketID != placed.BasketID || order.Owner != "alice" || order.Status != OrderStatusFilled {
+			t.Errorf("Expected the %s order filled in basket %s, got %+v", symbol, placed.BasketID, order)
+		}
+	}
+
+	// The makers' fills are outside the basket
+	for range 4 {
+		fill, raw := readFeed(t, dropCopy, FeedMessageFill)
+		want := placed.BasketID
+		if fill.Fill.Owner == "bob" {
+			want = ""
+		}
+		if fill.Fill.BasketID != want {
+			t.Errorf("Expected %s's fill in basket %q, got %s", fill.Fill.Owner, want, raw)
+		}
+	}
+
+	var basket BasketOrdersResponse
+	json.NewDecoder(serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/baskets/"+placed.BasketID, nil)).Body).Decode(&basket)
+	if len(basket.Orders) != 2 || basket.Orders[0].Symbol != "BTC-USD" || len(basket.Trades) != 2 {
+		t.Errorf("Expected the basket's closed orders and trades, got %+v", basket)
+	}
+	if response := serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/baskets/missing", nil)); response.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown basket not found, got %d", response.Code)
+	}
+}
+
+func TestBasket_OneRefusalRejectsEveryOrder(t *testing.T) {
+	setupSymbols("BTC-USD", "ETH-USD")
+	positionLimits = PositionLimitConfig{Limits: map[string]int{"ETH-USD": 5}}
+	openFunded(t, "alice", map[string]float64{"USD": 10000})
+	btc, _ := matcherFor("BTC-USD")
+	placeOn(btc, Order{ID: "btc-ask", Symbol: "BTC-USD", Side: SideSell, Price: 100, Quantity: 2, Owner: "bob"})
+
+	response := postBasket(t, `{"owner":"alice","orders":[
+		{"symbol":"BTC-USD","side":"buy","price":100,"quantity":2},
+		{"symbol":"ETH-USD","side":"buy","price":50,"quantity":6}]}`)
+	result := decodeError(t, response)
+	if response.Code != http.StatusUnprocessableEntity || result.Error.Code != ErrCodePositionLimit || result.Error.Message != "Basket rejected" {
+		t.Fatalf("Expected the basket refused for the ETH-USD position limit, got %d %+v", response.Code, result.Error)
+	}
+
+	// The BTC-USD order passed its own checks but never reached the book
+	if ask := bookFor("BTC-USD").asks.orders(); len(ask) != 1 || ask[0].Quantity != 2 || len(tradeStore.List("")) != 0 {
+		t.Errorf("Expected the BTC-USD ask untouched, got %+v", ask)
+	}
+	if bids := bookFor("ETH-USD").bids.orders(); len(bids) != 0 {
+		t.Errorf("Expected nothing rested on ETH-USD, got %+v", bids)
+	}
+	var id string
+	for _, closed := range closedStore.List(OrderHistoryFilter{}) {
+		if closed.ID == result.OrderID {
+			id = closed.BasketID
+		}
+	}
+	var basket BasketOrdersResponse
+	json.NewDecoder(serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/baskets/"+id, nil)).Body).Decode(&basket)
+	if len(basket.Orders) != 2 || basket.Orders[0].RejectReason != ErrCodeBasketRejected || basket.Orders[1].RejectReason != ErrCodePositionLimit {
+		t.Errorf("Expected the BTC-USD order rejected with the basket, got %+v", basket.Orders)
+	}
+}
+
+func TestBasket_MarginAndCreditCountEarlierOrders(t *testing.T) {
+	setupSymbols("BTC-PERP", "ETH-PERP")
+	listPerpetual("BTC-PERP")
+	listPerpetual("ETH-PERP")
+	openFunded(t, "alice", map[string]float64{"USD": 100})
+	var asked []CreditRequest
+	creditChecker = CreditCheckerFunc(func(_ context.Context, request CreditRequest) (CreditDecision, error) {
+		asked = append(asked, request)
+		return CreditDecision{Approved: true}, nil
+	})
+
+	// 100 USD at 10x leverage covers either order, but not both
+	response := postBasket(t, `{"owner":"alice","orders":[
+		{"symbol":"BTC-PERP","side":"buy","price":100,"quantity":6},
+		{"symbol":"ETH-PERP","side":"buy","price":100,"quantity":6}]}`)
+	result := decodeError(t, response)
+	if response.Code != http.StatusUnprocessableEntity || result.Error.Code != ErrCodeInsufficientMargin || !strings.HasPrefix(result.Error.Details.(string), "orders[1] on ETH-PERP") {
+		t.Fatalf("Expected the ETH-PERP order refused for margin, got %d %+v", response.Code, result.Error)
+	}
+
+	// The credit checker is shown the orders before each one
+	asked = nil
+	if response := postBasket(t, `{"owner":"alice","orders":[
+		{"symbol":"BTC-PERP","side":"buy","price":100,"quantity":4},
+		{"symbol":"ETH-PERP","side":"buy","price":100,"quantity":4}]}`); response.Code != http.StatusOK {
+		t.Fatalf("Expected the smaller basket placed, got %d %s", response.Code, response.Body)
+	}
+	if len(asked) != 2 || len(asked[0].Basket) != 0 || len(asked[1].Basket) != 1 || asked[1].Basket[0].Symbol != "BTC-PERP" {
+		t.Errorf("Expected the second credit check shown the first order, got %+v", asked)
+	}
+}
+
+func TestBasket_Validation(t *testing.T) {
+	setupSymbols("BTC-USD", "ETH-USD")
+	tests := []struct {
+		name, body string
+		code       ErrorCode
+		field      string
+	}{
+		{"empty", `{"orders":[]}`, ErrCodeValidationFailed, "orders"},
+		{"invalid order", `{"orders":[{"symbol":"ETH-USD","side":"hold","price":1,"quantity":1}]}`, ErrCodeValidationFailed, "orders[0].side"},
+		{"owner per order", `{"owner":"alice","orders":[{"symbol":"ETH-USD","side":"buy","price":1,"quantity":1,"owner":"bob"}]}`, ErrCodeValidationFailed, "orders[0].owner"},
+		{"default symbol named twice", `{"orders":[{"symbol":"BTC-USD","side":"buy","price":1,"quantity":1},{"symbol":"","side":"sell","price":9,"quantity":1}]}`, ErrCodeValidationFailed, "orders[1].symbol"},
+		{"unknown symbol", `{"orders":[{"symbol":"DOGE-USD","side":"buy","price":1,"quantity":1}]}`, ErrCodeUnknownSymbol, ""},
+	}
+	for _, tt := range tests {
+		response := postBasket(t, tt.body)
+		result := decodeError(t, response)
+		if response.Code != http.StatusBadRequest || result.Error.Code != tt.code {
+			t.Errorf("%s: expected 400 %s, got %d %+v", tt.name, tt.code, response.Code, result.Error)
+			continue
+		}
+		if tt.field == "" {
+			continue
+		}
+		raw, _ := json.Marshal(result.Error.Details)
+		if !strings.Contains(string(raw), `"field":"`+tt.field+`"`) {
+			t.Errorf("%s: expected an error on %s, got %s", tt.name, tt.field, raw)
+		}
+	}
+	if len(bookFor("BTC-USD").bids.orders())+len(bookFor("ETH-USD").bids.orders()) != 0 {
+		t.Error("Expected no invalid basket to place an order")
+	}
+}
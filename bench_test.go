@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// benchDepths are the book depths, in levels a side, the matching benchmarks
+// run at
+var benchDepths = []int{10, 100, 1000}
+
+// benchBook resets the engine to a deterministic book with levels price
+// levels of one order each a side, the best a cent either side of 100
+func benchBook(b *testing.B, levels int) {
+	setupTest()
+	useDeterministicEngine(b, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	for i := 0; i < levels; i++ {
+		processOrder(Order{ID: fmt.Sprintf("bid-%d", i), Side: SideBuy, Price: benchLevel(SideBuy, i), Quantity: 10, Status: OrderStatusPending, CreatedAt: engineClock.Now()})
+		processOrder(Order{ID: fmt.Sprintf("ask-%d", i), Side: SideSell, Price: benchLevel(SideSell, i), Quantity: 10, Status: OrderStatusPending, CreatedAt: engineClock.Now()})
+	}
+}
+
+// benchLevel is the price of a side's ith level in benchBook
+func benchLevel(side Side, i int) float64 {
+	if side == SideBuy {
+		return 99.99 - float64(i)*0.01
+	}
+	return 100.01 + float64(i)*0.01
+}
+
+// benchOrder is a resting order at a random existing level of either side
+func benchOrder(rng *rand.Rand, levels int) Order {
+	side := SideBuy
+	if rng.Intn(2) == 1 {
+		side = SideSell
+	}
+	return Order{ID: generateOrderID(), Side: side, Price: benchLevel(side, rng.Intn(levels)), Quantity: 10, Status: OrderStatusPending, CreatedAt: engineClock.Now()}
+}
+
+// BenchmarkMatching_Insert adds orders that rest behind existing levels.
+// Every levels orders, the ones added are cancelled with the timer stopped,
+// so the book stays between one and two orders a level.
+func BenchmarkMatching_Insert(b *testing.B) {
+	for _, levels := range benchDepths {
+		b.Run(fmt.Sprintf("depth=%d", levels), func(b *testing.B) {
+			benchBook(b, levels)
+			rng := rand.New(rand.NewSource(1))
+			added := make([]string, 0, levels)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if len(added) == levels {
+					b.StopTimer()
+					for _, id := range added {
+						cancelOrder("", id)
+					}
+					added = added[:0]
+					b.StartTimer()
+				}
+				added = append(added, processOrder(benchOrder(rng, levels)).ID)
+			}
+		})
+	}
+}
+
+// BenchmarkMatching_Cancel cancels a random resting order and rests a new one
+// at a random level, as a quoting market maker would, so the book holds
+// still at one order a level
+func BenchmarkMatching_Cancel(b *testing.B) {
+	for _, levels := range benchDepths {
+		b.Run(fmt.Sprintf("depth=%d", levels), func(b *testing.B) {
+			benchBook(b, levels)
+			rng := rand.New(rand.NewSource(1))
+			resting := make([]string, 0, 2*levels)
+			for i := 0; i < levels; i++ {
+				resting = append(resting, fmt.Sprintf("bid-%d", i), fmt.Sprintf("ask-%d", i))
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				j := rng.Intn(len(resting))
+				if _, ok := cancelOrder("", resting[j]); !ok {
+					b.Fatalf("Expected %s resting", resting[j])
+				}
+				resting[j] = processOrder(benchOrder(rng, levels)).ID
+			}
+		})
+	}
+}
+
+// BenchmarkMatching_Cross takes the whole best level of alternating sides
+// and puts it back, so every other order trades and the book holds still
+func BenchmarkMatching_Cross(b *testing.B) {
+	for _, levels := range benchDepths {
+		b.Run(fmt.Sprintf("depth=%d", levels), func(b *testing.B) {
+			benchBook(b, levels)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				taker, maker := SideBuy, SideSell
+				if i%2 == 1 {
+					taker, maker = SideSell, SideBuy
+				}
+				price := benchLevel(maker, 0)
+				taken := processOrder(Order{ID: generateOrderID(), Side: taker, Price: price, Quantity: 10, Status: OrderStatusPending, CreatedAt: engineClock.Now()})
+				if taken.Status != OrderStatusFilled {
+					b.Fatalf("Expected the taker filled, got %+v", taken)
+				}
+				processOrder(Order{ID: generateOrderID(), Side: maker, Price: price, Quantity: 10, Status: OrderStatusPending, CreatedAt: engineClock.Now()})
+			}
+		})
+	}
+}
+
+// benchStep sends one operation of lobbench's default mix on the matcher, the
+// way the REST handlers do: 70% limits resting up to a point from 100, 20%
+// priced 5% through it so they cross, and 10% cancels of an order the worker
+// placed earlier. It returns the worker's orders that may still rest.
+func benchStep(b *testing.B, m *matcher, rng *rand.Rand, resting []string) []string {
+	r := rng.Float64()
+	if r < 0.1 && len(resting) > 0 {
+		j := rng.Intn(len(resting))
+		id := resting[j]
+		resting[j] = resting[len(resting)-1]
+		m.do(func() { cancelOrder(m.symbol, id) })
+		return resting[:len(resting)-1]
+	}
+
+	side := SideBuy
+	if rng.Intn(2) == 1 {
+		side = SideSell
+	}
+	offset := rng.Float64()
+	if r < 0.3 {
+		offset = -5
+	}
+	price := 100 - offset
+	if side == SideSell {
+		price = 100 + offset
+	}
+	req := PlaceOrderRequest{Side: side, Price: math.Round(price*100) / 100, Quantity: 1 + rng.Intn(10)}
+	order, err := submitOrder(context.Background(), m, newOrder(m.symbol, req, engineClock.Now()))
+	if err != nil {
+		b.Error(err)
+		return resting
+	}
+	if order.Status == OrderStatusPending || order.Status == OrderStatusPartiallyFilled {
+		resting = append(resting, order.ID)
+	}
+	return resting
+}
+
+// BenchmarkMixedFlow is lobbench's in-process target: parallel workers send
+// its default mix straight to the matcher, leaving out HTTP and JSON. Besides
+// the time per operation it reports the p50 and p99 latency of one.
+func BenchmarkMixedFlow(b *testing.B) {
+	setupTest()
+	m, _ := matcherFor("")
+	var (
+		mu        sync.Mutex
+		latencies []time.Duration
+		seed      atomic.Int64
+	)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		rng := rand.New(rand.NewSource(seed.Add(1)))
+		var resting []string
+		var local []time.Duration
+		for pb.Next() {
+			start := time.Now()
+			resting = benchStep(b, m, rng, resting)
+			local = append(local, time.Since(start))
+		}
+		mu.Lock()
+		latencies = append(latencies, local...)
+		mu.Unlock()
+	})
+	b.StopTimer()
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	if n := len(latencies); n > 0 {
+		b.ReportMetric(float64(latencies[n*50/100]), "p50-ns")
+		b.ReportMetric(float64(latencies[n*99/100]), "p99-ns")
+	}
+}
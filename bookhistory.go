@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// BookHistoryConfig controls how much of each book's depth is kept for replay
+type BookHistoryConfig struct {
+	// Interval is how often a full snapshot is kept, with every depth change
+	// in between; zero keeps no history
+	Interval  time.Duration
+	Retention time.Duration
+}
+
+// bookHistory is the book history configuration the matchers record under
+var bookHistory BookHistoryConfig
+
+// BookHistoryResponse is a symbol's depth as it stood at a moment in the past
+type BookHistoryResponse struct {
+	DepthSnapshot
+	// At is the moment asked for, and ChangedAt when the book last changed
+	// before it
+	At        time.Time `json:"at"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// recordedDepth is a full snapshot or an update, with when the book reached it
+type recordedDepth struct {
+	at     time.Time
+	full   bool
+	update DepthUpdate
+}
+
+// recordHistory keeps an update in the book's history, as a full snapshot
+// when the interval has passed since the last one, and drops what has aged
+// past the retention. It must run on the matcher.
+func (m *matcher) recordHistory(update DepthUpdate) {
+	now := engineClock.Now()
+	if n := len(m.historySnapshots); n == 0 || now.Sub(m.history[m.historySnapshots[n-1]].at) >= bookHistory.Interval {
+		m.historySnapshots = append(m.historySnapshots, len(m.history))
+		update.Bids = aggregateLevels(m.book.bids.orders(), 0)
+		update.Asks = aggregateLevels(m.book.asks.orders(), 0)
+		m.history = append(m.history, recordedDepth{at: now, full: true, update: update})
+	} else {
+		m.history = append(m.history, recordedDepth{at: now, update: update})
+	}
+
+	// The newest snapshot at or before the cutoff is still needed to replay
+	// the changes after it
+	cutoff := now.Add(-bookHistory.Retention)
+	drop := 0
+	for len(m.historySnapshots) > drop+1 && !m.history[m.historySnapshots[drop+1]].at.After(cutoff) {
+		drop++
+	}
+	if drop > 0 {
+		first := m.historySnapshots[drop]
+		m.history = append(m.history[:0], m.history[first:]...)
+		m.historySnapshots = m.historySnapshots[drop:]
+		for i := range m.historySnapshots {
+			m.historySnapshots[i] -= first
+		}
+	}
+}
+
+// depthAt rebuilds the book as it stood at a moment, or at the end of a
+// sequence number when sequence is positive, from the newest snapshot before
+// it and the changes that followed. It returns false if the history does not
+// reach back that far. It must run on the matcher.
+func (m *matcher) depthAt(at time.Time, sequence int64, limit int) (BookHistoryResponse, bool) {
+	reached := func(recorded recordedDepth) bool {
+		if sequence > 0 {
+			return recorded.update.Sequence <= sequence
+		}
+		return !recorded.at.After(at)
+	}
+	start := -1
+	for _, i := range m.historySnapshots {
+		if reached(m.history[i]) {
+			start = i
+		}
+	}
+	if start < 0 {
+		return BookHistoryResponse{}, false
+	}
+
+	bids, asks := make(map[float64]PriceLevel), make(map[float64]PriceLevel)
+	last := m.history[start]
+	for _, recorded := range m.history[start:] {
+		if !reached(recorded) {
+			break
+		}
+		last = recorded
+		for _, side := range []struct {
+			levels  []PriceLevel
+			applied map[float64]PriceLevel
+		}{{recorded.update.Bids, bids}, {recorded.update.Asks, asks}} {
+			if recorded.full {
+				clear(side.applied)
+			}
+			for _, level := range side.levels {
+				if level.Quantity == 0 {
+					delete(side.applied, level.Price)
+				} else {
+					side.applied[level.Price] = level
+				}
+			}
+		}
+	}
+	if sequence > 0 {
+		at = last.at
+	}
+
+	response := BookHistoryResponse{
+		DepthSnapshot: DepthSnapshot{Symbol: m.symbol, Sequence: last.update.Sequence},
+		At:            at,
+		ChangedAt:     last.at,
+	}
+	response.Bids = sortedLevels(bids, SideBuy, limit)
+	response.Asks = sortedLevels(asks, SideSell, limit)
+	return response, true
+}
+
+// sortedLevels lists one side's levels best price first, keeping at most
+// limit of them; zero keeps every level
+func sortedLevels(levels map[float64]PriceLevel, side Side, limit int) []PriceLevel {
+	sorted := make([]PriceLevel, 0, len(levels))
+	for _, level := range levels {
+		sorted = append(sorted, level)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if side == SideBuy {
+			return sorted[i].Price > sorted[j].Price
+		}
+		return sorted[i].Price < sorted[j].Price
+	})
+	if limit > 0 && len(sorted) > limit {
+		sorted = sorted[:limit]
+	}
+	return sorted
+}
+
+// getBookHistoryHandler returns a symbol's depth as it stood at the time or
+// sequence number in the query
+func getBookHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	m, ok := matcherFor(r.URL.Query().Get("symbol"))
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrCodeUnknownSymbol, "Unknown symbol",
+			"symbol '"+r.URL.Query().Get("symbol")+"' is not traded here")
+		return
+	}
+
+	var validationErrors []string
+	var at time.Time
+	var sequence int64
+	rawAt, rawSequence := r.URL.Query().Get("at"), r.URL.Query().Get("sequence")
+	switch {
+	case (rawAt == "") == (rawSequence == ""):
+		validationErrors = append(validationErrors, "exactly one of at and sequence is required")
+	case rawAt != "":
+		parsed, err := time.Parse(time.RFC3339Nano, rawAt)
+		if err != nil {
+			validationErrors = append(validationErrors, "at must be an RFC 3339 timestamp (received: '"+rawAt+"')")
+		}
+		at = parsed
+	default:
+		parsed, err := strconv.ParseInt(rawSequence, 10, 64)
+		if err != nil || parsed <= 0 {
+			validationErrors = append(validationErrors, "sequence must be a positive integer (received: '"+rawSequence+"')")
+		}
+		sequence = parsed
+	}
+	limit := 0
+	if raw := r.URL.Query().Get("levels"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 0 {
+			validationErrors = append(validationErrors, "levels must be a non-negative integer (received: '"+raw+"')")
+		}
+		limit = parsed
+	}
+	if len(validationErrors) > 0 {
+		writeError(w, http.StatusBadRequest, ErrCodeValidationFailed, "Validation failed", validationErrors)
+		return
+	}
+	// Top-of-book callers only see the best level on each side
+	if !entitlementFrom(r.Context()).allows(EntitlementL2) {
+		limit = 1
+	}
+
+	var response BookHistoryResponse
+	m.do(func() {
+		// Expiries due by now belong in the history before it is read
+		expireOrders(m.book, engineClock.Now())
+		m.publishDepth()
+		response, ok = m.depthAt(at, sequence, limit)
+	})
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrCodeNoBookHistory, "No book history",
+			"the book history of '"+m.symbol+"' does not reach back that far")
+		return
+	}
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// bookAt fetches the book history for a query and decodes it
+func bookAt(t *testing.T, query string) (BookHistoryResponse, int) {
+	t.Helper()
+	result := serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/orderbook/history?"+query, nil))
+	var response BookHistoryResponse
+	json.NewDecoder(result.Body).Decode(&response)
+	return response, result.Code
+}
+
+func TestBookHistory_ReplaysTheBookAtAPastMoment(t *testing.T) {
+	setupTest()
+	start := time.Date(2024, 1, 1, 9, 30, 0, 0, time.UTC)
+	clock := useDeterministicEngine(t, start)
+	bookHistory = BookHistoryConfig{Interval: time.Minute, Retention: time.Hour}
+
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "ask-1", Side: SideSell, Price: 101.0, Quantity: 3, Owner: "maker"})
+	clock.Advance(10 * time.Second)
+	placeOn(m, Order{ID: "bid-1", Side: SideBuy, Price: 99.0, Quantity: 2, Owner: "maker"})
+	clock.Advance(10 * time.Second)
+	placeOn(m, Order{ID: "sell-1", Side: SideSell, Price: 99.0, Quantity: 2, Owner: "taker"})
+	// Past the interval, the next change is kept as a new snapshot
+	clock.Advance(2 * time.Minute)
+	placeOn(m, Order{ID: "ask-2", Side: SideSell, Price: 102.0, Quantity: 1, Owner: "maker"})
+
+	book, code := bookAt(t, "at="+start.Add(15*time.Second).Format(time.RFC3339))
+	if code != http.StatusOK || book.Sequence != 2 || len(book.Bids) != 1 || book.Bids[0].Quantity != 2 || len(book.Asks) != 1 || book.Asks[0].Price != 101 {
+		t.Fatalf("Expected the book after the bid arrived, got %d %+v", code, book)
+	}
+	if !book.ChangedAt.Equal(start.Add(10 * time.Second)) {
+		t.Errorf("Expected the book to have last changed when the bid arrived, got %v", book.ChangedAt)
+	}
+	if book, _ := bookAt(t, "at="+start.Add(time.Minute).Format(time.RFC3339)); book.Sequence != 3 || len(book.Bids) != 0 || len(book.Asks) != 1 {
+		t.Errorf("Expected the bid to have filled, got %+v", book)
+	}
+	if book, _ := bookAt(t, "sequence=4&levels=1"); len(book.Asks) != 1 || book.Asks[0].Price != 101 || !book.At.Equal(start.Add(140*time.Second)) {
+		t.Errorf("Expected only the best ask at sequence 4, got %+v", book)
+	}
+	if _, code := bookAt(t, "at="+start.Add(-time.Second).Format(time.RFC3339)); code != http.StatusNotFound {
+		t.Errorf("Expected 404 before the history starts, got %d", code)
+	}
+
+	// Snapshots that have aged past the retention are dropped with their changes
+	clock.Advance(2 * time.Hour)
+	placeOn(m, Order{ID: "ask-3", Side: SideSell, Price: 103.0, Quantity: 1, Owner: "maker"})
+	if _, code := bookAt(t, "at="+start.Add(15*time.Second).Format(time.RFC3339)); code != http.StatusNotFound {
+		t.Errorf("Expected 404 past the retention, got %d", code)
+	}
+	if book, code := bookAt(t, "sequence=5"); code != http.StatusOK || len(book.Asks) != 3 {
+		t.Errorf("Expected the newest snapshot kept, got %d %+v", code, book)
+	}
+}
+
+func TestBookHistory_Validation(t *testing.T) {
+	setupTest()
+	for _, query := range []string{"", "at=yesterday", "sequence=0", "at=2024-01-01T00:00:00Z&sequence=1", "sequence=1&levels=-1"} {
+		if _, code := bookAt(t, query); code != http.StatusBadRequest {
+			t.Errorf("%q: expected 400, got %d", query, code)
+		}
+	}
+	if _, code := bookAt(t, "sequence=1"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 with book history off, got %d", code)
+	}
+	if _, err := loadConfig([]string{"-book-history-interval", "-1s"}); err == nil {
+		t.Error("Expected a negative interval to be refused")
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"math/rand"
+	"time"
+)
+
+const (
+	marketMakerOwner = "bot-market-maker"
+	takerOwner       = "bot-taker"
+)
+
+// marketMaker quotes both sides of the book around a random-walk reference price
+type marketMaker struct {
+	cfg       BotConfig
+	rng       *rand.Rand
+	reference float64
+	quotes    []string
+}
+
+// taker sends marketable orders of random size and side against the best quotes
+type taker struct {
+	cfg BotConfig
+	rng *rand.Rand
+}
+
+// startBots runs the configured bots until ctx is cancelled
+func startBots(ctx context.Context, cfg BotConfig) {
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	m, ok := matcherFor(cfg.Symbol)
+	if !ok {
+		log.Printf("bots: unknown symbol %q, bots not started", cfg.Symbol)
+		return
+	}
+	cfg.Symbol = m.symbol
+
+	mm := newMarketMaker(cfg, rand.New(rand.NewSource(seed)))
+	tk := newTaker(cfg, rand.New(rand.NewSource(seed+1)))
+
+	go runBot(ctx, m, cfg.QuoteInterval, mm.step)
+	go runBot(ctx, m, cfg.TakerInterval, tk.step)
+
+	log.Printf("bots: market maker quoting %s around %.2f, taker every %s", m.symbol, cfg.StartPrice, cfg.TakerInterval)
+}
+
+// runBot calls step on the symbol's matcher on every tick
+func runBot(ctx context.Context, m *matcher, interval time.Duration, step func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.do(step)
+		}
+	}
+}
+
+func newMarketMaker(cfg BotConfig, rng *rand.Rand) *marketMaker {
+	return &marketMaker{cfg: cfg, rng: rng, reference: cfg.StartPrice}
+}
+
+// step moves the reference price and replaces every resting quote
+func (m *marketMaker) step() {
+	m.reference = math.Max(m.cfg.LevelStep, m.reference*(1+m.cfg.Volatility*m.rng.NormFloat64()))
+
+	for _, orderID := range m.quotes {
+		cancelOrder(m.cfg.Symbol, orderID)
+	}
+	m.quotes = m.quotes[:0]
+
+	half := m.cfg.Spread / 2
+	for level := 0; level < m.cfg.Levels; level++ {
+		offset := half + float64(level)*m.cfg.LevelStep
+		m.quote(SideBuy, roundPrice(m.reference-offset))
+		m.quote(SideSell, roundPrice(m.reference+offset))
+	}
+}
+
+// quote places a single resting order and remembers it for the next re-quote
+func (m *marketMaker) quote(side Side, price float64) {
+	if price <= 0 {
+		return
+	}
+
+	order := processOrder(Order{
+		ID:        generateOrderID(),
+		Symbol:    m.cfg.Symbol,
+		Side:      side,
+		Quantity:  1 + m.rng.Intn(m.cfg.QuoteQuantity),
+		Price:     price,
+		Status:    OrderStatusPending,
+		CreatedAt: engineClock.Now(),
+		Owner:     marketMakerOwner,
+	})
+	if order.Quantity > 0 && !isTerminalStatus(order.Status) {
+		m.quotes = append(m.quotes, order.ID)
+	}
+}
+
+func newTaker(cfg BotConfig, rng *rand.Rand) *taker {
+	return &taker{cfg: cfg, rng: rng}
+}
+
+// step crosses the spread at the current best opposite price and cancels any remainder
+func (t *taker) step() {
+	book := bookFor(t.cfg.Symbol)
+	side, opposite := SideBuy, &book.asks
+	if t.rng.Intn(2) == 0 {
+		side, opposite = SideSell, &book.bids
+	}
+	best, ok := opposite.first()
+	if !ok {
+		return
+	}
+
+	order := processOrder(Order{
+		ID:        generateOrderID(),
+		Symbol:    t.cfg.Symbol,
+		Side:      side,
+		Quantity:  1 + t.rng.Intn(t.cfg.TakerMaxQuantity),
+		Price:     best.Price,
+		Status:    OrderStatusPending,
+		CreatedAt: engineClock.Now(),
+		Owner:     takerOwner,
+	})
+
+	// The taker never rests; pull whatever did not fill
+	if order.Quantity > 0 && !isTerminalStatus(order.Status) {
+		cancelOrder(t.cfg.Symbol, order.ID)
+	}
+}
+
+// roundPrice rounds a price to two decimal places
+func roundPrice(price float64) float64 {
+	return math.Round(price*100) / 100
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func testBotConfig() BotConfig {
+	return BotConfig{
+		Enabled:          true,
+		StartPrice:       100.0,
+		Volatility:       0.001,
+		Spread:           0.10,
+		LevelStep:        0.05,
+		Levels:           3,
+		QuoteQuantity:    10,
+		QuoteInterval:    time.Second,
+		TakerMaxQuantity: 5,
+		TakerInterval:    time.Second,
+	}
+}
+
+// ordersByOwner counts resting orders belonging to owner
+func ordersByOwner(owner string) int {
+	count := 0
+	for _, order := range getAllOrders() {
+		if order.Owner == owner {
+			count++
+		}
+	}
+	return count
+}
+
+func TestMarketMaker_QuotesBothSides(t *testing.T) {
+	setupTest()
+
+	mm := newMarketMaker(testBotConfig(), rand.New(rand.NewSource(1)))
+	mm.step()
+
+	if len(bookFor("").bids.orders()) != 3 || len(bookFor("").asks.orders()) != 3 {
+		t.Fatalf("Expected 3 quotes per side, got %d bids and %d asks", len(bookFor("").bids.orders()), len(bookFor("").asks.orders()))
+	}
+
+	if bookFor("").bids.orders()[0].Price >= bookFor("").asks.orders()[0].Price {
+		t.Errorf("Expected best bid %.2f below best ask %.2f", bookFor("").bids.orders()[0].Price, bookFor("").asks.orders()[0].Price)
+	}
+
+	if len(tradeStore.List("")) != 0 {
+		t.Errorf("Expected the market maker not to trade with itself, got %d trades", len(tradeStore.List("")))
+	}
+}
+
+func TestMarketMaker_RequoteReplacesQuotes(t *testing.T) {
+	setupTest()
+
+	mm := newMarketMaker(testBotConfig(), rand.New(rand.NewSource(1)))
+	mm.step()
+	first := bookFor("").bids.orders()[0].ID
+	mm.step()
+
+	if ordersByOwner(marketMakerOwner) != 6 {
+		t.Errorf("Expected 6 resting quotes after re-quote, got %d", ordersByOwner(marketMakerOwner))
+	}
+
+	for _, order := range getAllOrders() {
+		if order.ID == first {
+			t.Error("Expected previous quotes to be cancelled")
+		}
+	}
+}
+
+func TestMarketMaker_ReferenceStaysPositive(t *testing.T) {
+	setupTest()
+
+	cfg := testBotConfig()
+	cfg.StartPrice = 0.05
+	cfg.Volatility = 5
+	mm := newMarketMaker(cfg, rand.New(rand.NewSource(7)))
+
+	for i := 0; i < 50; i++ {
+		mm.step()
+		if mm.reference <= 0 {
+			t.Fatalf("Expected positive reference price, got %.4f", mm.reference)
+		}
+	}
+
+	for _, order := range getAllOrders() {
+		if order.Price <= 0 {
+			t.Errorf("Expected only positive quote prices, got %.2f", order.Price)
+		}
+	}
+}
+
+func TestTaker_CrossesAndNeverRests(t *testing.T) {
+	setupTest()
+
+	cfg := testBotConfig()
+	mm := newMarketMaker(cfg, rand.New(rand.NewSource(1)))
+	tk := newTaker(cfg, rand.New(rand.NewSource(2)))
+
+	mm.step()
+	for i := 0; i < 10; i++ {
+		tk.step()
+	}
+
+	if len(tradeStore.List("")) == 0 {
+		t.Error("Expected the taker to generate trades")
+	}
+
+	if ordersByOwner(takerOwner) != 0 {
+		t.Errorf("Expected no resting taker orders, got %d", ordersByOwner(takerOwner))
+	}
+}
+
+func TestTaker_SkipsEmptyBook(t *testing.T) {
+	setupTest()
+
+	tk := newTaker(testBotConfig(), rand.New(rand.NewSource(1)))
+	tk.step()
+
+	if len(orderEvents) != 0 {
+		t.Errorf("Expected no orders against an empty book, got %d events", len(orderEvents))
+	}
+}
+
+func TestLoadConfig_Bots(t *testing.T) {
+	cfg, err := loadConfig([]string{"-addr", ":9090", "-bots", "-bot-price", "250", "-bot-levels", "2", "-taker-interval", "2s"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if cfg.Addr != ":9090" || !cfg.Bots.Enabled {
+		t.Errorf("Unexpected config %+v", cfg)
+	}
+
+	if cfg.Bots.StartPrice != 250 || cfg.Bots.Levels != 2 || cfg.Bots.TakerInterval != 2*time.Second {
+		t.Errorf("Unexpected bot config %+v", cfg.Bots)
+	}
+}
+
+func TestLoadConfig_RejectsInvalidBots(t *testing.T) {
+	for _, args := range [][]string{
+		{"-bots", "-bot-levels", "0"},
+		{"-bots", "-bot-qty", "0"},
+		{"-bots", "-taker-qty", "-1"},
+		{"-bots", "-bot-interval", "0"},
+		{"-bots", "-taker-interval", "-1s"},
+		{"-bots", "-bot-spread", "-0.1"},
+		{"-bots", "-bot-level-step", "-0.05"},
+	} {
+		if _, err := loadConfig(args); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
+
+func TestLoadConfig_Defaults(t *testing.T) {
+	cfg, err := loadConfig(nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if cfg.Addr != ":8080" || cfg.Bots.Enabled {
+		t.Errorf("Unexpected default config %+v", cfg)
+	}
+}
+
+func TestRoundPrice(t *testing.T) {
+	if roundPrice(100.126) != 100.13 {
+		t.Errorf("Expected 100.13, got %v", roundPrice(100.126))
+	}
+}
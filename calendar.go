@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ScheduledPhase is a phase a trading day enters at a time of day
+type ScheduledPhase struct {
+	// Offset is the time of day, from midnight
+	Offset time.Duration
+	Phase  TradingPhase
+	// reason is why the day differs from its calendar's sessions, if it does
+	reason string
+}
+
+// TradingCalendar is the phases a group of symbols goes through each day,
+// in Location's time. Weekends and holidays are closed all day, and an
+// early close ends a day's trading sooner than its sessions do.
+type TradingCalendar struct {
+	Name string
+	// Symbols are the symbols that follow the calendar; none makes it the
+	// calendar of every symbol no other one names
+	Symbols  []string
+	Location *time.Location
+	Sessions []ScheduledPhase
+	Weekend  []time.Weekday
+	// Holidays and EarlyCloses are keyed by date, as 2006-01-02
+	Holidays    map[string]bool
+	EarlyCloses map[string]time.Duration
+}
+
+// calendarFile is how a trading calendar is written in a -trading-calendars file
+type calendarFile struct {
+	Name     string   `json:"name"`
+	Symbols  []string `json:"symbols"`
+	Timezone string   `json:"timezone"`
+	Sessions string   `json:"sessions"`
+	// Weekend is missing for Saturday and Sunday, and empty for none
+	Weekend     *[]string         `json:"weekend"`
+	Holidays    []string          `json:"holidays"`
+	EarlyCloses map[string]string `json:"early_closes"`
+}
+
+// CalendarDay is one day of a symbol's trading calendar
+type CalendarDay struct {
+	Date string `json:"date"`
+	// Trading is false on weekends and holidays
+	Trading    bool          `json:"trading"`
+	Holiday    bool          `json:"holiday,omitempty"`
+	EarlyClose bool          `json:"early_close,omitempty"`
+	Phases     []PhaseChange `json:"phases"`
+}
+
+// CalendarResponse lists the coming days of a symbol's trading calendar
+type CalendarResponse struct {
+	Symbol   string        `json:"symbol"`
+	Calendar string        `json:"calendar"`
+	Timezone string        `json:"timezone"`
+	Days     []CalendarDay `json:"days"`
+}
+
+// maxCalendarDays caps how far ahead the calendar endpoint lists days, and
+// how far ahead the next phase change is looked for
+const maxCalendarDays = 366
+
+// calendars maps each symbol with its own calendar to it, and
+// defaultCalendar is followed by the rest; both are nil without one
+var (
+	calendars       map[string]*TradingCalendar
+	defaultCalendar *TradingCalendar
+)
+
+// configureCalendars makes the given calendars the ones symbols follow
+func configureCalendars(list []TradingCalendar) {
+	calendars, defaultCalendar = make(map[string]*TradingCalendar), nil
+	for i := range list {
+		calendar := &list[i]
+		if len(calendar.Symbols) == 0 {
+			defaultCalendar = calendar
+		}
+		for _, symbol := range calendar.Symbols {
+			calendars[symbol] = calendar
+		}
+	}
+}
+
+// calendarFor returns the calendar a symbol follows, or nil if it trades
+// continuously
+func calendarFor(symbol string) *TradingCalendar {
+	if calendar, ok := calendars[symbol]; ok {
+		return calendar
+	}
+	return defaultCalendar
+}
+
+// plan returns the phase changes of the day starting at midnight
+func (c *TradingCalendar) plan(midnight time.Time) []ScheduledPhase {
+	date := midnight.Format(time.DateOnly)
+	switch {
+	case c.Holidays[date]:
+		return []ScheduledPhase{{Phase: PhaseClosed, reason: "holiday"}}
+	case slices.Contains(c.Weekend, midnight.Weekday()):
+		return []ScheduledPhase{{Phase: PhaseClosed, reason: "weekend"}}
+	}
+	closeAt, early := c.EarlyCloses[date]
+	if !early {
+		return c.Sessions
+	}
+
+	// Trading ends at the early close, in the phase that ends the day's
+	// trading. Openings after it are skipped, and later changes kept.
+	var plan []ScheduledPhase
+	closed := false
+	for _, session := range c.Sessions {
+		switch {
+		case session.Offset < closeAt || closed:
+			plan = append(plan, session)
+		case session.Phase == PhasePostClose || session.Phase == PhaseClosed:
+			phase := session.Phase
+			if n := len(plan); n > 0 && !slices.Contains(phaseTransitions[plan[n-1].Phase], phase) {
+				phase = PhaseClosed
+			}
+			plan = append(plan, ScheduledPhase{Offset: closeAt, Phase: phase, reason: "early close"})
+			closed = true
+		}
+	}
+	if !closed {
+		plan = append(plan, ScheduledPhase{Offset: closeAt, Phase: PhaseClosed, reason: "early close"})
+	}
+	return plan
+}
+
+// at returns the phase the calendar is in at a moment and its next change,
+// which is zero if it never changes. Before a day's first change, the
+// previous day's last phase still holds.
+func (c *TradingCalendar) at(now time.Time) (ScheduledPhase, PhaseChange) {
+	local := now.In(c.Location)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, c.Location)
+	previous := c.plan(midnight.AddDate(0, 0, -1))
+	current := previous[len(previous)-1]
+	for _, scheduled := range c.plan(midnight) {
+		if scheduled.Offset <= local.Sub(midnight) {
+			current = scheduled
+		}
+	}
+
+	for day := 0; day <= maxCalendarDays; day++ {
+		date := midnight.AddDate(0, 0, day)
+		for _, scheduled := range c.plan(date) {
+			if at := date.Add(scheduled.Offset); at.After(now) && scheduled.Phase != current.Phase {
+				return current, PhaseChange{Phase: scheduled.Phase, At: at}
+			}
+		}
+	}
+	return current, PhaseChange{}
+}
+
+// parseSessions parses comma-separated HH:MM=phase pairs, in order through
+// the day. Each phase must be able to move to the next one, and the last to
+// the first.
+func parseSessions(source, list string) ([]ScheduledPhase, error) {
+	var sessions []ScheduledPhase
+	for _, item := range splitList(list) {
+		clock, phase, ok := strings.Cut(item, "=")
+		at, err := time.Parse("15:04", strings.TrimSpace(clock))
+		if !ok || err != nil {
+			return nil, fmt.Errorf("%s entry %q must be HH:MM=phase", source, item)
+		}
+		session := ScheduledPhase{Offset: time.Duration(at.Hour())*time.Hour + time.Duration(at.Minute())*time.Minute, Phase: TradingPhase(strings.TrimSpace(phase))}
+		if !slices.Contains(scheduledPhases, session.Phase) {
+			return nil, fmt.Errorf("%s phase %q must be closed, pre_open, continuous or post_close", source, phase)
+		}
+		if n := len(sessions); n > 0 && session.Offset <= sessions[n-1].Offset {
+			return nil, fmt.Errorf("%s entry %q must come later in the day than the one before it", source, item)
+		}
+		sessions = append(sessions, session)
+	}
+	for i, session := range sessions {
+		next := sessions[(i+1)%len(sessions)]
+		if next.Phase != session.Phase && !slices.Contains(phaseTransitions[session.Phase], next.Phase) {
+			return nil, fmt.Errorf("%s cannot move from %s to %s", source, session.Phase, next.Phase)
+		}
+	}
+	return sessions, nil
+}
+
+// parseTradingSchedule builds the calendar every symbol follows from the
+// -trading-schedule sessions, which run every day in the named time zone.
+// An empty list leaves the symbols trading continuously.
+func parseTradingSchedule(list, zone string) ([]TradingCalendar, error) {
+	location, err := time.LoadLocation(zone)
+	if err != nil {
+		return nil, fmt.Errorf("-trading-timezone %q: %v", zone, err)
+	}
+	sessions, err := parseSessions("-trading-schedule", list)
+	if err != nil || len(sessions) == 0 {
+		return nil, err
+	}
+	return []TradingCalendar{{Name: "default", Location: location, Sessions: sessions}}, nil
+}
+
+// loadTradingCalendars reads the calendars in a -trading-calendars JSON
+// file. Each calendar needs sessions, and at most one may name no symbols.
+func loadTradingCalendars(path string) ([]TradingCalendar, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("-trading-calendars: %v", err)
+	}
+	var files []calendarFile
+	if err := json.Unmarshal(data, &files); err != nil {
+		return nil, fmt.Errorf("-trading-calendars %s: %v", path, err)
+	}
+
+	list := make([]TradingCalendar, 0, len(files))
+	for _, file := range files {
+		source := fmt.Sprintf("-trading-calendars calendar %q", file.Name)
+		calendar := TradingCalendar{
+			Name:        file.Name,
+			Symbols:     file.Symbols,
+			Weekend:     []time.Weekday{time.Saturday, time.Sunday},
+			Holidays:    make(map[string]bool),
+			EarlyCloses: make(map[string]time.Duration),
+		}
+		if calendar.Location, err = time.LoadLocation(file.Timezone); err != nil {
+			return nil, fmt.Errorf("%s timezone %q: %v", source, file.Timezone, err)
+		}
+		if calendar.Sessions, err = parseSessions(source+" sessions", file.Sessions); err != nil {
+			return nil, err
+		}
+		if file.Name == "" || len(calendar.Sessions) == 0 {
+			return nil, fmt.Errorf("%s needs a name and sessions", source)
+		}
+
+		if file.Weekend != nil {
+			calendar.Weekend = nil
+			for _, name := range *file.Weekend {
+				day, ok := parseWeekday(name)
+				if !ok {
+					return nil, fmt.Errorf("%s weekend day %q must be a day of the week, e.g. saturday", source, name)
+				}
+				calendar.Weekend = append(calendar.Weekend, day)
+			}
+		}
+		for _, date := range file.Holidays {
+			if _, err := time.Parse(time.DateOnly, date); err != nil {
+				return nil, fmt.Errorf("%s holiday %q must be a date, e.g. 2026-12-25", source, date)
+			}
+			calendar.Holidays[date] = true
+		}
+		for date, clock := range file.EarlyCloses {
+			_, dateErr := time.Parse(time.DateOnly, date)
+			at, clockErr := time.Parse("15:04", clock)
+			if dateErr != nil || clockErr != nil {
+				return nil, fmt.Errorf("%s early close %q: %q must be a date and HH:MM time", source, date, clock)
+			}
+			calendar.EarlyCloses[date] = time.Duration(at.Hour())*time.Hour + time.Duration(at.Minute())*time.Minute
+		}
+		list = append(list, calendar)
+	}
+	return list, nil
+}
+
+// checkCalendars makes sure every symbol the calendars name is traded, no
+// symbol is on two calendars, and at most one calendar covers the rest
+func checkCalendars(symbols []string, list []TradingCalendar) error {
+	named := make(map[string]string)
+	defaults := 0
+	for _, calendar := range list {
+		if len(calendar.Symbols) == 0 {
+			defaults++
+		}
+		for _, symbol := range calendar.Symbols {
+			if !slices.Contains(symbols, symbol) {
+				return fmt.Errorf("calendar %q names %q, which is not in -symbols", calendar.Name, symbol)
+			}
+			if other, ok := named[symbol]; ok {
+				return fmt.Errorf("%q is on both the %q and %q calendars", symbol, other, calendar.Name)
+			}
+			named[symbol] = calendar.Name
+		}
+	}
+	if defaults > 1 {
+		return fmt.Errorf("only one calendar may leave out its symbols; -trading-schedule counts as one")
+	}
+	return nil
+}
+
+// parseWeekday reads a day of the week by its English name
+func parseWeekday(name string) (time.Weekday, bool) {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.EqualFold(day.String(), strings.TrimSpace(name)) {
+			return day, true
+		}
+	}
+	return 0, false
+}
+
+// getCalendarHandler lists the coming days of a symbol's trading calendar,
+// with the phase changes, holidays and early closes of each
+func getCalendarHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	m, ok := matcherFor(r.URL.Query().Get("symbol"))
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrCodeUnknownSymbol, "Unknown symbol",
+			"symbol '"+r.URL.Query().Get("symbol")+"' is not traded here")
+		return
+	}
+	days := 7
+	if raw := r.URL.Query().Get("days"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > maxCalendarDays {
+			writeError(w, http.StatusBadRequest, ErrCodeValidationFailed, "Validation failed",
+				[]string{fmt.Sprintf("days must be between 1 and %d (received: '%s')", maxCalendarDays, raw)})
+			return
+		}
+		days = parsed
+	}
+	calendar := calendarFor(m.symbol)
+	if calendar == nil {
+		writeError(w, http.StatusNotFound, ErrCodeNoCalendar, "No trading calendar",
+			"'"+m.symbol+"' trades continuously, with no trading calendar")
+		return
+	}
+
+	local := engineClock.Now().In(calendar.Location)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, calendar.Location)
+	response := CalendarResponse{Symbol: m.symbol, Calendar: calendar.Name, Timezone: calendar.Location.String(), Days: make([]CalendarDay, 0, days)}
+	for i := 0; i < days; i++ {
+		date := midnight.AddDate(0, 0, i)
+		key := date.Format(time.DateOnly)
+		_, early := calendar.EarlyCloses[key]
+		day := CalendarDay{
+			Date:    key,
+			Trading: !calendar.Holidays[key] && !slices.Contains(calendar.Weekend, date.Weekday()),
+			Holiday: calendar.Holidays[key],
+		}
+		day.EarlyClose = early && day.Trading
+		for _, scheduled := range calendar.plan(date) {
+			day.Phases = append(day.Phases, PhaseChange{Phase: scheduled.Phase, At: date.Add(scheduled.Offset)})
+		}
+		response.Days = append(response.Days, day)
+	}
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCalendars writes a -trading-calendars file and returns its path
+func writeCalendars(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "calendars.json")
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const testCalendars = `[
+	{"name": "equities", "symbols": ["AAPL"], "timezone": "America/New_York",
+	 "sessions": "04:00=pre_open,09:30=continuous,16:00=post_close,20:00=closed",
+	 "holidays": ["2026-11-26"], "early_closes": {"2026-11-27": "13:00"}},
+	{"name": "crypto", "symbols": ["BTC-USD"], "timezone": "UTC", "sessions": "00:00=continuous", "weekend": []}
+]`
+
+func TestTradingCalendar_WeekendsHolidaysAndEarlyCloses(t *testing.T) {
+	list, err := loadTradingCalendars(writeCalendars(t, testCalendars))
+	if err != nil || len(list) != 2 {
+		t.Fatalf("Unexpected calendars %+v (%v)", list, err)
+	}
+	equities := list[0]
+	newYork := equities.Location
+
+	// Thanksgiving is closed all day, and the day after closes early
+	phase, next := equities.at(time.Date(2026, 11, 26, 10, 0, 0, 0, newYork))
+	if phase.Phase != PhaseClosed || phase.reason != "holiday" || !next.At.Equal(time.Date(2026, 11, 27, 4, 0, 0, 0, newYork)) {
+		t.Errorf("Expected the holiday closed until the next pre-open, got %+v and %+v", phase, next)
+	}
+	phase, next = equities.at(time.Date(2026, 11, 27, 12, 0, 0, 0, newYork))
+	if phase.Phase != PhaseContinuous || next.Phase != PhasePostClose || !next.At.Equal(time.Date(2026, 11, 27, 13, 0, 0, 0, newYork)) {
+		t.Errorf("Expected continuous trading until the 13:00 early close, got %+v and %+v", phase, next)
+	}
+
+	// Saturday and Sunday are closed, so Friday's close lasts until Monday
+	phase, next = equities.at(time.Date(2026, 11, 28, 12, 0, 0, 0, newYork))
+	if phase.Phase != PhaseClosed || phase.reason != "weekend" || next.Phase != PhasePreOpen || !next.At.Equal(time.Date(2026, 11, 30, 4, 0, 0, 0, newYork)) {
+		t.Errorf("Expected the weekend closed until Monday's pre-open, got %+v and %+v", phase, next)
+	}
+
+	// A calendar with no weekend trades every day, and never changes phase
+	phase, next = list[1].at(time.Date(2026, 11, 28, 12, 0, 0, 0, time.UTC))
+	if phase.Phase != PhaseContinuous || !next.At.IsZero() {
+		t.Errorf("Expected crypto trading continuously on a Saturday, got %+v and %+v", phase, next)
+	}
+}
+
+func TestTradingCalendar_PerSymbolGroup(t *testing.T) {
+	setupTest()
+	resetSymbols([]string{"AAPL", "BTC-USD", "ETH-USD"})
+	useDeterministicEngine(t, time.Date(2026, 11, 26, 15, 0, 0, 0, time.UTC))
+	list, err := loadTradingCalendars(writeCalendars(t, testCalendars))
+	if err != nil {
+		t.Fatal(err)
+	}
+	configureCalendars(list)
+
+	aapl, _ := matcherFor("AAPL")
+	if refused := processOn(aapl, Order{ID: "holiday", Symbol: "AAPL", Side: SideBuy, Price: 100.0, Quantity: 1}); refused.RejectReason != ErrCodeMarketClosed {
+		t.Errorf("Expected AAPL orders refused on the holiday, got %+v", refused)
+	}
+	for _, symbol := range []string{"BTC-USD", "ETH-USD"} {
+		m, _ := matcherFor(symbol)
+		if accepted := processOn(m, Order{ID: symbol, Symbol: symbol, Side: SideBuy, Price: 100.0, Quantity: 1}); accepted.Status != OrderStatusPending {
+			t.Errorf("Expected %s orders taken on the AAPL holiday, got %+v", symbol, accepted)
+		}
+	}
+	if status := aapl.marketStatus(); status.Calendar != "equities" || status.Reason != "holiday" {
+		t.Errorf("Expected AAPL closed for the holiday by its calendar, got %+v", status)
+	}
+}
+
+func TestGetCalendar(t *testing.T) {
+	setupTest()
+	resetSymbols([]string{"AAPL", "ETH-USD"})
+	useDeterministicEngine(t, time.Date(2026, 11, 26, 15, 0, 0, 0, time.UTC))
+	list, err := loadTradingCalendars(writeCalendars(t, testCalendars))
+	if err != nil {
+		t.Fatal(err)
+	}
+	configureCalendars(list[:1])
+
+	result := serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/calendar?symbol=AAPL&days=3", nil))
+	if result.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", result.Code, result.Body.String())
+	}
+	var calendar CalendarResponse
+	json.NewDecoder(result.Body).Decode(&calendar)
+	if calendar.Calendar != "equities" || calendar.Timezone != "America/New_York" || len(calendar.Days) != 3 {
+		t.Fatalf("Unexpected calendar %+v", calendar)
+	}
+	holiday, early, weekend := calendar.Days[0], calendar.Days[1], calendar.Days[2]
+	if holiday.Date != "2026-11-26" || holiday.Trading || !holiday.Holiday || len(holiday.Phases) != 1 {
+		t.Errorf("Expected Thanksgiving a holiday, got %+v", holiday)
+	}
+	if !early.Trading || !early.EarlyClose || len(early.Phases) != 4 || early.Phases[2].Phase != PhasePostClose ||
+		!early.Phases[2].At.Equal(time.Date(2026, 11, 27, 18, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the day after to close at 13:00 New York time, got %+v", early)
+	}
+	if weekend.Trading || weekend.Holiday || weekend.Phases[0].Phase != PhaseClosed {
+		t.Errorf("Expected Saturday closed, got %+v", weekend)
+	}
+
+	for path, want := range map[string]int{
+		"/api/v1/calendar?symbol=ETH-USD":         http.StatusNotFound,
+		"/api/v1/calendar?symbol=AAPL&days=0":     http.StatusBadRequest,
+		"/api/v1/calendar?symbol=AAPL&days=367":   http.StatusBadRequest,
+		"/api/v1/calendar?symbol=DOGE-USD&days=1": http.StatusNotFound,
+	} {
+		if result := serve(HTTPConfig{}, httptest.NewRequest("GET", path, nil)); result.Code != want {
+			t.Errorf("Expected %d for %s, got %d: %s", want, path, result.Code, result.Body.String())
+		}
+	}
+}
+
+func TestLoadConfig_TradingCalendars(t *testing.T) {
+	path := writeCalendars(t, testCalendars)
+	cfg, err := loadConfig([]string{"-symbols", "AAPL,BTC-USD,ETH-USD", "-trading-calendars", path, "-trading-schedule", "09:00=continuous,17:00=closed"})
+	if err != nil || len(cfg.Calendars) != 3 || cfg.Calendars[2].Name != "default" {
+		t.Fatalf("Unexpected calendars %+v (%v)", cfg.Calendars, err)
+	}
+
+	for _, contents := range []string{
+		`{"name": "not a list"}`,
+		`[{"name": "no-sessions", "timezone": "UTC"}]`,
+		`[{"name": "bad-zone", "timezone": "Nowhere/Special", "sessions": "09:00=continuous"}]`,
+		`[{"name": "bad-holiday", "timezone": "UTC", "sessions": "09:00=continuous", "holidays": ["Dec 25"]}]`,
+		`[{"name": "bad-close", "timezone": "UTC", "sessions": "09:00=continuous", "early_closes": {"2026-12-24": "1pm"}}]`,
+		`[{"name": "bad-weekend", "timezone": "UTC", "sessions": "09:00=continuous", "weekend": ["someday"]}]`,
+		`[{"name": "unknown", "symbols": ["DOGE-USD"], "timezone": "UTC", "sessions": "09:00=continuous"}]`,
+		`[{"name": "a", "symbols": ["AAPL"], "timezone": "UTC", "sessions": "09:00=continuous"},
+		  {"name": "b", "symbols": ["AAPL"], "timezone": "UTC", "sessions": "09:00=continuous"}]`,
+		`[{"name": "everything", "timezone": "UTC", "sessions": "09:00=continuous"}]`,
+	} {
+		args := []string{"-symbols", "AAPL,BTC-USD", "-trading-calendars", writeCalendars(t, contents), "-trading-schedule", "09:00=continuous"}
+		if _, err := loadConfig(args); err == nil {
+			t.Errorf("Expected an error for %s", contents)
+		}
+	}
+	if _, err := loadConfig([]string{"-trading-calendars", filepath.Join(t.TempDir(), "missing.json")}); err == nil {
+		t.Error("Expected an error for a missing calendars file")
+	}
+}
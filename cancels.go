@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// CancelBatchRequest cancels every resting order and untriggered stop that
+// matches all of the criteria given. At least one is required.
+type CancelBatchRequest struct {
+	// OrderIDs limits the cancel to these orders
+	OrderIDs []string `json:"order_ids,omitempty"`
+	Symbol   string   `json:"symbol,omitempty"`
+	Owner    string   `json:"owner,omitempty"`
+	Side     Side     `json:"side,omitempty" validate:"omitempty,oneof=buy sell"`
+	// MinPrice and MaxPrice bound the limit price, inclusively; zero leaves
+	// that end open. Stops have no limit price, so a bound excludes them.
+	MinPrice float64 `json:"min_price,omitempty" validate:"min=0"`
+	MaxPrice float64 `json:"max_price,omitempty" validate:"min=0"`
+}
+
+// CancelBatchResponse carries the cancelled orders in their final state, and
+// the requested IDs that were not resting
+type CancelBatchResponse struct {
+	Orders   []Order  `json:"orders"`
+	Count    int      `json:"count"`
+	NotFound []string `json:"not_found,omitempty"`
+}
+
+// validate requires a criterion and an ordered price range
+func (req CancelBatchRequest) validate() []FieldError {
+	var errs []FieldError
+	if len(req.OrderIDs) == 0 && req.Symbol == "" && req.Owner == "" && req.Side == "" && req.MinPrice == 0 && req.MaxPrice == 0 {
+		errs = append(errs, fieldError("order_ids", "required", "give order_ids or at least one of symbol, owner, side, min_price and max_price"))
+	}
+	if req.MinPrice > 0 && req.MaxPrice > 0 && req.MinPrice > req.MaxPrice {
+		errs = append(errs, fieldError("min_price", "lte", "min_price cannot exceed max_price (received: %g > %g)", req.MinPrice, req.MaxPrice))
+	}
+	return errs
+}
+
+// matcher returns a test for whether a resting order or stop meets every criterion
+func (req CancelBatchRequest) matcher() func(Order) bool {
+	ids := make(map[string]bool, len(req.OrderIDs))
+	for _, id := range req.OrderIDs {
+		ids[id] = true
+	}
+	priced := req.MinPrice > 0 || req.MaxPrice > 0
+
+	return func(order Order) bool {
+		return (len(ids) == 0 || ids[order.ID]) &&
+			(req.Owner == "" || order.Owner == req.Owner) &&
+			(req.Side == "" || order.Side == req.Side) &&
+			!(priced && order.Type == OrderTypeTrailingStop) &&
+			(req.MinPrice == 0 || order.Price >= req.MinPrice) &&
+			(req.MaxPrice == 0 || order.Price <= req.MaxPrice)
+	}
+}
+
+// cancelBatch cancels the orders matching a batch request
+func cancelBatch(req CancelBatchRequest) []Order {
+	return cancelMatching(req.Symbol, req.matcher())
+}
+
+// cancelMatching cancels the matching orders on each symbol, or only on
+// symbol when one is given, in one matcher command per symbol, so nothing
+// trades against them partway through
+func cancelMatching(symbol string, matches func(Order) bool) []Order {
+	var cancelled []Order
+	for _, m := range allMatchers() {
+		if symbol != "" && m.symbol != symbol {
+			continue
+		}
+
+		m.do(func() {
+			expireOrders(m.book, engineClock.Now())
+			var ids []string
+			for _, orders := range [][]Order{m.book.bids.orders(), m.book.asks.orders(), m.book.stops, m.book.paper} {
+				for _, order := range orders {
+					if matches(order) {
+						ids = append(ids, order.ID)
+					}
+				}
+			}
+			for _, id := range ids {
+				if order, ok := cancelOrder(m.symbol, id); ok {
+					cancelled = append(cancelled, order)
+				}
+			}
+		})
+	}
+	return cancelled
+}
+
+// cancelBatchHandler cancels a list of orders, or every order matching a filter
+func cancelBatchHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req CancelBatchRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if req.Symbol != "" {
+		if _, ok := matcherFor(req.Symbol); !ok {
+			writeError(w, http.StatusBadRequest, ErrCodeUnknownSymbol, "Unknown symbol",
+				"symbol '"+req.Symbol+"' is not traded here")
+			return
+		}
+	}
+
+	// A batch for one owner costs that owner a single cancel
+	if !throttleAllows(req.Owner, ThrottleCancel) {
+		writeThrottled(w, req.Owner, ThrottleCancel)
+		return
+	}
+
+	orders := cancelBatch(req)
+	response := CancelBatchResponse{Orders: orders, Count: len(orders)}
+	if response.Orders == nil {
+		response.Orders = []Order{}
+	}
+	cancelled := make(map[string]bool, len(orders))
+	for _, order := range orders {
+		cancelled[order.ID] = true
+	}
+	for _, id := range req.OrderIDs {
+		if !cancelled[id] {
+			response.NotFound = append(response.NotFound, id)
+		}
+	}
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// postCancelBatch sends a batch cancel request
+func postCancelBatch(body string) *httptest.ResponseRecorder {
+	return serve(HTTPConfig{}, httptest.NewRequest("POST", "/api/v1/orders/cancel-batch", bytes.NewBufferString(body)))
+}
+
+func TestCancelBatch_ByOrderIDs(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "bid-1", Side: SideBuy, Price: 99.0, Quantity: 1, Owner: "maker"})
+	placeOn(m, Order{ID: "bid-2", Side: SideBuy, Price: 98.0, Quantity: 1, Owner: "maker"})
+	placeOn(m, Order{ID: "ask-1", Side: SideSell, Price: 101.0, Quantity: 1, Owner: "maker"})
+
+	response := postCancelBatch(`{"order_ids": ["bid-1", "ask-1", "missing"]}`)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", response.Code, response.Body.String())
+	}
+	var result CancelBatchResponse
+	json.NewDecoder(response.Body).Decode(&result)
+	if result.Count != 2 || result.Orders[0].Status != OrderStatusCancelled {
+		t.Errorf("Expected two cancelled orders, got %+v", result)
+	}
+	if len(result.NotFound) != 1 || result.NotFound[0] != "missing" {
+		t.Errorf("Expected the unknown ID to be reported, got %+v", result.NotFound)
+	}
+	if resting := collectOrders(""); len(resting) != 1 || resting[0].ID != "bid-2" {
+		t.Errorf("Expected only bid-2 to rest, got %+v", resting)
+	}
+}
+
+func TestCancelBatch_ByFilter(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "bid-1", Side: SideBuy, Price: 99.0, Quantity: 1, Owner: "maker"})
+	placeOn(m, Order{ID: "bid-2", Side: SideBuy, Price: 97.0, Quantity: 1, Owner: "maker"})
+	placeOn(m, Order{ID: "bid-3", Side: SideBuy, Price: 99.0, Quantity: 1, Owner: "other"})
+	placeOn(m, Order{ID: "ask-1", Side: SideSell, Price: 101.0, Quantity: 1, Owner: "maker"})
+
+	// Pull the maker's bids at 98 and above, leaving the deeper bid and everyone else's
+	response := postCancelBatch(`{"owner": "maker", "side": "buy", "min_price": 98}`)
+	var result CancelBatchResponse
+	json.NewDecoder(response.Body).Decode(&result)
+	if response.Code != http.StatusOK || result.Count != 1 || result.Orders[0].ID != "bid-1" {
+		t.Fatalf("Expected bid-1 to be cancelled, got %d %+v", response.Code, result)
+	}
+	if resting := collectOrders(""); len(resting) != 3 {
+		t.Errorf("Expected three orders to rest, got %+v", resting)
+	}
+
+	response = postCancelBatch(`{"owner": "nobody"}`)
+	json.NewDecoder(response.Body).Decode(&result)
+	if response.Code != http.StatusOK || result.Count != 0 || result.Orders == nil {
+		t.Errorf("Expected an empty list, got %d %+v", response.Code, result)
+	}
+}
+
+func TestCancelBatch_RejectsBadRequests(t *testing.T) {
+	setupTest()
+	cases := []struct {
+		body string
+		code ErrorCode
+	}{
+		{`{}`, ErrCodeValidationFailed},
+		{`{"min_price": 101, "max_price": 99}`, ErrCodeValidationFailed},
+		{`{"side": "both"}`, ErrCodeValidationFailed},
+		{`{"symbol": "DOGE-USD"}`, ErrCodeUnknownSymbol},
+	}
+	for _, tc := range cases {
+		response := postCancelBatch(tc.body)
+		if result := decodeError(t, response); response.Code != http.StatusBadRequest || result.Error.Code != tc.code {
+			t.Errorf("%s: expected 400 %s, got %d %s", tc.body, tc.code, response.Code, result.Error.Code)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultCandleInterval is the candle width when none is asked for
+const defaultCandleInterval = time.Minute
+
+// Candle summarizes a symbol's trades over one interval. Intervals without
+// trades have no candle.
+type Candle struct {
+	Symbol   string    `json:"symbol"`
+	Start    time.Time `json:"start"`
+	Open     float64   `json:"open"`
+	High     float64   `json:"high"`
+	Low      float64   `json:"low"`
+	Close    float64   `json:"close"`
+	Volume   int       `json:"volume"`
+	Trades   int       `json:"trades"`
+	Notional float64   `json:"notional"`
+}
+
+// CandlesResponse lists a symbol's candles, oldest first
+type CandlesResponse struct {
+	Symbol   string   `json:"symbol"`
+	Interval string   `json:"interval"`
+	Candles  []Candle `json:"candles"`
+	Count    int      `json:"count"`
+}
+
+// buildCandles buckets trades, which are in the order they happened, into
+// candles of the given interval. A limit above zero keeps the latest candles.
+func buildCandles(symbol string, trades []Trade, interval time.Duration, limit int) []Candle {
+	candles := make([]Candle, 0)
+	for _, trade := range trades {
+		candles = appendCandle(candles, symbol, trade, interval)
+	}
+	if limit > 0 && len(candles) > limit {
+		candles = candles[len(candles)-limit:]
+	}
+	return candles
+}
+
+// appendCandle adds a trade to the last candle, or starts a new one when the
+// trade is in a later interval
+func appendCandle(candles []Candle, symbol string, trade Trade, interval time.Duration) []Candle {
+	start := trade.CreatedAt.Truncate(interval)
+	if n := len(candles); n > 0 && candles[n-1].Start.Equal(start) {
+		candle := &candles[n-1]
+		candle.High = math.Max(candle.High, trade.Price)
+		candle.Low = math.Min(candle.Low, trade.Price)
+		candle.Close = trade.Price
+		candle.Volume += trade.Quantity
+		candle.Trades++
+		candle.Notional += tradeNotional(trade)
+		return candles
+	}
+	return append(candles, Candle{
+		Symbol:   symbol,
+		Start:    start,
+		Open:     trade.Price,
+		High:     trade.Price,
+		Low:      trade.Price,
+		Close:    trade.Price,
+		Volume:   trade.Quantity,
+		Trades:   1,
+		Notional: tradeNotional(trade),
+	})
+}
+
+// mergeCandles combines candles, in time order, into candles of a wider
+// interval that is a multiple of theirs. A limit above zero keeps the latest.
+func mergeCandles(narrow []Candle, interval time.Duration, limit int) []Candle {
+	candles := make([]Candle, 0)
+	for _, c := range narrow {
+		start := c.Start.Truncate(interval)
+		if n := len(candles); n > 0 && candles[n-1].Start.Equal(start) {
+			candle := &candles[n-1]
+			candle.High = math.Max(candle.High, c.High)
+			candle.Low = math.Min(candle.Low, c.Low)
+			candle.Close = c.Close
+			candle.Volume += c.Volume
+			candle.Trades += c.Trades
+			candle.Notional += c.Notional
+			continue
+		}
+		c.Start = start
+		candles = append(candles, c)
+	}
+	if limit > 0 && len(candles) > limit {
+		candles = candles[len(candles)-limit:]
+	}
+	return candles
+}
+
+// parseCandleQuery reads the interval and limit of a candles request,
+// returning the problems with them
+func parseCandleQuery(rawInterval, rawLimit string) (time.Duration, int, []string) {
+	var problems []string
+	interval := defaultCandleInterval
+	if rawInterval != "" {
+		parsed, err := time.ParseDuration(rawInterval)
+		if err != nil || parsed < time.Second {
+			problems = append(problems, "interval must be a duration of at least 1s such as '5m' (received: '"+rawInterval+"')")
+		}
+		interval = parsed
+	}
+
+	limit := 0
+	if rawLimit != "" {
+		parsed, err := strconv.Atoi(rawLimit)
+		if err != nil || parsed < 0 {
+			problems = append(problems, "limit must be a non-negative integer (received: '"+rawLimit+"')")
+		}
+		limit = parsed
+	}
+	return interval, limit, problems
+}
+
+// getCandlesHandler returns a symbol's trades as open, high, low and close
+// candles
+func getCandlesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	m, ok := matcherFor(r.URL.Query().Get("symbol"))
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrCodeUnknownSymbol, "Unknown symbol",
+			"symbol '"+r.URL.Query().Get("symbol")+"' is not traded here")
+		return
+	}
+	interval, limit, problems := parseCandleQuery(r.URL.Query().Get("interval"), r.URL.Query().Get("limit"))
+	if len(problems) > 0 {
+		writeError(w, http.StatusBadRequest, ErrCodeValidationFailed, "Validation failed", problems)
+		return
+	}
+
+	var candles []Candle
+	if readModel != nil {
+		if err := readModel.sync(r.Context()); err != nil {
+			writeRequestTimeout(w, err)
+			return
+		}
+		candles = readModel.candles(m.symbol, interval, limit)
+	} else {
+		candles = buildCandles(m.symbol, tradeStore.List(m.symbol), interval, limit)
+	}
+	json.NewEncoder(w).Encode(CandlesResponse{
+		Symbol:   m.symbol,
+		Interval: interval.String(),
+		Candles:  candles,
+		Count:    len(candles),
+	})
+}
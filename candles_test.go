@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBuildCandles(t *testing.T) {
+	start := time.Date(2024, 1, 1, 9, 30, 0, 0, time.UTC)
+	trades := []Trade{
+		{Price: 100.0, Quantity: 2, CreatedAt: start.Add(5 * time.Second)},
+		{Price: 102.0, Quantity: 1, CreatedAt: start.Add(20 * time.Second)},
+		{Price: 99.0, Quantity: 3, CreatedAt: start.Add(40 * time.Second)},
+		{Price: 101.0, Quantity: 1, CreatedAt: start.Add(3 * time.Minute)},
+	}
+
+	candles := buildCandles("BTC-USD", trades, time.Minute, 0)
+	want := []Candle{
+		{Symbol: "BTC-USD", Start: start, Open: 100.0, High: 102.0, Low: 99.0, Close: 99.0, Volume: 6, Trades: 3, Notional: 599.0},
+		{Symbol: "BTC-USD", Start: start.Add(3 * time.Minute), Open: 101.0, High: 101.0, Low: 101.0, Close: 101.0, Volume: 1, Trades: 1, Notional: 101.0},
+	}
+	if len(candles) != len(want) {
+		t.Fatalf("Expected %d candles, got %+v", len(want), candles)
+	}
+	for i := range want {
+		if candles[i] != want[i] {
+			t.Errorf("Candle %d: expected %+v, got %+v", i, want[i], candles[i])
+		}
+	}
+
+	if latest := buildCandles("BTC-USD", trades, time.Minute, 1); len(latest) != 1 || latest[0].Open != 101.0 {
+		t.Errorf("Expected only the latest candle, got %+v", latest)
+	}
+}
+
+func TestGetCandlesHandler(t *testing.T) {
+	setupTest()
+	clock := useDeterministicEngine(t, time.Date(2024, 1, 1, 9, 30, 0, 0, time.UTC))
+	m, _ := matcherFor("")
+	for _, price := range []float64{100.0, 101.0} {
+		placeOn(m, Order{ID: generateOrderID(), Symbol: "DEFAULT", Side: SideSell, Price: price, Quantity: 1})
+		placeOn(m, Order{ID: generateOrderID(), Symbol: "DEFAULT", Side: SideBuy, Price: price, Quantity: 1})
+		clock.Advance(5 * time.Minute)
+	}
+
+	var response CandlesResponse
+	json.NewDecoder(serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/candles?interval=5m", nil)).Body).Decode(&response)
+	if response.Symbol != "DEFAULT" || response.Interval != "5m0s" || response.Count != 2 || response.Candles[1].Close != 101.0 {
+		t.Errorf("Unexpected candles %+v", response)
+	}
+
+	for _, query := range []string{"interval=1ms", "interval=soon", "limit=-1"} {
+		if result := serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/candles?"+query, nil)); result.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, result.Code)
+		}
+	}
+}
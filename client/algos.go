@@ -0,0 +1,68 @@
+package client
+
+import (
+	"context"
+	"net/url"
+	"time"
+)
+
+// AlgoRequest starts a parent order that the server slices into child IOC
+// limit orders over Horizon. Schedule is "vwap" (the default) or "twap".
+type AlgoRequest struct {
+	Symbol        string    `json:"symbol,omitempty"`
+	Side          Side      `json:"side"`
+	Quantity      int       `json:"quantity"`
+	LimitPrice    float64   `json:"limit_price"`
+	Horizon       string    `json:"horizon"`
+	Slices        int       `json:"slices,omitempty"`
+	Schedule      string    `json:"schedule,omitempty"`
+	Owner         string    `json:"owner,omitempty"`
+	VolumeProfile []float64 `json:"volume_profile,omitempty"`
+}
+
+// AlgoOrder is a parent order and its aggregate fill progress
+type AlgoOrder struct {
+	ID           string    `json:"id"`
+	Symbol       string    `json:"symbol"`
+	Side         Side      `json:"side"`
+	Quantity     int       `json:"quantity"`
+	LimitPrice   float64   `json:"limit_price"`
+	Schedule     string    `json:"schedule"`
+	Owner        string    `json:"owner,omitempty"`
+	Status       string    `json:"status"`
+	Slices       int       `json:"slices"`
+	SlicesSent   int       `json:"slices_sent"`
+	Filled       int       `json:"filled"`
+	AveragePrice float64   `json:"average_price,omitempty"`
+	Plan         []int     `json:"plan"`
+	ChildIDs     []string  `json:"child_ids"`
+	StartedAt    time.Time `json:"started_at"`
+	EndsAt       time.Time `json:"ends_at"`
+}
+
+// StartAlgo starts a VWAP or TWAP parent order
+func (c *Client) StartAlgo(ctx context.Context, req AlgoRequest) (*AlgoOrder, error) {
+	var order AlgoOrder
+	if err := c.do(ctx, "POST", "/api/v1/algos", nil, req, &order); err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// Algo returns the progress of one parent order
+func (c *Client) Algo(ctx context.Context, id string) (*AlgoOrder, error) {
+	var order AlgoOrder
+	if err := c.do(ctx, "GET", "/api/v1/algos/"+url.PathEscape(id), nil, nil, &order); err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// CancelAlgo stops a parent order from sending further slices
+func (c *Client) CancelAlgo(ctx context.Context, id string) (*AlgoOrder, error) {
+	var order AlgoOrder
+	if err := c.do(ctx, "DELETE", "/api/v1/algos/"+url.PathEscape(id), nil, nil, &order); err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
@@ -0,0 +1,138 @@
+package client
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// PriceAnalytics are the mid price, microprice and fair value computed from a
+// symbol's depth. The prices are zero while either side of the book is empty.
+type PriceAnalytics struct {
+	Symbol     string  `json:"symbol"`
+	Sequence   int64   `json:"sequence"`
+	BestBid    float64 `json:"best_bid,omitempty"`
+	BestAsk    float64 `json:"best_ask,omitempty"`
+	Mid        float64 `json:"mid,omitempty"`
+	Microprice float64 `json:"microprice,omitempty"`
+	Imbalance  float64 `json:"imbalance"`
+	FairValue  float64 `json:"fair_value,omitempty"`
+}
+
+// PriceAnalytics fetches the current price analytics for symbol; an empty
+// symbol means the server's default
+func (c *Client) PriceAnalytics(ctx context.Context, symbol string) (*PriceAnalytics, error) {
+	query := url.Values{}
+	if symbol != "" {
+		query.Set("symbol", symbol)
+	}
+
+	var prices PriceAnalytics
+	if err := c.do(ctx, "GET", "/api/v1/analytics/price", query, nil, &prices); err != nil {
+		return nil, err
+	}
+	return &prices, nil
+}
+
+// SideLiquidity describes the resting liquidity on one side of a book
+type SideLiquidity struct {
+	Quantity             int     `json:"quantity"`
+	Orders               int     `json:"orders"`
+	Levels               int     `json:"levels"`
+	AverageLevelQuantity float64 `json:"avg_level_quantity"`
+	AverageLevelOrders   float64 `json:"avg_level_orders"`
+	DepthNearMid         int     `json:"depth_near_mid"`
+}
+
+// LiquidityAnalytics are the volume imbalance, depth near the mid and average
+// queue sizes of a symbol's book
+type LiquidityAnalytics struct {
+	Symbol          string        `json:"symbol"`
+	Sequence        int64         `json:"sequence"`
+	Mid             float64       `json:"mid,omitempty"`
+	Ticks           int           `json:"ticks"`
+	TickSize        float64       `json:"tick_size"`
+	VolumeImbalance float64       `json:"volume_imbalance"`
+	Bid             SideLiquidity `json:"bid"`
+	Ask             SideLiquidity `json:"ask"`
+}
+
+// LiquidityAnalytics fetches liquidity analytics for symbol, measuring depth
+// within ticks of the mid. Zero ticks or tickSize use the server defaults.
+func (c *Client) LiquidityAnalytics(ctx context.Context, symbol string, ticks int, tickSize float64) (*LiquidityAnalytics, error) {
+	query := url.Values{}
+	if symbol != "" {
+		query.Set("symbol", symbol)
+	}
+	if ticks > 0 {
+		query.Set("ticks", strconv.Itoa(ticks))
+	}
+	if tickSize > 0 {
+		query.Set("tick_size", strconv.FormatFloat(tickSize, 'f', -1, 64))
+	}
+
+	var analytics LiquidityAnalytics
+	if err := c.do(ctx, "GET", "/api/v1/analytics/liquidity", query, nil, &analytics); err != nil {
+		return nil, err
+	}
+	return &analytics, nil
+}
+
+// OrderLatency is how long one order spent inside the engine. Queue runs from
+// the server receiving the order to its matcher picking it up, and Match from
+// then to the end of its arrival matching event.
+type OrderLatency struct {
+	OrderID string        `json:"order_id"`
+	Queue   time.Duration `json:"queue_ns"`
+	Match   time.Duration `json:"match_ns"`
+	Total   time.Duration `json:"total_ns"`
+}
+
+// LatencyPercentiles summarises one stage of the engine's latency
+type LatencyPercentiles struct {
+	P50  time.Duration `json:"p50_ns"`
+	P90  time.Duration `json:"p90_ns"`
+	P99  time.Duration `json:"p99_ns"`
+	P999 time.Duration `json:"p999_ns"`
+	Max  time.Duration `json:"max_ns"`
+}
+
+// LatencyAnalytics are the engine's latency percentiles over a symbol's most
+// recent orders
+type LatencyAnalytics struct {
+	Symbol string             `json:"symbol"`
+	Count  int                `json:"count"`
+	Queue  LatencyPercentiles `json:"queue"`
+	Match  LatencyPercentiles `json:"match"`
+	Total  LatencyPercentiles `json:"total"`
+}
+
+// LatencyAnalytics fetches the engine's latency percentiles for symbol; an
+// empty symbol means the server's default
+func (c *Client) LatencyAnalytics(ctx context.Context, symbol string) (*LatencyAnalytics, error) {
+	query := url.Values{}
+	if symbol != "" {
+		query.Set("symbol", symbol)
+	}
+
+	var analytics LatencyAnalytics
+	if err := c.do(ctx, "GET", "/api/v1/analytics/latency", query, nil, &analytics); err != nil {
+		return nil, err
+	}
+	return &analytics, nil
+}
+
+// OrderLatency fetches how long one recent order spent inside the engine
+func (c *Client) OrderLatency(ctx context.Context, symbol, orderID string) (*OrderLatency, error) {
+	query := url.Values{"order_id": {orderID}}
+	if symbol != "" {
+		query.Set("symbol", symbol)
+	}
+
+	var latency OrderLatency
+	if err := c.do(ctx, "GET", "/api/v1/analytics/latency", query, nil, &latency); err != nil {
+		return nil, err
+	}
+	return &latency, nil
+}
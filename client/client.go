@@ -0,0 +1,417 @@
+// Package client is a Go SDK for the order book REST API
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+type Side string
+
+const (
+	SideBuy  Side = "buy"
+	SideSell Side = "sell"
+)
+
+// Order mirrors the server's order representation
+type Order struct {
+	ID              string           `json:"id"`
+	Symbol          string           `json:"symbol,omitempty"`
+	Side            Side             `json:"side"`
+	Quantity        int              `json:"quantity"`
+	FilledQuantity  int              `json:"filled_quantity,omitempty"`
+	QuoteQuantity   float64          `json:"quote_quantity,omitempty"`
+	ArrivalSequence int64            `json:"arrival_sequence"`
+	Price           float64          `json:"price"`
+	Status          string           `json:"status"`
+	CreatedAt       time.Time        `json:"created_at"`
+	ExpiresAt       *time.Time       `json:"expires_at,omitempty"`
+	Owner           string           `json:"owner,omitempty"`
+	RejectReason    string           `json:"reject_reason,omitempty"`
+	Type            string           `json:"type,omitempty"`
+	TrailAmount     float64          `json:"trail_amount,omitempty"`
+	TrailPercent    float64          `json:"trail_percent,omitempty"`
+	LimitOffset     *float64         `json:"limit_offset,omitempty"`
+	TriggerPrice    float64          `json:"trigger_price,omitempty"`
+	TriggerBy       string           `json:"trigger_by,omitempty"`
+	TimeInForce     string           `json:"time_in_force,omitempty"`
+	MinQuantity     int              `json:"min_quantity,omitempty"`
+	AllOrNone       bool             `json:"all_or_none,omitempty"`
+	Peg             string           `json:"peg,omitempty"`
+	PegOffset       float64          `json:"peg_offset,omitempty"`
+	Timestamps      *OrderTimestamps `json:"timestamps,omitempty"`
+}
+
+// OrderTimestamps record when the server received an order, when its matcher
+// picked it up and when its arrival matching event ended
+type OrderTimestamps struct {
+	ReceivedAt time.Time `json:"received_at"`
+	AcceptedAt time.Time `json:"accepted_at"`
+	MatchedAt  time.Time `json:"matched_at"`
+}
+
+// Trade mirrors the server's trade representation
+type Trade struct {
+	ID        string    `json:"id"`
+	Symbol    string    `json:"symbol,omitempty"`
+	MakerID   string    `json:"maker_id"`
+	TakerID   string    `json:"taker_id"`
+	Price     float64   `json:"price"`
+	Quantity  int       `json:"quantity"`
+	Venue     string    `json:"venue,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// AggressorSide is the taker's side, and TickDirection is "uptick",
+	// "downtick" or "zero" against the previous local trade
+	AggressorSide Side   `json:"aggressor_side"`
+	TickDirection string `json:"tick_direction,omitempty"`
+}
+
+// OrderBook holds the resting orders on each side
+type OrderBook struct {
+	BuyOrders  []Order `json:"buy_orders"`
+	SellOrders []Order `json:"sell_orders"`
+}
+
+// QueuePosition is where a resting order waits within its price level
+type QueuePosition struct {
+	OrderID  string  `json:"order_id"`
+	Symbol   string  `json:"symbol"`
+	Side     Side    `json:"side"`
+	Price    float64 `json:"price"`
+	Quantity int     `json:"quantity"`
+	// Position is 1 for the order that fills next at its price
+	Position      int `json:"position"`
+	OrdersAhead   int `json:"orders_ahead"`
+	QuantityAhead int `json:"quantity_ahead"`
+	LevelOrders   int `json:"level_orders"`
+	LevelQuantity int `json:"level_quantity"`
+}
+
+// OrderEvent is a single order status transition
+type OrderEvent struct {
+	Sequence  int       `json:"sequence"`
+	OrderID   string    `json:"order_id"`
+	From      string    `json:"from,omitempty"`
+	To        string    `json:"to"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Order types accepted by PlaceOrderRequest.Type
+const (
+	OrderTypeLimit        = "limit"
+	OrderTypeMarket       = "market"
+	OrderTypeTrailingStop = "trailing_stop"
+	OrderTypePegged       = "pegged"
+)
+
+// Peg references accepted by PlaceOrderRequest.Peg
+const (
+	PegPrimary  = "primary"
+	PegMidpoint = "midpoint"
+	PegMarket   = "market"
+)
+
+// PlaceOrderRequest is the body sent when placing an order. Trailing stops
+// leave Price at zero and set one of TrailAmount or TrailPercent. Pegged
+// orders leave Price at zero and set Peg.
+type PlaceOrderRequest struct {
+	// Symbol is the book the order trades on; empty means the server's default
+	Symbol    string     `json:"symbol,omitempty"`
+	Side      Side       `json:"side"`
+	Price     float64    `json:"price"`
+	Quantity  int        `json:"quantity"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Owner     string     `json:"owner,omitempty"`
+	// QuoteQuantity is what a market buy spends, in the quote asset
+	QuoteQuantity float64 `json:"quote_quantity,omitempty"`
+
+	Type         string   `json:"type,omitempty"`
+	TrailAmount  float64  `json:"trail_amount,omitempty"`
+	TrailPercent float64  `json:"trail_percent,omitempty"`
+	LimitOffset  *float64 `json:"limit_offset,omitempty"`
+	// TriggerBy is "trade" (the default) or "mark"
+	TriggerBy string `json:"trigger_by,omitempty"`
+
+	// TimeInForce is "GTC" (the default) or "IOC"
+	TimeInForce string `json:"time_in_force,omitempty"`
+	MinQuantity int    `json:"min_quantity,omitempty"`
+	AllOrNone   bool   `json:"all_or_none,omitempty"`
+
+	Peg       string  `json:"peg,omitempty"`
+	PegOffset float64 `json:"peg_offset,omitempty"`
+}
+
+// PlaceOrderResponse is returned after an order is processed
+type PlaceOrderResponse struct {
+	OrderID string        `json:"order_id"`
+	Status  string        `json:"status"`
+	Trades  []Trade       `json:"trades,omitempty"`
+	Latency *OrderLatency `json:"latency,omitempty"`
+}
+
+// Error is returned for any non-2xx response and carries the server's reason code
+type Error struct {
+	StatusCode int
+	Code       string      `json:"code"`
+	Message    string      `json:"message"`
+	Details    interface{} `json:"details,omitempty"`
+	OrderID    string      `json:"-"`
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("order book API returned status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Client talks to a running order book server
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	// APIKey is sent as a bearer token when the server requires one
+	APIKey string
+	// APISecret, when set, signs every request that is not a GET, as the
+	// server requires of keys it has a secret for
+	APISecret string
+}
+
+// New creates a client for the server at baseURL, e.g. "http://localhost:8080"
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    baseURL,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// PlaceOrder submits a new order
+func (c *Client) PlaceOrder(ctx context.Context, req PlaceOrderRequest) (*PlaceOrderResponse, error) {
+	var resp PlaceOrderResponse
+	if err := c.do(ctx, "POST", "/api/v1/orders", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CancelOrder cancels an order resting on symbol, or on whichever symbol has
+// it if symbol is empty, and returns it in its final state
+func (c *Client) CancelOrder(ctx context.Context, symbol, orderID string) (*Order, error) {
+	var resp struct {
+		Order Order `json:"order"`
+	}
+	if err := c.do(ctx, "DELETE", "/api/v1/orders/"+url.PathEscape(orderID), symbolQuery(symbol), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Order, nil
+}
+
+// Orders returns every order resting on symbol, or on every symbol if it is
+// empty
+func (c *Client) Orders(ctx context.Context, symbol string) ([]Order, error) {
+	var resp struct {
+		Orders []Order `json:"orders"`
+	}
+	if err := c.do(ctx, "GET", "/api/v1/orders", symbolQuery(symbol), nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Orders, nil
+}
+
+// Order returns one resting order
+func (c *Client) Order(ctx context.Context, orderID string) (*Order, error) {
+	var order Order
+	if err := c.do(ctx, "GET", "/api/v1/orders/"+url.PathEscape(orderID), nil, nil, &order); err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// QueuePosition returns how much rests ahead of an order at its price
+func (c *Client) QueuePosition(ctx context.Context, orderID string) (*QueuePosition, error) {
+	var position QueuePosition
+	if err := c.do(ctx, "GET", "/api/v1/orders/"+url.PathEscape(orderID)+"/queue-position", nil, nil, &position); err != nil {
+		return nil, err
+	}
+	return &position, nil
+}
+
+// Trades returns every trade executed on symbol, or on every symbol if it is
+// empty
+func (c *Client) Trades(ctx context.Context, symbol string) ([]Trade, error) {
+	var resp struct {
+		Trades []Trade `json:"trades"`
+	}
+	if err := c.do(ctx, "GET", "/api/v1/trades", symbolQuery(symbol), nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Trades, nil
+}
+
+// Trade returns one trade the server still holds in memory
+func (c *Client) Trade(ctx context.Context, tradeID string) (*Trade, error) {
+	var trade Trade
+	if err := c.do(ctx, "GET", "/api/v1/trades/"+url.PathEscape(tradeID), nil, nil, &trade); err != nil {
+		return nil, err
+	}
+	return &trade, nil
+}
+
+// OrderBook returns both sides of symbol's book in priority order. An empty
+// symbol means the server's default.
+func (c *Client) OrderBook(ctx context.Context, symbol string) (*OrderBook, error) {
+	var resp struct {
+		OrderBook OrderBook `json:"orderbook"`
+	}
+	if err := c.do(ctx, "GET", "/api/v1/orderbook", symbolQuery(symbol), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.OrderBook, nil
+}
+
+// OrderEvents returns the status-change log, optionally for a single order
+func (c *Client) OrderEvents(ctx context.Context, orderID string) ([]OrderEvent, error) {
+	path := "/api/v1/order-events"
+	if orderID != "" {
+		path = "/api/v1/orders/" + url.PathEscape(orderID) + "/events"
+	}
+
+	var resp struct {
+		Events []OrderEvent `json:"events"`
+	}
+	if err := c.do(ctx, "GET", path, nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Events, nil
+}
+
+// symbolQuery names symbol in a request's query, or nothing if it is empty
+func symbolQuery(symbol string) url.Values {
+	if symbol == "" {
+		return nil
+	}
+	return url.Values{"symbol": {symbol}}
+}
+
+// do sends a request and decodes either the success body or the error envelope
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	endpoint := c.BaseURL + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	var data []byte
+	var reader io.Reader
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	c.authorize(req.Header)
+	if c.APISecret != "" && method != "GET" {
+		if err := c.sign(req, data); err != nil {
+			return err
+		}
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &Error{StatusCode: resp.StatusCode}
+		var envelope struct {
+			Error   *Error `json:"error"`
+			OrderID string `json:"order_id"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&envelope) == nil && envelope.Error != nil {
+			apiErr = envelope.Error
+			apiErr.StatusCode = resp.StatusCode
+			apiErr.OrderID = envelope.OrderID
+		}
+		return apiErr
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// PriceLevel is the total resting quantity at a single price
+type PriceLevel struct {
+	Price    float64 `json:"price"`
+	Quantity int     `json:"quantity"`
+	Orders   int     `json:"orders"`
+}
+
+// AggregateDepth collapses each side of the book into at most levels price levels.
+// A levels value of zero or less keeps every level.
+func AggregateDepth(book *OrderBook, levels int) (bids, asks []PriceLevel) {
+	return aggregateSide(book.BuyOrders, levels), aggregateSide(book.SellOrders, levels)
+}
+
+// aggregateSide groups orders that are already in priority order by price
+func aggregateSide(orders []Order, levels int) []PriceLevel {
+	var result []PriceLevel
+	for _, order := range orders {
+		if n := len(result); n > 0 && result[n-1].Price == order.Price {
+			result[n-1].Quantity += order.Quantity
+			result[n-1].Orders++
+			continue
+		}
+		if levels > 0 && len(result) == levels {
+			break
+		}
+		result = append(result, PriceLevel{Price: order.Price, Quantity: order.Quantity, Orders: 1})
+	}
+	return result
+}
+
+// sign adds the timestamp, a fresh nonce and the HMAC-SHA256 of both, the
+// method, path and body under the API secret to a request's headers
+func (c *Client) sign(req *http.Request, body []byte) error {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return err
+	}
+	timestamp, nonce := strconv.FormatInt(time.Now().UnixMilli(), 10), hex.EncodeToString(random)
+
+	mac := hmac.New(sha256.New, []byte(c.APISecret))
+	mac.Write([]byte(timestamp + "\n" + nonce + "\n" + req.Method + "\n" + req.URL.RequestURI() + "\n"))
+	mac.Write(body)
+	req.Header.Set("X-Signature-Timestamp", timestamp)
+	req.Header.Set("X-Signature-Nonce", nonce)
+	req.Header.Set("X-Signature", hex.EncodeToString(mac.Sum(nil)))
+	return nil
+}
+
+// authorize adds the API key, if any, to a request's headers
+func (c *Client) authorize(header http.Header) {
+	if c.APIKey != "" {
+		header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+}
@@ -0,0 +1,298 @@
+package client
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPlaceOrder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/api/v1/orders" {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		}
+
+		var req PlaceOrderRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Symbol != "BTC-USD" || req.Side != SideBuy || req.Price != 100.0 || req.Quantity != 10 {
+			t.Errorf("Unexpected request body %+v", req)
+		}
+
+		json.NewEncoder(w).Encode(PlaceOrderResponse{OrderID: "order-1", Status: "pending"})
+	}))
+	defer server.Close()
+
+	resp, err := New(server.URL).PlaceOrder(context.Background(), PlaceOrderRequest{Symbol: "BTC-USD", Side: SideBuy, Price: 100.0, Quantity: 10})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if resp.OrderID != "order-1" || resp.Status != "pending" {
+		t.Errorf("Unexpected response %+v", resp)
+	}
+}
+
+func TestAPIKey(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("Expected the API key as a bearer token, got %q", got)
+		}
+		w.Write([]byte(`{"orders": [], "count": 0}`))
+	}))
+	defer server.Close()
+
+	c := New(server.URL)
+	c.APIKey = "secret"
+	if _, err := c.Orders(context.Background(), ""); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
+
+func TestAPISecret(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp, nonce := r.Header.Get("X-Signature-Timestamp"), r.Header.Get("X-Signature-Nonce")
+		mac := hmac.New(sha256.New, []byte("s3cret"))
+		mac.Write([]byte(timestamp + "\n" + nonce + "\n" + r.Method + "\n" + r.URL.RequestURI() + "\n"))
+		mac.Write(body)
+		if nonce == "" || r.Header.Get("X-Signature") != hex.EncodeToString(mac.Sum(nil)) {
+			t.Errorf("Expected the request signed with the secret, got %v", r.Header)
+		}
+		w.Write([]byte(`{"order_id": "order-1", "status": "pending"}`))
+	}))
+	defer server.Close()
+
+	c := New(server.URL)
+	c.APIKey, c.APISecret = "desk", "s3cret"
+	if _, err := c.PlaceOrder(context.Background(), PlaceOrderRequest{Side: SideBuy, Price: 100.0, Quantity: 10}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte(`{"error":{"code":"SELF_TRADE","message":"would self trade"},"order_id":"order-1"}`))
+	}))
+	defer server.Close()
+
+	_, err := New(server.URL).PlaceOrder(context.Background(), PlaceOrderRequest{Side: SideBuy, Price: 100.0, Quantity: 10})
+
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Expected *Error, got %v", err)
+	}
+
+	if apiErr.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422, got %d", apiErr.StatusCode)
+	}
+
+	if apiErr.Code != "SELF_TRADE" || apiErr.OrderID != "order-1" {
+		t.Errorf("Unexpected error %+v", apiErr)
+	}
+}
+
+func TestErrorWithoutEnvelope(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	_, err := New(server.URL).Trades(context.Background(), "")
+
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadGateway {
+		t.Errorf("Expected status 502 error, got %v", err)
+	}
+}
+
+func TestOrderEventsFilter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/orders/order-1/events" {
+			t.Errorf("Expected the order's events path, got %q", r.URL.Path)
+		}
+		w.Write([]byte(`{"events":[{"sequence":1,"order_id":"order-1","to":"pending"}],"count":1}`))
+	}))
+	defer server.Close()
+
+	events, err := New(server.URL).OrderEvents(context.Background(), "order-1")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(events) != 1 || events[0].To != "pending" {
+		t.Errorf("Unexpected events %+v", events)
+	}
+}
+
+func TestAggregateDepth(t *testing.T) {
+	book := &OrderBook{
+		BuyOrders: []Order{
+			{ID: "b1", Price: 100.0, Quantity: 5},
+			{ID: "b2", Price: 100.0, Quantity: 3},
+			{ID: "b3", Price: 99.0, Quantity: 1},
+			{ID: "b4", Price: 98.0, Quantity: 2},
+		},
+		SellOrders: []Order{
+			{ID: "s1", Price: 101.0, Quantity: 4},
+		},
+	}
+
+	bids, asks := AggregateDepth(book, 2)
+
+	if len(bids) != 2 {
+		t.Fatalf("Expected 2 bid levels, got %d", len(bids))
+	}
+
+	if bids[0].Price != 100.0 || bids[0].Quantity != 8 || bids[0].Orders != 2 {
+		t.Errorf("Unexpected best bid level %+v", bids[0])
+	}
+
+	if len(asks) != 1 || asks[0].Quantity != 4 {
+		t.Errorf("Unexpected asks %+v", asks)
+	}
+}
+
+func TestPriceAnalytics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/analytics/price" || r.URL.Query().Get("symbol") != "BTC-USD" {
+			t.Errorf("Unexpected request %s", r.URL)
+		}
+		w.Write([]byte(`{"symbol":"BTC-USD","sequence":7,"mid":100,"microprice":99.5,"imbalance":-0.2,"fair_value":99.8}`))
+	}))
+	defer server.Close()
+
+	prices, err := New(server.URL).PriceAnalytics(context.Background(), "BTC-USD")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if prices.Sequence != 7 || prices.Mid != 100 || prices.Microprice != 99.5 || prices.FairValue != 99.8 {
+		t.Errorf("Unexpected analytics %+v", prices)
+	}
+}
+
+func TestLiquidityAnalytics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if r.URL.Path != "/api/v1/analytics/liquidity" || query.Get("ticks") != "20" || query.Get("tick_size") != "0.05" {
+			t.Errorf("Unexpected request %s", r.URL)
+		}
+		w.Write([]byte(`{"symbol":"DEFAULT","volume_imbalance":0.2,"bid":{"quantity":60,"levels":2,"avg_level_quantity":30}}`))
+	}))
+	defer server.Close()
+
+	analytics, err := New(server.URL).LiquidityAnalytics(context.Background(), "", 20, 0.05)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if analytics.VolumeImbalance != 0.2 || analytics.Bid.Quantity != 60 || analytics.Bid.AverageLevelQuantity != 30 {
+		t.Errorf("Unexpected analytics %+v", analytics)
+	}
+}
+
+func TestStartAlgo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req AlgoRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if r.Method != "POST" || r.URL.Path != "/api/v1/algos" || req.Horizon != "30m" || req.Schedule != "twap" {
+			t.Errorf("Unexpected request %s %s %+v", r.Method, r.URL, req)
+		}
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"id":"algo-1","status":"running","slices":3,"plan":[4,7,10]}`))
+	}))
+	defer server.Close()
+
+	order, err := New(server.URL).StartAlgo(context.Background(), AlgoRequest{Side: SideBuy, Quantity: 10, LimitPrice: 100, Horizon: "30m", Slices: 3, Schedule: "twap"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if order.ID != "algo-1" || order.Status != "running" || len(order.Plan) != 3 {
+		t.Errorf("Unexpected parent order %+v", order)
+	}
+}
+
+func TestLatencyAnalytics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/analytics/latency" || r.URL.Query().Get("symbol") != "BTC-USD" {
+			t.Errorf("Unexpected request %s", r.URL)
+		}
+		w.Write([]byte(`{"symbol":"BTC-USD","count":3,"total":{"p50_ns":1500,"max_ns":90000}}`))
+	}))
+	defer server.Close()
+
+	analytics, err := New(server.URL).LatencyAnalytics(context.Background(), "BTC-USD")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if analytics.Count != 3 || analytics.Total.P50 != 1500*time.Nanosecond || analytics.Total.Max != 90*time.Microsecond {
+		t.Errorf("Unexpected analytics %+v", analytics)
+	}
+}
+
+func TestCancelOrder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "DELETE" || r.URL.Path != "/api/v1/orders/order-1" || r.URL.Query().Get("symbol") != "BTC-USD" {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL)
+		}
+		w.Write([]byte(`{"order":{"id":"order-1","symbol":"BTC-USD","status":"cancelled"}}`))
+	}))
+	defer server.Close()
+
+	order, err := New(server.URL).CancelOrder(context.Background(), "BTC-USD", "order-1")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if order.ID != "order-1" || order.Symbol != "BTC-USD" || order.Status != "cancelled" {
+		t.Errorf("Unexpected order %+v", order)
+	}
+}
+
+func TestSymbolQuery(t *testing.T) {
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Path+"?"+r.URL.RawQuery)
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	c, ctx := New(server.URL), context.Background()
+	c.Orders(ctx, "ETH-USD")
+	c.Trades(ctx, "ETH-USD")
+	c.OrderBook(ctx, "ETH-USD")
+	c.OrderBook(ctx, "")
+	want := []string{"/api/v1/orders?symbol=ETH-USD", "/api/v1/trades?symbol=ETH-USD", "/api/v1/orderbook?symbol=ETH-USD", "/api/v1/orderbook?"}
+	if !reflect.DeepEqual(queries, want) {
+		t.Errorf("Expected %v, got %v", want, queries)
+	}
+}
+
+func TestQueuePosition(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/orders/order-1/queue-position" {
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+		w.Write([]byte(`{"order_id":"order-1","position":3,"orders_ahead":2,"quantity_ahead":7,"level_orders":3}`))
+	}))
+	defer server.Close()
+
+	position, err := New(server.URL).QueuePosition(context.Background(), "order-1")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if position.Position != 3 || position.QuantityAhead != 7 || position.LevelOrders != 3 {
+		t.Errorf("Unexpected queue position %+v", position)
+	}
+}
@@ -0,0 +1,228 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// DepthSnapshot is a symbol's aggregated book as of a sequence number
+type DepthSnapshot struct {
+	Symbol   string `json:"symbol"`
+	Sequence int64  `json:"sequence"`
+	// Epoch changes when the server's sequence numbers start over
+	Epoch string       `json:"epoch,omitempty"`
+	Bids  []PriceLevel `json:"bids"`
+	Asks  []PriceLevel `json:"asks"`
+}
+
+const (
+	// DepthMessageUpdate carries the new state of every level that changed
+	DepthMessageUpdate = "update"
+	// DepthMessageResync means the server dropped updates and a new snapshot is needed
+	DepthMessageResync = "resync"
+)
+
+// DepthUpdate is one message from the depth stream. A level with zero
+// quantity has left the book. An update conflated for a slow client replaces
+// every update from FirstSequence to Sequence.
+type DepthUpdate struct {
+	Type          string       `json:"type"`
+	Symbol        string       `json:"symbol"`
+	FirstSequence int64        `json:"first_sequence,omitempty"`
+	Sequence      int64        `json:"sequence"`
+	Bids          []PriceLevel `json:"bids,omitempty"`
+	Asks          []PriceLevel `json:"asks,omitempty"`
+}
+
+var (
+	// ErrSequenceGap is returned by DepthBook.Apply when updates were missed
+	ErrSequenceGap = errors.New("depth update sequence gap")
+	// ErrResyncRequired is returned by DepthBook.Apply for a server resync message
+	ErrResyncRequired = errors.New("depth resync required")
+)
+
+// DepthBook is a local copy of a symbol's depth kept current by stream updates
+type DepthBook struct {
+	Symbol   string
+	Sequence int64
+
+	bids map[float64]PriceLevel
+	asks map[float64]PriceLevel
+}
+
+// NewDepthBook starts a local book from a snapshot
+func NewDepthBook(snapshot *DepthSnapshot) *DepthBook {
+	book := &DepthBook{
+		Symbol:   snapshot.Symbol,
+		Sequence: snapshot.Sequence,
+		bids:     make(map[float64]PriceLevel),
+		asks:     make(map[float64]PriceLevel),
+	}
+	setLevels(book.bids, snapshot.Bids)
+	setLevels(book.asks, snapshot.Asks)
+	return book
+}
+
+// Apply applies the next update. Updates at or before the book's sequence are
+// ignored; anything that does not cover the next sequence number is a gap.
+func (b *DepthBook) Apply(update DepthUpdate) error {
+	if update.Type == DepthMessageResync {
+		return ErrResyncRequired
+	}
+	if update.Sequence <= b.Sequence {
+		return nil
+	}
+	first := update.FirstSequence
+	if first == 0 {
+		first = update.Sequence
+	}
+	if first > b.Sequence+1 {
+		return ErrSequenceGap
+	}
+
+	setLevels(b.bids, update.Bids)
+	setLevels(b.asks, update.Asks)
+	b.Sequence = update.Sequence
+	return nil
+}
+
+// Bids returns up to levels bid levels, best first. Zero returns all of them.
+func (b *DepthBook) Bids(levels int) []PriceLevel {
+	return sortedLevels(b.bids, levels, func(x, y float64) bool { return x > y })
+}
+
+// Asks returns up to levels ask levels, best first. Zero returns all of them.
+func (b *DepthBook) Asks(levels int) []PriceLevel {
+	return sortedLevels(b.asks, levels, func(x, y float64) bool { return x < y })
+}
+
+// setLevels overwrites levels, dropping any whose quantity reached zero
+func setLevels(side map[float64]PriceLevel, levels []PriceLevel) {
+	for _, level := range levels {
+		if level.Quantity == 0 {
+			delete(side, level.Price)
+			continue
+		}
+		side[level.Price] = level
+	}
+}
+
+// sortedLevels returns a side's levels ordered by better
+func sortedLevels(side map[float64]PriceLevel, levels int, better func(x, y float64) bool) []PriceLevel {
+	result := make([]PriceLevel, 0, len(side))
+	for _, level := range side {
+		result = append(result, level)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return better(result[i].Price, result[j].Price)
+	})
+	if levels > 0 && len(result) > levels {
+		result = result[:levels]
+	}
+	return result
+}
+
+// DepthSnapshot returns a symbol's aggregated depth, limited to levels per side
+// unless levels is zero. An empty symbol means the server's default.
+func (c *Client) DepthSnapshot(ctx context.Context, symbol string, levels int) (*DepthSnapshot, error) {
+	query := url.Values{}
+	if symbol != "" {
+		query.Set("symbol", symbol)
+	}
+	if levels > 0 {
+		query.Set("levels", strconv.Itoa(levels))
+	}
+
+	var snapshot DepthSnapshot
+	if err := c.do(ctx, "GET", "/api/v1/depth/snapshot", query, nil, &snapshot); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// StreamDepth keeps a local depth book for symbol in sync and calls onChange
+// with it after the initial snapshot and every applied update. It refetches
+// the snapshot on a sequence gap or a server resync, and runs until ctx is
+// done or the connection fails.
+func (c *Client) StreamDepth(ctx context.Context, symbol string, onChange func(*DepthBook)) error {
+	return c.StreamDepthEvery(ctx, symbol, 0, onChange)
+}
+
+// StreamDepthEvery is StreamDepth with the server sending the changes at most
+// once per interval, for displays that do not need every update. Zero sends
+// every update.
+func (c *Client) StreamDepthEvery(ctx context.Context, symbol string, interval time.Duration, onChange func(*DepthBook)) error {
+	query := url.Values{}
+	if symbol != "" {
+		query.Set("symbol", symbol)
+	}
+	if interval > 0 {
+		query.Set("interval", interval.String())
+	}
+
+	// Connect before fetching the snapshot so no update falls between the two
+	conn, err := c.dialStream(ctx, "/api/v1/depth/stream", query)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	updates := make(chan DepthUpdate, 256)
+	readErr := make(chan error, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			var update DepthUpdate
+			if err := conn.ReadJSON(&update); err != nil {
+				readErr <- err
+				return
+			}
+			select {
+			case updates <- update:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	book, err := c.syncDepth(ctx, symbol, onChange)
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-readErr:
+			return err
+		case update := <-updates:
+			before := book.Sequence
+			if err := book.Apply(update); err != nil {
+				if book, err = c.syncDepth(ctx, symbol, onChange); err != nil {
+					return err
+				}
+				continue
+			}
+			if book.Sequence != before {
+				onChange(book)
+			}
+		}
+	}
+}
+
+// syncDepth fetches a full snapshot and hands the fresh book to onChange
+func (c *Client) syncDepth(ctx context.Context, symbol string, onChange func(*DepthBook)) (*DepthBook, error) {
+	snapshot, err := c.DepthSnapshot(ctx, symbol, 0)
+	if err != nil {
+		return nil, err
+	}
+	book := NewDepthBook(snapshot)
+	onChange(book)
+	return book, nil
+}
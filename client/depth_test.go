@@ -0,0 +1,136 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestDepthBook_Apply(t *testing.T) {
+	book := NewDepthBook(&DepthSnapshot{
+		Sequence: 5,
+		Bids:     []PriceLevel{{Price: 99.0, Quantity: 10, Orders: 1}},
+		Asks:     []PriceLevel{{Price: 101.0, Quantity: 4, Orders: 2}},
+	})
+
+	if err := book.Apply(DepthUpdate{Type: DepthMessageUpdate, Sequence: 5, Bids: []PriceLevel{{Price: 99.0}}}); err != nil {
+		t.Errorf("Expected a stale update to be ignored, got %v", err)
+	}
+
+	if err := book.Apply(DepthUpdate{Type: DepthMessageUpdate, Sequence: 6, Bids: []PriceLevel{{Price: 99.0}, {Price: 98.5, Quantity: 3, Orders: 1}}}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	bids := book.Bids(0)
+	if book.Sequence != 6 || len(bids) != 1 || bids[0].Price != 98.5 {
+		t.Errorf("Unexpected book after update: sequence %d, bids %+v", book.Sequence, bids)
+	}
+
+	if err := book.Apply(DepthUpdate{Type: DepthMessageUpdate, Sequence: 8}); !errors.Is(err, ErrSequenceGap) {
+		t.Errorf("Expected ErrSequenceGap, got %v", err)
+	}
+
+	// A conflated update covering the next sequence is applied whole
+	if err := book.Apply(DepthUpdate{Type: DepthMessageUpdate, FirstSequence: 7, Sequence: 9, Bids: []PriceLevel{{Price: 98.0, Quantity: 1, Orders: 1}}}); err != nil || book.Sequence != 9 {
+		t.Errorf("Expected the conflated update applied, got %v at sequence %d", err, book.Sequence)
+	}
+	if err := book.Apply(DepthUpdate{Type: DepthMessageUpdate, FirstSequence: 11, Sequence: 12}); !errors.Is(err, ErrSequenceGap) {
+		t.Errorf("Expected ErrSequenceGap, got %v", err)
+	}
+
+	if err := book.Apply(DepthUpdate{Type: DepthMessageResync, Sequence: 9}); !errors.Is(err, ErrResyncRequired) {
+		t.Errorf("Expected ErrResyncRequired, got %v", err)
+	}
+}
+
+func TestDepthBook_LevelsAreSortedAndLimited(t *testing.T) {
+	book := NewDepthBook(&DepthSnapshot{
+		Bids: []PriceLevel{{Price: 98.0, Quantity: 1}, {Price: 99.0, Quantity: 1}, {Price: 97.0, Quantity: 1}},
+		Asks: []PriceLevel{{Price: 102.0, Quantity: 1}, {Price: 101.0, Quantity: 1}},
+	})
+
+	bids := book.Bids(2)
+	if len(bids) != 2 || bids[0].Price != 99.0 || bids[1].Price != 98.0 {
+		t.Errorf("Unexpected bids %+v", bids)
+	}
+
+	asks := book.Asks(0)
+	if len(asks) != 2 || asks[0].Price != 101.0 {
+		t.Errorf("Unexpected asks %+v", asks)
+	}
+}
+
+func TestStreamDepth_ResyncsOnGap(t *testing.T) {
+	snapshots := []DepthSnapshot{
+		{Symbol: "BTC-USD", Sequence: 5, Bids: []PriceLevel{{Price: 99.0, Quantity: 10, Orders: 1}}},
+		{Symbol: "BTC-USD", Sequence: 10, Bids: []PriceLevel{{Price: 97.0, Quantity: 1, Orders: 1}}},
+	}
+	stream := []DepthUpdate{
+		{Type: DepthMessageUpdate, Sequence: 4},
+		{Type: DepthMessageUpdate, Sequence: 6, Bids: []PriceLevel{{Price: 98.0, Quantity: 3, Orders: 1}}},
+		{Type: DepthMessageUpdate, Sequence: 8},
+		{Type: DepthMessageUpdate, Sequence: 11, Asks: []PriceLevel{{Price: 101.0, Quantity: 2, Orders: 1}}},
+	}
+
+	upgrader := websocket.Upgrader{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/depth/snapshot", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("symbol") != "BTC-USD" {
+			t.Errorf("Expected symbol BTC-USD, got %q", r.URL.Query().Get("symbol"))
+		}
+		json.NewEncoder(w).Encode(snapshots[0])
+		snapshots = snapshots[1:]
+	})
+	mux.HandleFunc("/api/v1/depth/stream", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for _, update := range stream {
+			conn.WriteJSON(update)
+		}
+		conn.ReadMessage()
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var sequences []int64
+	var final *DepthBook
+	err := New(server.URL).StreamDepth(ctx, "BTC-USD", func(book *DepthBook) {
+		sequences = append(sequences, book.Sequence)
+		if book.Sequence == 11 {
+			final = book
+			cancel()
+		}
+	})
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	// snapshot 5, update 6, resync to snapshot 10, update 11
+	expected := []int64{5, 6, 10, 11}
+	if len(sequences) != len(expected) {
+		t.Fatalf("Expected sequences %v, got %v", expected, sequences)
+	}
+	for i := range expected {
+		if sequences[i] != expected[i] {
+			t.Errorf("Expected sequences %v, got %v", expected, sequences)
+			break
+		}
+	}
+
+	if bids := final.Bids(0); len(bids) != 1 || bids[0].Price != 97.0 {
+		t.Errorf("Expected only the resynced bid, got %+v", bids)
+	}
+}
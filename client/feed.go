@@ -0,0 +1,58 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// feedMessage is one message from a symbol's public feed. Only trades are
+// read; depth, status and auction messages are skipped.
+type feedMessage struct {
+	Type  string `json:"type"`
+	Trade *Trade `json:"trade,omitempty"`
+}
+
+// StreamTrades follows symbol's public feed and calls onTrade with each trade
+// as it happens, from the moment the stream connects. The trades carry no
+// order IDs. It runs until ctx is done or the connection fails.
+func (c *Client) StreamTrades(ctx context.Context, symbol string, onTrade func(Trade)) error {
+	conn, err := c.dialStream(ctx, "/api/v1/feed/public", symbolQuery(symbol))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	for {
+		var msg feedMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if msg.Type == "trade" && msg.Trade != nil {
+			onTrade(*msg.Trade)
+		}
+	}
+}
+
+// dialStream opens a WebSocket to one of the server's streams
+func (c *Client) dialStream(ctx context.Context, path string, query url.Values) (*websocket.Conn, error) {
+	endpoint, err := url.Parse(c.BaseURL + path)
+	if err != nil {
+		return nil, err
+	}
+	endpoint.Scheme = strings.Replace(endpoint.Scheme, "http", "ws", 1)
+	endpoint.RawQuery = query.Encode()
+
+	header := make(http.Header)
+	c.authorize(header)
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, endpoint.String(), header)
+	return conn, err
+}
@@ -0,0 +1,47 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestStreamTrades_SkipsOtherMessages(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/feed/public" || r.URL.Query().Get("symbol") != "ETH-USD" {
+			t.Errorf("Expected the ETH-USD public feed, got %s", r.URL)
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"channel":"public","type":"trade","symbol":"ETH-USD","trade":{"id":"t1","price":50,"quantity":2,"aggressor_side":"buy"}}`))
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"channel":"public","type":"depth","symbol":"ETH-USD","depth":{"type":"update","sequence":3}}`))
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"channel":"public","type":"trade","symbol":"ETH-USD","trade":{"id":"t2","price":51,"quantity":1,"aggressor_side":"sell"}}`))
+		conn.ReadMessage()
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var trades []Trade
+	err := New(server.URL).StreamTrades(ctx, "ETH-USD", func(trade Trade) {
+		if trades = append(trades, trade); len(trades) == 2 {
+			cancel()
+		}
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if len(trades) != 2 || trades[0].ID != "t1" || trades[1].Price != 51 || trades[1].AggressorSide != SideSell {
+		t.Errorf("Expected the two trades, got %+v", trades)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Clock is the engine's source of time
+type Clock interface {
+	Now() time.Time
+}
+
+// IDGenerator is the engine's source of order and trade IDs
+type IDGenerator interface {
+	OrderID() string
+	TradeID() string
+}
+
+// engineClock and idGenerator are used for every timestamp and ID the engine assigns.
+// Tests and simulations can replace them to make matching fully deterministic.
+var (
+	engineClock Clock       = systemClock{}
+	idGenerator IDGenerator = uuidGenerator{}
+)
+
+// systemClock reads the wall clock
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// ManualClock only moves when told to
+type ManualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewManualClock creates a clock stopped at start
+func NewManualClock(start time.Time) *ManualClock {
+	return &ManualClock{now: start}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Set moves the clock to t
+func (c *ManualClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
+
+// Advance moves the clock forward by d and returns the new time
+func (c *ManualClock) Advance(d time.Duration) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	return c.now
+}
+
+// SimulatedClock is a virtual clock that runs at a multiple of the wall
+// clock's rate, stands still while paused, and can be moved forward by hand
+type SimulatedClock struct {
+	mu sync.Mutex
+	// at is the virtual time when the clock was last set, paused or
+	// resumed, and wall the wall time then
+	at     time.Time
+	wall   time.Time
+	rate   float64
+	paused bool
+}
+
+// NewSimulatedClock creates a clock paused at start that runs at the wall
+// clock's rate once resumed
+func NewSimulatedClock(start time.Time) *SimulatedClock {
+	return &SimulatedClock{at: start, wall: time.Now(), rate: 1, paused: true}
+}
+
+func (c *SimulatedClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.nowLocked()
+}
+
+// nowLocked reads the clock; c.mu must be held
+func (c *SimulatedClock) nowLocked() time.Time {
+	if c.paused {
+		return c.at
+	}
+	return c.at.Add(time.Duration(float64(time.Since(c.wall)) * c.rate))
+}
+
+// Pause stops the clock where it is
+func (c *SimulatedClock) Pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.at, c.paused = c.nowLocked(), true
+}
+
+// Resume runs the clock again, at rate times the wall clock's if rate is
+// positive and at its previous rate otherwise
+func (c *SimulatedClock) Resume(rate float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.at, c.wall, c.paused = c.nowLocked(), time.Now(), false
+	if rate > 0 {
+		c.rate = rate
+	}
+}
+
+// MoveTo moves the clock forward to t; it never moves back
+func (c *SimulatedClock) MoveTo(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t.After(c.nowLocked()) {
+		c.at, c.wall = t, time.Now()
+	}
+}
+
+// State reports the clock's time, whether it is paused and its rate
+func (c *SimulatedClock) State() (now time.Time, paused bool, rate float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.nowLocked(), c.paused, c.rate
+}
+
+// uuidGenerator issues random UUIDs
+type uuidGenerator struct{}
+
+func (uuidGenerator) OrderID() string {
+	return uuid.New().String()
+}
+
+func (uuidGenerator) TradeID() string {
+	return uuid.New().String()
+}
+
+// SequentialIDGenerator issues predictable IDs such as "order-1" and "trade-1"
+type SequentialIDGenerator struct {
+	mu     sync.Mutex
+	orders int
+	trades int
+}
+
+func (g *SequentialIDGenerator) OrderID() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.orders++
+	return fmt.Sprintf("order-%d", g.orders)
+}
+
+func (g *SequentialIDGenerator) TradeID() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.trades++
+	return fmt.Sprintf("trade-%d", g.trades)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// useDeterministicEngine installs a manual clock and sequential IDs for the duration of a test
+func useDeterministicEngine(t testing.TB, start time.Time) *ManualClock {
+	t.Helper()
+
+	clock := NewManualClock(start)
+	previousClock, previousIDs := engineClock, idGenerator
+	engineClock, idGenerator = clock, &SequentialIDGenerator{}
+	t.Cleanup(func() {
+		engineClock, idGenerator = previousClock, previousIDs
+	})
+	return clock
+}
+
+func TestManualClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 9, 30, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+
+	if !clock.Now().Equal(start) {
+		t.Errorf("Expected %s, got %s", start, clock.Now())
+	}
+
+	if got := clock.Advance(time.Minute); !got.Equal(start.Add(time.Minute)) {
+		t.Errorf("Expected clock to advance one minute, got %s", got)
+	}
+
+	later := start.Add(time.Hour)
+	clock.Set(later)
+	if !clock.Now().Equal(later) {
+		t.Errorf("Expected %s, got %s", later, clock.Now())
+	}
+}
+
+func TestSequentialIDGenerator(t *testing.T) {
+	ids := &SequentialIDGenerator{}
+
+	if ids.OrderID() != "order-1" || ids.OrderID() != "order-2" {
+		t.Error("Expected sequential order IDs")
+	}
+
+	if ids.TradeID() != "trade-1" {
+		t.Error("Expected trade IDs to count independently of order IDs")
+	}
+}
+
+func TestGenerateIDs_UseInjectedGenerator(t *testing.T) {
+	useDeterministicEngine(t, time.Now())
+
+	if id := generateOrderID(); id != "order-1" {
+		t.Errorf("Expected order-1, got %s", id)
+	}
+
+	if id := generateTradeID(); id != "trade-1" {
+		t.Errorf("Expected trade-1, got %s", id)
+	}
+}
+
+// replayScenario places the same orders through the handler path and returns the resulting trades
+func replayScenario(t *testing.T) []Trade {
+	setupTest()
+	clock := useDeterministicEngine(t, time.Date(2024, 1, 1, 9, 30, 0, 0, time.UTC))
+
+	requests := []PlaceOrderRequest{
+		{Side: SideSell, Price: 100.0, Quantity: 10},
+		{Side: SideSell, Price: 101.0, Quantity: 10},
+		{Side: SideBuy, Price: 101.0, Quantity: 15},
+	}
+	for _, req := range requests {
+		clock.Advance(time.Millisecond)
+		processOrder(Order{
+			ID:        generateOrderID(),
+			Side:      req.Side,
+			Price:     req.Price,
+			Quantity:  req.Quantity,
+			Status:    OrderStatusPending,
+			CreatedAt: engineClock.Now(),
+		})
+	}
+	return tradeStore.List("")
+}
+
+func TestProcessOrder_DeterministicReplay(t *testing.T) {
+	first := replayScenario(t)
+	second := replayScenario(t)
+
+	if len(first) != 2 {
+		t.Fatalf("Expected 2 trades, got %d", len(first))
+	}
+
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("Expected identical trades across replays:\n%+v\n%+v", first, second)
+	}
+
+	if first[0].ID != "trade-1" || first[0].MakerID != "order-1" || first[0].TakerID != "order-3" {
+		t.Errorf("Unexpected first trade %+v", first[0])
+	}
+
+	expected := time.Date(2024, 1, 1, 9, 30, 0, int(3*time.Millisecond), time.UTC)
+	if !first[0].CreatedAt.Equal(expected) {
+		t.Errorf("Expected trade time %s, got %s", expected, first[0].CreatedAt)
+	}
+}
+
+func TestExpireOrders_FollowsInjectedClock(t *testing.T) {
+	setupTest()
+	start := time.Date(2024, 1, 1, 9, 30, 0, 0, time.UTC)
+	clock := useDeterministicEngine(t, start)
+
+	expiresAt := start.Add(time.Minute)
+	processOrder(Order{ID: "buy-1", Side: SideBuy, Price: 100.0, Quantity: 10, Status: OrderStatusPending, CreatedAt: start, ExpiresAt: &expiresAt})
+
+	clock.Advance(30 * time.Second)
+	processOrder(Order{ID: "buy-2", Side: SideBuy, Price: 99.0, Quantity: 10, Status: OrderStatusPending, CreatedAt: clock.Now()})
+	if len(bookFor("").bids.orders()) != 2 {
+		t.Fatalf("Expected both orders to rest before expiry, got %d", len(bookFor("").bids.orders()))
+	}
+
+	clock.Advance(time.Minute)
+	processOrder(Order{ID: "buy-3", Side: SideBuy, Price: 98.0, Quantity: 10, Status: OrderStatusPending, CreatedAt: clock.Now()})
+	for _, order := range bookFor("").bids.orders() {
+		if order.ID == "buy-1" {
+			t.Error("Expected buy-1 to expire once the clock passed its expiry")
+		}
+	}
+}
@@ -0,0 +1,169 @@
+// Command lobbench fires a configurable mix of orders at an order book server
+// and reports throughput and latency percentiles, followed by the engine's own
+// queue and match timings over its most recent orders. Its in-process target,
+// with no HTTP in the way, is BenchmarkMixedFlow in the server's package:
+//
+//	go test -run XXX -bench MixedFlow .
+//
+// Usage:
+//
+//	lobbench [-addr URL] [-api-key KEY] [-n COUNT | -duration D] [-workers N]
+//	         [-limit R] [-market R] [-cancel R]
+//	         [-dist uniform|normal] [-price P] [-width W] [-qty Q] [-slippage S] [-seed N]
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"valhalla/client"
+)
+
+// target is the system under test
+type target interface {
+	place(ctx context.Context, req client.PlaceOrderRequest) (*client.PlaceOrderResponse, error)
+	cancel(ctx context.Context, orderID string) error
+}
+
+// httpTarget sends every operation through the REST API
+type httpTarget struct {
+	c *client.Client
+}
+
+func (t httpTarget) place(ctx context.Context, req client.PlaceOrderRequest) (*client.PlaceOrderResponse, error) {
+	return t.c.PlaceOrder(ctx, req)
+}
+
+func (t httpTarget) cancel(ctx context.Context, orderID string) error {
+	_, err := t.c.CancelOrder(ctx, "", orderID)
+	return err
+}
+
+func main() {
+	addr := flag.String("addr", "http://localhost:8080", "order book server address")
+	count := flag.Int("n", 10000, "total operations to send (ignored when -duration is set)")
+	duration := flag.Duration("duration", 0, "run for this long instead of a fixed count")
+	workers := flag.Int("workers", 8, "concurrent workers")
+	seed := flag.Int64("seed", 1, "random seed")
+	apiKey := flag.String("api-key", os.Getenv("VALHALLA_API_KEY"), "API key for servers that require one (defaults to $VALHALLA_API_KEY)")
+
+	var m mix
+	flag.Float64Var(&m.limit, "limit", 0.7, "share of passive limit orders")
+	flag.Float64Var(&m.market, "market", 0.2, "share of marketable orders")
+	flag.Float64Var(&m.cancel, "cancel", 0.1, "share of cancels of previously placed orders")
+	flag.StringVar(&m.dist, "dist", "uniform", "limit price distribution: uniform or normal")
+	flag.Float64Var(&m.price, "price", 100.0, "reference price")
+	flag.Float64Var(&m.width, "width", 1.0, "uniform range or normal standard deviation of limit prices around the reference")
+	flag.IntVar(&m.maxQty, "qty", 10, "maximum order quantity")
+	flag.Float64Var(&m.slippage, "slippage", 0.05, "how far marketable orders are priced through the reference, as a fraction")
+	flag.Parse()
+
+	if err := m.validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "lobbench: %v\n", err)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+		*count = 0
+	}
+
+	c := client.New(*addr)
+	c.HTTPClient.Transport = &http.Transport{MaxIdleConnsPerHost: *workers}
+	c.APIKey = *apiKey
+
+	start := time.Now()
+	rec := run(ctx, httpTarget{c: c}, m, *workers, *count, *seed)
+	rec.report(os.Stdout, time.Since(start))
+
+	// The run's context may already have ended, so ask for the engine's own
+	// timings on a fresh one
+	engine, err := c.LatencyAnalytics(context.Background(), "")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "lobbench: engine latency unavailable: %v\n", err)
+		return
+	}
+	reportEngine(os.Stdout, engine)
+}
+
+// run drives workers until count operations are sent (count > 0) or ctx ends
+func run(ctx context.Context, t target, m mix, workers, count int, seed int64) *recorder {
+	var sent int64
+	results := make([]*recorder, workers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		results[i] = newRecorder()
+		wg.Add(1)
+		go func(rec *recorder, rng *rand.Rand) {
+			defer wg.Done()
+			var resting []string
+
+			for ctx.Err() == nil {
+				if count > 0 && atomic.AddInt64(&sent, 1) > int64(count) {
+					return
+				}
+				resting = step(ctx, t, m, rng, rec, resting)
+			}
+		}(results[i], rand.New(rand.NewSource(seed+int64(i))))
+	}
+	wg.Wait()
+
+	total := newRecorder()
+	for _, rec := range results {
+		total.merge(rec)
+	}
+	return total
+}
+
+// step sends one operation and returns the worker's updated list of resting orders
+func step(ctx context.Context, t target, m mix, rng *rand.Rand, rec *recorder, resting []string) []string {
+	op := m.pick(rng)
+	if op == opCancel && len(resting) == 0 {
+		op = opLimit
+	}
+
+	var req client.PlaceOrderRequest
+	switch op {
+	case opCancel:
+		i := rng.Intn(len(resting))
+		orderID := resting[i]
+		resting[i] = resting[len(resting)-1]
+		resting = resting[:len(resting)-1]
+
+		began := time.Now()
+		err := t.cancel(ctx, orderID)
+		if ctx.Err() == nil {
+			rec.record(op, time.Since(began), err)
+		}
+		return resting
+	case opMarket:
+		req = m.marketOrder(rng)
+	default:
+		req = m.limitOrder(rng)
+	}
+
+	began := time.Now()
+	resp, err := t.place(ctx, req)
+	if ctx.Err() != nil {
+		return resting
+	}
+	rec.record(op, time.Since(began), err)
+
+	if err == nil && (resp.Status == "pending" || resp.Status == "partially_filled") {
+		resting = append(resting, resp.OrderID)
+	}
+	return resting
+}
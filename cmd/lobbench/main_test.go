@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"valhalla/client"
+)
+
+// fakeTarget rests every order and counts calls
+type fakeTarget struct {
+	mu      sync.Mutex
+	placed  int
+	cancels int
+	nextID  int
+}
+
+func (f *fakeTarget) place(ctx context.Context, req client.PlaceOrderRequest) (*client.PlaceOrderResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.placed++
+	f.nextID++
+	return &client.PlaceOrderResponse{OrderID: string(rune('a' + f.nextID%26)), Status: "pending"}, nil
+}
+
+func (f *fakeTarget) cancel(ctx context.Context, orderID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cancels++
+	return nil
+}
+
+func testMix() mix {
+	return mix{limit: 0.5, market: 0.2, cancel: 0.3, dist: "uniform", price: 100, width: 1, maxQty: 10, slippage: 0.05}
+}
+
+func TestMix_Validate(t *testing.T) {
+	if err := testMix().validate(); err != nil {
+		t.Errorf("Expected valid mix, got %v", err)
+	}
+
+	bad := testMix()
+	bad.limit, bad.market, bad.cancel = 0, 0, 0
+	if bad.validate() == nil {
+		t.Error("Expected error for all-zero ratios")
+	}
+
+	bad = testMix()
+	bad.dist = "pareto"
+	if bad.validate() == nil {
+		t.Error("Expected error for unknown distribution")
+	}
+}
+
+func TestMix_PickFollowsRatios(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	m := testMix()
+
+	counts := map[opKind]int{}
+	for i := 0; i < 10000; i++ {
+		counts[m.pick(rng)]++
+	}
+
+	if counts[opLimit] < 4500 || counts[opLimit] > 5500 {
+		t.Errorf("Expected about 5000 limit picks, got %d", counts[opLimit])
+	}
+
+	if counts[opCancel] < 2500 || counts[opCancel] > 3500 {
+		t.Errorf("Expected about 3000 cancel picks, got %d", counts[opCancel])
+	}
+}
+
+func TestMix_LimitOrdersStayOnTheirSide(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	for _, dist := range []string{"uniform", "normal"} {
+		m := testMix()
+		m.dist = dist
+		for i := 0; i < 1000; i++ {
+			req := m.limitOrder(rng)
+			if req.Side == client.SideBuy && req.Price > m.price {
+				t.Fatalf("%s: buy priced above reference: %.2f", dist, req.Price)
+			}
+			if req.Side == client.SideSell && req.Price < m.price {
+				t.Fatalf("%s: sell priced below reference: %.2f", dist, req.Price)
+			}
+			if req.Quantity < 1 || req.Quantity > m.maxQty {
+				t.Fatalf("%s: quantity out of range: %d", dist, req.Quantity)
+			}
+		}
+	}
+}
+
+func TestMix_MarketOrdersCrossReference(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	m := testMix()
+
+	for i := 0; i < 100; i++ {
+		req := m.marketOrder(rng)
+		if req.Side == client.SideBuy && req.Price != 105 {
+			t.Fatalf("Expected marketable buy at 105, got %.2f", req.Price)
+		}
+		if req.Side == client.SideSell && req.Price != 95 {
+			t.Fatalf("Expected marketable sell at 95, got %.2f", req.Price)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var samples []time.Duration
+	for i := 1; i <= 100; i++ {
+		samples = append(samples, time.Duration(i)*time.Millisecond)
+	}
+
+	if got := percentile(samples, 50); got != 50*time.Millisecond {
+		t.Errorf("Expected p50 of 50ms, got %s", got)
+	}
+
+	if got := percentile(samples, 99); got != 99*time.Millisecond {
+		t.Errorf("Expected p99 of 99ms, got %s", got)
+	}
+
+	if got := percentile(samples, 100); got != 100*time.Millisecond {
+		t.Errorf("Expected p100 of 100ms, got %s", got)
+	}
+
+	if got := percentile(nil, 50); got != 0 {
+		t.Errorf("Expected 0 for no samples, got %s", got)
+	}
+}
+
+func TestRun_SendsExactCount(t *testing.T) {
+	target := &fakeTarget{}
+
+	rec := run(context.Background(), target, testMix(), 4, 500, 1)
+
+	if rec.total() != 500 {
+		t.Errorf("Expected 500 recorded operations, got %d", rec.total())
+	}
+
+	if target.placed+target.cancels != 500 {
+		t.Errorf("Expected 500 calls, got %d", target.placed+target.cancels)
+	}
+
+	if target.cancels == 0 {
+		t.Error("Expected some cancels")
+	}
+
+	var out bytes.Buffer
+	rec.report(&out, time.Second)
+	if !strings.Contains(out.String(), "500 operations") || !strings.Contains(out.String(), "P99") {
+		t.Errorf("Unexpected report:\n%s", out.String())
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"valhalla/client"
+)
+
+type opKind string
+
+const (
+	opLimit  opKind = "limit"
+	opMarket opKind = "market"
+	opCancel opKind = "cancel"
+)
+
+// mix describes the share of each operation and how limit prices are drawn
+type mix struct {
+	limit, market, cancel float64
+
+	// dist is "uniform" or "normal"
+	dist     string
+	price    float64
+	width    float64
+	maxQty   int
+	slippage float64
+}
+
+// validate checks the ratios and distribution settings
+func (m mix) validate() error {
+	if m.limit < 0 || m.market < 0 || m.cancel < 0 || m.limit+m.market+m.cancel == 0 {
+		return fmt.Errorf("order mix ratios must be non-negative and not all zero")
+	}
+	if m.dist != "uniform" && m.dist != "normal" {
+		return fmt.Errorf("unknown price distribution %q (want uniform or normal)", m.dist)
+	}
+	if m.price <= 0 || m.width < 0 || m.maxQty <= 0 {
+		return fmt.Errorf("price and quantity must be positive")
+	}
+	return nil
+}
+
+// pick chooses the next operation according to the configured ratios
+func (m mix) pick(rng *rand.Rand) opKind {
+	r := rng.Float64() * (m.limit + m.market + m.cancel)
+	switch {
+	case r < m.limit:
+		return opLimit
+	case r < m.limit+m.market:
+		return opMarket
+	default:
+		return opCancel
+	}
+}
+
+// limitOrder draws a passive order whose price comes from the configured distribution
+func (m mix) limitOrder(rng *rand.Rand) client.PlaceOrderRequest {
+	side := randomSide(rng)
+
+	var offset float64
+	if m.dist == "normal" {
+		offset = math.Abs(rng.NormFloat64()) * m.width
+	} else {
+		offset = rng.Float64() * m.width
+	}
+
+	// Keep limit orders on their own side of the reference price
+	price := m.price - offset
+	if side == client.SideSell {
+		price = m.price + offset
+	}
+
+	return client.PlaceOrderRequest{
+		Side:     side,
+		Price:    math.Max(0.01, roundPrice(price)),
+		Quantity: 1 + rng.Intn(m.maxQty),
+	}
+}
+
+// marketOrder draws a marketable order priced through the reference by the slippage fraction.
+// The engine has no native market orders, so this is an aggressive limit.
+func (m mix) marketOrder(rng *rand.Rand) client.PlaceOrderRequest {
+	side := randomSide(rng)
+
+	price := m.price * (1 + m.slippage)
+	if side == client.SideSell {
+		price = m.price * (1 - m.slippage)
+	}
+
+	return client.PlaceOrderRequest{
+		Side:     side,
+		Price:    math.Max(0.01, roundPrice(price)),
+		Quantity: 1 + rng.Intn(m.maxQty),
+	}
+}
+
+func randomSide(rng *rand.Rand) client.Side {
+	if rng.Intn(2) == 0 {
+		return client.SideBuy
+	}
+	return client.SideSell
+}
+
+func roundPrice(price float64) float64 {
+	return math.Round(price*100) / 100
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"valhalla/client"
+)
+
+// recorder collects per-operation latencies and error counts
+type recorder struct {
+	latencies map[opKind][]time.Duration
+	errors    map[opKind]int
+}
+
+func newRecorder() *recorder {
+	return &recorder{
+		latencies: make(map[opKind][]time.Duration),
+		errors:    make(map[opKind]int),
+	}
+}
+
+func (r *recorder) record(op opKind, latency time.Duration, err error) {
+	r.latencies[op] = append(r.latencies[op], latency)
+	if err != nil {
+		r.errors[op]++
+	}
+}
+
+// merge folds another recorder's samples into this one
+func (r *recorder) merge(other *recorder) {
+	for op, samples := range other.latencies {
+		r.latencies[op] = append(r.latencies[op], samples...)
+	}
+	for op, count := range other.errors {
+		r.errors[op] += count
+	}
+}
+
+func (r *recorder) total() int {
+	n := 0
+	for _, samples := range r.latencies {
+		n += len(samples)
+	}
+	return n
+}
+
+// percentile returns the nearest-rank percentile of sorted samples
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(p/100*float64(len(sorted))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+// report prints throughput and latency percentiles per operation
+func (r *recorder) report(w io.Writer, elapsed time.Duration) {
+	fmt.Fprintf(w, "%d operations in %s (%.0f ops/sec)\n\n", r.total(), elapsed.Round(time.Millisecond), float64(r.total())/elapsed.Seconds())
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "OP\tCOUNT\tERRORS\tP50\tP90\tP99\tP99.9\tMAX\t")
+	for _, op := range []opKind{opLimit, opMarket, opCancel} {
+		samples := r.latencies[op]
+		if len(samples) == 0 {
+			continue
+		}
+		sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\t%s\t%s\t%s\t\n", op, len(samples), r.errors[op],
+			percentile(samples, 50), percentile(samples, 90), percentile(samples, 99),
+			percentile(samples, 99.9), samples[len(samples)-1])
+	}
+	tw.Flush()
+}
+
+// reportEngine prints the server's internal latency percentiles, which leave
+// out the HTTP round-trip
+func reportEngine(w io.Writer, engine *client.LatencyAnalytics) {
+	fmt.Fprintf(w, "\nengine latency over the last %d orders\n\n", engine.Count)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "STAGE\tP50\tP90\tP99\tP99.9\tMAX\t")
+	for _, stage := range []struct {
+		name string
+		p    client.LatencyPercentiles
+	}{{"queue", engine.Queue}, {"match", engine.Match}, {"total", engine.Total}} {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t\n", stage.name, stage.p.P50, stage.p.P90, stage.p.P99, stage.p.P999, stage.p.Max)
+	}
+	tw.Flush()
+}
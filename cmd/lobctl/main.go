@@ -0,0 +1,267 @@
+// Command lobctl places and cancels orders and inspects a running order book server.
+//
+// Usage:
+//
+//	lobctl [-addr URL] [-api-key KEY] [-api-secret SECRET] place [-symbol S] -side buy|sell -price P -qty Q [-owner NAME] [-ttl DURATION] [-tif GTC|IOC] [-min-qty N | -aon]
+//	lobctl [-addr URL] [-api-key KEY] [-api-secret SECRET] place [-symbol S] -side buy|sell -qty Q -trail AMOUNT|-trail-pct PCT [-limit-offset X]
+//	lobctl [-addr URL] [-api-key KEY] [-api-secret SECRET] place [-symbol S] -side buy|sell -qty Q -peg primary|midpoint|market [-peg-offset X]
+//	lobctl [-addr URL] [-api-key KEY] [-api-secret SECRET] place [-symbol S] -side buy -spend AMOUNT
+//	lobctl [-addr URL] [-api-key KEY] [-api-secret SECRET] cancel [-symbol S] ORDER_ID
+//	lobctl [-addr URL] [-api-key KEY] [-api-secret SECRET] book [-symbol S]
+//	lobctl [-addr URL] [-api-key KEY] [-api-secret SECRET] trades [-symbol S] [-n COUNT]
+//	lobctl [-addr URL] [-api-key KEY] [-api-secret SECRET] watch [-symbol S] [-levels N] [-stream=false -interval DURATION]
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"text/tabwriter"
+	"time"
+
+	"valhalla/client"
+)
+
+func main() {
+	addr := flag.String("addr", "http://localhost:8080", "order book server address")
+	apiKey := flag.String("api-key", os.Getenv("VALHALLA_API_KEY"), "API key for servers that require one (defaults to $VALHALLA_API_KEY)")
+	apiSecret := flag.String("api-secret", os.Getenv("VALHALLA_API_SECRET"), "secret of the API key, for servers that require signed orders (defaults to $VALHALLA_API_SECRET)")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	c := client.New(*addr)
+	c.APIKey = *apiKey
+	c.APISecret = *apiSecret
+	args := flag.Args()[1:]
+
+	var err error
+	switch flag.Arg(0) {
+	case "place":
+		err = runPlace(ctx, c, args)
+	case "cancel":
+		err = runCancel(ctx, c, args)
+	case "book":
+		err = runBook(ctx, c, args)
+	case "trades":
+		err = runTrades(ctx, c, args)
+	case "watch":
+		err = runWatch(ctx, c, args)
+	default:
+		fmt.Fprintf(os.Stderr, "lobctl: unknown command %q\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil && !errors.Is(err, context.Canceled) {
+		fmt.Fprintf(os.Stderr, "lobctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage: lobctl [-addr URL] [-api-key KEY] [-api-secret SECRET] <command> [flags]
+
+commands:
+  place   [-symbol S] -side buy|sell -price P -qty Q [-owner NAME] [-ttl DURATION] [-tif GTC|IOC] [-min-qty N | -aon]
+          [-symbol S] -side buy|sell -qty Q -trail AMOUNT|-trail-pct PCT [-limit-offset X]
+          [-symbol S] -side buy|sell -qty Q -peg primary|midpoint|market [-peg-offset X]
+          [-symbol S] -side buy -spend AMOUNT
+  cancel  [-symbol S] ORDER_ID
+  book    [-symbol S] print the current order book
+  trades  [-symbol S] [-n COUNT] print the most recent trades
+  watch   [-symbol S] [-levels N] [-stream=false -interval DURATION] live depth view`)
+}
+
+func runPlace(ctx context.Context, c *client.Client, args []string) error {
+	fs := flag.NewFlagSet("place", flag.ExitOnError)
+	symbol := fs.String("symbol", "", "symbol to trade (defaults to the server's default symbol)")
+	side := fs.String("side", "", "buy or sell")
+	price := fs.Float64("price", 0, "limit price")
+	qty := fs.Int("qty", 0, "quantity")
+	owner := fs.String("owner", "", "owner used for self-trade prevention")
+	ttl := fs.Duration("ttl", 0, "expire the order after this long")
+	trail := fs.Float64("trail", 0, "place a trailing stop this far from the best trade price")
+	trailPct := fs.Float64("trail-pct", 0, "place a trailing stop this many percent from the best trade price")
+	limitOffset := fs.Float64("limit-offset", -1, "make a triggered stop a limit this far past the trigger (default: market)")
+	tif := fs.String("tif", "", "time in force: GTC or IOC")
+	minQty := fs.Int("min-qty", 0, "only trade if at least this much fills at once")
+	aon := fs.Bool("aon", false, "only trade if the whole order fills at once")
+	peg := fs.String("peg", "", "peg the price to the primary, midpoint or market quote")
+	pegOffset := fs.Float64("peg-offset", 0, "keep a pegged order this far away from its reference")
+	spend := fs.Float64("spend", 0, "place a market buy for this much of the quote asset")
+	fs.Parse(args)
+
+	req := client.PlaceOrderRequest{
+		Symbol:      *symbol,
+		Side:        client.Side(*side),
+		Price:       *price,
+		Quantity:    *qty,
+		Owner:       *owner,
+		TimeInForce: *tif,
+		MinQuantity: *minQty,
+		AllOrNone:   *aon,
+	}
+	if *trail > 0 || *trailPct > 0 {
+		req.Type = client.OrderTypeTrailingStop
+		req.TrailAmount = *trail
+		req.TrailPercent = *trailPct
+		if *limitOffset >= 0 {
+			req.LimitOffset = limitOffset
+		}
+	}
+	if *peg != "" {
+		req.Type = client.OrderTypePegged
+		req.Peg = *peg
+		req.PegOffset = *pegOffset
+	}
+	if *spend > 0 {
+		req.Type = client.OrderTypeMarket
+		req.QuoteQuantity = *spend
+	}
+	if *ttl > 0 {
+		expiresAt := time.Now().Add(*ttl)
+		req.ExpiresAt = &expiresAt
+	}
+
+	resp, err := c.PlaceOrder(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("order %s %s\n", resp.OrderID, resp.Status)
+	return nil
+}
+
+func runCancel(ctx context.Context, c *client.Client, args []string) error {
+	fs := flag.NewFlagSet("cancel", flag.ExitOnError)
+	symbol := fs.String("symbol", "", "symbol the order rests on (defaults to whichever has it)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("cancel takes exactly one order ID")
+	}
+
+	order, err := c.CancelOrder(ctx, *symbol, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("order %s %s\n", order.ID, order.Status)
+	return nil
+}
+
+func runBook(ctx context.Context, c *client.Client, args []string) error {
+	fs := flag.NewFlagSet("book", flag.ExitOnError)
+	symbol := fs.String("symbol", "", "symbol to show (defaults to the server's default symbol)")
+	fs.Parse(args)
+
+	book, err := c.OrderBook(ctx, *symbol)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SIDE\tPRICE\tQTY\tSTATUS\tID")
+	for _, order := range book.SellOrders {
+		fmt.Fprintf(tw, "%s\t%.2f\t%d\t%s\t%s\n", order.Side, order.Price, order.Quantity, order.Status, order.ID)
+	}
+	for _, order := range book.BuyOrders {
+		fmt.Fprintf(tw, "%s\t%.2f\t%d\t%s\t%s\n", order.Side, order.Price, order.Quantity, order.Status, order.ID)
+	}
+	return tw.Flush()
+}
+
+func runTrades(ctx context.Context, c *client.Client, args []string) error {
+	fs := flag.NewFlagSet("trades", flag.ExitOnError)
+	symbol := fs.String("symbol", "", "symbol to show (defaults to every symbol)")
+	n := fs.Int("n", 20, "number of most recent trades to show (0 for all)")
+	fs.Parse(args)
+
+	trades, err := c.Trades(ctx, *symbol)
+	if err != nil {
+		return err
+	}
+	if *n > 0 && len(trades) > *n {
+		trades = trades[len(trades)-*n:]
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tSYMBOL\tPRICE\tQTY\tMAKER\tTAKER")
+	for _, trade := range trades {
+		fmt.Fprintf(tw, "%s\t%s\t%.2f\t%d\t%s\t%s\n",
+			trade.CreatedAt.Format("15:04:05.000"), trade.Symbol, trade.Price, trade.Quantity, trade.MakerID, trade.TakerID)
+	}
+	return tw.Flush()
+}
+
+// runWatch redraws the aggregated depth on every stream update, or each time
+// the book is polled when streaming is off
+func runWatch(ctx context.Context, c *client.Client, args []string) error {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	symbol := fs.String("symbol", "", "symbol to watch (defaults to the server's default symbol)")
+	stream := fs.Bool("stream", true, "follow the depth stream instead of polling")
+	interval := fs.Duration("interval", 500*time.Millisecond, "refresh interval when polling")
+	levels := fs.Int("levels", 10, "price levels to show per side")
+	fs.Parse(args)
+
+	if *stream {
+		return c.StreamDepth(ctx, *symbol, func(book *client.DepthBook) {
+			drawDepth(book.Bids(*levels), book.Asks(*levels), fmt.Sprintf("sequence %d", book.Sequence))
+		})
+	}
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	for {
+		snapshot, err := c.DepthSnapshot(ctx, *symbol, *levels)
+		if err != nil {
+			return err
+		}
+		drawDepth(snapshot.Bids, snapshot.Asks, fmt.Sprintf("sequence %d", snapshot.Sequence))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// drawDepth clears the terminal and renders the depth with a status line
+func drawDepth(bids, asks []client.PriceLevel, status string) {
+	fmt.Print("\033[H\033[2J")
+	renderDepth(os.Stdout, bids, asks)
+	fmt.Printf("\n%s, updated %s, ctrl-c to quit\n", status, time.Now().Format("15:04:05"))
+}
+
+// renderDepth prints asks above bids with the best prices meeting in the middle
+func renderDepth(w io.Writer, bids, asks []client.PriceLevel) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "ORDERS\tQTY\tPRICE\t")
+	for i := len(asks) - 1; i >= 0; i-- {
+		fmt.Fprintf(tw, "%d\t%d\t%.2f\t\n", asks[i].Orders, asks[i].Quantity, asks[i].Price)
+	}
+
+	spread := "-"
+	if len(bids) > 0 && len(asks) > 0 {
+		spread = fmt.Sprintf("%.2f", asks[0].Price-bids[0].Price)
+	}
+	fmt.Fprintf(tw, "\tspread\t%s\t\n", spread)
+
+	for _, level := range bids {
+		fmt.Fprintf(tw, "%d\t%d\t%.2f\t\n", level.Orders, level.Quantity, level.Price)
+	}
+	tw.Flush()
+}
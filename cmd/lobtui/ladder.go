@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"valhalla/client"
+)
+
+const (
+	colorReset = "\033[0m"
+	colorGreen = "\033[32m"
+	colorRed   = "\033[31m"
+	colorDim   = "\033[2m"
+)
+
+// ladderWidth is the visible width of one ladder row, used to align the trade panel
+const ladderWidth = 38
+
+// ladderView renders the order book as a price ladder next to recent trades
+type ladderView struct {
+	levels int
+	trades int
+	color  bool
+}
+
+// render builds the full screen for one refresh
+func (v ladderView) render(bids, asks []client.PriceLevel, trades []client.Trade, now time.Time) []string {
+	lines := []string{v.header(bids, asks, trades, now), ""}
+
+	ladder := v.ladder(bids, asks)
+	tape := v.tape(trades)
+	for i := 0; i < len(ladder) || i < len(tape); i++ {
+		left := strings.Repeat(" ", ladderWidth)
+		if i < len(ladder) {
+			left = ladder[i]
+		}
+		right := ""
+		if i < len(tape) {
+			right = tape[i]
+		}
+		lines = append(lines, left+"    "+right)
+	}
+
+	return append(lines, "", v.paint(colorDim, "ctrl-c to quit"))
+}
+
+// header summarizes the top of book, spread and last trade
+func (v ladderView) header(bids, asks []client.PriceLevel, trades []client.Trade, now time.Time) string {
+	bid, ask, spread, last := "-", "-", "-", "-"
+	if len(bids) > 0 {
+		bid = fmt.Sprintf("%.2f", bids[0].Price)
+	}
+	if len(asks) > 0 {
+		ask = fmt.Sprintf("%.2f", asks[0].Price)
+	}
+	if len(bids) > 0 && len(asks) > 0 {
+		spread = fmt.Sprintf("%.2f", asks[0].Price-bids[0].Price)
+	}
+	if len(trades) > 0 {
+		last = fmt.Sprintf("%.2f", trades[len(trades)-1].Price)
+	}
+	return fmt.Sprintf("bid %s  ask %s  spread %s  last %s  %s", bid, ask, spread, last, now.Format("15:04:05"))
+}
+
+// ladder renders asks (highest first) above bids, with a price column between the two quantity columns
+func (v ladderView) ladder(bids, asks []client.PriceLevel) []string {
+	lines := []string{fmt.Sprintf("%12s %12s %12s", "BID QTY", "PRICE", "ASK QTY")}
+
+	for i := len(asks) - 1; i >= 0; i-- {
+		row := fmt.Sprintf("%12s %12.2f %12d", "", asks[i].Price, asks[i].Quantity)
+		lines = append(lines, v.paint(colorRed, row))
+	}
+	lines = append(lines, v.paint(colorDim, fmt.Sprintf("%12s %12s %12s", "", "------", "")))
+	for _, level := range bids {
+		row := fmt.Sprintf("%12d %12.2f %12s", level.Quantity, level.Price, "")
+		lines = append(lines, v.paint(colorGreen, row))
+	}
+	return lines
+}
+
+// tape renders the most recent trades, newest first, colored by tick direction
+func (v ladderView) tape(trades []client.Trade) []string {
+	lines := []string{fmt.Sprintf("%-12s %10s %8s", "TIME", "PRICE", "QTY")}
+
+	start := 0
+	if v.trades > 0 && len(trades) > v.trades {
+		start = len(trades) - v.trades
+	}
+	for i := len(trades) - 1; i >= start; i-- {
+		trade := trades[i]
+		row := fmt.Sprintf("%-12s %10.2f %8d", trade.CreatedAt.Format("15:04:05.000"), trade.Price, trade.Quantity)
+
+		color := ""
+		if i > 0 && trade.Price > trades[i-1].Price {
+			color = colorGreen
+		} else if i > 0 && trade.Price < trades[i-1].Price {
+			color = colorRed
+		}
+		lines = append(lines, v.paint(color, row))
+	}
+	return lines
+}
+
+// paint wraps text in an ANSI color when colors are enabled
+func (v ladderView) paint(color, text string) string {
+	if !v.color || color == "" {
+		return text
+	}
+	return color + text + colorReset
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"valhalla/client"
+)
+
+func TestLadderView_Render(t *testing.T) {
+	book := &client.OrderBook{
+		BuyOrders: []client.Order{
+			{Price: 99.0, Quantity: 5},
+			{Price: 99.0, Quantity: 5},
+			{Price: 98.0, Quantity: 1},
+		},
+		SellOrders: []client.Order{
+			{Price: 101.0, Quantity: 7},
+		},
+	}
+	trades := []client.Trade{
+		{Price: 100.0, Quantity: 2},
+		{Price: 100.5, Quantity: 3},
+	}
+
+	view := ladderView{levels: 10, trades: 10}
+	bids, asks := client.AggregateDepth(book, view.levels)
+	lines := view.render(bids, asks, trades, time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+
+	if !strings.Contains(lines[0], "bid 99.00") || !strings.Contains(lines[0], "ask 101.00") {
+		t.Errorf("Expected top of book in header, got %q", lines[0])
+	}
+
+	if !strings.Contains(lines[0], "spread 2.00") || !strings.Contains(lines[0], "last 100.50") {
+		t.Errorf("Expected spread and last price in header, got %q", lines[0])
+	}
+
+	screen := strings.Join(lines, "\n")
+	if !strings.Contains(screen, "10        99.00") {
+		t.Errorf("Expected aggregated 99.00 bid level of 10, got:\n%s", screen)
+	}
+
+	if strings.Contains(screen, "\033[") {
+		t.Error("Expected no ANSI codes when color is disabled")
+	}
+}
+
+func TestLadderView_AsksAboveBids(t *testing.T) {
+	book := &client.OrderBook{
+		BuyOrders:  []client.Order{{Price: 99.0, Quantity: 1}},
+		SellOrders: []client.Order{{Price: 101.0, Quantity: 1}, {Price: 102.0, Quantity: 1}},
+	}
+
+	lines := ladderView{levels: 10}.ladder(client.AggregateDepth(book, 10))
+
+	// header, 102 ask, 101 ask, separator, 99 bid
+	if len(lines) != 5 {
+		t.Fatalf("Expected 5 ladder lines, got %d", len(lines))
+	}
+
+	if !strings.Contains(lines[1], "102.00") || !strings.Contains(lines[2], "101.00") || !strings.Contains(lines[4], "99.00") {
+		t.Errorf("Unexpected ladder order:\n%s", strings.Join(lines, "\n"))
+	}
+}
+
+func TestLadderView_RendersStreamedDepth(t *testing.T) {
+	book := client.NewDepthBook(&client.DepthSnapshot{
+		Sequence: 4,
+		Bids:     []client.PriceLevel{{Price: 99.0, Quantity: 3}, {Price: 98.0, Quantity: 2}},
+		Asks:     []client.PriceLevel{{Price: 101.0, Quantity: 4}},
+	})
+	// The best bid leaves and a better ask arrives
+	if err := book.Apply(client.DepthUpdate{Type: client.DepthMessageUpdate, Sequence: 5,
+		Bids: []client.PriceLevel{{Price: 99.0}}, Asks: []client.PriceLevel{{Price: 100.5, Quantity: 1}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	view := ladderView{levels: 10}
+	lines := view.render(book.Bids(view.levels), book.Asks(view.levels), nil, time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	if !strings.Contains(lines[0], "bid 98.00") || !strings.Contains(lines[0], "ask 100.50") || !strings.Contains(lines[0], "spread 2.50") {
+		t.Errorf("Expected the updated top of book in the header, got %q", lines[0])
+	}
+	if screen := strings.Join(lines, "\n"); strings.Contains(screen, "99.00") {
+		t.Errorf("Expected the removed level gone, got:\n%s", screen)
+	}
+}
+
+func TestLadderView_TapeLimitsTrades(t *testing.T) {
+	trades := []client.Trade{{Price: 1}, {Price: 2}, {Price: 3}}
+
+	lines := ladderView{trades: 2}.tape(trades)
+
+	// header plus the two newest trades
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 tape lines, got %d", len(lines))
+	}
+
+	if !strings.Contains(lines[1], "3.00") {
+		t.Errorf("Expected newest trade first, got %q", lines[1])
+	}
+}
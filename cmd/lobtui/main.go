@@ -0,0 +1,101 @@
+// Command lobtui shows a live ladder of the order book alongside the latest trades.
+//
+// Usage:
+//
+//	lobtui [-addr URL] [-api-key KEY] [-symbol S] [-levels N] [-trades N] [-no-color]
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"time"
+
+	"valhalla/client"
+)
+
+func main() {
+	addr := flag.String("addr", "http://localhost:8080", "order book server address")
+	symbol := flag.String("symbol", "", "symbol to show (defaults to the server's default symbol)")
+	levels := flag.Int("levels", 10, "price levels to show per side")
+	tradeCount := flag.Int("trades", 15, "number of recent trades to show")
+	noColor := flag.Bool("no-color", false, "disable ANSI colors")
+	apiKey := flag.String("api-key", os.Getenv("VALHALLA_API_KEY"), "API key for servers that require one (defaults to $VALHALLA_API_KEY)")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	view := ladderView{levels: *levels, trades: *tradeCount, color: !*noColor}
+	c := client.New(*addr)
+	c.APIKey = *apiKey
+	if err := run(ctx, c, *symbol, view); err != nil && !errors.Is(err, context.Canceled) {
+		fmt.Fprintf(os.Stderr, "lobtui: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run redraws the screen until ctx is cancelled. The ladder follows the depth
+// stream, which resyncs from a snapshot on a gap, and the trades come from
+// the symbol's public feed as they happen.
+func run(ctx context.Context, c *client.Client, symbol string, view ladderView) error {
+	// Hide the cursor while drawing and restore it on exit
+	fmt.Print("\033[?25l")
+	defer fmt.Print("\033[?25h\n")
+
+	var (
+		mu         sync.Mutex
+		bids, asks []client.PriceLevel
+		trades     []client.Trade
+	)
+	changed := make(chan struct{}, 1)
+	redraw := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+	streamErr := make(chan error, 2)
+	go func() {
+		streamErr <- c.StreamDepth(ctx, symbol, func(book *client.DepthBook) {
+			mu.Lock()
+			bids, asks = book.Bids(view.levels), book.Asks(view.levels)
+			mu.Unlock()
+			redraw()
+		})
+	}()
+	go func() {
+		streamErr <- c.StreamTrades(ctx, symbol, func(trade client.Trade) {
+			mu.Lock()
+			// Only the trades the tape shows are kept
+			if trades = append(trades, trade); view.trades > 0 && len(trades) > view.trades {
+				trades = slices.Delete(trades, 0, len(trades)-view.trades)
+			}
+			mu.Unlock()
+			redraw()
+		})
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-streamErr:
+			return err
+		case <-changed:
+		}
+
+		mu.Lock()
+		screen := view.render(bids, asks, trades, time.Now())
+		mu.Unlock()
+		fmt.Print("\033[H\033[2J")
+		for _, line := range screen {
+			fmt.Println(line)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+// validateFillConditions checks that minimum quantity and all-or-none fit the
+// order; time_in_force and the sign of min_quantity are checked by their tags
+func validateFillConditions(req PlaceOrderRequest) []FieldError {
+	var errs []FieldError
+
+	if req.MinQuantity > req.Quantity {
+		errs = append(errs, fieldError("min_quantity", "lte", "min_quantity cannot exceed quantity (received: %d)", req.MinQuantity))
+	}
+
+	if req.AllOrNone && req.MinQuantity > 0 {
+		errs = append(errs, fieldError("all_or_none", "exclusive", "set only one of min_quantity and all_or_none"))
+	}
+
+	return errs
+}
+
+// requiredFill returns how much an order must fill in its arrival matching
+// event, or zero when it has no such condition
+func requiredFill(order Order) int {
+	if order.AllOrNone {
+		return order.Quantity
+	}
+	return order.MinQuantity
+}
+
+// availableLiquidity adds up the opposite-side quantity an order could trade
+// against, stopping once it reaches limit
+func availableLiquidity(book *OrderBook, order Order, limit int) int {
+	opposite := &book.asks
+	if order.Side == SideSell {
+		opposite = &book.bids
+	}
+
+	available := 0
+	for _, resting := range opposite.orders() {
+		if !priceCrosses(order, resting) || available >= limit {
+			break
+		}
+		available += resting.Quantity
+	}
+	return available
+}
+
+// priceCrosses reports whether an incoming order's limit reaches a resting order's price
+func priceCrosses(order, resting Order) bool {
+	if order.Type == OrderTypeMarket {
+		return true
+	}
+	if order.Side == SideBuy {
+		return order.Price >= resting.Price
+	}
+	return order.Price <= resting.Price
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// restAsks puts sell orders on the default book at the given prices, each for quantity
+func restAsks(quantity int, prices ...float64) {
+	for i, price := range prices {
+		restOn(&bookFor("").asks, Order{
+			ID:        fmt.Sprintf("ask-%d", i+1),
+			Side:      SideSell,
+			Price:     price,
+			Quantity:  quantity,
+			Status:    OrderStatusPending,
+			CreatedAt: time.Now(),
+		})
+	}
+}
+
+func TestMinQuantity_MetFillsAndRests(t *testing.T) {
+	setupTest()
+	restAsks(3, 100.0, 100.5)
+
+	result := processOrder(Order{ID: "buy-1", Side: SideBuy, Price: 101.0, Quantity: 10, MinQuantity: 5, Status: OrderStatusPending, CreatedAt: time.Now()})
+
+	if result.Status != OrderStatusPartiallyFilled || result.Quantity != 4 {
+		t.Errorf("Expected 4 left after filling 6, got %s with %d", result.Status, result.Quantity)
+	}
+
+	if len(bookFor("").bids.orders()) != 1 || bookFor("").bids.orders()[0].ID != "buy-1" {
+		t.Errorf("Expected the remainder to rest, got %+v", bookFor("").bids.orders())
+	}
+}
+
+func TestMinQuantity_NotMetIsRejected(t *testing.T) {
+	setupTest()
+	restAsks(3, 100.0, 102.0)
+
+	result := processOrder(Order{ID: "buy-1", Side: SideBuy, Price: 101.0, Quantity: 10, MinQuantity: 5, Status: OrderStatusPending, CreatedAt: time.Now()})
+
+	if result.Status != OrderStatusRejected || result.RejectReason != ErrCodeMinQuantityNotMet {
+		t.Errorf("Expected MIN_QUANTITY_NOT_MET rejection, got %s %s", result.Status, result.RejectReason)
+	}
+
+	if len(tradeStore.List("")) != 0 || len(bookFor("").asks.orders()) != 2 || bookFor("").asks.orders()[0].Quantity != 3 {
+		t.Errorf("Expected the book to be untouched, got %d trades and %+v", len(tradeStore.List("")), bookFor("").asks.orders())
+	}
+}
+
+func TestMinQuantity_NoCrossingLiquidityRests(t *testing.T) {
+	setupTest()
+	restAsks(3, 102.0)
+
+	result := processOrder(Order{ID: "buy-1", Side: SideBuy, Price: 101.0, Quantity: 10, MinQuantity: 5, Status: OrderStatusPending, CreatedAt: time.Now()})
+
+	if result.Status != OrderStatusPending || len(bookFor("").bids.orders()) != 1 {
+		t.Errorf("Expected a GTC order to rest, got %s with %d bids", result.Status, len(bookFor("").bids.orders()))
+	}
+}
+
+func TestAllOrNone(t *testing.T) {
+	setupTest()
+	restAsks(5, 100.0, 100.5, 101.0)
+
+	result := processOrder(Order{ID: "buy-1", Side: SideBuy, Price: 100.5, Quantity: 11, AllOrNone: true, Status: OrderStatusPending, CreatedAt: time.Now()})
+	if result.Status != OrderStatusRejected {
+		t.Errorf("Expected an AON order for 11 against 10 to be rejected, got %s", result.Status)
+	}
+
+	result = processOrder(Order{ID: "buy-2", Side: SideBuy, Price: 100.5, Quantity: 10, AllOrNone: true, Status: OrderStatusPending, CreatedAt: time.Now()})
+	if result.Status != OrderStatusFilled || len(tradeStore.List("")) != 2 {
+		t.Errorf("Expected an AON order for 10 to fill across two levels, got %s with %d trades", result.Status, len(tradeStore.List("")))
+	}
+}
+
+func TestImmediateOrCancel(t *testing.T) {
+	setupTest()
+	restAsks(4, 100.0)
+
+	result := processOrder(Order{ID: "buy-1", Side: SideBuy, Price: 100.0, Quantity: 10, TimeInForce: TimeInForceIOC, Status: OrderStatusPending, CreatedAt: time.Now()})
+
+	if result.Status != OrderStatusCancelled || result.Quantity != 6 {
+		t.Errorf("Expected the unfilled 6 to be cancelled, got %s with %d", result.Status, result.Quantity)
+	}
+
+	if len(bookFor("").bids.orders()) != 0 {
+		t.Errorf("Expected an IOC order never to rest, got %+v", bookFor("").bids.orders())
+	}
+
+	result = processOrder(Order{ID: "buy-2", Side: SideBuy, Price: 100.0, Quantity: 1, TimeInForce: TimeInForceIOC, Status: OrderStatusPending, CreatedAt: time.Now()})
+	if result.Status != OrderStatusRejected || result.RejectReason != ErrCodeNoLiquidity {
+		t.Errorf("Expected an unfillable IOC order to be rejected with NO_LIQUIDITY, got %s %s", result.Status, result.RejectReason)
+	}
+}
+
+func TestAllOrNoneImmediateOrCancel_NoLiquidity(t *testing.T) {
+	setupTest()
+
+	result := processOrder(Order{ID: "buy-1", Side: SideBuy, Price: 100.0, Quantity: 10, AllOrNone: true, TimeInForce: TimeInForceIOC, Status: OrderStatusPending, CreatedAt: time.Now()})
+
+	if result.Status != OrderStatusRejected || result.RejectReason != ErrCodeMinQuantityNotMet {
+		t.Errorf("Expected MIN_QUANTITY_NOT_MET rejection, got %s %s", result.Status, result.RejectReason)
+	}
+}
+
+func TestPlaceOrderHandler_MinQuantityRejection(t *testing.T) {
+	setupTest()
+	restAsks(1, 100.0)
+
+	jsonData, _ := json.Marshal(PlaceOrderRequest{Side: SideBuy, Price: 100.0, Quantity: 5, AllOrNone: true})
+	request := httptest.NewRequest("POST", "/api/v1/orders", bytes.NewBuffer(jsonData))
+	response := httptest.NewRecorder()
+	placeOrderHandler(response, request)
+
+	if response.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422, got %d", response.Code)
+	}
+
+	if result := decodeError(t, response); result.Error.Code != ErrCodeMinQuantityNotMet {
+		t.Errorf("Expected code %s, got %s", ErrCodeMinQuantityNotMet, result.Error.Code)
+	}
+}
+
+func TestPlaceOrderHandler_FillConditionValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		req  PlaceOrderRequest
+	}{
+		{"min above quantity", PlaceOrderRequest{Side: SideBuy, Price: 100, Quantity: 5, MinQuantity: 6}},
+		{"negative min", PlaceOrderRequest{Side: SideBuy, Price: 100, Quantity: 5, MinQuantity: -1}},
+		{"min and aon", PlaceOrderRequest{Side: SideBuy, Price: 100, Quantity: 5, MinQuantity: 2, AllOrNone: true}},
+		{"unknown tif", PlaceOrderRequest{Side: SideBuy, Price: 100, Quantity: 5, TimeInForce: "FOK"}},
+	}
+
+	for _, test := range tests {
+		setupTest()
+
+		jsonData, _ := json.Marshal(test.req)
+		request := httptest.NewRequest("POST", "/api/v1/orders", bytes.NewBuffer(jsonData))
+		response := httptest.NewRecorder()
+		placeOrderHandler(response, request)
+
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", test.name, response.Code)
+		}
+	}
+}
@@ -0,0 +1,795 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Config holds the server settings parsed from command-line flags
+type Config struct {
+	Addr string
+	// Symbols lists the tradable symbols; the first one is the default
+	Symbols []string
+	Bots    BotConfig
+
+	// Auctions maps the symbols matched in frequent batch auctions to their
+	// interval; every other symbol matches continuously
+	Auctions map[string]time.Duration
+	// Ladders are the bounded price grids symbols keep their levels on
+	Ladders map[string]LadderConfig
+	Marks   MarkConfig
+	Index   IndexConfig
+	// Shadow mirrors outside exchanges into read-only symbols
+	Shadow ShadowConfig
+	// Perpetuals are the symbols traded as perpetual swaps
+	Perpetuals PerpetualConfig
+	Margin     MarginConfig
+	// PositionLimits cap each account's net position by symbol
+	PositionLimits PositionLimitConfig
+	// Options maps option symbols to their terms
+	Options map[string]OptionSpec
+	// Spreads maps spread symbols to the legs their orders are implied into
+	Spreads map[string]Spread
+	// Pairs say what the symbols whose name does not say it trade, and in
+	// what lots
+	Pairs map[string]Pair
+
+	// RouterURL is the venue adapter unfilled remainders are posted to; empty disables routing
+	RouterURL     string
+	RouterTimeout time.Duration
+	// Credit is the external credit check every order must pass
+	Credit CreditConfig
+	// Webhooks posts every fill to an endpoint, dead-lettering what it refuses
+	Webhooks WebhookConfig
+
+	// Fees are charged on trades between two accounts
+	Fees FeeSchedule
+	// ReferralShare is the fraction of each fee paid to the payer's referrer
+	ReferralShare float64
+	// Throttle limits each account's order messages
+	Throttle ThrottleConfig
+	// Queue bounds the commands waiting for each matcher
+	Queue        QueueConfig
+	Surveillance SurveillanceConfig
+	// Volatility interrupts continuous trading on fast price moves
+	Volatility VolatilityConfig
+	// Calendars are the trading phases each group of symbols goes through
+	Calendars []TradingCalendar
+	// UncrossWindow is how long after their scheduled end call auctions may
+	// run on before uncrossing at a random instant
+	UncrossWindow time.Duration
+	// SimulationStart is where the virtual clock starts, paused, when the
+	// engine runs on one; zero runs it on the wall clock
+	SimulationStart time.Time
+	// SeedDepth is the depth the books start with
+	SeedDepth []DepthSnapshot
+	// Replay is the market data file replayed through the books
+	Replay ReplayConfig
+	// Strategies are the registered strategies run in-process
+	Strategies StrategyConfig
+	// Scripts are the Lua pre-trade checks every order goes through
+	Scripts ScriptConfig
+
+	Archive     ArchiveConfig
+	BookHistory BookHistoryConfig
+	HTTP        HTTPConfig
+	TLS         TLSConfig
+	Replication ReplicationConfig
+	Redis       RedisConfig
+	Tracing     TracingConfig
+	// Streams controls what the streams do with clients that fall behind
+	Streams StreamConfig
+	// ReadModel serves order, trade and candle reads from a projection
+	// instead of the matchers and the trade store
+	ReadModel bool
+	// SequenceFile keeps the sequences and IDs the engine hands out across
+	// restarts
+	SequenceFile string
+}
+
+// ArchiveConfig controls where old trades and order events are archived
+type ArchiveConfig struct {
+	// Dir archives to a local directory
+	Dir string
+	// S3Endpoint and S3Bucket archive to S3-compatible storage instead, with
+	// credentials from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY
+	S3Endpoint string
+	S3Bucket   string
+	S3Region   string
+
+	Retention time.Duration
+	Interval  time.Duration
+}
+
+// BotConfig controls the built-in market-maker and taker bots
+type BotConfig struct {
+	Enabled bool
+	Seed    int64
+	// Symbol is the book the bots trade on; empty means the default symbol
+	Symbol string
+
+	// Market maker
+	StartPrice    float64
+	Volatility    float64
+	Spread        float64
+	LevelStep     float64
+	Levels        int
+	QuoteQuantity int
+	QuoteInterval time.Duration
+
+	// Taker
+	TakerMaxQuantity int
+	TakerInterval    time.Duration
+}
+
+// loadConfig parses the server flags from args
+func loadConfig(args []string) (Config, error) {
+	var cfg Config
+	fs := flag.NewFlagSet("valhalla", flag.ContinueOnError)
+
+	fs.StringVar(&cfg.Addr, "addr", ":8080", "address to listen on")
+	symbols := fs.String("symbols", defaultSymbol, "comma-separated tradable symbols; the first is the default")
+	auctions := fs.String("batch-auctions", "", "comma-separated symbol=interval pairs matched in batch auctions, e.g. BTC-USD=100ms")
+	ladders := fs.String("tick-ladders", "", "comma-separated symbol=tick:min:max price grids whose levels are held in an array by tick, e.g. BTC-USD=0.01:90:110")
+	markSources := fs.String("mark-sources", "", "comma-separated symbol=source pairs choosing last_trade, mid_ema or index mark prices (default last_trade)")
+	fs.Float64Var(&cfg.Marks.EMAAlpha, "mark-ema-alpha", 0.2, "weight of each new mid sample in the mid_ema mark price, between 0 and 1")
+	perpetuals := fs.String("perpetuals", "", "comma-separated symbol=interval pairs traded as perpetual swaps that pay funding every interval, e.g. BTC-PERP=8h")
+	fs.Float64Var(&cfg.Perpetuals.RateCap, "funding-rate-cap", 0.0075, "largest funding rate charged per interval, as a fraction of the position's value")
+	pairList := fs.String("pairs", "", "comma-separated symbol=base/quote[:lot] pairs for spot symbols whose name is not their pair or whose quantities are lots of base, e.g. DEFAULT=BTC/USD:0.001")
+	spreadList := fs.String("spreads", "", "comma-separated symbol=buy-leg/sell-leg spreads whose orders also fill against the prices their legs imply, e.g. BTC-CAL=BTC-JUN/BTC-MAR")
+	optionSpecs := fs.String("options", "", "comma-separated symbol=underlying:type:strike:expiry options settled against the underlying's mark at expiry, e.g. BTC-70000-C=BTC-USD:call:70000:2026-12-31T08:00:00Z")
+	fs.Float64Var(&cfg.Margin.MaxLeverage, "max-leverage", 10, "highest leverage an account may hold perpetual positions at, and the leverage accounts start at")
+	fs.Float64Var(&cfg.Margin.LiquidationFeeBps, "liquidation-fee-bps", 0, "fee paid into the insurance fund on liquidation fills, in basis points of their notional")
+	limits := fs.String("position-limits", "", "comma-separated symbol=lots caps on the net position an account may build, counting its resting orders, e.g. BTC-PERP=100")
+	fs.BoolVar(&cfg.PositionLimits.Trim, "position-limit-trim", false, "cut orders that would pass a position limit down to what fits instead of rejecting them")
+	fs.Float64Var(&cfg.Margin.MaintenanceRate, "maintenance-margin", 0.05, "equity an account must keep, as a fraction of its perpetual positions' value, before it is liquidated")
+	indexURLs := fs.String("index-urls", "", "comma-separated symbol=url pairs whose index prices are polled over HTTP")
+	fs.StringVar(&cfg.Index.Field, "index-field", "price", "dotted path to the price in each -index-urls response, e.g. data.amount")
+	shadowFeeds := fs.String("shadow", "", "comma-separated symbol=exchange:product pairs mirrored read-only from a public exchange feed, e.g. BTC-USD=binance:btcusdt,ETH-USD=coinbase:ETH-USD")
+	fs.Float64Var(&cfg.Shadow.Lot, "shadow-lot", 0.0001, "exchange quantity one unit of a shadow symbol's quantity stands for")
+	binanceURL := fs.String("shadow-binance-url", defaultShadowURLs["binance"], "Binance WebSocket endpoint for -shadow")
+	coinbaseURL := fs.String("shadow-coinbase-url", defaultShadowURLs["coinbase"], "Coinbase Exchange WebSocket feed for -shadow")
+	fs.BoolVar(&cfg.Shadow.Paper, "paper", false, "paper-trade -shadow symbols, filling orders against the exchange's depth and trades")
+	fs.DurationVar(&cfg.Index.Interval, "index-interval", 5*time.Second, "how often to poll -index-urls")
+	fs.DurationVar(&cfg.Index.Timeout, "index-timeout", 2*time.Second, "how long to wait for an index source")
+
+	corsOrigins := fs.String("cors-origins", "*", "comma-separated browser origins allowed to call the API; * allows any")
+	apiKeys := fs.String("api-keys", os.Getenv("VALHALLA_API_KEYS"), "comma-separated API keys required on every endpoint (defaults to $VALHALLA_API_KEYS); empty leaves the API open")
+	entitlements := fs.String("entitlements", "", "comma-separated key=level market data entitlements, where level is top, l2 or l3")
+	defaultEntitlement := fs.String("default-entitlement", "", "entitlement for API keys not in -entitlements and for requests without one (defaults to l3)")
+	dropCopyKeys := fs.String("drop-copy-keys", "", "comma-separated API keys allowed to follow the drop copy of every owner's orders and fills")
+	keyRoles := fs.String("key-roles", "", "comma-separated key=role+role grants, where each role is read, trade, admin or surveillance")
+	defaultRoles := fs.String("default-roles", "", "roles, joined with +, of keys not in -key-roles and of requests without a key; every role when empty")
+	apiSecrets := fs.String("api-secrets", os.Getenv("VALHALLA_API_SECRETS"), "comma-separated key=secret pairs; keys listed must HMAC-sign requests that place, amend or cancel orders (defaults to $VALHALLA_API_SECRETS)")
+	fs.DurationVar(&cfg.HTTP.SignatureWindow, "signature-window", 5*time.Second, "how far a signed request's timestamp may be from the server's clock; its nonce may not be reused within it")
+	keyAllowlists := fs.String("key-allowlists", "", "comma-separated key=cidr+cidr pairs binding API keys to the networks they may be used from, e.g. desk=10.0.0.0/8+192.0.2.7")
+	trustedProxies := fs.String("trusted-proxies", "", "comma-separated CIDRs of proxies whose X-Forwarded-For names the client address -key-allowlists checks")
+	keyOwners := fs.String("key-owners", "", "comma-separated key=owner pairs naming the order owner each API key acts for on the private feed")
+	var jwt JWTConfig
+	fs.StringVar(&jwt.Issuer, "jwt-issuer", "", "also accept bearer JWTs whose iss claim is this issuer; empty accepts only -api-keys")
+	fs.StringVar(&jwt.JWKSURL, "jwt-jwks-url", "", "JSON Web Key Set the issuer signs tokens with (defaults to <issuer>/.well-known/jwks.json)")
+	fs.StringVar(&jwt.Audience, "jwt-audience", "", "audience every token's aud claim must include; empty accepts any")
+	fs.StringVar(&jwt.AccountClaim, "jwt-account-claim", "sub", "claim naming the order owner a token acts for")
+	fs.StringVar(&jwt.RolesClaim, "jwt-roles-claim", "roles", "claim listing a token's roles; tokens without it get -default-roles")
+	fs.DurationVar(&jwt.Refresh, "jwt-jwks-refresh", time.Hour, "how long fetched signing keys are used before they are fetched again")
+	fs.BoolVar(&cfg.HTTP.LogRequests, "log-requests", true, "log one line per HTTP request")
+	fs.BoolVar(&cfg.HTTP.Compress, "gzip", true, "gzip responses for clients that accept it")
+	fs.StringVar(&cfg.Tracing.Endpoint, "otlp-endpoint", defaultOTLPEndpoint(), "OTLP/HTTP traces URL to export spans to, e.g. http://localhost:4318/v1/traces (defaults from $OTEL_EXPORTER_OTLP_TRACES_ENDPOINT or $OTEL_EXPORTER_OTLP_ENDPOINT); empty turns tracing off")
+	fs.StringVar(&cfg.Tracing.Service, "otlp-service", defaultOTLPService(), "service.name exported spans carry (defaults to $OTEL_SERVICE_NAME)")
+	fs.Float64Var(&cfg.Tracing.SampleRatio, "otlp-sample", 1, "fraction of new traces recorded; requests with a traceparent follow their caller")
+	fs.DurationVar(&cfg.Tracing.Interval, "otlp-interval", time.Second, "how often to export spans")
+	fs.DurationVar(&cfg.Tracing.Timeout, "otlp-timeout", 5*time.Second, "how long to wait for the collector")
+	fs.BoolVar(&cfg.Streams.Conflate, "stream-conflate", true, "fold the depth updates a slow stream client has no room for into one, instead of telling it to resync")
+	fs.DurationVar(&cfg.Streams.SlowTimeout, "slow-consumer-timeout", defaultSlowConsumerTimeout, "disconnect stream clients that stay behind this long; 0 keeps them connected")
+	fs.BoolVar(&cfg.ReadModel, "read-model", false, "serve account orders, trades and candles from a read model projected off the matchers")
+	fs.StringVar(&cfg.SequenceFile, "sequence-file", "", "file the sequence numbers and IDs are saved to on shutdown and resumed from on startup; empty starts a new epoch every time")
+	fs.DurationVar(&cfg.HTTP.RequestTimeout, "request-timeout", defaultRequestTimeout, "how long a request may wait on the engine before a 504; 0 waits as long as it takes")
+
+	fs.StringVar(&cfg.TLS.CertFile, "tls-cert", "", "serve HTTPS and HTTP/2 with this PEM certificate")
+	fs.StringVar(&cfg.TLS.KeyFile, "tls-key", "", "PEM private key for -tls-cert")
+	autocertDomains := fs.String("autocert-domains", "", "comma-separated host names to get Let's Encrypt certificates for")
+	fs.StringVar(&cfg.TLS.AutocertCache, "autocert-cache", "autocert-cache", "directory that keeps certificates from -autocert-domains")
+	fs.StringVar(&cfg.TLS.AutocertEmail, "autocert-email", "", "contact address for the Let's Encrypt account")
+	fs.StringVar(&cfg.TLS.RedirectAddr, "http-redirect-addr", "", "with TLS, also listen here for plain HTTP, redirecting to HTTPS and answering ACME challenges (e.g. :80)")
+
+	fs.StringVar(&cfg.RouterURL, "router-url", "", "post unfilled remainders to this venue adapter URL")
+	fs.DurationVar(&cfg.RouterTimeout, "router-timeout", 2*time.Second, "how long to wait for the venue adapter")
+	fs.StringVar(&cfg.Credit.URL, "credit-url", "", "post every order to this credit service before it can trade; empty turns the credit check off")
+	fs.DurationVar(&cfg.Credit.Timeout, "credit-timeout", 200*time.Millisecond, "how long to wait for the credit service before applying -credit-fail-open")
+	fs.BoolVar(&cfg.Credit.FailOpen, "credit-fail-open", false, "let orders through when the credit service fails or times out, instead of refusing them")
+	fs.StringVar(&cfg.Webhooks.URL, "webhook-url", "", "post every fill to this URL as JSON; empty turns the fill webhook off")
+	fs.DurationVar(&cfg.Webhooks.Timeout, "webhook-timeout", 5*time.Second, "how long to wait for the fill webhook to answer")
+	fs.IntVar(&cfg.Webhooks.Attempts, "webhook-attempts", 5, "how many times a fill is posted before it is dead-lettered")
+	fs.DurationVar(&cfg.Webhooks.RetryDelay, "webhook-retry-delay", 500*time.Millisecond, "wait before retrying a fill the webhook did not take, doubling after each retry")
+	fs.IntVar(&cfg.Webhooks.SchemaVersion, "webhook-schema-version", currentSchemaVersion, "schema version fills are posted to the webhook in, for receivers written against an older one")
+	fs.IntVar(&cfg.Webhooks.DeadLetters, "dead-letter-limit", defaultDeadLetterLimit, "most undelivered fills kept for /admin/dead-letters; past it the oldest are dropped")
+
+	fs.Float64Var(&cfg.Fees.MakerBps, "maker-fee-bps", 0, "fee charged to the maker of a trade between accounts, in basis points of its notional; negative pays a rebate")
+	fs.Float64Var(&cfg.Fees.TakerBps, "taker-fee-bps", 0, "fee charged to the taker of a trade between accounts, in basis points of its notional")
+	feeTiers := fs.String("fee-tiers", "", "comma-separated volume=maker_bps:taker_bps tiers that replace the base fees once an account's volume reaches them, e.g. 1000000=8:18")
+	fs.DurationVar(&cfg.Fees.Window, "fee-volume-window", defaultFeeWindow, "how far back an account's traded notional counts towards its fee tier")
+	fs.Float64Var(&cfg.ReferralShare, "referral-share", 0, "fraction of each trading fee paid to the account that referred the payer; 0 turns referral commission off")
+
+	fs.Float64Var(&cfg.Throttle.Rate, "order-rate", 0, "order message weight each account may send per second; 0 turns throttling off")
+	fs.Float64Var(&cfg.Throttle.Burst, "order-burst", 0, "most order message weight an account can save up (default one second at -order-rate)")
+	throttleWeights := fs.String("throttle-weights", "", "comma-separated action=weight pairs over the default place=1,amend=1,cancel=0.5,quote=1")
+	fs.IntVar(&cfg.Queue.Depth, "queue-depth", defaultQueueDepth, "commands that may wait for each matcher before new orders and mass quotes are refused with ENGINE_BUSY")
+	fs.DurationVar(&cfg.Queue.RetryAfter, "busy-retry-after", time.Second, "how long ENGINE_BUSY refusals tell senders to wait before retrying")
+
+	fs.BoolVar(&cfg.Surveillance.Enabled, "surveillance", false, "raise surveillance alerts on wash trading, high cancel-to-fill ratios and layering")
+	fs.DurationVar(&cfg.Surveillance.Window, "surveillance-window", 10*time.Minute, "how far back surveillance looks at trades, fills and cancels")
+	fs.Float64Var(&cfg.Surveillance.CancelRatio, "cancel-fill-ratio", 20, "cancels per fill that raise a surveillance alert, once an owner has cancelled 10 orders in the window")
+	fs.IntVar(&cfg.Surveillance.LayerLevels, "layering-levels", 3, "price levels one owner may hold on a side near the best price before a layering alert")
+	fs.Float64Var(&cfg.Surveillance.LayerDistance, "layering-distance", 0.005, "how near the best price an order counts towards layering, as a fraction of the price")
+
+	fs.Float64Var(&cfg.Volatility.Move, "volatility-move", 0, "fraction the trade price may move within -volatility-window before trading is interrupted, e.g. 0.05; 0 turns the guard off")
+	fs.DurationVar(&cfg.Volatility.Window, "volatility-window", 30*time.Second, "how far back the volatility guard compares trade prices")
+	fs.DurationVar(&cfg.Volatility.Pause, "volatility-pause", time.Minute, "how long a volatility interruption lasts before trading reopens with an auction")
+	volatilityAction := fs.String("volatility-action", string(VolatilityAuction), "what a volatility interruption does: auction collects orders for the reopening auction, halt refuses them")
+	schedule := fs.String("trading-schedule", "", "comma-separated HH:MM=phase changes each day goes through, with phases closed, pre_open, continuous and post_close, e.g. 08:00=pre_open,09:30=continuous,16:00=post_close,18:00=closed; empty trades continuously")
+	timezone := fs.String("trading-timezone", "UTC", "time zone the -trading-schedule times are in, e.g. America/New_York")
+	fs.DurationVar(&cfg.UncrossWindow, "uncross-window", 0, "window after their scheduled end in which call auctions uncross at a random instant, e.g. 30s; 0 uncrosses them on schedule")
+	simClock := fs.String("sim-clock", "", "run the engine on a virtual clock starting paused at this RFC 3339 time, e.g. 2024-01-02T09:00:00Z, moved through the /admin/clock endpoints; empty runs it on the wall clock")
+	calendarFile := fs.String("trading-calendars", "", "JSON file of trading calendars, each with its symbols, time zone, sessions, weekend, holidays and early closes")
+
+	fs.StringVar(&cfg.Replication.PrimaryURL, "replicate-from", "", "run as a hot standby of the primary at this base URL, e.g. http://primary:8080")
+	fs.StringVar(&cfg.Replication.APIKey, "replicate-api-key", os.Getenv("VALHALLA_API_KEY"), "API key sent to the primary (defaults to $VALHALLA_API_KEY)")
+
+	fs.StringVar(&cfg.Redis.Addr, "redis-addr", "", "mirror the books, trades and order events into the Redis server at host:port")
+	fs.StringVar(&cfg.Redis.Password, "redis-password", os.Getenv("VALHALLA_REDIS_PASSWORD"), "password for -redis-addr (defaults to $VALHALLA_REDIS_PASSWORD)")
+	fs.StringVar(&cfg.Redis.Prefix, "redis-prefix", "valhalla", "prefix for every Redis key and channel")
+	fs.BoolVar(&cfg.Redis.Reader, "redis-reader", false, "serve reads from the state in -redis-addr instead of matching orders")
+
+	fs.StringVar(&cfg.Archive.Dir, "archive-dir", "", "archive old trades and order events to this directory")
+	fs.StringVar(&cfg.Archive.S3Endpoint, "archive-s3-endpoint", "", "archive to this S3-compatible endpoint instead of a directory")
+	fs.StringVar(&cfg.Archive.S3Bucket, "archive-s3-bucket", "", "bucket for -archive-s3-endpoint")
+	fs.StringVar(&cfg.Archive.S3Region, "archive-s3-region", "us-east-1", "region used to sign archive uploads")
+	fs.DurationVar(&cfg.Archive.Retention, "archive-retention", 24*time.Hour, "keep this much history in memory")
+	fs.DurationVar(&cfg.Archive.Interval, "archive-interval", time.Minute, "how often to archive")
+	fs.DurationVar(&cfg.BookHistory.Interval, "book-history-interval", 0, "how often to keep a full depth snapshot of each book for replay, with every change in between; 0 turns book history off")
+	fs.DurationVar(&cfg.BookHistory.Retention, "book-history-retention", 24*time.Hour, "how far back book history is kept")
+
+	seedDepth := fs.String("seed-depth", "", "depth file the books start with, as JSON depth snapshots or CSV symbol,side,price,quantity[,orders] rows")
+	fs.StringVar(&cfg.Replay.Path, "replay", "", "historical market data file to replay through the books: NASDAQ ITCH 5.0, a pcap of it, or CSV")
+	fs.StringVar(&cfg.Replay.Format, "replay-format", "", "format of the -replay file: itch, pcap or csv (defaults from the file's extension, else itch)")
+	fs.Float64Var(&cfg.Replay.Speed, "replay-speed", 1, "how many times faster than recorded -replay runs; 0 replays as fast as possible")
+
+	orderScripts := fs.String("order-scripts", "", "comma-separated Lua files whose check(order, market) function every order must pass before it trades")
+	fs.DurationVar(&cfg.Scripts.Timeout, "order-script-timeout", defaultScriptTimeout, "how long an order script may take over one order before the order is refused")
+
+	strategies := fs.String("strategies", "", "comma-separated symbol=name pairs of registered strategies to run in-process, e.g. BTC-USD=momentum")
+	strategyParams := fs.String("strategy-params", "", "comma-separated name.key=value parameters passed to -strategies")
+	fs.DurationVar(&cfg.Strategies.Interval, "strategy-interval", time.Second, "how often each strategy's OnTimer runs; 0 turns timers off")
+	fs.IntVar(&cfg.Strategies.Depth, "strategy-depth", 10, "levels a side in the depth strategies are sent on book updates; 0 sends every level")
+
+	fs.BoolVar(&cfg.Bots.Enabled, "bots", false, "run the built-in market-maker and taker bots")
+	fs.Int64Var(&cfg.Bots.Seed, "bot-seed", 0, "random seed for the bots (0 uses the current time)")
+	fs.StringVar(&cfg.Bots.Symbol, "bot-symbol", "", "symbol the bots trade (defaults to the default symbol)")
+	fs.Float64Var(&cfg.Bots.StartPrice, "bot-price", 100.0, "starting reference price for the market maker")
+	fs.Float64Var(&cfg.Bots.Volatility, "bot-volatility", 0.001, "per-step standard deviation of the reference price, as a fraction")
+	fs.Float64Var(&cfg.Bots.Spread, "bot-spread", 0.10, "distance between the market maker's best bid and ask")
+	fs.Float64Var(&cfg.Bots.LevelStep, "bot-level-step", 0.05, "price gap between successive quote levels")
+	fs.IntVar(&cfg.Bots.Levels, "bot-levels", 5, "quote levels per side")
+	fs.IntVar(&cfg.Bots.QuoteQuantity, "bot-qty", 10, "maximum quantity per quote")
+	fs.DurationVar(&cfg.Bots.QuoteInterval, "bot-interval", time.Second, "how often the market maker re-quotes")
+	fs.IntVar(&cfg.Bots.TakerMaxQuantity, "taker-qty", 15, "maximum quantity per taker order")
+	fs.DurationVar(&cfg.Bots.TakerInterval, "taker-interval", 700*time.Millisecond, "how often the taker bot crosses the spread")
+
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	if cfg.Archive.S3Endpoint != "" && cfg.Archive.S3Bucket == "" {
+		err := errors.New("-archive-s3-bucket is required with -archive-s3-endpoint")
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+
+	if cfg.BookHistory.Interval < 0 || cfg.BookHistory.Retention <= 0 {
+		err := errors.New("-book-history-interval cannot be negative and -book-history-retention must be positive")
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+
+	if cfg.Fees.TakerBps < 0 || cfg.Fees.MakerBps < -cfg.Fees.TakerBps {
+		err := errors.New("-taker-fee-bps cannot be negative, and a negative -maker-fee-bps cannot rebate more than the taker fee")
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+
+	tiers, err := parseFeeTiers(*feeTiers)
+	if err == nil && cfg.Fees.Window < 24*time.Hour {
+		err = errors.New("-fee-volume-window must be at least 24h")
+	}
+	if err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+	cfg.Fees.Tiers = tiers
+
+	if cfg.ReferralShare < 0 || cfg.ReferralShare > 1 {
+		err := errors.New("-referral-share must be between 0 and 1")
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+
+	if cfg.Margin.LiquidationFeeBps < 0 {
+		err := errors.New("-liquidation-fee-bps cannot be negative")
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+
+	if cfg.Margin.MaxLeverage < 1 || cfg.Margin.MaintenanceRate <= 0 || cfg.Margin.MaintenanceRate*cfg.Margin.MaxLeverage >= 1 {
+		err := errors.New("-max-leverage must be at least 1, and -maintenance-margin positive and below the initial margin at that leverage")
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+
+	weights, err := parseThrottleWeights(*throttleWeights)
+	if err == nil && cfg.Throttle.Burst == 0 {
+		cfg.Throttle.Burst = cfg.Throttle.Rate
+	}
+	for action, weight := range weights {
+		if err == nil && cfg.Throttle.Rate > 0 && weight > cfg.Throttle.Burst {
+			err = fmt.Errorf("-order-burst of %g cannot pay for a %s, which weighs %g", cfg.Throttle.Burst, action, weight)
+		}
+	}
+	if err == nil && (cfg.Throttle.Rate < 0 || cfg.Throttle.Burst < 0) {
+		err = errors.New("-order-rate and -order-burst cannot be negative")
+	}
+	if err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+	cfg.Throttle.Weights = weights
+
+	if cfg.Queue.Depth < 1 || cfg.Queue.RetryAfter <= 0 {
+		err := errors.New("-queue-depth must be at least 1 and -busy-retry-after positive")
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+
+	if cfg.Tracing.SampleRatio < 0 || cfg.Tracing.SampleRatio > 1 || cfg.Tracing.Interval <= 0 || cfg.Tracing.Timeout <= 0 {
+		err := errors.New("-otlp-sample must be between 0 and 1, and -otlp-interval and -otlp-timeout positive")
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+
+	if cfg.Credit.Timeout <= 0 {
+		err := errors.New("-credit-timeout must be positive")
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+
+	if cfg.Webhooks.Timeout <= 0 || cfg.Webhooks.Attempts < 1 || cfg.Webhooks.RetryDelay < 0 || cfg.Webhooks.DeadLetters < 1 {
+		err := errors.New("-webhook-timeout must be positive, -webhook-attempts and -dead-letter-limit at least 1 and -webhook-retry-delay not negative")
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+
+	if cfg.Webhooks.SchemaVersion < minSchemaVersion || cfg.Webhooks.SchemaVersion > currentSchemaVersion {
+		err := fmt.Errorf("-webhook-schema-version must be from %d to %d", minSchemaVersion, currentSchemaVersion)
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+
+	if cfg.Streams.SlowTimeout < 0 {
+		err := errors.New("-slow-consumer-timeout cannot be negative")
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+
+	if cfg.HTTP.RequestTimeout < 0 {
+		err := errors.New("-request-timeout cannot be negative")
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+
+	if cfg.Surveillance.Window <= 0 || cfg.Surveillance.CancelRatio < 1 || cfg.Surveillance.LayerLevels < 2 || cfg.Surveillance.LayerDistance <= 0 {
+		err := errors.New("-surveillance-window must be positive, -cancel-fill-ratio at least 1, -layering-levels at least 2 and -layering-distance positive")
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+
+	cfg.Volatility.Action = VolatilityAction(*volatilityAction)
+	if cfg.Volatility.Move < 0 || cfg.Volatility.Window <= 0 || cfg.Volatility.Pause <= 0 ||
+		(cfg.Volatility.Action != VolatilityAuction && cfg.Volatility.Action != VolatilityHalt) {
+		err := errors.New("-volatility-move cannot be negative, -volatility-window and -volatility-pause must be positive and -volatility-action must be auction or halt")
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+
+	if cfg.Bots.Levels < 1 || cfg.Bots.QuoteQuantity < 1 || cfg.Bots.TakerMaxQuantity < 1 ||
+		cfg.Bots.QuoteInterval <= 0 || cfg.Bots.TakerInterval <= 0 || cfg.Bots.Spread < 0 || cfg.Bots.LevelStep < 0 {
+		err := errors.New("-bot-levels, -bot-qty and -taker-qty must be at least 1, -bot-interval and -taker-interval must be positive and -bot-spread and -bot-level-step cannot be negative")
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+
+	if cfg.Replication.PrimaryURL != "" && cfg.Bots.Enabled {
+		err := errors.New("-bots cannot run on a standby started with -replicate-from")
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+	if cfg.Replication.PrimaryURL != "" && *seedDepth != "" {
+		err := errors.New("-seed-depth cannot seed a standby started with -replicate-from")
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+	if cfg.Replication.PrimaryURL != "" && cfg.Replay.Path != "" {
+		err := errors.New("-replay cannot run on a standby started with -replicate-from")
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+	if *simClock != "" {
+		start, err := time.Parse(time.RFC3339, *simClock)
+		if err != nil {
+			err = fmt.Errorf("-sim-clock must be an RFC 3339 time: %v", err)
+		} else if cfg.Replication.PrimaryURL != "" {
+			err = errors.New("-sim-clock cannot run on a standby started with -replicate-from")
+		}
+		if err != nil {
+			fmt.Fprintln(fs.Output(), err)
+			return Config{}, err
+		}
+		cfg.SimulationStart = start
+	}
+	if cfg.Replication.PrimaryURL != "" && *strategies != "" {
+		err := errors.New("-strategies cannot run on a standby started with -replicate-from")
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+
+	if cfg.Redis.Reader {
+		var err error
+		switch {
+		case cfg.Redis.Addr == "":
+			err = errors.New("-redis-addr is required with -redis-reader")
+		case cfg.Replication.PrimaryURL != "":
+			err = errors.New("-redis-reader cannot be combined with -replicate-from")
+		case cfg.Bots.Enabled:
+			err = errors.New("-bots cannot run on a Redis reader")
+		case *seedDepth != "":
+			err = errors.New("-seed-depth cannot seed a Redis reader")
+		case cfg.Replay.Path != "":
+			err = errors.New("-replay cannot run on a Redis reader")
+		case *strategies != "":
+			err = errors.New("-strategies cannot run on a Redis reader")
+		}
+		if err != nil {
+			fmt.Fprintln(fs.Output(), err)
+			return Config{}, err
+		}
+	}
+
+	cfg.TLS.AutocertDomains = splitList(*autocertDomains)
+	if err := cfg.TLS.validate(); err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+
+	cfg.HTTP.CORSOrigins = splitList(*corsOrigins)
+	cfg.HTTP.APIKeys = splitList(*apiKeys)
+	cfg.HTTP.DropCopyKeys = splitList(*dropCopyKeys)
+	if err := cfg.HTTP.parseEntitlements(*entitlements, *defaultEntitlement); err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+	if err := cfg.HTTP.parseRoles(*keyRoles, *defaultRoles); err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+	if err := cfg.HTTP.parseAllowlists(*keyAllowlists, *trustedProxies); err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+	for _, item := range splitList(*apiSecrets) {
+		key, secret, ok := strings.Cut(item, "=")
+		if !ok || strings.TrimSpace(key) == "" || secret == "" {
+			err := fmt.Errorf("-api-secrets entry %q must be key=secret", item)
+			fmt.Fprintln(fs.Output(), err)
+			return Config{}, err
+		}
+		if cfg.HTTP.APISecrets == nil {
+			cfg.HTTP.APISecrets = make(map[string]string)
+		}
+		cfg.HTTP.APISecrets[strings.TrimSpace(key)] = secret
+	}
+	if cfg.HTTP.SignatureWindow <= 0 {
+		err := errors.New("-signature-window must be positive")
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+	if jwt.Issuer == "" && (jwt.JWKSURL != "" || jwt.Audience != "") {
+		err := errors.New("-jwt-jwks-url and -jwt-audience need -jwt-issuer")
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+	if jwt.Issuer != "" {
+		if jwt.JWKSURL == "" {
+			jwt.JWKSURL = strings.TrimSuffix(jwt.Issuer, "/") + "/.well-known/jwks.json"
+		}
+		if jwt.AccountClaim == "" || jwt.RolesClaim == "" || jwt.Refresh <= 0 {
+			err := errors.New("-jwt-account-claim and -jwt-roles-claim must not be empty and -jwt-jwks-refresh must be positive")
+			fmt.Fprintln(fs.Output(), err)
+			return Config{}, err
+		}
+		cfg.HTTP.JWT = NewJWTVerifier(jwt)
+	}
+	for _, item := range splitList(*keyOwners) {
+		key, owner, ok := strings.Cut(item, "=")
+		if !ok || strings.TrimSpace(key) == "" || strings.TrimSpace(owner) == "" {
+			err := fmt.Errorf("-key-owners entry %q must be key=owner", item)
+			fmt.Fprintln(fs.Output(), err)
+			return Config{}, err
+		}
+		if cfg.HTTP.KeyOwners == nil {
+			cfg.HTTP.KeyOwners = make(map[string]string)
+		}
+		cfg.HTTP.KeyOwners[strings.TrimSpace(key)] = strings.TrimSpace(owner)
+	}
+
+	cfg.Symbols = parseSymbols(*symbols)
+	if len(cfg.Symbols) == 0 {
+		err := errors.New("at least one symbol is required")
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+
+	cfg.SeedDepth, err = loadSeedDepth(*seedDepth)
+	if err == nil {
+		err = checkSeedDepth(cfg.Symbols, cfg.SeedDepth)
+	}
+	if err == nil {
+		err = checkReplay(cfg.Replay)
+	}
+	if err == nil {
+		cfg.Strategies.Strategies, err = parseStrategies(*strategies, *strategyParams)
+	}
+	if err == nil {
+		named := make(map[string]bool)
+		for _, spec := range cfg.Strategies.Strategies {
+			named[spec.Symbol] = true
+		}
+		err = checkSymbols("strategies", cfg.Symbols, named)
+	}
+	if err == nil && (cfg.Strategies.Interval < 0 || cfg.Strategies.Depth < 0) {
+		err = errors.New("-strategy-interval and -strategy-depth must not be negative")
+	}
+	cfg.Scripts.Paths = splitList(*orderScripts)
+	if err == nil && cfg.Scripts.Timeout <= 0 {
+		err = errors.New("-order-script-timeout must be positive")
+	}
+	for _, path := range cfg.Scripts.Paths {
+		if err != nil {
+			break
+		}
+		var script *orderScript
+		if script, err = loadOrderScript(path); err == nil {
+			script.state.Close()
+		} else {
+			err = fmt.Errorf("-order-scripts %v", err)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+
+	cfg.Calendars, err = loadTradingCalendars(*calendarFile)
+	if err == nil {
+		var scheduled []TradingCalendar
+		scheduled, err = parseTradingSchedule(*schedule, *timezone)
+		cfg.Calendars = append(cfg.Calendars, scheduled...)
+	}
+	if err == nil {
+		err = checkCalendars(cfg.Symbols, cfg.Calendars)
+	}
+	if err == nil && cfg.UncrossWindow < 0 {
+		err = errors.New("-uncross-window cannot be negative")
+	}
+	if err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+
+	intervals, err := parseSymbolIntervals("batch-auctions", *auctions)
+	if err == nil {
+		err = checkSymbols("batch-auctions", cfg.Symbols, intervals)
+	}
+	if err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+	if len(intervals) > 0 {
+		cfg.Auctions = intervals
+	}
+
+	grids, err := parseTickLadders(*ladders)
+	if err == nil {
+		err = checkSymbols("tick-ladders", cfg.Symbols, grids)
+	}
+	if err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+	if len(grids) > 0 {
+		cfg.Ladders = grids
+	}
+
+	if cfg.Marks.EMAAlpha <= 0 || cfg.Marks.EMAAlpha > 1 {
+		err := errors.New("-mark-ema-alpha must be greater than 0 and at most 1")
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+	sources, err := parseMarkSources(*markSources)
+	if err == nil {
+		err = checkSymbols("mark-sources", cfg.Symbols, sources)
+	}
+	if err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+	if len(sources) > 0 {
+		cfg.Marks.Sources = sources
+	}
+
+	urls, err := parseIndexURLs(*indexURLs)
+	if err == nil {
+		err = checkSymbols("index-urls", cfg.Symbols, urls)
+	}
+	if err == nil && cfg.Index.Interval <= 0 {
+		err = errors.New("-index-interval must be positive")
+	}
+	if err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+	if len(urls) > 0 {
+		cfg.Index.URLs = urls
+	}
+
+	cfg.Shadow.Feeds, err = parseShadowFeeds(*shadowFeeds)
+	if err == nil {
+		err = checkSymbols("shadow", cfg.Symbols, cfg.Shadow.Feeds)
+	}
+	if err == nil && cfg.Shadow.Lot <= 0 {
+		err = errors.New("-shadow-lot must be positive")
+	}
+	if err == nil && cfg.Shadow.Paper && len(cfg.Shadow.Feeds) == 0 {
+		err = errors.New("-paper needs -shadow symbols to trade")
+	}
+	if err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+	cfg.Shadow.URLs = map[string]string{"binance": *binanceURL, "coinbase": *coinbaseURL}
+
+	funding, err := parseSymbolIntervals("perpetuals", *perpetuals)
+	if err == nil {
+		err = checkSymbols("perpetuals", cfg.Symbols, funding)
+	}
+	if err == nil && (cfg.Perpetuals.RateCap <= 0 || cfg.Perpetuals.RateCap >= 1) {
+		err = errors.New("-funding-rate-cap must be greater than 0 and less than 1")
+	}
+	if err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+	if len(funding) > 0 {
+		cfg.Perpetuals.Funding = funding
+	}
+
+	caps, err := parsePositionLimits(*limits)
+	if err == nil {
+		err = checkSymbols("position-limits", cfg.Symbols, caps)
+	}
+	if err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+	if len(caps) > 0 {
+		cfg.PositionLimits.Limits = caps
+	}
+
+	specs, err := parseOptions(*optionSpecs)
+	if err == nil {
+		err = checkSymbols("options", cfg.Symbols, specs)
+	}
+	for symbol, spec := range specs {
+		if err == nil && (symbol == spec.Underlying || !slices.Contains(cfg.Symbols, spec.Underlying)) {
+			err = fmt.Errorf("-options underlying %q of %s must be another symbol in -symbols", spec.Underlying, symbol)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+	if len(specs) > 0 {
+		cfg.Options = specs
+	}
+
+	configured, err := parsePairs(*pairList)
+	if err == nil {
+		err = checkSymbols("pairs", cfg.Symbols, configured)
+	}
+	for symbol := range configured {
+		_, perpetual := funding[symbol]
+		_, option := specs[symbol]
+		if err == nil && (perpetual || option) {
+			err = fmt.Errorf("-pairs cannot set %s, which is a perpetual or an option", symbol)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+	if len(configured) > 0 {
+		cfg.Pairs = configured
+	}
+
+	listed, err := parseSpreads(*spreadList)
+	if err == nil {
+		err = checkSymbols("spreads", cfg.Symbols, listed)
+	}
+	for symbol, spread := range listed {
+		_, leg := listed[spread.BuyLeg]
+		_, otherLeg := listed[spread.SellLeg]
+		switch {
+		case err != nil:
+		case spread.BuyLeg == symbol || spread.SellLeg == symbol || spread.BuyLeg == spread.SellLeg ||
+			!slices.Contains(cfg.Symbols, spread.BuyLeg) || !slices.Contains(cfg.Symbols, spread.SellLeg):
+			err = fmt.Errorf("-spreads legs %q and %q of %s must be two other symbols in -symbols", spread.BuyLeg, spread.SellLeg, symbol)
+		case leg || otherLeg:
+			err = fmt.Errorf("-spreads legs of %s cannot themselves be spreads", symbol)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		return Config{}, err
+	}
+	if len(listed) > 0 {
+		cfg.Spreads = listed
+	}
+	return cfg, nil
+}
+
+// parseSymbolIntervals reads a flag's symbol=interval pairs, such as BTC-USD=100ms
+func parseSymbolIntervals(flagName, list string) (map[string]time.Duration, error) {
+	intervals := make(map[string]time.Duration)
+	for _, item := range splitList(list) {
+		symbol, raw, ok := strings.Cut(item, "=")
+		interval, err := time.ParseDuration(strings.TrimSpace(raw))
+		if !ok || strings.TrimSpace(symbol) == "" || err != nil || interval < time.Millisecond {
+			return nil, fmt.Errorf("-%s entry %q must be symbol=interval with an interval of at least 1ms", flagName, item)
+		}
+		intervals[strings.ToUpper(strings.TrimSpace(symbol))] = interval
+	}
+	return intervals, nil
+}
+
+// checkSymbols makes sure every symbol a flag names is one of the tradable symbols
+func checkSymbols[V any](flagName string, symbols []string, named map[string]V) error {
+	for symbol := range named {
+		if !slices.Contains(symbols, symbol) {
+			return fmt.Errorf("-%s symbol %q is not in -symbols", flagName, symbol)
+		}
+	}
+	return nil
+}
+
+// splitList splits a comma-separated flag value, dropping blank entries
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
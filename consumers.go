@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultConsumerLimit and maxConsumerLimit bound how many events one poll
+// of a consumer group returns
+const (
+	defaultConsumerLimit = 100
+	maxConsumerLimit     = 1000
+)
+
+// ConsumerOffset is the last order event sequence a consumer group has
+// acknowledged
+type ConsumerOffset struct {
+	Group       string    `json:"group"`
+	Offset      int       `json:"offset"`
+	CommittedAt time.Time `json:"committed_at"`
+	// Deleted is set on the replication stream when the group was deleted
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// ConsumerGroup is a consumer group's offset and how far behind the log it is
+type ConsumerGroup struct {
+	ConsumerOffset
+	// Lag is how many events are newer than Offset
+	Lag int `json:"lag"`
+}
+
+// ConsumerGroupsResponse lists every consumer group, by name
+type ConsumerGroupsResponse struct {
+	Groups []ConsumerGroup `json:"groups"`
+	Count  int             `json:"count"`
+}
+
+// ConsumerEventsResponse is the oldest events a consumer group has not
+// acknowledged yet
+type ConsumerEventsResponse struct {
+	Group  string       `json:"group"`
+	Epoch  string       `json:"epoch,omitempty"`
+	Offset int          `json:"offset"`
+	Events []OrderEvent `json:"events"`
+	Count  int          `json:"count"`
+	// More is set when newer events are waiting beyond these
+	More bool `json:"more"`
+}
+
+// CommitOffsetRequest acknowledges every event up to and including Sequence
+type CommitOffsetRequest struct {
+	Sequence int `json:"sequence" validate:"min=0"`
+	// ExpectedOffset only commits if the group's offset is still this one, so
+	// of two consumers that processed the same events only one commits
+	ExpectedOffset *int `json:"expected_offset,omitempty"`
+}
+
+// consumerGroups holds each group's offset. Archiving keeps every event newer
+// than the lowest one, so a group never misses an event it has not acknowledged.
+var (
+	consumersMu    sync.Mutex
+	consumerGroups = make(map[string]ConsumerOffset)
+)
+
+// consumerFloor returns the lowest offset of any consumer group, and false
+// when there are none
+func consumerFloor() (int, bool) {
+	consumersMu.Lock()
+	defer consumersMu.Unlock()
+	floor, ok := 0, false
+	for _, group := range consumerGroups {
+		if !ok || group.Offset < floor {
+			floor, ok = group.Offset, true
+		}
+	}
+	return floor, ok
+}
+
+// consumerOffsets copies every group's offset, by name
+func consumerOffsets() []ConsumerOffset {
+	consumersMu.Lock()
+	defer consumersMu.Unlock()
+	return consumerOffsetsLocked()
+}
+
+// consumerOffsetsLocked is consumerOffsets for a caller holding consumersMu,
+// which commits take before publishing, so no commit can fall between a copy
+// of the offsets and the replication stream
+func consumerOffsetsLocked() []ConsumerOffset {
+	offsets := make([]ConsumerOffset, 0, len(consumerGroups))
+	for _, group := range consumerGroups {
+		offsets = append(offsets, group)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i].Group < offsets[j].Group })
+	return offsets
+}
+
+// replaceConsumerOffsets makes offsets the only consumer groups
+func replaceConsumerOffsets(offsets []ConsumerOffset) {
+	consumersMu.Lock()
+	defer consumersMu.Unlock()
+	consumerGroups = make(map[string]ConsumerOffset, len(offsets))
+	for _, group := range offsets {
+		consumerGroups[group.Group] = group
+	}
+}
+
+// applyConsumerOffset applies a group's commit or deletion from the primary
+func applyConsumerOffset(change ConsumerOffset) {
+	consumersMu.Lock()
+	defer consumersMu.Unlock()
+	if change.Deleted {
+		delete(consumerGroups, change.Group)
+		return
+	}
+	consumerGroups[change.Group] = change
+}
+
+// publishConsumerOffset sends a group's change to the replicas
+func publishConsumerOffset(change ConsumerOffset) {
+	if replication.publishing() {
+		replication.publish(ReplicationMessage{Consumer: &change})
+	}
+}
+
+// withLag reports a group's offset with the events newer than it
+func withLag(group ConsumerOffset, last int) ConsumerGroup {
+	return ConsumerGroup{ConsumerOffset: group, Lag: max(last-group.Offset, 0)}
+}
+
+// lastEvent returns the sequence of the newest order event
+func lastEvent() int {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	return lastEventSequence
+}
+
+// lookupConsumerGroup returns the named group's offset
+func lookupConsumerGroup(name string) (ConsumerOffset, bool) {
+	consumersMu.Lock()
+	defer consumersMu.Unlock()
+	group, ok := consumerGroups[name]
+	return group, ok
+}
+
+// getConsumerGroupsHandler returns every consumer group
+func getConsumerGroupsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	last := lastEvent()
+	offsets := consumerOffsets()
+	groups := make([]ConsumerGroup, 0, len(offsets))
+	for _, group := range offsets {
+		groups = append(groups, withLag(group, last))
+	}
+	json.NewEncoder(w).Encode(ConsumerGroupsResponse{
+		Groups: groups,
+		Count:  len(groups),
+	})
+}
+
+// getConsumerGroupHandler returns the consumer group named in the path
+func getConsumerGroupHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	name := r.PathValue("group")
+	last := lastEvent()
+	group, ok := lookupConsumerGroup(name)
+	if !ok {
+		writeConsumerGroupNotFound(w, name)
+		return
+	}
+	json.NewEncoder(w).Encode(withLag(group, last))
+}
+
+// pollConsumerGroupHandler returns the oldest events newer than the group's
+// offset. Polling does not move the offset; only a commit does, so events are
+// delivered again until they are acknowledged.
+func pollConsumerGroupHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	limit := defaultConsumerLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > maxConsumerLimit {
+			writeError(w, http.StatusBadRequest, ErrCodeValidationFailed, "Validation failed",
+				[]string{fmt.Sprintf("limit must be an integer from 1 to %d (received: '%s')", maxConsumerLimit, raw)})
+			return
+		}
+		limit = parsed
+	}
+
+	name := r.PathValue("group")
+	group, ok := lookupConsumerGroup(name)
+	if !ok {
+		writeConsumerGroupNotFound(w, name)
+		return
+	}
+
+	historyMu.Lock()
+	// The log is in sequence order, with gaps only where archived orders were
+	start := sort.Search(len(orderEvents), func(i int) bool { return orderEvents[i].Sequence > group.Offset })
+	end := min(start+limit, len(orderEvents))
+	events := append(make([]OrderEvent, 0, end-start), orderEvents[start:end]...)
+	more := end < len(orderEvents)
+	historyMu.Unlock()
+
+	json.NewEncoder(w).Encode(ConsumerEventsResponse{
+		Group:  name,
+		Epoch:  currentEpoch(),
+		Offset: group.Offset,
+		Events: events,
+		Count:  len(events),
+		More:   more,
+	})
+}
+
+// commitConsumerOffsetHandler acknowledges the events up to a sequence,
+// creating the group if it is new. Offsets only move forward: committing one
+// at or before the group's offset changes nothing, so a retried commit is
+// harmless.
+func commitConsumerOffsetHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req CommitOffsetRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	name := r.PathValue("group")
+	last := lastEvent()
+	if req.Sequence > last {
+		writeError(w, http.StatusBadRequest, ErrCodeValidationFailed, "Validation failed",
+			[]FieldError{fieldError("sequence", "lte", "sequence must be at most the newest event's, %d (received: %d)", last, req.Sequence)})
+		return
+	}
+
+	// A group that has never committed is at offset 0
+	consumersMu.Lock()
+	group, exists := consumerGroups[name]
+	if req.ExpectedOffset != nil && group.Offset != *req.ExpectedOffset {
+		consumersMu.Unlock()
+		writeError(w, http.StatusConflict, ErrCodeOffsetConflict, "Offset conflict",
+			fmt.Sprintf("group '%s' is at offset %d, not %d", name, group.Offset, *req.ExpectedOffset))
+		return
+	}
+	if !exists || req.Sequence > group.Offset {
+		group = ConsumerOffset{Group: name, Offset: req.Sequence, CommittedAt: engineClock.Now()}
+		consumerGroups[name] = group
+		publishConsumerOffset(group)
+	}
+	consumersMu.Unlock()
+
+	json.NewEncoder(w).Encode(withLag(group, last))
+}
+
+// deleteConsumerGroupHandler forgets the consumer group named in the path
+func deleteConsumerGroupHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	name := r.PathValue("group")
+	last := lastEvent()
+	consumersMu.Lock()
+	group, ok := consumerGroups[name]
+	if ok {
+		delete(consumerGroups, name)
+		publishConsumerOffset(ConsumerOffset{Group: name, Deleted: true})
+	}
+	consumersMu.Unlock()
+	if !ok {
+		writeConsumerGroupNotFound(w, name)
+		return
+	}
+	json.NewEncoder(w).Encode(withLag(group, last))
+}
+
+// writeConsumerGroupNotFound reports a group that has never committed
+func writeConsumerGroupNotFound(w http.ResponseWriter, name string) {
+	writeError(w, http.StatusNotFound, ErrCodeConsumerNotFound, "Consumer group not found",
+		"group '"+name+"' has not committed an offset")
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// commitOffset posts a commit for a consumer group
+func commitOffset(group, body string) *httptest.ResponseRecorder {
+	return serve(HTTPConfig{}, httptest.NewRequest("POST", "/api/v1/consumer-groups/"+group+"/offset", strings.NewReader(body)))
+}
+
+// pollGroup fetches the events a consumer group has not acknowledged
+func pollGroup(t *testing.T, group, query string) ConsumerEventsResponse {
+	t.Helper()
+	response := serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/consumer-groups/"+group+"/events"+query, nil))
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected the group's events, got %d: %s", response.Code, response.Body.String())
+	}
+	var result ConsumerEventsResponse
+	json.NewDecoder(response.Body).Decode(&result)
+	return result
+}
+
+func TestConsumerGroups_PollAndCommit(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+	for i := 0; i < 3; i++ {
+		placeOn(m, Order{ID: fmt.Sprintf("bid-%d", i), Side: SideBuy, Price: 99, Quantity: 1})
+	}
+
+	if response := commitOffset("billing", `{"sequence": 0}`); response.Code != http.StatusOK {
+		t.Fatalf("Expected the group created, got %d: %s", response.Code, response.Body.String())
+	}
+	first := pollGroup(t, "billing", "?limit=2")
+	if first.Count != 2 || first.Events[0].Sequence != 1 || first.Events[1].Sequence != 2 || !first.More {
+		t.Fatalf("Expected events 1 and 2 with more waiting, got %+v", first)
+	}
+	// Polling again without a commit delivers the same events
+	if again := pollGroup(t, "billing", "?limit=2"); again.Events[0].Sequence != 1 {
+		t.Errorf("Expected the unacknowledged events again, got %+v", again.Events)
+	}
+
+	commitOffset("billing", `{"sequence": 2}`)
+	if rest := pollGroup(t, "billing", ""); rest.Offset != 2 || rest.Count != 1 || rest.Events[0].Sequence != 3 || rest.More {
+		t.Errorf("Expected only event 3 after the commit, got %+v", rest)
+	}
+
+	// A retried or stale commit leaves the offset where it is
+	var group ConsumerGroup
+	json.NewDecoder(commitOffset("billing", `{"sequence": 1}`).Body).Decode(&group)
+	if group.Offset != 2 || group.Lag != 1 {
+		t.Errorf("Expected offset 2 with a lag of 1, got %+v", group)
+	}
+
+	var list ConsumerGroupsResponse
+	json.NewDecoder(serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/consumer-groups", nil)).Body).Decode(&list)
+	if list.Count != 1 || list.Groups[0].Group != "billing" {
+		t.Errorf("Expected the billing group listed, got %+v", list)
+	}
+	if response := serve(HTTPConfig{}, httptest.NewRequest("DELETE", "/api/v1/consumer-groups/billing", nil)); response.Code != http.StatusOK {
+		t.Errorf("Expected the group deleted, got %d", response.Code)
+	}
+	response := serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/consumer-groups/billing/events", nil))
+	if result := decodeError(t, response); response.Code != http.StatusNotFound || result.Error.Code != ErrCodeConsumerNotFound {
+		t.Errorf("Expected 404 CONSUMER_GROUP_NOT_FOUND, got %d %s", response.Code, result.Error.Code)
+	}
+}
+
+func TestConsumerGroups_RefuseConflictingCommits(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "bid", Side: SideBuy, Price: 99, Quantity: 1})
+
+	// Two consumers processed the same event; only the first commit counts
+	if response := commitOffset("billing", `{"sequence": 1, "expected_offset": 0}`); response.Code != http.StatusOK {
+		t.Fatalf("Expected the first commit to succeed, got %d: %s", response.Code, response.Body.String())
+	}
+	response := commitOffset("billing", `{"sequence": 1, "expected_offset": 0}`)
+	if result := decodeError(t, response); response.Code != http.StatusConflict || result.Error.Code != ErrCodeOffsetConflict {
+		t.Errorf("Expected 409 OFFSET_CONFLICT, got %d %s", response.Code, result.Error.Code)
+	}
+
+	response = commitOffset("billing", `{"sequence": 5}`)
+	if result := decodeError(t, response); response.Code != http.StatusBadRequest || result.Error.Code != ErrCodeValidationFailed {
+		t.Errorf("Expected a commit past the log refused, got %d %s", response.Code, result.Error.Code)
+	}
+	response = serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/consumer-groups/billing/events?limit=0", nil))
+	if result := decodeError(t, response); response.Code != http.StatusBadRequest || result.Error.Code != ErrCodeValidationFailed {
+		t.Errorf("Expected a zero limit refused, got %d %s", response.Code, result.Error.Code)
+	}
+}
+
+func TestConsumerGroups_ArchivingKeepsUnacknowledgedEvents(t *testing.T) {
+	setupTest()
+	useDeterministicEngine(t, time.Date(2024, 1, 1, 9, 30, 0, 0, time.UTC))
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "ask", Side: SideSell, Price: 100, Quantity: 1})
+	placeOn(m, Order{ID: "bid", Side: SideBuy, Price: 100, Quantity: 1})
+	commitOffset("billing", `{"sequence": 2}`)
+
+	cutoff := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	for _, event := range selectArchivable(cutoff).events {
+		if event.Sequence > 2 {
+			t.Errorf("Expected events after the group's offset kept, got %+v", event)
+		}
+	}
+	trimArchived(selectArchivable(cutoff))
+	if events := pollGroup(t, "billing", ""); events.Count == 0 || events.Events[0].Sequence != 3 {
+		t.Errorf("Expected the group to carry on from event 3, got %+v", events.Events)
+	}
+}
+
+func TestConsumerGroups_OutliveRestoresAndRestarts(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "bid", Side: SideBuy, Price: 99, Quantity: 1})
+	commitOffset("billing", `{"sequence": 1}`)
+	saved := takeSnapshot()
+
+	commitOffset("billing", `{"sequence": 2}`)
+	restoreSnapshot(saved)
+	if group, _ := lookupConsumerGroup("billing"); group.Offset != 1 {
+		t.Errorf("Expected the snapshot's offset 1, got %+v", group)
+	}
+
+	path := filepath.Join(t.TempDir(), "sequences.json")
+	if err := openSequences(path); err != nil {
+		t.Fatal(err)
+	}
+	saveSequences(path, func(error) {})
+	setupTest()
+	if err := openSequences(path); err != nil {
+		t.Fatal(err)
+	}
+	if group, ok := lookupConsumerGroup("billing"); !ok || group.Offset != 1 {
+		t.Errorf("Expected the offset kept across a clean restart, got %+v", group)
+	}
+
+	// A standby follows the primary's commits and deletions
+	applyReplication(ReplicationMessage{Consumer: &ConsumerOffset{Group: "audit", Offset: 1}})
+	applyReplication(ReplicationMessage{Consumer: &ConsumerOffset{Group: "billing", Deleted: true}})
+	if offsets := consumerOffsets(); len(offsets) != 1 || offsets[0].Group != "audit" {
+		t.Errorf("Expected only the replicated group, got %+v", offsets)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+)
+
+// CreditChecker approves or refuses each order before it can trade, so an
+// external credit or risk system has the last word on what an account places
+type CreditChecker interface {
+	CheckCredit(ctx context.Context, request CreditRequest) (CreditDecision, error)
+}
+
+// CreditCheckerFunc adapts an ordinary function to the CreditChecker interface
+type CreditCheckerFunc func(ctx context.Context, request CreditRequest) (CreditDecision, error)
+
+func (f CreditCheckerFunc) CheckCredit(ctx context.Context, request CreditRequest) (CreditDecision, error) {
+	return f(ctx, request)
+}
+
+// CreditRequest is an order as it is about to trade and its owner as it
+// stood at that moment
+type CreditRequest struct {
+	Order Order `json:"order"`
+	// Account is nil when the owner has no account
+	Account   *Account   `json:"account,omitempty"`
+	Positions []Position `json:"positions,omitempty"`
+	// Basket holds the orders placed before this one in the same basket,
+	// which will rest or trade alongside it if it is approved
+	Basket []Order `json:"basket,omitempty"`
+}
+
+// CreditDecision is a credit checker's answer about one order
+type CreditDecision struct {
+	Approved bool   `json:"approved"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// CreditConfig says how long the credit checker has to answer and what
+// becomes of orders when it does not
+type CreditConfig struct {
+	// URL is the credit service orders are posted to; empty leaves the check off
+	URL     string
+	Timeout time.Duration
+	// FailOpen lets orders through when the checker fails or times out,
+	// where refusing them is the default
+	FailOpen bool
+}
+
+// creditChecker is asked about every order placed from outside the engine;
+// nil turns the check off. It is called on the symbol's matcher, so a slow
+// checker holds up that symbol until credit.Timeout.
+var (
+	creditChecker CreditChecker
+	credit        CreditConfig
+)
+
+// creditRefusal asks the credit checker about an order on a book and returns
+// the code and reason to reject it with, or an empty code to let it through.
+// It must run on the symbol's matcher.
+func creditRefusal(book *OrderBook, order Order) (ErrorCode, string) {
+	checker := creditChecker
+	if checker == nil || order.Owner == "" {
+		return "", ""
+	}
+
+	decision, err := askCredit(checker, creditSnapshot(book, order))
+	switch {
+	case err != nil && credit.FailOpen:
+		log.Printf("credit: letting order %s through: %v", order.ID, err)
+		recordOrderEvent(order.ID, order.Status, order.Status, fmt.Sprintf("credit check failed, so the order was let through: %v", err))
+		return "", ""
+	case err != nil:
+		return ErrCodeCreditUnavailable, fmt.Sprintf("refused because the credit check failed: %v", err)
+	case !decision.Approved:
+		reason := decision.Reason
+		if reason == "" {
+			reason = "no reason given"
+		}
+		return ErrCodeCreditRefused, "refused by the credit check: " + reason
+	}
+	return "", ""
+}
+
+// creditSnapshot copies what the credit checker is shown about an order's
+// owner and the basket it is part of
+func creditSnapshot(book *OrderBook, order Order) CreditRequest {
+	accountsMu.Lock()
+	defer accountsMu.Unlock()
+
+	request := CreditRequest{Order: order, Basket: slices.Clone(book.basket)}
+	if account, ok := accounts[order.Owner]; ok {
+		snapshot := account.snapshot()
+		request.Account = &snapshot
+	}
+	for _, position := range positions[order.Owner] {
+		request.Positions = append(request.Positions, *position)
+	}
+	sort.Slice(request.Positions, func(i, j int) bool { return request.Positions[i].Symbol < request.Positions[j].Symbol })
+	return request
+}
+
+// askCredit calls the checker and gives up after credit.Timeout, even if the
+// checker does not watch its context
+func askCredit(checker CreditChecker, request CreditRequest) (CreditDecision, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), credit.Timeout)
+	defer cancel()
+
+	type answer struct {
+		decision CreditDecision
+		err      error
+	}
+	answered := make(chan answer, 1)
+	go func() {
+		decision, err := checker.CheckCredit(ctx, request)
+		answered <- answer{decision, err}
+	}()
+
+	select {
+	case a := <-answered:
+		return a.decision, a.err
+	case <-ctx.Done():
+		return CreditDecision{}, fmt.Errorf("no answer within %s", credit.Timeout)
+	}
+}
+
+// WebhookCreditChecker checks orders by posting them to an external credit service
+type WebhookCreditChecker struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewWebhookCreditChecker creates a checker that posts to url. How long it
+// waits comes from the context it is called with.
+func NewWebhookCreditChecker(url string) *WebhookCreditChecker {
+	return &WebhookCreditChecker{URL: url, Client: &http.Client{}}
+}
+
+func (c *WebhookCreditChecker) CheckCredit(ctx context.Context, request CreditRequest) (CreditDecision, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return CreditDecision{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return CreditDecision{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return CreditDecision{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return CreditDecision{}, fmt.Errorf("credit service returned %s", resp.Status)
+	}
+
+	var decision CreditDecision
+	if err := json.NewDecoder(resp.Body).Decode(&decision); err != nil {
+		return CreditDecision{}, fmt.Errorf("decoding credit service response: %w", err)
+	}
+	return decision, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCreditCheck_RefusesWithTheCheckersReason(t *testing.T) {
+	setupTest()
+	openFunded(t, "alice", map[string]float64{"USD": 500})
+	var asked []CreditRequest
+	creditChecker = CreditCheckerFunc(func(_ context.Context, request CreditRequest) (CreditDecision, error) {
+		asked = append(asked, request)
+		if request.Order.Quantity > 10 {
+			return CreditDecision{Reason: "over the desk's line"}, nil
+		}
+		return CreditDecision{Approved: true}, nil
+	})
+
+	if response := placeViaHandler(PlaceOrderRequest{Side: SideBuy, Price: 100, Quantity: 5, Owner: "alice"}); response.Code != http.StatusOK {
+		t.Fatalf("Expected an approved order placed, got %d %s", response.Code, response.Body)
+	}
+	response := placeViaHandler(PlaceOrderRequest{Side: SideBuy, Price: 100, Quantity: 50, Owner: "alice"})
+	result := decodeError(t, response)
+	if response.Code != http.StatusUnprocessableEntity || result.Error.Code != ErrCodeCreditRefused {
+		t.Fatalf("Expected 422 CREDIT_REFUSED, got %d %s", response.Code, result.Error.Code)
+	}
+	if event := orderEvents[len(orderEvents)-1]; event.OrderID != result.OrderID || !strings.Contains(event.Reason, "over the desk's line") {
+		t.Errorf("Expected the checker's reason recorded, got %+v", event)
+	}
+	if len(asked) != 2 || asked[0].Account == nil || asked[0].Account.Balances["USD"] != 500 || asked[0].Order.Owner != "alice" {
+		t.Errorf("Expected the checker shown the order and alice's account, got %+v", asked)
+	}
+
+	// Anonymous orders and orders the engine makes itself are not checked
+	placeViaHandler(PlaceOrderRequest{Side: SideSell, Price: 101, Quantity: 50})
+	m, _ := matcherFor("")
+	processOn(m, Order{ID: "internal", Side: SideSell, Price: 102, Quantity: 50, Owner: "alice"})
+	if len(asked) != 2 {
+		t.Errorf("Expected 2 orders checked, got %d", len(asked))
+	}
+}
+
+func TestCreditCheck_FailurePolicy(t *testing.T) {
+	setupTest()
+	credit.Timeout = 20 * time.Millisecond
+	release := make(chan struct{})
+	defer close(release)
+	creditChecker = CreditCheckerFunc(func(_ context.Context, request CreditRequest) (CreditDecision, error) {
+		if request.Order.Owner == "down" {
+			return CreditDecision{}, errors.New("connection refused")
+		}
+		// Ignores its context, so only the timeout gets the order moving again
+		<-release
+		return CreditDecision{Approved: true}, nil
+	})
+
+	for _, owner := range []string{"down", "slow"} {
+		response := placeViaHandler(PlaceOrderRequest{Side: SideBuy, Price: 100, Quantity: 1, Owner: owner})
+		if result := decodeError(t, response); result.Error.Code != ErrCodeCreditUnavailable {
+			t.Errorf("%s: expected CREDIT_UNAVAILABLE when failing closed, got %d %s", owner, response.Code, result.Error.Code)
+		}
+	}
+
+	credit.FailOpen = true
+	response := placeViaHandler(PlaceOrderRequest{Side: SideBuy, Price: 100, Quantity: 1, Owner: "slow"})
+	var placed PlaceOrderResponse
+	json.NewDecoder(response.Body).Decode(&placed)
+	if response.Code != http.StatusOK || placed.Status != OrderStatusPending {
+		t.Fatalf("Expected the order let through when failing open, got %d %+v", response.Code, placed)
+	}
+	for _, event := range orderEvents {
+		if event.OrderID == placed.OrderID && strings.Contains(event.Reason, "no answer within 20ms") {
+			return
+		}
+	}
+	t.Errorf("Expected the failed check recorded on %s, got %+v", placed.OrderID, orderEvents)
+}
+
+func TestWebhookCreditChecker_PostsTheOrder(t *testing.T) {
+	setupTest()
+	var received CreditRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+		json.NewEncoder(w).Encode(CreditDecision{Reason: "limit reached"})
+	}))
+	defer server.Close()
+	creditChecker = NewWebhookCreditChecker(server.URL)
+
+	response := placeViaHandler(PlaceOrderRequest{Side: SideSell, Price: 100, Quantity: 3, Owner: "bob"})
+	if result := decodeError(t, response); result.Error.Code != ErrCodeCreditRefused {
+		t.Errorf("Expected CREDIT_REFUSED, got %d %s", response.Code, result.Error.Code)
+	}
+	if received.Order.Owner != "bob" || received.Order.Quantity != 3 || received.Account != nil {
+		t.Errorf("Expected bob's order without an account, got %+v", received)
+	}
+
+	cfg, err := loadConfig([]string{"-credit-url", server.URL, "-credit-timeout", "50ms", "-credit-fail-open"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Credit.URL != server.URL || cfg.Credit.Timeout != 50*time.Millisecond || !cfg.Credit.FailOpen {
+		t.Errorf("Expected the credit flags read, got %+v", cfg.Credit)
+	}
+	if _, err := loadConfig([]string{"-credit-timeout", "0s"}); err == nil {
+		t.Error("Expected a zero -credit-timeout refused")
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultDeadLetterLimit is how many dead letters are kept unless
+// -dead-letter-limit says otherwise
+const defaultDeadLetterLimit = 10000
+
+// DeadLetter is a fill the webhook did not take, kept until it is delivered
+// again or discarded
+type DeadLetter struct {
+	ID    string       `json:"id"`
+	Event WebhookEvent `json:"event"`
+	// URL is the webhook the event was posted to
+	URL string `json:"url"`
+	// Attempts counts the posts made so far; 0 means the fill never got a
+	// place in the webhook's queue
+	Attempts      int       `json:"attempts"`
+	Error         string    `json:"error"`
+	FailedAt      time.Time `json:"failed_at"`
+	LastAttemptAt time.Time `json:"last_attempt_at"`
+}
+
+// DeadLettersResponse lists the dead letters, oldest first
+type DeadLettersResponse struct {
+	DeadLetters []DeadLetter `json:"dead_letters"`
+	Count       int          `json:"count"`
+	// Dropped counts the oldest dead letters let go to stay within the limit
+	Dropped int `json:"dropped"`
+}
+
+// DeadLetterRetryResponse is what retrying every dead letter delivered
+type DeadLetterRetryResponse struct {
+	Delivered int `json:"delivered"`
+	Remaining int `json:"remaining"`
+	// Error is why the retry stopped, when it did not deliver them all
+	Error string `json:"error,omitempty"`
+}
+
+// deadLetters are the undelivered fills, oldest first. Past deadLetterLimit
+// the oldest are dropped, each with a log line, so none go unnoticed.
+var (
+	deadLettersMu      sync.Mutex
+	deadLetters        []DeadLetter
+	deadLetterLimit    = defaultDeadLetterLimit
+	droppedDeadLetters int
+)
+
+// addDeadLetter keeps an event the webhook did not take
+func addDeadLetter(event WebhookEvent, url string, attempts int, reason string) {
+	now := engineClock.Now()
+	log.Printf("webhooks: dead-lettering fill %s after %d attempts: %s", event.ID, attempts, reason)
+
+	deadLettersMu.Lock()
+	defer deadLettersMu.Unlock()
+	deadLetters = append(deadLetters, DeadLetter{
+		ID:            event.ID,
+		Event:         event,
+		URL:           url,
+		Attempts:      attempts,
+		Error:         reason,
+		FailedAt:      now,
+		LastAttemptAt: now,
+	})
+	if excess := len(deadLetters) - deadLetterLimit; deadLetterLimit > 0 && excess > 0 {
+		for _, dropped := range deadLetters[:excess] {
+			log.Printf("webhooks: dropping dead letter %s past the limit of %d", dropped.ID, deadLetterLimit)
+		}
+		deadLetters = append(deadLetters[:0:0], deadLetters[excess:]...)
+		droppedDeadLetters += excess
+	}
+}
+
+// findDeadLetterLocked returns the index of the dead letter with id, or -1.
+// deadLettersMu must be held.
+func findDeadLetterLocked(id string) int {
+	for i, letter := range deadLetters {
+		if letter.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// lookupDeadLetter returns the dead letter with id
+func lookupDeadLetter(id string) (DeadLetter, bool) {
+	deadLettersMu.Lock()
+	defer deadLettersMu.Unlock()
+	if i := findDeadLetterLocked(id); i >= 0 {
+		return deadLetters[i], true
+	}
+	return DeadLetter{}, false
+}
+
+// redeliver posts a dead letter once more. Delivered, it is removed; otherwise
+// it stays with the new attempt and error. The letter is returned either way.
+func redeliver(letter DeadLetter) (DeadLetter, error) {
+	err := fillWebhook.post(letter.Event)
+	letter.Attempts++
+	letter.LastAttemptAt = engineClock.Now()
+	if err != nil {
+		letter.Error = err.Error()
+	}
+
+	deadLettersMu.Lock()
+	defer deadLettersMu.Unlock()
+	i := findDeadLetterLocked(letter.ID)
+	switch {
+	case i < 0:
+		// Discarded or delivered while this post was in flight
+	case err == nil:
+		deadLetters = append(deadLetters[:i], deadLetters[i+1:]...)
+	default:
+		deadLetters[i] = letter
+	}
+	return letter, err
+}
+
+// getDeadLettersHandler returns every dead letter
+func getDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	deadLettersMu.Lock()
+	list := append(make([]DeadLetter, 0, len(deadLetters)), deadLetters...)
+	dropped := droppedDeadLetters
+	deadLettersMu.Unlock()
+	json.NewEncoder(w).Encode(DeadLettersResponse{
+		DeadLetters: list,
+		Count:       len(list),
+		Dropped:     dropped,
+	})
+}
+
+// getDeadLetterHandler returns the dead letter named in the path
+func getDeadLetterHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id := r.PathValue("id")
+	letter, ok := lookupDeadLetter(id)
+	if !ok {
+		writeDeadLetterNotFound(w, id)
+		return
+	}
+	json.NewEncoder(w).Encode(letter)
+}
+
+// retryDeadLetterHandler delivers the dead letter named in the path again
+func retryDeadLetterHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id := r.PathValue("id")
+	letter, ok := lookupDeadLetter(id)
+	if !ok {
+		writeDeadLetterNotFound(w, id)
+		return
+	}
+	if fillWebhook == nil {
+		writeWebhookOff(w)
+		return
+	}
+	letter, err := redeliver(letter)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, ErrCodeDeliveryFailed, "Delivery failed", err.Error())
+		return
+	}
+	json.NewEncoder(w).Encode(letter)
+}
+
+// retryDeadLettersHandler delivers the dead letters again, oldest first, and
+// stops at the first that fails, as the webhook is likely still down
+func retryDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if fillWebhook == nil {
+		writeWebhookOff(w)
+		return
+	}
+	deadLettersMu.Lock()
+	pending := append([]DeadLetter(nil), deadLetters...)
+	deadLettersMu.Unlock()
+
+	var result DeadLetterRetryResponse
+	for _, letter := range pending {
+		if _, err := redeliver(letter); err != nil {
+			result.Error = err.Error()
+			break
+		}
+		result.Delivered++
+	}
+	deadLettersMu.Lock()
+	result.Remaining = len(deadLetters)
+	deadLettersMu.Unlock()
+	json.NewEncoder(w).Encode(result)
+}
+
+// discardDeadLetterHandler forgets the dead letter named in the path without
+// delivering it
+func discardDeadLetterHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id := r.PathValue("id")
+	deadLettersMu.Lock()
+	i := findDeadLetterLocked(id)
+	var letter DeadLetter
+	if i >= 0 {
+		letter = deadLetters[i]
+		deadLetters = append(deadLetters[:i], deadLetters[i+1:]...)
+	}
+	deadLettersMu.Unlock()
+	if i < 0 {
+		writeDeadLetterNotFound(w, id)
+		return
+	}
+	log.Printf("webhooks: dead letter %s discarded", id)
+	json.NewEncoder(w).Encode(letter)
+}
+
+// writeDeadLetterNotFound reports a dead letter that is not held
+func writeDeadLetterNotFound(w http.ResponseWriter, id string) {
+	writeError(w, http.StatusNotFound, ErrCodeDeadLetterNotFound, "Dead letter not found",
+		"no dead letter with ID '"+id+"'")
+}
+
+// writeWebhookOff refuses a retry while there is no webhook to retry against
+func writeWebhookOff(w http.ResponseWriter) {
+	writeError(w, http.StatusServiceUnavailable, ErrCodeDeliveryFailed, "Delivery failed",
+		"the fill webhook is off; start the server with -webhook-url")
+}
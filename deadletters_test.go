@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// adminDeadLetters sends a request to the dead-letter admin API
+func adminDeadLetters(method, path string) *httptest.ResponseRecorder {
+	return serve(HTTPConfig{}, httptest.NewRequest(method, "/api/v1/admin/dead-letters"+path, nil))
+}
+
+// deadLetterFills fills a resting order so the webhook dead-letters both sides
+func deadLetterFills(t *testing.T) {
+	t.Helper()
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "ask", Side: SideSell, Price: 100, Quantity: 1})
+	placeOn(m, Order{ID: "bid", Side: SideBuy, Price: 100, Quantity: 1})
+	waitUntil(t, "both fills dead-lettered", func() bool { return deadLetterCount() == 2 })
+}
+
+func TestDeadLetters_InspectRetryAndDiscard(t *testing.T) {
+	setupTest()
+	var up atomic.Bool
+	receiver := useWebhook(t, 1, func(int64) int {
+		if up.Load() {
+			return http.StatusOK
+		}
+		return http.StatusServiceUnavailable
+	})
+	deadLetterFills(t)
+
+	var list DeadLettersResponse
+	json.NewDecoder(adminDeadLetters("GET", "").Body).Decode(&list)
+	if list.Count != 2 || list.DeadLetters[0].Event.Fill.OrderID != "ask" || list.DeadLetters[0].URL == "" {
+		t.Fatalf("Expected both fills listed, oldest first, got %+v", list)
+	}
+	maker, taker := list.DeadLetters[0].ID, list.DeadLetters[1].ID
+	if response := adminDeadLetters("GET", "/"+taker); response.Code != http.StatusOK {
+		t.Errorf("Expected the taker's dead letter, got %d", response.Code)
+	}
+
+	// While the webhook is still down a retry fails and the letter stays
+	response := adminDeadLetters("POST", "/"+maker+"/retry")
+	if result := decodeError(t, response); response.Code != http.StatusBadGateway || result.Error.Code != ErrCodeDeliveryFailed {
+		t.Errorf("Expected 502 DELIVERY_FAILED, got %d %s", response.Code, result.Error.Code)
+	}
+	if letter, ok := lookupDeadLetter(maker); !ok || letter.Attempts != 2 {
+		t.Errorf("Expected the failed retry counted, got %+v", letter)
+	}
+
+	up.Store(true)
+	if response := adminDeadLetters("POST", "/"+maker+"/retry"); response.Code != http.StatusOK {
+		t.Fatalf("Expected the retry delivered, got %d: %s", response.Code, response.Body.String())
+	}
+	if events := receiver.received(); len(events) != 1 || events[0].ID != maker {
+		t.Errorf("Expected the maker's fill delivered, got %+v", events)
+	}
+	if response := adminDeadLetters("DELETE", "/"+taker); response.Code != http.StatusOK {
+		t.Errorf("Expected the taker's dead letter discarded, got %d", response.Code)
+	}
+	if count := deadLetterCount(); count != 0 || len(receiver.received()) != 1 {
+		t.Errorf("Expected no dead letters and the discarded fill undelivered, got %d", count)
+	}
+
+	response = adminDeadLetters("GET", "/"+maker)
+	if result := decodeError(t, response); response.Code != http.StatusNotFound || result.Error.Code != ErrCodeDeadLetterNotFound {
+		t.Errorf("Expected 404 DEAD_LETTER_NOT_FOUND, got %d %s", response.Code, result.Error.Code)
+	}
+}
+
+func TestDeadLetters_RetryEveryOneOldestFirst(t *testing.T) {
+	setupTest()
+	var up atomic.Bool
+	receiver := useWebhook(t, 1, func(int64) int {
+		if up.Load() {
+			return http.StatusOK
+		}
+		return http.StatusInternalServerError
+	})
+	deadLetterFills(t)
+
+	var result DeadLetterRetryResponse
+	json.NewDecoder(adminDeadLetters("POST", "/retry").Body).Decode(&result)
+	if result.Delivered != 0 || result.Remaining != 2 || result.Error == "" {
+		t.Errorf("Expected the retry to stop at the first failure, got %+v", result)
+	}
+
+	up.Store(true)
+	result = DeadLetterRetryResponse{}
+	json.NewDecoder(adminDeadLetters("POST", "/retry").Body).Decode(&result)
+	if result.Delivered != 2 || result.Remaining != 0 {
+		t.Errorf("Expected both delivered, got %+v", result)
+	}
+	if events := receiver.received(); len(events) != 2 || events[0].Fill.OrderID != "ask" {
+		t.Errorf("Expected the fills delivered in order, got %+v", events)
+	}
+}
+
+func TestDeadLetters_DropTheOldestPastTheLimit(t *testing.T) {
+	setupTest()
+	deadLetterLimit = 1
+	event := WebhookEvent{ID: "trade-1-maker", Type: FeedMessageFill, Fill: &Fill{TradeID: "trade-1"}}
+	addDeadLetter(event, "http://hooks.local", 1, "webhook answered 500")
+	event.ID = "trade-1-taker"
+	addDeadLetter(event, "http://hooks.local", 1, "webhook answered 500")
+
+	var list DeadLettersResponse
+	json.NewDecoder(adminDeadLetters("GET", "").Body).Decode(&list)
+	if list.Count != 1 || list.DeadLetters[0].ID != "trade-1-taker" || list.Dropped != 1 {
+		t.Errorf("Expected only the newest kept and one dropped, got %+v", list)
+	}
+
+	// Without a webhook there is nothing to retry against
+	response := adminDeadLetters("POST", "/trade-1-taker/retry")
+	if result := decodeError(t, response); response.Code != http.StatusServiceUnavailable || result.Error.Code != ErrCodeDeliveryFailed {
+		t.Errorf("Expected 503 DELIVERY_FAILED, got %d %s", response.Code, result.Error.Code)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SwitchStatus is where a dead man's switch is in its countdown
+type SwitchStatus string
+
+const (
+	// SwitchArmed is counting down and cancels on expiry unless re-armed
+	SwitchArmed SwitchStatus = "armed"
+	// SwitchTriggered expired and cancelled the owner's orders
+	SwitchTriggered SwitchStatus = "triggered"
+	// SwitchDisarmed was turned off by the owner
+	SwitchDisarmed SwitchStatus = "disarmed"
+)
+
+// DeadMansSwitchRequest arms, or re-arms, an owner's switch
+type DeadMansSwitchRequest struct {
+	Owner string `json:"owner" validate:"required"`
+	// Timeout is how long the owner has to re-arm, as a Go duration such as "10s"
+	Timeout string `json:"timeout" validate:"required,duration"`
+}
+
+// DeadMansSwitch is an owner's countdown to having every open order cancelled
+type DeadMansSwitch struct {
+	Owner     string       `json:"owner"`
+	Status    SwitchStatus `json:"status"`
+	Timeout   string       `json:"timeout"`
+	ArmedAt   time.Time    `json:"armed_at"`
+	ExpiresAt time.Time    `json:"expires_at"`
+	// TriggeredAt and Cancelled are set once the switch has fired
+	TriggeredAt *time.Time `json:"triggered_at,omitempty"`
+	Cancelled   []Order    `json:"cancelled,omitempty"`
+}
+
+// DeadMansSwitchesResponse lists every switch, by owner
+type DeadMansSwitchesResponse struct {
+	Switches []DeadMansSwitch `json:"switches"`
+	Count    int              `json:"count"`
+}
+
+// deadMansSwitch is an owner's switch and the timer that fires it. Arming
+// bumps the generation, so a timer from an earlier arming cannot fire it.
+type deadMansSwitch struct {
+	state      DeadMansSwitch
+	timer      *time.Timer
+	generation int
+}
+
+var (
+	switchesMu sync.Mutex
+	switches   = make(map[string]*deadMansSwitch)
+)
+
+// armSwitch starts or restarts an owner's countdown
+func armSwitch(owner string, timeout time.Duration) DeadMansSwitch {
+	switchesMu.Lock()
+	defer switchesMu.Unlock()
+
+	s, ok := switches[owner]
+	if !ok {
+		s = &deadMansSwitch{}
+		switches[owner] = s
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+
+	now := engineClock.Now()
+	s.state = DeadMansSwitch{
+		Owner:     owner,
+		Status:    SwitchArmed,
+		Timeout:   timeout.String(),
+		ArmedAt:   now,
+		ExpiresAt: now.Add(timeout),
+	}
+	s.generation++
+	generation := s.generation
+	s.timer = time.AfterFunc(timeout, func() { fireSwitch(owner, generation) })
+	return s.state
+}
+
+// fireSwitch cancels the owner's open orders on every symbol. A timer that
+// fires after the switch was re-armed or disarmed does nothing.
+func fireSwitch(owner string, generation int) {
+	switchesMu.Lock()
+	defer switchesMu.Unlock()
+
+	s, ok := switches[owner]
+	if !ok || s.generation != generation || s.state.Status != SwitchArmed {
+		return
+	}
+
+	now := engineClock.Now()
+	s.timer = nil
+	s.state.Status = SwitchTriggered
+	s.state.TriggeredAt = &now
+	s.state.Cancelled = cancelBatch(CancelBatchRequest{Owner: owner})
+	if s.state.Cancelled == nil {
+		s.state.Cancelled = []Order{}
+	}
+}
+
+// disarmSwitch stops an owner's countdown without cancelling anything
+func disarmSwitch(owner string) (DeadMansSwitch, bool) {
+	switchesMu.Lock()
+	defer switchesMu.Unlock()
+
+	s, ok := switches[owner]
+	if !ok {
+		return DeadMansSwitch{}, false
+	}
+	if s.state.Status == SwitchArmed {
+		s.timer.Stop()
+		s.timer = nil
+		s.state.Status = SwitchDisarmed
+	}
+	return s.state, true
+}
+
+// lookupSwitch returns an owner's switch
+func lookupSwitch(owner string) (DeadMansSwitch, bool) {
+	switchesMu.Lock()
+	defer switchesMu.Unlock()
+
+	s, ok := switches[owner]
+	if !ok {
+		return DeadMansSwitch{}, false
+	}
+	return s.state, true
+}
+
+// stopSwitches disarms every switch and forgets them, as when tests reset the engine
+func stopSwitches() {
+	switchesMu.Lock()
+	defer switchesMu.Unlock()
+
+	for _, s := range switches {
+		if s.timer != nil {
+			s.timer.Stop()
+		}
+	}
+	switches = make(map[string]*deadMansSwitch)
+}
+
+// armSwitchHandler arms or re-arms the owner's dead man's switch
+func armSwitchHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req DeadMansSwitchRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	timeout, _ := time.ParseDuration(req.Timeout)
+
+	json.NewEncoder(w).Encode(armSwitch(req.Owner, timeout))
+}
+
+// getSwitchesHandler returns every owner's switch
+func getSwitchesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switchesMu.Lock()
+	list := make([]DeadMansSwitch, 0, len(switches))
+	for _, s := range switches {
+		list = append(list, s.state)
+	}
+	switchesMu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Owner < list[j].Owner })
+
+	json.NewEncoder(w).Encode(DeadMansSwitchesResponse{
+		Switches: list,
+		Count:    len(list),
+	})
+}
+
+// getSwitchHandler returns the switch of the owner named in the path
+func getSwitchHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	owner := r.PathValue("owner")
+	state, ok := lookupSwitch(owner)
+	if !ok {
+		writeSwitchNotFound(w, owner)
+		return
+	}
+	json.NewEncoder(w).Encode(state)
+}
+
+// disarmSwitchHandler turns off the switch of the owner named in the path
+func disarmSwitchHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	owner := r.PathValue("owner")
+	state, ok := disarmSwitch(owner)
+	if !ok {
+		writeSwitchNotFound(w, owner)
+		return
+	}
+	json.NewEncoder(w).Encode(state)
+}
+
+// writeSwitchNotFound reports an owner that has never armed a switch
+func writeSwitchNotFound(w http.ResponseWriter, owner string) {
+	writeError(w, http.StatusNotFound, ErrCodeSwitchNotFound, "Switch not found",
+		"owner '"+owner+"' has not armed a dead man's switch")
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// armViaAPI arms an owner's switch through the endpoint
+func armViaAPI(t *testing.T, owner, timeout string) DeadMansSwitch {
+	t.Helper()
+	body := `{"owner": "` + owner + `", "timeout": "` + timeout + `"}`
+	response := serve(HTTPConfig{}, httptest.NewRequest("POST", "/api/v1/deadmans-switch", bytes.NewBufferString(body)))
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected the switch to arm, got %d: %s", response.Code, response.Body.String())
+	}
+	var state DeadMansSwitch
+	json.NewDecoder(response.Body).Decode(&state)
+	return state
+}
+
+func TestDeadMansSwitch_CancelsOnExpiry(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "bid-1", Side: SideBuy, Price: 99.0, Quantity: 1, Owner: "maker"})
+	placeOn(m, Order{ID: "ask-1", Side: SideSell, Price: 101.0, Quantity: 1, Owner: "maker"})
+	placeOn(m, Order{ID: "bid-2", Side: SideBuy, Price: 98.0, Quantity: 1, Owner: "other"})
+
+	if state := armViaAPI(t, "maker", "20ms"); state.Status != SwitchArmed || !state.ExpiresAt.After(state.ArmedAt) {
+		t.Fatalf("Expected an armed switch, got %+v", state)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	state, _ := lookupSwitch("maker")
+	for state.Status == SwitchArmed && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+		state, _ = lookupSwitch("maker")
+	}
+	if state.Status != SwitchTriggered || state.TriggeredAt == nil || len(state.Cancelled) != 2 {
+		t.Fatalf("Expected the switch to cancel both of the maker's orders, got %+v", state)
+	}
+	if resting := collectOrders(""); len(resting) != 1 || resting[0].ID != "bid-2" {
+		t.Errorf("Expected only the other owner's order to rest, got %+v", resting)
+	}
+}
+
+func TestDeadMansSwitch_ReArmAndDisarm(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "bid-1", Side: SideBuy, Price: 99.0, Quantity: 1, Owner: "maker"})
+
+	// The first arming's timer firing late must not cancel after a re-arm
+	armSwitch("maker", time.Hour)
+	armViaAPI(t, "maker", "1h")
+	fireSwitch("maker", 1)
+	if state, _ := lookupSwitch("maker"); state.Status != SwitchArmed || len(collectOrders("")) != 1 {
+		t.Fatalf("Expected the re-armed switch to keep counting down, got %+v", state)
+	}
+
+	response := serve(HTTPConfig{}, httptest.NewRequest("DELETE", "/api/v1/deadmans-switch/maker", nil))
+	var state DeadMansSwitch
+	json.NewDecoder(response.Body).Decode(&state)
+	if response.Code != http.StatusOK || state.Status != SwitchDisarmed {
+		t.Errorf("Expected the switch to disarm, got %d %+v", response.Code, state)
+	}
+	fireSwitch("maker", 2)
+	if len(collectOrders("")) != 1 {
+		t.Errorf("Expected a disarmed switch to leave the order resting")
+	}
+
+	response = serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/deadmans-switch", nil))
+	var list DeadMansSwitchesResponse
+	json.NewDecoder(response.Body).Decode(&list)
+	if list.Count != 1 || list.Switches[0].Owner != "maker" {
+		t.Errorf("Expected the maker's switch to be listed, got %+v", list)
+	}
+}
+
+func TestDeadMansSwitch_Errors(t *testing.T) {
+	setupTest()
+	response := serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/deadmans-switch/nobody", nil))
+	if result := decodeError(t, response); response.Code != http.StatusNotFound || result.Error.Code != ErrCodeSwitchNotFound {
+		t.Errorf("Expected 404 SWITCH_NOT_FOUND, got %d %s", response.Code, result.Error.Code)
+	}
+
+	for _, body := range []string{`{"timeout": "10s"}`, `{"owner": "maker"}`, `{"owner": "maker", "timeout": "-1s"}`, `{"owner": "maker", "timeout": "soon"}`} {
+		response := serve(HTTPConfig{}, httptest.NewRequest("POST", "/api/v1/deadmans-switch", bytes.NewBufferString(body)))
+		if result := decodeError(t, response); response.Code != http.StatusBadRequest || result.Error.Code != ErrCodeValidationFailed {
+			t.Errorf("%s: expected a validation failure, got %d %s", body, response.Code, result.Error.Code)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// PriceLevel is the total resting quantity at a single price
+type PriceLevel struct {
+	Price    float64 `json:"price"`
+	Quantity int     `json:"quantity"`
+	Orders   int     `json:"orders"`
+}
+
+// DepthSnapshot is a symbol's aggregated book as of a sequence number
+type DepthSnapshot struct {
+	Symbol   string `json:"symbol"`
+	Sequence int64  `json:"sequence"`
+	// Epoch is the run of sequence numbers Sequence belongs to
+	Epoch string       `json:"epoch,omitempty"`
+	Bids  []PriceLevel `json:"bids"`
+	Asks  []PriceLevel `json:"asks"`
+}
+
+// DepthMessageType tells a stream client how to treat a message
+type DepthMessageType string
+
+const (
+	// DepthMessageUpdate carries the new state of every level that changed
+	DepthMessageUpdate DepthMessageType = "update"
+	// DepthMessageResync tells the client it missed updates and must fetch a new snapshot
+	DepthMessageResync DepthMessageType = "resync"
+)
+
+// DepthUpdate is one message on the depth stream. Each update has the next
+// sequence number after the previous one, and a level with zero quantity has
+// left the book. A conflated update, sent to a client that fell behind,
+// carries the changes of every sequence from FirstSequence to Sequence.
+type DepthUpdate struct {
+	Type          DepthMessageType `json:"type"`
+	Symbol        string           `json:"symbol"`
+	FirstSequence int64            `json:"first_sequence,omitempty"`
+	Sequence      int64            `json:"sequence"`
+	Bids          []PriceLevel     `json:"bids,omitempty"`
+	Asks          []PriceLevel     `json:"asks,omitempty"`
+}
+
+// minDepthInterval and maxDepthInterval bound how often a throttled depth
+// stream may send
+const (
+	minDepthInterval = 10 * time.Millisecond
+	maxDepthInterval = time.Minute
+)
+
+// depthBufferSize is how many updates a stream client may fall behind by
+// before it is told to resync
+const depthBufferSize = 256
+
+// depthSubscriber is one stream client's queue of pending updates
+type depthSubscriber struct {
+	updates chan DepthUpdate
+	// session counts the subscriber's resyncs, when it has one
+	session *session
+	backlog *backlog
+}
+
+// send queues an update without blocking the matcher. A subscriber whose
+// queue is full has the update conflated, when its backlog conflates, and
+// otherwise loses what it has queued and gets a resync message instead.
+func (s *depthSubscriber) send(update DepthUpdate) {
+	if update.Type == DepthMessageUpdate && s.backlog.conflating() {
+		queueDepth(s.backlog, s.updates, update, update)
+		return
+	}
+	s.backlog.discard()
+	select {
+	case s.updates <- update:
+		return
+	default:
+	}
+
+	s.backlog.fellBehind()
+	if s.session != nil {
+		s.session.resyncs.Add(1)
+	}
+	for len(s.updates) > 0 {
+		select {
+		case <-s.updates:
+		default:
+		}
+	}
+	s.updates <- DepthUpdate{Type: DepthMessageResync, Symbol: update.Symbol, Sequence: update.Sequence}
+}
+
+// markDirty records that the level at price on one side of a book has changed
+func markDirty(book *OrderBook, side Side, price float64) {
+	if side == SideBuy {
+		if book.dirtyBids == nil {
+			book.dirtyBids = make(map[float64]struct{})
+		}
+		book.dirtyBids[price] = struct{}{}
+		return
+	}
+	if book.dirtyAsks == nil {
+		book.dirtyAsks = make(map[float64]struct{})
+	}
+	book.dirtyAsks[price] = struct{}{}
+}
+
+// publishDepth advances the book's sequence if anything changed since the last
+// call, refreshes the price analytics and sends the changed levels to every
+// subscriber and the book history. It runs on the matcher goroutine after
+// each command.
+func (m *matcher) publishDepth() {
+	book := m.book
+	if len(book.dirtyBids) == 0 && len(book.dirtyAsks) == 0 {
+		return
+	}
+
+	book.sequence++
+	m.refreshPrices()
+	if len(m.subscribers) > 0 || feed.watching(m.symbol) || bookHistory.Interval > 0 {
+		update := DepthUpdate{
+			Type:     DepthMessageUpdate,
+			Symbol:   m.symbol,
+			Sequence: book.sequence,
+			Bids:     changedLevels(&book.bidLiquidity, book.dirtyBids, SideBuy),
+			Asks:     changedLevels(&book.askLiquidity, book.dirtyAsks, SideSell),
+		}
+		for subscriber := range m.subscribers {
+			subscriber.send(update)
+		}
+		feed.depth(update)
+		if bookHistory.Interval > 0 {
+			m.recordHistory(update)
+		}
+	}
+
+	m.viewBids = m.viewBids || len(book.dirtyBids) > 0
+	m.viewAsks = m.viewAsks || len(book.dirtyAsks) > 0
+	clear(book.dirtyBids)
+	clear(book.dirtyAsks)
+}
+
+// changedLevels returns the current state of each dirty price on one side,
+// best price first, read from the side's totals rather than its orders
+func changedLevels(liquidity *sideLiquidity, dirty map[float64]struct{}, side Side) []PriceLevel {
+	if len(dirty) == 0 {
+		return nil
+	}
+
+	levels := make([]PriceLevel, 0, len(dirty))
+	for price := range dirty {
+		levels = append(levels, liquidity.level(price))
+	}
+	sort.Slice(levels, func(i, j int) bool {
+		if side == SideBuy {
+			return levels[i].Price > levels[j].Price
+		}
+		return levels[i].Price < levels[j].Price
+	})
+	return levels
+}
+
+// aggregateLevels collapses a side that is in priority order into at most
+// limit price levels. A limit of zero keeps every level.
+func aggregateLevels(orders []Order, limit int) []PriceLevel {
+	levels := make([]PriceLevel, 0)
+	for _, order := range orders {
+		if n := len(levels); n > 0 && levels[n-1].Price == order.Price {
+			levels[n-1].Quantity += order.Quantity
+			levels[n-1].Orders++
+			continue
+		}
+		if limit > 0 && len(levels) == limit {
+			break
+		}
+		levels = append(levels, PriceLevel{Price: order.Price, Quantity: order.Quantity, Orders: 1})
+	}
+	return levels
+}
+
+// depthSnapshot expires stale orders, publishes the result and aggregates the
+// book at the current sequence. It must run on the matcher.
+func (m *matcher) depthSnapshot(limit int) DepthSnapshot {
+	expireOrders(m.book, engineClock.Now())
+	m.publishDepth()
+
+	return DepthSnapshot{
+		Symbol:   m.symbol,
+		Sequence: m.book.sequence,
+		Epoch:    currentEpoch(),
+		Bids:     sideLevels(&m.book.bidLiquidity, &m.book.bids, limit),
+		Asks:     sideLevels(&m.book.askLiquidity, &m.book.asks, limit),
+	}
+}
+
+// depth aggregates a view into at most limit levels a side
+func (view *bookView) depth(symbol string, limit int) DepthSnapshot {
+	return DepthSnapshot{
+		Symbol:   symbol,
+		Sequence: view.sequence,
+		Epoch:    currentEpoch(),
+		Bids:     aggregateLevels(view.buy, limit),
+		Asks:     aggregateLevels(view.sell, limit),
+	}
+}
+
+// getDepthSnapshotHandler returns a symbol's aggregated depth and the sequence
+// number stream updates continue from, read from the latest view of the book
+func getDepthSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	m, ok := matcherFor(r.URL.Query().Get("symbol"))
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrCodeUnknownSymbol, "Unknown symbol",
+			"symbol '"+r.URL.Query().Get("symbol")+"' is not traded here")
+		return
+	}
+
+	limit := 0
+	if raw := r.URL.Query().Get("levels"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 0 {
+			writeError(w, http.StatusBadRequest, ErrCodeValidationFailed, "Validation failed",
+				[]string{"levels must be a non-negative integer (received: '" + raw + "')"})
+			return
+		}
+		limit = parsed
+	}
+	// Top-of-book callers only see the best level on each side
+	if !entitlementFrom(r.Context()).allows(EntitlementL2) {
+		limit = 1
+	}
+
+	writeBody(w, r, m.readView().depth(m.symbol, limit))
+}
+
+// depthUpgrader accepts stream connections from any origin, matching the
+// CORS policy of the REST endpoints
+var depthUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// depthStreamHandler streams depth updates for a symbol over a WebSocket.
+// Clients should connect first, then fetch a snapshot and apply the updates
+// that follow its sequence number. With an interval, the changes are held and
+// sent together once an interval, so each level appears at most once in it.
+func depthStreamHandler(w http.ResponseWriter, r *http.Request) {
+	m, ok := matcherFor(r.URL.Query().Get("symbol"))
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrCodeUnknownSymbol, "Unknown symbol",
+			"symbol '"+r.URL.Query().Get("symbol")+"' is not traded here")
+		return
+	}
+	var interval time.Duration
+	if raw := r.URL.Query().Get("interval"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil || parsed < minDepthInterval || parsed > maxDepthInterval {
+			writeError(w, http.StatusBadRequest, ErrCodeValidationFailed, "Validation failed",
+				[]string{"interval must be a duration from " + minDepthInterval.String() + " to " + maxDepthInterval.String() + " such as '100ms' (received: '" + raw + "')"})
+			return
+		}
+		interval = parsed
+	}
+
+	conn, err := depthUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already written an error response
+		return
+	}
+	defer conn.Close()
+	version := schemaVersionFrom(r.Context())
+
+	subscription := "depth:" + m.symbol
+	if interval > 0 {
+		subscription += "@" + interval.String()
+	}
+	subscriber := &depthSubscriber{updates: make(chan DepthUpdate, depthBufferSize)}
+	subscriber.session = openSession(SessionDepth, r, []string{subscription}, depthBufferSize,
+		func() int { return len(subscriber.updates) })
+	subscriber.backlog = newBacklog(subscriber.session, streamConfig.Conflate || interval > 0)
+	var tick <-chan time.Time
+	if interval > 0 {
+		subscriber.backlog.throttled = true
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	m.do(func() {
+		m.subscribers[subscriber] = struct{}{}
+	})
+	defer m.do(func() {
+		delete(m.subscribers, subscriber)
+	})
+	// Deferred last so the session leaves the list before its subscription does
+	defer subscriber.session.close()
+
+	// The stream is one-way; reading only notices when the client goes away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	write := func(update DepthUpdate) error {
+		if err := writeJSONMessage(conn, update, version); err != nil {
+			return err
+		}
+		subscriber.session.sent.Add(1)
+		return nil
+	}
+	for {
+		select {
+		case update := <-subscriber.updates:
+			if write(update) != nil {
+				return
+			}
+		case <-subscriber.backlog.ready:
+			if flushBacklog(subscriber.backlog, subscriber.updates, write, func(update DepthUpdate) DepthUpdate { return update }) != nil {
+				return
+			}
+		case <-tick:
+			if flushBacklog(subscriber.backlog, subscriber.updates, write, func(update DepthUpdate) DepthUpdate { return update }) != nil {
+				return
+			}
+		case <-subscriber.session.kicked:
+			subscriber.session.writeKicked(conn)
+			return
+		case <-closed:
+			return
+		}
+		if len(subscriber.updates) == 0 {
+			subscriber.backlog.caughtUp()
+		}
+	}
+}
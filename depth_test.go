@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// subscribeDepth attaches a subscriber with the given queue size to the default matcher
+func subscribeDepth(t *testing.T, size int) (*matcher, *depthSubscriber) {
+	t.Helper()
+
+	m, _ := matcherFor("")
+	subscriber := &depthSubscriber{updates: make(chan DepthUpdate, size)}
+	m.do(func() {
+		m.subscribers[subscriber] = struct{}{}
+	})
+	return m, subscriber
+}
+
+// waitForSubscribers waits until a matcher has exactly count depth subscribers
+func waitForSubscribers(t *testing.T, m *matcher, count int) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		var current int
+		m.do(func() { current = len(m.subscribers) })
+		if current == count {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d depth subscribers, got %d", count, current)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// closeStream closes a depth or L3 stream client and waits for its handler
+// to unsubscribe from the matcher, which it does through m.do on the way out,
+// so the next test's reset cannot close the matcher under it
+func closeStream(t *testing.T, m *matcher, conn *websocket.Conn) {
+	t.Helper()
+	conn.Close()
+	deadline := time.Now().Add(time.Second)
+	for {
+		var current int
+		m.do(func() { current = len(m.subscribers) + len(m.book.l3.subscribers) })
+		if current == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the stream to unsubscribe, got %d subscribers", current)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// placeOn runs processOrder for an order on its matcher
+func placeOn(m *matcher, order Order) {
+	order.Status = OrderStatusPending
+	order.CreatedAt = time.Now()
+	m.do(func() {
+		processOrder(order)
+	})
+}
+
+// nextUpdate returns the next queued update or fails the test
+func nextUpdate(t *testing.T, subscriber *depthSubscriber) DepthUpdate {
+	t.Helper()
+
+	select {
+	case update := <-subscriber.updates:
+		return update
+	default:
+		t.Fatal("Expected a queued depth update")
+		return DepthUpdate{}
+	}
+}
+
+func TestPublishDepth_SequencedLevelUpdates(t *testing.T) {
+	setupTest()
+	m, subscriber := subscribeDepth(t, 16)
+
+	placeOn(m, Order{ID: "sell-1", Side: SideSell, Price: 100.0, Quantity: 10})
+	placeOn(m, Order{ID: "sell-2", Side: SideSell, Price: 100.0, Quantity: 5})
+	placeOn(m, Order{ID: "buy-1", Side: SideBuy, Price: 100.0, Quantity: 12})
+
+	first := nextUpdate(t, subscriber)
+	if first.Sequence != 1 || len(first.Asks) != 1 || first.Asks[0].Quantity != 10 {
+		t.Errorf("Unexpected first update %+v", first)
+	}
+
+	second := nextUpdate(t, subscriber)
+	if second.Sequence != 2 || second.Asks[0].Quantity != 15 || second.Asks[0].Orders != 2 {
+		t.Errorf("Unexpected second update %+v", second)
+	}
+
+	// The buy fills sell-1 and part of sell-2, leaving 3 at 100.00
+	third := nextUpdate(t, subscriber)
+	if third.Sequence != 3 || len(third.Bids) != 0 || third.Asks[0].Quantity != 3 || third.Asks[0].Orders != 1 {
+		t.Errorf("Unexpected third update %+v", third)
+	}
+
+	m.do(func() {
+		cancelOrder("", "sell-2")
+	})
+
+	fourth := nextUpdate(t, subscriber)
+	if fourth.Sequence != 4 || fourth.Asks[0].Quantity != 0 {
+		t.Errorf("Expected the 100.00 level to be removed, got %+v", fourth)
+	}
+}
+
+func TestPublishDepth_NoUpdateWithoutChange(t *testing.T) {
+	setupTest()
+	m, subscriber := subscribeDepth(t, 16)
+
+	m.do(func() {
+		cancelOrder("", "missing")
+	})
+
+	if len(subscriber.updates) != 0 {
+		t.Errorf("Expected no update for a command that changed nothing, got %d", len(subscriber.updates))
+	}
+}
+
+func TestDepthSubscriber_ResyncWhenFull(t *testing.T) {
+	setupTest()
+	m, subscriber := subscribeDepth(t, 2)
+
+	for i, price := range []float64{101.0, 102.0, 103.0} {
+		placeOn(m, Order{ID: fmt.Sprintf("sell-%d", i+1), Side: SideSell, Price: price, Quantity: 1})
+	}
+
+	update := nextUpdate(t, subscriber)
+	if update.Type != DepthMessageResync || update.Sequence != 3 {
+		t.Errorf("Expected a resync at sequence 3, got %+v", update)
+	}
+
+	if len(subscriber.updates) != 0 {
+		t.Errorf("Expected the queue to hold only the resync, got %d more", len(subscriber.updates))
+	}
+}
+
+func TestGetDepthSnapshotHandler(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+
+	placeOn(m, Order{ID: "buy-1", Side: SideBuy, Price: 99.0, Quantity: 10})
+	placeOn(m, Order{ID: "buy-2", Side: SideBuy, Price: 99.0, Quantity: 5})
+	placeOn(m, Order{ID: "buy-3", Side: SideBuy, Price: 98.0, Quantity: 1})
+	placeOn(m, Order{ID: "sell-1", Side: SideSell, Price: 101.0, Quantity: 7})
+
+	request := httptest.NewRequest("GET", "/api/v1/depth/snapshot?levels=1", nil)
+	response := httptest.NewRecorder()
+	getDepthSnapshotHandler(response, request)
+
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", response.Code)
+	}
+
+	var snapshot DepthSnapshot
+	json.Unmarshal(response.Body.Bytes(), &snapshot)
+
+	if snapshot.Sequence != 4 {
+		t.Errorf("Expected sequence 4, got %d", snapshot.Sequence)
+	}
+
+	if len(snapshot.Bids) != 1 || snapshot.Bids[0].Quantity != 15 || snapshot.Bids[0].Orders != 2 {
+		t.Errorf("Unexpected bids %+v", snapshot.Bids)
+	}
+
+	if len(snapshot.Asks) != 1 || snapshot.Asks[0].Price != 101.0 {
+		t.Errorf("Unexpected asks %+v", snapshot.Asks)
+	}
+}
+
+func TestGetDepthSnapshotHandler_InvalidLevels(t *testing.T) {
+	setupTest()
+
+	request := httptest.NewRequest("GET", "/api/v1/depth/snapshot?levels=-1", nil)
+	response := httptest.NewRecorder()
+	getDepthSnapshotHandler(response, request)
+
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", response.Code)
+	}
+}
+
+func TestDepthStreamHandler(t *testing.T) {
+	setupTest()
+	server := httptest.NewServer(http.HandlerFunc(depthStreamHandler))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Expected to connect, got %v", err)
+	}
+
+	// Wait for the handler to register before changing the book
+	m, _ := matcherFor("")
+	defer closeStream(t, m, conn)
+	waitForSubscribers(t, m, 1)
+
+	placeOn(m, Order{ID: "buy-1", Side: SideBuy, Price: 99.5, Quantity: 4})
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var update DepthUpdate
+	if err := conn.ReadJSON(&update); err != nil {
+		t.Fatalf("Expected an update, got %v", err)
+	}
+
+	if update.Type != DepthMessageUpdate || update.Sequence != 1 || len(update.Bids) != 1 || update.Bids[0].Price != 99.5 {
+		t.Errorf("Unexpected update %+v", update)
+	}
+}
+
+func TestDepthStreamHandler_Interval(t *testing.T) {
+	setupTest()
+	server := httptest.NewServer(http.HandlerFunc(depthStreamHandler))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"?interval=200ms", nil)
+	if err != nil {
+		t.Fatalf("Expected to connect, got %v", err)
+	}
+	m, _ := matcherFor("")
+	defer closeStream(t, m, conn)
+	waitForSubscribers(t, m, 1)
+
+	placeOn(m, Order{ID: "buy-1", Side: SideBuy, Price: 99.5, Quantity: 4})
+	placeOn(m, Order{ID: "buy-2", Side: SideBuy, Price: 99.5, Quantity: 2})
+	placeOn(m, Order{ID: "buy-3", Side: SideBuy, Price: 99.0, Quantity: 1})
+
+	// The three changes arrive together, unless an interval ended among them
+	var updates []DepthUpdate
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for len(updates) == 0 || updates[len(updates)-1].Sequence < 3 {
+		var update DepthUpdate
+		if err := conn.ReadJSON(&update); err != nil {
+			t.Fatalf("Expected an update, got %v after %+v", err, updates)
+		}
+		updates = append(updates, update)
+	}
+	if len(updates) > 2 || updates[0].FirstSequence != 1 {
+		t.Errorf("Expected the changes sent together from sequence 1, got %+v", updates)
+	}
+	bids := []PriceLevel{{Price: 99.5, Quantity: 6, Orders: 2}, {Price: 99.0, Quantity: 1, Orders: 1}}
+	if len(updates) == 1 && !reflect.DeepEqual(updates[0].Bids, bids) {
+		t.Errorf("Expected the latest state of each level, got %+v", updates[0].Bids)
+	}
+}
+
+func TestDepthStreamHandler_InvalidInterval(t *testing.T) {
+	setupTest()
+	for _, raw := range []string{"1ms", "2m", "soon"} {
+		response := serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/depth/stream?interval="+raw, nil))
+		if result := decodeError(t, response); response.Code != http.StatusBadRequest || result.Error.Code != ErrCodeValidationFailed {
+			t.Errorf("Expected %q refused, got %d %s", raw, response.Code, result.Error.Code)
+		}
+	}
+}
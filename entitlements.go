@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Entitlement is how much market data an API key may see, from the best bid
+// and offer up to every order in the book
+type Entitlement string
+
+const (
+	// EntitlementTop sees trades and the best price on each side
+	EntitlementTop Entitlement = "top"
+	// EntitlementL2 adds aggregated depth at every level and the depth stream
+	EntitlementL2 Entitlement = "l2"
+	// EntitlementL3 adds individual orders with their IDs
+	EntitlementL3 Entitlement = "l3"
+)
+
+// entitlementLevels lists the entitlements from least to most data
+var entitlementLevels = []Entitlement{EntitlementTop, EntitlementL2, EntitlementL3}
+
+// allows reports whether e covers the data need requires
+func (e Entitlement) allows(need Entitlement) bool {
+	return slices.Index(entitlementLevels, e) >= slices.Index(entitlementLevels, need)
+}
+
+// entitlementKey is the request context key for the caller's entitlement
+type entitlementKey struct{}
+
+// entitlementFrom returns the caller's entitlement. Requests that went
+// through no entitlement check see everything.
+func entitlementFrom(ctx context.Context) Entitlement {
+	if e, ok := ctx.Value(entitlementKey{}).(Entitlement); ok {
+		return e
+	}
+	return EntitlementL3
+}
+
+// entitlementFor is the entitlement of an API key under cfg
+func (cfg HTTPConfig) entitlementFor(key string) Entitlement {
+	if e, ok := cfg.Entitlements[key]; ok && key != "" {
+		return e
+	}
+	if cfg.DefaultEntitlement != "" {
+		return cfg.DefaultEntitlement
+	}
+	return EntitlementL3
+}
+
+// checkEntitlement resolves the caller's entitlement for the handlers that
+// trim what they return, and rejects callers below need
+func checkEntitlement(cfg HTTPConfig, need Entitlement) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			entitlement := cfg.entitlementFor(requestAPIKey(r))
+			if need != "" && !entitlement.allows(need) {
+				writeError(w, http.StatusForbidden, ErrCodeNotEntitled, "Not entitled",
+					fmt.Sprintf("this data needs the %s entitlement; the API key has %s", need, entitlement))
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), entitlementKey{}, entitlement)))
+		})
+	}
+}
+
+// parseEntitlements reads the -entitlements list of key=level pairs and the
+// -default-entitlement level into cfg
+func (cfg *HTTPConfig) parseEntitlements(list, defaultLevel string) error {
+	for _, item := range splitList(list) {
+		key, level, ok := strings.Cut(item, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return fmt.Errorf("entitlement %q must be key=level", item)
+		}
+		e, err := parseEntitlement(level)
+		if err != nil {
+			return err
+		}
+		if cfg.Entitlements == nil {
+			cfg.Entitlements = make(map[string]Entitlement)
+		}
+		cfg.Entitlements[strings.TrimSpace(key)] = e
+	}
+
+	if defaultLevel != "" {
+		e, err := parseEntitlement(defaultLevel)
+		if err != nil {
+			return err
+		}
+		cfg.DefaultEntitlement = e
+	}
+	return nil
+}
+
+// parseEntitlement reads one entitlement level
+func parseEntitlement(level string) (Entitlement, error) {
+	e := Entitlement(strings.TrimSpace(level))
+	if !slices.Contains(entitlementLevels, e) {
+		return "", fmt.Errorf("entitlement must be top, l2 or l3 (received: %q)", level)
+	}
+	return e, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// tiered has one API key per entitlement and leaves keyless requests at top
+var tiered = HTTPConfig{
+	Entitlements:       map[string]Entitlement{"top-key": EntitlementTop, "l2-key": EntitlementL2, "l3-key": EntitlementL3},
+	DefaultEntitlement: EntitlementTop,
+}
+
+// getAs sends a GET with an API key
+func getAs(cfg HTTPConfig, key, path string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest("GET", path, nil)
+	if key != "" {
+		request.Header.Set("X-API-Key", key)
+	}
+	return serve(cfg, request)
+}
+
+func TestEntitlements_GateMarketData(t *testing.T) {
+	setupTest()
+	cases := []struct {
+		path string
+		need Entitlement
+	}{
+		{"/api/v1/trades", EntitlementTop},
+		{"/api/v1/analytics/price", EntitlementTop},
+		{"/api/v1/analytics/liquidity", EntitlementL2},
+		{"/api/v1/orderbook", EntitlementL3},
+		{"/api/v1/orders", EntitlementL3},
+		{"/api/v1/order-events", EntitlementL3},
+		{"/api/v1/orders/history", EntitlementL3},
+		{"/api/v1/l3/snapshot", EntitlementL3},
+	}
+	for _, tc := range cases {
+		for _, key := range []string{"", "top-key", "l2-key", "l3-key"} {
+			response := getAs(tiered, key, tc.path)
+			allowed := tiered.entitlementFor(key).allows(tc.need)
+			if allowed && response.Code != http.StatusOK {
+				t.Errorf("%s with %q: expected 200, got %d", tc.path, key, response.Code)
+			}
+			if !allowed {
+				if result := decodeError(t, response); response.Code != http.StatusForbidden || result.Error.Code != ErrCodeNotEntitled {
+					t.Errorf("%s with %q: expected 403 NOT_ENTITLED, got %d %s", tc.path, key, response.Code, result.Error.Code)
+				}
+			}
+		}
+	}
+
+	// Without entitlements configured every caller sees everything
+	if response := getAs(HTTPConfig{}, "", "/api/v1/orderbook"); response.Code != http.StatusOK {
+		t.Errorf("Expected an open order book, got %d", response.Code)
+	}
+}
+
+func TestEntitlements_TopOfBookDepth(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+	for _, price := range []float64{99.0, 98.0, 97.0} {
+		placeOn(m, Order{ID: generateOrderID(), Side: SideBuy, Price: price, Quantity: 1})
+	}
+
+	for key, levels := range map[string]int{"top-key": 1, "l2-key": 3} {
+		var snapshot DepthSnapshot
+		json.NewDecoder(getAs(tiered, key, "/api/v1/depth/snapshot").Body).Decode(&snapshot)
+		if len(snapshot.Bids) != levels || snapshot.Bids[0].Price != 99.0 {
+			t.Errorf("%s: expected %d bid levels from 99, got %+v", key, levels, snapshot.Bids)
+		}
+	}
+}
+
+func TestEntitlements_DepthStream(t *testing.T) {
+	setupTest()
+	server := httptest.NewServer(newServer(tiered))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/v1/depth/stream"
+
+	_, response, err := websocket.DefaultDialer.Dial(url, http.Header{"X-API-Key": {"top-key"}})
+	if err == nil || response.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected the top-of-book key to be refused the stream, got %v", err)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"X-API-Key": {"l2-key"}})
+	if err != nil {
+		t.Fatalf("Expected the l2 key to connect, got %v", err)
+	}
+	m, _ := matcherFor("")
+	defer closeStream(t, m, conn)
+	waitForSubscribers(t, m, 1)
+	if list := listSessions(t); list.Count != 1 || list.Sessions[0].Entitlement != EntitlementL2 {
+		t.Errorf("Expected the session to record its entitlement, got %+v", list)
+	}
+}
+
+func TestLoadConfig_EntitlementFlags(t *testing.T) {
+	cfg, err := loadConfig([]string{"-entitlements", "a=top, b=l3", "-default-entitlement", "l2"})
+	if err != nil || cfg.HTTP.Entitlements["a"] != EntitlementTop || cfg.HTTP.Entitlements["b"] != EntitlementL3 || cfg.HTTP.DefaultEntitlement != EntitlementL2 {
+		t.Errorf("Unexpected entitlements %+v (%v)", cfg.HTTP, err)
+	}
+	for _, args := range [][]string{{"-entitlements", "a=l4"}, {"-entitlements", "a"}, {"-default-entitlement", "all"}} {
+		if _, err := loadConfig(args); err == nil {
+			t.Errorf("%v: expected the entitlements to be refused", args)
+		}
+	}
+}
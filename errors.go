@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// ErrorCode is a machine-readable reason attached to every error response
+type ErrorCode string
+
+const (
+	ErrCodeMethodNotAllowed   ErrorCode = "METHOD_NOT_ALLOWED"
+	ErrCodeInvalidJSON        ErrorCode = "INVALID_JSON"
+	ErrCodeInvalidProtobuf    ErrorCode = "INVALID_PROTOBUF"
+	ErrCodeUnsupportedType    ErrorCode = "UNSUPPORTED_MEDIA_TYPE"
+	ErrCodeValidationFailed   ErrorCode = "VALIDATION_FAILED"
+	ErrCodeOrderNotFound      ErrorCode = "ORDER_NOT_FOUND"
+	ErrCodeTradeNotFound      ErrorCode = "TRADE_NOT_FOUND"
+	ErrCodeUnknownSymbol      ErrorCode = "UNKNOWN_SYMBOL"
+	ErrCodeOrderExpired       ErrorCode = "ORDER_EXPIRED"
+	ErrCodeSelfTrade          ErrorCode = "SELF_TRADE"
+	ErrCodeNoLiquidity        ErrorCode = "NO_LIQUIDITY"
+	ErrCodeMinQuantityNotMet  ErrorCode = "MIN_QUANTITY_NOT_MET"
+	ErrCodeNoReferencePrice   ErrorCode = "NO_REFERENCE_PRICE"
+	ErrCodeWouldCross         ErrorCode = "WOULD_CROSS"
+	ErrCodeBatchAuction       ErrorCode = "BATCH_AUCTION"
+	ErrCodeNotPerpetual       ErrorCode = "NOT_PERPETUAL"
+	ErrCodeInsufficientMargin ErrorCode = "INSUFFICIENT_MARGIN"
+	ErrCodePositionLimit      ErrorCode = "POSITION_LIMIT_EXCEEDED"
+	ErrCodeLeverageTooHigh    ErrorCode = "LEVERAGE_TOO_HIGH"
+	ErrCodeNotOption          ErrorCode = "NOT_OPTION"
+	ErrCodeOptionExpired      ErrorCode = "OPTION_EXPIRED"
+	ErrCodeThrottled          ErrorCode = "THROTTLED"
+	ErrCodeTradingHalted      ErrorCode = "TRADING_HALTED"
+	ErrCodeMarketClosed       ErrorCode = "MARKET_CLOSED"
+	ErrCodeNoBookHistory      ErrorCode = "NO_BOOK_HISTORY"
+	ErrCodeNoCalendar         ErrorCode = "NO_CALENDAR"
+	ErrCodeShadowSymbol       ErrorCode = "SHADOW_SYMBOL"
+	ErrCodePaperUnsupported   ErrorCode = "PAPER_UNSUPPORTED"
+	ErrCodeScriptRejected     ErrorCode = "SCRIPT_REJECTED"
+	ErrCodeScriptInvalid      ErrorCode = "SCRIPT_INVALID"
+	ErrCodeCreditRefused      ErrorCode = "CREDIT_REFUSED"
+	ErrCodeCreditUnavailable  ErrorCode = "CREDIT_UNAVAILABLE"
+	ErrCodeBasketRejected     ErrorCode = "BASKET_REJECTED"
+	ErrCodeBasketNotFound     ErrorCode = "BASKET_NOT_FOUND"
+	ErrCodeSpreadUnsupported  ErrorCode = "SPREAD_UNSUPPORTED"
+	ErrCodeLegRefused         ErrorCode = "LEG_REFUSED"
+	ErrCodeUnauthorized       ErrorCode = "UNAUTHORIZED"
+	ErrCodeNotEntitled        ErrorCode = "NOT_ENTITLED"
+	ErrCodeForbidden          ErrorCode = "FORBIDDEN"
+	ErrCodeIPNotAllowed       ErrorCode = "IP_NOT_ALLOWED"
+	ErrCodeInvalidSignature   ErrorCode = "INVALID_SIGNATURE"
+	ErrCodeReplayedRequest    ErrorCode = "REPLAYED_REQUEST"
+	ErrCodeAlgosRunning       ErrorCode = "ALGOS_RUNNING"
+	ErrCodeAccountNotFound    ErrorCode = "ACCOUNT_NOT_FOUND"
+	ErrCodeAccountExists      ErrorCode = "ACCOUNT_EXISTS"
+	ErrCodeInsufficientFunds  ErrorCode = "INSUFFICIENT_FUNDS"
+	ErrCodeNotSubAccount      ErrorCode = "NOT_SUB_ACCOUNT"
+	ErrCodeSharedSubAccount   ErrorCode = "SHARED_SUB_ACCOUNT"
+	ErrCodeSwitchNotFound     ErrorCode = "SWITCH_NOT_FOUND"
+	ErrCodeSessionNotFound    ErrorCode = "SESSION_NOT_FOUND"
+	ErrCodeSessionInUse       ErrorCode = "SESSION_IN_USE"
+	ErrCodeSequenceGap        ErrorCode = "SEQUENCE_GAP"
+	ErrCodeDuplicateSequence  ErrorCode = "DUPLICATE_SEQUENCE"
+	ErrCodeConsumerNotFound   ErrorCode = "CONSUMER_GROUP_NOT_FOUND"
+	ErrCodeOffsetConflict     ErrorCode = "OFFSET_CONFLICT"
+	ErrCodeDeadLetterNotFound ErrorCode = "DEAD_LETTER_NOT_FOUND"
+	ErrCodeDeliveryFailed     ErrorCode = "DELIVERY_FAILED"
+	ErrCodeStandby            ErrorCode = "STANDBY"
+	ErrCodeEngineBusy         ErrorCode = "ENGINE_BUSY"
+	ErrCodeRequestTimeout     ErrorCode = "REQUEST_TIMEOUT"
+	ErrCodeNotStandby         ErrorCode = "NOT_STANDBY"
+	ErrCodeNotSimulating      ErrorCode = "NOT_SIMULATING"
+	ErrCodeInternal           ErrorCode = "INTERNAL_ERROR"
+)
+
+// APIError is the body of the structured error envelope
+type APIError struct {
+	Code    ErrorCode   `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// ErrorResponse is the envelope returned by every endpoint on failure
+type ErrorResponse struct {
+	Error   APIError `json:"error"`
+	OrderID string   `json:"order_id,omitempty"`
+}
+
+// writeError writes a structured error envelope with the given status code
+func writeError(w http.ResponseWriter, status int, code ErrorCode, message string, details interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{
+		Error: APIError{
+			Code:    code,
+			Message: message,
+			Details: details,
+		},
+	})
+}
+
+// writeMethodNotAllowed reports that the endpoint does not accept the request
+// method, listing the methods it does accept
+func writeMethodNotAllowed(w http.ResponseWriter, allowed []string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed,
+		"Method not allowed", "This endpoint supports "+strings.Join(allowed, ", "))
+}
+
+// writeRejection reports an order the engine accepted for processing but
+// refused to book. A throttled order is reported as too many requests.
+func writeRejection(w http.ResponseWriter, order Order) {
+	w.Header().Set("Content-Type", "application/json")
+	status := http.StatusUnprocessableEntity
+	if order.RejectReason == ErrCodeThrottled {
+		status = http.StatusTooManyRequests
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{
+		Error: APIError{
+			Code:    order.RejectReason,
+			Message: rejectMessages[order.RejectReason],
+		},
+		OrderID: order.ID,
+	})
+}
+
+// rejectMessages holds the human-readable text for each rejection reason
+var rejectMessages = map[ErrorCode]string{
+	ErrCodeOrderExpired:       "Order expiry time is in the past",
+	ErrCodeSelfTrade:          "Order would cross or lock a resting order from the same owner",
+	ErrCodeNoLiquidity:        "No resting orders were available to fill the order immediately",
+	ErrCodeMinQuantityNotMet:  "Not enough quantity is available to meet the order's minimum fill",
+	ErrCodeNoReferencePrice:   "The book has no quote to price the pegged order from",
+	ErrCodeWouldCross:         "The amended price would cross the book; cancel and place a new order to trade",
+	ErrCodeBatchAuction:       "Batch auction symbols only take limit orders, without a minimum quantity or all-or-none",
+	ErrCodeInsufficientMargin: "The account would not have the initial margin for its perpetual positions and orders",
+	ErrCodePositionLimit:      "The order and the account's resting orders could take its position in the symbol past its limit",
+	ErrCodeOptionExpired:      "The option is past its expiry",
+	ErrCodeThrottled:          "The owner has sent more order messages than its throttle allows",
+	ErrCodeTradingHalted:      "Trading in the symbol is halted after a fast price move; see /market-status for when it reopens",
+	ErrCodeMarketClosed:       "The symbol is closed, or past its close and the order would trade; see /market-status for when it opens",
+	ErrCodeShadowSymbol:       "The symbol mirrors an outside exchange and takes no orders unless paper trading is on",
+	ErrCodePaperUnsupported:   "Paper trading only takes limit orders, without a minimum quantity or all-or-none",
+	ErrCodeScriptRejected:     "An order script refused the order; the order's events give its reason",
+	ErrCodeCreditRefused:      "The credit check refused the order; the order's events give its reason",
+	ErrCodeCreditUnavailable:  "The credit check failed or did not answer in time, so the order was refused",
+	ErrCodeBasketRejected:     "Another order in the basket was refused, so none of its orders were placed",
+	ErrCodeSpreadUnsupported:  "Spread symbols only take limit and market orders, without a minimum quantity or all-or-none",
+	ErrCodeLegRefused:         "The spread's other leg was refused or filled nothing, so this one was not sent",
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// decodeError parses a structured error envelope from a recorded response
+func decodeError(t *testing.T, response *httptest.ResponseRecorder) ErrorResponse {
+	t.Helper()
+
+	var result ErrorResponse
+	if err := json.Unmarshal(response.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Expected JSON error envelope, got %q", response.Body.String())
+	}
+	return result
+}
+
+func TestPlaceOrderHandler_ValidationErrorCode(t *testing.T) {
+	setupTest()
+
+	jsonData, _ := json.Marshal(PlaceOrderRequest{Side: SideBuy, Price: 0, Quantity: 10})
+	request := httptest.NewRequest("POST", "/api/v1/orders", bytes.NewBuffer(jsonData))
+	response := httptest.NewRecorder()
+
+	placeOrderHandler(response, request)
+
+	result := decodeError(t, response)
+	if result.Error.Code != ErrCodeValidationFailed {
+		t.Errorf("Expected code %s, got %s", ErrCodeValidationFailed, result.Error.Code)
+	}
+
+	if result.Error.Details == nil {
+		t.Error("Expected validation details")
+	}
+}
+
+func TestPlaceOrderHandler_InvalidJSONErrorCode(t *testing.T) {
+	setupTest()
+
+	request := httptest.NewRequest("POST", "/api/v1/orders", bytes.NewBufferString("{"))
+	response := httptest.NewRecorder()
+
+	placeOrderHandler(response, request)
+
+	result := decodeError(t, response)
+	if result.Error.Code != ErrCodeInvalidJSON {
+		t.Errorf("Expected code %s, got %s", ErrCodeInvalidJSON, result.Error.Code)
+	}
+}
+
+func TestGetHandlers_MethodNotAllowedErrorCode(t *testing.T) {
+	paths := []string{"/api/v1/orders/order-1", "/api/v1/trades", "/api/v1/orderbook", "/api/v1/order-events"}
+
+	for _, path := range paths {
+		setupTest()
+
+		request := httptest.NewRequest("POST", path, nil)
+		response := serve(HTTPConfig{}, request)
+
+		if response.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s: expected status 405, got %d", path, response.Code)
+		}
+		if response.Header().Get("Allow") == "" {
+			t.Errorf("%s: expected an Allow header", path)
+		}
+
+		result := decodeError(t, response)
+		if result.Error.Code != ErrCodeMethodNotAllowed {
+			t.Errorf("%s: expected code %s, got %s", path, ErrCodeMethodNotAllowed, result.Error.Code)
+		}
+	}
+}
+
+func TestPlaceOrderHandler_RejectsExpiredOrder(t *testing.T) {
+	setupTest()
+
+	past := time.Now().Add(-time.Minute)
+	jsonData, _ := json.Marshal(PlaceOrderRequest{Side: SideBuy, Price: 100.0, Quantity: 10, ExpiresAt: &past})
+	request := httptest.NewRequest("POST", "/api/v1/orders", bytes.NewBuffer(jsonData))
+	response := httptest.NewRecorder()
+
+	placeOrderHandler(response, request)
+
+	if response.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422, got %d", response.Code)
+	}
+
+	result := decodeError(t, response)
+	if result.Error.Code != ErrCodeOrderExpired {
+		t.Errorf("Expected code %s, got %s", ErrCodeOrderExpired, result.Error.Code)
+	}
+
+	if result.OrderID == "" {
+		t.Error("Expected rejected order ID in the envelope")
+	}
+}
+
+func TestPlaceOrderHandler_RejectsSelfTrade(t *testing.T) {
+	setupTest()
+
+	restOn(&bookFor("").asks, Order{
+		ID:        "sell-1",
+		Side:      SideSell,
+		Price:     100.0,
+		Quantity:  10,
+		Status:    OrderStatusPending,
+		CreatedAt: time.Now(),
+		Owner:     "alice",
+	})
+
+	// A buy at the ask price would lock the owner's own book
+	jsonData, _ := json.Marshal(PlaceOrderRequest{Side: SideBuy, Price: 100.0, Quantity: 5, Owner: "alice"})
+	request := httptest.NewRequest("POST", "/api/v1/orders", bytes.NewBuffer(jsonData))
+	response := httptest.NewRecorder()
+
+	placeOrderHandler(response, request)
+
+	if response.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422, got %d", response.Code)
+	}
+
+	result := decodeError(t, response)
+	if result.Error.Code != ErrCodeSelfTrade {
+		t.Errorf("Expected code %s, got %s", ErrCodeSelfTrade, result.Error.Code)
+	}
+
+	if len(tradeStore.List("")) != 0 {
+		t.Errorf("Expected no trades, got %d", len(tradeStore.List("")))
+	}
+
+	if bookFor("").asks.orders()[0].Quantity != 10 {
+		t.Errorf("Expected resting order to be untouched, got quantity %d", bookFor("").asks.orders()[0].Quantity)
+	}
+}
+
+func TestProcessOrder_SelfTradeOnlyAppliesToSameOwner(t *testing.T) {
+	setupTest()
+
+	restOn(&bookFor("").bids, Order{
+		ID:        "buy-1",
+		Side:      SideBuy,
+		Price:     100.0,
+		Quantity:  10,
+		Status:    OrderStatusPending,
+		CreatedAt: time.Now(),
+		Owner:     "alice",
+	})
+
+	// Another owner trades normally
+	result := processOrder(Order{ID: "sell-1", Side: SideSell, Price: 100.0, Quantity: 5, Status: OrderStatusPending, CreatedAt: time.Now(), Owner: "bob"})
+	if result.Status != OrderStatusFilled {
+		t.Errorf("Expected bob's order to fill, got %s", result.Status)
+	}
+
+	// The same owner may still rest a non-crossing order
+	result = processOrder(Order{ID: "sell-2", Side: SideSell, Price: 101.0, Quantity: 5, Status: OrderStatusPending, CreatedAt: time.Now(), Owner: "alice"})
+	if result.Status != OrderStatusPending {
+		t.Errorf("Expected alice's non-crossing order to rest, got %s", result.Status)
+	}
+
+	// Anonymous orders are never treated as self-trades
+	result = processOrder(Order{ID: "sell-3", Side: SideSell, Price: 99.0, Quantity: 1, Status: OrderStatusPending, CreatedAt: time.Now()})
+	if result.RejectReason != "" {
+		t.Errorf("Expected anonymous order to be accepted, got %s", result.RejectReason)
+	}
+}
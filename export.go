@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/csv"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// exportFlushRows is how many CSV rows are written between flushes to the client
+const exportFlushRows = 1000
+
+// tradeRow is one trade as written to an export file
+type tradeRow struct {
+	ID       string  `parquet:"id"`
+	Symbol   string  `parquet:"symbol"`
+	MakerID  string  `parquet:"maker_id"`
+	TakerID  string  `parquet:"taker_id"`
+	Venue    string  `parquet:"venue"`
+	Price    float64 `parquet:"price"`
+	Quantity int64   `parquet:"quantity"`
+	// AggressorSide and TickDirection classify the trade for flow analysis
+	AggressorSide string    `parquet:"aggressor_side"`
+	TickDirection string    `parquet:"tick_direction"`
+	CreatedAt     time.Time `parquet:"created_at,timestamp(nanosecond)"`
+	// BaseQuantity of BaseAsset changed hands for QuoteNotional of QuoteAsset
+	BaseAsset     string  `parquet:"base_asset"`
+	BaseQuantity  float64 `parquet:"base_quantity"`
+	QuoteAsset    string  `parquet:"quote_asset"`
+	QuoteNotional float64 `parquet:"quote_notional"`
+}
+
+var tradeColumns = []string{"id", "symbol", "maker_id", "taker_id", "venue", "price", "quantity",
+	"aggressor_side", "tick_direction", "created_at", "base_asset", "base_quantity", "quote_asset", "quote_notional"}
+
+func newTradeRow(trade Trade) tradeRow {
+	return tradeRow{
+		ID:        trade.ID,
+		Symbol:    trade.Symbol,
+		MakerID:   trade.MakerID,
+		TakerID:   trade.TakerID,
+		Venue:     trade.Venue,
+		Price:     trade.Price,
+		Quantity:  int64(trade.Quantity),
+		CreatedAt: trade.CreatedAt,
+
+		AggressorSide: string(trade.AggressorSide),
+		TickDirection: string(trade.TickDirection),
+		BaseAsset:     trade.BaseAsset,
+		BaseQuantity:  trade.BaseQuantity,
+		QuoteAsset:    trade.QuoteAsset,
+		QuoteNotional: trade.QuoteNotional,
+	}
+}
+
+func (r tradeRow) record() []string {
+	return []string{r.ID, r.Symbol, r.MakerID, r.TakerID, r.Venue,
+		formatPrice(r.Price), strconv.FormatInt(r.Quantity, 10), r.AggressorSide, r.TickDirection,
+		formatTime(r.CreatedAt), r.BaseAsset, formatPrice(r.BaseQuantity), r.QuoteAsset, formatPrice(r.QuoteNotional)}
+}
+
+// orderRow is one order as written to an export file
+type orderRow struct {
+	ID          string    `parquet:"id"`
+	Symbol      string    `parquet:"symbol"`
+	Owner       string    `parquet:"owner"`
+	Side        string    `parquet:"side"`
+	Type        string    `parquet:"type"`
+	Status      string    `parquet:"status"`
+	Price       float64   `parquet:"price"`
+	Quantity    int64     `parquet:"quantity"`
+	TimeInForce string    `parquet:"time_in_force"`
+	CreatedAt   time.Time `parquet:"created_at,timestamp(nanosecond)"`
+	ExpiresAt   time.Time `parquet:"expires_at,optional,timestamp(nanosecond)"`
+}
+
+var orderColumns = []string{"id", "symbol", "owner", "side", "type", "status", "price", "quantity", "time_in_force", "created_at", "expires_at"}
+
+func newOrderRow(order Order) orderRow {
+	row := orderRow{
+		ID:          order.ID,
+		Symbol:      order.Symbol,
+		Owner:       order.Owner,
+		Side:        string(order.Side),
+		Type:        string(order.Type),
+		Status:      string(order.Status),
+		Price:       order.Price,
+		Quantity:    int64(order.Quantity),
+		TimeInForce: string(order.TimeInForce),
+		CreatedAt:   order.CreatedAt,
+	}
+	if order.ExpiresAt != nil {
+		row.ExpiresAt = *order.ExpiresAt
+	}
+	return row
+}
+
+func (r orderRow) record() []string {
+	return []string{r.ID, r.Symbol, r.Owner, r.Side, r.Type, r.Status,
+		formatPrice(r.Price), strconv.FormatInt(r.Quantity, 10), r.TimeInForce,
+		formatTime(r.CreatedAt), formatTime(r.ExpiresAt)}
+}
+
+// formatPrice writes a price with no more digits than it needs
+func formatPrice(price float64) string {
+	return strconv.FormatFloat(price, 'f', -1, 64)
+}
+
+// formatTime writes a timestamp as RFC 3339 with nanoseconds; the zero time is left blank
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// exportQuery holds the filters shared by the export endpoints
+type exportQuery struct {
+	symbol string
+	format string
+	timeRange
+}
+
+// timeRange filters on a timestamp. from is inclusive and to is exclusive;
+// zero leaves that end open.
+type timeRange struct {
+	from time.Time
+	to   time.Time
+}
+
+// parseExportQuery reads the export filters, returning any validation errors
+func parseExportQuery(r *http.Request) (exportQuery, []string) {
+	values := r.URL.Query()
+	query := exportQuery{symbol: values.Get("symbol"), format: values.Get("format")}
+	var validationErrors []string
+
+	switch query.format {
+	case "":
+		query.format = "csv"
+	case "csv", "parquet":
+	default:
+		validationErrors = append(validationErrors, "format must be 'csv' or 'parquet' (received: '"+query.format+"')")
+	}
+
+	var rangeErrors []string
+	query.timeRange, rangeErrors = parseTimeRange(values)
+	return query, append(validationErrors, rangeErrors...)
+}
+
+// parseTimeRange reads the from and to query parameters as RFC 3339
+// timestamps, returning any validation errors
+func parseTimeRange(values url.Values) (timeRange, []string) {
+	var span timeRange
+	var validationErrors []string
+	for _, bound := range []struct {
+		name string
+		dest *time.Time
+	}{{"from", &span.from}, {"to", &span.to}} {
+		raw := values.Get(bound.name)
+		if raw == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339Nano, raw)
+		if err != nil {
+			validationErrors = append(validationErrors, bound.name+" must be an RFC 3339 timestamp (received: '"+raw+"')")
+			continue
+		}
+		*bound.dest = parsed
+	}
+
+	if !span.from.IsZero() && !span.to.IsZero() && !span.from.Before(span.to) {
+		validationErrors = append(validationErrors, "from must be before to")
+	}
+	return span, validationErrors
+}
+
+// includes reports whether t falls inside the time range
+func (span timeRange) includes(t time.Time) bool {
+	return (span.from.IsZero() || !t.Before(span.from)) && (span.to.IsZero() || t.Before(span.to))
+}
+
+// exportTradesHandler streams the trade history as CSV or Parquet
+func exportTradesHandler(w http.ResponseWriter, r *http.Request) {
+	query, validationErrors := parseExportQuery(r)
+	if len(validationErrors) > 0 {
+		writeError(w, http.StatusBadRequest, ErrCodeValidationFailed, "Validation failed", validationErrors)
+		return
+	}
+
+	var rows []tradeRow
+	for _, trade := range tradeStore.List(query.symbol) {
+		if query.includes(trade.CreatedAt) {
+			rows = append(rows, newTradeRow(trade))
+		}
+	}
+	writeExport(w, query.format, "trades", tradeColumns, rows, tradeRow.record)
+}
+
+// exportOrdersHandler streams the orders the server holds as CSV or Parquet
+func exportOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	query, validationErrors := parseExportQuery(r)
+	if len(validationErrors) > 0 {
+		writeError(w, http.StatusBadRequest, ErrCodeValidationFailed, "Validation failed", validationErrors)
+		return
+	}
+
+	var rows []orderRow
+	for _, order := range collectOrders(query.symbol) {
+		if query.includes(order.CreatedAt) {
+			rows = append(rows, newOrderRow(order))
+		}
+	}
+	writeExport(w, query.format, "orders", orderColumns, rows, orderRow.record)
+}
+
+// writeExport writes rows as an attachment named after the export. CSV is
+// flushed to the client as it goes; Parquet is written once its footer is known.
+func writeExport[T any](w http.ResponseWriter, format, name string, columns []string, rows []T, record func(T) []string) {
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+"."+format+`"`)
+
+	if format == "parquet" {
+		w.Header().Set("Content-Type", "application/vnd.apache.parquet")
+		writer := parquet.NewGenericWriter[T](w)
+		if _, err := writer.Write(rows); err != nil {
+			log.Printf("export: writing %s parquet: %v", name, err)
+			return
+		}
+		if err := writer.Close(); err != nil {
+			log.Printf("export: closing %s parquet: %v", name, err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	flusher, _ := w.(http.Flusher)
+	writer := csv.NewWriter(w)
+	writer.Write(columns)
+	for i, row := range rows {
+		writer.Write(record(row))
+		if (i+1)%exportFlushRows == 0 {
+			writer.Flush()
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		log.Printf("export: writing %s csv: %v", name, err)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// seedExportTrades makes one trade a minute starting at start
+func seedExportTrades(t *testing.T, start time.Time, count int) {
+	t.Helper()
+
+	clock := useDeterministicEngine(t, start)
+	for i := 0; i < count; i++ {
+		processOrder(Order{ID: generateOrderID(), Side: SideSell, Price: 100.0 + float64(i), Quantity: 1, Status: OrderStatusPending, CreatedAt: clock.Now()})
+		processOrder(Order{ID: generateOrderID(), Side: SideBuy, Price: 100.0 + float64(i), Quantity: 1, Status: OrderStatusPending, CreatedAt: clock.Now()})
+		clock.Advance(time.Minute)
+	}
+}
+
+// export runs an export handler and returns the response
+func export(handler http.HandlerFunc, target string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest("GET", target, nil)
+	response := httptest.NewRecorder()
+	handler(response, request)
+	return response
+}
+
+func TestExportTrades_CSVWithTimeRange(t *testing.T) {
+	setupTest()
+	start := time.Date(2024, 1, 1, 9, 30, 0, 0, time.UTC)
+	seedExportTrades(t, start, 4)
+
+	response := export(exportTradesHandler, "/api/v1/trades/export?from=2024-01-01T09:31:00Z&to=2024-01-01T09:33:00Z")
+
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", response.Code)
+	}
+	if got := response.Header().Get("Content-Type"); got != "text/csv" {
+		t.Errorf("Expected text/csv, got %s", got)
+	}
+	if got := response.Header().Get("Content-Disposition"); got != `attachment; filename="trades.csv"` {
+		t.Errorf("Unexpected Content-Disposition %s", got)
+	}
+
+	records, err := csv.NewReader(response.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("Expected valid CSV, got %v", err)
+	}
+
+	if len(records) != 3 {
+		t.Fatalf("Expected a header and 2 trades, got %d records", len(records))
+	}
+	if records[0][0] != "id" || records[0][9] != "created_at" || records[0][len(records[0])-1] != "quote_notional" {
+		t.Errorf("Unexpected header %v", records[0])
+	}
+	if records[1][5] != "101" || records[1][9] != "2024-01-01T09:31:00Z" || records[2][5] != "102" || records[1][13] != "101" {
+		t.Errorf("Unexpected rows %v", records[1:])
+	}
+}
+
+func TestExportTrades_Parquet(t *testing.T) {
+	setupTest()
+	seedExportTrades(t, time.Date(2024, 1, 1, 9, 30, 0, 0, time.UTC), 3)
+
+	response := export(exportTradesHandler, "/api/v1/trades/export?format=parquet")
+
+	if got := response.Header().Get("Content-Type"); got != "application/vnd.apache.parquet" {
+		t.Errorf("Expected the parquet content type, got %s", got)
+	}
+
+	body := response.Body.Bytes()
+	rows, err := parquet.Read[tradeRow](bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("Expected a readable parquet file, got %v", err)
+	}
+
+	if len(rows) != 3 {
+		t.Fatalf("Expected 3 trades, got %d", len(rows))
+	}
+	if rows[2].Price != 102.0 || rows[2].Quantity != 1 || !rows[2].CreatedAt.Equal(time.Date(2024, 1, 1, 9, 32, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected last row %+v", rows[2])
+	}
+}
+
+func TestExportOrders_CSV(t *testing.T) {
+	setupTest()
+	start := time.Date(2024, 1, 1, 9, 30, 0, 0, time.UTC)
+	useDeterministicEngine(t, start)
+
+	expiresAt := start.Add(time.Hour)
+	processOrder(Order{ID: "buy-1", Side: SideBuy, Price: 99.5, Quantity: 10, Owner: "alice", Status: OrderStatusPending, CreatedAt: start, ExpiresAt: &expiresAt})
+	processOrder(Order{ID: "sell-1", Side: SideSell, Price: 101.0, Quantity: 5, Status: OrderStatusPending, CreatedAt: start})
+
+	response := export(exportOrdersHandler, "/api/v1/orders/export")
+
+	records, err := csv.NewReader(response.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("Expected valid CSV, got %v", err)
+	}
+
+	if len(records) != 3 {
+		t.Fatalf("Expected a header and 2 orders, got %d records", len(records))
+	}
+	buy := records[1]
+	if buy[0] != "buy-1" || buy[2] != "alice" || buy[6] != "99.5" || buy[10] != "2024-01-01T10:30:00Z" {
+		t.Errorf("Unexpected buy row %v", buy)
+	}
+	if records[2][10] != "" {
+		t.Errorf("Expected no expiry for sell-1, got %q", records[2][10])
+	}
+}
+
+func TestExport_Validation(t *testing.T) {
+	setupTest()
+
+	targets := []string{
+		"/api/v1/trades/export?format=xlsx",
+		"/api/v1/trades/export?from=yesterday",
+		"/api/v1/trades/export?from=2024-01-02T00:00:00Z&to=2024-01-01T00:00:00Z",
+	}
+	for _, target := range targets {
+		if response := export(exportTradesHandler, target); response.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", target, response.Code)
+		}
+	}
+
+	request := httptest.NewRequest("POST", "/api/v1/orders/export", nil)
+	response := serve(HTTPConfig{}, request)
+	if response.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405, got %d", response.Code)
+	}
+}
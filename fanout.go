@@ -0,0 +1,193 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultSlowConsumerTimeout is how long a stream client may stay behind
+// before it is disconnected by default
+const defaultSlowConsumerTimeout = 10 * time.Second
+
+// StreamConfig controls what the streams do with clients that fall behind
+type StreamConfig struct {
+	// Conflate folds the depth updates a client has no room for into one
+	// update, rather than telling it to resync
+	Conflate bool
+	// SlowTimeout disconnects a client that has been behind this long; zero
+	// keeps it connected however far behind it is
+	SlowTimeout time.Duration
+}
+
+// streamConfig is what the streams do with clients that fall behind
+var streamConfig = StreamConfig{Conflate: true, SlowTimeout: defaultSlowConsumerTimeout}
+
+// backlog is what a stream client builds up once its queue fills: the depth
+// changes it had no room for, folded into one update, and how long it has
+// been behind. A nil backlog leaves the client to resync when its queue
+// fills, and never disconnects it.
+type backlog struct {
+	session  *session
+	conflate bool
+	// throttled holds every depth update for the writer to send on its own
+	// interval, rather than only those that found the queue full
+	throttled bool
+
+	mu   sync.Mutex
+	held *DepthUpdate
+	// ready is signalled whenever held is set
+	ready chan struct{}
+	// behind is when the client fell behind, in engine-clock nanoseconds, or
+	// zero once it has caught up
+	behind atomic.Int64
+}
+
+func newBacklog(s *session, conflate bool) *backlog {
+	return &backlog{session: s, conflate: conflate, ready: make(chan struct{}, 1)}
+}
+
+// conflating reports whether b folds depth updates
+func (b *backlog) conflating() bool {
+	return b != nil && b.conflate
+}
+
+// queueDepth sends msg, which carries update, to ch. Once ch is full, or
+// while depth is already held back, update is folded into the held update
+// instead. A throttled backlog folds every update. b must conflate.
+func queueDepth[T any](b *backlog, ch chan T, msg T, update DepthUpdate) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.held == nil && !b.throttled {
+		select {
+		case ch <- msg:
+			return
+		default:
+		}
+	}
+
+	if b.held == nil {
+		held := update
+		held.FirstSequence = held.Sequence
+		b.held = &held
+	} else {
+		b.held = &DepthUpdate{
+			Type:          DepthMessageUpdate,
+			Symbol:        update.Symbol,
+			FirstSequence: b.held.FirstSequence,
+			Sequence:      update.Sequence,
+			Bids:          mergeLevels(b.held.Bids, update.Bids, SideBuy),
+			Asks:          mergeLevels(b.held.Asks, update.Asks, SideSell),
+		}
+	}
+	if b.session != nil {
+		b.session.conflated.Add(1)
+	}
+	if b.throttled {
+		return
+	}
+	select {
+	case b.ready <- struct{}{}:
+	default:
+	}
+	b.fellBehind()
+}
+
+// mergeLevels returns the levels of held updated by changed, best first.
+// Neither slice is changed, as every subscriber shares the updates.
+func mergeLevels(held, changed []PriceLevel, side Side) []PriceLevel {
+	if len(changed) == 0 {
+		return held
+	}
+	merged := make([]PriceLevel, 0, len(held)+len(changed))
+	merged = append(merged, changed...)
+	for _, level := range held {
+		replaced := false
+		for _, c := range changed {
+			if c.Price == level.Price {
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged = append(merged, level)
+		}
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		if side == SideBuy {
+			return merged[i].Price > merged[j].Price
+		}
+		return merged[i].Price < merged[j].Price
+	})
+	return merged
+}
+
+// take returns the held update, if there is one, and clears it
+func (b *backlog) take() (DepthUpdate, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.held == nil {
+		return DepthUpdate{}, false
+	}
+	update := *b.held
+	b.held = nil
+	return update, true
+}
+
+// discard drops the held update, as when the client is told to resync
+func (b *backlog) discard() {
+	if !b.conflating() {
+		return
+	}
+	b.mu.Lock()
+	b.held = nil
+	b.mu.Unlock()
+}
+
+// fellBehind notes that the client's queue was full, disconnecting the
+// client once it has been behind for streamConfig.SlowTimeout
+func (b *backlog) fellBehind() {
+	if b == nil {
+		return
+	}
+	now := engineClock.Now().UnixNano()
+	since := b.behind.Load()
+	if since == 0 {
+		b.behind.CompareAndSwap(0, now)
+		return
+	}
+	if timeout := streamConfig.SlowTimeout; timeout > 0 && now-since >= int64(timeout) && b.session != nil {
+		b.session.drop()
+	}
+}
+
+// caughtUp notes that the client has written everything it had queued
+func (b *backlog) caughtUp() {
+	if b == nil || b.behind.Load() == 0 {
+		return
+	}
+	if b.conflate && !b.throttled {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.held != nil {
+			return
+		}
+	}
+	b.behind.Store(0)
+}
+
+// flushBacklog writes the messages queued on ch ahead of the held update,
+// then the held update itself, wrapped by wrap. It runs on the stream's
+// writer when b signals ready.
+func flushBacklog[T any](b *backlog, ch chan T, write func(T) error, wrap func(DepthUpdate) T) error {
+	for n := len(ch); n > 0; n-- {
+		if err := write(<-ch); err != nil {
+			return err
+		}
+	}
+	if update, ok := b.take(); ok {
+		return write(wrap(update))
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// subscribeConflated attaches a conflating depth subscriber with a session
+// and the given queue size to the default matcher
+func subscribeConflated(t *testing.T, size int) (*matcher, *depthSubscriber) {
+	t.Helper()
+	m, subscriber := subscribeDepth(t, size)
+	subscriber.session = openSession(SessionDepth, httptest.NewRequest("GET", "/", nil), []string{"depth:DEFAULT"}, size,
+		func() int { return len(subscriber.updates) })
+	t.Cleanup(subscriber.session.close)
+	subscriber.backlog = newBacklog(subscriber.session, true)
+	return m, subscriber
+}
+
+// flushed returns what the subscriber's writer would send next: its queue,
+// then the update its backlog holds
+func flushed(t *testing.T, subscriber *depthSubscriber) []DepthUpdate {
+	t.Helper()
+	var written []DepthUpdate
+	write := func(update DepthUpdate) error {
+		written = append(written, update)
+		return nil
+	}
+	if err := flushBacklog(subscriber.backlog, subscriber.updates, write, func(update DepthUpdate) DepthUpdate { return update }); err != nil {
+		t.Fatal(err)
+	}
+	return written
+}
+
+func TestBacklog_ConflatesDepthForSlowClients(t *testing.T) {
+	setupTest()
+	m, subscriber := subscribeConflated(t, 1)
+
+	for i, price := range []float64{101.0, 102.0, 103.0} {
+		placeOn(m, Order{ID: fmt.Sprintf("sell-%d", i+1), Side: SideSell, Price: price, Quantity: 1})
+	}
+	placeOn(m, Order{ID: "sell-4", Side: SideSell, Price: 102.0, Quantity: 4})
+	placeOn(m, Order{ID: "buy-1", Side: SideBuy, Price: 99.0, Quantity: 2})
+
+	select {
+	case <-subscriber.backlog.ready:
+	default:
+		t.Fatal("Expected the writer told a conflated update is waiting")
+	}
+	written := flushed(t, subscriber)
+	if len(written) != 2 || written[0].Sequence != 1 || written[0].FirstSequence != 0 {
+		t.Fatalf("Expected the queued update then the conflated one, got %+v", written)
+	}
+	conflated := written[1]
+	if conflated.Type != DepthMessageUpdate || conflated.FirstSequence != 2 || conflated.Sequence != 5 {
+		t.Errorf("Expected sequences 2 to 5 conflated, got %+v", conflated)
+	}
+	asks := []PriceLevel{{Price: 102, Quantity: 5, Orders: 2}, {Price: 103, Quantity: 1, Orders: 1}}
+	if !reflect.DeepEqual(conflated.Asks, asks) || len(conflated.Bids) != 1 || conflated.Bids[0].Price != 99 {
+		t.Errorf("Expected each level's latest state, got %+v and %+v", conflated.Bids, conflated.Asks)
+	}
+	if session := subscriber.session.snapshot(); session.Conflated != 4 || session.Resyncs != 0 {
+		t.Errorf("Expected four updates conflated and no resync, got %+v", session)
+	}
+
+	// Once it has caught up the client gets every update again
+	subscriber.backlog.caughtUp()
+	placeOn(m, Order{ID: "sell-5", Side: SideSell, Price: 104.0, Quantity: 1})
+	if update := nextUpdate(t, subscriber); update.Sequence != 6 || update.FirstSequence != 0 {
+		t.Errorf("Expected sequence 6 on its own, got %+v", update)
+	}
+	if subscriber.backlog.behind.Load() != 0 {
+		t.Error("Expected the client no longer behind")
+	}
+}
+
+func TestBacklog_ResyncDropsConflatedDepth(t *testing.T) {
+	setupTest()
+	m, subscriber := subscribeConflated(t, 1)
+	for i, price := range []float64{101.0, 102.0} {
+		placeOn(m, Order{ID: fmt.Sprintf("sell-%d", i+1), Side: SideSell, Price: price, Quantity: 1})
+	}
+
+	m.do(func() {
+		subscriber.send(DepthUpdate{Type: DepthMessageResync, Symbol: m.symbol, Sequence: m.book.sequence})
+	})
+	if written := flushed(t, subscriber); len(written) != 1 || written[0].Type != DepthMessageResync {
+		t.Errorf("Expected only the resync, got %+v", written)
+	}
+}
+
+func TestBacklog_DisconnectsClientsThatStayBehind(t *testing.T) {
+	setupTest()
+	clock := useDeterministicEngine(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	streamConfig.SlowTimeout = time.Minute
+	m, subscriber := subscribeConflated(t, 1)
+	place := func(price float64) {
+		m.do(func() {
+			processOrder(Order{Side: SideSell, Price: price, Quantity: 1, Status: OrderStatusPending, CreatedAt: clock.Now()})
+		})
+	}
+
+	place(101)
+	place(102)
+	clock.Advance(59 * time.Second)
+	place(103)
+	select {
+	case <-subscriber.session.kicked:
+		t.Fatal("Expected the client kept before the timeout")
+	default:
+	}
+
+	clock.Advance(time.Second)
+	place(104)
+	select {
+	case <-subscriber.session.kicked:
+	default:
+		t.Fatal("Expected a client behind for a minute disconnected")
+	}
+	if !subscriber.session.slow.Load() {
+		t.Error("Expected the session marked as too slow")
+	}
+}
+
+func TestBacklog_SlowClientsAreToldWhyTheyWereDropped(t *testing.T) {
+	setupTest()
+	server := httptest.NewServer(newServer(HTTPConfig{}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/api/v1/feed/public", nil)
+	if err != nil {
+		t.Fatalf("Expected to connect, got %v", err)
+	}
+	defer conn.Close()
+	list := waitForSessions(t, 1)
+
+	sessionsMu.Lock()
+	s := sessions[list[0].ID]
+	sessionsMu.Unlock()
+	s.drop()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseTryAgainLater) {
+		t.Errorf("Expected a try-again-later close, got %v", err)
+	}
+}
+
+// waitForSessions waits until count streaming sessions are connected
+func waitForSessions(t *testing.T, count int) []StreamSession {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if list := sessionSnapshots(); len(list) == count {
+			return list
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d sessions", count)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestMergeLevels_LeavesSharedUpdatesAlone(t *testing.T) {
+	held := []PriceLevel{{Price: 101, Quantity: 1}, {Price: 103, Quantity: 3}}
+	changed := []PriceLevel{{Price: 102, Quantity: 2}, {Price: 103}}
+	merged := mergeLevels(held, changed, SideSell)
+	if want := []PriceLevel{{Price: 101, Quantity: 1}, {Price: 102, Quantity: 2}, {Price: 103}}; !reflect.DeepEqual(merged, want) {
+		t.Errorf("Expected %+v, got %+v", want, merged)
+	}
+	if held[1].Quantity != 3 || changed[0].Price != 102 {
+		t.Errorf("Expected the inputs unchanged, got %+v and %+v", held, changed)
+	}
+}
+
+func TestLoadConfig_Streams(t *testing.T) {
+	cfg, err := loadConfig([]string{"-stream-conflate=false", "-slow-consumer-timeout", "30s"})
+	if err != nil || cfg.Streams != (StreamConfig{Conflate: false, SlowTimeout: 30 * time.Second}) {
+		t.Fatalf("Unexpected stream config %+v (%v)", cfg.Streams, err)
+	}
+	if _, err := loadConfig([]string{"-slow-consumer-timeout", "-1s"}); err == nil {
+		t.Error("Expected an error for a negative timeout")
+	}
+}
+
+// BenchmarkFanOut_Depth publishes depth changes to many stream clients, each
+// drained by its own writer the way a connection's goroutine drains it
+func BenchmarkFanOut_Depth(b *testing.B) {
+	for _, clients := range []int{100, 1000, 5000} {
+		b.Run(fmt.Sprintf("clients=%d", clients), func(b *testing.B) {
+			benchBook(b, 100)
+			m, _ := matcherFor("")
+			m.book.dirtyBids, m.book.dirtyAsks = nil, nil
+			stop := make(chan struct{})
+			var writers sync.WaitGroup
+			for i := 0; i < clients; i++ {
+				subscriber := &depthSubscriber{updates: make(chan DepthUpdate, depthBufferSize)}
+				subscriber.backlog = newBacklog(nil, true)
+				m.subscribers[subscriber] = struct{}{}
+				writers.Add(1)
+				go func() {
+					defer writers.Done()
+					discard := func(DepthUpdate) error { return nil }
+					for {
+						select {
+						case <-subscriber.updates:
+						case <-subscriber.backlog.ready:
+							flushBacklog(subscriber.backlog, subscriber.updates, discard, func(update DepthUpdate) DepthUpdate { return update })
+						case <-stop:
+							return
+						}
+					}
+				}()
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				adjustLevel(m.book, SideBuy, benchLevel(SideBuy, i%100), 1-2*(i/100%2), 0)
+				m.publishDepth()
+			}
+			b.StopTimer()
+			close(stop)
+			writers.Wait()
+			clear(m.subscribers)
+		})
+	}
+}
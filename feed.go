@@ -0,0 +1,517 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FeedChannel is which feed a message is on
+type FeedChannel string
+
+const (
+	// FeedPublic carries trades and aggregated depth with no order IDs or owners
+	FeedPublic FeedChannel = "public"
+	// FeedPrivate carries one owner's order updates and fills
+	FeedPrivate FeedChannel = "private"
+	// FeedDropCopy carries every owner's order updates and fills
+	FeedDropCopy FeedChannel = "drop_copy"
+)
+
+// FeedMessageType tells a feed client what a message carries
+type FeedMessageType string
+
+const (
+	FeedMessageTrade FeedMessageType = "trade"
+	FeedMessageDepth FeedMessageType = "depth"
+	FeedMessageOrder FeedMessageType = "order"
+	FeedMessageFill  FeedMessageType = "fill"
+	// FeedMessageStatus announces a symbol's move to a new trading phase
+	FeedMessageStatus FeedMessageType = "status"
+	// FeedMessageAuction gives where a call auction would uncross now
+	FeedMessageAuction FeedMessageType = "auction"
+	// FeedMessageResync tells the client it missed messages and must refetch
+	// the depth snapshot, or its orders, before carrying on
+	FeedMessageResync FeedMessageType = "resync"
+)
+
+// feedBufferSize is how many messages a feed client may fall behind by before
+// it is told to resync
+const feedBufferSize = 256
+
+// PublicTrade is a trade as anyone may see it: no order IDs
+type PublicTrade struct {
+	ID            string        `json:"id"`
+	Symbol        string        `json:"symbol"`
+	Price         float64       `json:"price"`
+	Quantity      int           `json:"quantity"`
+	AggressorSide Side          `json:"aggressor_side"`
+	TickDirection TickDirection `json:"tick_direction,omitempty"`
+	CreatedAt     time.Time     `json:"created_at"`
+}
+
+// Liquidity says whether a fill added liquidity to the book or took it
+type Liquidity string
+
+const (
+	LiquidityMaker Liquidity = "maker"
+	LiquidityTaker Liquidity = "taker"
+)
+
+// Fill is one side of a trade, as the owner of that order sees it
+type Fill struct {
+	TradeID  string  `json:"trade_id"`
+	OrderID  string  `json:"order_id"`
+	Owner    string  `json:"owner,omitempty"`
+	Symbol   string  `json:"symbol"`
+	Side     Side    `json:"side"`
+	Price    float64 `json:"price"`
+	Quantity int     `json:"quantity"`
+	// BaseQuantity is the fill's lots in units of the symbol's base asset,
+	// and QuoteNotional what they changed hands for in its quote
+	BaseQuantity  float64   `json:"base_quantity,omitempty" since:"3"`
+	QuoteNotional float64   `json:"quote_notional,omitempty" since:"3"`
+	Liquidity     Liquidity `json:"liquidity"`
+	// Fee is what the owner paid on the fill, in FeeAsset; negative for a rebate
+	Fee      float64 `json:"fee,omitempty"`
+	FeeAsset string  `json:"fee_asset,omitempty"`
+	// BasketID is the basket the order was placed in, if any
+	BasketID  string    `json:"basket_id,omitempty" since:"7"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// FeedMessage is one message on the public or private feed
+type FeedMessage struct {
+	Channel FeedChannel     `json:"channel"`
+	Type    FeedMessageType `json:"type"`
+	Symbol  string          `json:"symbol,omitempty"`
+	Trade   *PublicTrade    `json:"trade,omitempty"`
+	Depth   *DepthUpdate    `json:"depth,omitempty"`
+	// Order is the owner's order after a status change, with the reason
+	Order  *Order `json:"order,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	Fill   *Fill  `json:"fill,omitempty"`
+	// Status is the symbol's new trading phase
+	Status *MarketStatus `json:"status,omitempty"`
+	// Auction is the indicative uncross of the symbol's call auction
+	Auction *IndicativeAuction `json:"auction,omitempty" since:"9"`
+}
+
+// feedSubscriber is one feed client's queue, for a symbol's public feed, an
+// owner's private one or the drop copy
+type feedSubscriber struct {
+	messages chan FeedMessage
+	channel  FeedChannel
+	symbol   string
+	owner    string
+	session  *session
+	backlog  *backlog
+}
+
+// send queues a message without blocking the matcher. A subscriber whose
+// queue is full has a depth message conflated, when its backlog conflates,
+// and otherwise loses what it has queued and gets a resync message instead.
+func (s *feedSubscriber) send(msg FeedMessage) {
+	if msg.Type == FeedMessageDepth && s.backlog.conflating() {
+		queueDepth(s.backlog, s.messages, msg, *msg.Depth)
+		return
+	}
+	select {
+	case s.messages <- msg:
+		return
+	default:
+	}
+
+	s.backlog.discard()
+	s.backlog.fellBehind()
+	if s.session != nil {
+		s.session.resyncs.Add(1)
+	}
+	for len(s.messages) > 0 {
+		select {
+		case <-s.messages:
+		default:
+		}
+	}
+	s.messages <- FeedMessage{Channel: s.channel, Type: FeedMessageResync, Symbol: s.symbol}
+}
+
+// feedHub fans trades, depth and order changes out to the feed subscribers.
+// Publishing costs one atomic load while none are connected.
+type feedHub struct {
+	mu       sync.Mutex
+	active   atomic.Bool
+	public   map[string]map[*feedSubscriber]struct{}
+	private  map[string]map[*feedSubscriber]struct{}
+	dropCopy map[string]map[*feedSubscriber]struct{}
+}
+
+var feed = &feedHub{
+	public:   make(map[string]map[*feedSubscriber]struct{}),
+	private:  make(map[string]map[*feedSubscriber]struct{}),
+	dropCopy: make(map[string]map[*feedSubscriber]struct{}),
+}
+
+// index returns the index a subscriber is kept in, keyed by its symbol or
+// owner. Drop copy subscribers follow everything, so they share one key.
+func (h *feedHub) index(s *feedSubscriber) (map[string]map[*feedSubscriber]struct{}, string) {
+	switch s.channel {
+	case FeedPublic:
+		return h.public, s.symbol
+	case FeedDropCopy:
+		return h.dropCopy, ""
+	}
+	return h.private, s.owner
+}
+
+// subscribe adds a feed client
+func (h *feedHub) subscribe(s *feedSubscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	index, key := h.index(s)
+	if index[key] == nil {
+		index[key] = make(map[*feedSubscriber]struct{})
+	}
+	index[key][s] = struct{}{}
+	h.active.Store(true)
+}
+
+// unsubscribe removes a feed client
+func (h *feedHub) unsubscribe(s *feedSubscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	index, key := h.index(s)
+	delete(index[key], s)
+	if len(index[key]) == 0 {
+		delete(index, key)
+	}
+	h.active.Store(len(h.public) > 0 || len(h.private) > 0 || len(h.dropCopy) > 0)
+}
+
+// watching reports whether anyone follows a symbol's public feed
+func (h *feedHub) watching(symbol string) bool {
+	if !h.active.Load() {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.public[symbol]) > 0
+}
+
+// sendLocked queues a message for every subscriber under one key. The caller
+// must hold h.mu.
+func (h *feedHub) sendLocked(subscribers map[*feedSubscriber]struct{}, msg FeedMessage) {
+	for s := range subscribers {
+		s.send(msg)
+	}
+}
+
+// trade publishes a local trade anonymously, and as a fill to each owner, the
+// drop copy and the fill webhook
+func (h *feedHub) trade(trade Trade, maker, taker Order) {
+	if fillWebhook != nil {
+		fillWebhook.publish(newFill(trade, maker, LiquidityMaker))
+		fillWebhook.publish(newFill(trade, taker, LiquidityTaker))
+	}
+	if !h.active.Load() {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.sendPublicTradeLocked(trade)
+	h.sendFillLocked(trade, maker, LiquidityMaker)
+	h.sendFillLocked(trade, taker, LiquidityTaker)
+}
+
+// fill sends one order's side of a trade to the drop copy, its owner and the
+// fill webhook, without publishing the trade, as paper fills are kept off the public feed
+func (h *feedHub) fill(trade Trade, order Order, liquidity Liquidity) {
+	if fillWebhook != nil {
+		fillWebhook.publish(newFill(trade, order, liquidity))
+	}
+	if !h.active.Load() {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sendFillLocked(trade, order, liquidity)
+}
+
+// newFill is an order's side of a trade
+func newFill(trade Trade, order Order, liquidity Liquidity) *Fill {
+	fill := &Fill{
+		TradeID:   trade.ID,
+		OrderID:   order.ID,
+		Owner:     order.Owner,
+		Symbol:    trade.Symbol,
+		Side:      order.Side,
+		Price:     trade.Price,
+		Quantity:  trade.Quantity,
+		Liquidity: liquidity,
+		BasketID:  order.BasketID,
+		CreatedAt: trade.CreatedAt,
+
+		BaseQuantity:  trade.BaseQuantity,
+		QuoteNotional: trade.QuoteNotional,
+	}
+	if trade.Fees != nil {
+		fill.Fee, fill.FeeAsset = trade.Fees.TakerFee, trade.Fees.Asset
+		if liquidity == LiquidityMaker {
+			fill.Fee = trade.Fees.MakerFee
+		}
+	}
+	return fill
+}
+
+// sendFillLocked sends an order's fill to the drop copy, and privately to its
+// owner if it has one. h.mu must be held.
+func (h *feedHub) sendFillLocked(trade Trade, order Order, liquidity Liquidity) {
+	fill := newFill(trade, order, liquidity)
+	h.sendLocked(h.dropCopy[""], FeedMessage{Channel: FeedDropCopy, Type: FeedMessageFill, Symbol: trade.Symbol, Fill: fill})
+	if order.Owner != "" {
+		h.sendLocked(h.private[order.Owner], FeedMessage{Channel: FeedPrivate, Type: FeedMessageFill, Symbol: trade.Symbol, Fill: fill})
+	}
+}
+
+// publicTrade publishes a trade with no local orders behind it, such as one
+// mirrored from an exchange, on its symbol's public feed
+func (h *feedHub) publicTrade(trade Trade) {
+	if !h.active.Load() {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sendPublicTradeLocked(trade)
+}
+
+// sendPublicTradeLocked sends a trade to its symbol's public subscribers
+func (h *feedHub) sendPublicTradeLocked(trade Trade) {
+	h.sendLocked(h.public[trade.Symbol], FeedMessage{Channel: FeedPublic, Type: FeedMessageTrade, Symbol: trade.Symbol, Trade: &PublicTrade{
+		ID:            trade.ID,
+		Symbol:        trade.Symbol,
+		Price:         trade.Price,
+		Quantity:      trade.Quantity,
+		AggressorSide: trade.AggressorSide,
+		TickDirection: trade.TickDirection,
+		CreatedAt:     trade.CreatedAt,
+	}})
+}
+
+// depthMessage wraps a conflated update for the public feed
+func depthMessage(update DepthUpdate) FeedMessage {
+	return FeedMessage{Channel: FeedPublic, Type: FeedMessageDepth, Symbol: update.Symbol, Depth: &update}
+}
+
+// depth publishes a symbol's changed levels on its public feed
+func (h *feedHub) depth(update DepthUpdate) {
+	if !h.active.Load() {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sendLocked(h.public[update.Symbol], depthMessage(update))
+}
+
+// status publishes a symbol's new trading phase on its public feed
+func (h *feedHub) status(status MarketStatus) {
+	if !h.active.Load() {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sendLocked(h.public[status.Symbol], FeedMessage{Channel: FeedPublic, Type: FeedMessageStatus, Symbol: status.Symbol, Status: &status})
+}
+
+// auction publishes a call auction's indicative uncross on its symbol's
+// public feed
+func (h *feedHub) auction(indicative IndicativeAuction) {
+	if !h.active.Load() {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sendLocked(h.public[indicative.Symbol], FeedMessage{Channel: FeedPublic, Type: FeedMessageAuction, Symbol: indicative.Symbol, Auction: &indicative})
+}
+
+// order publishes an order's change on its owner's private feed and the drop copy
+func (h *feedHub) order(order Order, reason string) {
+	if !h.active.Load() {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sendLocked(h.dropCopy[""], FeedMessage{Channel: FeedDropCopy, Type: FeedMessageOrder, Symbol: order.Symbol, Order: &order, Reason: reason})
+	if order.Owner != "" {
+		h.sendLocked(h.private[order.Owner], FeedMessage{Channel: FeedPrivate, Type: FeedMessageOrder, Symbol: order.Symbol, Order: &order, Reason: reason})
+	}
+}
+
+// ownerKey is the request context key for the owner an API key acts for
+type ownerKey struct{}
+
+// ownerFrom returns the owner the request's API key maps to, if any
+func ownerFrom(ctx context.Context) (string, bool) {
+	owner, ok := ctx.Value(ownerKey{}).(string)
+	return owner, ok
+}
+
+// identifyOwner records which owner the request's API key or token acts for.
+// With owners, keys that map to no owner act for nobody; without, only
+// tokens act for an owner.
+func identifyOwner(owners map[string]string) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			owner, identified := "", len(owners) > 0
+			if key := requestAPIKey(r); key != "" {
+				owner = owners[key]
+			}
+			if identity, ok := tokenFrom(r.Context()); ok {
+				owner, identified = identity.account, true
+			}
+			if !identified {
+				next.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ownerKey{}, owner)))
+		})
+	}
+}
+
+// publicFeedHandler streams a symbol's trades and depth changes, with no order
+// IDs or owners, over a WebSocket
+func publicFeedHandler(w http.ResponseWriter, r *http.Request) {
+	m, ok := matcherFor(r.URL.Query().Get("symbol"))
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrCodeUnknownSymbol, "Unknown symbol",
+			"symbol '"+r.URL.Query().Get("symbol")+"' is not traded here")
+		return
+	}
+	serveFeed(w, r, &feedSubscriber{channel: FeedPublic, symbol: m.symbol}, SessionPublicFeed, "public:"+m.symbol)
+}
+
+// privateFeedHandler streams the caller's own order updates and fills over a
+// WebSocket. The owner is a token's account or, with -key-owners, the API
+// key's; otherwise it is named by the owner query parameter.
+func privateFeedHandler(w http.ResponseWriter, r *http.Request) {
+	owner, identified := ownerFrom(r.Context())
+	if !identified {
+		owner = r.URL.Query().Get("owner")
+	}
+	if identified && owner == "" {
+		writeError(w, http.StatusForbidden, ErrCodeNotEntitled, "No owner for API key",
+			"the private feed needs an API key that -key-owners maps to an owner")
+		return
+	}
+	if owner == "" {
+		writeError(w, http.StatusBadRequest, ErrCodeValidationFailed, "Validation failed",
+			[]string{"owner is required"})
+		return
+	}
+	serveFeed(w, r, &feedSubscriber{channel: FeedPrivate, owner: owner}, SessionPrivateFeed, "private:"+owner)
+}
+
+// dropCopyFeedHandler streams every owner's order updates and fills over a
+// WebSocket, for a compliance consumer whose key is in -drop-copy-keys
+func dropCopyFeedHandler(w http.ResponseWriter, r *http.Request) {
+	serveFeed(w, r, &feedSubscriber{channel: FeedDropCopy}, SessionDropCopy, "drop_copy")
+}
+
+// requireDropCopyKey refuses callers whose API key may not follow the drop
+// copy, which also keeps them from the other compliance endpoints. Tokens
+// granting the surveillance role may.
+func requireDropCopyKey(keys []string) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			identity, _ := tokenFrom(r.Context())
+			if key := requestAPIKey(r); !slices.Contains(identity.roles, RoleSurveillance) && (key == "" || !validAPIKey(keys, key)) {
+				writeError(w, http.StatusForbidden, ErrCodeNotEntitled, "Not entitled",
+					"this endpoint needs an API key listed in -drop-copy-keys")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// serveFeed upgrades the connection and writes the subscriber's messages
+// until the client goes away or is kicked
+func serveFeed(w http.ResponseWriter, r *http.Request, subscriber *feedSubscriber, kind SessionKind, subscription string) {
+	conn, err := depthUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already written an error response
+		return
+	}
+	defer conn.Close()
+	version := schemaVersionFrom(r.Context())
+
+	subscriber.messages = make(chan FeedMessage, feedBufferSize)
+	subscriber.session = openSession(kind, r, []string{subscription}, feedBufferSize,
+		func() int { return len(subscriber.messages) })
+	subscriber.backlog = newBacklog(subscriber.session, streamConfig.Conflate)
+	feed.subscribe(subscriber)
+	defer feed.unsubscribe(subscriber)
+	// Deferred last so the session leaves the list before its subscription does
+	defer subscriber.session.close()
+
+	// The feed is one-way; reading only notices when the client goes away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	write := func(msg FeedMessage) error {
+		// Clients written before indicative auctions were published never
+		// see them
+		if msg.Type == FeedMessageAuction && version < 9 {
+			return nil
+		}
+		if err := writeJSONMessage(conn, msg, version); err != nil {
+			return err
+		}
+		subscriber.session.sent.Add(1)
+		return nil
+	}
+	for {
+		select {
+		case msg := <-subscriber.messages:
+			if write(msg) != nil {
+				return
+			}
+		case <-subscriber.backlog.ready:
+			if flushBacklog(subscriber.backlog, subscriber.messages, write, depthMessage) != nil {
+				return
+			}
+		case <-subscriber.session.kicked:
+			subscriber.session.writeKicked(conn)
+			return
+		case <-closed:
+			return
+		}
+		if len(subscriber.messages) == 0 {
+			subscriber.backlog.caughtUp()
+		}
+	}
+}
+
+// anonymizeTrades drops the order IDs, in place, from trades for callers that
+// are not entitled to see individual orders
+func anonymizeTrades(ctx context.Context, trades []Trade) []Trade {
+	if entitlementFrom(ctx).allows(EntitlementL3) {
+		return trades
+	}
+	for i := range trades {
+		trades[i].MakerID, trades[i].TakerID = "", ""
+	}
+	return trades
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// dialFeed connects to a feed path on server with an optional API key
+func dialFeed(t *testing.T, server *httptest.Server, path, key string) *websocket.Conn {
+	t.Helper()
+	header := http.Header{}
+	if key != "" {
+		header.Set("X-API-Key", key)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+path, header)
+	if err != nil {
+		t.Fatalf("Expected to connect to %s, got %v", path, err)
+	}
+	return conn
+}
+
+// readFeed reads feed messages until one of the wanted type arrives
+func readFeed(t *testing.T, conn *websocket.Conn, want FeedMessageType) (FeedMessage, string) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		_, raw, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("Expected a %s message, got %v", want, err)
+		}
+		var msg FeedMessage
+		json.Unmarshal(raw, &msg)
+		if msg.Type == want {
+			return msg, string(raw)
+		}
+	}
+}
+
+// waitForFeeds waits until count feed sessions are connected
+func waitForFeeds(t *testing.T, count int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for len(sessionSnapshots()) != count {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d feed sessions, got %d", count, len(sessionSnapshots()))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFeeds_PublicIsAnonymousAndPrivateIsOwn(t *testing.T) {
+	setupTest()
+	server := httptest.NewServer(newServer(HTTPConfig{KeyOwners: map[string]string{"maker-key": "maker"}}))
+	defer server.Close()
+
+	public := dialFeed(t, server, "/api/v1/feed/public", "")
+	defer public.Close()
+	private := dialFeed(t, server, "/api/v1/feed/private", "maker-key")
+	defer private.Close()
+	waitForFeeds(t, 2)
+
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "ask-1", Symbol: "DEFAULT", Side: SideSell, Price: 100.0, Quantity: 3, Owner: "maker"})
+	placeOn(m, Order{ID: "buy-1", Symbol: "DEFAULT", Side: SideBuy, Price: 100.0, Quantity: 2, Owner: "taker"})
+
+	depth, raw := readFeed(t, public, FeedMessageDepth)
+	if depth.Channel != FeedPublic || len(depth.Depth.Asks) != 1 || strings.Contains(raw, "ask-1") {
+		t.Errorf("Expected an anonymous depth update, got %s", raw)
+	}
+	trade, raw := readFeed(t, public, FeedMessageTrade)
+	if trade.Trade.Quantity != 2 || trade.Trade.AggressorSide != SideBuy || strings.Contains(raw, "ask-1") || strings.Contains(raw, "buy-1") {
+		t.Errorf("Expected an anonymous trade, got %s", raw)
+	}
+
+	accepted, _ := readFeed(t, private, FeedMessageOrder)
+	if accepted.Order.ID != "ask-1" || accepted.Reason != "order accepted" {
+		t.Errorf("Expected the maker's order to be acknowledged, got %+v", accepted)
+	}
+	fill, raw := readFeed(t, private, FeedMessageFill)
+	if fill.Fill.OrderID != "ask-1" || fill.Fill.Liquidity != LiquidityMaker || fill.Fill.Quantity != 2 || strings.Contains(raw, "buy-1") {
+		t.Errorf("Expected the maker's own fill, got %s", raw)
+	}
+	update, _ := readFeed(t, private, FeedMessageOrder)
+	if update.Order.Status != OrderStatusPartiallyFilled || update.Order.Quantity != 1 {
+		t.Errorf("Expected the maker's order to be partially filled, got %+v", update.Order)
+	}
+}
+
+func TestPrivateFeed_NeedsAnOwner(t *testing.T) {
+	setupTest()
+	server := httptest.NewServer(newServer(HTTPConfig{KeyOwners: map[string]string{"maker-key": "maker"}}))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/v1/feed/private"
+
+	if _, response, err := websocket.DefaultDialer.Dial(url+"?owner=maker", http.Header{"X-API-Key": {"other-key"}}); err == nil || response.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected a key without an owner to be refused, got %v", err)
+	}
+
+	open := httptest.NewServer(newServer(HTTPConfig{}))
+	defer open.Close()
+	if _, response, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(open.URL, "http")+"/api/v1/feed/private", nil); err == nil || response.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected an owner to be required, got %v", err)
+	}
+}
+
+func TestTrades_AnonymousBelowL3(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "ask-1", Side: SideSell, Price: 100.0, Quantity: 1})
+	placeOn(m, Order{ID: "buy-1", Side: SideBuy, Price: 100.0, Quantity: 1})
+
+	for key, anonymous := range map[string]bool{"l2-key": true, "l3-key": false} {
+		var trades TradesResponse
+		json.NewDecoder(getAs(tiered, key, "/api/v1/trades").Body).Decode(&trades)
+		if got := trades.Trades[0].MakerID == ""; trades.Count != 1 || got != anonymous {
+			t.Errorf("%s: expected anonymous=%v, got %+v", key, anonymous, trades.Trades)
+		}
+	}
+	if trade := tradeStore.List("")[0]; trade.MakerID != "ask-1" {
+		t.Errorf("Expected the stored trade to keep its IDs, got %+v", trade)
+	}
+}
+
+func TestLoadConfig_KeyOwners(t *testing.T) {
+	cfg, err := loadConfig([]string{"-key-owners", "k1=alice, k2=bob"})
+	if err != nil || cfg.HTTP.KeyOwners["k1"] != "alice" || cfg.HTTP.KeyOwners["k2"] != "bob" {
+		t.Errorf("Unexpected key owners %+v (%v)", cfg.HTTP.KeyOwners, err)
+	}
+	if _, err := loadConfig([]string{"-key-owners", "k1"}); err == nil {
+		t.Errorf("Expected an entry without an owner to be refused")
+	}
+}
+
+func TestDropCopy_CarriesEveryOwnersExecutions(t *testing.T) {
+	setupTest()
+	server := httptest.NewServer(newServer(HTTPConfig{DropCopyKeys: []string{"compliance-key"}}))
+	defer server.Close()
+
+	dropCopy := dialFeed(t, server, "/api/v1/feed/drop-copy", "compliance-key")
+	defer dropCopy.Close()
+	waitForFeeds(t, 1)
+
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "ask-1", Side: SideSell, Price: 100.0, Quantity: 3, Owner: "alice"})
+	placeOn(m, Order{ID: "buy-1", Side: SideBuy, Price: 100.0, Quantity: 2, Owner: "bob"})
+
+	accepted, _ := readFeed(t, dropCopy, FeedMessageOrder)
+	if accepted.Channel != FeedDropCopy || accepted.Order.ID != "ask-1" || accepted.Order.Owner != "alice" {
+		t.Errorf("Expected alice's order on the drop copy, got %+v", accepted)
+	}
+	for _, want := range []struct {
+		owner     string
+		liquidity Liquidity
+	}{{"alice", LiquidityMaker}, {"bob", LiquidityTaker}} {
+		fill, raw := readFeed(t, dropCopy, FeedMessageFill)
+		if fill.Fill.Owner != want.owner || fill.Fill.Liquidity != want.liquidity || fill.Fill.Quantity != 2 {
+			t.Errorf("Expected %s's %s fill, got %s", want.owner, want.liquidity, raw)
+		}
+	}
+}
+
+func TestDropCopy_NeedsADropCopyKey(t *testing.T) {
+	setupTest()
+	url := "/api/v1/feed/drop-copy"
+	for _, test := range []struct {
+		cfg HTTPConfig
+		key string
+	}{
+		{HTTPConfig{}, "compliance-key"},
+		{HTTPConfig{DropCopyKeys: []string{"compliance-key"}}, ""},
+		{HTTPConfig{DropCopyKeys: []string{"compliance-key"}}, "trader-key"},
+	} {
+		req := httptest.NewRequest("GET", url, nil)
+		req.Header.Set("X-API-Key", test.key)
+		if result := serve(test.cfg, req); result.Code != http.StatusForbidden {
+			t.Errorf("%+v with key %q: expected 403, got %d", test.cfg, test.key, result.Code)
+		}
+	}
+	if cfg, err := loadConfig([]string{"-drop-copy-keys", "a, b"}); err != nil || len(cfg.HTTP.DropCopyKeys) != 2 {
+		t.Errorf("Unexpected drop copy keys %v (%v)", cfg.HTTP.DropCopyKeys, err)
+	}
+}
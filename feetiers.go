@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultFeeWindow is how far back trading volume counts towards a fee tier
+const defaultFeeWindow = 30 * 24 * time.Hour
+
+// FeeTier is a fee schedule an account trades under once its volume over the
+// fee window reaches MinVolume
+type FeeTier struct {
+	MinVolume float64 `json:"min_volume"`
+	MakerBps  float64 `json:"maker_bps"`
+	TakerBps  float64 `json:"taker_bps"`
+}
+
+// FeeTierProgress is how far an account is from its next fee tier
+type FeeTierProgress struct {
+	Tier int `json:"tier"`
+	FeeTier
+	// Remaining is the volume still to trade, and Progress the fraction of
+	// MinVolume already traded
+	Remaining float64 `json:"remaining"`
+	Progress  float64 `json:"progress"`
+}
+
+// FeeTierResponse is the fee tier an account trades under and the volume that earned it
+type FeeTierResponse struct {
+	AccountID string `json:"account_id"`
+	// Window is how far back volume counts, such as 720h0m0s
+	Window      string  `json:"window"`
+	MakerVolume float64 `json:"maker_volume"`
+	TakerVolume float64 `json:"taker_volume"`
+	Volume      float64 `json:"volume"`
+	// Tier is 0 for the base schedule and n for the nth tier up
+	Tier     int              `json:"tier"`
+	MakerBps float64          `json:"maker_bps"`
+	TakerBps float64          `json:"taker_bps"`
+	Next     *FeeTierProgress `json:"next,omitempty"`
+	// Tiers is the whole schedule, starting with the base one
+	Tiers []FeeTier `json:"tiers"`
+}
+
+// feeVolume is an account's notional traded on one UTC day
+type feeVolume struct {
+	day          time.Time
+	maker, taker float64
+}
+
+// feeVolumes is guarded by accountsMu
+var feeVolumes = make(map[string][]feeVolume)
+
+// feeTiersLocked lists the schedule's tiers, starting with the base one at no
+// volume. It must be called with accountsMu held.
+func feeTiersLocked() []FeeTier {
+	tiers := []FeeTier{{MakerBps: fees.MakerBps, TakerBps: fees.TakerBps}}
+	return append(tiers, fees.Tiers...)
+}
+
+// feeWindow is how far back volume counts towards a fee tier
+func feeWindow() time.Duration {
+	if fees.Window > 0 {
+		return fees.Window
+	}
+	return defaultFeeWindow
+}
+
+// volumeLocked totals an account's maker and taker volume on the days that
+// overlap the fee window. It must be called with accountsMu held.
+func volumeLocked(owner string, now time.Time) (maker, taker float64) {
+	since := now.Add(-feeWindow())
+	for _, traded := range feeVolumes[owner] {
+		if traded.day.Add(24 * time.Hour).After(since) {
+			maker += traded.maker
+			taker += traded.taker
+		}
+	}
+	return maker, taker
+}
+
+// feeTierLocked returns the highest tier an account's volume has reached and
+// its place in the schedule. It must be called with accountsMu held.
+func feeTierLocked(owner string, now time.Time) (int, FeeTier) {
+	maker, taker := volumeLocked(owner, now)
+	tiers := feeTiersLocked()
+	reached := 0
+	for i, tier := range tiers {
+		if maker+taker >= tier.MinVolume {
+			reached = i
+		}
+	}
+	return reached, tiers[reached]
+}
+
+// addVolumeLocked counts a trade's notional towards an account's fee tier and
+// drops the days that have left the window. It must be called with accountsMu
+// held.
+func addVolumeLocked(owner string, now time.Time, maker, taker float64) {
+	day := now.UTC().Truncate(24 * time.Hour)
+	since := now.Add(-feeWindow())
+	kept := feeVolumes[owner][:0]
+	for _, traded := range feeVolumes[owner] {
+		if traded.day.Add(24 * time.Hour).After(since) {
+			kept = append(kept, traded)
+		}
+	}
+	if n := len(kept); n > 0 && kept[n-1].day.Equal(day) {
+		kept[n-1].maker += maker
+		kept[n-1].taker += taker
+	} else {
+		kept = append(kept, feeVolume{day: day, maker: maker, taker: taker})
+	}
+	feeVolumes[owner] = kept
+}
+
+// accountFeeTier describes an account's fee tier, or returns false if there
+// is no such account
+func accountFeeTier(id string) (FeeTierResponse, bool) {
+	accountsMu.Lock()
+	defer accountsMu.Unlock()
+
+	if _, ok := accounts[id]; !ok {
+		return FeeTierResponse{}, false
+	}
+	now := engineClock.Now()
+	response := FeeTierResponse{AccountID: id, Window: feeWindow().String(), Tiers: feeTiersLocked()}
+	response.MakerVolume, response.TakerVolume = volumeLocked(id, now)
+	response.Volume = response.MakerVolume + response.TakerVolume
+
+	tier, rates := feeTierLocked(id, now)
+	response.Tier, response.MakerBps, response.TakerBps = tier, rates.MakerBps, rates.TakerBps
+	if tier+1 < len(response.Tiers) {
+		next := response.Tiers[tier+1]
+		response.Next = &FeeTierProgress{
+			Tier:      tier + 1,
+			FeeTier:   next,
+			Remaining: next.MinVolume - response.Volume,
+			Progress:  response.Volume / next.MinVolume,
+		}
+	}
+	return response, true
+}
+
+// parseFeeTiers reads volume=maker:taker entries, such as 1000000=8:18, and
+// orders them by volume
+func parseFeeTiers(list string) ([]FeeTier, error) {
+	var tiers []FeeTier
+	for _, item := range splitList(list) {
+		rawVolume, rates, ok := strings.Cut(item, "=")
+		rawMaker, rawTaker, ratesOK := strings.Cut(rates, ":")
+		volume, volumeErr := strconv.ParseFloat(strings.TrimSpace(rawVolume), 64)
+		maker, makerErr := strconv.ParseFloat(strings.TrimSpace(rawMaker), 64)
+		taker, takerErr := strconv.ParseFloat(strings.TrimSpace(rawTaker), 64)
+		if !ok || !ratesOK || volumeErr != nil || makerErr != nil || takerErr != nil || volume <= 0 || taker < 0 || maker < -taker {
+			return nil, fmt.Errorf("-fee-tiers entry %q must be volume=maker_bps:taker_bps with a positive volume, a taker fee of at least 0 and no maker rebate above it", item)
+		}
+		tiers = append(tiers, FeeTier{MinVolume: volume, MakerBps: maker, TakerBps: taker})
+	}
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].MinVolume < tiers[j].MinVolume })
+	for i := 1; i < len(tiers); i++ {
+		if tiers[i].MinVolume == tiers[i-1].MinVolume {
+			return nil, fmt.Errorf("-fee-tiers has two tiers at a volume of %g", tiers[i].MinVolume)
+		}
+	}
+	return tiers, nil
+}
+
+// getFeeTierHandler returns the fee tier of the account named in the path
+func getFeeTierHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id := r.PathValue("id")
+	response, ok := accountFeeTier(id)
+	if !ok {
+		writeAccountNotFound(w, id)
+		return
+	}
+	json.NewEncoder(w).Encode(response)
+}
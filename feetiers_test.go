@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFeeTiers_ApplyOnceVolumeIsReached(t *testing.T) {
+	setupTest()
+	clock := useDeterministicEngine(t, time.Date(2024, 1, 1, 9, 30, 0, 0, time.UTC))
+	resetSymbols([]string{"BTC-USD"})
+	fees = FeeSchedule{MakerBps: 10, TakerBps: 20, Window: defaultFeeWindow, Tiers: []FeeTier{
+		{MinVolume: 500, MakerBps: 5, TakerBps: 10},
+		{MinVolume: 2000, MakerBps: 0, TakerBps: 5},
+	}}
+	openFunded(t, "maker", map[string]float64{"BTC": 10})
+	openFunded(t, "taker", map[string]float64{"USD": 10000})
+
+	// The taker pays 20 bps on the first 600 of volume and 10 bps after it
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "ask", Symbol: "BTC-USD", Side: SideSell, Price: 100.0, Quantity: 10, Owner: "maker"})
+	for i := 0; i < 3; i++ {
+		placeOn(m, Order{ID: fmt.Sprintf("buy-%d", i), Symbol: "BTC-USD", Side: SideBuy, Price: 100.0, Quantity: 3, Owner: "taker"})
+	}
+	if taker, _ := lookupAccount("taker"); !approxEqual(taker.Balances["USD"], 10000-900-0.6-0.6-0.3) {
+		t.Errorf("Expected the third fill charged at the first tier, got %+v", taker.Balances)
+	}
+
+	var response FeeTierResponse
+	json.NewDecoder(serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/accounts/taker/fee-tier", nil)).Body).Decode(&response)
+	if response.Tier != 1 || response.TakerVolume != 900 || response.MakerVolume != 0 || response.TakerBps != 10 || len(response.Tiers) != 3 {
+		t.Fatalf("Expected the taker on the first tier, got %+v", response)
+	}
+	if response.Next == nil || response.Next.Tier != 2 || response.Next.Remaining != 1100 || !approxEqual(response.Next.Progress, 0.45) {
+		t.Errorf("Unexpected progress %+v", response.Next)
+	}
+	if tier, _ := accountFeeTier("maker"); tier.Tier != 1 || tier.MakerVolume != 900 {
+		t.Errorf("Expected the maker on the first tier, got %+v", tier)
+	}
+
+	// Volume leaves the window a day at a time
+	clock.Advance(30 * 24 * time.Hour)
+	if tier, _ := accountFeeTier("taker"); tier.Volume != 900 {
+		t.Errorf("Expected the first day still in the window, got %+v", tier)
+	}
+	clock.Advance(24 * time.Hour)
+	if tier, _ := accountFeeTier("taker"); tier.Tier != 0 || tier.Volume != 0 || tier.Next.Remaining != 500 {
+		t.Errorf("Expected the taker back on the base fees, got %+v", tier)
+	}
+
+	if result := serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/accounts/nobody/fee-tier", nil)); result.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown account, got %d", result.Code)
+	}
+}
+
+func TestLoadConfig_FeeTiers(t *testing.T) {
+	cfg, err := loadConfig([]string{"-fee-tiers", "5000000=2:8, 1000000=5:15"})
+	if err != nil || len(cfg.Fees.Tiers) != 2 || cfg.Fees.Tiers[0].MinVolume != 1000000 || cfg.Fees.Tiers[1].MakerBps != 2 || cfg.Fees.Window != defaultFeeWindow {
+		t.Errorf("Unexpected fee tiers %+v (%v)", cfg.Fees, err)
+	}
+	for _, args := range [][]string{
+		{"-fee-tiers", "1000000=5"},
+		{"-fee-tiers", "0=5:15"},
+		{"-fee-tiers", "1000000=-20:15"},
+		{"-fee-tiers", "1000000=5:15,1000000=4:12"},
+		{"-fee-volume-window", "1h"},
+	} {
+		if _, err := loadConfig(args); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
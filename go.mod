@@ -2,4 +2,24 @@ module valhalla
 
 go 1.22.4
 
-require github.com/google/uuid v1.6.0 // indirect
+require (
+	github.com/alicebob/miniredis/v2 v2.35.0
+	github.com/google/uuid v1.6.0
+	github.com/gorilla/websocket v1.5.3
+	github.com/parquet-go/parquet-go v0.23.0
+	github.com/yuin/gopher-lua v1.1.1
+	golang.org/x/crypto v0.24.0
+)
+
+require (
+	github.com/andybalholm/brotli v1.1.0 // indirect
+	github.com/klauspost/compress v1.17.9 // indirect
+	github.com/mattn/go-runewidth v0.0.15 // indirect
+	github.com/olekukonko/tablewriter v0.0.5 // indirect
+	github.com/pierrec/lz4/v4 v4.1.21 // indirect
+	github.com/rivo/uniseg v0.4.7 // indirect
+	github.com/segmentio/encoding v0.4.0 // indirect
+	golang.org/x/net v0.21.0 // indirect
+	golang.org/x/sys v0.21.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
+)
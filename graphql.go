@@ -0,0 +1,1448 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// GraphQLRequest is the body of a GraphQL query over HTTP, and the payload of
+// a subscribe message over the WebSocket
+type GraphQLRequest struct {
+	Query         string                 `json:"query" validate:"required"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// GraphQLResponse carries the selected data and any errors. Fields that
+// failed are null in data, with an error naming their path.
+type GraphQLResponse struct {
+	Data   interface{}    `json:"data,omitempty"`
+	Errors []GraphQLError `json:"errors,omitempty"`
+}
+
+// GraphQLError is one problem with a query or one of its fields
+type GraphQLError struct {
+	Message    string                  `json:"message"`
+	Path       []interface{}           `json:"path,omitempty"`
+	Extensions *GraphQLErrorExtensions `json:"extensions,omitempty"`
+}
+
+// GraphQLErrorExtensions gives a field error the code the REST API would use
+type GraphQLErrorExtensions struct {
+	Code ErrorCode `json:"code"`
+}
+
+// graphQLQueryError is a resolver failure with the REST error code it maps to
+type graphQLQueryError struct {
+	code    ErrorCode
+	message string
+}
+
+func (e graphQLQueryError) Error() string { return e.message }
+
+// graphQLArg is an argument a root field takes. kind is string, int, float64,
+// bool or one of the enumerations in apiEnums.
+type graphQLArg struct {
+	name     string
+	kind     reflect.Type
+	required bool
+}
+
+// graphQLArgs are a field's arguments after variables are substituted and
+// values checked against their kinds. Missing arguments read as zero.
+type graphQLArgs map[string]interface{}
+
+func (a graphQLArgs) string(name string) string {
+	value, _ := a[name].(string)
+	return value
+}
+
+func (a graphQLArgs) int(name string) int {
+	value, _ := a[name].(int)
+	return value
+}
+
+// graphQLField is a root field of the query or subscription type
+type graphQLField struct {
+	name        string
+	description string
+	args        []graphQLArg
+	// result is the Go type the field resolves to; a pointer may be null
+	result reflect.Type
+	// need is the entitlement the field's data needs
+	need Entitlement
+	// resolve answers a query field
+	resolve func(ctx context.Context, args graphQLArgs) (interface{}, error)
+	// subscribe starts a subscription field on the public feed, with pick
+	// choosing the value each feed message carries, if any
+	subscribe func(args graphQLArgs) (*feedSubscriber, func(FeedMessage) (interface{}, bool), error)
+}
+
+var (
+	stringType = reflect.TypeOf("")
+	intType    = reflect.TypeOf(0)
+	symbolArg  = graphQLArg{name: "symbol", kind: stringType}
+	idArg      = graphQLArg{name: "id", kind: stringType, required: true}
+)
+
+// graphQLQueries lists the fields of the query type. They read the same data
+// as the REST endpoints, with the same entitlements.
+func graphQLQueries() []graphQLField {
+	return []graphQLField{
+		{name: "symbols", description: "Every traded symbol", result: reflect.TypeOf([]string{}),
+			resolve: func(ctx context.Context, args graphQLArgs) (interface{}, error) {
+				return listSymbols(), nil
+			}},
+		{name: "orders", description: "Resting orders, on one symbol or all of them", args: []graphQLArg{symbolArg},
+			result: reflect.TypeOf([]Order{}), need: EntitlementL3,
+			resolve: func(ctx context.Context, args graphQLArgs) (interface{}, error) {
+				return collectOrders(args.string("symbol")), nil
+			}},
+		{name: "order", description: "A resting order by ID", args: []graphQLArg{idArg},
+			result: reflect.TypeOf(&Order{}), need: EntitlementL3,
+			resolve: func(ctx context.Context, args graphQLArgs) (interface{}, error) {
+				for _, order := range collectOrders("") {
+					if order.ID == args.string("id") {
+						return &order, nil
+					}
+				}
+				return (*Order)(nil), nil
+			}},
+		{name: "trades", description: "Trades still in memory, oldest first; limit keeps the latest",
+			args:   []graphQLArg{symbolArg, {name: "aggressor_side", kind: reflect.TypeOf(Side(""))}, {name: "limit", kind: intType}},
+			result: reflect.TypeOf([]Trade{}),
+			resolve: func(ctx context.Context, args graphQLArgs) (interface{}, error) {
+				trades := tradeStore.List(args.string("symbol"))
+				if side := Side(args.string("aggressor_side")); side != "" {
+					trades = slices.DeleteFunc(trades, func(trade Trade) bool { return trade.AggressorSide != side })
+				}
+				if limit := args.int("limit"); limit > 0 && len(trades) > limit {
+					trades = trades[len(trades)-limit:]
+				}
+				return anonymizeTrades(ctx, trades), nil
+			}},
+		{name: "trade", description: "A trade by ID, while it is still in memory", args: []graphQLArg{idArg},
+			result: reflect.TypeOf(&Trade{}),
+			resolve: func(ctx context.Context, args graphQLArgs) (interface{}, error) {
+				if trade, ok := tradeStore.Get(args.string("id")); ok {
+					return &anonymizeTrades(ctx, []Trade{trade})[0], nil
+				}
+				return (*Trade)(nil), nil
+			}},
+		{name: "depth", description: "Aggregated depth with a sequence number; only the best level with the top entitlement",
+			args:   []graphQLArg{symbolArg, {name: "levels", kind: intType}},
+			result: reflect.TypeOf(DepthSnapshot{}),
+			resolve: func(ctx context.Context, args graphQLArgs) (interface{}, error) {
+				m, err := graphQLMatcher(args)
+				if err != nil {
+					return nil, err
+				}
+				limit := args.int("levels")
+				if !entitlementFrom(ctx).allows(EntitlementL2) {
+					limit = 1
+				}
+				return m.readView().depth(m.symbol, limit), nil
+			}},
+		{name: "candles", description: "Open, high, low and close candles of a symbol's trades",
+			args:   []graphQLArg{symbolArg, {name: "interval", kind: stringType}, {name: "limit", kind: intType}},
+			result: reflect.TypeOf([]Candle{}),
+			resolve: func(ctx context.Context, args graphQLArgs) (interface{}, error) {
+				m, err := graphQLMatcher(args)
+				if err != nil {
+					return nil, err
+				}
+				interval, limit, problems := parseCandleQuery(args.string("interval"), strconv.Itoa(args.int("limit")))
+				if len(problems) > 0 {
+					return nil, graphQLQueryError{ErrCodeValidationFailed, strings.Join(problems, "; ")}
+				}
+				return buildCandles(m.symbol, tradeStore.List(m.symbol), interval, limit), nil
+			}},
+		{name: "accounts", description: "Every account, oldest first", result: reflect.TypeOf([]Account{}),
+			resolve: func(ctx context.Context, args graphQLArgs) (interface{}, error) {
+				return accountSnapshots(), nil
+			}},
+		{name: "account", description: "An account by ID", args: []graphQLArg{idArg},
+			result: reflect.TypeOf(&Account{}),
+			resolve: func(ctx context.Context, args graphQLArgs) (interface{}, error) {
+				if account, ok := lookupAccount(args.string("id")); ok {
+					return &account, nil
+				}
+				return (*Account)(nil), nil
+			}},
+	}
+}
+
+// graphQLSubscriptions lists the fields of the subscription type
+func graphQLSubscriptions() []graphQLField {
+	return []graphQLField{
+		{name: "trades", description: "Each trade on a symbol as it happens, without order IDs", args: []graphQLArg{symbolArg},
+			result: reflect.TypeOf(PublicTrade{}),
+			subscribe: func(args graphQLArgs) (*feedSubscriber, func(FeedMessage) (interface{}, bool), error) {
+				m, err := graphQLMatcher(args)
+				if err != nil {
+					return nil, nil, err
+				}
+				return &feedSubscriber{channel: FeedPublic, symbol: m.symbol}, func(msg FeedMessage) (interface{}, bool) {
+					return msg.Trade, msg.Type == FeedMessageTrade
+				}, nil
+			}},
+		{name: "book", description: "Depth updates for a symbol, including resyncs, as on the depth stream", args: []graphQLArg{symbolArg},
+			result: reflect.TypeOf(DepthUpdate{}), need: EntitlementL2,
+			subscribe: func(args graphQLArgs) (*feedSubscriber, func(FeedMessage) (interface{}, bool), error) {
+				m, err := graphQLMatcher(args)
+				if err != nil {
+					return nil, nil, err
+				}
+				return &feedSubscriber{channel: FeedPublic, symbol: m.symbol}, func(msg FeedMessage) (interface{}, bool) {
+					switch msg.Type {
+					case FeedMessageDepth:
+						return msg.Depth, true
+					case FeedMessageResync:
+						return DepthUpdate{Type: DepthMessageResync, Symbol: msg.Symbol}, true
+					}
+					return nil, false
+				}, nil
+			}},
+	}
+}
+
+// graphQLMatcher finds the matcher for a field's symbol argument
+func graphQLMatcher(args graphQLArgs) (*matcher, error) {
+	m, ok := matcherFor(args.string("symbol"))
+	if !ok {
+		return nil, graphQLQueryError{ErrCodeUnknownSymbol, "symbol '" + args.string("symbol") + "' is not traded here"}
+	}
+	return m, nil
+}
+
+// graphQLDocument is a parsed query: its operations and named fragments
+type graphQLDocument struct {
+	operations []*graphQLOperation
+	fragments  map[string]*graphQLFragment
+}
+
+// graphQLOperation is one query, mutation or subscription in a document
+type graphQLOperation struct {
+	kind       string
+	name       string
+	variables  []graphQLVariable
+	selections []graphQLSelection
+}
+
+// graphQLVariable is a declared operation variable
+type graphQLVariable struct {
+	name         string
+	required     bool
+	defaultValue interface{}
+}
+
+// graphQLFragment is a named fragment and the type it applies to
+type graphQLFragment struct {
+	on         string
+	selections []graphQLSelection
+}
+
+// graphQLSelection is a field, a fragment spread or an inline fragment
+type graphQLSelection struct {
+	alias      string
+	name       string
+	args       map[string]interface{}
+	directives []graphQLDirective
+	selections []graphQLSelection
+	// spread names a fragment; inline fragments set on and selections instead
+	spread string
+	inline bool
+	on     string
+}
+
+// graphQLDirective is an @include or @skip on a selection
+type graphQLDirective struct {
+	name string
+	args map[string]interface{}
+}
+
+// graphQLEnum and graphQLVariableRef are the literal kinds JSON has no
+// equivalent for
+type (
+	graphQLEnum        string
+	graphQLVariableRef string
+)
+
+// key is the name a field's value has in the response
+func (s graphQLSelection) key() string {
+	if s.alias != "" {
+		return s.alias
+	}
+	return s.name
+}
+
+// operation picks the operation to run: the named one, or the only one
+func (d *graphQLDocument) operation(name string) (*graphQLOperation, error) {
+	if name == "" {
+		if len(d.operations) != 1 {
+			return nil, fmt.Errorf("operationName is required when the document has %d operations", len(d.operations))
+		}
+		return d.operations[0], nil
+	}
+	for _, op := range d.operations {
+		if op.name == name {
+			return op, nil
+		}
+	}
+	return nil, fmt.Errorf("no operation named %q", name)
+}
+
+// graphQLParser reads a document. The first error stops it: the position
+// jumps to the end so every loop finishes.
+type graphQLParser struct {
+	src string
+	pos int
+	err error
+}
+
+// parseGraphQL parses a query document
+func parseGraphQL(src string) (*graphQLDocument, error) {
+	p := &graphQLParser{src: src}
+	doc := &graphQLDocument{fragments: make(map[string]*graphQLFragment)}
+	for p.peek() != 0 {
+		switch {
+		case p.peek() == '{':
+			doc.operations = append(doc.operations, &graphQLOperation{kind: "query", selections: p.selectionSet()})
+		case p.keyword("fragment"):
+			name := p.name()
+			if !p.keyword("on") {
+				p.fail("expected 'on' after fragment %s", name)
+			}
+			doc.fragments[name] = &graphQLFragment{on: p.name(), selections: p.selectionSet()}
+		default:
+			doc.operations = append(doc.operations, p.operationDefinition())
+		}
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	if len(doc.operations) == 0 {
+		return nil, fmt.Errorf("the document has no operations")
+	}
+	return doc, nil
+}
+
+// fail records the first syntax error
+func (p *graphQLParser) fail(format string, args ...interface{}) {
+	if p.err == nil {
+		p.err = fmt.Errorf("syntax error at offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+	}
+	p.pos = len(p.src)
+}
+
+// peek skips whitespace, commas and comments and returns the next byte, or
+// zero at the end
+func (p *graphQLParser) peek() byte {
+	for p.pos < len(p.src) {
+		switch c := p.src[p.pos]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			p.pos++
+		case c == '#':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return c
+		}
+	}
+	return 0
+}
+
+// expect consumes the punctuator c
+func (p *graphQLParser) expect(c byte) {
+	if p.peek() != c {
+		p.fail("expected '%c'", c)
+		return
+	}
+	p.pos++
+}
+
+// accept consumes the punctuator c if it is next
+func (p *graphQLParser) accept(c byte) bool {
+	if p.peek() == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// isNameByte reports whether c may appear in a name, and start at the first byte
+func isNameByte(c byte, start bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!start && c >= '0' && c <= '9')
+}
+
+// name consumes a name
+func (p *graphQLParser) name() string {
+	if !isNameByte(p.peek(), true) {
+		p.fail("expected a name")
+		return ""
+	}
+	start := p.pos
+	for p.pos < len(p.src) && isNameByte(p.src[p.pos], false) {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+// keyword consumes word if it is the next name
+func (p *graphQLParser) keyword(word string) bool {
+	p.peek()
+	end := p.pos + len(word)
+	if strings.HasPrefix(p.src[p.pos:], word) && (end == len(p.src) || !isNameByte(p.src[end], false)) {
+		p.pos = end
+		return true
+	}
+	return false
+}
+
+// operationDefinition parses a named or typed operation
+func (p *graphQLParser) operationDefinition() *graphQLOperation {
+	op := &graphQLOperation{}
+	for _, kind := range []string{"query", "mutation", "subscription"} {
+		if p.keyword(kind) {
+			op.kind = kind
+			break
+		}
+	}
+	if op.kind == "" {
+		p.fail("expected an operation or fragment")
+		return op
+	}
+	if isNameByte(p.peek(), true) {
+		op.name = p.name()
+	}
+	if p.accept('(') {
+		for p.peek() != ')' && p.peek() != 0 {
+			p.expect('$')
+			variable := graphQLVariable{name: p.name()}
+			p.expect(':')
+			variable.required = p.typeReference()
+			if p.accept('=') {
+				variable.defaultValue = p.value(true)
+			}
+			op.variables = append(op.variables, variable)
+		}
+		p.expect(')')
+	}
+	p.directives()
+	op.selections = p.selectionSet()
+	return op
+}
+
+// typeReference consumes a variable's type and reports whether it is non-null.
+// Types are not checked beyond that; argument values are checked when used.
+func (p *graphQLParser) typeReference() bool {
+	if p.accept('[') {
+		p.typeReference()
+		p.expect(']')
+	} else {
+		p.name()
+	}
+	return p.accept('!')
+}
+
+// selectionSet parses the braces of fields and fragments
+func (p *graphQLParser) selectionSet() []graphQLSelection {
+	p.expect('{')
+	var selections []graphQLSelection
+	for p.peek() != '}' && p.peek() != 0 {
+		if strings.HasPrefix(p.src[p.pos:], "...") {
+			p.pos += 3
+			selection := graphQLSelection{}
+			switch {
+			case p.keyword("on"):
+				selection.inline, selection.on = true, p.name()
+			case isNameByte(p.peek(), true):
+				selection.spread = p.name()
+			default:
+				selection.inline = true
+			}
+			selection.directives = p.directives()
+			if selection.inline {
+				selection.selections = p.selectionSet()
+			}
+			selections = append(selections, selection)
+			continue
+		}
+
+		selection := graphQLSelection{name: p.name()}
+		if p.accept(':') {
+			selection.alias, selection.name = selection.name, p.name()
+		}
+		if p.peek() == '(' {
+			selection.args = p.arguments()
+		}
+		selection.directives = p.directives()
+		if p.peek() == '{' {
+			selection.selections = p.selectionSet()
+		}
+		selections = append(selections, selection)
+	}
+	p.expect('}')
+	if len(selections) == 0 && p.err == nil {
+		p.fail("a selection set may not be empty")
+	}
+	return selections
+}
+
+// arguments parses a parenthesized list of name: value pairs
+func (p *graphQLParser) arguments() map[string]interface{} {
+	p.expect('(')
+	args := make(map[string]interface{})
+	for p.peek() != ')' && p.peek() != 0 {
+		name := p.name()
+		p.expect(':')
+		args[name] = p.value(false)
+	}
+	p.expect(')')
+	return args
+}
+
+// directives parses any @name(args) following a selection
+func (p *graphQLParser) directives() []graphQLDirective {
+	var directives []graphQLDirective
+	for p.accept('@') {
+		directive := graphQLDirective{name: p.name()}
+		if p.peek() == '(' {
+			directive.args = p.arguments()
+		}
+		directives = append(directives, directive)
+	}
+	return directives
+}
+
+// value parses a literal, list, object or, unless constant, a variable
+func (p *graphQLParser) value(constant bool) interface{} {
+	switch c := p.peek(); {
+	case c == '$' && !constant:
+		p.pos++
+		return graphQLVariableRef(p.name())
+	case c == '"':
+		return p.stringValue()
+	case c == '-' || (c >= '0' && c <= '9'):
+		start := p.pos
+		p.pos++
+		for p.pos < len(p.src) && strings.IndexByte("0123456789.eE+-", p.src[p.pos]) >= 0 {
+			p.pos++
+		}
+		text := p.src[start:p.pos]
+		if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return i
+		}
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			p.fail("invalid number %q", text)
+		}
+		return f
+	case c == '[':
+		p.pos++
+		list := []interface{}{}
+		for p.peek() != ']' && p.peek() != 0 {
+			list = append(list, p.value(constant))
+		}
+		p.expect(']')
+		return list
+	case c == '{':
+		p.pos++
+		object := make(map[string]interface{})
+		for p.peek() != '}' && p.peek() != 0 {
+			name := p.name()
+			p.expect(':')
+			object[name] = p.value(constant)
+		}
+		p.expect('}')
+		return object
+	case isNameByte(c, true):
+		switch name := p.name(); name {
+		case "true":
+			return true
+		case "false":
+			return false
+		case "null":
+			return nil
+		default:
+			return graphQLEnum(name)
+		}
+	}
+	p.fail("expected a value")
+	return nil
+}
+
+// stringValue parses a quoted string. JSON's escapes are GraphQL's, apart
+// from block strings, which are not supported.
+func (p *graphQLParser) stringValue() string {
+	if strings.HasPrefix(p.src[p.pos:], `"""`) {
+		p.fail("block strings are not supported")
+		return ""
+	}
+	end := p.pos + 1
+	for end < len(p.src) && p.src[end] != '"' {
+		if p.src[end] == '\\' {
+			end++
+		}
+		end++
+	}
+	if end >= len(p.src) {
+		p.fail("unterminated string")
+		return ""
+	}
+	var s string
+	if err := json.Unmarshal([]byte(p.src[p.pos:end+1]), &s); err != nil {
+		p.fail("invalid string: %v", err)
+	}
+	p.pos = end + 1
+	return s
+}
+
+// graphQLObject is a response object that keeps its fields in selection order
+type graphQLObject []graphQLEntry
+
+// graphQLEntry is one field of a response object
+type graphQLEntry struct {
+	key   string
+	value interface{}
+}
+
+// MarshalJSON writes the fields in order
+func (o graphQLObject) MarshalJSON() ([]byte, error) {
+	buf := []byte{'{'}
+	for i, entry := range o {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		key, _ := json.Marshal(entry.key)
+		value, err := json.Marshal(entry.value)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(append(append(buf, key...), ':'), value...)
+	}
+	return append(buf, '}'), nil
+}
+
+// graphQLExecution runs one operation, collecting field errors as it goes
+type graphQLExecution struct {
+	ctx       context.Context
+	doc       *graphQLDocument
+	variables map[string]interface{}
+	errors    []GraphQLError
+}
+
+// newGraphQLExecution parses a request and picks its operation, with the
+// variables' defaults applied
+func newGraphQLExecution(ctx context.Context, req GraphQLRequest) (*graphQLExecution, *graphQLOperation, error) {
+	doc, err := parseGraphQL(req.Query)
+	if err != nil {
+		return nil, nil, err
+	}
+	op, err := doc.operation(req.OperationName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	variables := make(map[string]interface{}, len(op.variables))
+	for _, variable := range op.variables {
+		value, ok := req.Variables[variable.name]
+		switch {
+		case ok && value != nil:
+			variables[variable.name] = value
+		case variable.defaultValue != nil:
+			variables[variable.name] = variable.defaultValue
+		case variable.required:
+			return nil, nil, fmt.Errorf("variable $%s is required", variable.name)
+		}
+	}
+	return &graphQLExecution{ctx: ctx, doc: doc, variables: variables}, op, nil
+}
+
+// fail records the error of a root field that resolved to null
+func (e *graphQLExecution) fail(key string, err error) {
+	graphQLErr := graphQLErrorFrom(err)
+	graphQLErr.Path = []interface{}{key}
+	e.errors = append(e.errors, graphQLErr)
+}
+
+// collect flattens fragments and applies @include and @skip, merging the
+// selections of fields that share a response key. typeName is the object type
+// fragments are matched against.
+func (e *graphQLExecution) collect(selections []graphQLSelection, typeName string, visited map[string]bool) ([]graphQLSelection, error) {
+	var fields []graphQLSelection
+	index := make(map[string]int)
+	var walk func(selections []graphQLSelection) error
+	walk = func(selections []graphQLSelection) error {
+		for _, selection := range selections {
+			included, err := e.included(selection.directives)
+			if err != nil {
+				return err
+			}
+			if !included {
+				continue
+			}
+
+			switch {
+			case selection.spread != "":
+				fragment, ok := e.doc.fragments[selection.spread]
+				if !ok {
+					return fmt.Errorf("unknown fragment %q", selection.spread)
+				}
+				if visited[selection.spread] {
+					return fmt.Errorf("fragment %q spreads itself", selection.spread)
+				}
+				if fragment.on != typeName {
+					continue
+				}
+				visited[selection.spread] = true
+				err := walk(fragment.selections)
+				delete(visited, selection.spread)
+				if err != nil {
+					return err
+				}
+			case selection.inline:
+				if selection.on != "" && selection.on != typeName {
+					continue
+				}
+				if err := walk(selection.selections); err != nil {
+					return err
+				}
+			default:
+				if i, ok := index[selection.key()]; ok {
+					fields[i].selections = append(fields[i].selections, selection.selections...)
+					continue
+				}
+				index[selection.key()] = len(fields)
+				fields = append(fields, selection)
+			}
+		}
+		return nil
+	}
+	return fields, walk(selections)
+}
+
+// included evaluates a selection's @include and @skip directives
+func (e *graphQLExecution) included(directives []graphQLDirective) (bool, error) {
+	for _, directive := range directives {
+		if directive.name != "include" && directive.name != "skip" {
+			return false, fmt.Errorf("unknown directive @%s", directive.name)
+		}
+		value, err := e.resolveValue(directive.args["if"])
+		condition, ok := value.(bool)
+		if err != nil || !ok {
+			return false, fmt.Errorf("@%s needs a boolean 'if' argument", directive.name)
+		}
+		if condition == (directive.name == "skip") {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// resolveValue substitutes variables into a literal
+func (e *graphQLExecution) resolveValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case graphQLVariableRef:
+		resolved, ok := e.variables[string(v)]
+		if !ok {
+			return nil, nil
+		}
+		return resolved, nil
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			resolved, err := e.resolveValue(item)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = resolved
+		}
+		return list, nil
+	}
+	return value, nil
+}
+
+// arguments checks a field's arguments against what it takes
+func (e *graphQLExecution) arguments(field graphQLField, given map[string]interface{}) (graphQLArgs, error) {
+	for name := range given {
+		if !slices.ContainsFunc(field.args, func(arg graphQLArg) bool { return arg.name == name }) {
+			return nil, graphQLQueryError{ErrCodeValidationFailed, fmt.Sprintf("unknown argument %q on field %q", name, field.name)}
+		}
+	}
+
+	args := make(graphQLArgs, len(field.args))
+	for _, arg := range field.args {
+		value, err := e.resolveValue(given[arg.name])
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			if arg.required {
+				return nil, graphQLQueryError{ErrCodeValidationFailed, fmt.Sprintf("argument %q of field %q is required", arg.name, field.name)}
+			}
+			continue
+		}
+		coerced, ok := coerceGraphQLArg(arg.kind, value)
+		if !ok {
+			return nil, graphQLQueryError{ErrCodeValidationFailed, fmt.Sprintf("argument %q of field %q must be %s (received: %v)", arg.name, field.name, graphQLTypeName(arg.kind), value)}
+		}
+		args[arg.name] = coerced
+	}
+	return args, nil
+}
+
+// coerceGraphQLArg converts a literal or variable value to an argument's kind.
+// Enumerations and strings come back as strings. Literal integers are int64;
+// numbers in JSON variables are float64.
+func coerceGraphQLArg(kind reflect.Type, value interface{}) (interface{}, bool) {
+	if values, ok := apiEnums[kind]; ok {
+		var name string
+		switch v := value.(type) {
+		case graphQLEnum:
+			name = string(v)
+		case string:
+			name = v
+		}
+		return name, slices.Contains(values, name)
+	}
+
+	switch kind.Kind() {
+	case reflect.String:
+		s, ok := value.(string)
+		return s, ok
+	case reflect.Int:
+		switch v := value.(type) {
+		case int64:
+			return int(v), true
+		case float64:
+			return int(v), v == float64(int(v))
+		}
+	case reflect.Float64:
+		switch v := value.(type) {
+		case int64:
+			return float64(v), true
+		case float64:
+			return v, true
+		}
+	case reflect.Bool:
+		b, ok := value.(bool)
+		return b, ok
+	}
+	return nil, false
+}
+
+// validate checks an operation's selections against the schema before any
+// field runs, returning the root fields with their arguments
+func (e *graphQLExecution) validate(op *graphQLOperation, roots []graphQLField, typeName string) ([]graphQLSelection, []graphQLField, []graphQLArgs, error) {
+	selections, err := e.collect(op.selections, typeName, map[string]bool{})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	fields := make([]graphQLField, len(selections))
+	args := make([]graphQLArgs, len(selections))
+	for i, selection := range selections {
+		if selection.name == "__typename" {
+			continue
+		}
+		if strings.HasPrefix(selection.name, "__") {
+			return nil, nil, nil, fmt.Errorf("introspection is not supported; the schema is served at %s/graphql/schema", apiPrefix)
+		}
+		j := slices.IndexFunc(roots, func(field graphQLField) bool { return field.name == selection.name })
+		if j < 0 {
+			return nil, nil, nil, fmt.Errorf("cannot query field %q on type %s", selection.name, typeName)
+		}
+		if args[i], err = e.arguments(roots[j], selection.args); err != nil {
+			return nil, nil, nil, err
+		}
+		if err := e.validateSelection(selection, roots[j].result); err != nil {
+			return nil, nil, nil, err
+		}
+		fields[i] = roots[j]
+	}
+	return selections, fields, args, nil
+}
+
+// validateSelection checks a field's subfields against the Go type it
+// resolves to: objects need a selection, scalars may not have one, and only
+// root fields take arguments
+func (e *graphQLExecution) validateSelection(selection graphQLSelection, t reflect.Type) error {
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t == timeType {
+		if len(selection.selections) > 0 {
+			return fmt.Errorf("field %q is a scalar and takes no selection of subfields", selection.name)
+		}
+		return nil
+	}
+	if len(selection.selections) == 0 {
+		return fmt.Errorf("field %q of type %s must have a selection of subfields", selection.name, t.Name())
+	}
+
+	fields, err := e.collect(selection.selections, t.Name(), map[string]bool{})
+	if err != nil {
+		return err
+	}
+	index := graphQLFieldsOf(t)
+	for _, field := range fields {
+		if field.name == "__typename" {
+			continue
+		}
+		structField, ok := index[field.name]
+		if !ok {
+			return fmt.Errorf("cannot query field %q on type %s", field.name, t.Name())
+		}
+		if len(field.args) > 0 {
+			return fmt.Errorf("field %q takes no arguments", field.name)
+		}
+		if err := e.validateSelection(field, structField.Type); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// entitled checks the caller may see a root field's data
+func (e *graphQLExecution) entitled(field graphQLField) error {
+	if entitlement := entitlementFrom(e.ctx); field.need != "" && !entitlement.allows(field.need) {
+		return graphQLQueryError{ErrCodeNotEntitled,
+			fmt.Sprintf("field %q needs the %s entitlement; the API key has %s", field.name, field.need, entitlement)}
+	}
+	return nil
+}
+
+// execute resolves the root fields of a validated query. A field that fails
+// is null, with an error naming it, while the others still resolve.
+func (e *graphQLExecution) execute(selections []graphQLSelection, fields []graphQLField, args []graphQLArgs) graphQLObject {
+	data := make(graphQLObject, 0, len(selections))
+	for i, selection := range selections {
+		if selection.name == "__typename" {
+			data = append(data, graphQLEntry{selection.key(), "Query"})
+			continue
+		}
+
+		value, err := interface{}(nil), e.entitled(fields[i])
+		if err == nil {
+			value, err = fields[i].resolve(e.ctx, args[i])
+		}
+		if err != nil {
+			e.fail(selection.key(), err)
+			data = append(data, graphQLEntry{selection.key(), nil})
+			continue
+		}
+		data = append(data, graphQLEntry{selection.key(), e.project(reflect.ValueOf(value), selection)})
+	}
+	return data
+}
+
+// project selects the requested fields from a resolved value
+func (e *graphQLExecution) project(v reflect.Value, selection graphQLSelection) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	t := v.Type()
+	switch {
+	case t == timeType:
+		return v.Interface().(time.Time).Format(time.RFC3339Nano)
+	case t == durationType:
+		return v.Int()
+	case t.Kind() == reflect.Pointer || t.Kind() == reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return e.project(v.Elem(), selection)
+	case t.Kind() == reflect.Slice:
+		list := make([]interface{}, v.Len())
+		for i := range list {
+			list[i] = e.project(v.Index(i), selection)
+		}
+		return list
+	case t.Kind() == reflect.Struct:
+		return e.projectObject(v, selection)
+	}
+
+	if _, ok := apiEnums[t]; ok && v.String() == "" {
+		// Unset enumerations have no GraphQL value
+		return nil
+	}
+	if t.Kind() == reflect.Map && v.IsNil() {
+		return nil
+	}
+	return v.Interface()
+}
+
+// projectObject selects fields from a struct by their json names. The
+// selection has been validated, so every field exists.
+func (e *graphQLExecution) projectObject(v reflect.Value, selection graphQLSelection) interface{} {
+	fields, _ := e.collect(selection.selections, v.Type().Name(), map[string]bool{})
+	index := graphQLFieldsOf(v.Type())
+	object := make(graphQLObject, 0, len(fields))
+	for _, field := range fields {
+		if field.name == "__typename" {
+			object = append(object, graphQLEntry{field.key(), v.Type().Name()})
+			continue
+		}
+		object = append(object, graphQLEntry{field.key(), e.project(v.FieldByIndex(index[field.name].Index), field)})
+	}
+	return object
+}
+
+// graphQLFields caches each struct's fields by json name
+var graphQLFields sync.Map
+
+// graphQLFieldsOf returns a struct's exported fields by json name, including
+// those of embedded structs, following the same rules as objectSchema
+func graphQLFieldsOf(t reflect.Type) map[string]reflect.StructField {
+	if cached, ok := graphQLFields.Load(t); ok {
+		return cached.(map[string]reflect.StructField)
+	}
+
+	fields := make(map[string]reflect.StructField)
+	for _, field := range reflect.VisibleFields(t) {
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || (field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct) {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field
+	}
+	graphQLFields.Store(t, fields)
+	return fields
+}
+
+// executeGraphQL answers a query or mutation over HTTP
+func executeGraphQL(ctx context.Context, req GraphQLRequest) GraphQLResponse {
+	execution, op, err := newGraphQLExecution(ctx, req)
+	if err != nil {
+		return GraphQLResponse{Errors: []GraphQLError{{Message: err.Error()}}}
+	}
+	switch op.kind {
+	case "mutation":
+		return GraphQLResponse{Errors: []GraphQLError{{Message: "mutations are not supported; place and cancel orders through the REST API"}}}
+	case "subscription":
+		return GraphQLResponse{Errors: []GraphQLError{{Message: "subscriptions need a WebSocket connection to " + apiPrefix + "/graphql"}}}
+	}
+
+	selections, fields, args, err := execution.validate(op, graphQLQueries(), "Query")
+	if err != nil {
+		return GraphQLResponse{Errors: []GraphQLError{graphQLErrorFrom(err)}}
+	}
+	data := execution.execute(selections, fields, args)
+	return GraphQLResponse{Data: data, Errors: execution.errors}
+}
+
+// graphQLHandler answers a GraphQL query. Field errors come back alongside
+// the data with a 200, as GraphQL clients expect.
+func graphQLHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req GraphQLRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	json.NewEncoder(w).Encode(executeGraphQL(r.Context(), req))
+}
+
+// graphQLWSProtocol is the WebSocket subprotocol subscriptions speak
+const graphQLWSProtocol = "graphql-transport-ws"
+
+// graphQLUpgrader accepts subscription connections from any origin, like
+// depthUpgrader, and agrees on the graphql-transport-ws subprotocol
+var graphQLUpgrader = websocket.Upgrader{
+	CheckOrigin:  func(r *http.Request) bool { return true },
+	Subprotocols: []string{graphQLWSProtocol},
+}
+
+// GraphQLWSMessage is one graphql-transport-ws message
+type GraphQLWSMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// graphQLConnection is one subscription WebSocket and its running operations
+type graphQLConnection struct {
+	r    *http.Request
+	conn *websocket.Conn
+
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	running map[string]chan struct{}
+	wg      sync.WaitGroup
+}
+
+// send writes a message, with payload marshalled, from any goroutine
+func (c *graphQLConnection) send(id, kind string, payload interface{}) error {
+	msg := GraphQLWSMessage{ID: id, Type: kind}
+	if payload != nil {
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		msg.Payload = raw
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.conn.WriteJSON(msg)
+}
+
+// closeWith ends the connection with a graphql-transport-ws close code
+func (c *graphQLConnection) closeWith(code int, reason string) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+}
+
+// graphQLSubscriptionHandler runs GraphQL subscriptions, and queries, over a
+// WebSocket using the graphql-transport-ws protocol
+func graphQLSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	conn, err := graphQLUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already written an error response
+		return
+	}
+	defer conn.Close()
+
+	c := &graphQLConnection{r: r, conn: conn, running: make(map[string]chan struct{})}
+	defer c.wg.Wait()
+	defer c.stopAll()
+
+	initialized := false
+	for {
+		var msg GraphQLWSMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			return
+		}
+
+		switch msg.Type {
+		case "connection_init":
+			if initialized {
+				c.closeWith(4429, "Too many initialisation requests")
+				return
+			}
+			initialized = true
+			c.send("", "connection_ack", nil)
+		case "ping":
+			c.send("", "pong", nil)
+		case "pong":
+		case "subscribe":
+			if !initialized {
+				c.closeWith(4401, "Unauthorized")
+				return
+			}
+			var req GraphQLRequest
+			if err := json.Unmarshal(msg.Payload, &req); err != nil || msg.ID == "" {
+				c.closeWith(4400, "Invalid subscribe message")
+				return
+			}
+			if !c.start(msg.ID, req) {
+				c.closeWith(4409, "Subscriber for "+msg.ID+" already exists")
+				return
+			}
+		case "complete":
+			c.stop(msg.ID)
+		default:
+			c.closeWith(4400, "Unknown message type "+strconv.Quote(msg.Type))
+			return
+		}
+	}
+}
+
+// start runs an operation under id, reporting false if id is already running.
+// Queries are answered at once; a subscription streams until it is completed,
+// the client goes away or an operator kicks it.
+func (c *graphQLConnection) start(id string, req GraphQLRequest) bool {
+	c.mu.Lock()
+	if _, ok := c.running[id]; ok {
+		c.mu.Unlock()
+		return false
+	}
+	stop := make(chan struct{})
+	c.running[id] = stop
+	c.mu.Unlock()
+
+	execution, op, err := newGraphQLExecution(c.r.Context(), req)
+	if err == nil && op.kind != "subscription" {
+		response := executeGraphQL(c.r.Context(), req)
+		c.send(id, "next", response)
+		c.finish(id, true)
+		return true
+	}
+
+	var stream *graphQLStream
+	if err == nil {
+		stream, err = execution.subscribe(op)
+	}
+	if err != nil {
+		c.send(id, "error", []GraphQLError{graphQLErrorFrom(err)})
+		c.finish(id, false)
+		return true
+	}
+
+	c.wg.Add(1)
+	go c.stream(id, stop, execution, stream)
+	return true
+}
+
+// graphQLErrorFrom wraps an error, with its code if it has one
+func graphQLErrorFrom(err error) GraphQLError {
+	graphQLErr := GraphQLError{Message: err.Error()}
+	if queryErr, ok := err.(graphQLQueryError); ok {
+		graphQLErr.Extensions = &GraphQLErrorExtensions{Code: queryErr.code}
+	}
+	return graphQLErr
+}
+
+// finish forgets a finished operation, first telling the client if asked
+func (c *graphQLConnection) finish(id string, complete bool) {
+	c.mu.Lock()
+	delete(c.running, id)
+	c.mu.Unlock()
+	if complete {
+		c.send(id, "complete", nil)
+	}
+}
+
+// stop ends the running operation under id at the client's request
+func (c *graphQLConnection) stop(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if stop, ok := c.running[id]; ok {
+		close(stop)
+		delete(c.running, id)
+	}
+}
+
+// stopAll ends every running operation once the connection has gone
+func (c *graphQLConnection) stopAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, stop := range c.running {
+		close(stop)
+		delete(c.running, id)
+	}
+}
+
+// graphQLStream is a started subscription field
+type graphQLStream struct {
+	selection  graphQLSelection
+	subscriber *feedSubscriber
+	pick       func(FeedMessage) (interface{}, bool)
+}
+
+// subscribe resolves a subscription operation's single root field
+func (e *graphQLExecution) subscribe(op *graphQLOperation) (*graphQLStream, error) {
+	selections, fields, args, err := e.validate(op, graphQLSubscriptions(), "Subscription")
+	if err != nil {
+		return nil, err
+	}
+	if len(selections) != 1 || selections[0].name == "__typename" {
+		return nil, fmt.Errorf("a subscription must select exactly one field")
+	}
+	if err := e.entitled(fields[0]); err != nil {
+		return nil, err
+	}
+
+	selection := selections[0]
+	subscriber, pick, err := fields[0].subscribe(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return &graphQLStream{selection: selection, subscriber: subscriber, pick: pick}, nil
+}
+
+// stream forwards a subscription's values until it is stopped or kicked
+func (c *graphQLConnection) stream(id string, stop chan struct{}, execution *graphQLExecution, stream *graphQLStream) {
+	defer c.wg.Done()
+
+	subscriber := stream.subscriber
+	subscriber.messages = make(chan FeedMessage, feedBufferSize)
+	subscriber.session = openSession(SessionGraphQL, c.r, []string{"graphql:" + stream.selection.name + ":" + subscriber.symbol}, feedBufferSize,
+		func() int { return len(subscriber.messages) })
+	subscriber.backlog = newBacklog(subscriber.session, streamConfig.Conflate)
+	feed.subscribe(subscriber)
+	defer feed.unsubscribe(subscriber)
+	// Deferred last so the session leaves the list before its subscription does
+	defer subscriber.session.close()
+
+	write := func(msg FeedMessage) error {
+		value, ok := stream.pick(msg)
+		if !ok {
+			return nil
+		}
+		data := graphQLObject{{stream.selection.key(), execution.project(reflect.ValueOf(value), stream.selection)}}
+		if err := c.send(id, "next", GraphQLResponse{Data: data}); err != nil {
+			return err
+		}
+		subscriber.session.sent.Add(1)
+		return nil
+	}
+	for {
+		select {
+		case msg := <-subscriber.messages:
+			if write(msg) != nil {
+				return
+			}
+		case <-subscriber.backlog.ready:
+			if flushBacklog(subscriber.backlog, subscriber.messages, write, depthMessage) != nil {
+				return
+			}
+		case <-subscriber.session.kicked:
+			c.writeMu.Lock()
+			subscriber.session.writeKicked(c.conn)
+			c.writeMu.Unlock()
+			c.conn.Close()
+			return
+		case <-stop:
+			return
+		}
+		if len(subscriber.messages) == 0 {
+			subscriber.backlog.caughtUp()
+		}
+	}
+}
+
+// graphQLSchema renders the schema in the GraphQL schema language from the
+// root fields and the Go types they resolve to
+func graphQLSchema() string {
+	s := &graphQLSchemaWriter{types: make(map[string]string)}
+	var b strings.Builder
+	b.WriteString("scalar Time\n\n\"Any JSON value, such as a map of balances\"\nscalar JSON\n\n")
+	for _, root := range []struct {
+		name   string
+		fields []graphQLField
+	}{{"Query", graphQLQueries()}, {"Subscription", graphQLSubscriptions()}} {
+		fmt.Fprintf(&b, "type %s {\n", root.name)
+		for _, field := range root.fields {
+			fmt.Fprintf(&b, "  %q\n  %s", field.description, field.name)
+			if len(field.args) > 0 {
+				args := make([]string, len(field.args))
+				for i, arg := range field.args {
+					args[i] = arg.name + ": " + s.typeRef(arg.kind, !arg.required)
+				}
+				b.WriteString("(" + strings.Join(args, ", ") + ")")
+			}
+			b.WriteString(": " + s.typeRef(field.result, false) + "\n")
+		}
+		b.WriteString("}\n\n")
+	}
+
+	names := make([]string, 0, len(s.types))
+	for name := range s.types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b.WriteString(s.types[name] + "\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// graphQLSchemaWriter collects the definitions of the types a schema uses
+type graphQLSchemaWriter struct {
+	types map[string]string
+}
+
+// graphQLTypeName is the GraphQL name of a scalar or enumeration kind
+func graphQLTypeName(t reflect.Type) string {
+	if _, ok := apiEnums[t]; ok {
+		return t.Name()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "String"
+	case reflect.Bool:
+		return "Boolean"
+	case reflect.Float32, reflect.Float64:
+		return "Float"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "Int"
+	}
+	return "JSON"
+}
+
+// typeRef names t in the schema, defining the objects and enumerations it
+// refers to. Lists are never null; pointers and maps may be.
+func (s *graphQLSchemaWriter) typeRef(t reflect.Type, nullable bool) string {
+	suffix := "!"
+	if nullable {
+		suffix = ""
+	}
+	switch {
+	case t == timeType:
+		return "Time" + suffix
+	case t == durationType:
+		return "Int" + suffix
+	case t.Kind() == reflect.Pointer:
+		return s.typeRef(t.Elem(), true)
+	case t.Kind() == reflect.Map || t.Kind() == reflect.Interface:
+		return "JSON"
+	case t.Kind() == reflect.Slice:
+		return "[" + s.typeRef(t.Elem(), false) + "]!"
+	case t.Kind() == reflect.Struct:
+		s.define(t)
+		return t.Name() + suffix
+	}
+	if values, ok := apiEnums[t]; ok {
+		if _, defined := s.types[t.Name()]; !defined {
+			s.types[t.Name()] = "enum " + t.Name() + " {\n  " + strings.Join(values, "\n  ") + "\n}\n"
+		}
+	}
+	return graphQLTypeName(t) + suffix
+}
+
+// define writes an object type for a struct, by its fields' json names.
+// Enumerations left unset read as null, so optional ones are nullable.
+func (s *graphQLSchemaWriter) define(t reflect.Type) {
+	if _, ok := s.types[t.Name()]; ok {
+		return
+	}
+	// Claim the name first so a type that refers to itself terminates
+	s.types[t.Name()] = ""
+
+	fields := graphQLFieldsOf(t)
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return slices.Compare(fields[names[i]].Index, fields[names[j]].Index) < 0
+	})
+
+	var b strings.Builder
+	b.WriteString("type " + t.Name() + " {\n")
+	for _, name := range names {
+		field := fields[name]
+		_, enum := apiEnums[field.Type]
+		optional := enum && strings.Contains(field.Tag.Get("json"), "omitempty")
+		b.WriteString("  " + name + ": " + s.typeRef(field.Type, optional) + "\n")
+	}
+	s.types[t.Name()] = b.String() + "}\n"
+}
+
+// graphQLSchemaHandler serves the schema, for clients that generate code or
+// validate queries from it
+func graphQLSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(graphQLSchema()))
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// postGraphQL sends a query with an optional API key and returns the raw response
+func postGraphQL(t *testing.T, cfg HTTPConfig, key string, req GraphQLRequest) string {
+	t.Helper()
+	body, _ := json.Marshal(req)
+	request := httptest.NewRequest("POST", "/api/v1/graphql", bytes.NewReader(body))
+	if key != "" {
+		request.Header.Set("X-API-Key", key)
+	}
+	response := serve(cfg, request)
+	if response.Code != 200 {
+		t.Fatalf("Expected 200, got %d: %s", response.Code, response.Body.String())
+	}
+	return strings.TrimSpace(response.Body.String())
+}
+
+func TestGraphQL_SelectsRequestedFields(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "ask-1", Symbol: "DEFAULT", Side: SideSell, Price: 101.0, Quantity: 3})
+	placeOn(m, Order{ID: "ask-2", Symbol: "DEFAULT", Side: SideSell, Price: 100.0, Quantity: 2})
+	placeOn(m, Order{ID: "buy-1", Symbol: "DEFAULT", Side: SideBuy, Price: 100.0, Quantity: 1})
+	openFunded(t, "alice", map[string]float64{"USD": 50})
+
+	got := postGraphQL(t, HTTPConfig{}, "", GraphQLRequest{
+		Query: `query Dashboard($side: Side!, $levels: Int = 1) {
+			book: depth(levels: $levels) { sequence asks { ...level } }
+			trades(aggressor_side: $side) { price quantity maker_id }
+			resting: orders { id @include(if: false) quantity }
+			account(id: "alice") { id balances }
+			missing: order(id: "nope") { id }
+		}
+		fragment level on PriceLevel { price quantity }`,
+		Variables: map[string]interface{}{"side": "buy"},
+	})
+	want := `{"data":{"book":{"sequence":3,"asks":[{"price":100,"quantity":1}]},` +
+		`"trades":[{"price":100,"quantity":1,"maker_id":"ask-2"}],` +
+		`"resting":[{"quantity":1},{"quantity":3}],` +
+		`"account":{"id":"alice","balances":{"USD":50}},"missing":null}}`
+	if got != want {
+		t.Errorf("Unexpected response\n got: %s\nwant: %s", got, want)
+	}
+}
+
+func TestGraphQL_Errors(t *testing.T) {
+	setupTest()
+	cases := []struct {
+		query string
+		want  string
+	}{
+		{`{ orders { id nope } }`, `cannot query field \"nope\" on type Order`},
+		{`{ depth(symbol: "XYZ") { sequence } }`, `"code":"UNKNOWN_SYMBOL"`},
+		{`{ trades(limit: "ten") { id } }`, `argument \"limit\" of field \"trades\" must be Int`},
+		{`{ orders }`, `must have a selection of subfields`},
+		{`{ orders { id }`, `syntax error`},
+		{`{ __schema { types { name } } }`, `introspection is not supported`},
+		{`mutation { cancel }`, `mutations are not supported`},
+		{`subscription { trades { id } }`, `subscriptions need a WebSocket`},
+	}
+	for _, tc := range cases {
+		if got := postGraphQL(t, HTTPConfig{}, "", GraphQLRequest{Query: tc.query}); !strings.Contains(got, tc.want) {
+			t.Errorf("%s: expected %s, got %s", tc.query, tc.want, got)
+		}
+	}
+
+	// Other fields still resolve beside one that failed
+	got := postGraphQL(t, HTTPConfig{}, "", GraphQLRequest{Query: `{ symbols  depth(symbol: "XYZ") { sequence } }`})
+	if !strings.HasPrefix(got, `{"data":{"symbols":["DEFAULT"],"depth":null},"errors":[{"message"`) || !strings.Contains(got, `"path":["depth"]`) {
+		t.Errorf("Expected a partial result, got %s", got)
+	}
+}
+
+func TestGraphQL_Entitlements(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "ask-1", Symbol: "DEFAULT", Side: SideSell, Price: 100.0, Quantity: 1})
+	placeOn(m, Order{ID: "ask-2", Symbol: "DEFAULT", Side: SideSell, Price: 101.0, Quantity: 1})
+	placeOn(m, Order{ID: "buy-1", Symbol: "DEFAULT", Side: SideBuy, Price: 100.0, Quantity: 1})
+
+	got := postGraphQL(t, tiered, "top-key", GraphQLRequest{Query: `{ depth { asks { price } } trades { maker_id } orders { id } }`})
+	want := `{"data":{"depth":{"asks":[{"price":101}]},"trades":[{"maker_id":""}],"orders":null},` +
+		`"errors":[{"message":"field \"orders\" needs the l3 entitlement; the API key has top","path":["orders"],"extensions":{"code":"NOT_ENTITLED"}}]}`
+	if got != want {
+		t.Errorf("Unexpected response\n got: %s\nwant: %s", got, want)
+	}
+}
+
+func TestGraphQL_Subscriptions(t *testing.T) {
+	setupTest()
+	server := httptest.NewServer(newServer(HTTPConfig{}))
+	defer server.Close()
+	dialer := websocket.Dialer{Subprotocols: []string{graphQLWSProtocol}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/api/v1/graphql", nil)
+	if err != nil {
+		t.Fatalf("Expected to connect, got %v", err)
+	}
+	defer conn.Close()
+	if conn.Subprotocol() != graphQLWSProtocol {
+		t.Errorf("Expected the %s subprotocol, got %q", graphQLWSProtocol, conn.Subprotocol())
+	}
+
+	read := func() GraphQLWSMessage {
+		t.Helper()
+		var msg GraphQLWSMessage
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("Expected a message, got %v", err)
+		}
+		return msg
+	}
+	conn.WriteJSON(GraphQLWSMessage{Type: "connection_init"})
+	if msg := read(); msg.Type != "connection_ack" {
+		t.Fatalf("Expected the connection to be acknowledged, got %+v", msg)
+	}
+
+	payload, _ := json.Marshal(GraphQLRequest{Query: `subscription { trades { price quantity aggressor_side } }`})
+	conn.WriteJSON(GraphQLWSMessage{ID: "t1", Type: "subscribe", Payload: payload})
+	payload, _ = json.Marshal(GraphQLRequest{Query: `subscription { trades(symbol: "XYZ") { price } }`})
+	conn.WriteJSON(GraphQLWSMessage{ID: "bad", Type: "subscribe", Payload: payload})
+	if msg := read(); msg.ID != "bad" || msg.Type != "error" || !strings.Contains(string(msg.Payload), "UNKNOWN_SYMBOL") {
+		t.Errorf("Expected the unknown symbol to be refused, got %+v", msg)
+	}
+	waitForFeeds(t, 1)
+	if session := sessionSnapshots()[0]; session.Kind != SessionGraphQL || session.Subscriptions[0] != "graphql:trades:DEFAULT" {
+		t.Errorf("Expected a GraphQL session, got %+v", session)
+	}
+
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "ask-1", Symbol: "DEFAULT", Side: SideSell, Price: 100.0, Quantity: 2})
+	placeOn(m, Order{ID: "buy-1", Symbol: "DEFAULT", Side: SideBuy, Price: 100.0, Quantity: 2})
+	if msg := read(); msg.ID != "t1" || msg.Type != "next" || string(msg.Payload) != `{"data":{"trades":{"price":100,"quantity":2,"aggressor_side":"buy"}}}` {
+		t.Errorf("Expected the trade, got %s %s", msg.Type, msg.Payload)
+	}
+
+	conn.WriteJSON(GraphQLWSMessage{ID: "t1", Type: "complete"})
+	waitForFeeds(t, 0)
+}
+
+func TestGraphQL_Schema(t *testing.T) {
+	response := serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/graphql/schema", nil))
+	schema := response.Body.String()
+	for _, want := range []string{
+		"type Query {",
+		"  orders(symbol: String): [Order!]!\n",
+		"  order(id: String!): Order\n",
+		"  trades(symbol: String, aggressor_side: Side, limit: Int): [Trade!]!\n",
+		"type Subscription {",
+		"  book(symbol: String): DepthUpdate!\n",
+		"enum Side {\n  buy\n  sell\n}",
+		"  expires_at: Time\n",
+		"  tick_direction: TickDirection\n",
+		"  balances: JSON\n",
+	} {
+		if !strings.Contains(schema, want) {
+			t.Errorf("Expected the schema to contain %q", want)
+		}
+	}
+}
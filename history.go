@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// ClosedOrder is an order in the state it left the book in: filled,
+// cancelled, rejected or expired
+type ClosedOrder struct {
+	Order
+	ClosedAt time.Time `json:"closed_at"`
+}
+
+// OrderHistoryResponse lists closed orders in the order they closed
+type OrderHistoryResponse struct {
+	Orders []ClosedOrder `json:"orders"`
+	Count  int           `json:"count"`
+}
+
+// OrderHistoryFilter picks closed orders; empty fields match every order
+type OrderHistoryFilter struct {
+	Symbol string
+	Owner  string
+	Status OrderStatus
+	// Closed filters on when the order closed
+	Closed timeRange
+}
+
+// matches reports whether a closed order passes the filter
+func (f OrderHistoryFilter) matches(order ClosedOrder) bool {
+	return (f.Symbol == "" || order.Symbol == f.Symbol) &&
+		(f.Owner == "" || order.Owner == f.Owner) &&
+		(f.Status == "" || order.Status == f.Status) &&
+		f.Closed.includes(order.ClosedAt)
+}
+
+// terminalStatuses are the statuses an order can close with
+var terminalStatuses = []string{
+	string(OrderStatusFilled), string(OrderStatusCancelled),
+	string(OrderStatusRejected), string(OrderStatusExpired),
+}
+
+// getOrderHistoryHandler returns the orders that have closed, optionally for
+// one symbol, owner or status and closed within a time range
+func getOrderHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	values := r.URL.Query()
+	closed, validationErrors := parseTimeRange(values)
+	filter := OrderHistoryFilter{
+		Symbol: values.Get("symbol"),
+		Owner:  values.Get("owner"),
+		Status: OrderStatus(values.Get("status")),
+		Closed: closed,
+	}
+	if filter.Status != "" && !slices.Contains(terminalStatuses, string(filter.Status)) {
+		validationErrors = append(validationErrors, "status must be 'filled', 'cancelled', 'rejected' or 'expired' (received: '"+string(filter.Status)+"')")
+	}
+	if len(validationErrors) > 0 {
+		writeError(w, http.StatusBadRequest, ErrCodeValidationFailed, "Validation failed", validationErrors)
+		return
+	}
+
+	orders := closedStore.List(filter)
+	json.NewEncoder(w).Encode(OrderHistoryResponse{
+		Orders: orders,
+		Count:  len(orders),
+	})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// getHistory fetches the order history with a query string
+func getHistory(t *testing.T, query string) OrderHistoryResponse {
+	t.Helper()
+	response := serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/orders/history"+query, nil))
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected the history, got %d: %s", response.Code, response.Body.String())
+	}
+	var result OrderHistoryResponse
+	json.NewDecoder(response.Body).Decode(&result)
+	return result
+}
+
+func TestOrderHistory_KeepsClosedOrders(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "ask-1", Side: SideSell, Price: 100.0, Quantity: 5, Owner: "maker"})
+	placeOn(m, Order{ID: "buy-1", Side: SideBuy, Price: 100.0, Quantity: 3, Owner: "taker"})
+	placeOn(m, Order{ID: "buy-2", Side: SideBuy, Price: 100.0, Quantity: 4, Owner: "taker", TimeInForce: TimeInForceIOC})
+	placeOn(m, Order{ID: "bid-1", Side: SideBuy, Price: 99.0, Quantity: 1, Owner: "maker"})
+	m.do(func() { cancelOrder("", "bid-1") })
+
+	// ask-1 fills across two takers, and the IOC remainder of buy-2 is cancelled
+	all := getHistory(t, "")
+	want := []struct {
+		id     string
+		status OrderStatus
+		left   int
+		filled int
+	}{{"buy-1", OrderStatusFilled, 0, 3}, {"ask-1", OrderStatusFilled, 0, 5}, {"buy-2", OrderStatusCancelled, 2, 2}, {"bid-1", OrderStatusCancelled, 1, 0}}
+	if all.Count != len(want) {
+		t.Fatalf("Expected %d closed orders, got %+v", len(want), all)
+	}
+	for i, order := range all.Orders {
+		if order.ID != want[i].id || order.Status != want[i].status || order.Quantity != want[i].left || order.FilledQuantity != want[i].filled {
+			t.Errorf("Order %d: expected %+v, got %+v", i, want[i], order.Order)
+		}
+		if order.ClosedAt.IsZero() {
+			t.Errorf("Expected %s to record when it closed", order.ID)
+		}
+	}
+
+	if mine := getHistory(t, "?owner=maker&status=cancelled"); mine.Count != 1 || mine.Orders[0].ID != "bid-1" {
+		t.Errorf("Expected the maker's cancelled bid, got %+v", mine)
+	}
+	if resting := collectOrders(""); len(resting) != 0 {
+		t.Errorf("Expected closed orders to have left the book, got %+v", resting)
+	}
+}
+
+func TestOrderHistory_FiltersByCloseTime(t *testing.T) {
+	setupTest()
+	start := time.Date(2024, 1, 1, 9, 30, 0, 0, time.UTC)
+	clock := useDeterministicEngine(t, start)
+	processOrder(Order{ID: "early", Side: SideSell, Price: 100.0, Quantity: 1, Status: OrderStatusPending, CreatedAt: clock.Now(), TimeInForce: TimeInForceIOC})
+	clock.Advance(time.Hour)
+	processOrder(Order{ID: "late", Side: SideSell, Price: 100.0, Quantity: 1, Status: OrderStatusPending, CreatedAt: clock.Now(), TimeInForce: TimeInForceIOC})
+
+	if history := getHistory(t, "?from=2024-01-01T10:00:00Z"); history.Count != 1 || history.Orders[0].ID != "late" {
+		t.Errorf("Expected only the order closed after 10:00, got %+v", history)
+	}
+	if history := getHistory(t, "?to=2024-01-01T10:00:00Z&status=rejected"); history.Count != 1 || history.Orders[0].ID != "early" {
+		t.Errorf("Expected the earlier IOC to have been rejected, got %+v", history)
+	}
+
+	for _, query := range []string{"?status=pending", "?status=bogus", "?from=yesterday", "?from=2024-01-02T00:00:00Z&to=2024-01-01T00:00:00Z"} {
+		response := serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/orders/history"+query, nil))
+		if result := decodeError(t, response); response.Code != http.StatusBadRequest || result.Error.Code != ErrCodeValidationFailed {
+			t.Errorf("%s: expected a validation failure, got %d %s", query, response.Code, result.Error.Code)
+		}
+	}
+}
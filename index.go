@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IndexSource fetches a symbol's index price from outside the engine
+type IndexSource interface {
+	FetchIndex(ctx context.Context) (float64, error)
+}
+
+// IndexSourceFunc adapts an ordinary function to the IndexSource interface
+type IndexSourceFunc func(ctx context.Context) (float64, error)
+
+func (f IndexSourceFunc) FetchIndex(ctx context.Context) (float64, error) {
+	return f(ctx)
+}
+
+// IndexConfig controls the pollers that pull index prices over HTTP
+type IndexConfig struct {
+	// URLs maps each polled symbol to the URL its index price is read from
+	URLs map[string]string
+	// Field is the dotted path to the price in each response, such as
+	// "data.amount" or "result.0.price"
+	Field    string
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+// HTTPIndexSource reads an index price from a JSON document served over HTTP.
+// The price may be a JSON number or a string holding one, as many price APIs
+// send it.
+type HTTPIndexSource struct {
+	URL    string
+	Field  string
+	Client *http.Client
+}
+
+// NewHTTPIndexSource creates a source that gets url, reads the price at field
+// and gives up after timeout
+func NewHTTPIndexSource(url, field string, timeout time.Duration) *HTTPIndexSource {
+	return &HTTPIndexSource{URL: url, Field: field, Client: &http.Client{Timeout: timeout}}
+}
+
+func (s *HTTPIndexSource) FetchIndex(ctx context.Context) (float64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("index source returned %s", resp.Status)
+	}
+
+	var document interface{}
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil {
+		return 0, fmt.Errorf("decoding index source response: %w", err)
+	}
+	return priceAt(document, s.Field)
+}
+
+// priceAt follows a dotted path of object keys and array indexes through a
+// decoded JSON document to a positive price
+func priceAt(document interface{}, path string) (float64, error) {
+	value := document
+	for _, key := range strings.Split(path, ".") {
+		switch node := value.(type) {
+		case map[string]interface{}:
+			value = node[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return 0, fmt.Errorf("%q has no element %q", path, key)
+			}
+			value = node[i]
+		default:
+			return 0, fmt.Errorf("%q has no field %q", path, key)
+		}
+	}
+
+	var raw string
+	switch price := value.(type) {
+	case json.Number:
+		raw = price.String()
+	case float64:
+		raw = strconv.FormatFloat(price, 'f', -1, 64)
+	case string:
+		raw = price
+	default:
+		return 0, fmt.Errorf("%q is not a price", path)
+	}
+	price, err := strconv.ParseFloat(raw, 64)
+	if err != nil || price <= 0 {
+		return 0, fmt.Errorf("%q is not a positive price (received: %q)", path, raw)
+	}
+	return price, nil
+}
+
+// startIndexPollers polls each configured index source on its own goroutine
+// until ctx is done
+func startIndexPollers(ctx context.Context, cfg IndexConfig) {
+	for symbol, rawURL := range cfg.URLs {
+		m, ok := matcherFor(symbol)
+		if !ok {
+			log.Printf("index: unknown symbol %q, poller not started", symbol)
+			continue
+		}
+		source := NewHTTPIndexSource(rawURL, cfg.Field, cfg.Timeout)
+		go runIndexPoller(ctx, m, indexSourceName(rawURL), source, cfg.Interval)
+		log.Printf("index: polling %s for %s every %s", indexSourceName(rawURL), m.symbol, cfg.Interval)
+	}
+}
+
+// runIndexPoller fetches the symbol's index price on every tick
+func runIndexPoller(ctx context.Context, m *matcher, name string, source IndexSource, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		pollIndex(ctx, m, name, source)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pollIndex fetches one index price and feeds it to the symbol's mark. A
+// failed fetch keeps the last price. A standby takes its index from the
+// primary, so it does not poll.
+func pollIndex(ctx context.Context, m *matcher, name string, source IndexSource) {
+	if standby.running.Load() {
+		return
+	}
+	price, err := source.FetchIndex(ctx)
+	if err != nil {
+		log.Printf("index: %s keeps its last index price: %v", m.symbol, err)
+		return
+	}
+	setIndexPrice(m, price, name)
+}
+
+// setIndexPrice records a symbol's new index price and where it came from.
+// The mark, and any stops following it, move as the command ends.
+func setIndexPrice(m *matcher, price float64, source string) {
+	m.do(func() {
+		now := engineClock.Now()
+		inputs := &m.book.mark.inputs
+		inputs.Index, inputs.IndexAt, inputs.IndexSource = price, &now, source
+	})
+}
+
+// indexSourceName names a polled source by its host, keeping credentials and
+// query strings out of responses and logs
+func indexSourceName(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return "poller"
+}
+
+// parseIndexURLs reads symbol=url pairs, such as BTC-USD=https://example.com/btc
+func parseIndexURLs(list string) (map[string]string, error) {
+	urls := make(map[string]string)
+	for _, item := range splitList(list) {
+		symbol, rawURL, ok := strings.Cut(item, "=")
+		rawURL = strings.TrimSpace(rawURL)
+		u, err := url.Parse(rawURL)
+		if !ok || strings.TrimSpace(symbol) == "" || err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("-index-urls entry %q must be symbol=url with an http or https URL", item)
+		}
+		urls[strings.ToUpper(strings.TrimSpace(symbol))] = rawURL
+	}
+	return urls, nil
+}
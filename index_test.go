@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPriceAt(t *testing.T) {
+	var document interface{}
+	json.Unmarshal([]byte(`{"price": 101.5, "data": {"amount": "99.25"}, "result": [{"price": 7}], "bad": "n/a", "zero": 0}`), &document)
+
+	cases := []struct {
+		path  string
+		price float64
+	}{
+		{"price", 101.5},
+		{"data.amount", 99.25},
+		{"result.0.price", 7},
+		{"result.1.price", 0},
+		{"data", 0},
+		{"missing", 0},
+		{"bad", 0},
+		{"zero", 0},
+	}
+	for _, tc := range cases {
+		price, err := priceAt(document, tc.path)
+		if price != tc.price || (err == nil) != (tc.price > 0) {
+			t.Errorf("%s: expected %v, got %v (%v)", tc.path, tc.price, price, err)
+		}
+	}
+}
+
+func TestHTTPIndexSource(t *testing.T) {
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(`{"data": {"base": "BTC", "amount": "64250.10"}}`))
+	}))
+	defer server.Close()
+
+	source := NewHTTPIndexSource(server.URL, "data.amount", time.Second)
+	if price, err := source.FetchIndex(context.Background()); err != nil || price != 64250.10 {
+		t.Errorf("Expected 64250.10, got %v (%v)", price, err)
+	}
+	status = http.StatusServiceUnavailable
+	if _, err := source.FetchIndex(context.Background()); err == nil {
+		t.Error("Expected an error from a failing source")
+	}
+}
+
+func TestPollIndex_FeedsTheMark(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+	configureMarks(map[string]MarkSource{"DEFAULT": MarkIndex})
+
+	price, err := 100.0, error(nil)
+	source := IndexSourceFunc(func(ctx context.Context) (float64, error) { return price, err })
+	pollIndex(context.Background(), m, "prices.example", source)
+	if mark := markOf(m); mark.MarkPrice != 100.0 || mark.IndexSource != "prices.example" {
+		t.Errorf("Expected the polled index as the mark, got %+v", mark)
+	}
+
+	// A failed poll keeps the last index price
+	price, err = 0, errors.New("timeout")
+	pollIndex(context.Background(), m, "prices.example", source)
+	if mark := markOf(m); mark.MarkPrice != 100.0 {
+		t.Errorf("Expected the last index price to stay, got %+v", mark)
+	}
+
+	// Pushes name their sender
+	if mark := pushIndex(t, 101.0); mark.IndexSource != "push" {
+		t.Errorf("Expected a push to be named push, got %+v", mark)
+	}
+}
+
+func TestLoadConfig_IndexURLs(t *testing.T) {
+	cfg, err := loadConfig([]string{"-symbols", "BTC-USD", "-index-urls", "btc-usd=https://prices.example/btc?currency=USD", "-index-field", "data.amount"})
+	if err != nil || cfg.Index.URLs["BTC-USD"] != "https://prices.example/btc?currency=USD" || cfg.Index.Field != "data.amount" || cfg.Index.Interval != 5*time.Second {
+		t.Errorf("Unexpected index config %+v (%v)", cfg.Index, err)
+	}
+	if name := indexSourceName(cfg.Index.URLs["BTC-USD"]); name != "prices.example" {
+		t.Errorf("Expected the source to be named by its host, got %q", name)
+	}
+	for _, args := range [][]string{
+		{"-index-urls", "DEFAULT=prices.example/btc"},
+		{"-index-urls", "SOL-USD=https://prices.example/sol"},
+		{"-index-interval", "0s"},
+	} {
+		if _, err := loadConfig(args); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
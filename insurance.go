@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// liquidationFeeBps is charged on the notional of each liquidation fill and
+// paid into the insurance fund
+var liquidationFeeBps float64
+
+// ADLEntry is one position's place in its side's auto-deleveraging queue
+type ADLEntry struct {
+	// Rank is 1 for the position deleveraged first
+	Rank      int    `json:"rank"`
+	AccountID string `json:"account_id"`
+	// Quantity is signed: positive is long and negative short
+	Quantity      int     `json:"quantity"`
+	EntryPrice    float64 `json:"entry_price"`
+	MarkPrice     float64 `json:"mark_price"`
+	UnrealizedPnL float64 `json:"unrealized_pnl"`
+	Leverage      float64 `json:"leverage"`
+	// Score is the unrealized PnL as a fraction of the entry value, multiplied
+	// by the leverage when it is a profit and divided by it when a loss
+	Score float64 `json:"score"`
+}
+
+// ADLFill is the part of a deleveraging taken by one opposing position
+type ADLFill struct {
+	AccountID string  `json:"account_id"`
+	Quantity  int     `json:"quantity"`
+	Score     float64 `json:"score"`
+}
+
+// ADLEvent is a position the book could not absorb, closed against the top of
+// the opposing queue at its bankruptcy price
+type ADLEvent struct {
+	ID        string `json:"id"`
+	Symbol    string `json:"symbol"`
+	AccountID string `json:"account_id"`
+	// Price is the liquidated account's bankruptcy price, where its equity
+	// in the settlement asset would be zero
+	Price    float64   `json:"price"`
+	Quantity int       `json:"quantity"`
+	Fills    []ADLFill `json:"fills"`
+	At       time.Time `json:"at"`
+}
+
+// ADLQueueResponse ranks a perpetual's positions on each side for
+// deleveraging, with its past deleveraging events
+type ADLQueueResponse struct {
+	Symbol string     `json:"symbol"`
+	Longs  []ADLEntry `json:"longs"`
+	Shorts []ADLEntry `json:"shorts"`
+	Events []ADLEvent `json:"events"`
+}
+
+// InsuranceFundResponse is the insurance fund's balances and what moved them
+type InsuranceFundResponse struct {
+	Balances map[string]float64 `json:"balances"`
+	Changes  []BalanceChange    `json:"changes"`
+	Count    int                `json:"count"`
+}
+
+// adlEvents is guarded by accountsMu
+var adlEvents []ADLEvent
+
+// bankruptcyPriceLocked is the price at which an account's position in a
+// symbol would leave it no equity in the settlement asset. It must be called
+// with accountsMu held.
+func bankruptcyPriceLocked(owner, symbol string) float64 {
+	position := positions[owner][symbol]
+	if position == nil || position.Quantity == 0 {
+		return 0
+	}
+	assets, _ := marginLocked(owner)
+	asset := perpetualAsset(symbol)
+	equity := ledgerBalances[owner][asset]
+	for _, held := range assets {
+		if held.Asset == asset {
+			equity = held.Equity
+		}
+	}
+	// The other positions' profit or loss counts towards this one's cushion
+	others := equity - float64(position.Quantity)*(markLocked(position)-position.EntryPrice)
+	price := position.EntryPrice - others/float64(position.Quantity)
+	return math.Max(price, 0)
+}
+
+// adlQueueLocked ranks the open positions in a symbol on each side, first to
+// be deleveraged first. It must be called with accountsMu held.
+func adlQueueLocked(symbol string) (longs, shorts []ADLEntry) {
+	longs, shorts = make([]ADLEntry, 0), make([]ADLEntry, 0)
+	for owner, held := range positions {
+		position := held[symbol]
+		if position == nil || position.Quantity == 0 {
+			continue
+		}
+		mark := markLocked(position)
+		entry := ADLEntry{
+			AccountID:     owner,
+			Quantity:      position.Quantity,
+			EntryPrice:    position.EntryPrice,
+			MarkPrice:     mark,
+			UnrealizedPnL: float64(position.Quantity) * (mark - position.EntryPrice),
+			Leverage:      leverageLocked(owner),
+		}
+		if value := math.Abs(float64(position.Quantity)) * position.EntryPrice; value > 0 {
+			entry.Score = entry.UnrealizedPnL / value
+		}
+		if entry.Score > 0 {
+			entry.Score *= entry.Leverage
+		} else {
+			entry.Score /= entry.Leverage
+		}
+		if position.Quantity > 0 {
+			longs = append(longs, entry)
+		} else {
+			shorts = append(shorts, entry)
+		}
+	}
+	for _, queue := range [][]ADLEntry{longs, shorts} {
+		sort.Slice(queue, func(i, j int) bool {
+			if queue[i].Score != queue[j].Score {
+				return queue[i].Score > queue[j].Score
+			}
+			return queue[i].AccountID < queue[j].AccountID
+		})
+		for i := range queue {
+			queue[i].Rank = i + 1
+		}
+	}
+	return longs, shorts
+}
+
+// deleverageLocked closes what is left of a liquidated position against the
+// top of the opposing queue at the bankruptcy price, if the insurance fund
+// has nothing left to cover a further loss. It returns the quantity closed.
+// It must be called with accountsMu held.
+func deleverageLocked(symbol, owner string, price float64) int {
+	position := positions[owner][symbol]
+	asset := perpetualAsset(symbol)
+	if position == nil || position.Quantity == 0 || ledgerBalances[insuranceAccount][asset] > 0 {
+		return 0
+	}
+
+	longs, shorts := adlQueueLocked(symbol)
+	opposing := shorts
+	if position.Quantity < 0 {
+		opposing = longs
+	}
+
+	event := ADLEvent{ID: generateOrderID(), Symbol: symbol, AccountID: owner, Price: price, Fills: make([]ADLFill, 0), At: engineClock.Now()}
+	for _, entry := range opposing {
+		remaining := abs(position.Quantity)
+		if remaining == 0 {
+			break
+		}
+		quantity := min(remaining, abs(entry.Quantity))
+		trade := Trade{ID: event.ID, Symbol: symbol, Price: price, Quantity: quantity}
+		if position.Quantity > 0 {
+			settlePerpetualLocked(trade, entry.AccountID, owner, BalanceADL)
+		} else {
+			settlePerpetualLocked(trade, owner, entry.AccountID, BalanceADL)
+		}
+		event.Quantity += quantity
+		event.Fills = append(event.Fills, ADLFill{AccountID: entry.AccountID, Quantity: quantity, Score: entry.Score})
+	}
+	if event.Quantity == 0 {
+		return 0
+	}
+	adlEvents = append(adlEvents, event)
+	log.Printf("margin: deleveraged %d of %s's %s at %.2f against %d positions", event.Quantity, owner, symbol, price, len(event.Fills))
+	return event.Quantity
+}
+
+// chargeLiquidationFeeLocked moves the liquidation fee on a liquidation's
+// fills from the account into the insurance fund and returns it. It must be
+// called with accountsMu held.
+func chargeLiquidationFeeLocked(owner, symbol string, fills []Trade) float64 {
+	var notional float64
+	for _, fill := range fills {
+		notional += fill.Price * float64(fill.Quantity)
+	}
+	fee := notional * liquidationFeeBps / 10000
+	if fee <= 0 || len(fills) == 0 {
+		return 0
+	}
+	asset := perpetualAsset(symbol)
+	postLocked(BalanceLiquidationFee, fills[0].TakerID, []posting{
+		{account: owner, asset: asset, amount: -fee},
+		{account: insuranceAccount, asset: asset, amount: fee},
+	})
+	return fee
+}
+
+// coverDeficitLocked pays what it can of an account's negative balance in a
+// perpetual's settlement asset from the insurance fund, once the account has
+// no positions left in it, and returns the amount paid. It must be called with
+// accountsMu held.
+func coverDeficitLocked(owner, symbol, reference string) float64 {
+	asset := perpetualAsset(symbol)
+	for held, position := range positions[owner] {
+		if position.Quantity != 0 && perpetualAsset(held) == asset {
+			return 0
+		}
+	}
+	deficit := -ledgerBalances[owner][asset]
+	paid := math.Min(deficit, ledgerBalances[insuranceAccount][asset])
+	if paid <= 0 {
+		return 0
+	}
+	postLocked(BalanceInsurance, reference, []posting{
+		{account: insuranceAccount, asset: asset, amount: -paid},
+		{account: owner, asset: asset, amount: paid},
+	})
+	return paid
+}
+
+// getInsuranceFundHandler returns the insurance fund's balances and history
+func getInsuranceFundHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	accountsMu.Lock()
+	response := InsuranceFundResponse{Balances: make(map[string]float64), Changes: make([]BalanceChange, 0)}
+	for asset, balance := range ledgerBalances[insuranceAccount] {
+		response.Balances[asset] = balance
+	}
+	for _, entry := range ledgerEntries {
+		if entry.Account == insuranceAccount {
+			response.Changes = append(response.Changes, entry.balanceChange())
+		}
+	}
+	accountsMu.Unlock()
+
+	response.Count = len(response.Changes)
+	json.NewEncoder(w).Encode(response)
+}
+
+// fundInsuranceHandler deposits into the insurance fund from outside the venue
+func fundInsuranceHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req BalanceRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	accountsMu.Lock()
+	entries := postLocked(BalanceDeposit, "", []posting{
+		{account: insuranceAccount, asset: req.Asset, amount: req.Amount},
+		{account: externalAccount, asset: req.Asset, amount: -req.Amount},
+	})
+	accountsMu.Unlock()
+	json.NewEncoder(w).Encode(entries[0].balanceChange())
+}
+
+// getADLQueueHandler ranks a perpetual's positions for auto-deleveraging
+func getADLQueueHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	m, ok := matcherFor(r.URL.Query().Get("symbol"))
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrCodeUnknownSymbol, "Unknown symbol",
+			"symbol '"+r.URL.Query().Get("symbol")+"' is not traded here")
+		return
+	}
+
+	accountsMu.Lock()
+	_, perpetual := perpetuals[m.symbol]
+	response := ADLQueueResponse{Symbol: m.symbol, Events: make([]ADLEvent, 0)}
+	if perpetual {
+		response.Longs, response.Shorts = adlQueueLocked(m.symbol)
+		for _, event := range adlEvents {
+			if event.Symbol == m.symbol {
+				response.Events = append(response.Events, event)
+			}
+		}
+	}
+	accountsMu.Unlock()
+
+	if !perpetual {
+		writeError(w, http.StatusBadRequest, ErrCodeNotPerpetual, "Not a perpetual",
+			"symbol '"+m.symbol+"' is not traded as a perpetual swap")
+		return
+	}
+	json.NewEncoder(w).Encode(response)
+}
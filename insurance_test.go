@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// openLong gives alice a 10 lot long at 100 on 100 USD, sold to her by bob,
+// with bids from dave at each price in bids
+func openLong(t *testing.T, bids map[float64]int) *matcher {
+	t.Helper()
+	resetSymbols([]string{"BTC-PERP"})
+	listPerpetual("BTC-PERP")
+	openFunded(t, "alice", map[string]float64{"USD": 100})
+	for _, id := range []string{"bob", "carol", "dave"} {
+		openFunded(t, id, map[string]float64{"USD": 10000})
+	}
+	m, _ := matcherFor("BTC-PERP")
+	placeOn(m, Order{ID: "ask", Symbol: "BTC-PERP", Side: SideSell, Price: 100.0, Quantity: 10, Owner: "bob"})
+	placeOn(m, Order{ID: "long", Symbol: "BTC-PERP", Side: SideBuy, Price: 100.0, Quantity: 10, Owner: "alice"})
+	for price, quantity := range bids {
+		placeOn(m, Order{ID: fmt.Sprintf("bid-%g", price), Symbol: "BTC-PERP", Side: SideBuy, Price: price, Quantity: quantity, Owner: "dave"})
+	}
+	return m
+}
+
+func TestLiquidation_InsuranceCoversTheDeficit(t *testing.T) {
+	setupTest()
+	liquidationFeeBps = 10
+	m := openLong(t, map[float64]int{94: 1, 85: 20})
+	body, _ := json.Marshal(BalanceRequest{Asset: "USD", Amount: 100})
+	if result := serve(HTTPConfig{}, httptest.NewRequest("POST", "/api/v1/admin/insurance-fund", bytes.NewReader(body))); result.Code != http.StatusOK {
+		t.Fatalf("Expected to fund the insurance fund, got %d: %s", result.Code, result.Body)
+	}
+
+	// At 94 alice is liquidated into the bid at 85, 5 below her bankruptcy price
+	placeOn(m, Order{ID: "sell-94", Symbol: "BTC-PERP", Side: SideSell, Price: 94.0, Quantity: 1, Owner: "carol"})
+	response, _ := accountMargin("alice")
+	if len(response.Liquidations) != 1 {
+		t.Fatalf("Expected one liquidation, got %+v", response)
+	}
+	liquidation := response.Liquidations[0]
+	if liquidation.BankruptcyPrice != 90 || !approxEqual(liquidation.Fee, 0.85) || !approxEqual(liquidation.InsurancePaid, 50.85) || liquidation.Deleveraged != 0 {
+		t.Errorf("Unexpected liquidation %+v", liquidation)
+	}
+	if alice, _ := lookupAccount("alice"); !approxEqual(alice.Balances["USD"], 0) {
+		t.Errorf("Expected the fund to bring alice back to zero, got %+v", alice.Balances)
+	}
+
+	var fund InsuranceFundResponse
+	json.NewDecoder(serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/insurance-fund", nil)).Body).Decode(&fund)
+	if !approxEqual(fund.Balances["USD"], 50) || fund.Count != 3 || fund.Changes[1].Type != BalanceLiquidationFee || fund.Changes[2].Type != BalanceInsurance {
+		t.Errorf("Expected the deposit, the fee and the cover, got %+v", fund)
+	}
+}
+
+func TestLiquidation_DeleveragesWhenTheFundIsEmpty(t *testing.T) {
+	setupTest()
+	m := openLong(t, map[float64]int{95: 1, 94: 1})
+
+	// carol's two sells move the mark and leave the book without bids
+	placeOn(m, Order{ID: "sell-95", Symbol: "BTC-PERP", Side: SideSell, Price: 95.0, Quantity: 1, Owner: "carol"})
+	placeOn(m, Order{ID: "sell-94", Symbol: "BTC-PERP", Side: SideSell, Price: 94.0, Quantity: 1, Owner: "carol"})
+
+	response, _ := accountMargin("alice")
+	if len(response.Liquidations) != 1 || response.Liquidations[0].Filled != 0 || response.Liquidations[0].Deleveraged != 10 {
+		t.Fatalf("Expected the whole position deleveraged, got %+v", response)
+	}
+
+	var queue ADLQueueResponse
+	json.NewDecoder(serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/adl?symbol=BTC-PERP", nil)).Body).Decode(&queue)
+	if len(queue.Events) != 1 || queue.Events[0].Price != 90 || len(queue.Events[0].Fills) != 1 || queue.Events[0].Fills[0].AccountID != "bob" {
+		t.Fatalf("Expected bob, the most profitable short, to take the position at 90, got %+v", queue.Events)
+	}
+	// bob is flat, leaving carol's short and dave's long
+	if len(queue.Shorts) != 1 || queue.Shorts[0].AccountID != "carol" || len(queue.Longs) != 1 || queue.Longs[0].Rank != 1 {
+		t.Errorf("Unexpected queue %+v", queue)
+	}
+
+	for id, balance := range map[string]float64{"alice": 0, "bob": 10100} {
+		if account, _ := lookupAccount(id); !approxEqual(account.Balances["USD"], balance) {
+			t.Errorf("%s: expected %g USD, got %+v", id, balance, account.Balances)
+		}
+	}
+	accountsMu.Lock()
+	check := checkLedgerLocked()
+	accountsMu.Unlock()
+	if !check.Balanced {
+		t.Errorf("Expected a balanced ledger, got %+v", check)
+	}
+}
+
+func TestADLQueue_RanksByLeveragedProfit(t *testing.T) {
+	setupTest()
+	resetSymbols([]string{"BTC-PERP", "BTC-USD"})
+	listPerpetual("BTC-PERP")
+
+	accountsMu.Lock()
+	perpetualMarks["BTC-PERP"] = 110
+	leverages["low"] = 2
+	for owner, quantity := range map[string]int{"low": 5, "high": 5, "short": -10} {
+		positionLocked(owner, "BTC-PERP").apply(quantity, 100)
+	}
+	longs, shorts := adlQueueLocked("BTC-PERP")
+	accountsMu.Unlock()
+
+	if len(longs) != 2 || longs[0].AccountID != "high" || !approxEqual(longs[0].Score, 1) || !approxEqual(longs[1].Score, 0.2) {
+		t.Errorf("Expected the 10x long ahead of the 2x one, got %+v", longs)
+	}
+	if len(shorts) != 1 || !approxEqual(shorts[0].Score, -0.01) {
+		t.Errorf("Expected the losing short's score divided by its leverage, got %+v", shorts)
+	}
+
+	if result := serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/adl?symbol=BTC-USD", nil)); result.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a spot symbol, got %d", result.Code)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// simOp is one step of a generated order sequence
+type simOp struct {
+	cancel   bool
+	side     Side
+	price    float64
+	quantity int
+	// target picks which previously placed order a cancel refers to
+	target int
+}
+
+// simulation replays generated operations and tracks what each order should add up to
+type simulation struct {
+	base     time.Time
+	placed   map[string]int
+	filled   map[string]int
+	canceled map[string]int
+	ids      []string
+}
+
+func newSimulation() *simulation {
+	setupTest()
+	return &simulation{
+		base:     time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		placed:   make(map[string]int),
+		filled:   make(map[string]int),
+		canceled: make(map[string]int),
+	}
+}
+
+// apply runs a single operation and checks every invariant afterwards
+func (s *simulation) apply(t *testing.T, step int, op simOp) {
+	t.Helper()
+
+	if op.cancel {
+		if len(s.ids) == 0 {
+			return
+		}
+		orderID := s.ids[op.target%len(s.ids)]
+		if order, ok := cancelOrder("", orderID); ok {
+			s.canceled[orderID] += order.Quantity
+		}
+		s.check(t, step)
+		return
+	}
+
+	order := Order{
+		ID:        fmt.Sprintf("order-%d", step),
+		Side:      op.side,
+		Price:     op.price,
+		Quantity:  op.quantity,
+		Status:    OrderStatusPending,
+		CreatedAt: s.base.Add(time.Duration(step) * time.Microsecond),
+	}
+	s.placed[order.ID] = order.Quantity
+	s.ids = append(s.ids, order.ID)
+
+	tradesBefore := len(tradeStore.List(""))
+	processOrder(order)
+	s.checkTakerPriority(t, order, tradeStore.List("")[tradesBefore:])
+
+	for _, trade := range tradeStore.List("")[tradesBefore:] {
+		s.filled[trade.MakerID] += trade.Quantity
+		s.filled[trade.TakerID] += trade.Quantity
+	}
+	s.check(t, step)
+}
+
+// check asserts the book-wide invariants
+func (s *simulation) check(t *testing.T, step int) {
+	t.Helper()
+
+	// Book is never crossed or locked
+	if len(bookFor("").bids.orders()) > 0 && len(bookFor("").asks.orders()) > 0 &&
+		bookFor("").bids.orders()[0].Price >= bookFor("").asks.orders()[0].Price {
+		t.Fatalf("step %d: book crossed: best bid %.2f >= best ask %.2f", step, bookFor("").bids.orders()[0].Price, bookFor("").asks.orders()[0].Price)
+	}
+
+	// Each side is in price-time priority
+	for i := 1; i < len(bookFor("").bids.orders()); i++ {
+		prev, cur := bookFor("").bids.orders()[i-1], bookFor("").bids.orders()[i]
+		if prev.Price < cur.Price || (prev.Price == cur.Price && prev.CreatedAt.After(cur.CreatedAt)) {
+			t.Fatalf("step %d: buy side out of priority at %d: %+v before %+v", step, i, prev, cur)
+		}
+	}
+	for i := 1; i < len(bookFor("").asks.orders()); i++ {
+		prev, cur := bookFor("").asks.orders()[i-1], bookFor("").asks.orders()[i]
+		if prev.Price > cur.Price || (prev.Price == cur.Price && prev.CreatedAt.After(cur.CreatedAt)) {
+			t.Fatalf("step %d: sell side out of priority at %d: %+v before %+v", step, i, prev, cur)
+		}
+	}
+
+	// Resting orders have positive quantity and a non-terminal status
+	resting := make(map[string]int)
+	for _, order := range getAllOrders() {
+		if order.Quantity <= 0 {
+			t.Fatalf("step %d: resting order %s has quantity %d", step, order.ID, order.Quantity)
+		}
+		if isTerminalStatus(order.Status) {
+			t.Fatalf("step %d: resting order %s has terminal status %s", step, order.ID, order.Status)
+		}
+		resting[order.ID] = order.Quantity
+	}
+
+	// The incremental liquidity totals agree with the book
+	checkLiquidity(t, step, "bid", bookFor("").bids.orders(), &bookFor("").bidLiquidity)
+	checkLiquidity(t, step, "ask", bookFor("").asks.orders(), &bookFor("").askLiquidity)
+
+	// Trades are positive
+	for _, trade := range tradeStore.List("") {
+		if trade.Quantity <= 0 {
+			t.Fatalf("step %d: trade %s has quantity %d", step, trade.ID, trade.Quantity)
+		}
+	}
+
+	// Quantity is conserved: placed = resting + filled + cancelled
+	for orderID, placed := range s.placed {
+		accounted := resting[orderID] + s.filled[orderID] + s.canceled[orderID]
+		if accounted != placed {
+			t.Fatalf("step %d: order %s placed %d but accounts for %d (resting %d, filled %d, cancelled %d)",
+				step, orderID, placed, accounted, resting[orderID], s.filled[orderID], s.canceled[orderID])
+		}
+	}
+}
+
+// checkLiquidity asserts that a side's liquidity totals match its orders
+func checkLiquidity(t *testing.T, step int, name string, orders []Order, liquidity *sideLiquidity) {
+	t.Helper()
+
+	levels := aggregateLevels(orders, 0)
+	quantity := 0
+	for _, level := range levels {
+		quantity += level.Quantity
+		if liquidity.level(level.Price) != level {
+			t.Fatalf("step %d: %s level %.2f is %+v, expected %+v", step, name, level.Price, liquidity.level(level.Price), level)
+		}
+	}
+	if liquidity.levelCount() != len(levels) || liquidity.quantity != quantity || liquidity.orders != len(orders) {
+		t.Fatalf("step %d: %s totals %d levels, %d quantity, %d orders; book has %d, %d, %d",
+			step, name, liquidity.levelCount(), liquidity.quantity, liquidity.orders, len(levels), quantity, len(orders))
+	}
+}
+
+// checkTakerPriority asserts that a taker's fills walked the book from the best price
+// and never traded through its own limit
+func (s *simulation) checkTakerPriority(t *testing.T, taker Order, fills []Trade) {
+	t.Helper()
+
+	for i, trade := range fills {
+		if trade.TakerID != taker.ID {
+			t.Fatalf("trade %s has taker %s, expected %s", trade.ID, trade.TakerID, taker.ID)
+		}
+		if taker.Side == SideBuy && trade.Price > taker.Price {
+			t.Fatalf("buy %s limit %.2f filled at %.2f", taker.ID, taker.Price, trade.Price)
+		}
+		if taker.Side == SideSell && trade.Price < taker.Price {
+			t.Fatalf("sell %s limit %.2f filled at %.2f", taker.ID, taker.Price, trade.Price)
+		}
+		if i > 0 {
+			prev := fills[i-1]
+			if taker.Side == SideBuy && trade.Price < prev.Price {
+				t.Fatalf("buy %s filled at %.2f after %.2f", taker.ID, trade.Price, prev.Price)
+			}
+			if taker.Side == SideSell && trade.Price > prev.Price {
+				t.Fatalf("sell %s filled at %.2f after %.2f", taker.ID, trade.Price, prev.Price)
+			}
+		}
+	}
+
+	// Anything left on the opposite side must be worse than the taker's limit,
+	// unless the taker was completely filled
+	filled := 0
+	for _, trade := range fills {
+		filled += trade.Quantity
+	}
+	if filled == taker.Quantity {
+		return
+	}
+	if taker.Side == SideBuy && len(bookFor("").asks.orders()) > 0 && bookFor("").asks.orders()[0].Price <= taker.Price {
+		t.Fatalf("buy %s at %.2f left a marketable ask at %.2f", taker.ID, taker.Price, bookFor("").asks.orders()[0].Price)
+	}
+	if taker.Side == SideSell && len(bookFor("").bids.orders()) > 0 && bookFor("").bids.orders()[0].Price >= taker.Price {
+		t.Fatalf("sell %s at %.2f left a marketable bid at %.2f", taker.ID, taker.Price, bookFor("").bids.orders()[0].Price)
+	}
+}
+
+// maxFuzzOps bounds a single fuzz input so every invariant can be rechecked after each step
+const maxFuzzOps = 256
+
+// decodeOps turns fuzz input into operations, three bytes per step
+func decodeOps(data []byte) []simOp {
+	var ops []simOp
+	for i := 0; i+2 < len(data) && len(ops) < maxFuzzOps; i += 3 {
+		kind, price, qty := data[i], data[i+1], data[i+2]
+		op := simOp{
+			side: SideBuy,
+			// Cluster prices on a small tick grid so orders actually cross
+			price:    95.0 + float64(price%21)*0.5,
+			quantity: 1 + int(qty%50),
+			target:   int(price),
+		}
+		if kind&1 == 1 {
+			op.side = SideSell
+		}
+		op.cancel = kind%5 == 0
+		ops = append(ops, op)
+	}
+	return ops
+}
+
+func FuzzProcessOrder_Invariants(f *testing.F) {
+	f.Add([]byte{0x01, 10, 5, 0x02, 12, 3, 0x03, 8, 9})
+	f.Add([]byte{0x02, 0, 49, 0x01, 20, 49, 0x00, 1, 0, 0x02, 10, 10, 0x01, 10, 10})
+	f.Add([]byte{0x01, 5, 1, 0x01, 5, 1, 0x01, 5, 1, 0x02, 5, 2, 0x02, 6, 9})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		sim := newSimulation()
+		for step, op := range decodeOps(data) {
+			sim.apply(t, step, op)
+		}
+	})
+}
+
+func TestProcessOrder_RandomSequenceInvariants(t *testing.T) {
+	for seed := int64(1); seed <= 20; seed++ {
+		rng := rand.New(rand.NewSource(seed))
+		sim := newSimulation()
+
+		for step := 0; step < 500; step++ {
+			op := simOp{
+				cancel:   rng.Intn(6) == 0,
+				side:     SideBuy,
+				price:    95.0 + float64(rng.Intn(21))*0.5,
+				quantity: 1 + rng.Intn(50),
+				target:   rng.Intn(1 << 16),
+			}
+			if rng.Intn(2) == 0 {
+				op.side = SideSell
+			}
+			sim.apply(t, step, op)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+// jsonAppender is a body on a market data hot path that writes its own JSON,
+// byte for byte what encoding/json would, without reflection or allocation
+type jsonAppender interface {
+	appendJSON(b []byte) []byte
+}
+
+// jsonBuffers recycles the buffers hot-path bodies are encoded into
+var jsonBuffers = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 4096)
+		return &b
+	},
+}
+
+// encodeJSON appends v's JSON and the newline json.Encoder ends it with,
+// taking the hand-rolled path when v has one
+func encodeJSON(b []byte, v interface{}) []byte {
+	if appender, ok := v.(jsonAppender); ok {
+		return append(appender.appendJSON(b), '\n')
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return b
+	}
+	return append(append(b, data...), '\n')
+}
+
+// writeJSONMessage sends v as one text message in a schema version through
+// pooled buffers, as conn.WriteJSON would
+func writeJSONMessage(conn *websocket.Conn, v interface{}, version int) error {
+	buf, stamped := jsonBuffers.Get().(*[]byte), jsonBuffers.Get().(*[]byte)
+	*buf = encodeJSON((*buf)[:0], v)
+	if t := reflect.TypeOf(v); hasNewerFields(t, version) {
+		*stamped = versionJSON(*buf, t, version)
+	} else {
+		*stamped = stampSchemaVersion((*stamped)[:0], *buf, version)
+	}
+	err := conn.WriteMessage(websocket.TextMessage, *stamped)
+	jsonBuffers.Put(buf)
+	jsonBuffers.Put(stamped)
+	return err
+}
+
+// jsonHex is the digits \u escapes are written with
+const jsonHex = "0123456789abcdef"
+
+// appendJSONString appends s quoted and escaped as encoding/json does,
+// including its HTML escapes. Invalid UTF-8 becomes U+FFFD, which older
+// versions of encoding/json write escaped.
+func appendJSONString(b []byte, s string) []byte {
+	b = append(b, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if c := s[i]; c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' && c != '<' && c != '>' && c != '&' {
+				i++
+				continue
+			}
+			b = append(b, s[start:i]...)
+			switch c {
+			case '\\', '"':
+				b = append(b, '\\', c)
+			case '\b':
+				b = append(b, '\\', 'b')
+			case '\f':
+				b = append(b, '\\', 'f')
+			case '\n':
+				b = append(b, '\\', 'n')
+			case '\r':
+				b = append(b, '\\', 'r')
+			case '\t':
+				b = append(b, '\\', 't')
+			default:
+				b = append(b, '\\', 'u', '0', '0', jsonHex[c>>4], jsonHex[c&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			b = append(append(b, s[start:i]...), "\ufffd"...)
+		} else if r == '\u2028' || r == '\u2029' {
+			b = append(append(b, s[start:i]...), '\\', 'u', '2', '0', '2', jsonHex[r&0xF])
+		} else {
+			i += size
+			continue
+		}
+		i += size
+		start = i
+	}
+	return append(append(b, s[start:]...), '"')
+}
+
+// appendJSONFloat appends f as encoding/json does: plain decimals, with
+// exponents only for very small or very large magnitudes. NaN and the
+// infinities, which encoding/json refuses, are written as null.
+func appendJSONFloat(b []byte, f float64) []byte {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return append(b, "null"...)
+	}
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	b = strconv.AppendFloat(b, f, format, -1, 64)
+	if format == 'e' {
+		// e-09 is written e-9
+		if n := len(b); n >= 4 && b[n-4] == 'e' && b[n-3] == '-' && b[n-2] == '0' {
+			b[n-2] = b[n-1]
+			b = b[:n-1]
+		}
+	}
+	return b
+}
+
+// appendJSONTime appends t as time.Time's MarshalJSON does
+func appendJSONTime(b []byte, t time.Time) []byte {
+	return t.AppendFormat(b, `"`+time.RFC3339Nano+`"`)
+}
+
+// appendJSONLevels appends a list of levels, with a nil list as null
+func appendJSONLevels(b []byte, levels []PriceLevel) []byte {
+	if levels == nil {
+		return append(b, "null"...)
+	}
+	b = append(b, '[')
+	for i, level := range levels {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = level.appendJSON(b)
+	}
+	return append(b, ']')
+}
+
+func (level PriceLevel) appendJSON(b []byte) []byte {
+	b = append(b, `{"price":`...)
+	b = appendJSONFloat(b, level.Price)
+	b = append(b, `,"quantity":`...)
+	b = strconv.AppendInt(b, int64(level.Quantity), 10)
+	b = append(b, `,"orders":`...)
+	b = strconv.AppendInt(b, int64(level.Orders), 10)
+	return append(b, '}')
+}
+
+func (snapshot DepthSnapshot) appendJSON(b []byte) []byte {
+	b = append(b, `{"symbol":`...)
+	b = appendJSONString(b, snapshot.Symbol)
+	b = append(b, `,"sequence":`...)
+	b = strconv.AppendInt(b, snapshot.Sequence, 10)
+	if snapshot.Epoch != "" {
+		b = append(b, `,"epoch":`...)
+		b = appendJSONString(b, snapshot.Epoch)
+	}
+	b = append(b, `,"bids":`...)
+	b = appendJSONLevels(b, snapshot.Bids)
+	b = append(b, `,"asks":`...)
+	b = appendJSONLevels(b, snapshot.Asks)
+	return append(b, '}')
+}
+
+func (update DepthUpdate) appendJSON(b []byte) []byte {
+	b = append(b, `{"type":`...)
+	b = appendJSONString(b, string(update.Type))
+	b = append(b, `,"symbol":`...)
+	b = appendJSONString(b, update.Symbol)
+	if update.FirstSequence != 0 {
+		b = append(b, `,"first_sequence":`...)
+		b = strconv.AppendInt(b, update.FirstSequence, 10)
+	}
+	b = append(b, `,"sequence":`...)
+	b = strconv.AppendInt(b, update.Sequence, 10)
+	if len(update.Bids) > 0 {
+		b = append(b, `,"bids":`...)
+		b = appendJSONLevels(b, update.Bids)
+	}
+	if len(update.Asks) > 0 {
+		b = append(b, `,"asks":`...)
+		b = appendJSONLevels(b, update.Asks)
+	}
+	return append(b, '}')
+}
+
+func (trade PublicTrade) appendJSON(b []byte) []byte {
+	b = append(b, `{"id":`...)
+	b = appendJSONString(b, trade.ID)
+	b = append(b, `,"symbol":`...)
+	b = appendJSONString(b, trade.Symbol)
+	b = append(b, `,"price":`...)
+	b = appendJSONFloat(b, trade.Price)
+	b = append(b, `,"quantity":`...)
+	b = strconv.AppendInt(b, int64(trade.Quantity), 10)
+	b = append(b, `,"aggressor_side":`...)
+	b = appendJSONString(b, string(trade.AggressorSide))
+	if trade.TickDirection != "" {
+		b = append(b, `,"tick_direction":`...)
+		b = appendJSONString(b, string(trade.TickDirection))
+	}
+	b = append(b, `,"created_at":`...)
+	b = appendJSONTime(b, trade.CreatedAt)
+	return append(b, '}')
+}
+
+// appendJSON writes public trade and depth messages by hand. Order updates,
+// fills, status changes and indicative auctions are rarer and go through
+// encoding/json.
+func (msg FeedMessage) appendJSON(b []byte) []byte {
+	if msg.Order != nil || msg.Fill != nil || msg.Status != nil || msg.Auction != nil {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return b
+		}
+		return append(b, data...)
+	}
+	b = append(b, `{"channel":`...)
+	b = appendJSONString(b, string(msg.Channel))
+	b = append(b, `,"type":`...)
+	b = appendJSONString(b, string(msg.Type))
+	if msg.Symbol != "" {
+		b = append(b, `,"symbol":`...)
+		b = appendJSONString(b, msg.Symbol)
+	}
+	if msg.Trade != nil {
+		b = append(b, `,"trade":`...)
+		b = msg.Trade.appendJSON(b)
+	}
+	if msg.Depth != nil {
+		b = append(b, `,"depth":`...)
+		b = msg.Depth.appendJSON(b)
+	}
+	if msg.Reason != "" {
+		b = append(b, `,"reason":`...)
+		b = appendJSONString(b, msg.Reason)
+	}
+	return append(b, '}')
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// jsonSamples are hot-path bodies covering every field, empty and escaped
+// strings, and the floats and times encoding/json formats specially
+func jsonSamples() []interface{} {
+	levels := []PriceLevel{{Price: 100.25, Quantity: 3, Orders: 1}, {Price: 1e-7, Quantity: 1, Orders: 2}, {Price: 1e21, Quantity: 0, Orders: 0}, {Price: -0.5}}
+	zone := time.FixedZone("EST", -5*60*60)
+	return []interface{}{
+		DepthSnapshot{Symbol: "BTC-USD", Sequence: 42, Bids: levels, Asks: levels[:1]},
+		DepthSnapshot{Symbol: "", Bids: nil, Asks: []PriceLevel{}},
+		DepthSnapshot{Symbol: "A<B>&\"C\"\\\n\t\x01\u2028\u2029é", Sequence: -1},
+		DepthUpdate{Type: DepthMessageUpdate, Symbol: "ETH-USD", Sequence: 7, Bids: levels},
+		DepthUpdate{Type: DepthMessageResync, Symbol: "ETH-USD", Sequence: 8, Asks: []PriceLevel{}},
+		DepthUpdate{Type: DepthMessageUpdate, Symbol: "ETH-USD", FirstSequence: 9, Sequence: 12, Asks: levels},
+		FeedMessage{Channel: FeedPublic, Type: FeedMessageTrade, Symbol: "BTC-USD", Trade: &PublicTrade{
+			ID: "trade-1", Symbol: "BTC-USD", Price: 0.000123, Quantity: 5, AggressorSide: SideBuy, TickDirection: TickUp,
+			CreatedAt: time.Date(2024, 1, 2, 9, 30, 0, 123456789, time.UTC),
+		}},
+		FeedMessage{Channel: FeedPublic, Type: FeedMessageTrade, Trade: &PublicTrade{CreatedAt: time.Date(2024, 6, 1, 0, 0, 0, 0, zone)}},
+		FeedMessage{Channel: FeedPublic, Type: FeedMessageTrade, Trade: &PublicTrade{}},
+		FeedMessage{Channel: FeedPublic, Type: FeedMessageDepth, Symbol: "BTC-USD", Depth: &DepthUpdate{Type: DepthMessageUpdate, Sequence: 3, Asks: levels}},
+		FeedMessage{Channel: FeedPublic, Type: FeedMessageResync, Symbol: "BTC-USD", Reason: "fell behind"},
+		FeedMessage{Channel: FeedPrivate, Type: FeedMessageOrder, Order: &Order{ID: "order-1", Price: 100}, Reason: "placed"},
+	}
+}
+
+func TestAppendJSON_MatchesEncodingJSON(t *testing.T) {
+	for i, sample := range jsonSamples() {
+		want, err := json.Marshal(sample)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := encodeJSON(nil, sample); string(got) != string(want)+"\n" {
+			t.Errorf("Sample %d: expected\n%s\ngot\n%s", i, want, got)
+		}
+	}
+}
+
+func TestAppendJSONString_InvalidUTF8(t *testing.T) {
+	var decoded string
+	if err := json.Unmarshal(appendJSONString(nil, "ok\xffok"), &decoded); err != nil || decoded != "ok\ufffdok" {
+		t.Errorf("Expected invalid UTF-8 replaced, got %q (%v)", decoded, err)
+	}
+}
+
+func TestAppendJSON_DoesNotAllocate(t *testing.T) {
+	for i, sample := range jsonSamples() {
+		appender := sample.(jsonAppender)
+		if msg, ok := sample.(FeedMessage); ok && msg.Order != nil {
+			continue
+		}
+		buf := make([]byte, 0, 4096)
+		if allocs := testing.AllocsPerRun(100, func() { buf = appender.appendJSON(buf[:0]) }); allocs != 0 {
+			t.Errorf("Sample %d: expected no allocations, got %.1f", i, allocs)
+		}
+	}
+}
+
+// benchDepth is a depth snapshot levels deep a side
+func benchDepth(levels int) DepthSnapshot {
+	snapshot := DepthSnapshot{Symbol: "BTC-USD", Sequence: 123456}
+	for i := 0; i < levels; i++ {
+		snapshot.Bids = append(snapshot.Bids, PriceLevel{Price: benchLevel(SideBuy, i), Quantity: 10 + i, Orders: 1 + i%3})
+		snapshot.Asks = append(snapshot.Asks, PriceLevel{Price: benchLevel(SideSell, i), Quantity: 10 + i, Orders: 1 + i%3})
+	}
+	return snapshot
+}
+
+func BenchmarkJSON_Depth(b *testing.B) {
+	for _, levels := range []int{1, 10, 100} {
+		snapshot := benchDepth(levels)
+		b.Run(fmt.Sprintf("levels=%d/encoding_json", levels), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				json.Marshal(snapshot)
+			}
+		})
+		b.Run(fmt.Sprintf("levels=%d/append", levels), func(b *testing.B) {
+			buf := make([]byte, 0, 4096)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				buf = snapshot.appendJSON(buf[:0])
+			}
+		})
+	}
+}
+
+func BenchmarkJSON_TradeMessage(b *testing.B) {
+	msg := FeedMessage{Channel: FeedPublic, Type: FeedMessageTrade, Symbol: "BTC-USD", Trade: &PublicTrade{
+		ID: "trade-123456", Symbol: "BTC-USD", Price: 100.25, Quantity: 5, AggressorSide: SideBuy, TickDirection: TickUp,
+		CreatedAt: time.Date(2024, 1, 2, 9, 30, 0, 123456789, time.UTC),
+	}}
+	b.Run("encoding_json", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			json.Marshal(msg)
+		}
+	})
+	b.Run("append", func(b *testing.B) {
+		buf := make([]byte, 0, 4096)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buf = msg.appendJSON(buf[:0])
+		}
+	})
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JWTConfig says which identity provider's tokens the API accepts besides
+// its static API keys, and which claims name the account and its roles
+type JWTConfig struct {
+	// Issuer must match each token's iss claim
+	Issuer string
+	// JWKSURL serves the provider's signing keys as a JSON Web Key Set
+	JWKSURL string
+	// Audience, when set, must be one of each token's aud claim
+	Audience string
+	// AccountClaim names the order owner a token acts for, and RolesClaim
+	// the roles it is granted
+	AccountClaim string
+	RolesClaim   string
+	// Refresh is how long fetched keys are used before they are fetched again
+	Refresh time.Duration
+}
+
+// jwtLeeway is how far a token's exp and nbf may be off the server's clock
+const jwtLeeway = 30 * time.Second
+
+// jwksRetryDelay is the least time between fetches of the key set, so tokens
+// naming an unknown key cannot make the server hammer the provider
+const jwksRetryDelay = 30 * time.Second
+
+// JWTVerifier checks bearer tokens against the keys its issuer publishes
+type JWTVerifier struct {
+	cfg    JWTConfig
+	client *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+// NewJWTVerifier creates a verifier for cfg. Keys are fetched on first use.
+func NewJWTVerifier(cfg JWTConfig) *JWTVerifier {
+	return &JWTVerifier{cfg: cfg, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+// tokenIdentity is who a verified token speaks for
+type tokenIdentity struct {
+	account string
+	// roles is nil when the token has no roles claim
+	roles []Role
+}
+
+// tokenKey is the request context key for the identity of a verified token
+type tokenKey struct{}
+
+// tokenFrom returns the identity of the request's bearer token, if it had one
+func tokenFrom(ctx context.Context) (tokenIdentity, bool) {
+	identity, ok := ctx.Value(tokenKey{}).(tokenIdentity)
+	return identity, ok
+}
+
+// looksLikeJWT reports whether a credential has the three parts of a JWT,
+// which static API keys are not expected to
+func looksLikeJWT(credential string) bool {
+	return strings.Count(credential, ".") == 2
+}
+
+// jwtHeader is the part of a token's header the verifier reads
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verify checks a token's signature, issuer, audience and lifetime and
+// returns who it speaks for
+func (v *JWTVerifier) Verify(token string) (tokenIdentity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return tokenIdentity{}, errors.New("not a JWT")
+	}
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return tokenIdentity{}, fmt.Errorf("reading header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return tokenIdentity{}, fmt.Errorf("reading signature: %w", err)
+	}
+
+	key, err := v.key(header.Kid)
+	if err != nil {
+		return tokenIdentity{}, err
+	}
+	if err := verifyJWTSignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return tokenIdentity{}, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return tokenIdentity{}, fmt.Errorf("reading claims: %w", err)
+	}
+	if err := v.checkClaims(claims, time.Now()); err != nil {
+		return tokenIdentity{}, err
+	}
+
+	account, _ := claims[v.cfg.AccountClaim].(string)
+	if account == "" {
+		return tokenIdentity{}, fmt.Errorf("token has no %s claim", v.cfg.AccountClaim)
+	}
+	identity := tokenIdentity{account: account}
+	if granted, ok := claims[v.cfg.RolesClaim]; ok {
+		identity.roles = tokenRoles(granted)
+	}
+	return identity, nil
+}
+
+// checkClaims checks a token's issuer, audience, expiry and not-before time
+func (v *JWTVerifier) checkClaims(claims map[string]interface{}, now time.Time) error {
+	if issuer, _ := claims["iss"].(string); issuer != v.cfg.Issuer {
+		return fmt.Errorf("token issued by %q, not %q", issuer, v.cfg.Issuer)
+	}
+	if v.cfg.Audience != "" {
+		var audiences []string
+		switch aud := claims["aud"].(type) {
+		case string:
+			audiences = []string{aud}
+		case []interface{}:
+			for _, item := range aud {
+				if s, ok := item.(string); ok {
+					audiences = append(audiences, s)
+				}
+			}
+		}
+		if !slices.Contains(audiences, v.cfg.Audience) {
+			return fmt.Errorf("token is not for audience %q", v.cfg.Audience)
+		}
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("token has no exp claim")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(jwtLeeway)) {
+		return errors.New("token has expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("token is not valid yet")
+	}
+	return nil
+}
+
+// tokenRoles reads a roles claim, a list of names or a space-separated
+// string of them like an OAuth2 scope. Names that are not roles here, as
+// an identity provider may grant for other services, are ignored.
+func tokenRoles(claim interface{}) []Role {
+	var names []string
+	switch granted := claim.(type) {
+	case string:
+		names = strings.Fields(granted)
+	case []interface{}:
+		for _, item := range granted {
+			if name, ok := item.(string); ok {
+				names = append(names, name)
+			}
+		}
+	}
+	roles := []Role{}
+	for _, name := range names {
+		if role := Role(name); slices.Contains(allRoles, role) && !slices.Contains(roles, role) {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// key returns the signing key a token names, fetching the key set again
+// when the keys are stale or the token names one not seen yet. A token with
+// no kid may use the only key there is.
+func (v *JWTVerifier) key(kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	stale := time.Since(v.fetched) > v.cfg.Refresh
+	_, known := v.keys[kid]
+	if stale || (!known && kid != "" && time.Since(v.fetched) > jwksRetryDelay) {
+		keys, err := v.fetchKeys()
+		if err != nil && v.keys == nil {
+			return nil, fmt.Errorf("fetching signing keys: %w", err)
+		}
+		if err == nil {
+			v.keys = keys
+		}
+		v.fetched = time.Now()
+	}
+
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	if kid == "" && len(v.keys) == 1 {
+		for _, key := range v.keys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("no signing key %q", kid)
+}
+
+// jsonWebKey is one key of a JSON Web Key Set, RSA or elliptic curve
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// fetchKeys downloads the key set, keeping the signing keys it can read
+func (v *JWTVerifier) fetchKeys() (map[string]crypto.PublicKey, error) {
+	resp, err := v.client.Get(v.cfg.JWKSURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("key set returned %s", resp.Status)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("decoding key set: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	return keys, nil
+}
+
+// publicKey reads an RSA or P-256, P-384 or P-521 key
+func (jwk jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeJWKInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeJWKInt(jwk.E)
+		if err != nil || !e.IsInt64() {
+			return nil, errors.New("bad RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[jwk.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := decodeJWKInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeJWKInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+}
+
+// verifyJWTSignature checks signed, the token's header and claims, against
+// its signature with an RS or ES algorithm
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	hashes := map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}
+	hash, ok := hashes[strings.TrimLeft(alg, "RSE")]
+	if !ok || len(alg) != 5 {
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			return fmt.Errorf("algorithm %s does not match an RSA key", alg)
+		}
+		if rsa.VerifyPKCS1v15(pub, hash, digest, signature) != nil {
+			return errors.New("bad signature")
+		}
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(alg, "ES") || len(signature) != 2*size {
+			return fmt.Errorf("algorithm %s does not match an elliptic curve key", alg)
+		}
+		r, s := new(big.Int).SetBytes(signature[:size]), new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errors.New("bad signature")
+		}
+	default:
+		return errors.New("unsupported key")
+	}
+	return nil
+}
+
+// decodeJWTPart decodes a base64url JSON part of a token into v
+func decodeJWTPart(part string, v interface{}) error {
+	raw, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// decodeJWKInt decodes a base64url big-endian integer of a JSON Web Key
+func decodeJWKInt(s string) (*big.Int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(raw) == 0 {
+		return nil, errors.New("bad key parameter")
+	}
+	return new(big.Int).SetBytes(raw), nil
+}
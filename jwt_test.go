@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testIssuer signs tokens with an EC key and an RSA key it publishes
+type testIssuer struct {
+	ec  *ecdsa.PrivateKey
+	rsa *rsa.PrivateKey
+	url string
+}
+
+// newTestIssuer serves the issuer's key set until the test ends
+func newTestIssuer(t *testing.T) *testIssuer {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	b64 := base64.RawURLEncoding.EncodeToString
+	set := map[string]interface{}{"keys": []map[string]string{
+		{"kty": "EC", "kid": "ec-1", "use": "sig", "crv": "P-256", "x": b64(ecKey.X.Bytes()), "y": b64(ecKey.Y.Bytes())},
+		{"kty": "RSA", "kid": "rsa-1", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "RSA", "kid": "enc-1", "use": "enc", "n": b64(rsaKey.N.Bytes()), "e": "AQAB"},
+	}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(server.Close)
+	return &testIssuer{ec: ecKey, rsa: rsaKey, url: server.URL}
+}
+
+// verifier accepts the issuer's tokens for the venue audience
+func (issuer *testIssuer) verifier() *JWTVerifier {
+	return NewJWTVerifier(JWTConfig{Issuer: "https://idp.test", JWKSURL: issuer.url, Audience: "venue",
+		AccountClaim: "sub", RolesClaim: "roles", Refresh: time.Hour})
+}
+
+// sign issues a token with claims layered over valid defaults; a nil claim
+// is left out
+func (issuer *testIssuer) sign(t *testing.T, alg, kid string, claims map[string]interface{}) string {
+	all := map[string]interface{}{"iss": "https://idp.test", "aud": []string{"venue"}, "sub": "alice",
+		"exp": time.Now().Add(time.Minute).Unix()}
+	for name, value := range claims {
+		if value == nil {
+			delete(all, name)
+		} else {
+			all[name] = value
+		}
+	}
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT", "kid": kid})
+	payload, _ := json.Marshal(all)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signed))
+	var signature []byte
+	if alg == "RS256" {
+		signature, _ = rsa.SignPKCS1v15(rand.Reader, issuer.rsa, crypto.SHA256, digest[:])
+	} else {
+		r, s, err := ecdsa.Sign(rand.Reader, issuer.ec, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// callWithToken sends a request with a bearer token
+func callWithToken(cfg HTTPConfig, token, method, path, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	request.Header.Set("Authorization", "Bearer "+token)
+	return serve(cfg, request)
+}
+
+func TestJWT_TokensActForTheirAccountWithTheirRoles(t *testing.T) {
+	setupTest()
+	issuer := newTestIssuer(t)
+	cfg := HTTPConfig{APIKeys: []string{"static"}, DefaultRoles: []Role{RoleRead}, JWT: issuer.verifier()}
+	order := `{"side":"buy","price":100,"quantity":1}`
+
+	trader := issuer.sign(t, "ES256", "ec-1", map[string]interface{}{"roles": []string{"read", "trade", "other-app"}})
+	if response := callWithToken(cfg, trader, "POST", "/api/v1/orders", order); response.Code != http.StatusOK {
+		t.Errorf("Expected a trade token to place an order, got %d %s", response.Code, response.Body)
+	}
+	if response := callWithToken(cfg, trader, "GET", "/api/v1/admin/queues", ""); response.Code != http.StatusForbidden {
+		t.Errorf("Expected the admin endpoints refused, got %d", response.Code)
+	}
+
+	// Without a roles claim a token gets the default roles, and a space-
+	// separated scope string works too
+	plain := issuer.sign(t, "RS256", "rsa-1", nil)
+	result := decodeError(t, callWithToken(cfg, plain, "POST", "/api/v1/orders", order))
+	if result.Error.Code != ErrCodeForbidden || result.Error.Details != "this endpoint needs the trade role; the token has read" {
+		t.Errorf("Expected FORBIDDEN naming the token's roles, got %+v", result.Error)
+	}
+	scoped := issuer.sign(t, "ES256", "ec-1", map[string]interface{}{"roles": "surveillance"})
+	if response := callWithToken(cfg, scoped, "GET", "/api/v1/surveillance/alerts", ""); response.Code != http.StatusOK {
+		t.Errorf("Expected a surveillance token to read alerts, got %d %s", response.Code, response.Body)
+	}
+	if response := callAs(cfg, "static", "GET", "/api/v1/orders", ""); response.Code != http.StatusOK {
+		t.Errorf("Expected the static key still accepted, got %d", response.Code)
+	}
+
+	// The token's account is who the private feed streams for
+	var owner string
+	var identified bool
+	handler := chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		owner, identified = ownerFrom(r.Context())
+	}), requireAPIKey(cfg.APIKeys, cfg.JWT), identifyOwner(nil))
+	request := httptest.NewRequest("GET", "/api/v1/feed/private", nil)
+	request.Header.Set("Authorization", "Bearer "+trader)
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+	if owner != "alice" || !identified {
+		t.Errorf("Expected the token to act for alice, got %q %v", owner, identified)
+	}
+}
+
+func TestJWT_RefusesBadTokens(t *testing.T) {
+	setupTest()
+	issuer := newTestIssuer(t)
+	cfg := HTTPConfig{JWT: issuer.verifier()}
+	valid := issuer.sign(t, "ES256", "ec-1", nil)
+	// Mallory's claims under the signature of alice's
+	forged := strings.Split(issuer.sign(t, "ES256", "ec-1", map[string]interface{}{"sub": "mallory"}), ".")
+	parts := strings.Split(valid, ".")
+	tampered := parts[0] + "." + forged[1] + "." + parts[2]
+
+	tests := []struct {
+		name, token, reason string
+	}{
+		{"expired", issuer.sign(t, "ES256", "ec-1", map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()}), "token has expired"},
+		{"not yet valid", issuer.sign(t, "ES256", "ec-1", map[string]interface{}{"nbf": time.Now().Add(time.Hour).Unix()}), "token is not valid yet"},
+		{"other issuer", issuer.sign(t, "ES256", "ec-1", map[string]interface{}{"iss": "https://evil.test"}), `token issued by "https://evil.test", not "https://idp.test"`},
+		{"other audience", issuer.sign(t, "ES256", "ec-1", map[string]interface{}{"aud": "elsewhere"}), `token is not for audience "venue"`},
+		{"no account", issuer.sign(t, "ES256", "ec-1", map[string]interface{}{"sub": nil}), "token has no sub claim"},
+		{"unknown key", issuer.sign(t, "ES256", "ec-9", nil), `no signing key "ec-9"`},
+		{"encryption key", issuer.sign(t, "RS256", "enc-1", nil), `no signing key "enc-1"`},
+		{"wrong algorithm", issuer.sign(t, "ES256", "rsa-1", nil), "algorithm ES256 does not match an RSA key"},
+		{"tampered", tampered, "bad signature"},
+	}
+	for _, tt := range tests {
+		response := callWithToken(cfg, tt.token, "GET", "/api/v1/orders", "")
+		result := decodeError(t, response)
+		if response.Code != http.StatusUnauthorized || result.Error.Details != "the bearer token was refused: "+tt.reason {
+			t.Errorf("%s: expected 401 with %q, got %d %+v", tt.name, tt.reason, response.Code, result.Error)
+		}
+	}
+
+	if response := callWithToken(cfg, valid, "GET", "/api/v1/orders", ""); response.Code != http.StatusOK {
+		t.Errorf("Expected the valid token accepted, got %d %s", response.Code, response.Body)
+	}
+	if response := callWithToken(cfg, "not-a-token", "GET", "/api/v1/orders", ""); response.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a credential that is neither a key nor a token refused, got %d", response.Code)
+	}
+}
+
+func TestLoadConfig_JWT(t *testing.T) {
+	cfg, err := loadConfig([]string{"-jwt-issuer", "https://idp.test/", "-jwt-audience", "venue", "-jwt-account-claim", "account_id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := JWTConfig{Issuer: "https://idp.test/", JWKSURL: "https://idp.test/.well-known/jwks.json", Audience: "venue",
+		AccountClaim: "account_id", RolesClaim: "roles", Refresh: time.Hour}
+	if cfg.HTTP.JWT == nil || cfg.HTTP.JWT.cfg != want {
+		t.Errorf("Expected %+v, got %+v", want, cfg.HTTP.JWT)
+	}
+	if cfg, _ := loadConfig(nil); cfg.HTTP.JWT != nil {
+		t.Error("Expected no verifier without -jwt-issuer")
+	}
+	for _, args := range [][]string{{"-jwt-jwks-url", "https://idp.test/keys"}, {"-jwt-issuer", "https://idp.test", "-jwt-account-claim", ""}, {"-jwt-issuer", "https://idp.test", "-jwt-jwks-refresh", "0s"}} {
+		if _, err := loadConfig(args); err == nil {
+			t.Errorf("Expected %v refused", args)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// L3EventType is what happened to an individual resting order
+type L3EventType string
+
+const (
+	// L3Add is an order coming to rest in the book
+	L3Add L3EventType = "add"
+	// L3Reduce is an order's resting quantity shrinking without a trade
+	L3Reduce L3EventType = "reduce"
+	// L3Delete is an order leaving the book without a trade: cancelled,
+	// expired, or repriced by its peg
+	L3Delete L3EventType = "delete"
+	// L3Execute is a resting order trading. It has left the book once its
+	// quantity reaches zero; no delete follows.
+	L3Execute L3EventType = "execute"
+	// L3Resync tells the client it missed events and must fetch a new snapshot
+	L3Resync L3EventType = "resync"
+)
+
+// L3Event is one message on the order-by-order stream. Each event has the
+// next sequence number after the previous one for its symbol.
+type L3Event struct {
+	Type     L3EventType `json:"type"`
+	Symbol   string      `json:"symbol"`
+	Sequence int64       `json:"sequence"`
+	OrderID  string      `json:"order_id,omitempty"`
+	Side     Side        `json:"side,omitempty"`
+	Price    float64     `json:"price,omitempty"`
+	// Quantity is what the order has resting after the event, and Delta is
+	// the signed change the event made to it
+	Quantity int `json:"quantity"`
+	Delta    int `json:"delta,omitempty"`
+	// QueuePosition is the order's place in its price level, 1 filling next.
+	// Orders that left the book report the place they left from.
+	QueuePosition int       `json:"queue_position,omitempty"`
+	TradeID       string    `json:"trade_id,omitempty"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+// L3Order is one resting order in an order-by-order snapshot
+type L3Order struct {
+	OrderID       string    `json:"order_id"`
+	Price         float64   `json:"price"`
+	Quantity      int       `json:"quantity"`
+	QueuePosition int       `json:"queue_position"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// L3Snapshot is every resting order in a symbol's book, in priority order, as
+// of an L3 sequence number
+type L3Snapshot struct {
+	Symbol   string `json:"symbol"`
+	Sequence int64  `json:"sequence"`
+	// Epoch is the run of sequence numbers Sequence belongs to
+	Epoch string    `json:"epoch,omitempty"`
+	Bids  []L3Order `json:"bids"`
+	Asks  []L3Order `json:"asks"`
+}
+
+// l3BufferSize is how many events a stream client may fall behind by before
+// it is told to resync
+const l3BufferSize = 1024
+
+// l3Subscriber is one order-by-order stream client's queue of pending events
+type l3Subscriber struct {
+	events  chan L3Event
+	session *session
+	backlog *backlog
+}
+
+// send queues an event without blocking the matcher. A subscriber whose queue
+// is full loses what it has queued and gets a resync message instead.
+func (s *l3Subscriber) send(event L3Event) {
+	select {
+	case s.events <- event:
+		return
+	default:
+	}
+
+	if s.session != nil {
+		s.session.resyncs.Add(1)
+	}
+	for len(s.events) > 0 {
+		select {
+		case <-s.events:
+		default:
+		}
+	}
+	s.events <- L3Event{Type: L3Resync, Symbol: event.Symbol, Sequence: event.Sequence}
+	s.backlog.fellBehind()
+}
+
+// l3Book numbers a book's order-by-order events and holds the clients
+// streaming them. It is only touched on the book's matcher.
+type l3Book struct {
+	symbol      string
+	sequence    int64
+	subscribers map[*l3Subscriber]struct{}
+}
+
+// publishL3 numbers an event on order and sends it to the book's L3
+// subscribers. position is the order's place in its level, or, for an order
+// that has just left, the place it left from. delta is the signed change to
+// its resting quantity. It must run on the matcher.
+func publishL3(book *OrderBook, kind L3EventType, order Order, position, delta int, tradeID string) {
+	book.l3.sequence++
+	if kind == L3Delete || order.Quantity == 0 {
+		readModel.leave(order)
+	} else {
+		readModel.rest(order)
+	}
+	if len(book.l3.subscribers) == 0 {
+		return
+	}
+
+	event := L3Event{
+		Type:          kind,
+		Symbol:        book.l3.symbol,
+		Sequence:      book.l3.sequence,
+		OrderID:       order.ID,
+		Side:          order.Side,
+		Price:         order.Price,
+		Quantity:      order.Quantity,
+		Delta:         delta,
+		QueuePosition: position,
+		TradeID:       tradeID,
+		Timestamp:     engineClock.Now(),
+	}
+	for subscriber := range book.l3.subscribers {
+		subscriber.send(event)
+	}
+}
+
+// publishLevelL3 reports a replicated level change as order events: deletes
+// for the orders that went or changed beyond a reduction, then adds and
+// reductions in the new level's order. A standby cannot tell fills from
+// reductions, so it reports both as reductions. It must run on the matcher,
+// before the old level is replaced, with what the level held.
+func publishLevelL3(book *OrderBook, orders []Order, level LevelOrders) {
+	next := make(map[string]Order, len(level.Orders))
+	for _, order := range level.Orders {
+		next[order.ID] = order
+	}
+
+	previous := make(map[string]Order, len(orders))
+	removed := 0
+	for i, order := range orders {
+		if replacement, ok := next[order.ID]; ok && replacement.Quantity <= order.Quantity && replacement.CreatedAt.Equal(order.CreatedAt) && replacement.ArrivalSequence == order.ArrivalSequence {
+			previous[order.ID] = order
+			continue
+		}
+		publishL3(book, L3Delete, order, i-removed+1, -order.Quantity, "")
+		removed++
+	}
+
+	for i, order := range level.Orders {
+		old, kept := previous[order.ID]
+		switch {
+		case !kept:
+			publishL3(book, L3Add, order, i+1, order.Quantity, "")
+		case order.Quantity < old.Quantity:
+			publishL3(book, L3Reduce, order, i+1, order.Quantity-old.Quantity, "")
+		}
+	}
+}
+
+// l3Orders numbers each order of a side that is in priority order by its
+// place in its level
+func l3Orders(orders []Order) []L3Order {
+	list := make([]L3Order, 0, len(orders))
+	position := 0
+	for i, order := range orders {
+		position++
+		if i == 0 || orders[i-1].Price != order.Price {
+			position = 1
+		}
+		list = append(list, L3Order{
+			OrderID:       order.ID,
+			Price:         order.Price,
+			Quantity:      order.Quantity,
+			QueuePosition: position,
+			CreatedAt:     order.CreatedAt,
+		})
+	}
+	return list
+}
+
+// getL3SnapshotHandler returns every resting order in a symbol's book and the
+// sequence number L3 stream events continue from, read from the latest view
+// of the book
+func getL3SnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	m, ok := matcherFor(r.URL.Query().Get("symbol"))
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrCodeUnknownSymbol, "Unknown symbol",
+			"symbol '"+r.URL.Query().Get("symbol")+"' is not traded here")
+		return
+	}
+
+	view := m.readView()
+	writeBody(w, r, L3Snapshot{
+		Symbol:   m.symbol,
+		Sequence: view.l3Sequence,
+		Epoch:    currentEpoch(),
+		Bids:     l3Orders(view.buy),
+		Asks:     l3Orders(view.sell),
+	})
+}
+
+// l3StreamHandler streams every add, reduce, delete and execute on a
+// symbol's resting orders over a WebSocket. Clients should connect first,
+// then fetch an L3 snapshot and apply the events that follow its sequence.
+func l3StreamHandler(w http.ResponseWriter, r *http.Request) {
+	m, ok := matcherFor(r.URL.Query().Get("symbol"))
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrCodeUnknownSymbol, "Unknown symbol",
+			"symbol '"+r.URL.Query().Get("symbol")+"' is not traded here")
+		return
+	}
+
+	conn, err := depthUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already written an error response
+		return
+	}
+	defer conn.Close()
+	version := schemaVersionFrom(r.Context())
+
+	subscriber := &l3Subscriber{events: make(chan L3Event, l3BufferSize)}
+	subscriber.session = openSession(SessionL3, r, []string{"l3:" + m.symbol}, l3BufferSize,
+		func() int { return len(subscriber.events) })
+	// Order-by-order events cannot be folded together, so L3 clients resync
+	subscriber.backlog = newBacklog(subscriber.session, false)
+	m.do(func() {
+		if m.book.l3.subscribers == nil {
+			m.book.l3.subscribers = make(map[*l3Subscriber]struct{})
+		}
+		m.book.l3.symbol = m.symbol
+		m.book.l3.subscribers[subscriber] = struct{}{}
+	})
+	defer m.do(func() {
+		delete(m.book.l3.subscribers, subscriber)
+	})
+	// Deferred last so the session leaves the list before its subscription does
+	defer subscriber.session.close()
+
+	// The stream is one-way; reading only notices when the client goes away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case event := <-subscriber.events:
+			if err := writeJSONMessage(conn, event, version); err != nil {
+				return
+			}
+			subscriber.session.sent.Add(1)
+		case <-subscriber.session.kicked:
+			subscriber.session.writeKicked(conn)
+			return
+		case <-closed:
+			return
+		}
+		if len(subscriber.events) == 0 {
+			subscriber.backlog.caughtUp()
+		}
+	}
+}
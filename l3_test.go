@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// subscribeL3 attaches an order-by-order subscriber to the default matcher
+func subscribeL3(t *testing.T, size int) (*matcher, *l3Subscriber) {
+	t.Helper()
+
+	m, _ := matcherFor("")
+	subscriber := &l3Subscriber{events: make(chan L3Event, size)}
+	m.do(func() {
+		m.book.l3.subscribers = map[*l3Subscriber]struct{}{subscriber: {}}
+		m.book.l3.symbol = m.symbol
+	})
+	return m, subscriber
+}
+
+// nextL3 returns the next queued L3 event or fails the test
+func nextL3(t *testing.T, subscriber *l3Subscriber) L3Event {
+	t.Helper()
+
+	select {
+	case event := <-subscriber.events:
+		return event
+	default:
+		t.Fatal("Expected a queued L3 event")
+		return L3Event{}
+	}
+}
+
+// expectL3 checks an event's type, order, queue position and quantities
+func expectL3(t *testing.T, event L3Event, kind L3EventType, orderID string, position, quantity, delta int) {
+	t.Helper()
+	if event.Type != kind || event.OrderID != orderID || event.QueuePosition != position || event.Quantity != quantity || event.Delta != delta {
+		t.Errorf("Expected %s of %s at %d leaving %d (%+d), got %+v", kind, orderID, position, quantity, delta, event)
+	}
+}
+
+func TestL3_OrderEventsWithQueuePositions(t *testing.T) {
+	setupTest()
+	m, subscriber := subscribeL3(t, 16)
+
+	placeOn(m, Order{ID: "ask-1", Side: SideSell, Price: 100.0, Quantity: 5})
+	placeOn(m, Order{ID: "ask-2", Side: SideSell, Price: 100.0, Quantity: 4})
+	placeOn(m, Order{ID: "ask-3", Side: SideSell, Price: 100.0, Quantity: 3})
+	placeOn(m, Order{ID: "buy-1", Side: SideBuy, Price: 100.0, Quantity: 7})
+	m.do(func() { cancelOrder(m.symbol, "ask-3") })
+
+	expectL3(t, nextL3(t, subscriber), L3Add, "ask-1", 1, 5, 5)
+	expectL3(t, nextL3(t, subscriber), L3Add, "ask-2", 2, 4, 4)
+	expectL3(t, nextL3(t, subscriber), L3Add, "ask-3", 3, 3, 3)
+	fill := nextL3(t, subscriber)
+	expectL3(t, fill, L3Execute, "ask-1", 1, 0, -5)
+	if fill.TradeID == "" || fill.Symbol != m.symbol || fill.Side != SideSell || fill.Price != 100.0 {
+		t.Errorf("Expected the execution to name its trade, got %+v", fill)
+	}
+	expectL3(t, nextL3(t, subscriber), L3Execute, "ask-2", 1, 2, -2)
+	deleted := nextL3(t, subscriber)
+	expectL3(t, deleted, L3Delete, "ask-3", 2, 3, -3)
+	if deleted.Sequence != 6 {
+		t.Errorf("Expected the sixth event to be numbered 6, got %d", deleted.Sequence)
+	}
+
+	var snapshot L3Snapshot
+	json.NewDecoder(serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/l3/snapshot", nil)).Body).Decode(&snapshot)
+	if snapshot.Sequence != 6 || len(snapshot.Asks) != 1 || snapshot.Asks[0].OrderID != "ask-2" || snapshot.Asks[0].QueuePosition != 1 {
+		t.Errorf("Expected ask-2 alone at sequence 6, got %+v", snapshot)
+	}
+}
+
+func TestL3_MassQuoteAmendments(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "other", Side: SideBuy, Price: 99.0, Quantity: 1})
+	postMassQuote(t, `{"owner": "mm", "quotes": [{"side": "buy", "price": 99.0, "quantity": 5}]}`)
+	_, subscriber := subscribeL3(t, 16)
+
+	postMassQuote(t, `{"owner": "mm", "quotes": [{"side": "buy", "price": 99.0, "quantity": 3}]}`)
+	reduced := nextL3(t, subscriber)
+	expectL3(t, reduced, L3Reduce, reduced.OrderID, 2, 3, -2)
+
+	// Growing a quote sends it to the back of its level
+	postMassQuote(t, `{"owner": "mm", "quotes": [{"side": "buy", "price": 99.0, "quantity": 6}]}`)
+	expectL3(t, nextL3(t, subscriber), L3Delete, reduced.OrderID, 2, 3, -3)
+	expectL3(t, nextL3(t, subscriber), L3Add, reduced.OrderID, 2, 6, 6)
+}
+
+func TestL3_ReplicatedLevelChanges(t *testing.T) {
+	setupTest()
+	m, subscriber := subscribeL3(t, 16)
+	created := time.Now()
+	level := func(orders ...Order) BookChange {
+		return BookChange{Levels: []LevelOrders{{Side: SideSell, Price: 100.0, Orders: orders}}}
+	}
+
+	m.do(func() {
+		m.applyBookChange(level(
+			Order{ID: "a", Side: SideSell, Price: 100.0, Quantity: 5, CreatedAt: created},
+			Order{ID: "b", Side: SideSell, Price: 100.0, Quantity: 5, CreatedAt: created}))
+		m.applyBookChange(level(
+			Order{ID: "b", Side: SideSell, Price: 100.0, Quantity: 2, CreatedAt: created},
+			Order{ID: "c", Side: SideSell, Price: 100.0, Quantity: 1, CreatedAt: created}))
+	})
+
+	expectL3(t, nextL3(t, subscriber), L3Add, "a", 1, 5, 5)
+	expectL3(t, nextL3(t, subscriber), L3Add, "b", 2, 5, 5)
+	expectL3(t, nextL3(t, subscriber), L3Delete, "a", 1, 5, -5)
+	expectL3(t, nextL3(t, subscriber), L3Reduce, "b", 1, 2, -3)
+	expectL3(t, nextL3(t, subscriber), L3Add, "c", 2, 1, 1)
+}
+
+func TestL3Stream_NeedsL3(t *testing.T) {
+	setupTest()
+	server := httptest.NewServer(newServer(tiered))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/v1/l3/stream"
+
+	if _, response, err := websocket.DefaultDialer.Dial(url, http.Header{"X-API-Key": {"l2-key"}}); err == nil || response.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected the l2 key to be refused the L3 stream, got %v", err)
+	}
+
+	conn := dialFeed(t, server, "/api/v1/l3/stream", "l3-key")
+	m, _ := matcherFor("")
+	defer closeStream(t, m, conn)
+	waitForFeeds(t, 1)
+	placeOn(m, Order{ID: "bid-1", Side: SideBuy, Price: 99.0, Quantity: 2})
+
+	var event L3Event
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if err := conn.ReadJSON(&event); err != nil || event.Type != L3Add || event.OrderID != "bid-1" || event.QueuePosition != 1 {
+		t.Errorf("Expected bid-1 to be added, got %+v (%v)", event, err)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// maxLadderTicks caps how many prices a ladder may hold a side
+const maxLadderTicks = 1 << 20
+
+// LadderConfig is the bounded price grid a symbol's levels are held on
+type LadderConfig struct {
+	TickSize float64
+	Min      float64
+	Max      float64
+}
+
+// ticks is how many prices the grid has, both ends included
+func (c LadderConfig) ticks() int {
+	return int(math.Round((c.Max-c.Min)/c.TickSize)) + 1
+}
+
+// tick returns price's place on the grid counted from its bottom. A price
+// off the grid or outside it has none.
+func (c LadderConfig) tick(price float64) (int, bool) {
+	if c.TickSize <= 0 {
+		return 0, false
+	}
+	i := int(math.Round((price - c.Min) / c.TickSize))
+	if i < 0 || i >= c.ticks() || math.Abs(c.Min+float64(i)*c.TickSize-price) > c.TickSize*1e-6 {
+		return 0, false
+	}
+	return i, true
+}
+
+// tickLadders are the ladders each symbol's book is started with. Symbols
+// without one keep every level in a map.
+var tickLadders map[string]LadderConfig
+
+// tickLadder holds one side's levels in an array indexed by tick from the
+// bottom of the grid, so finding a level or the best one takes no search
+type tickLadder struct {
+	grid     LadderConfig
+	buy      bool
+	levels   []PriceLevel
+	occupied int
+	// best is the index of the best occupied level, or -1 when there is none
+	best int
+}
+
+func newTickLadder(config LadderConfig, side Side) *tickLadder {
+	return &tickLadder{
+		grid:   config,
+		buy:    side == SideBuy,
+		levels: make([]PriceLevel, config.ticks()),
+		best:   -1,
+	}
+}
+
+// index returns price's place on the grid. A price off the grid or outside
+// it, or that rounds onto a tick already holding a different price, has none.
+func (l *tickLadder) index(price float64) (int, bool) {
+	i, ok := l.grid.tick(price)
+	if !ok {
+		return 0, false
+	}
+	if level := l.levels[i]; level.Orders > 0 && level.Price != price {
+		return 0, false
+	}
+	return i, true
+}
+
+// better reports whether tick i is a better price than tick j for the side
+func (l *tickLadder) better(i, j int) bool {
+	if l.buy {
+		return i > j
+	}
+	return i < j
+}
+
+// adjust applies a change to the level at tick i, emptying it once nothing
+// rests there and moving the best tick past it
+func (l *tickLadder) adjust(i int, price float64, quantity, orders int) {
+	level := &l.levels[i]
+	wasEmpty := level.Orders == 0
+	level.Price = price
+	level.Quantity += quantity
+	level.Orders += orders
+	if level.Quantity > 0 && level.Orders > 0 {
+		if wasEmpty {
+			l.occupied++
+			if l.best < 0 || l.better(i, l.best) {
+				l.best = i
+			}
+		}
+		return
+	}
+
+	*level = PriceLevel{}
+	if wasEmpty {
+		return
+	}
+	l.occupied--
+	if i == l.best {
+		l.best = l.next(i)
+	}
+}
+
+// next returns the first occupied tick worse than i, or -1
+func (l *tickLadder) next(i int) int {
+	if l.occupied == 0 {
+		return -1
+	}
+	step := 1
+	if l.buy {
+		step = -1
+	}
+	for i += step; i >= 0 && i < len(l.levels); i += step {
+		if l.levels[i].Orders > 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// top collects at most limit occupied levels from the best down, or every
+// level with a limit of zero. It gives up once it has looked at more than
+// budget ticks, so a sparse ladder is left for a walk of the orders instead.
+func (l *tickLadder) top(limit, budget int) ([]PriceLevel, bool) {
+	levels := make([]PriceLevel, 0)
+	scanned := 0
+	for i := l.best; i >= 0 && (limit == 0 || len(levels) < limit); {
+		levels = append(levels, l.levels[i])
+		next := l.next(i)
+		if next >= 0 {
+			if scanned += abs(next - i); scanned > budget {
+				return nil, false
+			}
+		}
+		i = next
+	}
+	return levels, true
+}
+
+// reset empties the ladder, keeping its grid
+func (l *tickLadder) reset() {
+	clear(l.levels)
+	l.occupied, l.best = 0, -1
+}
+
+// useLadder puts a book's levels and their totals on config's grid, or back
+// in a sorted list and a map when config is zero, rebuilding both from the
+// resting orders
+func useLadder(book *OrderBook, config LadderConfig) {
+	book.bids.useGrid(config)
+	book.asks.useGrid(config)
+	book.bidLiquidity, book.askLiquidity = sideLiquidity{}, sideLiquidity{}
+	if config.TickSize > 0 {
+		book.bidLiquidity.ladder = newTickLadder(config, SideBuy)
+		book.askLiquidity.ladder = newTickLadder(config, SideSell)
+	}
+	for _, order := range book.bids.orders() {
+		book.bidLiquidity.adjust(order.Price, order.Quantity, 1)
+	}
+	for _, order := range book.asks.orders() {
+		book.askLiquidity.adjust(order.Price, order.Quantity, 1)
+	}
+}
+
+// sideLevels aggregates at most limit levels of one side, best first, from
+// its ladder when every level is on it and it is dense enough to walk, and
+// from its orders otherwise. A limit of zero keeps every level. It must run
+// on the matcher.
+func sideLevels(liquidity *sideLiquidity, side *bookSide, limit int) []PriceLevel {
+	if liquidity.ladder != nil && len(liquidity.levels) == 0 {
+		if levels, ok := liquidity.ladder.top(limit, side.len()); ok {
+			return levels
+		}
+	}
+	if limit > 0 {
+		return aggregateLevels(side.head(limit), limit)
+	}
+	return aggregateLevels(side.orders(), limit)
+}
+
+// parseTickLadders reads -tick-ladders' symbol=tick:min:max entries, such as
+// BTC-USD=0.01:90:110
+func parseTickLadders(list string) (map[string]LadderConfig, error) {
+	ladders := make(map[string]LadderConfig)
+	for _, item := range splitList(list) {
+		symbol, raw, ok := strings.Cut(item, "=")
+		fields := strings.Split(raw, ":")
+		if !ok || strings.TrimSpace(symbol) == "" || len(fields) != 3 {
+			return nil, fmt.Errorf("-tick-ladders entry %q must be symbol=tick:min:max", item)
+		}
+		var values [3]float64
+		for i, field := range fields {
+			value, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+			if err != nil {
+				return nil, fmt.Errorf("-tick-ladders entry %q must be symbol=tick:min:max", item)
+			}
+			values[i] = value
+		}
+		config := LadderConfig{TickSize: values[0], Min: values[1], Max: values[2]}
+		if config.TickSize <= 0 || config.Min < 0 || config.Max <= config.Min {
+			return nil, fmt.Errorf("-tick-ladders entry %q needs a positive tick and a min below its max", item)
+		}
+		if config.ticks() > maxLadderTicks {
+			return nil, fmt.Errorf("-tick-ladders entry %q spans %d ticks; at most %d are allowed", item, config.ticks(), maxLadderTicks)
+		}
+		ladders[strings.ToUpper(strings.TrimSpace(symbol))] = config
+	}
+	return ladders, nil
+}
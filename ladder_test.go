@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestTickLadder_LevelsAndBestPrice(t *testing.T) {
+	s := sideLiquidity{ladder: newTickLadder(LadderConfig{TickSize: 0.5, Min: 90, Max: 110}, SideBuy)}
+	s.adjust(100, 5, 1)
+	s.adjust(101.5, 3, 1)
+	s.adjust(99, 2, 2)
+	if best := s.ladder.levels[s.ladder.best]; best.Price != 101.5 {
+		t.Fatalf("Expected 101.5 best, got %+v", best)
+	}
+
+	// Off the grid or outside it falls back to the map
+	s.adjust(100.25, 1, 1)
+	s.adjust(120, 1, 1)
+	if len(s.levels) != 2 || s.level(100.25).Quantity != 1 || s.level(120).Quantity != 1 {
+		t.Errorf("Expected the off-grid prices in the map, got %+v", s.levels)
+	}
+	if s.levelCount() != 5 || s.quantity != 12 || s.orders != 6 {
+		t.Errorf("Unexpected totals: %d levels, %d quantity, %d orders", s.levelCount(), s.quantity, s.orders)
+	}
+
+	// Emptying the best level moves the best down to the next one
+	s.adjust(101.5, -3, -1)
+	if best := s.ladder.levels[s.ladder.best]; best.Price != 100 {
+		t.Errorf("Expected 100 best once 101.5 emptied, got %+v", best)
+	}
+	if level := s.level(101.5); level != (PriceLevel{Price: 101.5}) {
+		t.Errorf("Expected the emptied level zero, got %+v", level)
+	}
+	s.adjust(100, -5, -1)
+	s.adjust(99, -2, -2)
+	if s.ladder.best != -1 || s.ladder.occupied != 0 {
+		t.Errorf("Expected an empty ladder, got best %d with %d levels", s.ladder.best, s.ladder.occupied)
+	}
+}
+
+func TestBookSide_LevelsOnTheGrid(t *testing.T) {
+	side := newBookSide(SideSell, 0)
+	side.useGrid(LadderConfig{TickSize: 0.5, Min: 90, Max: 110})
+	for _, order := range []Order{
+		{ID: "a", Price: 101},
+		{ID: "b", Price: 100.25},
+		{ID: "c", Price: 100},
+		{ID: "d", Price: 120},
+		{ID: "e", Price: 101},
+	} {
+		order.Side = SideSell
+		side.insert(order)
+	}
+	ids := func() string {
+		var got string
+		for _, order := range side.orders() {
+			got += order.ID
+		}
+		return got
+	}
+
+	// Prices on the grid are held by tick, the others sorted, and the side
+	// reads them in one price order
+	if side.onTicks != 2 || len(side.levels) != 2 || ids() != "cbaed" {
+		t.Fatalf("Expected 2 levels on ticks and 2 sorted read as cbaed, got %d, %d and %s", side.onTicks, len(side.levels), ids())
+	}
+	if level := side.level(101); len(level) != 2 || level[1].ID != "e" {
+		t.Errorf("Expected a and e at 101, got %+v", level)
+	}
+
+	// Emptying the best tick moves the best to the next one
+	handle, _ := side.find("c")
+	side.remove(handle)
+	if first, _ := side.first(); first.ID != "b" || side.ticks[side.bestTick].price != 101 {
+		t.Errorf("Expected b first and 101 the best tick, got %s and %v", first.ID, side.ticks[side.bestTick].price)
+	}
+	side.sweep(func(order *Order, _ int) bool { return order.Price != 120 })
+	if ids() != "d" || side.onTicks != 0 || len(side.levels) != 1 {
+		t.Errorf("Expected only d left, got %s with %d levels on ticks", ids(), side.onTicks)
+	}
+}
+
+func TestTickLadder_AgreesWithTheBook(t *testing.T) {
+	for seed := int64(1); seed <= 10; seed++ {
+		rng := rand.New(rand.NewSource(seed))
+		sim := newSimulation()
+		// The grid covers the lower half of the prices, so the upper half
+		// rests in the map
+		tickLadders = map[string]LadderConfig{"DEFAULT": {TickSize: 0.5, Min: 90, Max: 100}}
+		resetSymbols([]string{"DEFAULT"})
+		m, _ := matcherFor("")
+
+		for step := 0; step < 300; step++ {
+			op := simOp{
+				cancel:   rng.Intn(6) == 0,
+				side:     SideBuy,
+				price:    95.0 + float64(rng.Intn(21))*0.5,
+				quantity: 1 + rng.Intn(50),
+				target:   rng.Intn(1 << 16),
+			}
+			if rng.Intn(2) == 0 {
+				op.side = SideSell
+			}
+			sim.apply(t, step, op)
+
+			for _, limit := range []int{0, 3} {
+				if got, want := m.depthSnapshot(limit), (DepthSnapshot{
+					Symbol:   m.symbol,
+					Sequence: m.book.sequence,
+					Bids:     aggregateLevels(m.book.bids.orders(), limit),
+					Asks:     aggregateLevels(m.book.asks.orders(), limit),
+				}); !reflect.DeepEqual(got, want) {
+					t.Fatalf("seed %d step %d: expected depth %+v, got %+v", seed, step, want, got)
+				}
+			}
+		}
+	}
+}
+
+func TestTickLadder_KeptAcrossRestore(t *testing.T) {
+	setupTest()
+	tickLadders = map[string]LadderConfig{"DEFAULT": {TickSize: 0.01, Min: 90, Max: 110}}
+	resetSymbols([]string{"DEFAULT"})
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "bid", Side: SideBuy, Price: 100, Quantity: 5})
+
+	m.do(func() {
+		m.restoreBook(BookSnapshot{BuyOrders: []Order{{ID: "restored", Side: SideBuy, Price: 99.5, Quantity: 2, Status: OrderStatusPending}}})
+		if m.book.bidLiquidity.ladder == nil || m.book.bidLiquidity.ladder.occupied != 1 || len(m.book.bidLiquidity.levels) != 0 {
+			t.Fatalf("Expected the restored level on the ladder, got %+v", m.book.bidLiquidity)
+		}
+		if level := m.book.bidLiquidity.level(99.5); level.Quantity != 2 || m.book.bidLiquidity.level(100).Quantity != 0 {
+			t.Errorf("Expected only the restored level, got %+v", level)
+		}
+	})
+}
+
+func TestLoadConfig_TickLadders(t *testing.T) {
+	cfg, err := loadConfig([]string{"-symbols", "BTC-USD,ETH-USD", "-tick-ladders", "btc-usd=0.01:90:110"})
+	if err != nil || cfg.Ladders["BTC-USD"] != (LadderConfig{TickSize: 0.01, Min: 90, Max: 110}) || len(cfg.Ladders) != 1 {
+		t.Fatalf("Unexpected ladders %+v (%v)", cfg.Ladders, err)
+	}
+	for _, list := range []string{"BTC-USD=0.01:90", "BTC-USD=0:90:110", "BTC-USD=0.01:110:90", "BTC-USD=0.0001:0:1000", "DOGE-USD=1:1:2", "BTC-USD=a:1:2"} {
+		if _, err := loadConfig([]string{"-symbols", "BTC-USD", "-tick-ladders", list}); err == nil {
+			t.Errorf("Expected an error for %q", list)
+		}
+	}
+}
+
+// BenchmarkDepth_Publish changes one level of a book and publishes the update
+// to a subscriber, with the book's levels in a map and on a ladder
+func BenchmarkDepth_Publish(b *testing.B) {
+	for _, levels := range benchDepths {
+		for _, ladder := range []bool{false, true} {
+			name := fmt.Sprintf("depth=%d/map", levels)
+			if ladder {
+				name = fmt.Sprintf("depth=%d/ladder", levels)
+			}
+			b.Run(name, func(b *testing.B) {
+				benchBook(b, levels)
+				m, _ := matcherFor("")
+				// Building the book marked every level dirty, and cleared maps keep their size
+				m.book.dirtyBids, m.book.dirtyAsks = nil, nil
+				if ladder {
+					useLadder(m.book, LadderConfig{TickSize: 0.01, Min: 80, Max: 120})
+				}
+				subscriber := &depthSubscriber{updates: make(chan DepthUpdate, 1)}
+				m.subscribers[subscriber] = struct{}{}
+				rng := rand.New(rand.NewSource(1))
+				side, price := SideBuy, 0.0
+
+				// Even steps add one to a random level and odd steps take it back off
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if i%2 == 0 {
+						side = []Side{SideBuy, SideSell}[rng.Intn(2)]
+						price = benchLevel(side, rng.Intn(levels))
+						adjustLevel(m.book, side, price, 1, 0)
+					} else {
+						adjustLevel(m.book, side, price, -1, 0)
+					}
+					m.publishDepth()
+					<-subscriber.updates
+				}
+				b.StopTimer()
+				delete(m.subscribers, subscriber)
+			})
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// latencyWindow is how many recent orders each book keeps timings for
+const latencyWindow = 10000
+
+// OrderTimestamps record when an order reached each stage of the engine
+type OrderTimestamps struct {
+	// ReceivedAt is when the API received the order
+	ReceivedAt time.Time `json:"received_at"`
+	// AcceptedAt is when the symbol's matcher picked it up
+	AcceptedAt time.Time `json:"accepted_at"`
+	// MatchedAt is when its arrival matching event ended, whether it then
+	// traded, rested or was rejected
+	MatchedAt time.Time `json:"matched_at"`
+}
+
+// IsZero reports whether the order has not been received, as an order placed
+// from inside the engine has not until its matcher picks it up
+func (times OrderTimestamps) IsZero() bool {
+	return times.ReceivedAt.IsZero()
+}
+
+// OrderLatency breaks down how long one order spent inside the engine
+type OrderLatency struct {
+	OrderID string `json:"order_id"`
+	// Queue runs from the API receiving the order to its matcher picking it up
+	Queue time.Duration `json:"queue_ns"`
+	// Match runs from the matcher picking the order up to the end of its
+	// arrival matching event
+	Match time.Duration `json:"match_ns"`
+	Total time.Duration `json:"total_ns"`
+}
+
+// latencyOf measures an order from its timestamps, which it must have
+func latencyOf(order Order) OrderLatency {
+	times := order.Timestamps
+	return OrderLatency{
+		OrderID: order.ID,
+		Queue:   times.AcceptedAt.Sub(times.ReceivedAt),
+		Match:   times.MatchedAt.Sub(times.AcceptedAt),
+		Total:   times.MatchedAt.Sub(times.ReceivedAt),
+	}
+}
+
+// stampMatched marks the end of an order's arrival matching event. Stops that
+// fire later keep the time they were first placed.
+func stampMatched(order *Order) {
+	if !order.Timestamps.IsZero() && order.Timestamps.MatchedAt.IsZero() {
+		order.Timestamps.MatchedAt = engineClock.Now()
+	}
+}
+
+// latencySamples is a ring of the most recent order latencies on a book
+type latencySamples struct {
+	samples []OrderLatency
+	next    int
+}
+
+// add records a sample, replacing the oldest once the window is full
+func (s *latencySamples) add(sample OrderLatency) {
+	if len(s.samples) < latencyWindow {
+		s.samples = append(s.samples, sample)
+		return
+	}
+	s.samples[s.next] = sample
+	s.next = (s.next + 1) % latencyWindow
+}
+
+// find returns the sample for an order if it is still in the window
+func (s *latencySamples) find(orderID string) (OrderLatency, bool) {
+	for i := range s.samples {
+		if s.samples[i].OrderID == orderID {
+			return s.samples[i], true
+		}
+	}
+	return OrderLatency{}, false
+}
+
+// LatencyPercentiles summarises one stage of the engine's latency
+type LatencyPercentiles struct {
+	P50  time.Duration `json:"p50_ns"`
+	P90  time.Duration `json:"p90_ns"`
+	P99  time.Duration `json:"p99_ns"`
+	P999 time.Duration `json:"p999_ns"`
+	Max  time.Duration `json:"max_ns"`
+}
+
+// LatencyAnalytics are aggregate timings over a symbol's most recent orders
+type LatencyAnalytics struct {
+	Symbol string             `json:"symbol"`
+	Count  int                `json:"count"`
+	Queue  LatencyPercentiles `json:"queue"`
+	Match  LatencyPercentiles `json:"match"`
+	Total  LatencyPercentiles `json:"total"`
+}
+
+// summarizeLatency computes the percentiles of each stage over samples
+func summarizeLatency(symbol string, samples []OrderLatency) LatencyAnalytics {
+	analytics := LatencyAnalytics{Symbol: symbol, Count: len(samples)}
+	if len(samples) == 0 {
+		return analytics
+	}
+
+	stage := make([]time.Duration, len(samples))
+	summarize := func(pick func(OrderLatency) time.Duration) LatencyPercentiles {
+		for i := range samples {
+			stage[i] = pick(samples[i])
+		}
+		sort.Slice(stage, func(i, j int) bool { return stage[i] < stage[j] })
+		return LatencyPercentiles{
+			P50:  percentile(stage, 50),
+			P90:  percentile(stage, 90),
+			P99:  percentile(stage, 99),
+			P999: percentile(stage, 99.9),
+			Max:  stage[len(stage)-1],
+		}
+	}
+
+	analytics.Queue = summarize(func(l OrderLatency) time.Duration { return l.Queue })
+	analytics.Match = summarize(func(l OrderLatency) time.Duration { return l.Match })
+	analytics.Total = summarize(func(l OrderLatency) time.Duration { return l.Total })
+	return analytics
+}
+
+// percentile returns the nearest-rank percentile of sorted samples
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(p/100*float64(len(sorted))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+// getLatencyAnalyticsHandler returns latency percentiles over a symbol's recent
+// orders, or the latency of one order when order_id is given
+func getLatencyAnalyticsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	m, ok := matcherFor(r.URL.Query().Get("symbol"))
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrCodeUnknownSymbol, "Unknown symbol",
+			"symbol '"+r.URL.Query().Get("symbol")+"' is not traded here")
+		return
+	}
+
+	if orderID := r.URL.Query().Get("order_id"); orderID != "" {
+		var latency OrderLatency
+		var found bool
+		m.do(func() {
+			latency, found = m.book.latency.find(orderID)
+		})
+		if !found {
+			writeError(w, http.StatusNotFound, ErrCodeOrderNotFound, "Order not found",
+				"no recent order with ID '"+orderID+"' on "+m.symbol)
+			return
+		}
+		json.NewEncoder(w).Encode(latency)
+		return
+	}
+
+	// Copy on the matcher and sort off it, so a request never holds up matching
+	var samples []OrderLatency
+	m.do(func() {
+		samples = append(samples, m.book.latency.samples...)
+	})
+	json.NewEncoder(w).Encode(summarizeLatency(m.symbol, samples))
+}
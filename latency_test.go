@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// tickingClock moves forward by step every time it is read, so every stage
+// an order passes through takes a measurable time
+type tickingClock struct {
+	now  time.Time
+	step time.Duration
+}
+
+func (c *tickingClock) Now() time.Time {
+	c.now = c.now.Add(c.step)
+	return c.now
+}
+
+// getLatency calls the latency analytics endpoint
+func getLatency(t *testing.T, target string, out interface{}) int {
+	t.Helper()
+
+	request := httptest.NewRequest("GET", target, nil)
+	response := httptest.NewRecorder()
+	getLatencyAnalyticsHandler(response, request)
+
+	json.NewDecoder(response.Body).Decode(out)
+	return response.Code
+}
+
+func TestLatencySamples_KeepsRecentWindow(t *testing.T) {
+	var samples latencySamples
+	for i := 0; i < latencyWindow+5; i++ {
+		samples.add(OrderLatency{OrderID: string(rune('a' + i%26)), Total: time.Duration(i)})
+	}
+
+	if len(samples.samples) != latencyWindow {
+		t.Fatalf("Expected %d samples, got %d", latencyWindow, len(samples.samples))
+	}
+	// The five oldest samples were overwritten in place
+	if samples.samples[4].Total != latencyWindow+4 || samples.samples[5].Total != 5 {
+		t.Errorf("Expected the oldest samples to be replaced, got %v and %v", samples.samples[4].Total, samples.samples[5].Total)
+	}
+}
+
+func TestSummarizeLatency(t *testing.T) {
+	var samples []OrderLatency
+	for i := 1000; i >= 1; i-- {
+		samples = append(samples, OrderLatency{Queue: time.Duration(i), Match: 2 * time.Duration(i), Total: 3 * time.Duration(i)})
+	}
+
+	analytics := summarizeLatency("DEFAULT", samples)
+
+	if analytics.Count != 1000 {
+		t.Errorf("Expected 1000 samples, got %d", analytics.Count)
+	}
+	want := LatencyPercentiles{P50: 500, P90: 900, P99: 990, P999: 999, Max: 1000}
+	if analytics.Queue != want {
+		t.Errorf("Expected queue percentiles %+v, got %+v", want, analytics.Queue)
+	}
+	if analytics.Total.P50 != 1500 || analytics.Match.Max != 2000 {
+		t.Errorf("Unexpected match or total percentiles %+v %+v", analytics.Match, analytics.Total)
+	}
+
+	if empty := summarizeLatency("DEFAULT", nil); empty.Count != 0 || empty.Total.Max != 0 {
+		t.Errorf("Expected empty analytics with no samples, got %+v", empty)
+	}
+}
+
+func TestOrderTimestamps_ThroughTheAPI(t *testing.T) {
+	setupTest()
+	previousClock := engineClock
+	engineClock = &tickingClock{now: time.Date(2024, 1, 1, 9, 30, 0, 0, time.UTC), step: time.Microsecond}
+	t.Cleanup(func() { engineClock = previousClock })
+
+	jsonData, _ := json.Marshal(PlaceOrderRequest{Side: SideBuy, Price: 100.0, Quantity: 10})
+	request := httptest.NewRequest("POST", "/api/v1/orders", bytes.NewBuffer(jsonData))
+	response := httptest.NewRecorder()
+	placeOrderHandler(response, request)
+
+	var placed PlaceOrderResponse
+	json.NewDecoder(response.Body).Decode(&placed)
+	if placed.Latency == nil || placed.Latency.Queue <= 0 || placed.Latency.Match <= 0 {
+		t.Fatalf("Expected positive queue and match latency, got %+v", placed.Latency)
+	}
+	if placed.Latency.Total != placed.Latency.Queue+placed.Latency.Match {
+		t.Errorf("Expected total to be queue plus match, got %+v", placed.Latency)
+	}
+
+	resting := collectOrders("")
+	if len(resting) != 1 {
+		t.Fatalf("Expected 1 resting order, got %d", len(resting))
+	}
+	order := resting[0]
+	times := order.Timestamps
+	if !times.ReceivedAt.Before(times.AcceptedAt) || !times.AcceptedAt.Before(times.MatchedAt) {
+		t.Errorf("Expected received < accepted < matched, got %+v", times)
+	}
+	if latencyOf(order) != *placed.Latency {
+		t.Errorf("Expected the resting order to carry the reported timings, got %+v", latencyOf(order))
+	}
+
+	var latency OrderLatency
+	if code := getLatency(t, "/api/v1/analytics/latency?order_id="+placed.OrderID, &latency); code != http.StatusOK || latency != *placed.Latency {
+		t.Errorf("Expected the order's latency from the endpoint, got %d %+v", code, latency)
+	}
+}
+
+func TestLatencyAnalyticsHandler(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+
+	placeOn(m, Order{ID: "ask-1", Side: SideSell, Price: 100.0, Quantity: 10})
+	placeOn(m, Order{ID: "buy-1", Side: SideBuy, Price: 100.0, Quantity: 10})
+	// Rejected orders are timed too
+	placeOn(m, Order{ID: "buy-2", Side: SideBuy, Price: 100.0, Quantity: 10, TimeInForce: TimeInForceIOC})
+
+	var analytics LatencyAnalytics
+	if code := getLatency(t, "/api/v1/analytics/latency", &analytics); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if analytics.Symbol != "DEFAULT" || analytics.Count != 3 {
+		t.Errorf("Expected 3 samples for DEFAULT, got %+v", analytics)
+	}
+	if analytics.Total.Max < analytics.Total.P50 {
+		t.Errorf("Expected max to be at least the median, got %+v", analytics.Total)
+	}
+
+	var missing OrderLatency
+	if code := getLatency(t, "/api/v1/analytics/latency?order_id=missing", &missing); code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown order, got %d", code)
+	}
+	if code := getLatency(t, "/api/v1/analytics/latency?symbol=NOPE", &analytics); code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown symbol, got %d", code)
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The ledger's own accounts, the other side of movements into and out of the
+// venue. They cannot be opened through the API.
+const (
+	// externalAccount is where deposits come from and withdrawals go to
+	externalAccount = "external"
+	// feeAccount collects trading fees
+	feeAccount = "fees"
+	// clearingAccount is the other side of perpetual profit, loss and funding
+	clearingAccount = "clearing"
+	// insuranceAccount collects liquidation fees and covers losses beyond a
+	// liquidated account's balance
+	insuranceAccount = "insurance"
+)
+
+// defaultQuoteAsset prices symbols whose name is not a base-quote pair
+const defaultQuoteAsset = "USD"
+
+// ledgerTolerance absorbs float rounding when debits and credits are compared
+const ledgerTolerance = 1e-6
+
+// FeeSchedule is what each side of a trade pays, in basis points of its
+// notional, charged in the symbol's quote asset. A negative MakerBps is a
+// rebate paid to the maker.
+type FeeSchedule struct {
+	MakerBps float64
+	TakerBps float64
+	// Tiers replace the base fees for accounts whose volume over Window has
+	// reached them, lowest volume first
+	Tiers  []FeeTier
+	Window time.Duration
+}
+
+// fees is the schedule settled trades are charged under
+var fees FeeSchedule
+
+// TradeFees is what each side of a settled trade paid, in Asset. A negative
+// fee is a rebate.
+type TradeFees struct {
+	Asset    string  `json:"asset"`
+	MakerBps float64 `json:"maker_bps"`
+	MakerFee float64 `json:"maker_fee"`
+	TakerBps float64 `json:"taker_bps"`
+	TakerFee float64 `json:"taker_fee"`
+}
+
+// LedgerEntry is one side of a double-entry posting. An account's balance in
+// an asset is its credits less its debits.
+type LedgerEntry struct {
+	Sequence      int64             `json:"sequence"`
+	TransactionID string            `json:"transaction_id"`
+	Account       string            `json:"account"`
+	Asset         string            `json:"asset"`
+	Type          BalanceChangeType `json:"type"`
+	Debit         float64           `json:"debit,omitempty"`
+	Credit        float64           `json:"credit,omitempty"`
+	// Balance is the account's balance in the asset after the entry
+	Balance   float64   `json:"balance"`
+	Reference string    `json:"reference,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LedgerTotal is the sum of every entry in one asset
+type LedgerTotal struct {
+	Asset   string  `json:"asset"`
+	Debits  float64 `json:"debits"`
+	Credits float64 `json:"credits"`
+}
+
+// LedgerCheck is the result of reconciling the ledger
+type LedgerCheck struct {
+	// Balanced is true when every asset's debits equal its credits and every
+	// balance matches the entries that made it
+	Balanced bool          `json:"balanced"`
+	Totals   []LedgerTotal `json:"totals"`
+	Problems []string      `json:"problems,omitempty"`
+}
+
+// LedgerResponse lists ledger entries, oldest first, with the reconciliation
+// of the whole ledger
+type LedgerResponse struct {
+	Entries []LedgerEntry `json:"entries"`
+	Count   int           `json:"count"`
+	LedgerCheck
+}
+
+// posting moves amount of asset into an account, or out of it when negative
+type posting struct {
+	account string
+	asset   string
+	amount  float64
+}
+
+// ledgerEntries and ledgerBalances are guarded by accountsMu
+var (
+	ledgerEntries  []LedgerEntry
+	ledgerBalances = make(map[string]map[string]float64)
+)
+
+// postLocked records postings as one transaction and applies them to the
+// balances. Each asset's postings must sum to zero, so that every debit has a
+// matching credit. It must be called with accountsMu held.
+func postLocked(kind BalanceChangeType, reference string, postings []posting) []LedgerEntry {
+	transactionID := generateOrderID()
+	now := engineClock.Now()
+
+	entries := make([]LedgerEntry, 0, len(postings))
+	for _, p := range postings {
+		balances, ok := ledgerBalances[p.account]
+		if !ok {
+			balances = make(map[string]float64)
+			ledgerBalances[p.account] = balances
+		}
+		balances[p.asset] += p.amount
+
+		entry := LedgerEntry{
+			Sequence:      int64(len(ledgerEntries)) + 1,
+			TransactionID: transactionID,
+			Account:       p.account,
+			Asset:         p.asset,
+			Type:          kind,
+			Balance:       balances[p.asset],
+			Reference:     reference,
+			CreatedAt:     now,
+		}
+		if p.amount < 0 {
+			entry.Debit = -p.amount
+		} else {
+			entry.Credit = p.amount
+		}
+		ledgerEntries = append(ledgerEntries, entry)
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// balanceChange is the entry as its account's history shows it
+func (e LedgerEntry) balanceChange() BalanceChange {
+	return BalanceChange{
+		Sequence:      e.Sequence,
+		TransactionID: e.TransactionID,
+		AccountID:     e.Account,
+		Asset:         e.Asset,
+		Type:          e.Type,
+		Amount:        e.Credit - e.Debit,
+		Balance:       e.Balance,
+		Reference:     e.Reference,
+		CreatedAt:     e.CreatedAt,
+	}
+}
+
+// symbolAssets returns a symbol's base and quote assets: its configured pair's,
+// or its name's split such as BTC-USD or BTC/USD. Any other symbol is its own
+// base, quoted in defaultQuoteAsset.
+func symbolAssets(symbol string) (base, quote string) {
+	if pair, ok := pairs[symbol]; ok {
+		return pair.Base, pair.Quote
+	}
+	if base, quote, ok := strings.Cut(symbol, "-"); ok {
+		return base, quote
+	}
+	if base, quote, ok := strings.Cut(symbol, "/"); ok {
+		return base, quote
+	}
+	return symbol, defaultQuoteAsset
+}
+
+// settleTrade records a local trade's amounts, moves its base quantity from
+// seller to buyer and its quote notional back the other way, then charges
+// each side its fee. Options move contracts for a premium, in the
+// underlying's settlement asset. Perpetual
+// trades move positions instead, and pay fees in the settlement asset. A
+// trade is only settled when the maker's and the taker's owners both have an
+// account; balances may go negative, since orders are not checked against them.
+// The fees charged are recorded on the trade.
+func settleTrade(trade *Trade, makerOwner, takerOwner string) {
+	accountsMu.Lock()
+	defer accountsMu.Unlock()
+
+	setTradeAmountsLocked(trade)
+	_, makerOK := accounts[makerOwner]
+	_, takerOK := accounts[takerOwner]
+	if !makerOK || !takerOK {
+		return
+	}
+	// Sub-accounts sharing their parent's balances settle on the parent's
+	makerOwner, takerOwner = fundingAccountLocked(makerOwner), fundingAccountLocked(takerOwner)
+
+	buyer, seller := takerOwner, makerOwner
+	if trade.AggressorSide == SideSell {
+		buyer, seller = makerOwner, takerOwner
+	}
+	notional := trade.QuoteNotional
+	if _, ok := perpetuals[trade.Symbol]; ok {
+		settlePerpetualLocked(*trade, buyer, seller, BalanceRealizedPnL)
+		trade.Fees = chargeFeesLocked(*trade, makerOwner, takerOwner, perpetualAsset(trade.Symbol), notional)
+		return
+	}
+
+	// Option contracts are held as an asset named after the option, and
+	// written ones as a negative balance, for a premium in the settlement asset
+	base, quote, quantity := trade.BaseAsset, trade.QuoteAsset, trade.BaseQuantity
+	postLocked(BalanceTrade, trade.ID, []posting{
+		{account: seller, asset: base, amount: -quantity},
+		{account: buyer, asset: base, amount: quantity},
+		{account: buyer, asset: quote, amount: -notional},
+		{account: seller, asset: quote, amount: notional},
+	})
+	trade.Fees = chargeFeesLocked(*trade, makerOwner, takerOwner, quote, notional)
+}
+
+// chargeFeesLocked charges the maker and the taker their fees on a trade's
+// notional, in asset, at the fee tier each had reached before it, then counts
+// the notional towards their tiers. A negative fee is paid to its side out of
+// the fee account as a rebate. Each fee charged is then offered to the
+// settlement hook. It must be called with accountsMu held.
+func chargeFeesLocked(trade Trade, makerOwner, takerOwner, asset string, notional float64) *TradeFees {
+	now := engineClock.Now()
+	_, makerTier := feeTierLocked(makerOwner, now)
+	_, takerTier := feeTierLocked(takerOwner, now)
+	addVolumeLocked(makerOwner, now, notional, 0)
+	addVolumeLocked(takerOwner, now, 0, notional)
+
+	charged := &TradeFees{
+		Asset:    asset,
+		MakerBps: makerTier.MakerBps,
+		MakerFee: notional * makerTier.MakerBps / 10000,
+		TakerBps: takerTier.TakerBps,
+		TakerFee: notional * takerTier.TakerBps / 10000,
+	}
+	var charges, rebates []posting
+	var shared []SettledFee
+	for _, fee := range []struct {
+		owner     string
+		liquidity Liquidity
+		amount    float64
+	}{{makerOwner, LiquidityMaker, charged.MakerFee}, {takerOwner, LiquidityTaker, charged.TakerFee}} {
+		switch {
+		case fee.amount > 0:
+			charges = append(charges,
+				posting{account: fee.owner, asset: asset, amount: -fee.amount},
+				posting{account: feeAccount, asset: asset, amount: fee.amount})
+			shared = append(shared, SettledFee{TradeID: trade.ID, Symbol: trade.Symbol, AccountID: fee.owner, Liquidity: fee.liquidity, Asset: asset, Amount: fee.amount})
+		case fee.amount < 0:
+			rebates = append(rebates,
+				posting{account: feeAccount, asset: asset, amount: fee.amount},
+				posting{account: fee.owner, asset: asset, amount: -fee.amount})
+		}
+	}
+	if len(charges) > 0 {
+		postLocked(BalanceFee, trade.ID, charges)
+	}
+	if len(rebates) > 0 {
+		postLocked(BalanceRebate, trade.ID, rebates)
+	}
+	for _, fee := range shared {
+		shareFeeLocked(fee)
+	}
+	return charged
+}
+
+// checkLedgerLocked reconciles the ledger: each asset's debits must equal its
+// credits, and replaying the entries must give the balances that are kept.
+// It must be called with accountsMu held.
+func checkLedgerLocked() LedgerCheck {
+	totals := make(map[string]*LedgerTotal)
+	replayed := make(map[string]map[string]float64)
+	for _, entry := range ledgerEntries {
+		total, ok := totals[entry.Asset]
+		if !ok {
+			total = &LedgerTotal{Asset: entry.Asset}
+			totals[entry.Asset] = total
+		}
+		total.Debits += entry.Debit
+		total.Credits += entry.Credit
+
+		if replayed[entry.Account] == nil {
+			replayed[entry.Account] = make(map[string]float64)
+		}
+		replayed[entry.Account][entry.Asset] += entry.Credit - entry.Debit
+	}
+
+	check := LedgerCheck{Totals: make([]LedgerTotal, 0, len(totals))}
+	for _, total := range totals {
+		check.Totals = append(check.Totals, *total)
+		if math.Abs(total.Debits-total.Credits) > ledgerTolerance {
+			check.Problems = append(check.Problems, fmt.Sprintf("%s debits of %g do not equal credits of %g", total.Asset, total.Debits, total.Credits))
+		}
+	}
+	sort.Slice(check.Totals, func(i, j int) bool { return check.Totals[i].Asset < check.Totals[j].Asset })
+
+	for account, balances := range ledgerBalances {
+		for asset, balance := range balances {
+			if want := replayed[account][asset]; math.Abs(balance-want) > ledgerTolerance {
+				check.Problems = append(check.Problems, fmt.Sprintf("%s holds %g %s but its entries add up to %g", account, balance, asset, want))
+			}
+		}
+	}
+	sort.Strings(check.Problems)
+	check.Balanced = len(check.Problems) == 0
+	return check
+}
+
+// getLedgerHandler returns ledger entries, optionally for one account or
+// asset, with the reconciliation of the whole ledger
+func getLedgerHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	account, asset := r.URL.Query().Get("account"), r.URL.Query().Get("asset")
+	accountsMu.Lock()
+	entries := make([]LedgerEntry, 0)
+	for _, entry := range ledgerEntries {
+		if (account == "" || entry.Account == account) && (asset == "" || entry.Asset == asset) {
+			entries = append(entries, entry)
+		}
+	}
+	check := checkLedgerLocked()
+	accountsMu.Unlock()
+
+	json.NewEncoder(w).Encode(LedgerResponse{
+		Entries:     entries,
+		Count:       len(entries),
+		LedgerCheck: check,
+	})
+}
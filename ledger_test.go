@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+// openFunded opens an account and deposits each asset into it
+func openFunded(t *testing.T, id string, deposits map[string]float64) {
+	t.Helper()
+	if _, ok := createAccount(CreateAccountRequest{ID: id}); !ok {
+		t.Fatalf("Expected to open %s", id)
+	}
+	for asset, amount := range deposits {
+		if _, code := adjustBalance(id, asset, amount, BalanceDeposit); code != "" {
+			t.Fatalf("Expected to deposit %g %s into %s, got %s", amount, asset, id, code)
+		}
+	}
+}
+
+func TestSettleTrade_PostsBothLegsAndFees(t *testing.T) {
+	setupTest()
+	resetSymbols([]string{"BTC-USD"})
+	fees = FeeSchedule{MakerBps: 10, TakerBps: 20}
+	openFunded(t, "maker", map[string]float64{"BTC": 5})
+	openFunded(t, "taker", map[string]float64{"USD": 1000})
+
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "ask-1", Symbol: "BTC-USD", Side: SideSell, Price: 100.0, Quantity: 3, Owner: "maker"})
+	placeOn(m, Order{ID: "buy-1", Symbol: "BTC-USD", Side: SideBuy, Price: 100.0, Quantity: 2, Owner: "taker"})
+
+	maker, _ := lookupAccount("maker")
+	taker, _ := lookupAccount("taker")
+	if maker.Balances["BTC"] != 3 || !approxEqual(maker.Balances["USD"], 199.8) {
+		t.Errorf("Expected the maker to hold 3 BTC and 199.80 USD, got %+v", maker.Balances)
+	}
+	if taker.Balances["BTC"] != 2 || !approxEqual(taker.Balances["USD"], 799.6) {
+		t.Errorf("Expected the taker to hold 2 BTC and 799.60 USD, got %+v", taker.Balances)
+	}
+
+	trade := tradeStore.List("")[0]
+	history, _ := balanceHistory("taker", "USD")
+	if len(history) != 3 || history[1].Type != BalanceTrade || history[2].Type != BalanceFee || history[2].Reference != trade.ID {
+		t.Errorf("Expected a deposit, the trade and its fee, got %+v", history)
+	}
+
+	response := serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/ledger?account=fees", nil))
+	var ledger LedgerResponse
+	json.NewDecoder(response.Body).Decode(&ledger)
+	if ledger.Count != 2 || !approxEqual(ledger.Entries[0].Credit+ledger.Entries[1].Credit, 0.6) {
+		t.Errorf("Expected the fee account to collect 0.60 USD, got %+v", ledger.Entries)
+	}
+	if !ledger.Balanced || len(ledger.Totals) != 2 {
+		t.Errorf("Expected a balanced ledger in two assets, got %+v", ledger.LedgerCheck)
+	}
+}
+
+func TestSettleTrade_PaysMakerRebates(t *testing.T) {
+	setupTest()
+	resetSymbols([]string{"BTC-USD"})
+	fees = FeeSchedule{MakerBps: -5, TakerBps: 20}
+	openFunded(t, "maker", map[string]float64{"BTC": 5})
+	openFunded(t, "taker", map[string]float64{"USD": 1000})
+	server := httptest.NewServer(newServer(HTTPConfig{KeyOwners: map[string]string{"maker-key": "maker"}}))
+	defer server.Close()
+	private := dialFeed(t, server, "/api/v1/feed/private", "maker-key")
+	defer private.Close()
+	waitForFeeds(t, 1)
+
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "ask-1", Symbol: "BTC-USD", Side: SideSell, Price: 100.0, Quantity: 3, Owner: "maker"})
+	placeOn(m, Order{ID: "buy-1", Symbol: "BTC-USD", Side: SideBuy, Price: 100.0, Quantity: 2, Owner: "taker"})
+
+	trade := tradeStore.List("")[0]
+	if trade.Fees == nil || trade.Fees.Asset != "USD" || trade.Fees.MakerBps != -5 || !approxEqual(trade.Fees.MakerFee, -0.1) || !approxEqual(trade.Fees.TakerFee, 0.4) {
+		t.Errorf("Expected the trade to record a 0.10 rebate and a 0.40 fee, got %+v", trade.Fees)
+	}
+
+	maker, _ := lookupAccount("maker")
+	if !approxEqual(maker.Balances["USD"], 200.1) {
+		t.Errorf("Expected the maker to be paid 0.10 USD on top of the notional, got %+v", maker.Balances)
+	}
+	history, _ := balanceHistory("maker", "USD")
+	if len(history) != 2 || history[1].Type != BalanceRebate || !approxEqual(history[1].Amount, 0.1) || history[1].Reference != trade.ID {
+		t.Errorf("Expected the trade and its rebate, got %+v", history)
+	}
+	accountsMu.Lock()
+	kept, check := ledgerBalances[feeAccount]["USD"], checkLedgerLocked()
+	accountsMu.Unlock()
+	if !approxEqual(kept, 0.3) || !check.Balanced {
+		t.Errorf("Expected the fee account to keep 0.30 USD in a balanced ledger, got %g, %+v", kept, check)
+	}
+
+	fill, raw := readFeed(t, private, FeedMessageFill)
+	if !approxEqual(fill.Fill.Fee, -0.1) || fill.Fill.FeeAsset != "USD" {
+		t.Errorf("Expected the maker's fill to report its rebate, got %s", raw)
+	}
+}
+
+func TestSettleTrade_NeedsBothOwnersToHaveAccounts(t *testing.T) {
+	setupTest()
+	openFunded(t, "maker", map[string]float64{"DEFAULT": 5})
+
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "ask-1", Side: SideSell, Price: 100.0, Quantity: 3, Owner: "maker"})
+	placeOn(m, Order{ID: "buy-1", Side: SideBuy, Price: 100.0, Quantity: 2, Owner: "anonymous"})
+
+	if history, _ := balanceHistory("maker", ""); len(history) != 1 {
+		t.Errorf("Expected only the deposit, got %+v", history)
+	}
+}
+
+func TestCheckLedger_FindsUnbalancedPostings(t *testing.T) {
+	setupTest()
+	openFunded(t, "alice", map[string]float64{"USD": 100})
+
+	accountsMu.Lock()
+	defer accountsMu.Unlock()
+	if check := checkLedgerLocked(); !check.Balanced {
+		t.Fatalf("Expected a balanced ledger, got %+v", check)
+	}
+
+	// A posting without its other side, and a balance changed behind the ledger's back
+	postLocked(BalanceDeposit, "", []posting{{account: "alice", asset: "USD", amount: 5}})
+	ledgerBalances["alice"]["EUR"] = 1
+	if check := checkLedgerLocked(); check.Balanced || len(check.Problems) != 2 {
+		t.Errorf("Expected two problems, got %+v", check)
+	}
+}
+
+func TestLoadConfig_FeeFlags(t *testing.T) {
+	cfg, err := loadConfig([]string{"-maker-fee-bps", "1.5", "-taker-fee-bps", "5"})
+	if err != nil || cfg.Fees.MakerBps != 1.5 || cfg.Fees.TakerBps != 5 {
+		t.Errorf("Unexpected fees %+v (%v)", cfg.Fees, err)
+	}
+	if cfg, err := loadConfig([]string{"-maker-fee-bps", "-2", "-taker-fee-bps", "5"}); err != nil || cfg.Fees.MakerBps != -2 {
+		t.Errorf("Expected a maker rebate, got %+v (%v)", cfg.Fees, err)
+	}
+	for _, args := range [][]string{{"-taker-fee-bps", "-1"}, {"-maker-fee-bps", "-6", "-taker-fee-bps", "5"}} {
+		if _, err := loadConfig(args); err == nil {
+			t.Errorf("%v: expected the fees to be refused", args)
+		}
+	}
+}
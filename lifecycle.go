@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// OrderEvent records a single order status transition
+type OrderEvent struct {
+	Sequence  int         `json:"sequence"`
+	OrderID   string      `json:"order_id"`
+	From      OrderStatus `json:"from,omitempty"`
+	To        OrderStatus `json:"to"`
+	Reason    string      `json:"reason,omitempty"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// CancelOrderResponse carries the cancelled order in its final state
+type CancelOrderResponse struct {
+	Order Order `json:"order"`
+}
+
+// OrderEventsResponse lists order status changes in the order they happened
+type OrderEventsResponse struct {
+	Events []OrderEvent `json:"events"`
+	Count  int          `json:"count"`
+	// Epoch is the run of sequence numbers the events belong to
+	Epoch string `json:"epoch,omitempty"`
+}
+
+var orderEvents []OrderEvent
+
+// lastEventSequence is the sequence of the newest order event. It keeps
+// counting when archived events are trimmed from orderEvents.
+var lastEventSequence int
+
+// orderTransitions lists the statuses each status may move to.
+// Filled, cancelled, rejected and expired are terminal.
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	OrderStatusPending: {
+		OrderStatusPartiallyFilled,
+		OrderStatusFilled,
+		OrderStatusPendingCancel,
+		OrderStatusRejected,
+		OrderStatusExpired,
+	},
+	OrderStatusPartiallyFilled: {
+		OrderStatusFilled,
+		OrderStatusPendingCancel,
+		OrderStatusExpired,
+	},
+	// A fill may still land while a cancel is in flight
+	OrderStatusPendingCancel: {
+		OrderStatusCancelled,
+		OrderStatusPartiallyFilled,
+		OrderStatusFilled,
+	},
+	// A stop becomes a live pending order when it triggers
+	OrderStatusUntriggered: {
+		OrderStatusPending,
+		OrderStatusPendingCancel,
+		OrderStatusRejected,
+		OrderStatusExpired,
+	},
+}
+
+// canTransition reports whether an order may move from one status to another
+func canTransition(from, to OrderStatus) bool {
+	for _, next := range orderTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// isTerminalStatus reports whether an order with this status has left the book for good
+func isTerminalStatus(status OrderStatus) bool {
+	return len(orderTransitions[status]) == 0
+}
+
+// transitionOrder moves an order to a new status and records the change.
+// Staying in the same status is a no-op and emits no event.
+func transitionOrder(order *Order, to OrderStatus, reason string) error {
+	if order.Status == to {
+		return nil
+	}
+	if !canTransition(order.Status, to) {
+		return fmt.Errorf("invalid status transition for order %s: %s -> %s", order.ID, order.Status, to)
+	}
+
+	from := order.Status
+	order.Status = to
+	recordOrderEvent(order.ID, from, to, reason)
+	feed.order(*order, reason)
+	readModel.status(*order)
+	if isTerminalStatus(to) {
+		recordClosedOrder(*order)
+	}
+	return nil
+}
+
+// recordOrderEvent appends a status-change event to the event log
+func recordOrderEvent(orderID string, from, to OrderStatus, reason string) {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	logOrderEvent(OrderEvent{
+		Sequence:  lastEventSequence + 1,
+		OrderID:   orderID,
+		From:      from,
+		To:        to,
+		Reason:    reason,
+		CreatedAt: engineClock.Now(),
+	})
+}
+
+// logOrderEvent appends an event to the log and the replication stream. The
+// caller must hold historyMu.
+func logOrderEvent(event OrderEvent) {
+	orderEvents = append(orderEvents, event)
+	lastEventSequence = event.Sequence
+	if replication.publishing() {
+		published := event
+		replication.publish(ReplicationMessage{Event: &published})
+	}
+}
+
+// logTransitionError reports a transition the engine attempted but the state machine refused
+func logTransitionError(err error) {
+	log.Printf("order lifecycle: %v", err)
+}
+
+// isExpired reports whether an order's expiry time has been reached
+func isExpired(order Order, now time.Time) bool {
+	return order.ExpiresAt != nil && !order.ExpiresAt.After(now)
+}
+
+// expireOrders removes every resting order in a book whose expiry time has
+// passed. A standby leaves expiry to its primary, whose removals it replicates.
+func expireOrders(book *OrderBook, now time.Time) []Order {
+	if standby.running.Load() {
+		return nil
+	}
+
+	var expired []Order
+	book.nextExpiry = time.Time{}
+	expired = expireResting(book, &book.bids, now, expired)
+	expired = expireResting(book, &book.asks, now, expired)
+	book.stops, expired = expireSide(book, book.stops, now, expired)
+	expired = expirePaper(book, now, expired)
+	if len(expired) > 0 {
+		repricePegs(book)
+	}
+	return expired
+}
+
+// expireResting takes expired orders off one side of the book and notes the
+// earliest expiry among the orders it keeps
+func expireResting(book *OrderBook, side *bookSide, now time.Time, expired []Order) []Order {
+	side.sweep(func(order *Order, position int) bool {
+		if !isExpired(*order, now) {
+			noteExpiry(book, *order)
+			return false
+		}
+		if err := transitionOrder(order, OrderStatusExpired, "expiry time reached"); err != nil {
+			logTransitionError(err)
+		}
+		adjustLevel(book, order.Side, order.Price, -order.Quantity, -1)
+		publishL3(book, L3Delete, *order, position, -order.Quantity, "")
+		expired = append(expired, *order)
+		return true
+	})
+	return expired
+}
+
+// expireSide filters expired orders out of the book's stops and notes the
+// earliest expiry among the orders it keeps
+func expireSide(book *OrderBook, orders []Order, now time.Time, expired []Order) ([]Order, []Order) {
+	kept := orders[:0]
+	for _, order := range orders {
+		if isExpired(order, now) {
+			if err := transitionOrder(&order, OrderStatusExpired, "expiry time reached"); err != nil {
+				logTransitionError(err)
+			}
+			expired = append(expired, order)
+			continue
+		}
+		noteExpiry(book, order)
+		kept = append(kept, order)
+	}
+	return kept, expired
+}
+
+// noteExpiry pulls a book's next expiry forward if a resting order expires sooner
+func noteExpiry(book *OrderBook, order Order) {
+	if order.ExpiresAt != nil && (book.nextExpiry.IsZero() || order.ExpiresAt.Before(book.nextExpiry)) {
+		book.nextExpiry = *order.ExpiresAt
+	}
+}
+
+// cancelOrder removes a resting order from its symbol's book, passing through
+// pending_cancel. It must run on that symbol's matcher.
+func cancelOrder(symbol, orderID string) (Order, bool) {
+	book := bookFor(symbol)
+	if side, handle, ok := findResting(book, orderID); ok {
+		order, position := takeResting(book, side, handle)
+		markCancelled(&order)
+		adjustLevel(book, order.Side, order.Price, -order.Quantity, -1)
+		publishL3(book, L3Delete, order, position, -order.Quantity, "")
+		repricePegs(book)
+		surveilCancel(order)
+		return order, true
+	}
+	order, _, ok := cancelFromSide(&book.stops, orderID)
+	if !ok && book.auction != nil {
+		order, _, ok = cancelFromSide(&book.auction.pending, orderID)
+	}
+	if !ok {
+		order, _, ok = cancelFromSide(&book.paper, orderID)
+	}
+	return order, ok
+}
+
+// cancelOnAnySymbol cancels an order on the given symbol, or searches every
+// symbol when none is given, charging the order's owner's throttle. It
+// returns ORDER_NOT_FOUND if no symbol has the order, THROTTLED if the
+// owner has not saved up the weight of a cancel, or REQUEST_TIMEOUT if ctx
+// ended before a matcher got to the cancel.
+func cancelOnAnySymbol(ctx context.Context, symbol, orderID string) (Order, ErrorCode) {
+	for _, m := range allMatchers() {
+		if symbol != "" && m.symbol != symbol {
+			continue
+		}
+
+		var order Order
+		var code ErrorCode
+		found := false
+		if err := m.doContext(ctx, func() {
+			expireOrders(m.book, engineClock.Now())
+			booked, ok := bookedOrder(m.book, orderID)
+			if !ok {
+				return
+			}
+			found = true
+			if !throttleAllows(booked.Owner, ThrottleCancel) {
+				order, code = booked, ErrCodeThrottled
+				return
+			}
+			order, _ = cancelOrder(m.symbol, orderID)
+		}); err != nil {
+			return Order{}, ErrCodeRequestTimeout
+		}
+		if found {
+			return order, code
+		}
+	}
+	return Order{}, ErrCodeOrderNotFound
+}
+
+// bookedOrder finds an order resting in a book, waiting as a stop or a paper
+// order, or held for the next batch auction. It must run on the book's matcher.
+func bookedOrder(book *OrderBook, orderID string) (Order, bool) {
+	if side, handle, ok := findResting(book, orderID); ok {
+		return side.at(handle), true
+	}
+	sides := [][]Order{book.stops, book.paper}
+	if book.auction != nil {
+		sides = append(sides, book.auction.pending)
+	}
+	for _, orders := range sides {
+		for _, order := range orders {
+			if order.ID == orderID {
+				return order, true
+			}
+		}
+	}
+	return Order{}, false
+}
+
+// cancelFromSide cancels an order on one side of the book if present,
+// returning the index it was at
+func cancelFromSide(orders *[]Order, orderID string) (Order, int, bool) {
+	for i := range *orders {
+		if (*orders)[i].ID == orderID {
+			return cancelAt(orders, i), i, true
+		}
+	}
+	return Order{}, 0, false
+}
+
+// cancelAt cancels the order at index i of a side and takes it off
+func cancelAt(orders *[]Order, i int) Order {
+	order := (*orders)[i]
+	markCancelled(&order)
+	*orders = append((*orders)[:i], (*orders)[i+1:]...)
+	return order
+}
+
+// markCancelled moves an order through pending_cancel to cancelled
+func markCancelled(order *Order) {
+	if err := transitionOrder(order, OrderStatusPendingCancel, "cancel requested"); err != nil {
+		logTransitionError(err)
+	}
+	if err := transitionOrder(order, OrderStatusCancelled, "cancelled by user"); err != nil {
+		logTransitionError(err)
+	}
+}
+
+// cancelOrderHandler cancels the resting order named in the path
+func cancelOrderHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	orderID := r.PathValue("id")
+	order, code := cancelOnAnySymbol(r.Context(), r.URL.Query().Get("symbol"), orderID)
+	switch code {
+	case ErrCodeOrderNotFound:
+		writeOrderNotFound(w, orderID)
+		return
+	case ErrCodeThrottled:
+		writeThrottled(w, order.Owner, ThrottleCancel)
+		return
+	case ErrCodeRequestTimeout:
+		writeRequestTimeout(w, r.Context().Err())
+		return
+	}
+
+	json.NewEncoder(w).Encode(CancelOrderResponse{Order: order})
+}
+
+// getOrderEventsHandler returns the order status-change log, or the part of
+// it for the order named in the path or query
+func getOrderEventsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	expireAllSymbols()
+
+	orderID := r.PathValue("id")
+	if orderID == "" {
+		orderID = r.URL.Query().Get("order_id")
+	}
+	historyMu.Lock()
+	events := make([]OrderEvent, 0, len(orderEvents))
+	for _, event := range orderEvents {
+		if orderID == "" || event.OrderID == orderID {
+			events = append(events, event)
+		}
+	}
+	historyMu.Unlock()
+
+	json.NewEncoder(w).Encode(OrderEventsResponse{
+		Events: events,
+		Count:  len(events),
+		Epoch:  currentEpoch(),
+	})
+}
+
+// writeOrderNotFound reports an order ID with no resting order
+func writeOrderNotFound(w http.ResponseWriter, orderID string) {
+	writeError(w, http.StatusNotFound, ErrCodeOrderNotFound, "Order not found",
+		"No resting order with id '"+orderID+"'")
+}
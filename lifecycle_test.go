@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from, to OrderStatus
+		expected bool
+	}{
+		{OrderStatusPending, OrderStatusPartiallyFilled, true},
+		{OrderStatusPending, OrderStatusFilled, true},
+		{OrderStatusPending, OrderStatusRejected, true},
+		{OrderStatusPending, OrderStatusExpired, true},
+		{OrderStatusPending, OrderStatusCancelled, false},
+		{OrderStatusPartiallyFilled, OrderStatusPendingCancel, true},
+		{OrderStatusPartiallyFilled, OrderStatusRejected, false},
+		{OrderStatusPendingCancel, OrderStatusCancelled, true},
+		{OrderStatusPendingCancel, OrderStatusFilled, true},
+		{OrderStatusFilled, OrderStatusCancelled, false},
+		{OrderStatusCancelled, OrderStatusPending, false},
+		{OrderStatusExpired, OrderStatusFilled, false},
+	}
+
+	for _, test := range tests {
+		result := canTransition(test.from, test.to)
+		if result != test.expected {
+			t.Errorf("canTransition(%s, %s) = %v, expected %v", test.from, test.to, result, test.expected)
+		}
+	}
+}
+
+func TestIsTerminalStatus(t *testing.T) {
+	terminal := []OrderStatus{OrderStatusFilled, OrderStatusCancelled, OrderStatusRejected, OrderStatusExpired}
+	for _, status := range terminal {
+		if !isTerminalStatus(status) {
+			t.Errorf("Expected %s to be terminal", status)
+		}
+	}
+
+	open := []OrderStatus{OrderStatusPending, OrderStatusPartiallyFilled, OrderStatusPendingCancel}
+	for _, status := range open {
+		if isTerminalStatus(status) {
+			t.Errorf("Expected %s not to be terminal", status)
+		}
+	}
+}
+
+func TestTransitionOrder_InvalidTransition(t *testing.T) {
+	setupTest()
+
+	order := Order{ID: "order-1", Status: OrderStatusFilled}
+
+	if err := transitionOrder(&order, OrderStatusPending, "reopen"); err == nil {
+		t.Error("Expected error when leaving a terminal status")
+	}
+
+	if order.Status != OrderStatusFilled {
+		t.Errorf("Expected status to stay filled, got %s", order.Status)
+	}
+
+	if len(orderEvents) != 0 {
+		t.Errorf("Expected no events for a refused transition, got %d", len(orderEvents))
+	}
+}
+
+func TestTransitionOrder_SameStatusIsNoop(t *testing.T) {
+	setupTest()
+
+	order := Order{ID: "order-1", Status: OrderStatusPartiallyFilled}
+
+	if err := transitionOrder(&order, OrderStatusPartiallyFilled, "partially filled"); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+
+	if len(orderEvents) != 0 {
+		t.Errorf("Expected no events, got %d", len(orderEvents))
+	}
+}
+
+func TestProcessOrder_EmitsStatusEvents(t *testing.T) {
+	setupTest()
+
+	sellOrder := Order{
+		ID:        "sell-1",
+		Side:      SideSell,
+		Price:     100.0,
+		Quantity:  10,
+		Status:    OrderStatusPending,
+		CreatedAt: time.Now(),
+	}
+	restOn(&bookFor("").asks, sellOrder)
+
+	buyOrder := Order{
+		ID:        "buy-1",
+		Side:      SideBuy,
+		Price:     101.0,
+		Quantity:  5,
+		Status:    OrderStatusPending,
+		CreatedAt: time.Now(),
+	}
+
+	result := processOrder(buyOrder)
+
+	if result.Status != OrderStatusFilled {
+		t.Errorf("Expected returned order to be filled, got %s", result.Status)
+	}
+
+	// accepted (buy-1), partially filled (sell-1), filled (buy-1)
+	if len(orderEvents) != 3 {
+		t.Fatalf("Expected 3 events, got %d", len(orderEvents))
+	}
+
+	if orderEvents[0].OrderID != "buy-1" || orderEvents[0].To != OrderStatusPending {
+		t.Errorf("Expected first event to accept buy-1, got %+v", orderEvents[0])
+	}
+
+	if orderEvents[1].OrderID != "sell-1" || orderEvents[1].To != OrderStatusPartiallyFilled {
+		t.Errorf("Expected second event to partially fill sell-1, got %+v", orderEvents[1])
+	}
+
+	if orderEvents[2].From != OrderStatusPending || orderEvents[2].To != OrderStatusFilled {
+		t.Errorf("Expected third event pending -> filled, got %+v", orderEvents[2])
+	}
+
+	for i, event := range orderEvents {
+		if event.Sequence != i+1 {
+			t.Errorf("Expected event %d to have sequence %d, got %d", i, i+1, event.Sequence)
+		}
+	}
+}
+
+func TestProcessOrder_RejectsExpiredOrder(t *testing.T) {
+	setupTest()
+
+	past := time.Now().Add(-time.Minute)
+	order := Order{
+		ID:        "buy-1",
+		Side:      SideBuy,
+		Price:     100.0,
+		Quantity:  10,
+		Status:    OrderStatusPending,
+		CreatedAt: time.Now(),
+		ExpiresAt: &past,
+	}
+
+	result := processOrder(order)
+
+	if result.Status != OrderStatusRejected {
+		t.Errorf("Expected order to be rejected, got %s", result.Status)
+	}
+
+	if len(bookFor("").bids.orders()) != 0 {
+		t.Errorf("Expected rejected order to stay out of the book, got %d buy orders", len(bookFor("").bids.orders()))
+	}
+}
+
+func TestExpireOrders(t *testing.T) {
+	setupTest()
+
+	now := time.Now()
+	soon := now.Add(time.Second)
+	later := now.Add(time.Hour)
+	restOn(&bookFor("").bids,
+		Order{ID: "buy-1", Side: SideBuy, Price: 100.0, Quantity: 10, Status: OrderStatusPending, CreatedAt: now, ExpiresAt: &soon},
+		Order{ID: "buy-2", Side: SideBuy, Price: 99.0, Quantity: 10, Status: OrderStatusPending, CreatedAt: now},
+	)
+	restOn(&bookFor("").asks,
+		Order{ID: "sell-1", Side: SideSell, Price: 101.0, Quantity: 10, Status: OrderStatusPartiallyFilled, CreatedAt: now, ExpiresAt: &later},
+	)
+
+	expired := expireOrders(bookFor(""), now.Add(2*time.Second))
+
+	if len(expired) != 1 || expired[0].ID != "buy-1" {
+		t.Fatalf("Expected only buy-1 to expire, got %+v", expired)
+	}
+
+	if expired[0].Status != OrderStatusExpired {
+		t.Errorf("Expected expired status, got %s", expired[0].Status)
+	}
+
+	if len(bookFor("").bids.orders()) != 1 || bookFor("").bids.orders()[0].ID != "buy-2" {
+		t.Errorf("Expected buy-2 to remain, got %+v", bookFor("").bids.orders())
+	}
+
+	if len(bookFor("").asks.orders()) != 1 {
+		t.Errorf("Expected sell-1 to remain, got %d sell orders", len(bookFor("").asks.orders()))
+	}
+}
+
+func TestProcessOrder_TracksNextExpiry(t *testing.T) {
+	setupTest()
+
+	now := time.Now()
+	soon := now.Add(time.Minute)
+	later := now.Add(time.Hour)
+	processOrder(Order{ID: "buy-1", Side: SideBuy, Price: 99.0, Quantity: 10, Status: OrderStatusPending, CreatedAt: now, ExpiresAt: &later})
+	processOrder(Order{ID: "buy-2", Side: SideBuy, Price: 98.0, Quantity: 10, Status: OrderStatusPending, CreatedAt: now, ExpiresAt: &soon})
+
+	if !bookFor("").nextExpiry.Equal(soon) {
+		t.Errorf("Expected next expiry %s, got %s", soon, bookFor("").nextExpiry)
+	}
+
+	expireOrders(bookFor(""), soon)
+
+	if !bookFor("").nextExpiry.Equal(later) {
+		t.Errorf("Expected next expiry to move to %s, got %s", later, bookFor("").nextExpiry)
+	}
+
+	expireOrders(bookFor(""), later)
+
+	if !bookFor("").nextExpiry.IsZero() {
+		t.Errorf("Expected no next expiry once the book has none, got %s", bookFor("").nextExpiry)
+	}
+}
+
+func TestCancelOrderHandler(t *testing.T) {
+	setupTest()
+
+	restOn(&bookFor("").bids, Order{
+		ID:        "buy-1",
+		Side:      SideBuy,
+		Price:     100.0,
+		Quantity:  10,
+		Status:    OrderStatusPending,
+		CreatedAt: time.Now(),
+	})
+
+	request := httptest.NewRequest("DELETE", "/api/v1/orders/buy-1", nil)
+	response := serve(HTTPConfig{}, request)
+
+	if response.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", response.Code)
+	}
+
+	var result struct {
+		Order Order `json:"order"`
+	}
+	json.Unmarshal(response.Body.Bytes(), &result)
+
+	if result.Order.Status != OrderStatusCancelled {
+		t.Errorf("Expected cancelled status, got %s", result.Order.Status)
+	}
+
+	if len(bookFor("").bids.orders()) != 0 {
+		t.Errorf("Expected order to be removed from the book, got %d buy orders", len(bookFor("").bids.orders()))
+	}
+
+	if len(orderEvents) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(orderEvents))
+	}
+
+	if orderEvents[0].To != OrderStatusPendingCancel || orderEvents[1].To != OrderStatusCancelled {
+		t.Errorf("Expected pending_cancel then cancelled, got %s then %s", orderEvents[0].To, orderEvents[1].To)
+	}
+}
+
+func TestCancelOrderHandler_NotFound(t *testing.T) {
+	setupTest()
+
+	request := httptest.NewRequest("DELETE", "/api/v1/orders/missing", nil)
+	response := serve(HTTPConfig{}, request)
+
+	if response.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", response.Code)
+	}
+	if result := decodeError(t, response); result.Error.Code != ErrCodeOrderNotFound {
+		t.Errorf("Expected code %s, got %s", ErrCodeOrderNotFound, result.Error.Code)
+	}
+}
+
+func TestCancelOrderHandler_MissingOrderID(t *testing.T) {
+	setupTest()
+
+	// Without an ID the request addresses the collection, which cannot be deleted
+	request := httptest.NewRequest("DELETE", "/api/v1/orders", nil)
+	response := serve(HTTPConfig{}, request)
+
+	if response.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405, got %d", response.Code)
+	}
+}
+
+func TestGetOrderEventsHandler_FilterByOrder(t *testing.T) {
+	setupTest()
+
+	recordOrderEvent("order-1", "", OrderStatusPending, "order accepted")
+	recordOrderEvent("order-2", "", OrderStatusPending, "order accepted")
+	recordOrderEvent("order-1", OrderStatusPending, OrderStatusFilled, "fully filled")
+
+	request := httptest.NewRequest("GET", "/api/v1/order-events?order_id=order-1", nil)
+	response := httptest.NewRecorder()
+
+	getOrderEventsHandler(response, request)
+
+	if response.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", response.Code)
+	}
+
+	var result map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &result)
+
+	if result["count"].(float64) != 2 {
+		t.Errorf("Expected 2 events, got %.0f", result["count"].(float64))
+	}
+}
+
+func TestGetOrderEventsHandler_ByPath(t *testing.T) {
+	setupTest()
+
+	recordOrderEvent("order-1", "", OrderStatusPending, "order accepted")
+	recordOrderEvent("order-2", "", OrderStatusPending, "order accepted")
+
+	response := serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/orders/order-2/events", nil))
+
+	var result OrderEventsResponse
+	json.Unmarshal(response.Body.Bytes(), &result)
+	if result.Count != 1 || result.Events[0].OrderID != "order-2" {
+		t.Errorf("Expected order-2's event only, got %+v", result)
+	}
+}
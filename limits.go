@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PositionLimitConfig caps the net position an account may build in a symbol
+type PositionLimitConfig struct {
+	// Limits are the largest positions, in lots either way, by symbol
+	Limits map[string]int
+	// Trim cuts an order that would pass its limit down to what fits, where
+	// rejecting it is the default
+	Trim bool
+}
+
+// positionLimits holds the limits orders are checked against
+var positionLimits PositionLimitConfig
+
+// PositionLimitUsage is how much of its limit in a symbol an account uses
+type PositionLimitUsage struct {
+	Symbol string `json:"symbol"`
+	Limit  int    `json:"limit"`
+	// Position is the account's net position in lots: its perpetual position,
+	// or its balance of the symbol's base asset
+	Position     float64 `json:"position"`
+	RestingBuys  int     `json:"resting_buys"`
+	RestingSells int     `json:"resting_sells"`
+	// Exposure is the largest position the account could reach if all its
+	// resting buys or all its resting sells filled, and Utilization is that
+	// as a fraction of the limit
+	Exposure    float64 `json:"exposure"`
+	Utilization float64 `json:"utilization"`
+}
+
+// netPositionLocked is an owner's net position in a symbol, in lots. It must
+// be called with accountsMu held.
+func netPositionLocked(owner, symbol string) float64 {
+	if _, ok := perpetuals[symbol]; ok {
+		if position := positions[owner][symbol]; position != nil {
+			return float64(position.Quantity)
+		}
+		return 0
+	}
+	pair := pairForLocked(symbol)
+	return ledgerBalances[owner][pair.Base] / pair.LotSize
+}
+
+// positionLimitsLocked returns the position limits an account trades under,
+// by symbol: -position-limits, with the account's own limits in their place
+// where it has them. It must be called with accountsMu held.
+func positionLimitsLocked(id string) map[string]int {
+	account := accounts[id]
+	if account == nil || len(account.PositionLimits) == 0 {
+		return positionLimits.Limits
+	}
+	limits := make(map[string]int, len(positionLimits.Limits)+len(account.PositionLimits))
+	for symbol, limit := range positionLimits.Limits {
+		limits[symbol] = limit
+	}
+	for symbol, limit := range account.PositionLimits {
+		limits[symbol] = limit
+	}
+	return limits
+}
+
+// checkPositionLimit refuses an order that would take its owner's exposure in
+// the symbol past its limit, counting the owner's resting orders as filled.
+// With trimming on, the order is cut down to what fits instead, unless
+// nothing does or its fill conditions no longer could be met. Orders that
+// bring the exposure back under the limit always pass, as do owners without
+// an account. A sub-account sharing its parent's balances is held to the
+// parent's limit, with the orders of every account sharing it. It must run on
+// the symbol's matcher.
+func checkPositionLimit(book *OrderBook, order *Order) ErrorCode {
+	if order.Owner == "" {
+		return ""
+	}
+	accountsMu.Lock()
+	_, account := accounts[order.Owner]
+	funding := fundingAccountLocked(order.Owner)
+	limit, limited := positionLimitsLocked(funding)[order.Symbol]
+	if !account || !limited {
+		accountsMu.Unlock()
+		return ""
+	}
+	held := netPositionLocked(funding, order.Symbol)
+	group := riskGroupLocked(order.Owner)
+	accountsMu.Unlock()
+
+	buys, sells := restingQuantity(book, group)
+	room := float64(limit) - held - float64(buys)
+	if order.Side == SideSell {
+		room = float64(limit) + held - float64(sells)
+	}
+	if float64(order.Quantity) <= room+ledgerTolerance {
+		return ""
+	}
+
+	fits := int(math.Floor(room + ledgerTolerance))
+	if positionLimits.Trim && fits > 0 && !order.AllOrNone && fits >= order.MinQuantity {
+		recordOrderEvent(order.ID, order.Status, order.Status,
+			fmt.Sprintf("quantity trimmed from %d to %d by the position limit of %d", order.Quantity, fits, limit))
+		order.Quantity = fits
+		return ""
+	}
+	return ErrCodePositionLimit
+}
+
+// positionLimitUsage reports an account's use of every symbol's position
+// limit, by symbol. A sub-account sharing its parent's balances reports the
+// parent's.
+func positionLimitUsage(id string) []PositionLimitUsage {
+	accountsMu.Lock()
+	funding := fundingAccountLocked(id)
+	limits := positionLimitsLocked(funding)
+	group := riskGroupLocked(id)
+	accountsMu.Unlock()
+
+	// Resting orders are read before the positions: the matchers take
+	// accountsMu when trades settle
+	resting := make(map[string][2]int)
+	for _, m := range allMatchers() {
+		if _, ok := limits[m.symbol]; ok {
+			m.do(func() {
+				buys, sells := restingQuantity(m.book, group)
+				resting[m.symbol] = [2]int{buys, sells}
+			})
+		}
+	}
+
+	accountsMu.Lock()
+	defer accountsMu.Unlock()
+	usage := make([]PositionLimitUsage, 0, len(resting))
+	for symbol, orders := range resting {
+		limit := limits[symbol]
+		held := netPositionLocked(funding, symbol)
+		exposure := math.Max(math.Abs(held+float64(orders[0])), math.Abs(held-float64(orders[1])))
+		usage = append(usage, PositionLimitUsage{
+			Symbol:       symbol,
+			Limit:        limit,
+			Position:     held,
+			RestingBuys:  orders[0],
+			RestingSells: orders[1],
+			Exposure:     exposure,
+			Utilization:  exposure / float64(limit),
+		})
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Symbol < usage[j].Symbol })
+	return usage
+}
+
+// parsePositionLimits reads -position-limits entries such as BTC-PERP=100, a
+// symbol's largest position in lots
+func parsePositionLimits(list string) (map[string]int, error) {
+	limits := make(map[string]int)
+	for _, item := range splitList(list) {
+		symbol, raw, ok := strings.Cut(item, "=")
+		limit, err := strconv.Atoi(strings.TrimSpace(raw))
+		if !ok || strings.TrimSpace(symbol) == "" || err != nil || limit <= 0 {
+			return nil, fmt.Errorf("-position-limits entry %q must be symbol=lots with a positive number of lots", item)
+		}
+		limits[strings.ToUpper(strings.TrimSpace(symbol))] = limit
+	}
+	return limits, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPositionLimits_RejectOrdersPastTheCap(t *testing.T) {
+	setupTest()
+	resetSymbols([]string{"BTC-PERP"})
+	listPerpetual("BTC-PERP")
+	positionLimits = PositionLimitConfig{Limits: map[string]int{"BTC-PERP": 10}}
+	openFunded(t, "alice", map[string]float64{"USD": 10000})
+	m, _ := matcherFor("BTC-PERP")
+
+	if placed := processOn(m, Order{ID: "bid", Symbol: "BTC-PERP", Side: SideBuy, Price: 99, Quantity: 6, Owner: "alice"}); placed.Status != OrderStatusPending {
+		t.Fatalf("Expected the bid to rest, got %+v", placed)
+	}
+	// The resting bid counts as filled
+	if placed := processOn(m, Order{ID: "more", Symbol: "BTC-PERP", Side: SideBuy, Price: 98, Quantity: 5, Owner: "alice"}); placed.RejectReason != ErrCodePositionLimit {
+		t.Errorf("Expected POSITION_LIMIT_EXCEEDED, got %+v", placed)
+	}
+	// Sells are measured from the position the other way
+	if placed := processOn(m, Order{ID: "too-short", Symbol: "BTC-PERP", Side: SideSell, Price: 105, Quantity: 11, Owner: "alice"}); placed.RejectReason != ErrCodePositionLimit {
+		t.Errorf("Expected a sell past the limit refused, got %+v", placed)
+	}
+	if placed := processOn(m, Order{ID: "ask", Symbol: "BTC-PERP", Side: SideSell, Price: 105, Quantity: 10, Owner: "alice"}); placed.Status != OrderStatusPending {
+		t.Errorf("Expected a sell up to the limit to rest, got %+v", placed)
+	}
+	// Owners without an account are not limited
+	if placed := processOn(m, Order{ID: "anon", Symbol: "BTC-PERP", Side: SideBuy, Price: 90, Quantity: 50, Owner: "bob"}); placed.Status != OrderStatusPending {
+		t.Errorf("Expected an owner without an account to rest, got %+v", placed)
+	}
+}
+
+func TestPositionLimits_TrimToWhatFits(t *testing.T) {
+	setupTest()
+	positionLimits = PositionLimitConfig{Limits: map[string]int{defaultSymbol: 10}, Trim: true}
+	openFunded(t, "alice", map[string]float64{defaultSymbol: 4})
+	m, _ := matcherFor("")
+
+	placed := processOn(m, Order{ID: "bid", Symbol: defaultSymbol, Side: SideBuy, Price: 99, Quantity: 8, Owner: "alice"})
+	if placed.Status != OrderStatusPending || placed.Quantity != 6 {
+		t.Fatalf("Expected the bid trimmed to 6, got %+v", placed)
+	}
+	if event := orderEvents[len(orderEvents)-1]; event.OrderID != "bid" || !strings.Contains(event.Reason, "trimmed from 8 to 6") {
+		t.Errorf("Expected the trim recorded, got %+v", event)
+	}
+	// All-or-none orders cannot be trimmed, and full ones have nothing to trim to
+	if placed := processOn(m, Order{ID: "aon", Symbol: defaultSymbol, Side: SideSell, Price: 101, Quantity: 15, AllOrNone: true, Owner: "alice"}); placed.RejectReason != ErrCodePositionLimit {
+		t.Errorf("Expected the all-or-none order refused, got %+v", placed)
+	}
+	if placed := processOn(m, Order{ID: "full", Symbol: defaultSymbol, Side: SideBuy, Price: 98, Quantity: 1, Owner: "alice"}); placed.RejectReason != ErrCodePositionLimit {
+		t.Errorf("Expected a buy with no room refused, got %+v", placed)
+	}
+
+	var response PositionsResponse
+	json.NewDecoder(serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/accounts/alice/positions", nil)).Body).Decode(&response)
+	want := PositionLimitUsage{Symbol: defaultSymbol, Limit: 10, Position: 4, RestingBuys: 6, Exposure: 10, Utilization: 1}
+	if len(response.Limits) != 1 || response.Limits[0] != want {
+		t.Errorf("Expected %+v, got %+v", want, response.Limits)
+	}
+}
+
+func TestLoadConfig_PositionLimits(t *testing.T) {
+	cfg, err := loadConfig([]string{"-symbols", "BTC-USD", "-position-limits", "btc-usd=25", "-position-limit-trim"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.PositionLimits.Limits["BTC-USD"] != 25 || !cfg.PositionLimits.Trim {
+		t.Errorf("Expected a trimmed limit of 25, got %+v", cfg.PositionLimits)
+	}
+	for _, list := range []string{"BTC-USD=0", "BTC-USD=x", "OTHER=5"} {
+		if _, err := loadConfig([]string{"-symbols", "BTC-USD", "-position-limits", list}); err == nil {
+			t.Errorf("Expected %q refused", list)
+		}
+	}
+}
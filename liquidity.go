@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// defaultLiquidityTicks and defaultTickSize set the window depth is measured in
+	defaultLiquidityTicks = 10
+	defaultTickSize       = 0.01
+)
+
+// sideLiquidity holds one side's resting totals. It is kept up to date as
+// orders rest, fill, cancel and expire, so reading it never scans the book.
+type sideLiquidity struct {
+	// ladder holds the levels on the symbol's tick grid, when it has one;
+	// levels holds the rest
+	ladder   *tickLadder
+	levels   map[float64]PriceLevel
+	quantity int
+	orders   int
+}
+
+// adjust applies a change in resting quantity and order count at one price
+func (s *sideLiquidity) adjust(price float64, quantity, orders int) {
+	s.quantity += quantity
+	s.orders += orders
+	if i, ok := s.tick(price); ok {
+		s.ladder.adjust(i, price, quantity, orders)
+		return
+	}
+
+	if s.levels == nil {
+		s.levels = make(map[float64]PriceLevel)
+	}
+
+	level := s.levels[price]
+	level.Price = price
+	level.Quantity += quantity
+	level.Orders += orders
+	if level.Quantity <= 0 || level.Orders <= 0 {
+		delete(s.levels, price)
+	} else {
+		s.levels[price] = level
+	}
+}
+
+// level returns the totals resting at price, which are zero once it has
+// left the book
+func (s *sideLiquidity) level(price float64) PriceLevel {
+	if i, ok := s.tick(price); ok {
+		level := s.ladder.levels[i]
+		level.Price = price
+		return level
+	}
+	level := s.levels[price]
+	level.Price = price
+	return level
+}
+
+// tick returns price's place on the side's ladder. A price already kept in
+// the map stays there until it leaves the book.
+func (s *sideLiquidity) tick(price float64) (int, bool) {
+	if s.ladder == nil {
+		return 0, false
+	}
+	if _, ok := s.levels[price]; ok {
+		return 0, false
+	}
+	return s.ladder.index(price)
+}
+
+// levelCount returns how many prices have orders resting
+func (s *sideLiquidity) levelCount() int {
+	if s.ladder != nil {
+		return len(s.levels) + s.ladder.occupied
+	}
+	return len(s.levels)
+}
+
+// reset empties the side, keeping its ladder's grid
+func (s *sideLiquidity) reset() {
+	if s.ladder != nil {
+		s.ladder.reset()
+	}
+	*s = sideLiquidity{ladder: s.ladder}
+}
+
+// adjustLevel records a change to the resting quantity at a price: it updates
+// the side's liquidity totals and marks the level dirty for depth subscribers
+func adjustLevel(book *OrderBook, side Side, price float64, quantity, orders int) {
+	if side == SideBuy {
+		book.bidLiquidity.adjust(price, quantity, orders)
+	} else {
+		book.askLiquidity.adjust(price, quantity, orders)
+	}
+	markDirty(book, side, price)
+}
+
+// SideLiquidity describes the resting liquidity on one side of a book
+type SideLiquidity struct {
+	Quantity int `json:"quantity"`
+	Orders   int `json:"orders"`
+	Levels   int `json:"levels"`
+	// AverageLevelQuantity and AverageLevelOrders are the mean queue at each price level
+	AverageLevelQuantity float64 `json:"avg_level_quantity"`
+	AverageLevelOrders   float64 `json:"avg_level_orders"`
+	// DepthNearMid is the quantity resting within the requested ticks of the mid
+	DepthNearMid int `json:"depth_near_mid"`
+}
+
+// LiquidityAnalytics summarises how much liquidity a symbol's book holds and
+// how it is split between the sides
+type LiquidityAnalytics struct {
+	Symbol   string  `json:"symbol"`
+	Sequence int64   `json:"sequence"`
+	Mid      float64 `json:"mid,omitempty"`
+	Ticks    int     `json:"ticks"`
+	TickSize float64 `json:"tick_size"`
+	// VolumeImbalance runs from -1 (all asks) to 1 (all bids) over the whole book
+	VolumeImbalance float64       `json:"volume_imbalance"`
+	Bid             SideLiquidity `json:"bid"`
+	Ask             SideLiquidity `json:"ask"`
+}
+
+// liquidity reports the book's liquidity, counting depth within ticks of the
+// mid. It must run on the matcher.
+func (m *matcher) liquidity(ticks int, tickSize float64) LiquidityAnalytics {
+	book := m.book
+	analytics := LiquidityAnalytics{
+		Symbol:   m.symbol,
+		Sequence: book.sequence,
+		Ticks:    ticks,
+		TickSize: tickSize,
+		Bid:      book.bidLiquidity.summary(),
+		Ask:      book.askLiquidity.summary(),
+	}
+
+	if total := analytics.Bid.Quantity + analytics.Ask.Quantity; total > 0 {
+		analytics.VolumeImbalance = float64(analytics.Bid.Quantity-analytics.Ask.Quantity) / float64(total)
+	}
+
+	bid, bidOK := book.bids.first()
+	ask, askOK := book.asks.first()
+	if bidOK && askOK {
+		analytics.Mid = (bid.Price + ask.Price) / 2
+		// Allow for rounding so a level exactly on the edge of the window counts
+		window := float64(ticks)*tickSize + 1e-9
+		analytics.Bid.DepthNearMid = book.bidLiquidity.depthWithin(&book.bids, analytics.Mid, window)
+		analytics.Ask.DepthNearMid = book.askLiquidity.depthWithin(&book.asks, analytics.Mid, window)
+	}
+	return analytics
+}
+
+// summary reports a side's totals and average queue per level
+func (s *sideLiquidity) summary() SideLiquidity {
+	summary := SideLiquidity{Quantity: s.quantity, Orders: s.orders, Levels: s.levelCount()}
+	if summary.Levels > 0 {
+		summary.AverageLevelQuantity = float64(s.quantity) / float64(summary.Levels)
+		summary.AverageLevelOrders = float64(s.orders) / float64(summary.Levels)
+	}
+	return summary
+}
+
+// depthWithin sums the level totals within window of mid, visiting the
+// side's levels from the best and stopping at the first outside it, so no
+// order is read
+func (s *sideLiquidity) depthWithin(side *bookSide, mid, window float64) int {
+	total := 0
+	side.eachLevel(func(level *restingLevel) bool {
+		if math.Abs(level.price-mid) > window {
+			return false
+		}
+		total += s.level(level.price).Quantity
+		return true
+	})
+	return total
+}
+
+// getLiquidityAnalyticsHandler returns a symbol's volume imbalance, depth near
+// the mid and average queue size per level
+func getLiquidityAnalyticsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	m, ok := matcherFor(r.URL.Query().Get("symbol"))
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrCodeUnknownSymbol, "Unknown symbol",
+			"symbol '"+r.URL.Query().Get("symbol")+"' is not traded here")
+		return
+	}
+
+	var validationErrors []string
+	ticks, tickSize := defaultLiquidityTicks, defaultTickSize
+	if raw := r.URL.Query().Get("ticks"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 0 {
+			validationErrors = append(validationErrors, "ticks must be a non-negative integer (received: '"+raw+"')")
+		}
+		ticks = parsed
+	}
+	if raw := r.URL.Query().Get("tick_size"); raw != "" {
+		parsed, err := strconv.ParseFloat(raw, 64)
+		if err != nil || !(parsed > 0) || math.IsInf(parsed, 0) {
+			validationErrors = append(validationErrors, "tick_size must be a positive number (received: '"+raw+"')")
+		}
+		tickSize = parsed
+	}
+	if len(validationErrors) > 0 {
+		writeError(w, http.StatusBadRequest, ErrCodeValidationFailed, "Validation failed", validationErrors)
+		return
+	}
+
+	var analytics LiquidityAnalytics
+	m.do(func() {
+		expireOrders(m.book, engineClock.Now())
+		m.publishDepth()
+		analytics = m.liquidity(ticks, tickSize)
+	})
+	json.NewEncoder(w).Encode(analytics)
+}
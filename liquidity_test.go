@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// getLiquidity calls the liquidity analytics endpoint
+func getLiquidity(t *testing.T, target string) (LiquidityAnalytics, int) {
+	t.Helper()
+
+	request := httptest.NewRequest("GET", target, nil)
+	response := httptest.NewRecorder()
+	getLiquidityAnalyticsHandler(response, request)
+
+	var analytics LiquidityAnalytics
+	json.NewDecoder(response.Body).Decode(&analytics)
+	return analytics, response.Code
+}
+
+func TestLiquidityAnalytics_FromBook(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+
+	placeOn(m, Order{ID: "bid-1", Side: SideBuy, Price: 99.95, Quantity: 10})
+	placeOn(m, Order{ID: "bid-2", Side: SideBuy, Price: 99.95, Quantity: 20})
+	placeOn(m, Order{ID: "bid-3", Side: SideBuy, Price: 99.0, Quantity: 30})
+	placeOn(m, Order{ID: "ask-1", Side: SideSell, Price: 100.05, Quantity: 40})
+
+	analytics, code := getLiquidity(t, "/api/v1/analytics/liquidity?ticks=10&tick_size=0.01")
+	if code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+
+	if analytics.Mid != 100.0 || analytics.Sequence != 4 {
+		t.Errorf("Expected mid 100.00 at sequence 4, got %+v", analytics)
+	}
+
+	bid := analytics.Bid
+	if bid.Quantity != 60 || bid.Orders != 3 || bid.Levels != 2 || bid.AverageLevelQuantity != 30 || bid.AverageLevelOrders != 1.5 {
+		t.Errorf("Unexpected bid liquidity %+v", bid)
+	}
+
+	// bid-3 is 100 ticks from the mid, outside the window
+	if bid.DepthNearMid != 30 || analytics.Ask.DepthNearMid != 40 {
+		t.Errorf("Expected 30 bid and 40 ask near the mid, got %d and %d", bid.DepthNearMid, analytics.Ask.DepthNearMid)
+	}
+
+	if !approxEqual(analytics.VolumeImbalance, 0.2) {
+		t.Errorf("Expected volume imbalance 0.2, got %f", analytics.VolumeImbalance)
+	}
+}
+
+func TestLiquidityAnalytics_FollowsFillsAndCancels(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+
+	placeOn(m, Order{ID: "ask-1", Side: SideSell, Price: 101.0, Quantity: 10})
+	placeOn(m, Order{ID: "ask-2", Side: SideSell, Price: 102.0, Quantity: 10})
+	placeOn(m, Order{ID: "buy-1", Side: SideBuy, Price: 101.0, Quantity: 4})
+
+	analytics, _ := getLiquidity(t, "/api/v1/analytics/liquidity")
+	if analytics.Ask.Quantity != 16 || analytics.Ask.Levels != 2 || analytics.Ask.Orders != 2 {
+		t.Errorf("Expected a partial fill to leave 16 on two levels, got %+v", analytics.Ask)
+	}
+
+	m.do(func() { cancelOrder("", "ask-1") })
+
+	analytics, _ = getLiquidity(t, "/api/v1/analytics/liquidity")
+	if analytics.Ask.Quantity != 10 || analytics.Ask.Levels != 1 || analytics.Mid != 0 {
+		t.Errorf("Expected one ask level and no mid after the cancel, got %+v", analytics)
+	}
+	if analytics.VolumeImbalance != -1 {
+		t.Errorf("Expected an all-ask book to have imbalance -1, got %f", analytics.VolumeImbalance)
+	}
+}
+
+func TestLiquidityAnalytics_DepthNearMidOnATickLadder(t *testing.T) {
+	setupTest()
+	tickLadders = map[string]LadderConfig{"DEFAULT": {TickSize: 0.05, Min: 90, Max: 110}}
+	resetSymbols([]string{"DEFAULT"})
+	m, _ := matcherFor("")
+
+	// 99.97 is off the grid, and 99.5 on it but outside the window
+	placeOn(m, Order{ID: "bid-1", Side: SideBuy, Price: 99.95, Quantity: 10})
+	placeOn(m, Order{ID: "bid-2", Side: SideBuy, Price: 99.97, Quantity: 5})
+	placeOn(m, Order{ID: "bid-3", Side: SideBuy, Price: 99.5, Quantity: 30})
+	placeOn(m, Order{ID: "ask-1", Side: SideSell, Price: 100.05, Quantity: 40})
+	placeOn(m, Order{ID: "ask-2", Side: SideSell, Price: 100.05, Quantity: 2})
+
+	analytics, _ := getLiquidity(t, "/api/v1/analytics/liquidity?ticks=10&tick_size=0.01")
+	if analytics.Bid.DepthNearMid != 15 || analytics.Ask.DepthNearMid != 42 {
+		t.Errorf("Expected 15 bid and 42 ask near the mid, got %d and %d", analytics.Bid.DepthNearMid, analytics.Ask.DepthNearMid)
+	}
+}
+
+func TestLiquidityAnalyticsHandler_Validation(t *testing.T) {
+	setupTest()
+
+	for _, target := range []string{
+		"/api/v1/analytics/liquidity?ticks=-1",
+		"/api/v1/analytics/liquidity?tick_size=0",
+		"/api/v1/analytics/liquidity?tick_size=NaN",
+	} {
+		if _, code := getLiquidity(t, target); code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", target, code)
+		}
+	}
+
+	if _, code := getLiquidity(t, "/api/v1/analytics/liquidity?symbol=NOPE"); code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown symbol, got %d", code)
+	}
+}
@@ -1,15 +1,19 @@
 package main
 
 import (
+	"cmp"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"slices"
 	"sort"
-	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/google/uuid"
 )
 
 type Side string
@@ -19,6 +23,29 @@ const (
 	SideSell Side = "sell"
 )
 
+// OrderType says how an order is executed. The zero value is a limit order.
+type OrderType string
+
+const (
+	OrderTypeLimit OrderType = "limit"
+	// OrderTypeMarket fills at any price and never rests; triggered stops and
+	// notional buys use it
+	OrderTypeMarket       OrderType = "market"
+	OrderTypeTrailingStop OrderType = "trailing_stop"
+	OrderTypePegged       OrderType = "pegged"
+)
+
+// TimeInForce says what happens to quantity an order cannot fill on arrival.
+// The zero value is GTC.
+type TimeInForce string
+
+const (
+	// TimeInForceGTC rests the remainder until it fills, expires or is cancelled
+	TimeInForceGTC TimeInForce = "GTC"
+	// TimeInForceIOC cancels whatever does not fill immediately
+	TimeInForceIOC TimeInForce = "IOC"
+)
+
 type OrderStatus string
 
 const (
@@ -26,260 +53,851 @@ const (
 	OrderStatusFilled          OrderStatus = "filled"
 	OrderStatusPartiallyFilled OrderStatus = "partially_filled"
 	OrderStatusCancelled       OrderStatus = "cancelled"
+	OrderStatusPendingCancel   OrderStatus = "pending_cancel"
+	OrderStatusRejected        OrderStatus = "rejected"
+	OrderStatusExpired         OrderStatus = "expired"
+	// OrderStatusUntriggered is a stop order waiting for its trigger price
+	OrderStatusUntriggered OrderStatus = "untriggered"
 )
 
 // Order represents an order structure
 type Order struct {
 	ID        string      `json:"id"`
+	Symbol    string      `json:"symbol,omitempty"`
 	Side      Side        `json:"side"`
 	Quantity  int         `json:"quantity"`
 	Price     float64     `json:"price"`
 	Status    OrderStatus `json:"status"`
 	CreatedAt time.Time   `json:"created_at"`
+	ExpiresAt *time.Time  `json:"expires_at,omitempty"`
+	Owner     string      `json:"owner,omitempty"`
+	// ArrivalSequence numbers the order's arrival in its queue. It breaks
+	// ties between orders whose created_at is equal to the nanosecond.
+	ArrivalSequence int64 `json:"arrival_sequence"`
+	// FilledQuantity is how much has traded; Quantity is what is left
+	FilledQuantity int `json:"filled_quantity,omitempty"`
+	// QuoteQuantity is what a notional buy was placed to spend, in the
+	// symbol's quote asset. Its quantity is the lots that buys at the asks.
+	QuoteQuantity float64 `json:"quote_quantity,omitempty" since:"4"`
+	// BasketID is the basket the order was placed in, if any
+	BasketID string `json:"basket_id,omitempty" since:"7"`
+	// SpreadOrderID is the spread order a leg order was implied from
+	SpreadOrderID string `json:"spread_order_id,omitempty" since:"8"`
+	// RejectReason is set when the order was refused on arrival
+	RejectReason ErrorCode `json:"reject_reason,omitempty"`
+
+	Type OrderType `json:"type,omitempty"`
+	// TrailAmount or TrailPercent sets how far a trailing stop's trigger
+	// follows the best trade price seen since it was placed
+	TrailAmount  float64 `json:"trail_amount,omitempty"`
+	TrailPercent float64 `json:"trail_percent,omitempty"`
+	// LimitOffset turns a triggered stop into a limit this far past the
+	// trigger price; without it the stop becomes a market order
+	LimitOffset *float64 `json:"limit_offset,omitempty"`
+	// TriggerPrice is where a trailing stop currently fires; zero until the
+	// symbol has traded, or for a stop that follows the mark, has a mark price
+	TriggerPrice float64 `json:"trigger_price,omitempty"`
+	// TriggerBy is set on trailing stops that follow the mark price
+	TriggerBy StopTrigger `json:"trigger_by,omitempty"`
+
+	// Peg and PegOffset price a pegged order from the best bid and offer
+	Peg       PegType `json:"peg,omitempty"`
+	PegOffset float64 `json:"peg_offset,omitempty"`
+
+	TimeInForce TimeInForce `json:"time_in_force,omitempty"`
+	// MinQuantity and AllOrNone are conditions on the arrival matching event:
+	// the order only trades if at least MinQuantity, or all of it, fills at once
+	MinQuantity int  `json:"min_quantity,omitempty"`
+	AllOrNone   bool `json:"all_or_none,omitempty"`
+
+	// Timestamps time the order through the engine
+	Timestamps OrderTimestamps `json:"timestamps,omitzero"`
+
+	// anchor is the best price a trailing stop has seen
+	anchor float64
+
+	// queueAhead is the exchange quantity a paper order rests behind
+	queueAhead int
 }
 
 type Trade struct {
-	ID        string    `json:"id"`
-	MakerID   string    `json:"maker_id"`
-	TakerID   string    `json:"taker_id"`
-	Price     float64   `json:"price"`
-	Quantity  int       `json:"quantity"`
-	CreatedAt time.Time `json:"created_at"`
+	ID       string  `json:"id"`
+	Symbol   string  `json:"symbol,omitempty"`
+	MakerID  string  `json:"maker_id"`
+	TakerID  string  `json:"taker_id"`
+	Price    float64 `json:"price"`
+	Quantity int     `json:"quantity"`
+	// Venue names the external venue for routed fills; empty means the local book
+	Venue string `json:"venue,omitempty"`
+	// AggressorSide is the taker's side: buy when the trade lifted an offer
+	AggressorSide Side `json:"aggressor_side"`
+	// TickDirection compares the price with the symbol's previous local trade;
+	// empty for the first trade and for routed fills
+	TickDirection TickDirection `json:"tick_direction,omitempty"`
+	CreatedAt     time.Time     `json:"created_at"`
+	// Fees is what each side paid, set when the trade settled between accounts
+	Fees *TradeFees `json:"fees,omitempty"`
+	// BaseAsset changed hands in BaseQuantity, the trade's lots in units of
+	// base, for QuoteNotional of QuoteAsset
+	BaseAsset     string  `json:"base_asset,omitempty" since:"3"`
+	BaseQuantity  float64 `json:"base_quantity,omitempty" since:"3"`
+	QuoteAsset    string  `json:"quote_asset,omitempty" since:"3"`
+	QuoteNotional float64 `json:"quote_notional,omitempty" since:"3"`
 }
 
+// TickDirection says whether a trade printed above, below or at the previous price
+type TickDirection string
+
+const (
+	TickUp   TickDirection = "uptick"
+	TickDown TickDirection = "downtick"
+	TickZero TickDirection = "zero"
+)
+
 // OrderBook represents the order book with separate buy and sell sides
 type OrderBook struct {
-	BuyOrders  []Order `json:"buy_orders"`
-	SellOrders []Order `json:"sell_orders"`
+	// bids and asks hold the orders resting on each side
+	bids bookSide
+	asks bookSide
+
+	// nextExpiry is no later than the earliest expiry among resting orders;
+	// zero means none of them expire
+	nextExpiry time.Time
+
+	// bidLiquidity and askLiquidity total each side's resting orders by level
+	bidLiquidity sideLiquidity
+	askLiquidity sideLiquidity
+
+	// pegBid and pegAsk are the reference quotes pegged orders were last priced
+	// from, and hasPegs is false once a reprice has found no pegged orders
+	pegBid  float64
+	pegAsk  float64
+	hasPegs bool
+
+	// stops holds untriggered stop orders, and lastPrice is the most recent
+	// local trade price they trail and the next trade ticks against
+	stops     []Order
+	lastPrice float64
+
+	// mark is the symbol's mark price source and the prices it is taken from
+	mark markState
+
+	// sequence counts the changes published to depth subscribers, and the
+	// dirty sets hold the prices changed since the last one
+	sequence  int64
+	dirtyBids map[float64]struct{}
+	dirtyAsks map[float64]struct{}
+
+	// l3 numbers the order-by-order events and holds their stream clients
+	l3 l3Book
+
+	// auction is set on symbols matched in batch auctions, and on continuous
+	// ones while their phase holds orders, which phaseAuction marks
+	auction      *batchAuction
+	phaseAuction bool
+	// uncross is when the call auction under way was drawn to uncross, once
+	// its scheduled end has passed within an uncross window
+	uncross randomUncross
+
+	// status is the book's trading phase, and scheduled the phase the trading
+	// schedule last moved it to
+	status    MarketStatus
+	scheduled TradingPhase
+
+	// volatility is what the volatility guard watches
+	volatility volatilityGuard
+
+	// latency holds the timings of the most recent orders processed here
+	latency latencySamples
+	// trace is the match span of the traced command running on the book, if any
+	trace *span
+
+	// paper holds the paper orders resting on a shadow book, and paperTaken
+	// the mirrored quantity by level they have filled against
+	paper      []Order
+	paperTaken map[levelKey]int
+
+	// basket holds the earlier orders of the basket being screened on the
+	// book, which the margin and credit checks count as resting; nil otherwise
+	basket []Order
 }
 
 // PlaceOrderRequest represents the request body for placing an order
 type PlaceOrderRequest struct {
-	Side     Side    `json:"side"`
-	Price    float64 `json:"price"`
-	Quantity int     `json:"quantity"`
+	Symbol    string     `json:"symbol,omitempty"`
+	Side      Side       `json:"side" validate:"required,oneof=buy sell"`
+	Price     float64    `json:"price"`
+	Quantity  int        `json:"quantity,omitempty" validate:"unless=quote_quantity,gt=0,max=999999999"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Owner     string     `json:"owner,omitempty"`
+	// QuoteQuantity places a market buy for an amount of the quote asset
+	// rather than a quantity
+	QuoteQuantity float64 `json:"quote_quantity,omitempty" validate:"omitempty,gt=0,max=999999999999"`
+
+	Type         OrderType `json:"type,omitempty" validate:"omitempty,oneof=limit market trailing_stop pegged"`
+	TrailAmount  float64   `json:"trail_amount,omitempty"`
+	TrailPercent float64   `json:"trail_percent,omitempty"`
+	LimitOffset  *float64  `json:"limit_offset,omitempty"`
+	// TriggerBy is the price a trailing stop follows: trades, the default, or
+	// the symbol's mark price
+	TriggerBy StopTrigger `json:"trigger_by,omitempty" validate:"omitempty,oneof=trade mark"`
+
+	TimeInForce TimeInForce `json:"time_in_force,omitempty" validate:"omitempty,oneof=GTC IOC"`
+	MinQuantity int         `json:"min_quantity,omitempty" validate:"min=0"`
+	AllOrNone   bool        `json:"all_or_none,omitempty"`
+
+	Peg       PegType `json:"peg,omitempty"`
+	PegOffset float64 `json:"peg_offset,omitempty"`
+}
+
+// validate checks the rules that depend on the order type. Trailing stops
+// take their price from the trigger, pegged orders from the book and market
+// orders from the asks they buy.
+func (req PlaceOrderRequest) validate() []FieldError {
+	var errs []FieldError
+
+	switch req.Type {
+	case OrderTypeMarket:
+		errs = validateNotional(req)
+	case OrderTypeTrailingStop:
+		errs = validateTrailingStop(req)
+	case OrderTypePegged:
+		errs = validatePeg(req)
+	case "", OrderTypeLimit:
+		if req.Price <= 0 {
+			errs = append(errs, fieldError("price", "gt", "price must be a positive number (received: %.2f)", req.Price))
+		} else if req.Price > 999999999.99 {
+			errs = append(errs, fieldError("price", "max", "price is too high (maximum allowed: 999,999,999.99)"))
+		}
+	}
+	if req.Type != OrderTypeMarket && req.QuoteQuantity != 0 {
+		errs = append(errs, fieldError("quote_quantity", "excluded", "quote_quantity is only used by market orders"))
+	}
+
+	return append(errs, validateFillConditions(req)...)
 }
 
 // PlaceOrderResponse represents the response for placing an order
 type PlaceOrderResponse struct {
-	OrderID string  `json:"order_id"`
-	Trades  []Trade `json:"trades,omitempty"`
+	OrderID string        `json:"order_id"`
+	Status  OrderStatus   `json:"status"`
+	Trades  []Trade       `json:"trades,omitempty"`
+	Latency *OrderLatency `json:"latency,omitempty"`
+}
+
+// OrdersResponse lists resting orders
+type OrdersResponse struct {
+	Orders []Order `json:"orders"`
+	Count  int     `json:"count"`
+}
+
+// TradesResponse lists trades in the order they happened
+type TradesResponse struct {
+	Trades []Trade `json:"trades"`
+	Count  int     `json:"count"`
+}
+
+// OrderBookResponse is one symbol's resting orders, best first on each side
+type OrderBookResponse struct {
+	Symbol    string        `json:"symbol"`
+	OrderBook RestingOrders `json:"orderbook"`
+	BuyCount  int           `json:"buy_count"`
+	SellCount int           `json:"sell_count"`
+}
+
+// RestingOrders is the orders resting on each side of a book, in priority order
+type RestingOrders struct {
+	BuyOrders  []Order `json:"buy_orders"`
+	SellOrders []Order `json:"sell_orders"`
 }
 
-var orderBook OrderBook
-var trades []Trade
+// initialHistoryCapacity is how many trades and order events the server has
+// room for at startup before the logs have to grow
+const initialHistoryCapacity = 1 << 16
 
 func main() {
-	// Initialize order book and trades
-	orderBook = OrderBook{
-		BuyOrders:  make([]Order, 0),
-		SellOrders: make([]Order, 0),
+	cfg, err := loadConfig(os.Args[1:])
+	if err != nil {
+		os.Exit(2)
+	}
+	if !cfg.SimulationStart.IsZero() {
+		startSimulation(context.Background(), cfg.SimulationStart)
+	}
+
+	// Initialize the trade and order event logs
+	tradeStore = newMemoryTradeRepository(initialHistoryCapacity)
+	orderEvents = make([]OrderEvent, 0, initialHistoryCapacity)
+	queueConfig = cfg.Queue
+	tickLadders = cfg.Ladders
+	pairs = cfg.Pairs
+	streamConfig = cfg.Streams
+	if cfg.ReadModel {
+		readModel = startReadModel(context.Background())
+	}
+	resetSymbols(cfg.Symbols)
+	if cfg.SequenceFile != "" {
+		if err := openSequences(cfg.SequenceFile); err != nil {
+			log.Fatalf("sequences: %v", err)
+		}
+		saveSequencesOnSignal(cfg.SequenceFile)
+	} else {
+		startEpoch()
+	}
+	seedBooks(cfg.SeedDepth)
+	startBatchAuctions(context.Background(), cfg.Auctions)
+	configureMarks(cfg.Marks.Sources)
+	if err := configureOrderScripts(cfg.Scripts); err != nil {
+		log.Fatal(err)
+	}
+	markEMAAlpha = cfg.Marks.EMAAlpha
+	fees = cfg.Fees
+	if cfg.ReferralShare > 0 {
+		referralShare = cfg.ReferralShare
+		settlementHook = referralHook(cfg.ReferralShare)
+	}
+	margin = cfg.Margin
+	positionLimits = cfg.PositionLimits
+	liquidationFeeBps = cfg.Margin.LiquidationFeeBps
+	throttle = cfg.Throttle
+	bookHistory = cfg.BookHistory
+	surveillance = cfg.Surveillance
+	volatility = cfg.Volatility
+	uncrossWindow = cfg.UncrossWindow
+	configureCalendars(cfg.Calendars)
+	startPhases(context.Background())
+	credit = cfg.Credit
+	if cfg.Credit.URL != "" {
+		creditChecker = NewWebhookCreditChecker(cfg.Credit.URL)
+	}
+	if cfg.RouterURL != "" {
+		orderRouter = NewWebhookRouter(cfg.RouterURL, cfg.RouterTimeout)
+	}
+	startWebhooks(context.Background(), cfg.Webhooks)
+
+	if cfg.Replication.PrimaryURL != "" {
+		startStandby(cfg.Replication)
+	}
+	if cfg.Redis.Addr != "" {
+		if cfg.Redis.Reader {
+			startRedisReader(cfg.Redis)
+		} else {
+			startRedisMirror(context.Background(), cfg.Redis)
+		}
+	}
+	if cfg.Bots.Enabled {
+		startBots(context.Background(), cfg.Bots)
+	}
+	startTracing(context.Background(), cfg.Tracing)
+	startIndexPollers(context.Background(), cfg.Index)
+	startShadows(context.Background(), cfg.Shadow)
+	if err := startStrategies(context.Background(), cfg.Strategies); err != nil {
+		log.Fatal(err)
+	}
+	startReplay(context.Background(), cfg.Replay)
+	startPerpetuals(context.Background(), cfg.Perpetuals)
+	startOptions(context.Background(), cfg.Options)
+	startSpreads(cfg.Spreads)
+	if store, ok := newArchiveStore(cfg.Archive); ok {
+		archiver := &Archiver{Store: store, Retention: cfg.Archive.Retention}
+		go archiver.Run(context.Background(), cfg.Archive.Interval)
 	}
-	trades = make([]Trade, 0)
 
-	// Define routes
-	http.HandleFunc("/api/place-order", placeOrderHandler)
-	http.HandleFunc("/api/orders", getOrdersHandler)
-	http.HandleFunc("/api/trades", getTradesHandler)
-	http.HandleFunc("/api/orderbook", getOrderBookHandler)
+	server, redirect, err := newHTTPServer(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// Start server
-	fmt.Println("Server starting on port 8080...")
+	scheme, wsScheme := "http", "ws"
+	if cfg.TLS.enabled() {
+		scheme, wsScheme = "https", "wss"
+	}
+	_, port, _ := net.SplitHostPort(cfg.Addr)
+	fmt.Printf("Server starting on %s (%s)...\n", cfg.Addr, scheme)
 	fmt.Println("API endpoints:")
-	fmt.Println("  POST http://localhost:8080/api/place-order - Place buy/sell order")
-	fmt.Println("  GET  http://localhost:8080/api/orders - View all orders")
-	fmt.Println("  GET  http://localhost:8080/api/trades - View all trades")
-	fmt.Println("  GET  http://localhost:8080/api/orderbook - View order book")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	for _, route := range apiRoutes() {
+		method, base := route.method, scheme+"://localhost:"+port
+		if route.websocket {
+			method, base = "WS", wsScheme+"://localhost:"+port
+		}
+		fmt.Printf("  %-6s %s%s - %s\n", method, base, route.path, route.summary)
+	}
+	log.Fatal(listenAndServe(server, redirect))
 }
 
 func placeOrderHandler(w http.ResponseWriter, r *http.Request) {
-	// Set CORS headers
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 
-	// Handle preflight requests
-	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusOK)
-		return
-	}
+	receivedAt := engineClock.Now()
 
-	// Only allow POST method
-	if r.Method != "POST" {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"error":   "Method not allowed",
-			"details": "Only POST method is supported for this endpoint",
-		})
+	var req PlaceOrderRequest
+	if !decodeRequest(w, r, &req) {
 		return
 	}
 
-	// Parse request body
-	var req PlaceOrderRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		errorMessage := "Invalid JSON format in request body"
-		if strings.Contains(err.Error(), "unexpected end of JSON input") {
-			errorMessage = "Request body is empty or incomplete"
-		} else if strings.Contains(err.Error(), "invalid character") {
-			errorMessage = "Request body contains invalid JSON syntax"
-		} else if strings.Contains(err.Error(), "cannot unmarshal") {
-			errorMessage = "Request body contains invalid data types"
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"error":   errorMessage,
-			"details": err.Error(),
-		})
+	m, ok := matcherFor(req.Symbol)
+	if !ok {
+		writeError(w, http.StatusBadRequest, ErrCodeUnknownSymbol, "Unknown symbol",
+			"symbol '"+req.Symbol+"' is not traded here")
 		return
 	}
 
-	// Validate request with detailed error messages
-	var validationErrors []string
+	// Create new order
+	order := newOrder(m.symbol, req, receivedAt)
 
-	// Validate quantity
-	if req.Quantity <= 0 {
-		validationErrors = append(validationErrors, "quantity must be a positive number (received: "+fmt.Sprintf("%d", req.Quantity)+")")
-	} else if req.Quantity > 999999999 {
-		validationErrors = append(validationErrors, "quantity is too high (maximum allowed: 999,999,999)")
+	// Process the order on the symbol's matcher, unless it is already too busy
+	// or the request ends before the order's turn
+	order, err := submitOrder(r.Context(), m, order)
+	if err == errEngineBusy {
+		writeEngineBusy(w, m)
+		return
+	} else if err != nil {
+		writeRequestTimeout(w, err)
+		return
 	}
 
-	// Validate price
-	if req.Price <= 0 {
-		validationErrors = append(validationErrors, "price must be a positive number (received: "+fmt.Sprintf("%.2f", req.Price)+")")
-	} else if req.Price > 999999999.99 {
-		validationErrors = append(validationErrors, "price is too high (maximum allowed: 999,999,999.99)")
+	traced := spanFrom(r.Context())
+	traced.set("order.id", order.ID)
+	traced.set("order.status", string(order.Status))
+	if order.Status == OrderStatusRejected {
+		writeRejection(w, order)
+		return
 	}
 
-	// Validate side
-	if req.Side == "" {
-		validationErrors = append(validationErrors, "side is required and cannot be empty")
-	} else if req.Side != SideBuy && req.Side != SideSell {
-		validationErrors = append(validationErrors, "side must be either 'buy' or 'sell' (received: '"+string(req.Side)+"')")
+	// Return all trades in match order. The order is placed by now, so a
+	// trade store too slow to answer only leaves the trades out.
+	trades, _ := storeCall(r.Context(), func() []Trade { return tradeStore.List("") })
+	latency := latencyOf(order)
+	response := PlaceOrderResponse{
+		OrderID: order.ID,
+		Status:  order.Status,
+		Trades:  trades,
+		Latency: &latency,
 	}
 
-	// Return all validation errors if any exist
-	if len(validationErrors) > 0 {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"error":   "Validation failed",
-			"details": validationErrors,
-		})
-		return
-	}
+	writeBody(w, r, response)
+}
 
-	// Create new order
+// newOrder builds the pending order a placement request asks for
+func newOrder(symbol string, req PlaceOrderRequest, receivedAt time.Time) Order {
 	order := Order{
 		ID:        generateOrderID(),
+		Symbol:    symbol,
 		Side:      req.Side,
 		Quantity:  req.Quantity,
 		Price:     req.Price,
 		Status:    OrderStatusPending,
-		CreatedAt: time.Now(),
-	}
+		CreatedAt: engineClock.Now(),
+		ExpiresAt: req.ExpiresAt,
+		Owner:     req.Owner,
 
-	// Process the order through the order book
-	processOrder(order)
+		QuoteQuantity: req.QuoteQuantity,
 
-	// Return all trades in match order
-	response := PlaceOrderResponse{
-		OrderID: order.ID,
-		Trades:  trades,
-	}
+		Type:         req.Type,
+		TrailAmount:  req.TrailAmount,
+		TrailPercent: req.TrailPercent,
+		LimitOffset:  req.LimitOffset,
+
+		TimeInForce: req.TimeInForce,
+		MinQuantity: req.MinQuantity,
+		AllOrNone:   req.AllOrNone,
+
+		Peg:       req.Peg,
+		PegOffset: req.PegOffset,
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
+		Timestamps: OrderTimestamps{ReceivedAt: receivedAt},
+	}
+	if order.Type == OrderTypeTrailingStop {
+		order.Status = OrderStatusUntriggered
+		order.TriggerBy = req.TriggerBy
+	}
+	return order
 }
 
-// generateOrderID creates a simple order ID
+// generateOrderID creates a new order ID
 func generateOrderID() string {
-	return uuid.New().String()
+	return idGenerator.OrderID()
 }
 
-// generateTradeID creates a simple trade ID
+// generateTradeID creates a new trade ID
 func generateTradeID() string {
-	return uuid.New().String()
+	return idGenerator.TradeID()
 }
 
-// processOrder processes an incoming order through the order book
-func processOrder(order Order) {
-	if order.Side == SideBuy {
-		// Try to match buy order against sell orders
-		remainingOrder, _ := matchBuyOrder(order)
+// processOrder processes an incoming order through its symbol's book and
+// returns it in its final state. It must run on that symbol's matcher.
+func processOrder(order Order) Order {
+	now := engineClock.Now()
+	book := bookFor(order.Symbol)
+
+	// Orders placed from inside the engine arrive straight on the matcher,
+	// and are not throttled
+	external := !order.Timestamps.IsZero()
+	if !external {
+		order.Timestamps.ReceivedAt = now
+	}
+	order.Timestamps.AcceptedAt = now
+	stampArrival(&order)
 
-		// If there's remaining quantity, add to buy side of order book
-		if remainingOrder.Quantity > 0 {
-			addToOrderBook(remainingOrder)
+	result := admitOrder(book, order, now, external)
+	stampMatched(&result)
+	book.latency.add(latencyOf(result))
+	return result
+}
+
+// arrivals is the last arrival sequence the engine handed out. It is shared
+// by every symbol so the sequence alone orders any two arrivals.
+var arrivals atomic.Int64
+
+// stampArrival gives an order the next arrival sequence
+func stampArrival(order *Order) {
+	order.ArrivalSequence = arrivals.Add(1)
+}
+
+// requeue sends an order to the back of its price level as of now, as when a
+// change to it costs its time priority
+func requeue(order *Order) {
+	order.CreatedAt = engineClock.Now()
+	stampArrival(order)
+}
+
+// noteArrival keeps the arrival sequence ahead of an order the engine did not
+// sequence itself, such as one restored from a snapshot or replicated from a
+// primary, so orders that arrive later still rank behind it
+func noteArrival(order Order) {
+	for {
+		last := arrivals.Load()
+		if order.ArrivalSequence <= last || arrivals.CompareAndSwap(last, order.ArrivalSequence) {
+			return
+		}
+	}
+}
+
+// admitOrder checks an accepted order, charging its owner's throttle if it
+// came from outside the engine, then rests it as a stop or executes it
+func admitOrder(book *OrderBook, order Order, now time.Time, external bool) Order {
+	order, admitted := screenOrder(book, order, now, external)
+	if !admitted {
+		return order
+	}
+	return enterOrder(book, order)
+}
+
+// screenOrder runs the checks an accepted order must pass before it may
+// trade, returning it rejected and false if one refuses it. It changes
+// nothing on the book but expired orders and the trading phase.
+func screenOrder(book *OrderBook, order Order, now time.Time, external bool) (Order, bool) {
+	// Drop resting orders that expired since the last book change
+	if !book.nextExpiry.IsZero() && !now.Before(book.nextExpiry) {
+		expireOrders(book, now)
+	}
+
+	recordOrderEvent(order.ID, "", order.Status, "order accepted")
+	feed.order(order, "order accepted")
+
+	if external && !throttleAllows(order.Owner, ThrottlePlace) {
+		rejectOrder(&order, ErrCodeThrottled)
+		return order, false
+	}
+
+	// Orders that are already past their expiry never reach the book
+	if isExpired(order, now) {
+		rejectOrder(&order, ErrCodeOrderExpired)
+		return order, false
+	}
+
+	// A notional buy is for the lots its quote amount pays for at the asks
+	if order.QuoteQuantity > 0 {
+		if order.Quantity = notionalLots(book, order); order.Quantity == 0 {
+			rejectOrder(&order, ErrCodeNoLiquidity)
+			return order, false
+		}
+	}
+
+	// Operator scripts may refuse any order, with a reason of their own
+	if reason, refused := scriptRefusal(book, order); refused {
+		order.RejectReason = ErrCodeScriptRejected
+		if err := transitionOrder(&order, OrderStatusRejected, reason); err != nil {
+			logTransitionError(err)
+		}
+		return order, false
+	}
+
+	// Shadow symbols only mirror their exchange, unless paper trading
+	// fills orders against it
+	if shadowed(order.Symbol) {
+		if !paperTrading {
+			rejectOrder(&order, ErrCodeShadowSymbol)
+			return order, false
+		}
+		return order, true
+	}
+
+	// Options stop trading at their expiry
+	if optionExpired(order.Symbol, now) {
+		rejectOrder(&order, ErrCodeOptionExpired)
+		return order, false
+	}
+
+	// No order may take its owner's position in the symbol past its limit
+	if code := checkPositionLimit(book, &order); code != "" {
+		rejectOrder(&order, code)
+		return order, false
+	}
+
+	// Perpetual orders must leave their owner with the initial margin
+	if !hasInitialMargin(book, order) {
+		rejectOrder(&order, ErrCodeInsufficientMargin)
+		return order, false
+	}
+
+	// An external credit system has the last say on orders from outside
+	if external {
+		if code, reason := creditRefusal(book, order); code != "" {
+			order.RejectReason = code
+			if err := transitionOrder(&order, OrderStatusRejected, reason); err != nil {
+				logTransitionError(err)
+			}
+			return order, false
 		}
+	}
+
+	// The trading phase decides whether the order may trade, wait or not
+	// enter at all
+	advancePhase(book, order.Symbol, now)
+	if code := phaseRefusal(book, order); code != "" {
+		rejectOrder(&order, code)
+		return order, false
+	}
+
+	return order, true
+}
+
+// enterOrder rests a screened order as a stop or paper order, holds it for an
+// auction or executes it
+func enterOrder(book *OrderBook, order Order) Order {
+	// Paper orders fill against the shadowed exchange
+	if shadowed(order.Symbol) {
+		return paperOrder(book, order)
+	}
+
+	// Batch auction symbols hold orders until the end of the interval
+	if book.auction != nil {
+		return collectForAuction(book, order)
+	}
+
+	// Stops wait off the book until the trade price reaches their trigger
+	if order.Type == OrderTypeTrailingStop {
+		stampMatched(&order)
+		return addStop(book, order)
+	}
+
+	// Pegged orders take their price from the rest of the book
+	if order.Type == OrderTypePegged {
+		bid, ask := referenceQuotes(book)
+		price, ok := pegPrice(order, bid, ask)
+		if !ok {
+			rejectOrder(&order, ErrCodeNoReferencePrice)
+			return order
+		}
+		order.Price = price
+	}
+
+	result := executeOrder(book, order)
+	repricePegs(book)
+	return result
+}
+
+// executeOrder matches an accepted order against the book, rests or cancels
+// its remainder, and then runs any stops its trades triggered
+func executeOrder(book *OrderBook, order Order) Order {
+	// Orders that reach a book whose phase holds them, such as the stops the
+	// trades before an interruption triggered, wait for its auction
+	if book.holding() {
+		return holdForAuction(book, order)
+	}
+
+	// An owner may not trade against their own resting orders
+	if crossesOwnOrder(book, order) {
+		rejectOrder(&order, ErrCodeSelfTrade)
+		return order
+	}
+
+	// A minimum or all-or-none quantity must be available in this one matching
+	// event. Otherwise the order may only rest, and only if it would not cross.
+	if required := requiredFill(order); required > 0 {
+		if available := availableLiquidity(book, order, required); available < required {
+			if available > 0 || !canRest(order) {
+				rejectOrder(&order, ErrCodeMinQuantityNotMet)
+			} else {
+				stampMatched(&order)
+				addToOrderBook(order)
+			}
+			return order
+		}
+	}
+
+	fills := fillBuffers.Get().(*[]Trade)
+	defer fillBuffers.Put(fills)
+
+	var remainingOrder Order
+	if order.Side == SideBuy {
+		// Try to match buy order against sell orders
+		remainingOrder, *fills = matchBuyOrder(book, order, (*fills)[:0])
 	} else {
 		// Try to match sell order against buy orders
-		remainingOrder, _ := matchSellOrder(order)
+		remainingOrder, *fills = matchSellOrder(book, order, (*fills)[:0])
+	}
 
-		// If there's remaining quantity, add to sell side of order book
-		if remainingOrder.Quantity > 0 {
+	// Whatever the book could not fill may be taken by an external venue,
+	// unless one of the order's trades interrupted trading
+	localFills := len(*fills)
+	if !book.holding() {
+		remainingOrder, *fills = routeRemainder(remainingOrder, *fills)
+	}
+	stampMatched(&remainingOrder)
+
+	// If there's remaining quantity, add to the order's side of the book.
+	// Market and IOC orders never rest, and after an interruption the rest
+	// waits for the reopening auction.
+	if remainingOrder.Quantity > 0 {
+		if book.holding() {
+			remainingOrder = holdForAuction(book, remainingOrder)
+		} else if canRest(remainingOrder) {
 			addToOrderBook(remainingOrder)
+		} else {
+			cancelUnfilled(&remainingOrder)
 		}
 	}
+
+	// Local trades move trailing stops, and any that fire are executed in turn
+	for _, triggered := range trailStops(book, (*fills)[:localFills]) {
+		executeOrder(book, triggered)
+	}
+	return remainingOrder
 }
 
-// matchBuyOrder matches a buy order against existing sell orders
-func matchBuyOrder(buyOrder Order) (Order, []Trade) {
-	var executedTrades []Trade
-	remainingOrder := buyOrder
+// canRest reports whether an order's unfilled quantity may wait on the book
+func canRest(order Order) bool {
+	return order.Type != OrderTypeMarket && order.TimeInForce != TimeInForceIOC
+}
+
+// cancelUnfilled ends an order whose remainder may not rest. An order that
+// filled nothing is rejected; otherwise the rest is cancelled.
+func cancelUnfilled(order *Order) {
+	if order.Status == OrderStatusPending {
+		rejectOrder(order, ErrCodeNoLiquidity)
+		return
+	}
+
+	if err := transitionOrder(order, OrderStatusPendingCancel, "no further liquidity"); err != nil {
+		logTransitionError(err)
+	}
+	if err := transitionOrder(order, OrderStatusCancelled, "unfilled remainder cancelled"); err != nil {
+		logTransitionError(err)
+	}
+}
+
+// rejectOrder refuses an incoming order with the given reason code
+func rejectOrder(order *Order, code ErrorCode) {
+	order.RejectReason = code
+	if err := transitionOrder(order, OrderStatusRejected, rejectMessages[code]); err != nil {
+		logTransitionError(err)
+	}
+}
+
+// crossesOwnOrder reports whether an incoming order would cross or lock
+// against a resting order from the same owner
+func crossesOwnOrder(book *OrderBook, order Order) bool {
+	if order.Owner == "" {
+		return false
+	}
 
-	// Sort sell orders by price (lowest first) and then by time (oldest first)
-	sort.Slice(orderBook.SellOrders, func(i, j int) bool {
-		if orderBook.SellOrders[i].Price != orderBook.SellOrders[j].Price {
-			return orderBook.SellOrders[i].Price < orderBook.SellOrders[j].Price
+	opposite := &book.asks
+	if order.Side == SideSell {
+		opposite = &book.bids
+	}
+	crosses := false
+	// The orders it would cross are the best ones, so the walk stops at the first it would not
+	opposite.each(func(resting *Order) bool {
+		if !priceCrosses(order, *resting) {
+			return false
 		}
-		return orderBook.SellOrders[i].CreatedAt.Before(orderBook.SellOrders[j].CreatedAt)
+		crosses = resting.Owner == order.Owner
+		return !crosses
 	})
+	return crosses
+}
+
+// matchBuyOrder matches a buy order against existing sell orders,
+// appending the resulting trades to executedTrades
+func matchBuyOrder(book *OrderBook, buyOrder Order, executedTrades []Trade) (Order, []Trade) {
+	remainingOrder := buyOrder
 
-	// Try to match against sell orders
-	for i := 0; i < len(orderBook.SellOrders) && remainingOrder.Quantity > 0; {
-		sellOrder := orderBook.SellOrders[i]
+	// Sell orders are kept by price (lowest first) and then by time (oldest first)
+	for remainingOrder.Quantity > 0 && !book.holding() {
+		handle, ok := book.asks.best()
+		if !ok {
+			break
+		}
+		sellOrder := book.asks.at(handle)
 
-		// Check if prices can match (buy price >= sell price)
-		if remainingOrder.Price >= sellOrder.Price {
+		// Check if prices can match (buy price >= sell price, or any price for a market order)
+		if priceCrosses(remainingOrder, sellOrder) {
 			// Execute trade
 			tradeQuantity := min(remainingOrder.Quantity, sellOrder.Quantity)
 			trade := Trade{
 				ID:        generateTradeID(),
+				Symbol:    remainingOrder.Symbol,
 				MakerID:   sellOrder.ID,      // Resting order (sell)
 				TakerID:   remainingOrder.ID, // Incoming order (buy)
 				Price:     sellOrder.Price,   // Trade at resting order's price
 				Quantity:  tradeQuantity,
-				CreatedAt: time.Now(),
+				CreatedAt: engineClock.Now(),
+
+				AggressorSide: SideBuy,
 			}
+			tickTrade(book, &trade)
+			settleTrade(&trade, sellOrder.Owner, remainingOrder.Owner)
 
 			executedTrades = append(executedTrades, trade)
-			trades = append(trades, trade)
+			persisted := book.trace.child("persist")
+			persisted.set("operation", "write")
+			persisted.set("trade.id", trade.ID)
+			recordTrade(trade)
+			persisted.finish()
+			feed.trade(trade, sellOrder, remainingOrder)
+			surveilTrade(trade, sellOrder, remainingOrder)
 
 			// Update quantities
 			remainingOrder.Quantity -= tradeQuantity
-			orderBook.SellOrders[i].Quantity -= tradeQuantity
+			remainingOrder.FilledQuantity += tradeQuantity
+			resting := book.asks.edit(handle)
+			resting.Quantity -= tradeQuantity
+			resting.FilledQuantity += tradeQuantity
+			adjustLevel(book, SideSell, sellOrder.Price, -tradeQuantity, filledOrders(*resting))
+			publishL3(book, L3Execute, *resting, 1, -tradeQuantity, trade.ID)
 
 			// Update order status
-			if orderBook.SellOrders[i].Quantity == 0 {
-				orderBook.SellOrders[i].Status = OrderStatusFilled
+			if resting.Quantity == 0 {
+				if err := transitionOrder(resting, OrderStatusFilled, "fully filled"); err != nil {
+					logTransitionError(err)
+				}
 				// Remove filled order
-				orderBook.SellOrders = append(orderBook.SellOrders[:i], orderBook.SellOrders[i+1:]...)
-				// Don't increment i since we removed an element
-			} else {
-				orderBook.SellOrders[i].Status = OrderStatusPartiallyFilled
-				i++ // Move to next order
+				book.asks.remove(handle)
+			} else if err := transitionOrder(resting, OrderStatusPartiallyFilled, "partially filled"); err != nil {
+				logTransitionError(err)
 			}
 
 			// Update remaining order status
 			if remainingOrder.Quantity == 0 {
-				remainingOrder.Status = OrderStatusFilled
+				if err := transitionOrder(&remainingOrder, OrderStatusFilled, "fully filled"); err != nil {
+					logTransitionError(err)
+				}
 			} else {
-				remainingOrder.Status = OrderStatusPartiallyFilled
+				if err := transitionOrder(&remainingOrder, OrderStatusPartiallyFilled, "partially filled"); err != nil {
+					logTransitionError(err)
+				}
 			}
 		} else {
 			// No more matches possible
@@ -290,59 +908,92 @@ func matchBuyOrder(buyOrder Order) (Order, []Trade) {
 	return remainingOrder, executedTrades
 }
 
-// matchSellOrder matches a sell order against existing buy orders
-func matchSellOrder(sellOrder Order) (Order, []Trade) {
-	var executedTrades []Trade
+// tickTrade sets a local trade's tick direction from the book's last trade
+// price, then makes the trade's price the last one and checks it against the
+// volatility guard
+func tickTrade(book *OrderBook, trade *Trade) {
+	switch {
+	case book.lastPrice == 0:
+	case trade.Price > book.lastPrice:
+		trade.TickDirection = TickUp
+	case trade.Price < book.lastPrice:
+		trade.TickDirection = TickDown
+	default:
+		trade.TickDirection = TickZero
+	}
+	book.lastPrice = trade.Price
+	guardVolatility(book, *trade)
+}
+
+// matchSellOrder matches a sell order against existing buy orders,
+// appending the resulting trades to executedTrades
+func matchSellOrder(book *OrderBook, sellOrder Order, executedTrades []Trade) (Order, []Trade) {
 	remainingOrder := sellOrder
 
-	// Sort buy orders by price (highest first) and then by time (oldest first)
-	sort.Slice(orderBook.BuyOrders, func(i, j int) bool {
-		if orderBook.BuyOrders[i].Price != orderBook.BuyOrders[j].Price {
-			return orderBook.BuyOrders[i].Price > orderBook.BuyOrders[j].Price
+	// Buy orders are kept by price (highest first) and then by time (oldest first)
+	for remainingOrder.Quantity > 0 && !book.holding() {
+		handle, ok := book.bids.best()
+		if !ok {
+			break
 		}
-		return orderBook.BuyOrders[i].CreatedAt.Before(orderBook.BuyOrders[j].CreatedAt)
-	})
+		buyOrder := book.bids.at(handle)
 
-	// Try to match against buy orders
-	for i := 0; i < len(orderBook.BuyOrders) && remainingOrder.Quantity > 0; {
-		buyOrder := orderBook.BuyOrders[i]
-
-		// Check if prices can match (sell price <= buy price)
-		if remainingOrder.Price <= buyOrder.Price {
+		// Check if prices can match (sell price <= buy price, or any price for a market order)
+		if priceCrosses(remainingOrder, buyOrder) {
 			// Execute trade
 			tradeQuantity := min(remainingOrder.Quantity, buyOrder.Quantity)
 			trade := Trade{
 				ID:        generateTradeID(),
+				Symbol:    remainingOrder.Symbol,
 				MakerID:   buyOrder.ID,       // Resting order (buy)
 				TakerID:   remainingOrder.ID, // Incoming order (sell)
 				Price:     buyOrder.Price,    // Trade at resting order's price
 				Quantity:  tradeQuantity,
-				CreatedAt: time.Now(),
+				CreatedAt: engineClock.Now(),
+
+				AggressorSide: SideSell,
 			}
+			tickTrade(book, &trade)
+			settleTrade(&trade, buyOrder.Owner, remainingOrder.Owner)
 
 			executedTrades = append(executedTrades, trade)
-			trades = append(trades, trade)
+			persisted := book.trace.child("persist")
+			persisted.set("operation", "write")
+			persisted.set("trade.id", trade.ID)
+			recordTrade(trade)
+			persisted.finish()
+			feed.trade(trade, buyOrder, remainingOrder)
+			surveilTrade(trade, buyOrder, remainingOrder)
 
 			// Update quantities
 			remainingOrder.Quantity -= tradeQuantity
-			orderBook.BuyOrders[i].Quantity -= tradeQuantity
+			remainingOrder.FilledQuantity += tradeQuantity
+			resting := book.bids.edit(handle)
+			resting.Quantity -= tradeQuantity
+			resting.FilledQuantity += tradeQuantity
+			adjustLevel(book, SideBuy, buyOrder.Price, -tradeQuantity, filledOrders(*resting))
+			publishL3(book, L3Execute, *resting, 1, -tradeQuantity, trade.ID)
 
 			// Update order status
-			if orderBook.BuyOrders[i].Quantity == 0 {
-				orderBook.BuyOrders[i].Status = OrderStatusFilled
+			if resting.Quantity == 0 {
+				if err := transitionOrder(resting, OrderStatusFilled, "fully filled"); err != nil {
+					logTransitionError(err)
+				}
 				// Remove filled order
-				orderBook.BuyOrders = append(orderBook.BuyOrders[:i], orderBook.BuyOrders[i+1:]...)
-				// Don't increment i since we removed an element
-			} else {
-				orderBook.BuyOrders[i].Status = OrderStatusPartiallyFilled
-				i++ // Move to next order
+				book.bids.remove(handle)
+			} else if err := transitionOrder(resting, OrderStatusPartiallyFilled, "partially filled"); err != nil {
+				logTransitionError(err)
 			}
 
 			// Update remaining order status
 			if remainingOrder.Quantity == 0 {
-				remainingOrder.Status = OrderStatusFilled
+				if err := transitionOrder(&remainingOrder, OrderStatusFilled, "fully filled"); err != nil {
+					logTransitionError(err)
+				}
 			} else {
-				remainingOrder.Status = OrderStatusPartiallyFilled
+				if err := transitionOrder(&remainingOrder, OrderStatusPartiallyFilled, "partially filled"); err != nil {
+					logTransitionError(err)
+				}
 			}
 		} else {
 			// No more matches possible
@@ -353,29 +1004,95 @@ func matchSellOrder(sellOrder Order) (Order, []Trade) {
 	return remainingOrder, executedTrades
 }
 
-// addToOrderBook adds an order to the appropriate side of the order book
+// filledOrders is the change in a level's order count after a fill: one
+// fewer once the resting order has nothing left
+func filledOrders(resting Order) int {
+	if resting.Quantity == 0 {
+		return -1
+	}
+	return 0
+}
+
+// addToOrderBook adds an order to the appropriate side of its symbol's book
 func addToOrderBook(order Order) {
-	if order.Side == SideBuy {
-		orderBook.BuyOrders = append(orderBook.BuyOrders, order)
-		// Sort buy orders by price (highest first) and then by time (oldest first)
-		sort.Slice(orderBook.BuyOrders, func(i, j int) bool {
-			if orderBook.BuyOrders[i].Price != orderBook.BuyOrders[j].Price {
-				return orderBook.BuyOrders[i].Price > orderBook.BuyOrders[j].Price
-			}
-			return orderBook.BuyOrders[i].CreatedAt.Before(orderBook.BuyOrders[j].CreatedAt)
-		})
-	} else {
-		orderBook.SellOrders = append(orderBook.SellOrders, order)
-		// Sort sell orders by price (lowest first) and then by time (oldest first)
-		sort.Slice(orderBook.SellOrders, func(i, j int) bool {
-			if orderBook.SellOrders[i].Price != orderBook.SellOrders[j].Price {
-				return orderBook.SellOrders[i].Price < orderBook.SellOrders[j].Price
-			}
-			return orderBook.SellOrders[i].CreatedAt.Before(orderBook.SellOrders[j].CreatedAt)
-		})
+	book := bookFor(order.Symbol)
+	noteExpiry(book, order)
+	adjustLevel(book, order.Side, order.Price, order.Quantity, 1)
+	if order.Type == OrderTypePegged {
+		book.hasPegs = true
+	}
+
+	// Each side is kept by price (best first) and then by time (oldest first)
+	_, position := book.sideOf(order.Side).insert(order)
+	publishL3(book, L3Add, order, position, order.Quantity, "")
+	surveilLayering(book, order)
+}
+
+// compareBids orders the buy side: highest price first, then oldest first
+func compareBids(a, b *Order) int {
+	if a.Price != b.Price {
+		if a.Price > b.Price {
+			return -1
+		}
+		return 1
+	}
+	return comparePriority(a, b)
+}
+
+// compareAsks orders the sell side: lowest price first, then oldest first
+func compareAsks(a, b *Order) int {
+	if a.Price != b.Price {
+		if a.Price < b.Price {
+			return -1
+		}
+		return 1
+	}
+	return comparePriority(a, b)
+}
+
+// comparePriority orders two orders at one price: oldest first, then by
+// arrival sequence when their timestamps are equal
+func comparePriority(a, b *Order) int {
+	if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.ArrivalSequence, b.ArrivalSequence)
+}
+
+// insertOrder places an order into an already sorted side behind every order
+// that ranks ahead of or level with it, so equal orders keep arrival order. It
+// returns the side and the index the order went in at.
+func insertOrder(orders []Order, order Order, compare func(a, b *Order) int) ([]Order, int) {
+	i := sort.Search(len(orders), func(i int) bool {
+		return compare(&order, &orders[i]) < 0
+	})
+	orders = append(orders, Order{})
+	copy(orders[i+1:], orders[i:])
+	orders[i] = order
+	return orders, i
+}
+
+// sortSide restores priority order on a side's orders. Orders read off a
+// book are already sorted, so this is normally a single scan.
+func sortSide(orders []Order, compare func(a, b *Order) int) {
+	for i := 1; i < len(orders); i++ {
+		if compare(&orders[i-1], &orders[i]) > 0 {
+			slices.SortStableFunc(orders, func(a, b Order) int {
+				return compare(&a, &b)
+			})
+			return
+		}
 	}
 }
 
+// fillBuffers recycles the per-order trade slices built while matching
+var fillBuffers = sync.Pool{
+	New: func() interface{} {
+		fills := make([]Trade, 0, 16)
+		return &fills
+	},
+}
+
 // min returns the minimum of two integers
 func min(a, b int) int {
 	if a < b {
@@ -384,60 +1101,148 @@ func min(a, b int) int {
 	return b
 }
 
-// getAllOrders returns all orders in the order book
+// getAllOrders returns all resting orders across every symbol
 func getAllOrders() []Order {
+	return collectOrders("")
+}
+
+// collectOrders gathers resting orders from each matcher, optionally for one symbol,
+// expiring stale orders on the way
+func collectOrders(symbol string) []Order {
 	var allOrders []Order
-	allOrders = append(allOrders, orderBook.BuyOrders...)
-	allOrders = append(allOrders, orderBook.SellOrders...)
+	for _, m := range allMatchers() {
+		if symbol != "" && m.symbol != symbol {
+			continue
+		}
+		m.do(func() {
+			expireOrders(m.book, engineClock.Now())
+			allOrders = append(allOrders, m.book.bids.orders()...)
+			allOrders = append(allOrders, m.book.asks.orders()...)
+			allOrders = append(allOrders, m.book.stops...)
+			allOrders = append(allOrders, m.book.paper...)
+		})
+	}
 	return allOrders
 }
 
 // getOrdersHandler returns all orders in the system
 func getOrdersHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-
-	if r.Method != "GET" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
 
-	allOrders := getAllOrders()
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"orders": allOrders,
-		"count":  len(allOrders),
+	allOrders := collectOrders(r.URL.Query().Get("symbol"))
+	writeBody(w, r, OrdersResponse{
+		Orders: allOrders,
+		Count:  len(allOrders),
 	})
 }
 
-// getTradesHandler returns all trades in the system
+// getOrderHandler returns the resting order named in the path
+func getOrderHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	orderID := r.PathValue("id")
+	for _, order := range collectOrders("") {
+		if order.ID == orderID {
+			writeBody(w, r, order)
+			return
+		}
+	}
+	writeOrderNotFound(w, orderID)
+}
+
+// getTradesHandler returns all trades in the system, optionally for one
+// symbol, aggressor side and time range
 func getTradesHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 
-	if r.Method != "GET" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	span, problems := parseTimeRange(r.URL.Query())
+	side := Side(r.URL.Query().Get("aggressor_side"))
+	if side != "" && side != SideBuy && side != SideSell {
+		problems = append(problems, "aggressor_side must be 'buy' or 'sell' (received: '"+string(side)+"')")
+	}
+	if len(problems) > 0 {
+		writeError(w, http.StatusBadRequest, ErrCodeValidationFailed, "Validation failed", problems)
 		return
 	}
 
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"trades": trades,
-		"count":  len(trades),
+	symbol := r.URL.Query().Get("symbol")
+	var allTrades []Trade
+	if readModel != nil {
+		if err := readModel.sync(r.Context()); err != nil {
+			writeRequestTimeout(w, err)
+			return
+		}
+		allTrades = readModel.listTrades(symbol, span)
+	} else {
+		listed, err := storeCall(r.Context(), func() []Trade { return tradeStore.List(symbol) })
+		if err != nil {
+			writeRequestTimeout(w, err)
+			return
+		}
+		allTrades = slices.DeleteFunc(listed, func(trade Trade) bool { return !span.includes(trade.CreatedAt) })
+	}
+	if side != "" {
+		allTrades = slices.DeleteFunc(allTrades, func(trade Trade) bool { return trade.AggressorSide != side })
+	}
+	json.NewEncoder(w).Encode(TradesResponse{
+		Trades: anonymizeTrades(r.Context(), allTrades),
+		Count:  len(allTrades),
 	})
 }
 
-// getOrderBookHandler returns the current order book
+// getTradeHandler returns the trade named in the path, if it is still in memory
+func getTradeHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	tradeID := r.PathValue("id")
+	var trade *Trade
+	if readModel != nil {
+		if err := readModel.sync(r.Context()); err != nil {
+			writeRequestTimeout(w, err)
+			return
+		}
+		if found, ok := readModel.tradeByID(tradeID); ok {
+			trade = &found
+		}
+	} else {
+		var err error
+		trade, err = storeCall(r.Context(), func() *Trade {
+			if trade, ok := tradeStore.Get(tradeID); ok {
+				return &trade
+			}
+			return nil
+		})
+		if err != nil {
+			writeRequestTimeout(w, err)
+			return
+		}
+	}
+	if trade != nil {
+		json.NewEncoder(w).Encode(anonymizeTrades(r.Context(), []Trade{*trade})[0])
+		return
+	}
+	writeError(w, http.StatusNotFound, ErrCodeTradeNotFound, "Trade not found",
+		"No trade with id '"+tradeID+"'")
+}
+
+// getOrderBookHandler returns the latest view of a symbol's order book
 func getOrderBookHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 
-	if r.Method != "GET" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	m, ok := matcherFor(r.URL.Query().Get("symbol"))
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrCodeUnknownSymbol, "Unknown symbol",
+			"symbol '"+r.URL.Query().Get("symbol")+"' is not traded here")
 		return
 	}
 
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"orderbook":  orderBook,
-		"buy_count":  len(orderBook.BuyOrders),
-		"sell_count": len(orderBook.SellOrders),
+	view := m.readView()
+	book := RestingOrders{BuyOrders: view.buy, SellOrders: view.sell}
+
+	writeBody(w, r, OrderBookResponse{
+		Symbol:    m.symbol,
+		OrderBook: book,
+		BuyCount:  len(book.BuyOrders),
+		SellCount: len(book.SellOrders),
 	})
 }
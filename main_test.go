@@ -3,8 +3,10 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 )
@@ -12,11 +14,82 @@ import (
 // Test helper functions
 func setupTest() {
 	// Reset global state
-	orderBook = OrderBook{
-		BuyOrders:  make([]Order, 0),
-		SellOrders: make([]Order, 0),
+	orderStore = newMemoryOrderRepository()
+	tradeStore = newMemoryTradeRepository(0)
+	closedStore = newMemoryClosedOrderRepository()
+	orderEvents = make([]OrderEvent, 0)
+	lastEventSequence = 0
+	arrivals.Store(0)
+	algos = make(map[string]*algo)
+	stopSwitches()
+	accounts = make(map[string]*Account)
+	ledgerEntries = nil
+	ledgerBalances = make(map[string]map[string]float64)
+	fees = FeeSchedule{}
+	feeVolumes = make(map[string][]feeVolume)
+	settlementHook = nil
+	referralShare = 0
+	feePayouts = nil
+	perpetuals = make(map[string]*perpetualContract)
+	positions = make(map[string]map[string]*Position)
+	fundingEvents = nil
+	fundingRateCap = 0.0075
+	margin = MarginConfig{MaxLeverage: 10, MaintenanceRate: 0.05}
+	positionLimits = PositionLimitConfig{}
+	creditChecker = nil
+	credit = CreditConfig{Timeout: 200 * time.Millisecond}
+	leverages = make(map[string]float64)
+	marginStatuses = make(map[string]MarginStatus)
+	perpetualMarks = make(map[string]float64)
+	marginEvents = nil
+	liquidations = nil
+	adlEvents = nil
+	liquidationFeeBps = 0
+	options = make(map[string]*OptionContract)
+	spreads = make(map[string]Spread)
+	throttle = ThrottleConfig{Weights: defaultThrottleWeights}
+	throttleBuckets = make(map[string]*throttleBucket)
+	bookHistory = BookHistoryConfig{}
+	surveillance = SurveillanceConfig{}
+	volatility = VolatilityConfig{}
+	uncrossWindow = 0
+	simulatedClock = nil
+	configureCalendars(nil)
+	shadowSymbols = nil
+	paperTrading = false
+	replay.status = ReplayStatus{}
+	strategiesRunning.Store(false)
+	runningStrategies = nil
+	configureOrderScripts(ScriptConfig{})
+	surveillanceAlerts = nil
+	auditEvents = nil
+	sequencedSessions = make(map[string]*sequencedSession)
+	surveillanceFills = make(map[string][]surveilledOrder)
+	surveillanceCancels = make(map[string][]surveilledOrder)
+	surveillanceTrades = make(map[string][]surveilledTrade)
+	surveillanceRaised = make(map[string]time.Time)
+	queueConfig = QueueConfig{Depth: defaultQueueDepth, RetryAfter: time.Second}
+	tickLadders = nil
+	pairs = nil
+	tracer = nil
+	streamConfig = StreamConfig{Conflate: true, SlowTimeout: defaultSlowConsumerTimeout}
+	readModel = nil
+	epoch.Store(nil)
+	consumerGroups = make(map[string]ConsumerOffset)
+	fillWebhook = nil
+	deadLetters = nil
+	deadLetterLimit = defaultDeadLetterLimit
+	droppedDeadLetters = 0
+	resetSymbols([]string{"DEFAULT"})
+}
+
+// restOn puts orders straight on one side of a book, as if they were already
+// resting, without matching them or updating what the engine derives from
+// the book
+func restOn(side *bookSide, orders ...Order) {
+	for _, order := range orders {
+		side.insert(order)
 	}
-	trades = make([]Trade, 0)
 }
 
 func TestPlaceOrderHandler_ValidBuyOrder(t *testing.T) {
@@ -29,7 +102,7 @@ func TestPlaceOrderHandler_ValidBuyOrder(t *testing.T) {
 	}
 
 	jsonData, _ := json.Marshal(req)
-	request := httptest.NewRequest("POST", "/api/place-order", bytes.NewBuffer(jsonData))
+	request := httptest.NewRequest("POST", "/api/v1/orders", bytes.NewBuffer(jsonData))
 	request.Header.Set("Content-Type", "application/json")
 	response := httptest.NewRecorder()
 
@@ -62,7 +135,7 @@ func TestPlaceOrderHandler_ValidSellOrder(t *testing.T) {
 	}
 
 	jsonData, _ := json.Marshal(req)
-	request := httptest.NewRequest("POST", "/api/place-order", bytes.NewBuffer(jsonData))
+	request := httptest.NewRequest("POST", "/api/v1/orders", bytes.NewBuffer(jsonData))
 	request.Header.Set("Content-Type", "application/json")
 	response := httptest.NewRecorder()
 
@@ -83,7 +156,7 @@ func TestPlaceOrderHandler_ValidSellOrder(t *testing.T) {
 func TestPlaceOrderHandler_InvalidJSON(t *testing.T) {
 	setupTest()
 
-	request := httptest.NewRequest("POST", "/api/place-order", bytes.NewBufferString("invalid json"))
+	request := httptest.NewRequest("POST", "/api/v1/orders", bytes.NewBufferString("invalid json"))
 	request.Header.Set("Content-Type", "application/json")
 	response := httptest.NewRecorder()
 
@@ -97,7 +170,7 @@ func TestPlaceOrderHandler_InvalidJSON(t *testing.T) {
 func TestPlaceOrderHandler_EmptyBody(t *testing.T) {
 	setupTest()
 
-	request := httptest.NewRequest("POST", "/api/place-order", bytes.NewBufferString(""))
+	request := httptest.NewRequest("POST", "/api/v1/orders", bytes.NewBufferString(""))
 	request.Header.Set("Content-Type", "application/json")
 	response := httptest.NewRecorder()
 
@@ -118,7 +191,7 @@ func TestPlaceOrderHandler_InvalidSide(t *testing.T) {
 	}
 
 	jsonData, _ := json.Marshal(req)
-	request := httptest.NewRequest("POST", "/api/place-order", bytes.NewBuffer(jsonData))
+	request := httptest.NewRequest("POST", "/api/v1/orders", bytes.NewBuffer(jsonData))
 	request.Header.Set("Content-Type", "application/json")
 	response := httptest.NewRecorder()
 
@@ -139,7 +212,7 @@ func TestPlaceOrderHandler_ZeroPrice(t *testing.T) {
 	}
 
 	jsonData, _ := json.Marshal(req)
-	request := httptest.NewRequest("POST", "/api/place-order", bytes.NewBuffer(jsonData))
+	request := httptest.NewRequest("POST", "/api/v1/orders", bytes.NewBuffer(jsonData))
 	request.Header.Set("Content-Type", "application/json")
 	response := httptest.NewRecorder()
 
@@ -160,7 +233,7 @@ func TestPlaceOrderHandler_NegativePrice(t *testing.T) {
 	}
 
 	jsonData, _ := json.Marshal(req)
-	request := httptest.NewRequest("POST", "/api/place-order", bytes.NewBuffer(jsonData))
+	request := httptest.NewRequest("POST", "/api/v1/orders", bytes.NewBuffer(jsonData))
 	request.Header.Set("Content-Type", "application/json")
 	response := httptest.NewRecorder()
 
@@ -181,7 +254,7 @@ func TestPlaceOrderHandler_ZeroQuantity(t *testing.T) {
 	}
 
 	jsonData, _ := json.Marshal(req)
-	request := httptest.NewRequest("POST", "/api/place-order", bytes.NewBuffer(jsonData))
+	request := httptest.NewRequest("POST", "/api/v1/orders", bytes.NewBuffer(jsonData))
 	request.Header.Set("Content-Type", "application/json")
 	response := httptest.NewRecorder()
 
@@ -202,7 +275,7 @@ func TestPlaceOrderHandler_NegativeQuantity(t *testing.T) {
 	}
 
 	jsonData, _ := json.Marshal(req)
-	request := httptest.NewRequest("POST", "/api/place-order", bytes.NewBuffer(jsonData))
+	request := httptest.NewRequest("POST", "/api/v1/orders", bytes.NewBuffer(jsonData))
 	request.Header.Set("Content-Type", "application/json")
 	response := httptest.NewRecorder()
 
@@ -223,7 +296,7 @@ func TestPlaceOrderHandler_ExcessivePrice(t *testing.T) {
 	}
 
 	jsonData, _ := json.Marshal(req)
-	request := httptest.NewRequest("POST", "/api/place-order", bytes.NewBuffer(jsonData))
+	request := httptest.NewRequest("POST", "/api/v1/orders", bytes.NewBuffer(jsonData))
 	request.Header.Set("Content-Type", "application/json")
 	response := httptest.NewRecorder()
 
@@ -244,7 +317,7 @@ func TestPlaceOrderHandler_ExcessiveQuantity(t *testing.T) {
 	}
 
 	jsonData, _ := json.Marshal(req)
-	request := httptest.NewRequest("POST", "/api/place-order", bytes.NewBuffer(jsonData))
+	request := httptest.NewRequest("POST", "/api/v1/orders", bytes.NewBuffer(jsonData))
 	request.Header.Set("Content-Type", "application/json")
 	response := httptest.NewRecorder()
 
@@ -258,14 +331,15 @@ func TestPlaceOrderHandler_ExcessiveQuantity(t *testing.T) {
 func TestPlaceOrderHandler_WrongMethod(t *testing.T) {
 	setupTest()
 
-	request := httptest.NewRequest("GET", "/api/place-order", nil)
-	response := httptest.NewRecorder()
-
-	placeOrderHandler(response, request)
+	request := httptest.NewRequest("PUT", "/api/v1/orders", nil)
+	response := serve(HTTPConfig{}, request)
 
 	if response.Code != http.StatusMethodNotAllowed {
 		t.Errorf("Expected status 405, got %d", response.Code)
 	}
+	if got := response.Header().Get("Allow"); got != "POST, GET" {
+		t.Errorf("Expected POST and GET to be allowed, got %q", got)
+	}
 }
 
 func TestProcessOrder_BuyOrderNoMatch(t *testing.T) {
@@ -282,11 +356,11 @@ func TestProcessOrder_BuyOrderNoMatch(t *testing.T) {
 
 	processOrder(order)
 
-	if len(orderBook.BuyOrders) != 1 {
-		t.Errorf("Expected 1 buy order in book, got %d", len(orderBook.BuyOrders))
+	if len(bookFor("").bids.orders()) != 1 {
+		t.Errorf("Expected 1 buy order in book, got %d", len(bookFor("").bids.orders()))
 	}
 
-	if orderBook.BuyOrders[0].ID != "test-buy" {
+	if bookFor("").bids.orders()[0].ID != "test-buy" {
 		t.Error("Expected buy order to be in book")
 	}
 }
@@ -305,11 +379,11 @@ func TestProcessOrder_SellOrderNoMatch(t *testing.T) {
 
 	processOrder(order)
 
-	if len(orderBook.SellOrders) != 1 {
-		t.Errorf("Expected 1 sell order in book, got %d", len(orderBook.SellOrders))
+	if len(bookFor("").asks.orders()) != 1 {
+		t.Errorf("Expected 1 sell order in book, got %d", len(bookFor("").asks.orders()))
 	}
 
-	if orderBook.SellOrders[0].ID != "test-sell" {
+	if bookFor("").asks.orders()[0].ID != "test-sell" {
 		t.Error("Expected sell order to be in book")
 	}
 }
@@ -326,7 +400,7 @@ func TestProcessOrder_BuyOrderMatchesSell(t *testing.T) {
 		Status:    OrderStatusPending,
 		CreatedAt: time.Now(),
 	}
-	orderBook.SellOrders = append(orderBook.SellOrders, sellOrder)
+	restOn(&bookFor("").asks, sellOrder)
 
 	// Place a buy order that should match
 	buyOrder := Order{
@@ -341,26 +415,26 @@ func TestProcessOrder_BuyOrderMatchesSell(t *testing.T) {
 	processOrder(buyOrder)
 
 	// Should have no buy orders (fully matched)
-	if len(orderBook.BuyOrders) != 0 {
-		t.Errorf("Expected 0 buy orders, got %d", len(orderBook.BuyOrders))
+	if len(bookFor("").bids.orders()) != 0 {
+		t.Errorf("Expected 0 buy orders, got %d", len(bookFor("").bids.orders()))
 	}
 
 	// Should have 1 sell order with reduced quantity
-	if len(orderBook.SellOrders) != 1 {
-		t.Errorf("Expected 1 sell order, got %d", len(orderBook.SellOrders))
+	if len(bookFor("").asks.orders()) != 1 {
+		t.Errorf("Expected 1 sell order, got %d", len(bookFor("").asks.orders()))
 	}
 
-	if orderBook.SellOrders[0].Quantity != 5 {
-		t.Errorf("Expected sell order quantity to be 5, got %d", orderBook.SellOrders[0].Quantity)
+	if bookFor("").asks.orders()[0].Quantity != 5 {
+		t.Errorf("Expected sell order quantity to be 5, got %d", bookFor("").asks.orders()[0].Quantity)
 	}
 
 	// Should have 1 trade
-	if len(trades) != 1 {
-		t.Errorf("Expected 1 trade, got %d", len(trades))
+	if len(tradeStore.List("")) != 1 {
+		t.Errorf("Expected 1 trade, got %d", len(tradeStore.List("")))
 	}
 
-	if trades[0].Price != 100.0 {
-		t.Errorf("Expected trade price to be 100.0, got %.2f", trades[0].Price)
+	if tradeStore.List("")[0].Price != 100.0 {
+		t.Errorf("Expected trade price to be 100.0, got %.2f", tradeStore.List("")[0].Price)
 	}
 }
 
@@ -376,7 +450,7 @@ func TestProcessOrder_SellOrderMatchesBuy(t *testing.T) {
 		Status:    OrderStatusPending,
 		CreatedAt: time.Now(),
 	}
-	orderBook.BuyOrders = append(orderBook.BuyOrders, buyOrder)
+	restOn(&bookFor("").bids, buyOrder)
 
 	// Place a sell order that should match
 	sellOrder := Order{
@@ -391,26 +465,26 @@ func TestProcessOrder_SellOrderMatchesBuy(t *testing.T) {
 	processOrder(sellOrder)
 
 	// Should have no sell orders (fully matched)
-	if len(orderBook.SellOrders) != 0 {
-		t.Errorf("Expected 0 sell orders, got %d", len(orderBook.SellOrders))
+	if len(bookFor("").asks.orders()) != 0 {
+		t.Errorf("Expected 0 sell orders, got %d", len(bookFor("").asks.orders()))
 	}
 
 	// Should have 1 buy order with reduced quantity
-	if len(orderBook.BuyOrders) != 1 {
-		t.Errorf("Expected 1 buy order, got %d", len(orderBook.BuyOrders))
+	if len(bookFor("").bids.orders()) != 1 {
+		t.Errorf("Expected 1 buy order, got %d", len(bookFor("").bids.orders()))
 	}
 
-	if orderBook.BuyOrders[0].Quantity != 5 {
-		t.Errorf("Expected buy order quantity to be 5, got %d", orderBook.BuyOrders[0].Quantity)
+	if bookFor("").bids.orders()[0].Quantity != 5 {
+		t.Errorf("Expected buy order quantity to be 5, got %d", bookFor("").bids.orders()[0].Quantity)
 	}
 
 	// Should have 1 trade
-	if len(trades) != 1 {
-		t.Errorf("Expected 1 trade, got %d", len(trades))
+	if len(tradeStore.List("")) != 1 {
+		t.Errorf("Expected 1 trade, got %d", len(tradeStore.List("")))
 	}
 
-	if trades[0].Price != 101.0 {
-		t.Errorf("Expected trade price to be 101.0, got %.2f", trades[0].Price)
+	if tradeStore.List("")[0].Price != 101.0 {
+		t.Errorf("Expected trade price to be 101.0, got %.2f", tradeStore.List("")[0].Price)
 	}
 }
 
@@ -426,7 +500,7 @@ func TestProcessOrder_PartialMatch(t *testing.T) {
 		Status:    OrderStatusPending,
 		CreatedAt: time.Now(),
 	}
-	orderBook.SellOrders = append(orderBook.SellOrders, sellOrder)
+	restOn(&bookFor("").asks, sellOrder)
 
 	// Place a buy order with larger quantity
 	buyOrder := Order{
@@ -441,22 +515,22 @@ func TestProcessOrder_PartialMatch(t *testing.T) {
 	processOrder(buyOrder)
 
 	// Should have 1 buy order with remaining quantity
-	if len(orderBook.BuyOrders) != 1 {
-		t.Errorf("Expected 1 buy order, got %d", len(orderBook.BuyOrders))
+	if len(bookFor("").bids.orders()) != 1 {
+		t.Errorf("Expected 1 buy order, got %d", len(bookFor("").bids.orders()))
 	}
 
-	if orderBook.BuyOrders[0].Quantity != 5 {
-		t.Errorf("Expected buy order quantity to be 5, got %d", orderBook.BuyOrders[0].Quantity)
+	if bookFor("").bids.orders()[0].Quantity != 5 {
+		t.Errorf("Expected buy order quantity to be 5, got %d", bookFor("").bids.orders()[0].Quantity)
 	}
 
 	// Should have no sell orders (fully matched)
-	if len(orderBook.SellOrders) != 0 {
-		t.Errorf("Expected 0 sell orders, got %d", len(orderBook.SellOrders))
+	if len(bookFor("").asks.orders()) != 0 {
+		t.Errorf("Expected 0 sell orders, got %d", len(bookFor("").asks.orders()))
 	}
 
 	// Should have 1 trade
-	if len(trades) != 1 {
-		t.Errorf("Expected 1 trade, got %d", len(trades))
+	if len(tradeStore.List("")) != 1 {
+		t.Errorf("Expected 1 trade, got %d", len(tradeStore.List("")))
 	}
 }
 
@@ -480,7 +554,7 @@ func TestProcessOrder_MultipleMatches(t *testing.T) {
 		Status:    OrderStatusPending,
 		CreatedAt: time.Now().Add(time.Millisecond),
 	}
-	orderBook.SellOrders = append(orderBook.SellOrders, sellOrder1, sellOrder2)
+	restOn(&bookFor("").asks, sellOrder1, sellOrder2)
 
 	// Place a buy order that should match both
 	buyOrder := Order{
@@ -495,32 +569,32 @@ func TestProcessOrder_MultipleMatches(t *testing.T) {
 	processOrder(buyOrder)
 
 	// Should have 0 buy orders (fully consumed)
-	if len(orderBook.BuyOrders) != 0 {
-		t.Errorf("Expected 0 buy orders, got %d", len(orderBook.BuyOrders))
+	if len(bookFor("").bids.orders()) != 0 {
+		t.Errorf("Expected 0 buy orders, got %d", len(bookFor("").bids.orders()))
 	}
 
 	// Should have 1 sell order with remaining quantity
-	if len(orderBook.SellOrders) != 1 {
-		t.Errorf("Expected 1 sell order, got %d", len(orderBook.SellOrders))
+	if len(bookFor("").asks.orders()) != 1 {
+		t.Errorf("Expected 1 sell order, got %d", len(bookFor("").asks.orders()))
 	}
 
-	if orderBook.SellOrders[0].Quantity != 2 {
-		t.Errorf("Expected sell order quantity to be 2, got %d", orderBook.SellOrders[0].Quantity)
+	if bookFor("").asks.orders()[0].Quantity != 2 {
+		t.Errorf("Expected sell order quantity to be 2, got %d", bookFor("").asks.orders()[0].Quantity)
 	}
 
 	// Should have 2 trades
-	if len(trades) != 2 {
-		t.Errorf("Expected 2 trades, got %d", len(trades))
+	if len(tradeStore.List("")) != 2 {
+		t.Errorf("Expected 2 trades, got %d", len(tradeStore.List("")))
 	}
 
 	// First trade should be at $99.00 (better price)
-	if trades[0].Price != 99.0 {
-		t.Errorf("Expected first trade price to be 99.0, got %.2f", trades[0].Price)
+	if tradeStore.List("")[0].Price != 99.0 {
+		t.Errorf("Expected first trade price to be 99.0, got %.2f", tradeStore.List("")[0].Price)
 	}
 
 	// Second trade should be at $100.00
-	if trades[1].Price != 100.0 {
-		t.Errorf("Expected second trade price to be 100.0, got %.2f", trades[1].Price)
+	if tradeStore.List("")[1].Price != 100.0 {
+		t.Errorf("Expected second trade price to be 100.0, got %.2f", tradeStore.List("")[1].Price)
 	}
 }
 
@@ -544,7 +618,7 @@ func TestProcessOrder_PriceTimePriority(t *testing.T) {
 		Status:    OrderStatusPending,
 		CreatedAt: time.Now().Add(time.Millisecond), // Later time
 	}
-	orderBook.SellOrders = append(orderBook.SellOrders, sellOrder1, sellOrder2)
+	restOn(&bookFor("").asks, sellOrder1, sellOrder2)
 
 	// Place a buy order that should match both
 	buyOrder := Order{
@@ -559,18 +633,53 @@ func TestProcessOrder_PriceTimePriority(t *testing.T) {
 	processOrder(buyOrder)
 
 	// Should have 2 trades
-	if len(trades) != 2 {
-		t.Errorf("Expected 2 trades, got %d", len(trades))
+	if len(tradeStore.List("")) != 2 {
+		t.Errorf("Expected 2 trades, got %d", len(tradeStore.List("")))
 	}
 
 	// First trade should be with sell-1 (earlier time)
-	if trades[0].MakerID != "sell-1" {
-		t.Errorf("Expected first trade to be with sell-1, got %s", trades[0].MakerID)
+	if tradeStore.List("")[0].MakerID != "sell-1" {
+		t.Errorf("Expected first trade to be with sell-1, got %s", tradeStore.List("")[0].MakerID)
 	}
 
 	// Second trade should be with sell-2 (later time)
-	if trades[1].MakerID != "sell-2" {
-		t.Errorf("Expected second trade to be with sell-2, got %s", trades[1].MakerID)
+	if tradeStore.List("")[1].MakerID != "sell-2" {
+		t.Errorf("Expected second trade to be with sell-2, got %s", tradeStore.List("")[1].MakerID)
+	}
+}
+
+func TestProcessOrder_ArrivalSequenceBreaksTimestampTies(t *testing.T) {
+	setupTest()
+	created := time.Date(2024, 1, 1, 9, 30, 0, 0, time.UTC)
+	m, _ := matcherFor("")
+
+	var first, second Order
+	m.do(func() {
+		first = processOrder(Order{ID: "sell-1", Side: SideSell, Price: 100.0, Quantity: 5, Status: OrderStatusPending, CreatedAt: created})
+		second = processOrder(Order{ID: "sell-2", Side: SideSell, Price: 100.0, Quantity: 5, Status: OrderStatusPending, CreatedAt: created})
+	})
+	if first.ArrivalSequence != 1 || second.ArrivalSequence != 2 {
+		t.Fatalf("Expected arrival sequences 1 and 2, got %d and %d", first.ArrivalSequence, second.ArrivalSequence)
+	}
+
+	// A restored book is put back in arrival order, and later arrivals rank behind it
+	second.ArrivalSequence = 50
+	m.do(func() {
+		m.restoreBook(BookSnapshot{SellOrders: []Order{second, first}})
+		processOrder(Order{ID: "sell-3", Side: SideSell, Price: 100.0, Quantity: 5, Status: OrderStatusPending, CreatedAt: created})
+	})
+	var ids []string
+	var sequences []int64
+	for _, order := range collectOrders("") {
+		ids, sequences = append(ids, order.ID), append(sequences, order.ArrivalSequence)
+	}
+	if strings.Join(ids, ",") != "sell-1,sell-2,sell-3" || sequences[2] != 51 {
+		t.Errorf("Expected sell-1, sell-2 then sell-3 at 51, got %v %v", ids, sequences)
+	}
+
+	response := serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/orders", nil))
+	if !strings.Contains(response.Body.String(), `"arrival_sequence":51`) {
+		t.Errorf("Expected orders to show their arrival sequence, got %s", response.Body.String())
 	}
 }
 
@@ -586,7 +695,7 @@ func TestProcessOrder_NoMatchDueToPrice(t *testing.T) {
 		Status:    OrderStatusPending,
 		CreatedAt: time.Now(),
 	}
-	orderBook.SellOrders = append(orderBook.SellOrders, sellOrder)
+	restOn(&bookFor("").asks, sellOrder)
 
 	// Place a buy order at $99 (should not match)
 	buyOrder := Order{
@@ -601,18 +710,18 @@ func TestProcessOrder_NoMatchDueToPrice(t *testing.T) {
 	processOrder(buyOrder)
 
 	// Should have 1 buy order in book
-	if len(orderBook.BuyOrders) != 1 {
-		t.Errorf("Expected 1 buy order, got %d", len(orderBook.BuyOrders))
+	if len(bookFor("").bids.orders()) != 1 {
+		t.Errorf("Expected 1 buy order, got %d", len(bookFor("").bids.orders()))
 	}
 
 	// Should have 1 sell order in book
-	if len(orderBook.SellOrders) != 1 {
-		t.Errorf("Expected 1 sell order, got %d", len(orderBook.SellOrders))
+	if len(bookFor("").asks.orders()) != 1 {
+		t.Errorf("Expected 1 sell order, got %d", len(bookFor("").asks.orders()))
 	}
 
 	// Should have no trades
-	if len(trades) != 0 {
-		t.Errorf("Expected 0 trades, got %d", len(trades))
+	if len(tradeStore.List("")) != 0 {
+		t.Errorf("Expected 0 trades, got %d", len(tradeStore.List("")))
 	}
 }
 
@@ -636,10 +745,10 @@ func TestGetOrdersHandler(t *testing.T) {
 		Status:    OrderStatusPending,
 		CreatedAt: time.Now(),
 	}
-	orderBook.BuyOrders = append(orderBook.BuyOrders, order1)
-	orderBook.SellOrders = append(orderBook.SellOrders, order2)
+	restOn(&bookFor("").bids, order1)
+	restOn(&bookFor("").asks, order2)
 
-	request := httptest.NewRequest("GET", "/api/orders", nil)
+	request := httptest.NewRequest("GET", "/api/v1/orders", nil)
 	response := httptest.NewRecorder()
 
 	getOrdersHandler(response, request)
@@ -676,9 +785,10 @@ func TestGetTradesHandler(t *testing.T) {
 		Quantity:  3,
 		CreatedAt: time.Now(),
 	}
-	trades = append(trades, trade1, trade2)
+	tradeStore.Add(trade1)
+	tradeStore.Add(trade2)
 
-	request := httptest.NewRequest("GET", "/api/trades", nil)
+	request := httptest.NewRequest("GET", "/api/v1/trades", nil)
 	response := httptest.NewRecorder()
 
 	getTradesHandler(response, request)
@@ -715,10 +825,10 @@ func TestGetOrderBookHandler(t *testing.T) {
 		Status:    OrderStatusPending,
 		CreatedAt: time.Now(),
 	}
-	orderBook.BuyOrders = append(orderBook.BuyOrders, order1)
-	orderBook.SellOrders = append(orderBook.SellOrders, order2)
+	restOn(&bookFor("").bids, order1)
+	restOn(&bookFor("").asks, order2)
 
-	request := httptest.NewRequest("GET", "/api/orderbook", nil)
+	request := httptest.NewRequest("GET", "/api/v1/orderbook", nil)
 	response := httptest.NewRecorder()
 
 	getOrderBookHandler(response, request)
@@ -818,16 +928,16 @@ func TestAddToOrderBook_BuyOrders(t *testing.T) {
 	addToOrderBook(order2)
 
 	// Should be sorted by price (highest first)
-	if len(orderBook.BuyOrders) != 2 {
-		t.Errorf("Expected 2 buy orders, got %d", len(orderBook.BuyOrders))
+	if len(bookFor("").bids.orders()) != 2 {
+		t.Errorf("Expected 2 buy orders, got %d", len(bookFor("").bids.orders()))
 	}
 
-	if orderBook.BuyOrders[0].Price != 101.0 {
-		t.Errorf("Expected first buy order price to be 101.0, got %.2f", orderBook.BuyOrders[0].Price)
+	if bookFor("").bids.orders()[0].Price != 101.0 {
+		t.Errorf("Expected first buy order price to be 101.0, got %.2f", bookFor("").bids.orders()[0].Price)
 	}
 
-	if orderBook.BuyOrders[1].Price != 100.0 {
-		t.Errorf("Expected second buy order price to be 100.0, got %.2f", orderBook.BuyOrders[1].Price)
+	if bookFor("").bids.orders()[1].Price != 100.0 {
+		t.Errorf("Expected second buy order price to be 100.0, got %.2f", bookFor("").bids.orders()[1].Price)
 	}
 }
 
@@ -856,16 +966,16 @@ func TestAddToOrderBook_SellOrders(t *testing.T) {
 	addToOrderBook(order2)
 
 	// Should be sorted by price (lowest first)
-	if len(orderBook.SellOrders) != 2 {
-		t.Errorf("Expected 2 sell orders, got %d", len(orderBook.SellOrders))
+	if len(bookFor("").asks.orders()) != 2 {
+		t.Errorf("Expected 2 sell orders, got %d", len(bookFor("").asks.orders()))
 	}
 
-	if orderBook.SellOrders[0].Price != 100.0 {
-		t.Errorf("Expected first sell order price to be 100.0, got %.2f", orderBook.SellOrders[0].Price)
+	if bookFor("").asks.orders()[0].Price != 100.0 {
+		t.Errorf("Expected first sell order price to be 100.0, got %.2f", bookFor("").asks.orders()[0].Price)
 	}
 
-	if orderBook.SellOrders[1].Price != 101.0 {
-		t.Errorf("Expected second sell order price to be 101.0, got %.2f", orderBook.SellOrders[1].Price)
+	if bookFor("").asks.orders()[1].Price != 101.0 {
+		t.Errorf("Expected second sell order price to be 101.0, got %.2f", bookFor("").asks.orders()[1].Price)
 	}
 }
 
@@ -889,8 +999,8 @@ func TestGetAllOrders(t *testing.T) {
 		Status:    OrderStatusPending,
 		CreatedAt: time.Now(),
 	}
-	orderBook.BuyOrders = append(orderBook.BuyOrders, order1)
-	orderBook.SellOrders = append(orderBook.SellOrders, order2)
+	restOn(&bookFor("").bids, order1)
+	restOn(&bookFor("").asks, order2)
 
 	allOrders := getAllOrders()
 
@@ -913,7 +1023,7 @@ func TestProcessOrder_ExactPriceMatch(t *testing.T) {
 		Status:    OrderStatusPending,
 		CreatedAt: time.Now(),
 	}
-	orderBook.SellOrders = append(orderBook.SellOrders, sellOrder)
+	restOn(&bookFor("").asks, sellOrder)
 
 	// Place a buy order at exactly $100 (should match)
 	buyOrder := Order{
@@ -928,12 +1038,12 @@ func TestProcessOrder_ExactPriceMatch(t *testing.T) {
 	processOrder(buyOrder)
 
 	// Should have 1 trade
-	if len(trades) != 1 {
-		t.Errorf("Expected 1 trade, got %d", len(trades))
+	if len(tradeStore.List("")) != 1 {
+		t.Errorf("Expected 1 trade, got %d", len(tradeStore.List("")))
 	}
 
-	if trades[0].Price != 100.0 {
-		t.Errorf("Expected trade price to be 100.0, got %.2f", trades[0].Price)
+	if tradeStore.List("")[0].Price != 100.0 {
+		t.Errorf("Expected trade price to be 100.0, got %.2f", tradeStore.List("")[0].Price)
 	}
 }
 
@@ -949,7 +1059,7 @@ func TestProcessOrder_ExactQuantityMatch(t *testing.T) {
 		Status:    OrderStatusPending,
 		CreatedAt: time.Now(),
 	}
-	orderBook.SellOrders = append(orderBook.SellOrders, sellOrder)
+	restOn(&bookFor("").asks, sellOrder)
 
 	// Place a buy order with exactly the same quantity
 	buyOrder := Order{
@@ -964,21 +1074,21 @@ func TestProcessOrder_ExactQuantityMatch(t *testing.T) {
 	processOrder(buyOrder)
 
 	// Should have no orders in book (both fully matched)
-	if len(orderBook.BuyOrders) != 0 {
-		t.Errorf("Expected 0 buy orders, got %d", len(orderBook.BuyOrders))
+	if len(bookFor("").bids.orders()) != 0 {
+		t.Errorf("Expected 0 buy orders, got %d", len(bookFor("").bids.orders()))
 	}
 
-	if len(orderBook.SellOrders) != 0 {
-		t.Errorf("Expected 0 sell orders, got %d", len(orderBook.SellOrders))
+	if len(bookFor("").asks.orders()) != 0 {
+		t.Errorf("Expected 0 sell orders, got %d", len(bookFor("").asks.orders()))
 	}
 
 	// Should have 1 trade
-	if len(trades) != 1 {
-		t.Errorf("Expected 1 trade, got %d", len(trades))
+	if len(tradeStore.List("")) != 1 {
+		t.Errorf("Expected 1 trade, got %d", len(tradeStore.List("")))
 	}
 
-	if trades[0].Quantity != 10 {
-		t.Errorf("Expected trade quantity to be 10, got %d", trades[0].Quantity)
+	if tradeStore.List("")[0].Quantity != 10 {
+		t.Errorf("Expected trade quantity to be 10, got %d", tradeStore.List("")[0].Quantity)
 	}
 }
 
@@ -997,12 +1107,12 @@ func TestProcessOrder_EmptyOrderBook(t *testing.T) {
 
 	processOrder(buyOrder)
 
-	if len(orderBook.BuyOrders) != 1 {
-		t.Errorf("Expected 1 buy order, got %d", len(orderBook.BuyOrders))
+	if len(bookFor("").bids.orders()) != 1 {
+		t.Errorf("Expected 1 buy order, got %d", len(bookFor("").bids.orders()))
 	}
 
-	if len(trades) != 0 {
-		t.Errorf("Expected 0 trades, got %d", len(trades))
+	if len(tradeStore.List("")) != 0 {
+		t.Errorf("Expected 0 trades, got %d", len(tradeStore.List("")))
 	}
 }
 
@@ -1018,7 +1128,7 @@ func TestProcessOrder_OrderStatusUpdates(t *testing.T) {
 		Status:    OrderStatusPending,
 		CreatedAt: time.Now(),
 	}
-	orderBook.SellOrders = append(orderBook.SellOrders, sellOrder)
+	restOn(&bookFor("").asks, sellOrder)
 
 	// Place a buy order that partially matches
 	buyOrder := Order{
@@ -1033,13 +1143,13 @@ func TestProcessOrder_OrderStatusUpdates(t *testing.T) {
 	processOrder(buyOrder)
 
 	// Sell order should be partially filled
-	if orderBook.SellOrders[0].Status != OrderStatusPartiallyFilled {
-		t.Errorf("Expected sell order status to be partially_filled, got %s", orderBook.SellOrders[0].Status)
+	if bookFor("").asks.orders()[0].Status != OrderStatusPartiallyFilled {
+		t.Errorf("Expected sell order status to be partially_filled, got %s", bookFor("").asks.orders()[0].Status)
 	}
 
 	// Buy order should be fully filled (no remaining quantity)
-	if len(orderBook.BuyOrders) != 0 {
-		t.Errorf("Expected 0 buy orders, got %d", len(orderBook.BuyOrders))
+	if len(bookFor("").bids.orders()) != 0 {
+		t.Errorf("Expected 0 buy orders, got %d", len(bookFor("").bids.orders()))
 	}
 }
 
@@ -1055,7 +1165,7 @@ func TestProcessOrder_MakerTakerIdentification(t *testing.T) {
 		Status:    OrderStatusPending,
 		CreatedAt: time.Now(),
 	}
-	orderBook.SellOrders = append(orderBook.SellOrders, sellOrder)
+	restOn(&bookFor("").asks, sellOrder)
 
 	// Place a buy order (will be taker)
 	buyOrder := Order{
@@ -1070,17 +1180,17 @@ func TestProcessOrder_MakerTakerIdentification(t *testing.T) {
 	processOrder(buyOrder)
 
 	// Should have 1 trade
-	if len(trades) != 1 {
-		t.Errorf("Expected 1 trade, got %d", len(trades))
+	if len(tradeStore.List("")) != 1 {
+		t.Errorf("Expected 1 trade, got %d", len(tradeStore.List("")))
 	}
 
 	// Verify maker and taker
-	if trades[0].MakerID != "sell-1" {
-		t.Errorf("Expected maker ID to be sell-1, got %s", trades[0].MakerID)
+	if tradeStore.List("")[0].MakerID != "sell-1" {
+		t.Errorf("Expected maker ID to be sell-1, got %s", tradeStore.List("")[0].MakerID)
 	}
 
-	if trades[0].TakerID != "buy-1" {
-		t.Errorf("Expected taker ID to be buy-1, got %s", trades[0].TakerID)
+	if tradeStore.List("")[0].TakerID != "buy-1" {
+		t.Errorf("Expected taker ID to be buy-1, got %s", tradeStore.List("")[0].TakerID)
 	}
 }
 
@@ -1096,7 +1206,7 @@ func TestProcessOrder_TradePriceIsMakerPrice(t *testing.T) {
 		Status:    OrderStatusPending,
 		CreatedAt: time.Now(),
 	}
-	orderBook.SellOrders = append(orderBook.SellOrders, sellOrder)
+	restOn(&bookFor("").asks, sellOrder)
 
 	// Place a buy order at $101 (taker)
 	buyOrder := Order{
@@ -1111,12 +1221,12 @@ func TestProcessOrder_TradePriceIsMakerPrice(t *testing.T) {
 	processOrder(buyOrder)
 
 	// Trade should execute at maker's price ($100)
-	if len(trades) != 1 {
-		t.Errorf("Expected 1 trade, got %d", len(trades))
+	if len(tradeStore.List("")) != 1 {
+		t.Errorf("Expected 1 trade, got %d", len(tradeStore.List("")))
 	}
 
-	if trades[0].Price != 100.0 {
-		t.Errorf("Expected trade price to be 100.0 (maker's price), got %.2f", trades[0].Price)
+	if tradeStore.List("")[0].Price != 100.0 {
+		t.Errorf("Expected trade price to be 100.0 (maker's price), got %.2f", tradeStore.List("")[0].Price)
 	}
 }
 
@@ -1137,21 +1247,21 @@ func TestProcessOrder_ConcurrentOrders(t *testing.T) {
 	}
 
 	// Verify buy orders are sorted by price (highest first)
-	if len(orderBook.BuyOrders) != 3 {
-		t.Errorf("Expected 3 buy orders, got %d", len(orderBook.BuyOrders))
+	if len(bookFor("").bids.orders()) != 3 {
+		t.Errorf("Expected 3 buy orders, got %d", len(bookFor("").bids.orders()))
 	}
 
-	if orderBook.BuyOrders[0].Price != 101.0 {
-		t.Errorf("Expected first buy order price to be 101.0, got %.2f", orderBook.BuyOrders[0].Price)
+	if bookFor("").bids.orders()[0].Price != 101.0 {
+		t.Errorf("Expected first buy order price to be 101.0, got %.2f", bookFor("").bids.orders()[0].Price)
 	}
 
 	// Verify sell orders are sorted by price (lowest first)
-	if len(orderBook.SellOrders) != 2 {
-		t.Errorf("Expected 2 sell orders, got %d", len(orderBook.SellOrders))
+	if len(bookFor("").asks.orders()) != 2 {
+		t.Errorf("Expected 2 sell orders, got %d", len(bookFor("").asks.orders()))
 	}
 
-	if orderBook.SellOrders[0].Price != 102.0 {
-		t.Errorf("Expected first sell order price to be 102.0, got %.2f", orderBook.SellOrders[0].Price)
+	if bookFor("").asks.orders()[0].Price != 102.0 {
+		t.Errorf("Expected first sell order price to be 102.0, got %.2f", bookFor("").asks.orders()[0].Price)
 	}
 }
 
@@ -1167,7 +1277,7 @@ func TestProcessOrder_EdgeCasePrices(t *testing.T) {
 		Status:    OrderStatusPending,
 		CreatedAt: time.Now(),
 	}
-	orderBook.SellOrders = append(orderBook.SellOrders, sellOrder)
+	restOn(&bookFor("").asks, sellOrder)
 
 	buyOrder := Order{
 		ID:        "buy-1",
@@ -1181,8 +1291,8 @@ func TestProcessOrder_EdgeCasePrices(t *testing.T) {
 	processOrder(buyOrder)
 
 	// Should match due to small price difference
-	if len(trades) != 1 {
-		t.Errorf("Expected 1 trade, got %d", len(trades))
+	if len(tradeStore.List("")) != 1 {
+		t.Errorf("Expected 1 trade, got %d", len(tradeStore.List("")))
 	}
 }
 
@@ -1198,7 +1308,7 @@ func TestProcessOrder_EdgeCaseQuantities(t *testing.T) {
 		Status:    OrderStatusPending,
 		CreatedAt: time.Now(),
 	}
-	orderBook.SellOrders = append(orderBook.SellOrders, sellOrder)
+	restOn(&bookFor("").asks, sellOrder)
 
 	buyOrder := Order{
 		ID:        "buy-1",
@@ -1212,12 +1322,12 @@ func TestProcessOrder_EdgeCaseQuantities(t *testing.T) {
 	processOrder(buyOrder)
 
 	// Should match with quantity 1
-	if len(trades) != 1 {
-		t.Errorf("Expected 1 trade, got %d", len(trades))
+	if len(tradeStore.List("")) != 1 {
+		t.Errorf("Expected 1 trade, got %d", len(tradeStore.List("")))
 	}
 
-	if trades[0].Quantity != 1 {
-		t.Errorf("Expected trade quantity to be 1, got %d", trades[0].Quantity)
+	if tradeStore.List("")[0].Quantity != 1 {
+		t.Errorf("Expected trade quantity to be 1, got %d", tradeStore.List("")[0].Quantity)
 	}
 }
 
@@ -1233,7 +1343,7 @@ func TestProcessOrder_HandlerReturnsCorrectTrades(t *testing.T) {
 		Status:    OrderStatusPending,
 		CreatedAt: time.Now(),
 	}
-	orderBook.SellOrders = append(orderBook.SellOrders, sellOrder)
+	restOn(&bookFor("").asks, sellOrder)
 
 	// Place a buy order via handler
 	req := PlaceOrderRequest{
@@ -1243,7 +1353,7 @@ func TestProcessOrder_HandlerReturnsCorrectTrades(t *testing.T) {
 	}
 
 	jsonData, _ := json.Marshal(req)
-	request := httptest.NewRequest("POST", "/api/place-order", bytes.NewBuffer(jsonData))
+	request := httptest.NewRequest("POST", "/api/v1/orders", bytes.NewBuffer(jsonData))
 	request.Header.Set("Content-Type", "application/json")
 	response := httptest.NewRecorder()
 
@@ -1286,7 +1396,7 @@ func TestProcessOrder_HandlerReturnsTradesInOrder(t *testing.T) {
 		Status:    OrderStatusPending,
 		CreatedAt: time.Now().Add(time.Millisecond),
 	}
-	orderBook.SellOrders = append(orderBook.SellOrders, sellOrder1, sellOrder2)
+	restOn(&bookFor("").asks, sellOrder1, sellOrder2)
 
 	// Place a buy order that matches both
 	req := PlaceOrderRequest{
@@ -1296,7 +1406,7 @@ func TestProcessOrder_HandlerReturnsTradesInOrder(t *testing.T) {
 	}
 
 	jsonData, _ := json.Marshal(req)
-	request := httptest.NewRequest("POST", "/api/place-order", bytes.NewBuffer(jsonData))
+	request := httptest.NewRequest("POST", "/api/v1/orders", bytes.NewBuffer(jsonData))
 	request.Header.Set("Content-Type", "application/json")
 	response := httptest.NewRecorder()
 
@@ -1324,3 +1434,123 @@ func TestProcessOrder_HandlerReturnsTradesInOrder(t *testing.T) {
 		t.Errorf("Expected second trade price to be 100.0, got %.2f", result.Trades[1].Price)
 	}
 }
+
+func TestAddToOrderBook_EqualOrdersKeepArrivalOrder(t *testing.T) {
+	setupTest()
+
+	now := time.Now()
+	for _, id := range []string{"sell-1", "sell-2", "sell-3"} {
+		addToOrderBook(Order{ID: id, Side: SideSell, Price: 100.0, Quantity: 1, Status: OrderStatusPending, CreatedAt: now})
+	}
+	addToOrderBook(Order{ID: "sell-0", Side: SideSell, Price: 99.0, Quantity: 1, Status: OrderStatusPending, CreatedAt: now})
+
+	expected := []string{"sell-0", "sell-1", "sell-2", "sell-3"}
+	for i, id := range expected {
+		if bookFor("").asks.orders()[i].ID != id {
+			t.Errorf("Expected %s at position %d, got %s", id, i, bookFor("").asks.orders()[i].ID)
+		}
+	}
+}
+
+func TestMatchSellOrder_SortsUnsortedBook(t *testing.T) {
+	setupTest()
+
+	now := time.Now()
+	restOn(&bookFor("").bids,
+		Order{ID: "buy-1", Side: SideBuy, Price: 99.0, Quantity: 5, Status: OrderStatusPending, CreatedAt: now},
+		Order{ID: "buy-2", Side: SideBuy, Price: 101.0, Quantity: 5, Status: OrderStatusPending, CreatedAt: now.Add(time.Millisecond)},
+		Order{ID: "buy-3", Side: SideBuy, Price: 101.0, Quantity: 5, Status: OrderStatusPending, CreatedAt: now},
+	)
+
+	_, fills := matchSellOrder(bookFor(""), Order{ID: "sell-1", Side: SideSell, Price: 100.0, Quantity: 6, Status: OrderStatusPending, CreatedAt: now}, nil)
+
+	if len(fills) != 2 || fills[0].MakerID != "buy-3" || fills[1].MakerID != "buy-2" {
+		t.Errorf("Expected fills against buy-3 then buy-2, got %+v", fills)
+	}
+}
+
+// seedDepth rests levels orders on each side of the default book around 100.00
+func seedDepth(levels int) {
+	for i := 0; i < levels; i++ {
+		offset := 0.5 + float64(i)*0.01
+		processOrder(Order{ID: fmt.Sprintf("bid-%d", i), Side: SideBuy, Price: 100.0 - offset, Quantity: 10, Status: OrderStatusPending, CreatedAt: engineClock.Now()})
+		processOrder(Order{ID: fmt.Sprintf("ask-%d", i), Side: SideSell, Price: 100.0 + offset, Quantity: 10, Status: OrderStatusPending, CreatedAt: engineClock.Now()})
+	}
+}
+
+func TestProcessOrder_TradesCarryAggressorAndTick(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "ask-1", Side: SideSell, Price: 100.0, Quantity: 1})
+	placeOn(m, Order{ID: "ask-2", Side: SideSell, Price: 101.0, Quantity: 1})
+	placeOn(m, Order{ID: "ask-3", Side: SideSell, Price: 101.0, Quantity: 1})
+	placeOn(m, Order{ID: "bid-1", Side: SideBuy, Price: 99.0, Quantity: 1})
+
+	// One buy sweeps three asks, then a sell hits the bid
+	placeOn(m, Order{ID: "buy-1", Side: SideBuy, Price: 101.0, Quantity: 3})
+	placeOn(m, Order{ID: "sell-1", Side: SideSell, Price: 99.0, Quantity: 1})
+
+	want := []struct {
+		side Side
+		tick TickDirection
+	}{{SideBuy, ""}, {SideBuy, TickUp}, {SideBuy, TickZero}, {SideSell, TickDown}}
+	trades := tradeStore.List("")
+	if len(trades) != len(want) {
+		t.Fatalf("Expected %d trades, got %+v", len(want), trades)
+	}
+	for i, trade := range trades {
+		if trade.AggressorSide != want[i].side || trade.TickDirection != want[i].tick {
+			t.Errorf("Trade %d: expected %s %q, got %s %q", i, want[i].side, want[i].tick, trade.AggressorSide, trade.TickDirection)
+		}
+	}
+}
+
+func TestGetTradesHandler_FiltersByAggressorSide(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "ask-1", Side: SideSell, Price: 100.0, Quantity: 1})
+	placeOn(m, Order{ID: "buy-1", Side: SideBuy, Price: 100.0, Quantity: 1})
+	placeOn(m, Order{ID: "bid-1", Side: SideBuy, Price: 99.0, Quantity: 2})
+	placeOn(m, Order{ID: "sell-1", Side: SideSell, Price: 99.0, Quantity: 2})
+
+	response := serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/trades?aggressor_side=sell", nil))
+	var result TradesResponse
+	json.NewDecoder(response.Body).Decode(&result)
+	if result.Count != 1 || result.Trades[0].TakerID != "sell-1" || result.Trades[0].TickDirection != TickDown {
+		t.Errorf("Expected only the sell-initiated trade, got %+v", result)
+	}
+
+	response = serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/trades?aggressor_side=up", nil))
+	if result := decodeError(t, response); response.Code != http.StatusBadRequest || result.Error.Code != ErrCodeValidationFailed {
+		t.Errorf("Expected an unknown side to fail validation, got %d %s", response.Code, result.Error.Code)
+	}
+}
+
+func BenchmarkProcessOrder_RestAndFill(b *testing.B) {
+	setupTest()
+	useDeterministicEngine(b, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	seedDepth(500)
+
+	maker := Order{ID: "maker", Side: SideSell, Price: 100.0, Quantity: 10, Status: OrderStatusPending}
+	taker := Order{ID: "taker", Side: SideBuy, Price: 100.0, Quantity: 10, Status: OrderStatusPending}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		maker.CreatedAt = engineClock.Now()
+		processOrder(maker)
+		taker.CreatedAt = engineClock.Now()
+		processOrder(taker)
+	}
+}
+
+func BenchmarkMatcher_Do(b *testing.B) {
+	setupTest()
+	m, _ := matcherFor("")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.do(func() {})
+	}
+}
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// MarginConfig sets the margin perpetual positions are held on
+type MarginConfig struct {
+	// MaxLeverage is the highest leverage an account may choose, and the
+	// leverage every account starts at
+	MaxLeverage float64
+	// MaintenanceRate is the fraction of its positions' value an account must
+	// keep as equity before it is liquidated
+	MaintenanceRate float64
+	// LiquidationFeeBps is paid into the insurance fund on liquidation fills
+	LiquidationFeeBps float64
+}
+
+// MarginStatus is how an account's equity compares with its margin
+type MarginStatus string
+
+const (
+	// MarginHealthy accounts have at least their initial margin
+	MarginHealthy MarginStatus = "healthy"
+	// MarginCall accounts are below their initial margin but not their
+	// maintenance margin. They can only place orders that reduce exposure.
+	MarginCall MarginStatus = "margin_call"
+	// MarginLiquidating accounts are below their maintenance margin and have
+	// their positions closed by the engine
+	MarginLiquidating MarginStatus = "liquidating"
+)
+
+// AssetMargin is an account's margin in one settlement asset
+type AssetMargin struct {
+	Asset   string  `json:"asset"`
+	Balance float64 `json:"balance"`
+	// UnrealizedPnL values the open positions settled in the asset at their marks
+	UnrealizedPnL float64 `json:"unrealized_pnl"`
+	// Equity is the balance plus the unrealized profit or loss
+	Equity float64 `json:"equity"`
+	// PositionValue is the open quantity at the marks, long or short
+	PositionValue     float64      `json:"position_value"`
+	InitialMargin     float64      `json:"initial_margin"`
+	MaintenanceMargin float64      `json:"maintenance_margin"`
+	Status            MarginStatus `json:"status"`
+}
+
+// MarginEvent records an account's margin status changing on a mark price update
+type MarginEvent struct {
+	ID        string       `json:"id"`
+	AccountID string       `json:"account_id"`
+	Status    MarginStatus `json:"status"`
+	// Symbol and MarkPrice are the mark update that changed the status
+	Symbol    string      `json:"symbol"`
+	MarkPrice float64     `json:"mark_price"`
+	Margin    AssetMargin `json:"margin"`
+	At        time.Time   `json:"at"`
+}
+
+// Liquidation is a market order the engine sent to close a position
+type Liquidation struct {
+	OrderID   string `json:"order_id"`
+	AccountID string `json:"account_id"`
+	Symbol    string `json:"symbol"`
+	Side      Side   `json:"side"`
+	Quantity  int    `json:"quantity"`
+	Filled    int    `json:"filled"`
+	// BankruptcyPrice is where the position would have left no equity
+	BankruptcyPrice float64 `json:"bankruptcy_price"`
+	// Fee went to the insurance fund, which paid InsurancePaid of any loss
+	// beyond the account's balance
+	Fee           float64 `json:"fee,omitempty"`
+	InsurancePaid float64 `json:"insurance_paid,omitempty"`
+	// Deleveraged is what the book could not fill and was closed against
+	// opposing positions while the insurance fund was empty
+	Deleveraged int `json:"deleveraged,omitempty"`
+	// Cancelled lists the account's resting orders cancelled first
+	Cancelled []string  `json:"cancelled,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+// MarginResponse is an account's leverage, margin and liquidation history
+type MarginResponse struct {
+	AccountID    string        `json:"account_id"`
+	Leverage     float64       `json:"leverage"`
+	Status       MarginStatus  `json:"status"`
+	Assets       []AssetMargin `json:"assets"`
+	Events       []MarginEvent `json:"events"`
+	Liquidations []Liquidation `json:"liquidations"`
+}
+
+// LeverageRequest chooses the leverage an account holds its positions at
+type LeverageRequest struct {
+	Leverage float64 `json:"leverage" validate:"min=1"`
+}
+
+// margin is the configuration positions are margined under
+var margin = MarginConfig{MaxLeverage: 10, MaintenanceRate: 0.05}
+
+// leverages, marginStatuses, perpetualMarks, marginEvents and liquidations
+// are guarded by accountsMu
+var (
+	leverages      = make(map[string]float64)
+	marginStatuses = make(map[string]MarginStatus)
+	// perpetualMarks is the last mark price margin saw for each perpetual
+	perpetualMarks = make(map[string]float64)
+	marginEvents   []MarginEvent
+	liquidations   []Liquidation
+)
+
+// leverageLocked returns an account's leverage. It must be called with accountsMu held.
+func leverageLocked(id string) float64 {
+	if leverage, ok := leverages[id]; ok {
+		return leverage
+	}
+	return margin.MaxLeverage
+}
+
+// markLocked values a position at its symbol's last mark, or at its entry
+// price before there is one. It must be called with accountsMu held.
+func markLocked(position *Position) float64 {
+	if mark, ok := perpetualMarks[position.Symbol]; ok {
+		return mark
+	}
+	return position.EntryPrice
+}
+
+// marginLocked works out an account's margin in each asset its positions
+// settle in, and its status, which is the worst of them. It must be called
+// with accountsMu held.
+func marginLocked(id string) ([]AssetMargin, MarginStatus) {
+	leverage := leverageLocked(id)
+	byAsset := make(map[string]*AssetMargin)
+	for _, position := range positions[id] {
+		if position.Quantity == 0 {
+			continue
+		}
+		asset := perpetualAsset(position.Symbol)
+		held, ok := byAsset[asset]
+		if !ok {
+			held = &AssetMargin{Asset: asset, Balance: ledgerBalances[id][asset]}
+			byAsset[asset] = held
+		}
+		mark := markLocked(position)
+		value := math.Abs(float64(position.Quantity)) * mark
+		held.UnrealizedPnL += float64(position.Quantity) * (mark - position.EntryPrice)
+		held.PositionValue += value
+		held.InitialMargin += value / leverage
+		held.MaintenanceMargin += value * margin.MaintenanceRate
+	}
+
+	status := MarginHealthy
+	list := make([]AssetMargin, 0, len(byAsset))
+	for _, held := range byAsset {
+		held.Equity = held.Balance + held.UnrealizedPnL
+		switch {
+		case held.Equity < held.MaintenanceMargin:
+			held.Status = MarginLiquidating
+		case held.Equity < held.InitialMargin:
+			held.Status = MarginCall
+		default:
+			held.Status = MarginHealthy
+		}
+		if held.Status == MarginLiquidating || (held.Status == MarginCall && status == MarginHealthy) {
+			status = held.Status
+		}
+		list = append(list, *held)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Asset < list[j].Asset })
+	return list, status
+}
+
+// exposure is the largest position an owner could end up with in a symbol if
+// all of their resting buys or all of their resting sells filled
+func exposure(held, buys, sells int) int {
+	return max(abs(held+buys), abs(held-sells))
+}
+
+// restingQuantity totals the owners' resting buys and sells in a book,
+// including stops that have not fired
+func restingQuantity(book *OrderBook, owners map[string]bool) (buys, sells int) {
+	for _, orders := range [][]Order{book.bids.orders(), book.asks.orders(), book.stops} {
+		for _, order := range orders {
+			if !owners[order.Owner] {
+				continue
+			}
+			if order.Side == SideBuy {
+				buys += order.Quantity
+			} else {
+				sells += order.Quantity
+			}
+		}
+	}
+	return buys, sells
+}
+
+// hasInitialMargin reports whether an order on a perpetual would leave its
+// owner's equity covering the initial margin of their positions, counting
+// the order and their resting orders in the symbol as filled. Orders that do
+// not add to the owner's exposure always pass. A sub-account sharing its
+// parent's balances is margined on the parent's, with the orders of every
+// account sharing them. It must run on the symbol's matcher.
+func hasInitialMargin(book *OrderBook, order Order) bool {
+	if order.Owner == "" {
+		return true
+	}
+	accountsMu.Lock()
+	defer accountsMu.Unlock()
+
+	_, perpetual := perpetuals[order.Symbol]
+	_, account := accounts[order.Owner]
+	if !perpetual || !account {
+		return true
+	}
+
+	owner := fundingAccountLocked(order.Owner)
+	held := 0
+	position := positions[owner][order.Symbol]
+	if position != nil {
+		held = position.Quantity
+	}
+	group := riskGroupLocked(order.Owner)
+	before, after := addedExposure(book, group, held, order)
+	if after <= before {
+		return true
+	}
+
+	asset := perpetualAsset(order.Symbol)
+	leverage := leverageLocked(owner)
+
+	equity, required := ledgerBalances[owner][asset], 0.0
+	assets, _ := marginLocked(owner)
+	for _, held := range assets {
+		if held.Asset == asset {
+			equity, required = held.Equity, held.InitialMargin
+		}
+	}
+	if position != nil && position.Quantity != 0 {
+		// The symbol's position is counted again with the orders
+		required -= math.Abs(float64(position.Quantity)) * markLocked(position) / leverage
+	}
+	required += float64(after) * orderPriceLocked(order) / leverage
+
+	// The earlier orders of a basket count as resting in their own symbols
+	for _, earlier := range book.basket {
+		if _, ok := perpetuals[earlier.Symbol]; !ok || perpetualAsset(earlier.Symbol) != asset {
+			continue
+		}
+		held := 0
+		if position := positions[owner][earlier.Symbol]; position != nil {
+			held = position.Quantity
+		}
+		before, after := addedExposure(bookFor(earlier.Symbol), group, held, earlier)
+		if after > before {
+			required += float64(after-before) * orderPriceLocked(earlier) / leverage
+		}
+	}
+	return equity >= required
+}
+
+// addedExposure returns the owners' exposure in an order's symbol given their
+// position and resting orders there, before and after the order rests too
+func addedExposure(book *OrderBook, owners map[string]bool, held int, order Order) (before, after int) {
+	buys, sells := restingQuantity(book, owners)
+	before = exposure(held, buys, sells)
+	if order.Side == SideBuy {
+		buys += order.Quantity
+	} else {
+		sells += order.Quantity
+	}
+	return before, exposure(held, buys, sells)
+}
+
+// orderPriceLocked is the price an order is margined at. Orders without a
+// price of their own, such as market orders, are valued at the mark.
+func orderPriceLocked(order Order) float64 {
+	if order.Price == 0 {
+		return perpetualMarks[order.Symbol]
+	}
+	return order.Price
+}
+
+// checkMargins revalues the positions in the matcher's perpetual at a new
+// mark price. Accounts that fall below their initial margin get a margin
+// call, and those below their maintenance margin are liquidated. It must run
+// on the matcher.
+func checkMargins(m *matcher, mark float64) {
+	accountsMu.Lock()
+	if _, ok := perpetuals[m.symbol]; !ok {
+		accountsMu.Unlock()
+		return
+	}
+	perpetualMarks[m.symbol] = mark
+
+	owners := make([]string, 0)
+	for owner, held := range positions {
+		// Accounts that were in trouble are checked again, so that a closed
+		// position clears their status
+		if position := held[m.symbol]; (position != nil && position.Quantity != 0) || marginStatuses[owner] != "" && marginStatuses[owner] != MarginHealthy {
+			owners = append(owners, owner)
+		}
+	}
+	sort.Strings(owners)
+
+	var liquidating []string
+	elsewhere := make(map[string][]string)
+	for _, owner := range owners {
+		assets, status := marginLocked(owner)
+		previous := marginStatuses[owner]
+		if previous == "" {
+			previous = MarginHealthy
+		}
+		if status != previous {
+			event := MarginEvent{ID: generateOrderID(), AccountID: owner, Status: status, Symbol: m.symbol, MarkPrice: mark, At: engineClock.Now()}
+			for _, held := range assets {
+				if held.Asset == perpetualAsset(m.symbol) {
+					event.Margin = held
+				}
+			}
+			marginEvents = append(marginEvents, event)
+			marginStatuses[owner] = status
+			log.Printf("margin: %s is %s at a %s mark of %.2f", owner, status, m.symbol, mark)
+		}
+		if status != MarginLiquidating {
+			continue
+		}
+		liquidating = append(liquidating, owner)
+		if previous != MarginLiquidating {
+			for symbol, position := range positions[owner] {
+				if symbol != m.symbol && position.Quantity != 0 {
+					elsewhere[owner] = append(elsewhere[owner], symbol)
+				}
+			}
+		}
+	}
+	accountsMu.Unlock()
+
+	for _, owner := range liquidating {
+		liquidate(m, owner)
+	}
+	// Positions in other symbols are closed on their own matchers
+	for owner, symbols := range elsewhere {
+		for _, symbol := range symbols {
+			if other, ok := matcherFor(symbol); ok {
+				go other.do(func() { liquidate(other, owner) })
+			}
+		}
+	}
+}
+
+// liquidate cancels an account's orders in the matcher's symbol and closes
+// its position there with an IOC market order. The insurance fund takes a fee
+// on the fills and covers any loss beyond the account's balance. Whatever the
+// book cannot fill is deleveraged if the fund is empty, and otherwise retried
+// on the next mark update. It must run on the matcher.
+func liquidate(m *matcher, owner string) {
+	accountsMu.Lock()
+	quantity := 0
+	if position := positions[owner][m.symbol]; position != nil {
+		quantity = position.Quantity
+	}
+	bankruptcy := bankruptcyPriceLocked(owner, m.symbol)
+	group := riskGroupLocked(owner)
+	accountsMu.Unlock()
+	if quantity == 0 {
+		return
+	}
+
+	// Resting orders would add to the exposure, and any on the other side
+	// would stop the closing order as a self-trade. Sub-accounts sharing the
+	// account's balances add to the same exposure.
+	var ids []string
+	for _, orders := range [][]Order{m.book.bids.orders(), m.book.asks.orders(), m.book.stops} {
+		for _, order := range orders {
+			if group[order.Owner] {
+				ids = append(ids, order.ID)
+			}
+		}
+	}
+	for _, id := range ids {
+		cancelOrder(m.symbol, id)
+	}
+
+	order := Order{
+		ID:          generateOrderID(),
+		Symbol:      m.symbol,
+		Side:        SideSell,
+		Quantity:    abs(quantity),
+		Status:      OrderStatusPending,
+		CreatedAt:   engineClock.Now(),
+		Owner:       owner,
+		Type:        OrderTypeMarket,
+		TimeInForce: TimeInForceIOC,
+	}
+	if quantity < 0 {
+		order.Side = SideBuy
+	}
+	result := processOrder(order)
+	fills := tradeStore.TakerFills(order.ID, result.FilledQuantity)
+	log.Printf("margin: liquidated %d of %s's %d %s", result.FilledQuantity, owner, quantity, m.symbol)
+
+	accountsMu.Lock()
+	liquidation := Liquidation{
+		OrderID:         order.ID,
+		AccountID:       owner,
+		Symbol:          m.symbol,
+		Side:            order.Side,
+		Quantity:        order.Quantity,
+		Filled:          result.FilledQuantity,
+		Cancelled:       ids,
+		BankruptcyPrice: bankruptcy,
+		At:              order.CreatedAt,
+	}
+	liquidation.Fee = chargeLiquidationFeeLocked(owner, m.symbol, fills)
+	liquidation.Deleveraged = deleverageLocked(m.symbol, owner, bankruptcy)
+	liquidation.InsurancePaid = coverDeficitLocked(owner, m.symbol, order.ID)
+	liquidations = append(liquidations, liquidation)
+	accountsMu.Unlock()
+}
+
+// accountMargin describes an account's margin, or returns false if there is
+// no such account
+func accountMargin(id string) (MarginResponse, bool) {
+	accountsMu.Lock()
+	defer accountsMu.Unlock()
+
+	if _, ok := accounts[id]; !ok {
+		return MarginResponse{}, false
+	}
+	response := MarginResponse{AccountID: id, Leverage: leverageLocked(id), Events: make([]MarginEvent, 0), Liquidations: make([]Liquidation, 0)}
+	response.Assets, response.Status = marginLocked(id)
+	for _, event := range marginEvents {
+		if event.AccountID == id {
+			response.Events = append(response.Events, event)
+		}
+	}
+	for _, liquidation := range liquidations {
+		if liquidation.AccountID == id {
+			response.Liquidations = append(response.Liquidations, liquidation)
+		}
+	}
+	return response, true
+}
+
+// getMarginHandler returns the margin of the account named in the path
+func getMarginHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id := r.PathValue("id")
+	response, ok := accountMargin(id)
+	if !ok {
+		writeAccountNotFound(w, id)
+		return
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// setLeverageHandler sets the leverage of the account named in the path
+func setLeverageHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req LeverageRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if req.Leverage > margin.MaxLeverage {
+		writeError(w, http.StatusUnprocessableEntity, ErrCodeLeverageTooHigh, "Leverage too high",
+			fmt.Sprintf("leverage cannot be above %g (received: %g)", margin.MaxLeverage, req.Leverage))
+		return
+	}
+
+	id := r.PathValue("id")
+	accountsMu.Lock()
+	_, ok := accounts[id]
+	if ok {
+		leverages[id] = req.Leverage
+	}
+	accountsMu.Unlock()
+	if !ok {
+		writeAccountNotFound(w, id)
+		return
+	}
+
+	response, _ := accountMargin(id)
+	json.NewEncoder(w).Encode(response)
+}
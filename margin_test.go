@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// processOn places an order on a matcher and returns it in its final state
+func processOn(m *matcher, order Order) Order {
+	order.Status = OrderStatusPending
+	order.CreatedAt = engineClock.Now()
+	m.do(func() { order = processOrder(order) })
+	return order
+}
+
+func TestHasInitialMargin_RejectsOrdersBeyondLeverage(t *testing.T) {
+	setupTest()
+	resetSymbols([]string{"BTC-PERP"})
+	listPerpetual("BTC-PERP")
+	openFunded(t, "alice", map[string]float64{"USD": 100})
+	m, _ := matcherFor("BTC-PERP")
+
+	// 100 USD at 10x leverage covers 1000 USD of exposure
+	if placed := processOn(m, Order{ID: "too-big", Symbol: "BTC-PERP", Side: SideBuy, Price: 100.0, Quantity: 11, Owner: "alice"}); placed.RejectReason != ErrCodeInsufficientMargin {
+		t.Fatalf("Expected INSUFFICIENT_MARGIN, got %+v", placed)
+	}
+	if placed := processOn(m, Order{ID: "bid", Symbol: "BTC-PERP", Side: SideBuy, Price: 100.0, Quantity: 10, Owner: "alice"}); placed.Status != OrderStatusPending {
+		t.Fatalf("Expected the bid to rest, got %+v", placed)
+	}
+	// Resting orders count as filled
+	if placed := processOn(m, Order{ID: "more", Symbol: "BTC-PERP", Side: SideBuy, Price: 100.0, Quantity: 1, Owner: "alice"}); placed.RejectReason != ErrCodeInsufficientMargin {
+		t.Errorf("Expected INSUFFICIENT_MARGIN with the resting bid counted, got %+v", placed)
+	}
+	// A sell smaller than the resting bid adds no exposure
+	if placed := processOn(m, Order{ID: "ask", Symbol: "BTC-PERP", Side: SideSell, Price: 120.0, Quantity: 5, Owner: "alice"}); placed.Status != OrderStatusPending {
+		t.Errorf("Expected the ask to rest, got %+v", placed)
+	}
+	// Owners without an account are not margined
+	if placed := processOn(m, Order{ID: "anonymous", Symbol: "BTC-PERP", Side: SideBuy, Price: 100.0, Quantity: 1000, Owner: "nobody"}); placed.Status != OrderStatusPending {
+		t.Errorf("Expected an order without an account to rest, got %+v", placed)
+	}
+}
+
+func TestCheckMargins_CallsThenLiquidates(t *testing.T) {
+	setupTest()
+	resetSymbols([]string{"BTC-PERP"})
+	listPerpetual("BTC-PERP")
+	openFunded(t, "alice", map[string]float64{"USD": 100})
+	for _, id := range []string{"bob", "carol", "dave"} {
+		openFunded(t, id, map[string]float64{"USD": 10000})
+	}
+	m, _ := matcherFor("BTC-PERP")
+
+	placeOn(m, Order{ID: "ask", Symbol: "BTC-PERP", Side: SideSell, Price: 100.0, Quantity: 10, Owner: "bob"})
+	placeOn(m, Order{ID: "long", Symbol: "BTC-PERP", Side: SideBuy, Price: 100.0, Quantity: 10, Owner: "alice"})
+	if response, _ := accountMargin("alice"); response.Status != MarginHealthy || response.Assets[0].InitialMargin != 100 {
+		t.Fatalf("Expected alice healthy at her initial margin, got %+v", response)
+	}
+
+	for _, bid := range []Order{
+		{ID: "bid-95", Price: 95.0, Quantity: 1},
+		{ID: "bid-94", Price: 94.0, Quantity: 1},
+		{ID: "bid-90", Price: 90.0, Quantity: 20},
+	} {
+		bid.Symbol, bid.Side, bid.Owner = "BTC-PERP", SideBuy, "dave"
+		placeOn(m, bid)
+	}
+
+	// At 95 alice's equity of 50 is below her initial margin of 95
+	placeOn(m, Order{ID: "sell-95", Symbol: "BTC-PERP", Side: SideSell, Price: 95.0, Quantity: 1, Owner: "carol"})
+	response, _ := accountMargin("alice")
+	if response.Status != MarginCall || response.Assets[0].Equity != 50 || len(response.Liquidations) != 0 {
+		t.Fatalf("Expected a margin call, got %+v", response)
+	}
+
+	// At 94 her equity of 40 is below the maintenance margin of 47, so her
+	// position is sold into the bids
+	placeOn(m, Order{ID: "sell-94", Symbol: "BTC-PERP", Side: SideSell, Price: 94.0, Quantity: 1, Owner: "carol"})
+	response, _ = accountMargin("alice")
+	if len(response.Liquidations) != 1 || response.Liquidations[0].Filled != 10 || response.Liquidations[0].Side != SideSell {
+		t.Fatalf("Expected alice's position to be liquidated, got %+v", response)
+	}
+	if list, _ := accountPositions("alice"); list[0].Quantity != 0 || list[0].RealizedPnL != -100 {
+		t.Errorf("Expected the position closed at 90, got %+v", list)
+	}
+
+	statuses := make([]MarginStatus, 0, len(response.Events))
+	for _, event := range response.Events {
+		statuses = append(statuses, event.Status)
+	}
+	if len(statuses) != 3 || statuses[0] != MarginCall || statuses[1] != MarginLiquidating || statuses[2] != MarginHealthy {
+		t.Errorf("Expected a margin call, a liquidation and recovery, got %v", statuses)
+	}
+	if response.Status != MarginHealthy {
+		t.Errorf("Expected alice healthy once flat, got %s", response.Status)
+	}
+}
+
+func TestMarginHandlers(t *testing.T) {
+	setupTest()
+	openFunded(t, "alice", nil)
+
+	for body, status := range map[string]int{
+		`{"leverage": 5}`:   http.StatusOK,
+		`{"leverage": 20}`:  http.StatusUnprocessableEntity,
+		`{"leverage": 0.5}`: http.StatusBadRequest,
+	} {
+		request := httptest.NewRequest("POST", "/api/v1/accounts/alice/leverage", bytes.NewBufferString(body))
+		if result := serve(HTTPConfig{}, request); result.Code != status {
+			t.Errorf("%s: expected %d, got %d", body, status, result.Code)
+		}
+	}
+
+	var response MarginResponse
+	json.NewDecoder(serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/accounts/alice/margin", nil)).Body).Decode(&response)
+	if response.Leverage != 5 || response.Status != MarginHealthy || len(response.Assets) != 0 {
+		t.Errorf("Unexpected margin %+v", response)
+	}
+	if result := serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/accounts/nobody/margin", nil)); result.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown account, got %d", result.Code)
+	}
+}
+
+func TestLoadConfig_Margin(t *testing.T) {
+	cfg, err := loadConfig([]string{"-max-leverage", "20", "-maintenance-margin", "0.02"})
+	if err != nil || cfg.Margin.MaxLeverage != 20 || cfg.Margin.MaintenanceRate != 0.02 {
+		t.Errorf("Unexpected margin config %+v (%v)", cfg.Margin, err)
+	}
+	for _, args := range [][]string{
+		{"-max-leverage", "0.5"},
+		{"-maintenance-margin", "0"},
+		{"-max-leverage", "20", "-maintenance-margin", "0.05"},
+	} {
+		if _, err := loadConfig(args); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// MarkSource is where a symbol's mark price comes from
+type MarkSource string
+
+const (
+	// MarkLastTrade marks at the most recent local trade price
+	MarkLastTrade MarkSource = "last_trade"
+	// MarkMidEMA marks at an exponential moving average of the mid price,
+	// sampled each time the depth changes
+	MarkMidEMA MarkSource = "mid_ema"
+	// MarkIndex marks at an index price pushed or polled from outside the engine
+	MarkIndex MarkSource = "index"
+)
+
+// StopTrigger is the price a trailing stop trails and fires on
+type StopTrigger string
+
+const (
+	// StopTriggerTrade follows local trade prices
+	StopTriggerTrade StopTrigger = "trade"
+	// StopTriggerMark follows the symbol's mark price
+	StopTriggerMark StopTrigger = "mark"
+)
+
+// markEMAAlpha is the weight each new mid sample gets in the mid EMA
+var markEMAAlpha = 0.2
+
+// MarkConfig chooses each symbol's mark price source
+type MarkConfig struct {
+	// Sources maps symbols to their source; the rest mark at the last trade
+	Sources  map[string]MarkSource
+	EMAAlpha float64
+}
+
+// MarkInputs are the reference prices a book keeps besides its last trade
+type MarkInputs struct {
+	MidEMA float64 `json:"mid_ema,omitempty"`
+	Index  float64 `json:"index,omitempty"`
+	// IndexAt is when the index price last arrived, and IndexSource is what
+	// sent it: the name given with a push, or a poller's host
+	IndexAt     *time.Time `json:"index_at,omitempty"`
+	IndexSource string     `json:"index_source,omitempty"`
+}
+
+// markState is a book's mark price source, its inputs, and the mark its
+// stops were last trailed at
+type markState struct {
+	source  MarkSource
+	inputs  MarkInputs
+	trailed float64
+}
+
+// MarkPriceResponse is a symbol's mark price and every price it can come from
+type MarkPriceResponse struct {
+	Symbol string     `json:"symbol"`
+	Source MarkSource `json:"source"`
+	// MarkPrice is taken from Source, or from the first of the last trade and
+	// the mid EMA that has a price while Source has none; zero when none do
+	MarkPrice float64 `json:"mark_price,omitempty"`
+	// From is the source MarkPrice was taken from
+	From      MarkSource `json:"from,omitempty"`
+	LastTrade float64    `json:"last_trade,omitempty"`
+	MarkInputs
+}
+
+// IndexPriceRequest pushes an externally computed index price for a symbol
+type IndexPriceRequest struct {
+	Symbol string  `json:"symbol,omitempty"`
+	Price  float64 `json:"price" validate:"gt=0,max=999999999.99"`
+	// Source names the sender, such as the adapter that computed the price;
+	// "push" when omitted
+	Source string `json:"source,omitempty"`
+}
+
+// configureMarks sets the mark price source of each named symbol
+func configureMarks(sources map[string]MarkSource) {
+	for symbol, source := range sources {
+		m, ok := matcherFor(symbol)
+		if !ok {
+			continue
+		}
+		m.do(func() { m.book.mark.source = source })
+	}
+}
+
+// markPrice returns the book's mark price and the source it came from
+func markPrice(book *OrderBook) (float64, MarkSource) {
+	for _, source := range [...]MarkSource{book.mark.source, MarkLastTrade, MarkMidEMA} {
+		if price := markFrom(book, source); price > 0 {
+			return price, source
+		}
+	}
+	return 0, ""
+}
+
+// markFrom returns the book's price from one source, or zero if it has none
+func markFrom(book *OrderBook, source MarkSource) float64 {
+	switch source {
+	case MarkMidEMA:
+		return book.mark.inputs.MidEMA
+	case MarkIndex:
+		return book.mark.inputs.Index
+	}
+	return book.lastPrice
+}
+
+// refreshMark samples the mid into the EMA when the last command changed the
+// depth, then trails the mark-triggered stops to the new mark, executing any
+// that fire, and checks the margin of the symbol's perpetual positions. It
+// runs on the matcher after each command. A standby takes its mark inputs
+// from the primary and leaves its stops to it.
+func (m *matcher) refreshMark() {
+	if standby.running.Load() {
+		return
+	}
+
+	book := m.book
+	dirty := len(book.dirtyBids) > 0 || len(book.dirtyAsks) > 0
+	bid, bidOK := book.bids.first()
+	ask, askOK := book.asks.first()
+	if dirty && bidOK && askOK {
+		mid := (bid.Price + ask.Price) / 2
+		if book.mark.inputs.MidEMA == 0 {
+			book.mark.inputs.MidEMA = mid
+		} else {
+			book.mark.inputs.MidEMA += markEMAAlpha * (mid - book.mark.inputs.MidEMA)
+		}
+	}
+
+	// Executing a fired stop can trade and move the mark again
+	for {
+		mark, _ := markPrice(book)
+		if mark == 0 || mark == book.mark.trailed {
+			return
+		}
+		book.mark.trailed = mark
+		for _, triggered := range trailMarkStops(book, mark) {
+			executeOrder(book, triggered)
+		}
+		checkMargins(m, mark)
+	}
+}
+
+// markResponse describes the book's mark price. It must run on the book's matcher.
+func markResponse(symbol string, book *OrderBook) MarkPriceResponse {
+	response := MarkPriceResponse{Symbol: symbol, Source: book.mark.source, LastTrade: book.lastPrice, MarkInputs: book.mark.inputs}
+	response.MarkPrice, response.From = markPrice(book)
+	return response
+}
+
+// parseMarkSources reads symbol=source pairs, such as BTC-USD=index
+func parseMarkSources(list string) (map[string]MarkSource, error) {
+	sources := make(map[string]MarkSource)
+	for _, item := range splitList(list) {
+		symbol, raw, ok := strings.Cut(item, "=")
+		source := MarkSource(strings.TrimSpace(raw))
+		if !ok || strings.TrimSpace(symbol) == "" || (source != MarkLastTrade && source != MarkMidEMA && source != MarkIndex) {
+			return nil, fmt.Errorf("-mark-sources entry %q must be symbol=source with a source of last_trade, mid_ema or index", item)
+		}
+		sources[strings.ToUpper(strings.TrimSpace(symbol))] = source
+	}
+	return sources, nil
+}
+
+// getMarkPriceHandler returns a symbol's mark price
+func getMarkPriceHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	m, ok := matcherFor(r.URL.Query().Get("symbol"))
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrCodeUnknownSymbol, "Unknown symbol",
+			"symbol '"+r.URL.Query().Get("symbol")+"' is not traded here")
+		return
+	}
+
+	var response MarkPriceResponse
+	m.do(func() { response = markResponse(m.symbol, m.book) })
+	json.NewEncoder(w).Encode(response)
+}
+
+// pushIndexPriceHandler sets a symbol's index price. Symbols that mark at
+// their index move their mark, and their mark-triggered stops, with it.
+func pushIndexPriceHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req IndexPriceRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	m, ok := matcherFor(req.Symbol)
+	if !ok {
+		writeError(w, http.StatusBadRequest, ErrCodeUnknownSymbol, "Unknown symbol",
+			"symbol '"+req.Symbol+"' is not traded here")
+		return
+	}
+
+	source := req.Source
+	if source == "" {
+		source = "push"
+	}
+	setIndexPrice(m, req.Price, source)
+
+	var response MarkPriceResponse
+	m.do(func() { response = markResponse(m.symbol, m.book) })
+	json.NewEncoder(w).Encode(response)
+}
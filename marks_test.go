@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// pushIndex sets the default symbol's index price through the API
+func pushIndex(t *testing.T, price float64) MarkPriceResponse {
+	t.Helper()
+	body, _ := json.Marshal(IndexPriceRequest{Price: price})
+	result := serve(HTTPConfig{}, httptest.NewRequest("POST", "/api/v1/admin/index-price", bytes.NewReader(body)))
+	if result.Code != http.StatusOK {
+		t.Fatalf("Expected the index push to succeed, got %d: %s", result.Code, result.Body)
+	}
+	var response MarkPriceResponse
+	json.NewDecoder(result.Body).Decode(&response)
+	return response
+}
+
+// markOf reads a matcher's mark price
+func markOf(m *matcher) MarkPriceResponse {
+	var response MarkPriceResponse
+	m.do(func() { response = markResponse(m.symbol, m.book) })
+	return response
+}
+
+func TestMarkPrice_Sources(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+
+	if mark := markOf(m); mark.Source != MarkLastTrade || mark.MarkPrice != 0 || mark.From != "" {
+		t.Errorf("Expected no mark before any prices, got %+v", mark)
+	}
+
+	// The mid EMA starts at the first mid and moves by alpha of each change
+	placeOn(m, Order{ID: "bid", Symbol: "DEFAULT", Side: SideBuy, Price: 99.0, Quantity: 5})
+	placeOn(m, Order{ID: "ask", Symbol: "DEFAULT", Side: SideSell, Price: 101.0, Quantity: 5})
+	placeOn(m, Order{ID: "better-bid", Symbol: "DEFAULT", Side: SideBuy, Price: 100.0, Quantity: 5})
+	mark := markOf(m)
+	if mark.MidEMA != 100.1 {
+		t.Errorf("Expected a mid EMA of 100.1, got %+v", mark)
+	}
+	// Without a trade the last trade source falls back to the mid EMA
+	if mark.MarkPrice != 100.1 || mark.From != MarkMidEMA {
+		t.Errorf("Expected the mark to fall back to the mid EMA, got %+v", mark)
+	}
+
+	placeOn(m, Order{ID: "taker", Symbol: "DEFAULT", Side: SideSell, Price: 100.0, Quantity: 1})
+	if mark := markOf(m); mark.MarkPrice != 100.0 || mark.From != MarkLastTrade || mark.LastTrade != 100.0 {
+		t.Errorf("Expected the mark at the last trade, got %+v", mark)
+	}
+
+	configureMarks(map[string]MarkSource{"DEFAULT": MarkIndex})
+	if mark := markOf(m); mark.Source != MarkIndex || mark.From != MarkLastTrade {
+		t.Errorf("Expected the index source to fall back to the last trade, got %+v", mark)
+	}
+	if mark := pushIndex(t, 105.0); mark.MarkPrice != 105.0 || mark.From != MarkIndex || mark.IndexAt == nil {
+		t.Errorf("Expected the mark at the index, got %+v", mark)
+	}
+}
+
+func TestTrailingStop_FollowsTheMark(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+	configureMarks(map[string]MarkSource{"DEFAULT": MarkIndex})
+	pushIndex(t, 100.0)
+
+	stop := trailingStop("stop", SideSell, 2, 5.0, 0)
+	stop.Symbol, stop.TriggerBy = "DEFAULT", StopTriggerMark
+	m.do(func() { processOrder(stop) })
+	if got, _ := findStop("stop"); got.TriggerPrice != 95.0 {
+		t.Fatalf("Expected the stop anchored at the mark with a trigger of 95, got %+v", got)
+	}
+
+	pushIndex(t, 110.0)
+	if got, _ := findStop("stop"); got.TriggerPrice != 105.0 {
+		t.Fatalf("Expected the trigger to follow the mark up to 105, got %+v", got)
+	}
+
+	// Trades below the trigger do not fire it while the mark holds
+	placeOn(m, Order{ID: "bid", Symbol: "DEFAULT", Side: SideBuy, Price: 90.0, Quantity: 5})
+	placeOn(m, Order{ID: "ask", Symbol: "DEFAULT", Side: SideSell, Price: 90.0, Quantity: 1})
+	if _, ok := findStop("stop"); !ok {
+		t.Fatal("Expected the stop to ignore trade prices")
+	}
+
+	pushIndex(t, 104.0)
+	if _, ok := findStop("stop"); ok {
+		t.Fatal("Expected the stop to fire when the mark reached its trigger")
+	}
+	trades := tradeStore.List("")
+	if last := trades[len(trades)-1]; last.TakerID != "stop" || last.Quantity != 2 || last.Price != 90.0 {
+		t.Errorf("Expected the fired stop to sell into the bid, got %+v", last)
+	}
+}
+
+func TestMarkPriceHandlers(t *testing.T) {
+	setupTest()
+
+	var mark MarkPriceResponse
+	json.NewDecoder(serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/mark-price", nil)).Body).Decode(&mark)
+	if mark.Symbol != "DEFAULT" || mark.Source != MarkLastTrade {
+		t.Errorf("Unexpected mark %+v", mark)
+	}
+	if result := serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/mark-price?symbol=XYZ", nil)); result.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown symbol, got %d", result.Code)
+	}
+
+	for body, status := range map[string]int{
+		`{"price": 0}`:                  http.StatusBadRequest,
+		`{"symbol": "XYZ", "price": 1}`: http.StatusBadRequest,
+	} {
+		if result := serve(HTTPConfig{}, httptest.NewRequest("POST", "/api/v1/admin/index-price", bytes.NewBufferString(body))); result.Code != status {
+			t.Errorf("%s: expected %d, got %d", body, status, result.Code)
+		}
+	}
+}
+
+func TestLoadConfig_MarkSources(t *testing.T) {
+	cfg, err := loadConfig([]string{"-symbols", "BTC-USD,ETH-USD", "-mark-sources", "btc-usd=index, ETH-USD=mid_ema", "-mark-ema-alpha", "0.5"})
+	if err != nil || cfg.Marks.Sources["BTC-USD"] != MarkIndex || cfg.Marks.Sources["ETH-USD"] != MarkMidEMA || cfg.Marks.EMAAlpha != 0.5 {
+		t.Errorf("Unexpected mark config %+v (%v)", cfg.Marks, err)
+	}
+	for _, args := range [][]string{
+		{"-mark-sources", "DEFAULT=vwap"},
+		{"-mark-sources", "SOL-USD=index"},
+		{"-mark-ema-alpha", "0"},
+	} {
+		if _, err := loadConfig(args); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
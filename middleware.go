@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"time"
+)
+
+// HTTPConfig controls the middleware every request passes through
+type HTTPConfig struct {
+	// CORSOrigins lists the browser origins allowed to call the API; "*" allows any
+	CORSOrigins []string
+	// APIKeys are accepted as "Authorization: Bearer <key>" or "X-API-Key";
+	// empty leaves the API open
+	APIKeys []string
+	// Entitlements sets how much market data each API key may see. Keys not
+	// listed, and requests without one, get DefaultEntitlement, or l3 when
+	// that is empty.
+	Entitlements       map[string]Entitlement
+	DefaultEntitlement Entitlement
+	// KeyOwners maps API keys to the order owner they act for, which decides
+	// whose orders the private feed carries
+	KeyOwners map[string]string
+	// DropCopyKeys may follow the drop copy of every owner's orders and fills
+	// and read surveillance alerts, as may keys with the surveillance role;
+	// with neither, both are refused to everyone
+	DropCopyKeys []string
+	// KeyRoles grants each API key the roles that decide which routes it may
+	// call. Keys not listed, and requests without one, get DefaultRoles, or
+	// every role when that is empty.
+	KeyRoles     map[string][]Role
+	DefaultRoles []Role
+	// JWT, when set, also accepts bearer tokens from an identity provider.
+	// A token acts for the account its claims name, with the roles they
+	// grant or, without a roles claim, DefaultRoles.
+	JWT *JWTVerifier
+	// APISecrets holds the secret each API key signs its trading requests
+	// with. A key listed here must sign them, and the signature's timestamp
+	// must be within SignatureWindow of the server's clock.
+	APISecrets      map[string]string
+	SignatureWindow time.Duration
+	// KeyAllowlists binds API keys to the networks they may be used from.
+	// Behind TrustedProxies the client's address is read from
+	// X-Forwarded-For.
+	KeyAllowlists  map[string][]netip.Prefix
+	TrustedProxies []netip.Prefix
+
+	// RequestTimeout bounds how long a request waits on the engine; zero
+	// leaves requests unbounded
+	RequestTimeout time.Duration
+
+	LogRequests bool
+	Compress    bool
+}
+
+// middleware wraps a handler with behaviour shared across endpoints
+type middleware func(http.Handler) http.Handler
+
+// chain wraps h so a request passes through middlewares in the order given
+func chain(h http.Handler, middlewares ...middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// statusRecorder remembers what a handler wrote so the outer middleware can
+// log it, or tell whether it is too late to send an error
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// Hijack lets the depth stream upgrade to a WebSocket through the recorder
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	r.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// recorderFor reuses the recorder an outer middleware installed, if any
+func recorderFor(w http.ResponseWriter) *statusRecorder {
+	if recorder, ok := w.(*statusRecorder); ok {
+		return recorder
+	}
+	return &statusRecorder{ResponseWriter: w}
+}
+
+// logRequests writes one line per request with its status, size and duration
+func logRequests(logger *log.Logger) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			recorder := recorderFor(w)
+			next.ServeHTTP(recorder, r)
+
+			status := recorder.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			logger.Printf("%s %s %d %dB %s", r.Method, r.URL.RequestURI(), status, recorder.bytes, time.Since(start))
+		})
+	}
+}
+
+// recoverPanics turns a panicking handler into a 500 error envelope instead
+// of a dropped connection
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := recorderFor(w)
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+
+			log.Printf("panic serving %s %s: %v\n%s", r.Method, r.URL.Path, err, debug.Stack())
+			// Once the status line is out there is nothing left to report on
+			if recorder.status == 0 {
+				recorder.Header().Del("Content-Encoding")
+				writeError(recorder, http.StatusInternalServerError, ErrCodeInternal,
+					"Internal server error", "the request could not be completed")
+			}
+		}()
+		next.ServeHTTP(recorder, r)
+	})
+}
+
+// cors answers preflight requests and adds the CORS headers for a path that
+// accepts methods
+func cors(origins []string, methods []string) middleware {
+	allowAny := slices.Contains(origins, "*")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			switch {
+			case allowAny:
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			case origin != "" && slices.Contains(origins, origin):
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Add("Vary", "Origin")
+			}
+
+			if r.Method == "OPTIONS" {
+				w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", ")+", OPTIONS")
+				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requireAPIKey rejects requests that carry neither one of keys nor a token
+// verifier accepts, and records who an accepted token speaks for
+func requireAPIKey(keys []string, verifier *JWTVerifier) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := requestAPIKey(r)
+			if validAPIKey(keys, key) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if verifier != nil && looksLikeJWT(key) {
+				identity, err := verifier.Verify(key)
+				if err != nil {
+					w.Header().Set("WWW-Authenticate", `Bearer realm="valhalla", error="invalid_token"`)
+					writeError(w, http.StatusUnauthorized, ErrCodeUnauthorized, "Unauthorized",
+						"the bearer token was refused: "+err.Error())
+					return
+				}
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tokenKey{}, identity)))
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="valhalla"`)
+			writeError(w, http.StatusUnauthorized, ErrCodeUnauthorized, "Unauthorized",
+				"a valid API key is required in the Authorization or X-API-Key header")
+		})
+	}
+}
+
+// requestAPIKey returns the key a request carries, preferring a bearer token
+func requestAPIKey(r *http.Request) string {
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return bearer
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+// validAPIKey compares key against every configured key in constant time
+func validAPIKey(keys []string, key string) bool {
+	valid := 0
+	for _, candidate := range keys {
+		valid |= subtle.ConstantTimeCompare([]byte(candidate), []byte(key))
+	}
+	return key != "" && valid == 1
+}
+
+// gzipWriter compresses the body once the handler starts writing one
+type gzipWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (w *gzipWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if status != http.StatusNoContent && status != http.StatusNotModified {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Del("Content-Length")
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *gzipWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.gz.Write(b)
+}
+
+func (w *gzipWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// compress gzips responses for clients that accept it
+func compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == "HEAD" || !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipWriter{ResponseWriter: w}
+		defer func() {
+			if gw.gz != nil {
+				gw.gz.Close()
+			}
+		}()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether the Accept-Encoding header lists gzip
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(encoding), ";")
+		if strings.TrimSpace(name) == "gzip" && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// serve sends a request through the full middleware stack
+func serve(cfg HTTPConfig, request *http.Request) *httptest.ResponseRecorder {
+	response := httptest.NewRecorder()
+	newServer(cfg).ServeHTTP(response, request)
+	return response
+}
+
+func TestServer_AnswersPreflightCentrally(t *testing.T) {
+	setupTest()
+
+	request := httptest.NewRequest("OPTIONS", "/api/v1/orders", nil)
+	response := serve(HTTPConfig{CORSOrigins: []string{"*"}}, request)
+
+	if response.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", response.Code)
+	}
+	if got := response.Header().Get("Access-Control-Allow-Methods"); got != "POST, GET, OPTIONS" {
+		t.Errorf("Expected every method on the path to be allowed, got %q", got)
+	}
+	if got := response.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Expected any origin to be allowed, got %q", got)
+	}
+
+	// A configured origin list echoes only the origins it names
+	cfg := HTTPConfig{CORSOrigins: []string{"https://app.example"}}
+	request = httptest.NewRequest("GET", "/api/v1/orders", nil)
+	request.Header.Set("Origin", "https://app.example")
+	if got := serve(cfg, request).Header().Get("Access-Control-Allow-Origin"); got != "https://app.example" {
+		t.Errorf("Expected the origin to be echoed, got %q", got)
+	}
+	request = httptest.NewRequest("GET", "/api/v1/orders", nil)
+	request.Header.Set("Origin", "https://evil.example")
+	if got := serve(cfg, request).Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Expected an unlisted origin to get no CORS header, got %q", got)
+	}
+}
+
+func TestServer_RequiresAPIKey(t *testing.T) {
+	setupTest()
+	cfg := HTTPConfig{APIKeys: []string{"secret-1", "secret-2"}}
+
+	response := serve(cfg, httptest.NewRequest("GET", "/api/v1/orders", nil))
+	if response.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected status 401 without a key, got %d", response.Code)
+	}
+	if result := decodeError(t, response); result.Error.Code != ErrCodeUnauthorized {
+		t.Errorf("Expected code %s, got %s", ErrCodeUnauthorized, result.Error.Code)
+	}
+
+	request := httptest.NewRequest("GET", "/api/v1/orders", nil)
+	request.Header.Set("Authorization", "Bearer wrong")
+	if code := serve(cfg, request).Code; code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for a wrong key, got %d", code)
+	}
+
+	request = httptest.NewRequest("GET", "/api/v1/orders", nil)
+	request.Header.Set("Authorization", "Bearer secret-2")
+	if code := serve(cfg, request).Code; code != http.StatusOK {
+		t.Errorf("Expected a bearer key to be accepted, got %d", code)
+	}
+	request = httptest.NewRequest("GET", "/api/v1/orders", nil)
+	request.Header.Set("X-API-Key", "secret-1")
+	if code := serve(cfg, request).Code; code != http.StatusOK {
+		t.Errorf("Expected an X-API-Key header to be accepted, got %d", code)
+	}
+
+	// The docs stay public, and preflights never carry credentials
+	if code := serve(cfg, httptest.NewRequest("GET", "/api/v1/openapi.json", nil)).Code; code != http.StatusOK {
+		t.Errorf("Expected the OpenAPI document to be public, got %d", code)
+	}
+	if code := serve(cfg, httptest.NewRequest("OPTIONS", "/api/v1/orders", nil)).Code; code != http.StatusOK {
+		t.Errorf("Expected preflight to skip auth, got %d", code)
+	}
+}
+
+func TestRecoverPanics(t *testing.T) {
+	var logs bytes.Buffer
+	previous := log.Writer()
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(previous) })
+
+	handler := recoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest("GET", "/api/v1/orders", nil))
+
+	if response.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected status 500, got %d", response.Code)
+	}
+	if result := decodeError(t, response); result.Error.Code != ErrCodeInternal {
+		t.Errorf("Expected code %s, got %s", ErrCodeInternal, result.Error.Code)
+	}
+	if !strings.Contains(logs.String(), "panic serving GET /api/v1/orders: boom") {
+		t.Errorf("Expected the panic to be logged, got %q", logs.String())
+	}
+}
+
+func TestServer_CompressesForGzipClients(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "buy-1", Side: SideBuy, Price: 99.0, Quantity: 5})
+
+	request := httptest.NewRequest("GET", "/api/v1/orders", nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+	response := serve(HTTPConfig{Compress: true}, request)
+
+	if response.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Expected a gzipped response, got headers %v", response.Header())
+	}
+	reader, err := gzip.NewReader(response.Body)
+	if err != nil {
+		t.Fatalf("Expected a gzip body, got %v", err)
+	}
+	var orders OrdersResponse
+	if err := json.NewDecoder(reader).Decode(&orders); err != nil || orders.Count != 1 {
+		t.Errorf("Expected 1 order after decompressing, got %+v (%v)", orders, err)
+	}
+
+	plain := serve(HTTPConfig{Compress: true}, httptest.NewRequest("GET", "/api/v1/orders", nil))
+	if plain.Header().Get("Content-Encoding") != "" {
+		t.Errorf("Expected no compression without Accept-Encoding")
+	}
+}
+
+func TestServer_LogsRequests(t *testing.T) {
+	setupTest()
+	var logs bytes.Buffer
+
+	handler := chain(http.HandlerFunc(getOrderHandler), logRequests(log.New(&logs, "", 0)), recoverPanics)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/orders/missing?symbol=DEFAULT", nil))
+
+	if !strings.HasPrefix(logs.String(), "GET /api/v1/orders/missing?symbol=DEFAULT 404 ") {
+		t.Errorf("Expected the method, URI and status to be logged, got %q", logs.String())
+	}
+}
+
+func TestServer_UpgradesThroughMiddleware(t *testing.T) {
+	setupTest()
+	server := httptest.NewServer(newServer(HTTPConfig{CORSOrigins: []string{"*"}, Compress: true, APIKeys: []string{"secret"}}))
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/v1/depth/stream"
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": {"Bearer secret"}})
+	if err != nil {
+		t.Fatalf("Expected to connect, got %v", err)
+	}
+
+	m, _ := matcherFor("")
+	defer closeStream(t, m, conn)
+	waitForSubscribers(t, m, 1)
+}
+
+func TestLoadConfig_HTTPFlags(t *testing.T) {
+	cfg, err := loadConfig([]string{"-cors-origins", "https://a.example, https://b.example", "-api-keys", "k1,,k2", "-gzip=false"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(cfg.HTTP.CORSOrigins) != 2 || cfg.HTTP.CORSOrigins[1] != "https://b.example" {
+		t.Errorf("Unexpected CORS origins %v", cfg.HTTP.CORSOrigins)
+	}
+	if len(cfg.HTTP.APIKeys) != 2 || cfg.HTTP.Compress || !cfg.HTTP.LogRequests {
+		t.Errorf("Unexpected HTTP config %+v", cfg.HTTP)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+// validateNotional checks the fields of a market order request, which buys
+// an amount of the quote asset rather than a quantity
+func validateNotional(req PlaceOrderRequest) []FieldError {
+	var errs []FieldError
+
+	if req.Side != SideBuy {
+		errs = append(errs, fieldError("side", "oneof", "market orders must be buys (received: '%s')", req.Side))
+	}
+	if req.QuoteQuantity == 0 {
+		errs = append(errs, fieldError("quote_quantity", "required", "market orders need the quote_quantity to spend"))
+	}
+	if req.Quantity != 0 {
+		errs = append(errs, fieldError("quantity", "excluded", "quantity is not used by market orders; it is what quote_quantity buys"))
+	}
+	if req.Price != 0 {
+		errs = append(errs, fieldError("price", "excluded", "price is not used by market orders; they pay the asks' prices"))
+	}
+
+	return errs
+}
+
+// notionalLots walks the asks a notional buy would take, best first, and
+// returns how many lots its quote quantity pays for. It stops at the first
+// ask it cannot buy all of, since the order could not reach the asks behind.
+// It must run on the book's matcher.
+func notionalLots(book *OrderBook, order Order) int {
+	lot := pairFor(order.Symbol).LotSize
+	budget := order.QuoteQuantity
+
+	lots := 0
+	for _, ask := range book.asks.orders() {
+		cost := ask.Price * lot
+		affordable := min(ask.Quantity, int((budget+ledgerTolerance)/cost))
+		lots += affordable
+		budget -= float64(affordable) * cost
+		if affordable < ask.Quantity {
+			break
+		}
+	}
+	return lots
+}
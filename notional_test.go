@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// spend places a notional market buy through the handler
+func spend(req PlaceOrderRequest) *httptest.ResponseRecorder {
+	req.Side, req.Type = SideBuy, OrderTypeMarket
+	jsonData, _ := json.Marshal(req)
+	response := httptest.NewRecorder()
+	placeOrderHandler(response, httptest.NewRequest("POST", "/api/v1/orders", bytes.NewBuffer(jsonData)))
+	return response
+}
+
+func TestNotionalOrders_BuyWhatTheQuotePaysFor(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "ask-1", Side: SideSell, Price: 100, Quantity: 2})
+	placeOn(m, Order{ID: "ask-2", Side: SideSell, Price: 101, Quantity: 3})
+
+	// 200 buys the first level, and the 205 left buys 2 of the next
+	response := spend(PlaceOrderRequest{QuoteQuantity: 405})
+	var placed PlaceOrderResponse
+	json.NewDecoder(response.Body).Decode(&placed)
+	if response.Code != http.StatusOK || placed.Status != OrderStatusFilled || len(placed.Trades) != 2 {
+		t.Fatalf("Expected a filled order with 2 trades, got %d %+v", response.Code, placed)
+	}
+	if spent := placed.Trades[0].QuoteNotional + placed.Trades[1].QuoteNotional; !approxEqual(spent, 402) {
+		t.Errorf("Expected 402 spent, got %g", spent)
+	}
+	if bought := placed.Trades[0].Quantity + placed.Trades[1].Quantity; bought != 4 {
+		t.Errorf("Expected 4 bought, got %d", bought)
+	}
+	if book := bookFor(defaultSymbol); len(book.asks.orders()) != 1 || book.asks.orders()[0].Quantity != 1 {
+		t.Errorf("Expected 1 left at 101, got %+v", book.asks.orders())
+	}
+}
+
+func TestNotionalOrders_CountLotsOfBase(t *testing.T) {
+	setupTest()
+	pairs = map[string]Pair{defaultSymbol: {Symbol: defaultSymbol, Base: "BTC", Quote: "USD", LotSize: 0.01}}
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "ask", Side: SideSell, Price: 50000, Quantity: 10})
+
+	// A lot of 0.01 BTC costs 500
+	var placed PlaceOrderResponse
+	json.NewDecoder(spend(PlaceOrderRequest{QuoteQuantity: 1200}).Body).Decode(&placed)
+	if len(placed.Trades) != 1 || placed.Trades[0].Quantity != 2 || !approxEqual(placed.Trades[0].QuoteNotional, 1000) {
+		t.Errorf("Expected 2 lots for 1000, got %+v", placed.Trades)
+	}
+
+	// Less than a lot's price buys nothing
+	response := spend(PlaceOrderRequest{QuoteQuantity: 499})
+	if result := decodeError(t, response); response.Code != http.StatusUnprocessableEntity || result.Error.Code != ErrCodeNoLiquidity {
+		t.Errorf("Expected 422 NO_LIQUIDITY, got %d %s", response.Code, result.Error.Code)
+	}
+}
+
+func TestNotionalOrders_Validation(t *testing.T) {
+	setupTest()
+	tests := []struct {
+		name string
+		req  PlaceOrderRequest
+		want []string
+	}{
+		{"sell", PlaceOrderRequest{Side: SideSell, Type: OrderTypeMarket, QuoteQuantity: 100}, []string{"side:oneof"}},
+		{"no quote quantity", PlaceOrderRequest{Side: SideBuy, Type: OrderTypeMarket}, []string{"quantity:gt", "quote_quantity:required"}},
+		{"both quantities", PlaceOrderRequest{Side: SideBuy, Type: OrderTypeMarket, Quantity: 1, QuoteQuantity: 100}, []string{"quantity:excluded"}},
+		{"priced", PlaceOrderRequest{Side: SideBuy, Type: OrderTypeMarket, Price: 100, QuoteQuantity: 100}, []string{"price:excluded"}},
+		{"limit", PlaceOrderRequest{Side: SideBuy, Price: 100, QuoteQuantity: 100}, []string{"quote_quantity:excluded"}},
+	}
+	for _, tt := range tests {
+		if got := fieldRules(fieldErrors(t, placeOrderHandler, tt.req)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
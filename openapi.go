@@ -0,0 +1,736 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiRoute describes one endpoint. The same table registers the handlers,
+// prints the endpoint list and generates the OpenAPI document, so the
+// document cannot drift from what the server serves.
+type apiRoute struct {
+	method  string
+	path    string
+	id      string
+	summary string
+	handler http.HandlerFunc
+	params  []apiParam
+	// request and response are zero values of the JSON bodies; nil means none
+	request  interface{}
+	response interface{}
+	// status is the success status, 200 when zero
+	status int
+	// produces lists the content types of an endpoint that does not return JSON
+	produces []string
+	// websocket endpoints are documented as the GET that upgrades
+	websocket bool
+	// hidden routes are served but left out of the document
+	hidden bool
+	// public routes are served without an API key
+	public bool
+	// data is the entitlement needed for the market data the route serves;
+	// empty routes need none
+	data Entitlement
+	// standby routes keep working on a hot standby, which refuses every
+	// other route that is not a GET
+	standby bool
+	// compliance routes, such as the drop copy, are only served to the keys
+	// in -drop-copy-keys
+	compliance bool
+	// unversioned routes speak a protocol with its own envelope, GraphQL or
+	// JSON-RPC, so their bodies are not stamped with a schema version
+	unversioned bool
+	// role is the role an API key needs for the route, when it is not the
+	// one requiredRole gives by default
+	role Role
+}
+
+// apiParam is a query or path parameter
+type apiParam struct {
+	name        string
+	description string
+	// in is where the parameter goes, "query" when empty
+	in string
+	// kind is the OpenAPI type of the value, "string" when empty
+	kind     string
+	format   string
+	enum     []string
+	required bool
+}
+
+// oneOf documents a response whose shape depends on the query
+type oneOf []interface{}
+
+var (
+	symbolParam  = apiParam{name: "symbol", description: "Symbol to query; the default symbol when omitted"}
+	exportParams = []apiParam{
+		symbolParam,
+		{name: "format", description: "Download format", enum: []string{"csv", "parquet"}},
+		{name: "from", description: "Earliest time to include (RFC 3339)", format: "date-time"},
+		{name: "to", description: "Time to include up to, exclusive (RFC 3339)", format: "date-time"},
+	}
+	exportTypes  = []string{"text/csv", "application/vnd.apache.parquet"}
+	orderIDParam = apiParam{name: "id", in: "path", description: "Order ID", required: true}
+	algoIDParam  = apiParam{name: "id", in: "path", description: "Parent order ID", required: true}
+	basketParam  = apiParam{name: "id", in: "path", description: "Basket ID", required: true}
+	accountParam = apiParam{name: "id", in: "path", description: "Account ID", required: true}
+	sessionParam = apiParam{name: "id", in: "path", description: "Session ID", required: true}
+	ownerParam   = apiParam{name: "owner", in: "path", description: "Order owner", required: true}
+	groupParam   = apiParam{name: "group", in: "path", description: "Consumer group name", required: true}
+	letterParam  = apiParam{name: "id", in: "path", description: "Dead letter ID: the fill's trade ID and liquidity, such as trade-1-maker", required: true}
+)
+
+// apiPrefix is the versioned root every endpoint lives under
+const apiPrefix = "/api/v1"
+
+// apiRoutes lists every endpoint the server exposes. Paths use net/http
+// patterns, so {id} segments are read with r.PathValue.
+func apiRoutes() []apiRoute {
+	return []apiRoute{
+		{method: "POST", path: apiPrefix + "/orders", id: "placeOrder", summary: "Place buy/sell order",
+			handler: placeOrderHandler, request: PlaceOrderRequest{}, response: PlaceOrderResponse{}},
+		{method: "GET", path: apiPrefix + "/orders", id: "listOrders", summary: "View all orders",
+			handler: getOrdersHandler, params: []apiParam{symbolParam}, response: OrdersResponse{}, data: EntitlementL3},
+		{method: "GET", path: apiPrefix + "/orders/{id}", id: "getOrder", summary: "View one resting order",
+			handler: getOrderHandler, params: []apiParam{orderIDParam}, response: Order{}},
+		{method: "DELETE", path: apiPrefix + "/orders/{id}", id: "cancelOrder", summary: "Cancel a resting order",
+			handler: cancelOrderHandler, params: []apiParam{orderIDParam,
+				{name: "symbol", description: "Symbol the order rests on; every symbol is searched when omitted"}},
+			response: CancelOrderResponse{}},
+		{method: "POST", path: apiPrefix + "/orders/cancel-batch", id: "cancelOrders", summary: "Cancel a list of orders or every order matching a filter",
+			handler: cancelBatchHandler, request: CancelBatchRequest{}, response: CancelBatchResponse{}},
+		{method: "POST", path: apiPrefix + "/orders/mass-quote", id: "massQuote", summary: "Replace an owner's quotes on a symbol with a new set",
+			handler: massQuoteHandler, request: MassQuoteRequest{}, response: MassQuoteResponse{}},
+		{method: "POST", path: apiPrefix + "/baskets", id: "placeBasket", summary: "Place orders on several symbols that pass or fail their risk checks together",
+			handler: placeBasketHandler, request: BasketRequest{}, response: BasketResponse{}},
+		{method: "GET", path: apiPrefix + "/baskets/{id}", id: "getBasket", summary: "View a basket's orders and trades",
+			handler: getBasketHandler, params: []apiParam{basketParam}, response: BasketOrdersResponse{}},
+		{method: "GET", path: apiPrefix + "/orders/{id}/queue-position", id: "getQueuePosition", summary: "Where a resting order waits in its price level",
+			handler: getQueuePositionHandler, params: []apiParam{orderIDParam,
+				{name: "symbol", description: "Symbol the order rests on; every symbol is searched when omitted"}},
+			response: QueuePosition{}, data: EntitlementL3},
+		{method: "GET", path: apiPrefix + "/orders/{id}/events", id: "getOrderEvents", summary: "View one order's status changes",
+			handler: getOrderEventsHandler, params: []apiParam{orderIDParam}, response: OrderEventsResponse{}, data: EntitlementL3},
+		{method: "GET", path: apiPrefix + "/orders/history", id: "listOrderHistory", summary: "View filled, cancelled, rejected and expired orders",
+			handler: getOrderHistoryHandler, params: []apiParam{
+				{name: "symbol", description: "Only return this symbol's orders"},
+				{name: "owner", description: "Only return this owner's orders"},
+				{name: "status", description: "Only return orders that closed with this status", enum: terminalStatuses},
+				{name: "from", description: "Earliest close time to include (RFC 3339)", format: "date-time"},
+				{name: "to", description: "Close time to include up to, exclusive (RFC 3339)", format: "date-time"}},
+			response: OrderHistoryResponse{}, data: EntitlementL3},
+		{method: "GET", path: apiPrefix + "/orders/export", id: "exportOrders", summary: "Download orders as CSV or Parquet",
+			handler: exportOrdersHandler, params: exportParams, produces: exportTypes, data: EntitlementL3},
+		{method: "GET", path: apiPrefix + "/order-events", id: "listOrderEvents", summary: "View order status changes",
+			handler: getOrderEventsHandler, params: []apiParam{{name: "order_id", description: "Only return this order's events"}},
+			response: OrderEventsResponse{}, data: EntitlementL3},
+		{method: "GET", path: apiPrefix + "/consumer-groups", id: "listConsumerGroups", summary: "Every consumer group's offset and lag",
+			handler: getConsumerGroupsHandler, response: ConsumerGroupsResponse{}, data: EntitlementL3},
+		{method: "GET", path: apiPrefix + "/consumer-groups/{group}", id: "getConsumerGroup", summary: "A consumer group's offset and lag",
+			handler: getConsumerGroupHandler, params: []apiParam{groupParam}, response: ConsumerGroup{}, data: EntitlementL3},
+		{method: "GET", path: apiPrefix + "/consumer-groups/{group}/events", id: "pollConsumerGroup", summary: "The order events a consumer group has not acknowledged",
+			handler: pollConsumerGroupHandler, params: []apiParam{groupParam,
+				{name: "limit", description: "Events to return, from 1 to 1000; 100 when omitted", kind: "integer"}},
+			response: ConsumerEventsResponse{}, data: EntitlementL3},
+		{method: "POST", path: apiPrefix + "/consumer-groups/{group}/offset", id: "commitConsumerOffset", summary: "Acknowledge a consumer group's events up to a sequence",
+			handler: commitConsumerOffsetHandler, params: []apiParam{groupParam}, request: CommitOffsetRequest{}, response: ConsumerGroup{}, data: EntitlementL3, role: RoleRead},
+		{method: "DELETE", path: apiPrefix + "/consumer-groups/{group}", id: "deleteConsumerGroup", summary: "Forget a consumer group",
+			handler: deleteConsumerGroupHandler, params: []apiParam{groupParam}, response: ConsumerGroup{}, data: EntitlementL3, role: RoleRead},
+		{method: "GET", path: apiPrefix + "/trades", id: "listTrades", summary: "View all trades",
+			handler: getTradesHandler, params: []apiParam{symbolParam,
+				{name: "aggressor_side", description: "Only return trades whose taker was on this side", enum: []string{"buy", "sell"}},
+				exportParams[2], exportParams[3]},
+			response: TradesResponse{}},
+		{method: "GET", path: apiPrefix + "/trades/{id}", id: "getTrade", summary: "View one trade",
+			handler: getTradeHandler, params: []apiParam{{name: "id", in: "path", description: "Trade ID", required: true}},
+			response: Trade{}},
+		{method: "GET", path: apiPrefix + "/trades/export", id: "exportTrades", summary: "Download trades as CSV or Parquet",
+			handler: exportTradesHandler, params: exportParams, produces: exportTypes},
+		{method: "GET", path: apiPrefix + "/orderbook", id: "getOrderBook", summary: "View order book",
+			handler: getOrderBookHandler, params: []apiParam{symbolParam}, response: OrderBookResponse{}, data: EntitlementL3},
+		{method: "GET", path: apiPrefix + "/orderbook/history", id: "getBookHistory", summary: "Replay a symbol's depth as it stood at a past time or sequence number",
+			handler: getBookHistoryHandler, params: []apiParam{symbolParam,
+				{name: "at", description: "The moment to rebuild the book at", format: "date-time"},
+				{name: "sequence", description: "The depth sequence number to rebuild the book at, instead of at", kind: "integer"},
+				{name: "levels", description: "Price levels per side; every level when omitted, and only the best with the top entitlement", kind: "integer"}},
+			response: BookHistoryResponse{}},
+		{method: "GET", path: apiPrefix + "/symbols", id: "listSymbols", summary: "List tradable symbols",
+			handler: getSymbolsHandler, response: SymbolsResponse{}},
+		{method: "GET", path: apiPrefix + "/depth/snapshot", id: "getDepthSnapshot", summary: "Aggregated depth with a sequence number",
+			handler: getDepthSnapshotHandler, params: []apiParam{symbolParam,
+				{name: "levels", description: "Price levels per side; every level when omitted, and only the best with the top entitlement", kind: "integer"}},
+			response: DepthSnapshot{}},
+		{method: "GET", path: apiPrefix + "/depth/stream", id: "streamDepth", summary: "Incremental depth updates",
+			handler: depthStreamHandler, params: []apiParam{symbolParam,
+				{name: "interval", description: "Send the changes at most once per interval, such as 100ms, instead of every update"}},
+			response: DepthUpdate{},
+			status:   http.StatusSwitchingProtocols, websocket: true, data: EntitlementL2},
+		{method: "GET", path: apiPrefix + "/l3/snapshot", id: "getL3Snapshot", summary: "Every resting order with its queue position and a sequence number",
+			handler: getL3SnapshotHandler, params: []apiParam{symbolParam}, response: L3Snapshot{}, data: EntitlementL3},
+		{method: "GET", path: apiPrefix + "/l3/stream", id: "streamL3", summary: "Order-by-order add, reduce, delete and execute events",
+			handler: l3StreamHandler, params: []apiParam{symbolParam}, response: L3Event{},
+			status: http.StatusSwitchingProtocols, websocket: true, data: EntitlementL3},
+		{method: "GET", path: apiPrefix + "/feed/public", id: "streamPublicFeed", summary: "Anonymous trades and depth changes",
+			handler: publicFeedHandler, params: []apiParam{symbolParam}, response: FeedMessage{},
+			status: http.StatusSwitchingProtocols, websocket: true, data: EntitlementL2},
+		{method: "GET", path: apiPrefix + "/feed/private", id: "streamPrivateFeed", summary: "The caller's own order updates and fills",
+			handler: privateFeedHandler, params: []apiParam{{name: "owner", description: "Owner to follow; ignored when API keys map to owners"}},
+			response: FeedMessage{}, status: http.StatusSwitchingProtocols, websocket: true},
+		{method: "GET", path: apiPrefix + "/feed/drop-copy", id: "streamDropCopy", summary: "Every owner's order updates and fills, for compliance",
+			handler: dropCopyFeedHandler, response: FeedMessage{}, status: http.StatusSwitchingProtocols, websocket: true, compliance: true},
+		{method: "GET", path: apiPrefix + "/surveillance/alerts", id: "listSurveillanceAlerts", summary: "Wash trading, cancel ratio and layering alerts, newest first",
+			handler: getSurveillanceAlertsHandler, params: []apiParam{
+				{name: "symbol", description: "Only alerts on this symbol"},
+				{name: "owner", description: "Only alerts naming this owner"},
+				{name: "kind", description: "Only alerts of this kind", enum: []string{string(AlertWashTrade), string(AlertCancelRatio), string(AlertLayering)}},
+				{name: "severity", description: "Only alerts of this severity", enum: []string{string(SeverityMedium), string(SeverityHigh)}}},
+			response: SurveillanceAlertsResponse{}, compliance: true},
+		{method: "GET", path: apiPrefix + "/rpc", id: "rpc", summary: "Place, cancel and amend orders with JSON-RPC 2.0",
+			handler: rpcHandler, response: RPCResponse{}, status: http.StatusSwitchingProtocols, websocket: true, unversioned: true, role: RoleTrade},
+		{method: "GET", path: apiPrefix + "/auctions", id: "getAuctionStatus", summary: "Whether a symbol matches continuously or in batch auctions",
+			handler: getAuctionHandler, params: []apiParam{symbolParam}, response: AuctionStatus{}},
+		{method: "GET", path: apiPrefix + "/market-status", id: "getMarketStatus", summary: "A symbol's trading phase and its calendar's next change",
+			handler: getMarketStatusHandler, params: []apiParam{symbolParam}, response: MarketStatus{}},
+		{method: "GET", path: apiPrefix + "/calendar", id: "getTradingCalendar", summary: "The coming days of a symbol's trading calendar, with holidays and early closes",
+			handler: getCalendarHandler, params: []apiParam{symbolParam,
+				{name: "days", description: "Days to list from today, at most 366", kind: "integer"}},
+			response: CalendarResponse{}},
+		{method: "GET", path: apiPrefix + "/shadow", id: "listShadowFeeds", summary: "The symbols mirrored read-only from outside exchanges, and how their feeds are going",
+			handler: getShadowHandler, response: ShadowResponse{}},
+		{method: "GET", path: apiPrefix + "/replay", id: "getReplayStatus", summary: "How far the historical market data replay has got",
+			handler: getReplayHandler, response: ReplayStatus{}},
+		{method: "GET", path: apiPrefix + "/strategies", id: "listStrategies", summary: "The strategies registered in-process, and what the running ones have seen and done",
+			handler: getStrategiesHandler, response: StrategiesResponse{}},
+		{method: "GET", path: apiPrefix + "/funding", id: "getFunding", summary: "A perpetual symbol's funding schedule, predicted rate and history",
+			handler: getFundingHandler, params: []apiParam{symbolParam}, response: FundingResponse{}},
+		{method: "GET", path: apiPrefix + "/adl", id: "getADLQueue", summary: "A perpetual's positions ranked for auto-deleveraging, with past deleveraging",
+			handler: getADLQueueHandler, params: []apiParam{symbolParam}, response: ADLQueueResponse{}},
+		{method: "GET", path: apiPrefix + "/insurance-fund", id: "getInsuranceFund", summary: "The insurance fund's balances and history",
+			handler: getInsuranceFundHandler, response: InsuranceFundResponse{}},
+		{method: "GET", path: apiPrefix + "/options", id: "listOptions", summary: "Option symbols with their terms and settlement",
+			handler: getOptionsHandler, params: []apiParam{{name: "symbol", description: "Only return this option"}}, response: OptionsResponse{}},
+		{method: "GET", path: apiPrefix + "/spreads", id: "listSpreads", summary: "Spread symbols with their legs and the prices the legs imply",
+			handler: getSpreadsHandler, response: SpreadsResponse{}},
+		{method: "GET", path: apiPrefix + "/mark-price", id: "getMarkPrice", summary: "A symbol's mark price and the prices it is taken from",
+			handler: getMarkPriceHandler, params: []apiParam{symbolParam}, response: MarkPriceResponse{}},
+		{method: "GET", path: apiPrefix + "/candles", id: "getCandles", summary: "Open, high, low and close candles of a symbol's trades",
+			handler: getCandlesHandler, params: []apiParam{symbolParam,
+				{name: "interval", description: "Candle width as a Go duration of at least 1s; 1m when omitted"},
+				{name: "limit", description: "Keep only the latest candles; every candle when omitted", kind: "integer"}},
+			response: CandlesResponse{}},
+		{method: "POST", path: apiPrefix + "/graphql", id: "graphql", summary: "Query orders, trades, depth, candles and accounts with GraphQL",
+			handler: graphQLHandler, request: GraphQLRequest{}, response: GraphQLResponse{}, standby: true, unversioned: true, role: RoleRead},
+		{method: "GET", path: apiPrefix + "/graphql", id: "graphqlSubscriptions", summary: "GraphQL subscriptions to trades and book updates",
+			handler: graphQLSubscriptionHandler, response: GraphQLWSMessage{}, status: http.StatusSwitchingProtocols, websocket: true, unversioned: true},
+		{method: "GET", path: apiPrefix + "/graphql/schema", id: "getGraphQLSchema", summary: "The GraphQL schema",
+			handler: graphQLSchemaHandler, produces: []string{"text/plain"}},
+		{method: "GET", path: apiPrefix + "/analytics/price", id: "getPriceAnalytics", summary: "Mid price, microprice and fair value",
+			handler: getPriceAnalyticsHandler, params: []apiParam{symbolParam}, response: PriceAnalytics{}},
+		{method: "GET", path: apiPrefix + "/analytics/liquidity", id: "getLiquidityAnalytics", summary: "Volume imbalance, depth near mid and queue sizes",
+			handler: getLiquidityAnalyticsHandler, params: []apiParam{symbolParam,
+				{name: "ticks", description: "How many ticks from the mid count as near it", kind: "integer"},
+				{name: "tick_size", description: "Size of one tick", kind: "number", format: "double"}},
+			response: LiquidityAnalytics{}, data: EntitlementL2},
+		{method: "GET", path: apiPrefix + "/analytics/latency", id: "getLatencyAnalytics", summary: "Engine latency percentiles and per-order timings",
+			handler: getLatencyAnalyticsHandler, params: []apiParam{symbolParam,
+				{name: "order_id", description: "Return this recent order's latency instead of the percentiles"}},
+			response: oneOf{LatencyAnalytics{}, OrderLatency{}}},
+		{method: "POST", path: apiPrefix + "/deadmans-switch", id: "armDeadMansSwitch", summary: "Arm or re-arm an owner's dead man's switch",
+			handler: armSwitchHandler, request: DeadMansSwitchRequest{}, response: DeadMansSwitch{}},
+		{method: "GET", path: apiPrefix + "/deadmans-switch", id: "listDeadMansSwitches", summary: "Every owner's dead man's switch",
+			handler: getSwitchesHandler, response: DeadMansSwitchesResponse{}},
+		{method: "GET", path: apiPrefix + "/deadmans-switch/{owner}", id: "getDeadMansSwitch", summary: "An owner's dead man's switch",
+			handler: getSwitchHandler, params: []apiParam{ownerParam}, response: DeadMansSwitch{}},
+		{method: "DELETE", path: apiPrefix + "/deadmans-switch/{owner}", id: "disarmDeadMansSwitch", summary: "Disarm an owner's dead man's switch",
+			handler: disarmSwitchHandler, params: []apiParam{ownerParam}, response: DeadMansSwitch{}},
+		{method: "GET", path: apiPrefix + "/throttle/{owner}", id: "getThrottle", summary: "An owner's order message throttle",
+			handler: getThrottleHandler, params: []apiParam{ownerParam}, response: ThrottleState{}},
+		{method: "POST", path: apiPrefix + "/algos", id: "startAlgo", summary: "Start a VWAP or TWAP parent order",
+			handler: startAlgoHandler, request: AlgoRequest{}, response: AlgoOrder{}, status: http.StatusAccepted},
+		{method: "GET", path: apiPrefix + "/algos", id: "listAlgos", summary: "Fill progress of every parent order",
+			handler: getAlgosHandler, response: AlgosResponse{}},
+		{method: "GET", path: apiPrefix + "/algos/{id}", id: "getAlgo", summary: "Parent order fill progress",
+			handler: getAlgoHandler, params: []apiParam{algoIDParam}, response: AlgoOrder{}},
+		{method: "DELETE", path: apiPrefix + "/algos/{id}", id: "cancelAlgo", summary: "Stop a parent order",
+			handler: cancelAlgoHandler, params: []apiParam{algoIDParam}, response: AlgoOrder{}},
+		{method: "POST", path: apiPrefix + "/accounts", id: "createAccount", summary: "Open an account",
+			handler: createAccountHandler, request: CreateAccountRequest{}, response: Account{}, status: http.StatusCreated},
+		{method: "GET", path: apiPrefix + "/accounts", id: "listAccounts", summary: "View every account's balances",
+			handler: getAccountsHandler, response: AccountsResponse{}},
+		{method: "GET", path: apiPrefix + "/accounts/{id}", id: "getAccount", summary: "View one account's balances",
+			handler: getAccountHandler, params: []apiParam{accountParam}, response: Account{}},
+		{method: "POST", path: apiPrefix + "/accounts/{id}/deposit", id: "deposit", summary: "Credit an account",
+			handler: depositHandler, params: []apiParam{accountParam}, request: BalanceRequest{}, response: BalanceChange{}},
+		{method: "POST", path: apiPrefix + "/accounts/{id}/withdraw", id: "withdraw", summary: "Debit an account",
+			handler: withdrawHandler, params: []apiParam{accountParam}, request: BalanceRequest{}, response: BalanceChange{}},
+		{method: "GET", path: apiPrefix + "/accounts/{id}/history", id: "getBalanceHistory", summary: "View an account's balance changes",
+			handler: getBalanceHistoryHandler, params: []apiParam{accountParam,
+				{name: "asset", description: "Only return changes to this asset"}},
+			response: BalanceHistoryResponse{}},
+		{method: "GET", path: apiPrefix + "/accounts/{id}/orders", id: "getAccountOrders", summary: "View an account's resting orders",
+			handler: getAccountOrdersHandler, params: []apiParam{accountParam,
+				{name: "symbol", description: "Only return orders on this symbol"}},
+			response: AccountOrdersResponse{}},
+		{method: "DELETE", path: apiPrefix + "/accounts/{id}/orders", id: "cancelAccountOrders", summary: "Cancel every order of an account and of the accounts under it",
+			handler: cancelAccountOrdersHandler, params: []apiParam{accountParam}, response: CancelBatchResponse{}},
+		{method: "POST", path: apiPrefix + "/accounts/{id}/transfer", id: "transfer", summary: "Move a balance between an account and its parent or a sub-account",
+			handler: transferHandler, params: []apiParam{accountParam}, request: TransferRequest{}, response: BalanceChange{}},
+		{method: "GET", path: apiPrefix + "/accounts/{id}/sub-accounts", id: "getSubAccounts", summary: "View the accounts under an account and what they hold together",
+			handler: getSubAccountsHandler, params: []apiParam{accountParam}, response: SubAccountReport{}},
+		{method: "GET", path: apiPrefix + "/accounts/{id}/positions", id: "getPositions", summary: "View an account's perpetual positions and its use of the position limits",
+			handler: getPositionsHandler, params: []apiParam{accountParam}, response: PositionsResponse{}},
+		{method: "GET", path: apiPrefix + "/accounts/{id}/margin", id: "getMargin", summary: "View an account's margin, margin calls and liquidations",
+			handler: getMarginHandler, params: []apiParam{accountParam}, response: MarginResponse{}},
+		{method: "GET", path: apiPrefix + "/accounts/{id}/fee-tier", id: "getFeeTier", summary: "View an account's fee tier and its progress to the next one",
+			handler: getFeeTierHandler, params: []apiParam{accountParam}, response: FeeTierResponse{}},
+		{method: "GET", path: apiPrefix + "/accounts/{id}/referrals", id: "getReferrals", summary: "View the accounts an account referred and the commission they earned it",
+			handler: getReferralsHandler, params: []apiParam{accountParam}, response: ReferralReport{}},
+		{method: "GET", path: apiPrefix + "/accounts/{id}/portfolio", id: "getPortfolio", summary: "Value an account's balances and positions at current prices",
+			handler: getPortfolioHandler, params: []apiParam{accountParam,
+				{name: "asset", description: "Asset to value the portfolio in; the default quote asset when omitted"}},
+			response: PortfolioResponse{}},
+		{method: "POST", path: apiPrefix + "/accounts/{id}/leverage", id: "setLeverage", summary: "Choose the leverage an account's positions are margined at",
+			handler: setLeverageHandler, params: []apiParam{accountParam}, request: LeverageRequest{}, response: MarginResponse{}},
+		{method: "GET", path: apiPrefix + "/ledger", id: "getLedger", summary: "Ledger entries and reconciliation",
+			handler: getLedgerHandler, params: []apiParam{
+				{name: "account", description: "Only return this account's entries"},
+				{name: "asset", description: "Only return entries in this asset"}},
+			response: LedgerResponse{}},
+		{method: "POST", path: apiPrefix + "/admin/snapshot", id: "takeSnapshot", summary: "Download the engine's complete state",
+			handler: snapshotHandler, response: Snapshot{}, standby: true},
+		{method: "POST", path: apiPrefix + "/admin/restore", id: "restoreSnapshot", summary: "Replace the engine's state with a snapshot",
+			handler: restoreHandler, request: Snapshot{}, response: RestoreResult{}},
+		{method: "POST", path: apiPrefix + "/admin/insurance-fund", id: "fundInsurance", summary: "Deposit into the insurance fund",
+			handler: fundInsuranceHandler, request: BalanceRequest{}, response: BalanceChange{}},
+		{method: "POST", path: apiPrefix + "/admin/index-price", id: "pushIndexPrice", summary: "Set a symbol's index price",
+			handler: pushIndexPriceHandler, request: IndexPriceRequest{}, response: MarkPriceResponse{}},
+		{method: "GET", path: apiPrefix + "/admin/replication", id: "getReplication", summary: "Replication role and progress",
+			handler: getReplicationHandler, response: ReplicationStatus{}},
+		{method: "GET", path: apiPrefix + "/admin/replication/stream", id: "streamReplication", summary: "Snapshot and change stream a hot standby follows",
+			handler: replicationStreamHandler, response: ReplicationMessage{}, status: http.StatusSwitchingProtocols, websocket: true},
+		{method: "GET", path: apiPrefix + "/admin/scripts", id: "listOrderScripts", summary: "The Lua scripts orders are checked by, and how they have ruled",
+			handler: getScriptsHandler, response: ScriptsResponse{}},
+		{method: "POST", path: apiPrefix + "/admin/scripts/reload", id: "reloadOrderScripts", summary: "Load the order scripts from their files again",
+			handler: reloadScriptsHandler, response: ScriptsResponse{}},
+		{method: "GET", path: apiPrefix + "/admin/queues", id: "listMatcherQueues", summary: "How full each matcher's command queue is, and what it has refused",
+			handler: getQueuesHandler, response: MatcherQueuesResponse{}},
+		{method: "GET", path: apiPrefix + "/admin/sessions", id: "listSessions", summary: "Connected streaming sessions and their queues",
+			handler: getSessionsHandler, response: SessionsResponse{}},
+		{method: "DELETE", path: apiPrefix + "/admin/sessions/{id}", id: "kickSession", summary: "Disconnect a streaming session",
+			handler: kickSessionHandler, params: []apiParam{sessionParam}, response: StreamSession{}, standby: true},
+		{method: "GET", path: apiPrefix + "/admin/dead-letters", id: "listDeadLetters", summary: "Fills the webhook could not deliver, oldest first",
+			handler: getDeadLettersHandler, response: DeadLettersResponse{}},
+		{method: "GET", path: apiPrefix + "/admin/dead-letters/{id}", id: "getDeadLetter", summary: "An undelivered fill and why its delivery failed",
+			handler: getDeadLetterHandler, params: []apiParam{letterParam}, response: DeadLetter{}},
+		{method: "POST", path: apiPrefix + "/admin/dead-letters/retry", id: "retryDeadLetters", summary: "Deliver every dead letter again, oldest first, stopping at the first failure",
+			handler: retryDeadLettersHandler, response: DeadLetterRetryResponse{}},
+		{method: "POST", path: apiPrefix + "/admin/dead-letters/{id}/retry", id: "retryDeadLetter", summary: "Deliver a dead letter again",
+			handler: retryDeadLetterHandler, params: []apiParam{letterParam}, response: DeadLetter{}},
+		{method: "DELETE", path: apiPrefix + "/admin/dead-letters/{id}", id: "discardDeadLetter", summary: "Discard a dead letter without delivering it",
+			handler: discardDeadLetterHandler, params: []apiParam{letterParam}, response: DeadLetter{}},
+		{method: "GET", path: apiPrefix + "/admin/audit", id: "listAuditEvents", summary: "Security events such as requests from outside an API key's allowlist, and random auction uncrosses, newest first",
+			handler: getAuditEventsHandler, params: []apiParam{
+				{name: "type", description: "Only events of this type", enum: []string{string(AuditIPNotAllowed), string(AuditAuctionUncross)}}},
+			response: AuditEventsResponse{}},
+		{method: "GET", path: apiPrefix + "/admin/clock", id: "getSimulationClock", summary: "Where the simulation's virtual clock stands",
+			handler: getClockHandler, response: SimulationClock{}},
+		{method: "POST", path: apiPrefix + "/admin/clock/pause", id: "pauseSimulationClock", summary: "Stop the simulation's virtual clock",
+			handler: pauseClockHandler, response: SimulationClock{}},
+		{method: "POST", path: apiPrefix + "/admin/clock/resume", id: "resumeSimulationClock", summary: "Run the simulation's virtual clock again",
+			handler: resumeClockHandler, params: []apiParam{
+				{name: "rate", description: "Multiple of the wall clock's rate to run at; the previous rate when omitted", kind: "number", format: "double"}},
+			response: SimulationClock{}},
+		{method: "POST", path: apiPrefix + "/admin/clock/step", id: "stepSimulationClock", summary: "Move the virtual clock forward by a duration, running what falls due on the way",
+			handler: stepClockHandler, request: StepClockRequest{}, response: SimulationClock{}},
+		{method: "POST", path: apiPrefix + "/admin/clock/fast-forward", id: "fastForwardSimulationClock", summary: "Move the virtual clock forward to an instant, running what falls due on the way",
+			handler: fastForwardClockHandler, request: FastForwardRequest{}, response: SimulationClock{}},
+		{method: "POST", path: apiPrefix + "/admin/promote", id: "promote", summary: "Promote a hot standby to primary",
+			handler: promoteHandler, response: ReplicationStatus{}, standby: true},
+		{method: "GET", path: apiPrefix + "/openapi.json", summary: "OpenAPI document", handler: openAPIHandler, hidden: true, public: true},
+		{method: "GET", path: apiPrefix + "/docs", summary: "Interactive API docs", handler: apiDocsHandler, hidden: true, public: true},
+		{method: "GET", path: apiPrefix + "/valhalla.proto", summary: "Protobuf schema", handler: protobufSchemaHandler, hidden: true, public: true},
+	}
+}
+
+// apiEnums lists the values of the string types that are enumerations
+var apiEnums = map[reflect.Type][]string{
+	reflect.TypeOf(Side("")):             {string(SideBuy), string(SideSell)},
+	reflect.TypeOf(OrderType("")):        {string(OrderTypeLimit), string(OrderTypeMarket), string(OrderTypeTrailingStop), string(OrderTypePegged)},
+	reflect.TypeOf(TimeInForce("")):      {string(TimeInForceGTC), string(TimeInForceIOC)},
+	reflect.TypeOf(PegType("")):          {string(PegPrimary), string(PegMidpoint), string(PegMarket)},
+	reflect.TypeOf(DepthMessageType("")): {string(DepthMessageUpdate), string(DepthMessageResync)},
+	reflect.TypeOf(AlgoSchedule("")):     {string(AlgoScheduleVWAP), string(AlgoScheduleTWAP)},
+	reflect.TypeOf(AlgoStatus("")):       {string(AlgoStatusRunning), string(AlgoStatusFilled), string(AlgoStatusExpired), string(AlgoStatusCancelled)},
+	reflect.TypeOf(BalanceChangeType("")): {string(BalanceDeposit), string(BalanceWithdrawal), string(BalanceTrade), string(BalanceFee), string(BalanceRebate), string(BalanceRealizedPnL), string(BalanceFunding),
+		string(BalanceLiquidationFee), string(BalanceInsurance), string(BalanceADL), string(BalanceExercise), string(BalanceAssignment),
+		string(BalanceCommission), string(BalanceTransfer)},
+	reflect.TypeOf(OptionType("")):      {string(OptionCall), string(OptionPut)},
+	reflect.TypeOf(OptionStatus("")):    {string(OptionActive), string(OptionExpired), string(OptionSettled)},
+	reflect.TypeOf(MarginStatus("")):    {string(MarginHealthy), string(MarginCall), string(MarginLiquidating)},
+	reflect.TypeOf(TickDirection("")):   {string(TickUp), string(TickDown), string(TickZero)},
+	reflect.TypeOf(SwitchStatus("")):    {string(SwitchArmed), string(SwitchTriggered), string(SwitchDisarmed)},
+	reflect.TypeOf(SessionKind("")):     {string(SessionDepth), string(SessionReplication), string(SessionPublicFeed), string(SessionPrivateFeed), string(SessionDropCopy), string(SessionL3), string(SessionGraphQL), string(SessionRPC)},
+	reflect.TypeOf(Entitlement("")):     {string(EntitlementTop), string(EntitlementL2), string(EntitlementL3)},
+	reflect.TypeOf(AlertKind("")):       {string(AlertWashTrade), string(AlertCancelRatio), string(AlertLayering)},
+	reflect.TypeOf(AlertSeverity("")):   {string(SeverityMedium), string(SeverityHigh)},
+	reflect.TypeOf(EvidenceType("")):    {string(EvidenceTrade), string(EvidenceOrder)},
+	reflect.TypeOf(FeedChannel("")):     {string(FeedPublic), string(FeedPrivate), string(FeedDropCopy)},
+	reflect.TypeOf(FeedMessageType("")): {string(FeedMessageTrade), string(FeedMessageDepth), string(FeedMessageOrder), string(FeedMessageFill), string(FeedMessageStatus), string(FeedMessageAuction), string(FeedMessageResync)},
+	reflect.TypeOf(Liquidity("")):       {string(LiquidityMaker), string(LiquidityTaker)},
+	reflect.TypeOf(L3EventType("")):     {string(L3Add), string(L3Reduce), string(L3Delete), string(L3Execute), string(L3Resync)},
+	reflect.TypeOf(MatchingMode("")):    {string(MatchingContinuous), string(MatchingBatch)},
+	reflect.TypeOf(TradingPhase("")): {string(PhaseClosed), string(PhasePreOpen), string(PhaseContinuous), string(PhaseAuction),
+		string(PhaseHalted), string(PhasePostClose)},
+	reflect.TypeOf(MarkSource("")):  {string(MarkLastTrade), string(MarkMidEMA), string(MarkIndex)},
+	reflect.TypeOf(StopTrigger("")): {string(StopTriggerTrade), string(StopTriggerMark)},
+	reflect.TypeOf(OrderStatus("")): {
+		string(OrderStatusPending), string(OrderStatusFilled), string(OrderStatusPartiallyFilled),
+		string(OrderStatusCancelled), string(OrderStatusPendingCancel), string(OrderStatusRejected),
+		string(OrderStatusExpired), string(OrderStatusUntriggered),
+	},
+	reflect.TypeOf(ErrorCode("")): {
+		string(ErrCodeMethodNotAllowed), string(ErrCodeInvalidJSON), string(ErrCodeValidationFailed),
+		string(ErrCodeOrderNotFound), string(ErrCodeTradeNotFound), string(ErrCodeUnknownSymbol), string(ErrCodeOrderExpired),
+		string(ErrCodeSelfTrade), string(ErrCodeNoLiquidity), string(ErrCodeMinQuantityNotMet),
+		string(ErrCodeNoReferencePrice), string(ErrCodeAccountNotFound), string(ErrCodeAccountExists),
+		string(ErrCodeInsufficientFunds), string(ErrCodeSwitchNotFound), string(ErrCodeSessionNotFound),
+		string(ErrCodeConsumerNotFound), string(ErrCodeOffsetConflict),
+		string(ErrCodeDeadLetterNotFound), string(ErrCodeDeliveryFailed),
+		string(ErrCodeUnauthorized), string(ErrCodeNotEntitled), string(ErrCodeInternal),
+	},
+}
+
+// OpenAPIDocument is the subset of OpenAPI 3.0 the server describes itself with
+type OpenAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       OpenAPIInfo                             `json:"info"`
+	Paths      map[string]map[string]*OpenAPIOperation `json:"paths"`
+	Components OpenAPIComponents                       `json:"components"`
+}
+
+// OpenAPIInfo names the API and its version
+type OpenAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// OpenAPIComponents holds the schemas operations refer to
+type OpenAPIComponents struct {
+	Schemas map[string]*OpenAPISchema `json:"schemas"`
+}
+
+// OpenAPIOperation is one method on one path
+type OpenAPIOperation struct {
+	OperationID string                      `json:"operationId"`
+	Summary     string                      `json:"summary"`
+	Parameters  []OpenAPIParameter          `json:"parameters,omitempty"`
+	RequestBody *OpenAPIBody                `json:"requestBody,omitempty"`
+	Responses   map[string]*OpenAPIResponse `json:"responses"`
+}
+
+// OpenAPIParameter is a query or path parameter of an operation
+type OpenAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required,omitempty"`
+	Schema      *OpenAPISchema `json:"schema"`
+}
+
+// OpenAPIBody is an operation's request body
+type OpenAPIBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]OpenAPIMediaType `json:"content"`
+}
+
+// OpenAPIResponse is one status an operation can answer with
+type OpenAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]OpenAPIMediaType `json:"content,omitempty"`
+}
+
+// OpenAPIMediaType gives the schema of a body in one content type
+type OpenAPIMediaType struct {
+	Schema *OpenAPISchema `json:"schema"`
+}
+
+// OpenAPISchema describes a JSON value
+type OpenAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Description          string                    `json:"description,omitempty"`
+	Enum                 []string                  `json:"enum,omitempty"`
+	Minimum              *float64                  `json:"minimum,omitempty"`
+	Maximum              *float64                  `json:"maximum,omitempty"`
+	ExclusiveMinimum     bool                      `json:"exclusiveMinimum,omitempty"`
+	Items                *OpenAPISchema            `json:"items,omitempty"`
+	Properties           map[string]*OpenAPISchema `json:"properties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+	AdditionalProperties *OpenAPISchema            `json:"additionalProperties,omitempty"`
+	OneOf                []*OpenAPISchema          `json:"oneOf,omitempty"`
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// schemaBuilder turns Go types into schemas, collecting every named struct it
+// meets as a reusable component
+type schemaBuilder struct {
+	components map[string]*OpenAPISchema
+}
+
+// schemaFor returns the schema of t, or a reference to it for named structs
+func (b *schemaBuilder) schemaFor(t reflect.Type) *OpenAPISchema {
+	switch t {
+	case timeType:
+		return &OpenAPISchema{Type: "string", Format: "date-time"}
+	case durationType:
+		return &OpenAPISchema{Type: "integer", Format: "int64", Description: "nanoseconds"}
+	}
+	if values, ok := apiEnums[t]; ok {
+		return &OpenAPISchema{Type: "string", Enum: values}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return b.schemaFor(t.Elem())
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.objectSchema(t)
+		}
+		if _, ok := b.components[t.Name()]; !ok {
+			// Claim the name first so a type that refers to itself terminates
+			b.components[t.Name()] = &OpenAPISchema{}
+			b.components[t.Name()] = b.objectSchema(t)
+		}
+		return &OpenAPISchema{Ref: "#/components/schemas/" + t.Name()}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &OpenAPISchema{Type: "string", Format: "byte"}
+		}
+		return &OpenAPISchema{Type: "array", Items: b.schemaFor(t.Elem())}
+	case reflect.Map:
+		return &OpenAPISchema{Type: "object", AdditionalProperties: b.schemaFor(t.Elem())}
+	case reflect.String:
+		return &OpenAPISchema{Type: "string"}
+	case reflect.Bool:
+		return &OpenAPISchema{Type: "boolean"}
+	case reflect.Int64:
+		return &OpenAPISchema{Type: "integer", Format: "int64"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &OpenAPISchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &OpenAPISchema{Type: "number", Format: "double"}
+	}
+	// Interfaces may hold anything
+	return &OpenAPISchema{}
+}
+
+// objectSchema describes a struct's exported fields by their json tags. Fields
+// without omitempty are always present, so they are listed as required.
+func (b *schemaBuilder) objectSchema(t reflect.Type) *OpenAPISchema {
+	schema := &OpenAPISchema{Type: "object", Properties: make(map[string]*OpenAPISchema)}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			embedded := b.objectSchema(field.Type)
+			for property, value := range embedded.Properties {
+				schema.Properties[property] = value
+			}
+			schema.Required = append(schema.Required, embedded.Required...)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		property := b.schemaFor(field.Type)
+		schema.Properties[name] = property
+		rules := field.Tag.Get("validate")
+		if optional := strings.Contains(options, "omitempty") || strings.Contains(options, "omitzero"); !optional || strings.Contains(rules, "required") {
+			schema.Required = append(schema.Required, name)
+		}
+		if property.Ref == "" {
+			applyValidateRules(property, rules)
+			if since := fieldSince(field); since > 0 {
+				property.Description = "Since schema version " + strconv.Itoa(since)
+			}
+		}
+	}
+	return schema
+}
+
+// applyValidateRules carries a field's validate tag into its schema, so the
+// document states the same limits the server enforces
+func applyValidateRules(schema *OpenAPISchema, rules string) {
+	for _, rule := range strings.Split(rules, ",") {
+		rule, arg, _ := strings.Cut(rule, "=")
+		limit, err := strconv.ParseFloat(arg, 64)
+		switch {
+		case rule == "gt" && err == nil:
+			schema.Minimum, schema.ExclusiveMinimum = &limit, true
+		case rule == "min" && err == nil:
+			schema.Minimum = &limit
+		case rule == "max" && err == nil:
+			schema.Maximum = &limit
+		case rule == "oneof":
+			schema.Enum = strings.Fields(arg)
+		}
+	}
+}
+
+// bodySchema returns the schema of a request or response body
+func (b *schemaBuilder) bodySchema(body interface{}) *OpenAPISchema {
+	if alternatives, ok := body.(oneOf); ok {
+		schema := &OpenAPISchema{}
+		for _, alternative := range alternatives {
+			schema.OneOf = append(schema.OneOf, b.schemaFor(reflect.TypeOf(alternative)))
+		}
+		return schema
+	}
+	return b.schemaFor(reflect.TypeOf(body))
+}
+
+// buildOpenAPI describes routes as an OpenAPI 3 document
+func buildOpenAPI(routes []apiRoute) OpenAPIDocument {
+	builder := &schemaBuilder{components: make(map[string]*OpenAPISchema)}
+	errorResponse := &OpenAPIResponse{
+		Description: "Error",
+		Content:     map[string]OpenAPIMediaType{"application/json": {Schema: builder.bodySchema(ErrorResponse{})}},
+	}
+
+	doc := OpenAPIDocument{
+		OpenAPI: "3.0.3",
+		Info:    OpenAPIInfo{Title: "Valhalla Order Book API", Version: "1.0"},
+		Paths:   make(map[string]map[string]*OpenAPIOperation),
+	}
+	for _, route := range routes {
+		if route.hidden {
+			continue
+		}
+
+		operation := &OpenAPIOperation{
+			OperationID: route.id,
+			Summary:     route.summary,
+			Responses:   map[string]*OpenAPIResponse{"default": errorResponse},
+		}
+		for _, param := range route.params {
+			schema := &OpenAPISchema{Type: param.kind, Format: param.format, Enum: param.enum}
+			if schema.Type == "" {
+				schema.Type = "string"
+			}
+			in := param.in
+			if in == "" {
+				in = "query"
+			}
+			operation.Parameters = append(operation.Parameters, OpenAPIParameter{
+				Name:        param.name,
+				In:          in,
+				Description: param.description,
+				Required:    param.required,
+				Schema:      schema,
+			})
+		}
+		if !route.unversioned {
+			oldest, newest := float64(minSchemaVersion), float64(currentSchemaVersion)
+			operation.Parameters = append(operation.Parameters, OpenAPIParameter{
+				Name:        schemaVersionHeader,
+				In:          "header",
+				Description: "Schema version to write JSON bodies in; the current one when omitted",
+				Schema:      &OpenAPISchema{Type: "integer", Minimum: &oldest, Maximum: &newest},
+			})
+		}
+		if route.request != nil {
+			operation.RequestBody = &OpenAPIBody{
+				Required: true,
+				Content:  map[string]OpenAPIMediaType{"application/json": {Schema: builder.bodySchema(route.request)}},
+			}
+			if _, ok := reflect.New(reflect.TypeOf(route.request)).Interface().(protoDecoder); ok {
+				operation.RequestBody.Content[protobufContentType] = OpenAPIMediaType{Schema: &OpenAPISchema{Type: "string", Format: "binary"}}
+			}
+		}
+
+		status := route.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := &OpenAPIResponse{Description: http.StatusText(status), Content: make(map[string]OpenAPIMediaType)}
+		if route.websocket {
+			success.Description = "Upgrades to a WebSocket that sends one message per book change"
+		}
+		if route.response != nil {
+			success.Content["application/json"] = OpenAPIMediaType{Schema: builder.bodySchema(route.response)}
+			if _, ok := route.response.(protoMessage); ok {
+				success.Content[protobufContentType] = OpenAPIMediaType{Schema: &OpenAPISchema{Type: "string", Format: "binary"}}
+			}
+		}
+		for _, contentType := range route.produces {
+			success.Content[contentType] = OpenAPIMediaType{Schema: &OpenAPISchema{Type: "string", Format: "binary"}}
+		}
+		operation.Responses[strconv.Itoa(status)] = success
+
+		if doc.Paths[route.path] == nil {
+			doc.Paths[route.path] = make(map[string]*OpenAPIOperation)
+		}
+		doc.Paths[route.path][strings.ToLower(route.method)] = operation
+	}
+
+	doc.Components.Schemas = builder.components
+	return doc
+}
+
+var (
+	openAPIOnce sync.Once
+	openAPIJSON []byte
+)
+
+// openAPIHandler serves the OpenAPI document, generated on first request
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	openAPIOnce.Do(func() {
+		openAPIJSON, _ = json.MarshalIndent(buildOpenAPI(apiRoutes()), "", "  ")
+	})
+	w.Write(openAPIJSON)
+}
+
+// apiDocsPage renders the OpenAPI document with Swagger UI
+const apiDocsPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Valhalla Order Book API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>
+    window.ui = SwaggerUIBundle({url: "/api/v1/openapi.json", dom_id: "#swagger-ui"});
+  </script>
+</body>
+</html>
+`
+
+// apiDocsHandler serves the interactive API docs
+func apiDocsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(apiDocsPage))
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// getOpenAPI fetches the served OpenAPI document
+func getOpenAPI(t *testing.T) OpenAPIDocument {
+	t.Helper()
+
+	request := httptest.NewRequest("GET", "/api/v1/openapi.json", nil)
+	response := httptest.NewRecorder()
+	openAPIHandler(response, request)
+
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", response.Code)
+	}
+	var doc OpenAPIDocument
+	if err := json.NewDecoder(response.Body).Decode(&doc); err != nil {
+		t.Fatalf("Expected a JSON document, got %v", err)
+	}
+	return doc
+}
+
+// collectRefs gathers every component reference under schema
+func collectRefs(schema *OpenAPISchema, refs map[string]bool) {
+	if schema == nil {
+		return
+	}
+	if schema.Ref != "" {
+		refs[strings.TrimPrefix(schema.Ref, "#/components/schemas/")] = true
+	}
+	collectRefs(schema.Items, refs)
+	collectRefs(schema.AdditionalProperties, refs)
+	for _, property := range schema.Properties {
+		collectRefs(property, refs)
+	}
+	for _, alternative := range schema.OneOf {
+		collectRefs(alternative, refs)
+	}
+}
+
+func TestAPIRoutes_AreUniqueAndDescribed(t *testing.T) {
+	patterns := make(map[string]bool)
+	ids := make(map[string]bool)
+	for _, route := range apiRoutes() {
+		pattern := route.method + " " + route.path
+		if patterns[pattern] {
+			t.Errorf("%s is registered twice", pattern)
+		}
+		patterns[pattern] = true
+
+		if route.handler == nil || route.summary == "" {
+			t.Errorf("%s needs a handler and a summary", route.path)
+		}
+		if !route.hidden && (route.id == "" || ids[route.id]) {
+			t.Errorf("%s needs a unique operation ID, got %q", route.path, route.id)
+		}
+		ids[route.id] = true
+	}
+}
+
+func TestOpenAPI_DescribesEveryRoute(t *testing.T) {
+	doc := getOpenAPI(t)
+
+	if doc.OpenAPI != "3.0.3" {
+		t.Errorf("Expected OpenAPI 3.0.3, got %s", doc.OpenAPI)
+	}
+	for _, route := range apiRoutes() {
+		operation := doc.Paths[route.path][strings.ToLower(route.method)]
+		if route.hidden {
+			if operation != nil {
+				t.Errorf("Expected %s to be left out", route.path)
+			}
+			continue
+		}
+		if operation == nil {
+			t.Errorf("Expected %s %s in the document", route.method, route.path)
+			continue
+		}
+		if operation.Responses["default"] == nil {
+			t.Errorf("Expected %s to document its error response", route.path)
+		}
+	}
+
+	cancel := doc.Paths["/api/v1/orders/{id}"]["delete"]
+	if cancel == nil || len(cancel.Parameters) == 0 || cancel.Parameters[0].In != "path" || !cancel.Parameters[0].Required {
+		t.Errorf("Expected cancelOrder to take a required path ID, got %+v", cancel)
+	}
+	if doc.Paths["/api/v1/algos"]["post"].Responses["202"] == nil {
+		t.Errorf("Expected the algo endpoint to answer 202")
+	}
+	export := doc.Paths["/api/v1/trades/export"]["get"].Responses["200"]
+	if _, ok := export.Content["application/vnd.apache.parquet"]; !ok {
+		t.Errorf("Expected the export to offer parquet, got %v", export.Content)
+	}
+}
+
+func TestOpenAPI_SchemasFollowStructTags(t *testing.T) {
+	doc := getOpenAPI(t)
+
+	body := doc.Paths["/api/v1/orders"]["post"].RequestBody
+	if body == nil || body.Content["application/json"].Schema.Ref != "#/components/schemas/PlaceOrderRequest" {
+		t.Fatalf("Expected placeOrder to take a PlaceOrderRequest, got %+v", body)
+	}
+
+	request := doc.Components.Schemas["PlaceOrderRequest"]
+	if side := request.Properties["side"]; side == nil || strings.Join(side.Enum, ",") != "buy,sell" {
+		t.Errorf("Expected side to enumerate buy and sell, got %+v", side)
+	}
+	if expires := request.Properties["expires_at"]; expires == nil || expires.Format != "date-time" {
+		t.Errorf("Expected expires_at to be a date-time, got %+v", expires)
+	}
+	if quantity := request.Properties["quantity"]; quantity == nil || quantity.Minimum == nil || *quantity.Minimum != 0 || !quantity.ExclusiveMinimum || quantity.Maximum == nil {
+		t.Errorf("Expected quantity bounds from its validate tag, got %+v", quantity)
+	}
+	if orderType := request.Properties["type"]; orderType == nil || strings.Join(orderType.Enum, ",") != "limit,market,trailing_stop,pegged" {
+		t.Errorf("Expected type to enumerate its oneof values, got %+v", orderType)
+	}
+	required := strings.Join(request.Required, ",")
+	if !strings.Contains(required, "side") || strings.Contains(required, "owner") {
+		t.Errorf("Expected side required and owner optional, got %s", required)
+	}
+
+	order := doc.Components.Schemas["Order"]
+	if _, ok := order.Properties["anchor"]; ok {
+		t.Errorf("Expected unexported fields to be left out")
+	}
+	if latency := doc.Components.Schemas["OrderLatency"].Properties["total_ns"]; latency == nil || latency.Type != "integer" {
+		t.Errorf("Expected durations as integer nanoseconds, got %+v", latency)
+	}
+
+	// Every reference must resolve to a component
+	refs := make(map[string]bool)
+	for _, operations := range doc.Paths {
+		for _, operation := range operations {
+			if operation.RequestBody != nil {
+				for _, media := range operation.RequestBody.Content {
+					collectRefs(media.Schema, refs)
+				}
+			}
+			for _, response := range operation.Responses {
+				for _, media := range response.Content {
+					collectRefs(media.Schema, refs)
+				}
+			}
+		}
+	}
+	for _, schema := range doc.Components.Schemas {
+		collectRefs(schema, refs)
+	}
+	for name := range refs {
+		if doc.Components.Schemas[name] == nil {
+			t.Errorf("Reference to missing schema %s", name)
+		}
+	}
+}
+
+func TestAPIDocsHandler(t *testing.T) {
+	request := httptest.NewRequest("GET", "/api/v1/docs", nil)
+	response := httptest.NewRecorder()
+	apiDocsHandler(response, request)
+
+	if !strings.HasPrefix(response.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("Expected an HTML page, got %s", response.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(response.Body.String(), `url: "/api/v1/openapi.json"`) {
+		t.Errorf("Expected the page to load the served document")
+	}
+}
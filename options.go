@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OptionType is the right an option gives its holder
+type OptionType string
+
+const (
+	// OptionCall pays the underlying's price above the strike
+	OptionCall OptionType = "call"
+	// OptionPut pays the underlying's price below the strike
+	OptionPut OptionType = "put"
+)
+
+// OptionStatus is where an option is in its life
+type OptionStatus string
+
+const (
+	OptionActive OptionStatus = "active"
+	// OptionExpired options are past their expiry and wait for the
+	// underlying's mark price to settle against
+	OptionExpired OptionStatus = "expired"
+	OptionSettled OptionStatus = "settled"
+)
+
+// OptionSpec describes an option symbol as it is configured
+type OptionSpec struct {
+	Underlying string
+	Type       OptionType
+	Strike     float64
+	Expiry     time.Time
+}
+
+// OptionSettlement is how an option was exercised and assigned at expiry
+type OptionSettlement struct {
+	// Price is the underlying's mark price at settlement, and Intrinsic what
+	// each contract paid its holder
+	Price     float64 `json:"price"`
+	Intrinsic float64 `json:"intrinsic"`
+	// Exercised is the number of contracts held long at expiry, each of which
+	// was assigned to a contract written short
+	Exercised int       `json:"exercised"`
+	Paid      float64   `json:"paid"`
+	At        time.Time `json:"at"`
+	// Cancelled lists the resting orders cancelled at expiry
+	Cancelled []string `json:"cancelled,omitempty"`
+}
+
+// OptionContract is an option symbol's terms and its settlement
+type OptionContract struct {
+	Symbol     string       `json:"symbol"`
+	Underlying string       `json:"underlying"`
+	Type       OptionType   `json:"type"`
+	Strike     float64      `json:"strike"`
+	Expiry     time.Time    `json:"expiry"`
+	Status     OptionStatus `json:"status"`
+	// Asset is what premiums and exercise are paid in
+	Asset      string            `json:"asset"`
+	Settlement *OptionSettlement `json:"settlement,omitempty"`
+}
+
+// OptionsResponse lists the option symbols by expiry
+type OptionsResponse struct {
+	Options []OptionContract `json:"options"`
+	Count   int              `json:"count"`
+}
+
+// options is guarded by accountsMu
+var options = make(map[string]*OptionContract)
+
+// listOption registers an option symbol. Its holders and writers pay
+// premiums and exercise in the settlement asset of the underlying.
+func listOption(symbol string, spec OptionSpec) *OptionContract {
+	accountsMu.Lock()
+	defer accountsMu.Unlock()
+	contract := &OptionContract{
+		Symbol:     symbol,
+		Underlying: spec.Underlying,
+		Type:       spec.Type,
+		Strike:     spec.Strike,
+		Expiry:     spec.Expiry,
+		Status:     OptionActive,
+		Asset:      perpetualAsset(spec.Underlying),
+	}
+	options[symbol] = contract
+	return contract
+}
+
+// startOptions lists each configured option and settles it at its expiry
+// until ctx is done. On a virtual clock the simulation settles it instead.
+func startOptions(ctx context.Context, specs map[string]OptionSpec) {
+	for symbol, spec := range specs {
+		m, ok := matcherFor(symbol)
+		underlying, underlyingOK := matcherFor(spec.Underlying)
+		if !ok || !underlyingOK {
+			log.Printf("options: unknown symbol %q or underlying %q, option not listed", symbol, spec.Underlying)
+			continue
+		}
+		listOption(m.symbol, spec)
+		if simulatedClock == nil {
+			go runOptionExpiry(ctx, m, underlying, spec.Expiry)
+		}
+		log.Printf("options: %s %s %s at %g expires %s", m.symbol, spec.Underlying, spec.Type, spec.Strike, spec.Expiry.Format(time.RFC3339))
+	}
+}
+
+// runOptionExpiry waits for the option's expiry, then tries to settle it
+// every second until the underlying has a mark price. A standby takes no
+// part, since it replicates no accounts.
+func runOptionExpiry(ctx context.Context, m, underlying *matcher, expiry time.Time) {
+	timer := time.NewTimer(time.Until(expiry))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return
+	case <-timer.C:
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		if !standby.running.Load() {
+			var mark float64
+			underlying.do(func() { mark, _ = markPrice(underlying.book) })
+			settled := false
+			m.do(func() { _, settled = settleOption(m, mark) })
+			if settled {
+				return
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// intrinsicValue is what one contract pays its holder with the underlying at price
+func intrinsicValue(optionType OptionType, strike, price float64) float64 {
+	if optionType == OptionCall {
+		return math.Max(price-strike, 0)
+	}
+	return math.Max(strike-price, 0)
+}
+
+// settleOption cancels the option's resting orders and settles every open
+// contract at the intrinsic value the underlying's mark gives it. Each
+// holder exercises against the clearing account, and each writer is
+// assigned from it, so the two sides pay each other in full. Without a mark
+// nothing is settled. It must run on the option's matcher.
+func settleOption(m *matcher, mark float64) (OptionSettlement, bool) {
+	accountsMu.Lock()
+	contract, ok := options[m.symbol]
+	if !ok || contract.Status == OptionSettled {
+		accountsMu.Unlock()
+		return OptionSettlement{}, false
+	}
+	contract.Status = OptionExpired
+	accountsMu.Unlock()
+	if mark <= 0 {
+		log.Printf("options: %s waits for a mark on %s to settle", m.symbol, contract.Underlying)
+		return OptionSettlement{}, false
+	}
+
+	var cancelled []string
+	for _, orders := range [][]Order{m.book.bids.orders(), m.book.asks.orders(), m.book.stops} {
+		for _, order := range orders {
+			cancelled = append(cancelled, order.ID)
+		}
+	}
+	for _, id := range cancelled {
+		cancelOrder(m.symbol, id)
+	}
+
+	accountsMu.Lock()
+	defer accountsMu.Unlock()
+
+	settlement := OptionSettlement{
+		Price:     mark,
+		Intrinsic: intrinsicValue(contract.Type, contract.Strike, mark),
+		At:        engineClock.Now(),
+		Cancelled: cancelled,
+	}
+	owners := make([]string, 0)
+	for owner := range accounts {
+		if ledgerBalances[owner][m.symbol] != 0 {
+			owners = append(owners, owner)
+		}
+	}
+	sort.Strings(owners)
+
+	var exercised, assigned []posting
+	for _, owner := range owners {
+		held := ledgerBalances[owner][m.symbol]
+		value := held * settlement.Intrinsic
+		side := &assigned
+		if held > 0 {
+			side = &exercised
+			settlement.Exercised += int(held)
+			settlement.Paid += value
+		}
+		*side = append(*side,
+			posting{account: owner, asset: m.symbol, amount: -held},
+			posting{account: clearingAccount, asset: m.symbol, amount: held})
+		if value != 0 {
+			*side = append(*side,
+				posting{account: owner, asset: contract.Asset, amount: value},
+				posting{account: clearingAccount, asset: contract.Asset, amount: -value})
+		}
+	}
+	if len(exercised) > 0 {
+		postLocked(BalanceExercise, m.symbol, exercised)
+	}
+	if len(assigned) > 0 {
+		postLocked(BalanceAssignment, m.symbol, assigned)
+	}
+
+	contract.Status = OptionSettled
+	contract.Settlement = &settlement
+	log.Printf("options: %s settled at %.2f, paying %.2f on %d contracts", m.symbol, mark, settlement.Intrinsic, settlement.Exercised)
+	return settlement, true
+}
+
+// optionExpired reports whether a symbol is an option past its expiry
+func optionExpired(symbol string, now time.Time) bool {
+	accountsMu.Lock()
+	defer accountsMu.Unlock()
+	return optionExpiredLocked(symbol, now)
+}
+
+// optionExpiredLocked reports whether a symbol is an option past its expiry. It
+// must be called with accountsMu held.
+func optionExpiredLocked(symbol string, now time.Time) bool {
+	contract, ok := options[symbol]
+	return ok && !now.Before(contract.Expiry)
+}
+
+// parseOptions reads symbol=underlying:type:strike:expiry entries, such as
+// BTC-70000-C=BTC-USD:call:70000:2026-12-31T08:00:00Z
+func parseOptions(list string) (map[string]OptionSpec, error) {
+	specs := make(map[string]OptionSpec)
+	for _, item := range splitList(list) {
+		symbol, raw, ok := strings.Cut(item, "=")
+		fields := strings.SplitN(raw, ":", 4)
+		if !ok || strings.TrimSpace(symbol) == "" || len(fields) != 4 {
+			return nil, fmt.Errorf("-options entry %q must be symbol=underlying:type:strike:expiry", item)
+		}
+		optionType := OptionType(strings.ToLower(strings.TrimSpace(fields[1])))
+		strike, strikeErr := strconv.ParseFloat(strings.TrimSpace(fields[2]), 64)
+		expiry, expiryErr := time.Parse(time.RFC3339, strings.TrimSpace(fields[3]))
+		if (optionType != OptionCall && optionType != OptionPut) || strikeErr != nil || strike <= 0 || expiryErr != nil {
+			return nil, fmt.Errorf("-options entry %q needs a type of call or put, a positive strike and an RFC 3339 expiry", item)
+		}
+		specs[strings.ToUpper(strings.TrimSpace(symbol))] = OptionSpec{
+			Underlying: strings.ToUpper(strings.TrimSpace(fields[0])),
+			Type:       optionType,
+			Strike:     strike,
+			Expiry:     expiry,
+		}
+	}
+	return specs, nil
+}
+
+// getOptionsHandler lists the option symbols, or one of them, with their settlement
+func getOptionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	symbol := r.URL.Query().Get("symbol")
+	now := engineClock.Now()
+	accountsMu.Lock()
+	list := make([]OptionContract, 0, len(options))
+	for _, contract := range options {
+		if symbol != "" && contract.Symbol != symbol {
+			continue
+		}
+		listed := *contract
+		if listed.Status == OptionActive && optionExpiredLocked(listed.Symbol, now) {
+			listed.Status = OptionExpired
+		}
+		list = append(list, listed)
+	}
+	accountsMu.Unlock()
+
+	if symbol != "" && len(list) == 0 {
+		writeError(w, http.StatusNotFound, ErrCodeNotOption, "Not an option",
+			"symbol '"+symbol+"' is not an option")
+		return
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].Expiry.Equal(list[j].Expiry) {
+			return list[i].Expiry.Before(list[j].Expiry)
+		}
+		return list[i].Symbol < list[j].Symbol
+	})
+	json.NewEncoder(w).Encode(OptionsResponse{Options: list, Count: len(list)})
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIntrinsicValue(t *testing.T) {
+	cases := []struct {
+		optionType OptionType
+		price      float64
+		value      float64
+	}{
+		{OptionCall, 120, 20},
+		{OptionCall, 90, 0},
+		{OptionPut, 90, 10},
+		{OptionPut, 120, 0},
+	}
+	for _, tc := range cases {
+		if value := intrinsicValue(tc.optionType, 100, tc.price); value != tc.value {
+			t.Errorf("%s at %g: expected %g, got %g", tc.optionType, tc.price, tc.value, value)
+		}
+	}
+}
+
+func TestSettleOption_ExercisesAndAssigns(t *testing.T) {
+	setupTest()
+	resetSymbols([]string{"BTC-USD", "BTC-70000-C"})
+	contract := listOption("BTC-70000-C", OptionSpec{Underlying: "BTC-USD", Type: OptionCall, Strike: 70000, Expiry: engineClock.Now().Add(time.Hour)})
+	openFunded(t, "writer", map[string]float64{"USD": 10000})
+	openFunded(t, "holder", map[string]float64{"USD": 10000})
+
+	m, _ := matcherFor("BTC-70000-C")
+	placeOn(m, Order{ID: "write", Symbol: "BTC-70000-C", Side: SideSell, Price: 500.0, Quantity: 3, Owner: "writer"})
+	placeOn(m, Order{ID: "buy", Symbol: "BTC-70000-C", Side: SideBuy, Price: 500.0, Quantity: 2, Owner: "holder"})
+
+	writer, _ := lookupAccount("writer")
+	if writer.Balances["BTC-70000-C"] != -2 || writer.Balances["USD"] != 11000 {
+		t.Fatalf("Expected the writer short 2 contracts for a 1000 USD premium, got %+v", writer.Balances)
+	}
+
+	var settled bool
+	m.do(func() { _, settled = settleOption(m, 0) })
+	if settled {
+		t.Fatal("Expected no settlement without a mark")
+	}
+
+	var settlement OptionSettlement
+	m.do(func() { settlement, settled = settleOption(m, 72000) })
+	if !settled || settlement.Intrinsic != 2000 || settlement.Exercised != 2 || settlement.Paid != 4000 || len(settlement.Cancelled) != 1 {
+		t.Fatalf("Unexpected settlement %+v", settlement)
+	}
+	if len(bookFor("BTC-70000-C").asks.orders()) != 0 {
+		t.Error("Expected the resting remainder to be cancelled at expiry")
+	}
+	for id, usd := range map[string]float64{"writer": 7000, "holder": 13000} {
+		account, _ := lookupAccount(id)
+		if account.Balances["USD"] != usd || account.Balances["BTC-70000-C"] != 0 {
+			t.Errorf("%s: expected %g USD and no contracts, got %+v", id, usd, account.Balances)
+		}
+	}
+	history, _ := balanceHistory("writer", "USD")
+	if last := history[len(history)-1]; last.Type != BalanceAssignment || last.Amount != -4000 {
+		t.Errorf("Expected the writer to be assigned, got %+v", last)
+	}
+
+	accountsMu.Lock()
+	check := checkLedgerLocked()
+	accountsMu.Unlock()
+	if !check.Balanced {
+		t.Errorf("Expected a balanced ledger, got %+v", check)
+	}
+
+	// Orders after expiry are refused
+	accountsMu.Lock()
+	contract.Expiry = engineClock.Now().Add(-time.Second)
+	accountsMu.Unlock()
+	if late := processOn(m, Order{ID: "late", Symbol: "BTC-70000-C", Side: SideBuy, Price: 500.0, Quantity: 1, Owner: "holder"}); late.RejectReason != ErrCodeOptionExpired {
+		t.Errorf("Expected OPTION_EXPIRED, got %+v", late)
+	}
+}
+
+func TestOptionsHandler(t *testing.T) {
+	setupTest()
+	resetSymbols([]string{"BTC-USD", "BTC-70000-C", "BTC-60000-P"})
+	expiry := engineClock.Now().Add(time.Hour)
+	listOption("BTC-70000-C", OptionSpec{Underlying: "BTC-USD", Type: OptionCall, Strike: 70000, Expiry: expiry.Add(time.Hour)})
+	listOption("BTC-60000-P", OptionSpec{Underlying: "BTC-USD", Type: OptionPut, Strike: 60000, Expiry: expiry})
+
+	var response OptionsResponse
+	json.NewDecoder(serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/options", nil)).Body).Decode(&response)
+	if response.Count != 2 || response.Options[0].Symbol != "BTC-60000-P" || response.Options[0].Status != OptionActive || response.Options[0].Asset != "USD" {
+		t.Errorf("Expected both options by expiry, got %+v", response)
+	}
+	if result := serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/options?symbol=BTC-USD", nil)); result.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a symbol that is not an option, got %d", result.Code)
+	}
+}
+
+func TestLoadConfig_Options(t *testing.T) {
+	cfg, err := loadConfig([]string{"-symbols", "BTC-USD,BTC-70000-C", "-options", "btc-70000-c=btc-usd:CALL:70000:2026-12-31T08:00:00Z"})
+	spec := cfg.Options["BTC-70000-C"]
+	if err != nil || spec.Underlying != "BTC-USD" || spec.Type != OptionCall || spec.Strike != 70000 || spec.Expiry.Hour() != 8 {
+		t.Errorf("Unexpected options config %+v (%v)", cfg.Options, err)
+	}
+	for _, args := range [][]string{
+		{"-symbols", "BTC-USD,OPT", "-options", "OPT=BTC-USD:call:70000"},
+		{"-symbols", "BTC-USD,OPT", "-options", "OPT=BTC-USD:straddle:70000:2026-12-31T08:00:00Z"},
+		{"-symbols", "BTC-USD,OPT", "-options", "OPT=ETH-USD:put:70000:2026-12-31T08:00:00Z"},
+		{"-symbols", "OPT", "-options", "OPT=OPT:put:70000:2026-12-31T08:00:00Z"},
+	} {
+		if _, err := loadConfig(args); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
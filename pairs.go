@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Pair is what a symbol trades: its base asset, bought and sold at a price in
+// its quote asset per unit of base. An order's quantity is a number of lots of
+// LotSize units of base.
+type Pair struct {
+	Symbol  string  `json:"symbol"`
+	Base    string  `json:"base"`
+	Quote   string  `json:"quote"`
+	LotSize float64 `json:"lot_size"`
+}
+
+// pairs holds the pairs configured for symbols whose name does not say what
+// they trade, or whose quantities are in lots. Every other symbol's pair is
+// read from its name, in lots of one.
+var pairs map[string]Pair
+
+// pairForLocked returns the pair a symbol trades. Options trade contracts for
+// their settlement asset, and perpetuals their underlying for the asset they
+// settle in. It must be called with accountsMu held.
+func pairForLocked(symbol string) Pair {
+	if pair, ok := pairs[symbol]; ok {
+		return pair
+	}
+	pair := Pair{Symbol: symbol, LotSize: 1}
+	if contract, ok := options[symbol]; ok {
+		pair.Base, pair.Quote = symbol, contract.Asset
+		return pair
+	}
+	if _, ok := perpetuals[symbol]; ok {
+		underlying := strings.TrimSuffix(strings.TrimSuffix(symbol, "-PERP"), "/PERP")
+		pair.Base, _ = symbolAssets(underlying)
+		pair.Quote = perpetualAsset(symbol)
+		return pair
+	}
+	pair.Base, pair.Quote = symbolAssets(symbol)
+	return pair
+}
+
+// pairFor is pairForLocked for callers that do not hold accountsMu
+func pairFor(symbol string) Pair {
+	accountsMu.Lock()
+	defer accountsMu.Unlock()
+	return pairForLocked(symbol)
+}
+
+// symbolPairs returns the pair of every symbol, in symbol order
+func symbolPairs(symbols []string) []Pair {
+	accountsMu.Lock()
+	defer accountsMu.Unlock()
+	listed := make([]Pair, 0, len(symbols))
+	for _, symbol := range symbols {
+		listed = append(listed, pairForLocked(symbol))
+	}
+	return listed
+}
+
+// setTradeAmountsLocked records on a trade the assets it exchanged, the base
+// quantity its lots came to and the notional paid for them in quote. It must
+// be called with accountsMu held.
+func setTradeAmountsLocked(trade *Trade) {
+	pair := pairForLocked(trade.Symbol)
+	trade.BaseAsset, trade.QuoteAsset = pair.Base, pair.Quote
+	trade.BaseQuantity = float64(trade.Quantity) * pair.LotSize
+	trade.QuoteNotional = trade.Price * trade.BaseQuantity
+}
+
+// setTradeAmounts is setTradeAmountsLocked for trades that are not settled
+func setTradeAmounts(trade *Trade) {
+	accountsMu.Lock()
+	defer accountsMu.Unlock()
+	setTradeAmountsLocked(trade)
+}
+
+// tradeNotional is what a trade's base changed hands for, in quote. Trades
+// recorded before they carried their amounts are taken to be in lots of one.
+func tradeNotional(trade Trade) float64 {
+	if trade.BaseAsset != "" {
+		return trade.QuoteNotional
+	}
+	return trade.Price * float64(trade.Quantity)
+}
+
+// parsePairs reads -pairs entries such as DEFAULT=BTC/USD:0.001, a symbol's
+// base and quote assets with an optional lot size
+func parsePairs(list string) (map[string]Pair, error) {
+	parsed := make(map[string]Pair)
+	for _, item := range splitList(list) {
+		symbol, raw, ok := strings.Cut(item, "=")
+		assets, lot, hasLot := strings.Cut(raw, ":")
+		base, quote, split := strings.Cut(assets, "/")
+		symbol, base, quote = strings.ToUpper(strings.TrimSpace(symbol)), strings.ToUpper(strings.TrimSpace(base)), strings.ToUpper(strings.TrimSpace(quote))
+		if !ok || !split || symbol == "" || base == "" || quote == "" || base == quote {
+			return nil, fmt.Errorf("-pairs entry %q must be symbol=base/quote[:lot] with two different assets", item)
+		}
+		pair := Pair{Symbol: symbol, Base: base, Quote: quote, LotSize: 1}
+		if hasLot {
+			size, err := strconv.ParseFloat(strings.TrimSpace(lot), 64)
+			if err != nil || size <= 0 {
+				return nil, fmt.Errorf("-pairs entry %q needs a positive lot size", item)
+			}
+			pair.LotSize = size
+		}
+		parsed[symbol] = pair
+	}
+	return parsed, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPairs_SettleLotsInBaseAndQuote(t *testing.T) {
+	setupTest()
+	resetSymbols([]string{"XBT"})
+	pairs = map[string]Pair{"XBT": {Symbol: "XBT", Base: "BTC", Quote: "EUR", LotSize: 0.01}}
+	fees = FeeSchedule{TakerBps: 10}
+	openFunded(t, "maker", map[string]float64{"BTC": 1})
+	openFunded(t, "taker", map[string]float64{"EUR": 10000})
+
+	// 5 lots of 0.01 BTC at 60000 EUR a bitcoin
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "ask", Symbol: "XBT", Side: SideSell, Price: 60000, Quantity: 5, Owner: "maker"})
+	placeOn(m, Order{ID: "lift", Symbol: "XBT", Side: SideBuy, Price: 60000, Quantity: 5, Owner: "taker"})
+
+	trade := tradeStore.List("")[0]
+	if trade.BaseAsset != "BTC" || !approxEqual(trade.BaseQuantity, 0.05) || trade.QuoteAsset != "EUR" || !approxEqual(trade.QuoteNotional, 3000) {
+		t.Errorf("Expected 0.05 BTC for 3000 EUR, got %+v", trade)
+	}
+	if trade.Fees == nil || trade.Fees.Asset != "EUR" || !approxEqual(trade.Fees.TakerFee, 3) {
+		t.Errorf("Expected a 3 EUR taker fee, got %+v", trade.Fees)
+	}
+	maker, _ := lookupAccount("maker")
+	taker, _ := lookupAccount("taker")
+	if !approxEqual(maker.Balances["BTC"], 0.95) || !approxEqual(maker.Balances["EUR"], 3000) {
+		t.Errorf("Expected the maker to hold 0.95 BTC and 3000 EUR, got %+v", maker.Balances)
+	}
+	if !approxEqual(taker.Balances["BTC"], 0.05) || !approxEqual(taker.Balances["EUR"], 6997) {
+		t.Errorf("Expected the taker to hold 0.05 BTC and 6997 EUR, got %+v", taker.Balances)
+	}
+	if candles := candlesFor(t, "XBT"); len(candles) != 1 || !approxEqual(candles[0].Notional, 3000) {
+		t.Errorf("Expected the candle's notional in EUR, got %+v", candles)
+	}
+}
+
+// candlesFor fetches a symbol's one-minute candles
+func candlesFor(t *testing.T, symbol string) []Candle {
+	t.Helper()
+	var result CandlesResponse
+	json.NewDecoder(serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/candles?symbol="+symbol+"&interval=1m", nil)).Body).Decode(&result)
+	return result.Candles
+}
+
+func TestPairs_ListedAndVersioned(t *testing.T) {
+	setupTest()
+	resetSymbols([]string{"BTC-USD", "XBT"})
+	pairs = map[string]Pair{"XBT": {Symbol: "XBT", Base: "BTC", Quote: "EUR", LotSize: 0.01}}
+
+	var symbols SymbolsResponse
+	json.NewDecoder(serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/symbols", nil)).Body).Decode(&symbols)
+	want := []Pair{{Symbol: "BTC-USD", Base: "BTC", Quote: "USD", LotSize: 1}, {Symbol: "XBT", Base: "BTC", Quote: "EUR", LotSize: 0.01}}
+	if len(symbols.Pairs) != 2 || symbols.Pairs[0] != want[0] || symbols.Pairs[1] != want[1] {
+		t.Errorf("Expected %+v, got %+v", want, symbols.Pairs)
+	}
+
+	m, _ := matcherFor("BTC-USD")
+	placeOn(m, Order{ID: "ask", Symbol: "BTC-USD", Side: SideSell, Price: 100, Quantity: 2})
+	placeOn(m, Order{ID: "lift", Symbol: "BTC-USD", Side: SideBuy, Price: 100, Quantity: 2})
+	var trades TradesResponse
+	json.NewDecoder(versionedGet("/api/v1/trades", "2").Body).Decode(&trades)
+	if len(trades.Trades) != 1 || trades.Trades[0].BaseAsset != "" || trades.Trades[0].QuoteNotional != 0 {
+		t.Errorf("Expected version 2 trades without their amounts, got %+v", trades.Trades)
+	}
+	json.NewDecoder(versionedGet("/api/v1/trades", "").Body).Decode(&trades)
+	if len(trades.Trades) != 1 || trades.Trades[0].BaseQuantity != 2 || trades.Trades[0].QuoteNotional != 200 {
+		t.Errorf("Expected the current version to carry 2 BTC for 200 USD, got %+v", trades.Trades)
+	}
+}
+
+func TestLoadConfig_Pairs(t *testing.T) {
+	cfg, err := loadConfig([]string{"-symbols", "XBT,ETH", "-pairs", "xbt=btc/eur:0.01, ETH=ETH/USDT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Pairs["XBT"] != (Pair{Symbol: "XBT", Base: "BTC", Quote: "EUR", LotSize: 0.01}) || cfg.Pairs["ETH"].LotSize != 1 {
+		t.Errorf("Expected both pairs, got %+v", cfg.Pairs)
+	}
+	for _, args := range [][]string{
+		{"-pairs", "DEFAULT=BTC"},
+		{"-pairs", "DEFAULT=BTC/BTC"},
+		{"-pairs", "DEFAULT=BTC/USD:0"},
+		{"-pairs", "OTHER=BTC/USD"},
+		{"-symbols", "BTC-PERP", "-perpetuals", "BTC-PERP=8h", "-pairs", "BTC-PERP=BTC/USD"},
+	} {
+		if _, err := loadConfig(args); err == nil {
+			t.Errorf("Expected %v refused", args)
+		}
+	}
+}
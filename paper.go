@@ -0,0 +1,248 @@
+package main
+
+import (
+	"slices"
+	"time"
+)
+
+// paperVenue marks the trades paper orders make, apart from the trades a
+// shadow symbol mirrors from its exchange
+const paperVenue = "paper"
+
+// paperTrading lets shadow symbols take orders. They are filled against the
+// exchange's mirrored depth and trades, which they never change.
+var paperTrading bool
+
+// paperOrder trades an order on a shadow book against the exchange liquidity
+// it crosses. Each level gives paper orders no more than it shows, until the
+// exchange changes it. What is left rests behind the exchange's quantity at
+// its price. It must run on the book's matcher.
+func paperOrder(book *OrderBook, order Order) Order {
+	if (order.Type != "" && order.Type != OrderTypeLimit) || requiredFill(order) > 0 {
+		rejectOrder(&order, ErrCodePaperUnsupported)
+		return order
+	}
+	stampMatched(&order)
+
+	opposite := &book.asks
+	if order.Side == SideSell {
+		opposite = &book.bids
+	}
+	for _, level := range opposite.orders() {
+		if order.Quantity == 0 || !priceCrosses(order, level) {
+			break
+		}
+		takePaperLiquidity(book, &order, level, level.Price, order.Side)
+	}
+
+	switch {
+	case order.Quantity == 0:
+	case order.TimeInForce == TimeInForceIOC:
+		cancelUnfilled(&order)
+	default:
+		order.queueAhead = mirroredQuantity(book, order.Side, order.Price)
+		noteExpiry(book, order)
+		book.paper, _ = insertOrder(book.paper, order, comparePaper)
+	}
+	return order
+}
+
+// takePaperLiquidity fills a paper order at price from what a mirrored level
+// has not yet given other paper orders
+func takePaperLiquidity(book *OrderBook, order *Order, level Order, price float64, aggressor Side) {
+	key := levelKey{level.Side, level.Price}
+	quantity := min(level.Quantity-book.paperTaken[key], order.Quantity)
+	if quantity <= 0 {
+		return
+	}
+	if book.paperTaken == nil {
+		book.paperTaken = make(map[levelKey]int)
+	}
+	book.paperTaken[key] += quantity
+
+	makerID, takerID := level.ID, order.ID
+	if aggressor != order.Side {
+		makerID, takerID = order.ID, level.ID
+	}
+	fillPaper(order, makerID, takerID, price, quantity, aggressor)
+}
+
+// fillPaper records a paper order's fill as a paper trade and moves the
+// order's status on
+func fillPaper(order *Order, makerID, takerID string, price float64, quantity int, aggressor Side) {
+	trade := Trade{
+		ID:        generateTradeID(),
+		Symbol:    order.Symbol,
+		MakerID:   makerID,
+		TakerID:   takerID,
+		Price:     price,
+		Quantity:  quantity,
+		Venue:     paperVenue,
+		CreatedAt: engineClock.Now(),
+
+		AggressorSide: aggressor,
+	}
+	setTradeAmounts(&trade)
+	recordTrade(trade)
+	liquidity := LiquidityTaker
+	if aggressor != order.Side {
+		liquidity = LiquidityMaker
+	}
+	feed.fill(trade, *order, liquidity)
+
+	order.Quantity -= quantity
+	order.FilledQuantity += quantity
+	status, reason := OrderStatusPartiallyFilled, "partially filled on paper"
+	if order.Quantity == 0 {
+		status, reason = OrderStatusFilled, "fully filled on paper"
+	}
+	if err := transitionOrder(order, status, reason); err != nil {
+		logTransitionError(err)
+	}
+}
+
+// mirroredQuantity is what the exchange shows at one price on one side
+func mirroredQuantity(book *OrderBook, side Side, price float64) int {
+	quantity := 0
+	for _, order := range book.sideOf(side).level(price) {
+		quantity += order.Quantity
+	}
+	return quantity
+}
+
+// followPaperTrade fills resting paper orders from an exchange trade. A
+// trade through an order's price fills it outright; one at its price first
+// works through the exchange quantity that was ahead of it. Paper orders
+// share the trade's quantity in price-time priority. It must run on the
+// book's matcher.
+func followPaperTrade(book *OrderBook, trade Trade) {
+	if len(book.paper) == 0 {
+		return
+	}
+	side := SideBuy
+	if trade.AggressorSide == SideBuy {
+		side = SideSell
+	}
+	remaining := trade.Quantity
+	for i := range book.paper {
+		order := &book.paper[i]
+		if order.Side != side || remaining == 0 {
+			continue
+		}
+		if (side == SideBuy && order.Price < trade.Price) || (side == SideSell && order.Price > trade.Price) {
+			continue
+		}
+		available := remaining
+		if order.Price == trade.Price {
+			available = max(remaining-order.queueAhead, 0)
+			order.queueAhead = max(order.queueAhead-trade.Quantity, 0)
+		}
+		if quantity := min(available, order.Quantity); quantity > 0 {
+			fillPaper(order, order.ID, trade.ID, order.Price, quantity, trade.AggressorSide)
+			remaining -= quantity
+		}
+	}
+	dropFilledPaper(book)
+}
+
+// followPaperDepth catches resting paper orders up with changed exchange
+// levels. A level that shrank has less ahead of the orders at its price, and
+// one that now crosses an order fills it at its price. It must run on the
+// book's matcher.
+func followPaperDepth(book *OrderBook, changed []levelKey) {
+	for _, key := range changed {
+		delete(book.paperTaken, key)
+	}
+	for i := range book.paper {
+		order := &book.paper[i]
+		order.queueAhead = min(order.queueAhead, mirroredQuantity(book, order.Side, order.Price))
+
+		opposite := &book.asks
+		if order.Side == SideSell {
+			opposite = &book.bids
+		}
+		for _, level := range opposite.orders() {
+			if order.Quantity == 0 || !priceCrosses(*order, level) {
+				break
+			}
+			takePaperLiquidity(book, order, level, order.Price, level.Side)
+		}
+	}
+	dropFilledPaper(book)
+}
+
+// paperQueuePosition estimates where a paper order waits: behind what is left
+// of the exchange quantity it joined, and any paper orders ahead of it at its
+// price. The exchange's quantity counts as one order.
+func paperQueuePosition(book *OrderBook, symbol, orderID string) (QueuePosition, bool) {
+	i := slices.IndexFunc(book.paper, func(order Order) bool { return order.ID == orderID })
+	if i < 0 {
+		return QueuePosition{}, false
+	}
+	order := book.paper[i]
+	position := QueuePosition{
+		OrderID:       order.ID,
+		Symbol:        symbol,
+		Side:          order.Side,
+		Price:         order.Price,
+		Quantity:      order.Quantity,
+		QuantityAhead: order.queueAhead,
+		LevelQuantity: mirroredQuantity(book, order.Side, order.Price),
+	}
+	if order.queueAhead > 0 {
+		position.OrdersAhead = 1
+	}
+	if position.LevelQuantity > 0 {
+		position.LevelOrders = 1
+	}
+	for j, resting := range book.paper {
+		if resting.Side != order.Side || resting.Price != order.Price {
+			continue
+		}
+		if j < i {
+			position.OrdersAhead++
+			position.QuantityAhead += resting.Quantity
+		}
+		position.LevelOrders++
+		position.LevelQuantity += resting.Quantity
+	}
+	position.Position = position.OrdersAhead + 1
+	return position, true
+}
+
+// comparePaper orders paper orders buys first, each side in its book's
+// price-time priority
+func comparePaper(a, b *Order) int {
+	switch {
+	case a.Side != b.Side && a.Side == SideBuy:
+		return -1
+	case a.Side != b.Side:
+		return 1
+	case a.Side == SideBuy:
+		return compareBids(a, b)
+	}
+	return compareAsks(a, b)
+}
+
+// dropFilledPaper removes the paper orders that have filled
+func dropFilledPaper(book *OrderBook) {
+	book.paper = slices.DeleteFunc(book.paper, func(order Order) bool { return order.Quantity == 0 })
+}
+
+// expirePaper takes the paper orders whose expiry has passed off the book
+func expirePaper(book *OrderBook, now time.Time, expired []Order) []Order {
+	kept := book.paper[:0]
+	for _, order := range book.paper {
+		if isExpired(order, now) {
+			if err := transitionOrder(&order, OrderStatusExpired, "expiry time reached"); err != nil {
+				logTransitionError(err)
+			}
+			expired = append(expired, order)
+			continue
+		}
+		noteExpiry(book, order)
+		kept = append(kept, order)
+	}
+	book.paper = kept
+	return expired
+}
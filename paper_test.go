@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+// paperBook sets up BTC-USD as a paper-traded shadow symbol mirroring levels
+// one lot to a unit
+func paperBook(t *testing.T, levels ...shadowLevel) *matcher {
+	t.Helper()
+	setupTest()
+	resetSymbols([]string{"BTC-USD"})
+	shadowSymbols = map[string]bool{"BTC-USD": true}
+	paperTrading = true
+	m, _ := matcherFor("BTC-USD")
+	m.do(func() { mirrorDepth(m, shadowUpdate{snapshot: true, levels: levels}, 1) })
+	return m
+}
+
+func TestPaperOrder_TakesTheMirroredLiquidity(t *testing.T) {
+	m := paperBook(t, shadowLevel{SideSell, 101, 5}, shadowLevel{SideSell, 102, 5})
+
+	first := processOn(m, Order{ID: "first", Symbol: "BTC-USD", Side: SideBuy, Price: 102, Quantity: 7, Owner: "alice"})
+	if first.Status != OrderStatusFilled || first.FilledQuantity != 7 {
+		t.Fatalf("Expected the order filled against both asks, got %+v", first)
+	}
+	trades := tradeStore.List("BTC-USD")
+	if len(trades) != 2 || trades[0].Price != 101 || trades[0].Quantity != 5 || trades[1].Quantity != 2 ||
+		trades[0].Venue != paperVenue || trades[0].TakerID != "first" {
+		t.Fatalf("Expected paper trades of 5 at 101 and 2 at 102, got %+v", trades)
+	}
+	if snapshot := m.depthSnapshot(0); len(snapshot.Asks) != 2 || snapshot.Asks[0].Quantity != 5 {
+		t.Errorf("Expected the mirrored book untouched, got %+v", snapshot)
+	}
+
+	// The 102 level has given paper orders 2 of its 5 until the exchange changes it
+	second := processOn(m, Order{ID: "second", Symbol: "BTC-USD", Side: SideBuy, Price: 102, Quantity: 5})
+	if second.Status != OrderStatusPartiallyFilled || second.FilledQuantity != 3 || len(m.book.paper) != 1 {
+		t.Fatalf("Expected 3 filled and 2 resting, got %+v", second)
+	}
+	m.do(func() { mirrorDepth(m, shadowUpdate{levels: []shadowLevel{{SideSell, 102, 4}}}, 1) })
+	if len(m.book.paper) != 0 || len(tradeStore.List("BTC-USD")) != 4 {
+		t.Errorf("Expected the changed ask to fill the resting order, got %+v", m.book.paper)
+	}
+}
+
+func TestPaperOrder_FillsFromTradedVolume(t *testing.T) {
+	m := paperBook(t, shadowLevel{SideBuy, 100, 10}, shadowLevel{SideSell, 101, 10})
+
+	order := processOn(m, Order{ID: "bid", Symbol: "BTC-USD", Side: SideBuy, Price: 100, Quantity: 5})
+	if order.Status != OrderStatusPending || len(m.book.paper) != 1 {
+		t.Fatalf("Expected the order resting behind the exchange's bid, got %+v", order)
+	}
+	var position QueuePosition
+	m.do(func() { position, _ = m.queuePosition("bid") })
+	if position.QuantityAhead != 10 || position.Position != 2 || position.LevelQuantity != 15 {
+		t.Errorf("Expected 10 ahead in a level of 15, got %+v", position)
+	}
+
+	trade := func(price, size float64) {
+		m.do(func() { mirrorTrade(m, shadowTrade{price: price, size: size, aggressor: SideSell}, 1) })
+	}
+	trade(100, 6)
+	if m.book.paper[0].FilledQuantity != 0 || m.book.paper[0].queueAhead != 4 {
+		t.Fatalf("Expected the trade to work through the queue ahead, got %+v", m.book.paper[0])
+	}
+	trade(100, 6)
+	if m.book.paper[0].FilledQuantity != 2 || m.book.paper[0].queueAhead != 0 {
+		t.Fatalf("Expected 2 filled past the queue, got %+v", m.book.paper[0])
+	}
+	trade(99, 10)
+	if len(m.book.paper) != 0 {
+		t.Fatalf("Expected a trade through the price to fill the rest, got %+v", m.book.paper)
+	}
+	trades := tradeStore.List("BTC-USD")
+	if last := trades[len(trades)-1]; last.Venue != paperVenue || last.Price != 100 || last.Quantity != 3 || last.MakerID != "bid" {
+		t.Errorf("Expected the rest filled at the order's price, got %+v", last)
+	}
+}
+
+func TestPaperOrder_CancelsAndRefuses(t *testing.T) {
+	m := paperBook(t, shadowLevel{SideSell, 101, 5})
+
+	processOn(m, Order{ID: "bid", Symbol: "BTC-USD", Side: SideBuy, Price: 100, Quantity: 5})
+	response := serve(HTTPConfig{}, httptest.NewRequest("DELETE", "/api/v1/orders/bid", nil))
+	if response.Code != 200 || len(m.book.paper) != 0 {
+		t.Errorf("Expected the paper order cancelled, got %d: %s", response.Code, response.Body.String())
+	}
+
+	for _, order := range []Order{
+		{ID: "market", Symbol: "BTC-USD", Side: SideBuy, Type: OrderTypeMarket, Quantity: 1},
+		{ID: "aon", Symbol: "BTC-USD", Side: SideBuy, Price: 101, Quantity: 10, AllOrNone: true},
+	} {
+		if placed := processOn(m, order); placed.Status != OrderStatusRejected || placed.RejectReason != ErrCodePaperUnsupported {
+			t.Errorf("Expected %s refused, got %+v", order.ID, placed)
+		}
+	}
+	if ioc := processOn(m, Order{ID: "ioc", Symbol: "BTC-USD", Side: SideBuy, Price: 101, Quantity: 8, TimeInForce: TimeInForceIOC}); ioc.Status != OrderStatusCancelled || ioc.FilledQuantity != 5 {
+		t.Errorf("Expected the IOC remainder cancelled, got %+v", ioc)
+	}
+}
+
+func TestLoadConfig_Paper(t *testing.T) {
+	cfg, err := loadConfig([]string{"-symbols", "BTC-USD", "-shadow", "BTC-USD=binance:btcusdt", "-paper"})
+	if err != nil || !cfg.Shadow.Paper {
+		t.Fatalf("Expected paper trading on, got %+v (%v)", cfg.Shadow, err)
+	}
+	if _, err := loadConfig([]string{"-symbols", "BTC-USD", "-paper"}); err == nil {
+		t.Error("Expected -paper refused without -shadow")
+	}
+}
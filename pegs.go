@@ -0,0 +1,133 @@
+package main
+
+// PegType names the quote a pegged order tracks
+type PegType string
+
+const (
+	// PegPrimary tracks the best price on the order's own side
+	PegPrimary PegType = "primary"
+	// PegMidpoint tracks the middle of the best bid and offer
+	PegMidpoint PegType = "midpoint"
+	// PegMarket tracks the best price on the opposite side
+	PegMarket PegType = "market"
+)
+
+// validatePeg checks the fields that only apply to pegged order requests
+func validatePeg(req PlaceOrderRequest) []FieldError {
+	var errs []FieldError
+
+	if req.Price != 0 {
+		errs = append(errs, fieldError("price", "excluded", "price is not used by pegged orders; it follows the best bid and offer"))
+	}
+
+	switch req.Peg {
+	case PegPrimary, PegMidpoint, PegMarket:
+	case "":
+		errs = append(errs, fieldError("peg", "required", "pegged orders need a peg of 'primary', 'midpoint' or 'market'"))
+	default:
+		errs = append(errs, fieldError("peg", "oneof", "peg must be one of 'primary', 'midpoint' or 'market' (received: '%s')", req.Peg))
+	}
+
+	if req.PegOffset < 0 {
+		errs = append(errs, fieldError("peg_offset", "min", "peg_offset cannot be negative (received: %.2f)", req.PegOffset))
+	}
+
+	return errs
+}
+
+// referenceQuotes returns the best bid and offer among orders that are not
+// pegged, so pegged orders never price off each other. Zero means that side
+// has no quote.
+func referenceQuotes(book *OrderBook) (bid, ask float64) {
+	book.bids.each(func(order *Order) bool {
+		if order.Type != OrderTypePegged {
+			bid = order.Price
+		}
+		return bid == 0
+	})
+	book.asks.each(func(order *Order) bool {
+		if order.Type != OrderTypePegged {
+			ask = order.Price
+		}
+		return ask == 0
+	})
+	return bid, ask
+}
+
+// pegPrice works out a pegged order's price from the reference quotes. The
+// offset always moves the price away from the market. It reports false when
+// the quote the peg needs is missing.
+func pegPrice(order Order, bid, ask float64) (float64, bool) {
+	var reference float64
+	switch {
+	case order.Peg == PegMidpoint:
+		if bid == 0 || ask == 0 {
+			return 0, false
+		}
+		reference = (bid + ask) / 2
+	case (order.Peg == PegPrimary) == (order.Side == SideBuy):
+		// Primary buys and market sells track the bid
+		reference = bid
+	default:
+		reference = ask
+	}
+
+	price := reference + order.PegOffset
+	if order.Side == SideBuy {
+		price = reference - order.PegOffset
+	}
+	if reference == 0 || price <= 0 {
+		return 0, false
+	}
+	return price, true
+}
+
+// repricePegs moves every pegged order whose reference quote has changed.
+// A repriced order loses its queue position and is re-entered at the new
+// price, where it may trade; that can move the quotes again, so this repeats
+// until they settle. While its quote is missing, an order keeps its last price.
+// Books without pegged orders are skipped.
+func repricePegs(book *OrderBook) {
+	for book.hasPegs {
+		bid, ask := referenceQuotes(book)
+		if bid == book.pegBid && ask == book.pegAsk {
+			return
+		}
+		book.pegBid, book.pegAsk = bid, ask
+
+		var moved []Order
+		var buyPegs, sellPegs int
+		moved, buyPegs = pullRepriced(book, &book.bids, bid, ask, moved)
+		moved, sellPegs = pullRepriced(book, &book.asks, bid, ask, moved)
+
+		// Moved orders set it again if they come back to rest
+		book.hasPegs = buyPegs+sellPegs > 0
+		for _, order := range moved {
+			executeOrder(book, order)
+		}
+	}
+}
+
+// pullRepriced takes the pegged orders whose price changed off one side,
+// ready to be re-entered, and counts the pegged orders left behind
+func pullRepriced(book *OrderBook, side *bookSide, bid, ask float64, moved []Order) ([]Order, int) {
+	pegs := 0
+	side.sweep(func(order *Order, position int) bool {
+		if order.Type != OrderTypePegged {
+			return false
+		}
+		price, ok := pegPrice(*order, bid, ask)
+		if !ok || price == order.Price {
+			pegs++
+			return false
+		}
+		adjustLevel(book, order.Side, order.Price, -order.Quantity, -1)
+		publishL3(book, L3Delete, *order, position, -order.Quantity, "")
+		repriced := *order
+		repriced.Price = price
+		requeue(&repriced)
+		moved = append(moved, repriced)
+		return true
+	})
+	return moved, pegs
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// quote places a plain limit order on the default book
+func quote(id string, side Side, price float64, quantity int) Order {
+	return processOrder(Order{ID: id, Side: side, Price: price, Quantity: quantity, Status: OrderStatusPending, CreatedAt: time.Now()})
+}
+
+// pegged places a pegged order on the default book
+func pegged(id string, side Side, peg PegType, offset float64, quantity int) Order {
+	return processOrder(Order{ID: id, Side: side, Quantity: quantity, Type: OrderTypePegged, Peg: peg, PegOffset: offset, Status: OrderStatusPending, CreatedAt: time.Now()})
+}
+
+// restingOrder finds an order on either side of the default book
+func restingOrder(id string) (Order, bool) {
+	for _, order := range append(append([]Order{}, bookFor("").bids.orders()...), bookFor("").asks.orders()...) {
+		if order.ID == id {
+			return order, true
+		}
+	}
+	return Order{}, false
+}
+
+func TestPeggedOrder_PrimaryFollowsBid(t *testing.T) {
+	setupTest()
+	start := time.Date(2024, 1, 1, 9, 30, 0, 0, time.UTC)
+	clock := useDeterministicEngine(t, start)
+
+	quote("bid-1", SideBuy, 99.0, 5)
+	quote("ask-1", SideSell, 101.0, 5)
+
+	result := pegged("peg-1", SideBuy, PegPrimary, 0.25, 10)
+	if result.Status != OrderStatusPending || result.Price != 98.75 {
+		t.Fatalf("Expected the peg to rest at 98.75, got %s at %.2f", result.Status, result.Price)
+	}
+
+	clock.Advance(time.Second)
+	quote("bid-2", SideBuy, 99.5, 5)
+
+	peg, ok := restingOrder("peg-1")
+	if !ok || peg.Price != 99.25 {
+		t.Fatalf("Expected the peg to move up to 99.25, got %+v", peg)
+	}
+
+	if !peg.CreatedAt.Equal(clock.Now()) {
+		t.Errorf("Expected the reprice to reset time priority to %s, got %s", clock.Now(), peg.CreatedAt)
+	}
+
+	// Cancelling the new best bid drops the peg back
+	cancelOrder("", "bid-2")
+	if peg, _ := restingOrder("peg-1"); peg.Price != 98.75 {
+		t.Errorf("Expected the peg back at 98.75, got %.2f", peg.Price)
+	}
+}
+
+func TestPeggedOrder_MidpointKeepsPriceWithoutQuote(t *testing.T) {
+	setupTest()
+
+	quote("bid-1", SideBuy, 99.0, 5)
+	quote("ask-1", SideSell, 101.0, 5)
+
+	if result := pegged("peg-1", SideSell, PegMidpoint, 0, 3); result.Price != 100.0 {
+		t.Fatalf("Expected the midpoint peg at 100.00, got %.2f", result.Price)
+	}
+
+	cancelOrder("", "ask-1")
+	if peg, _ := restingOrder("peg-1"); peg.Price != 100.0 {
+		t.Errorf("Expected the peg to hold 100.00 with no offer, got %.2f", peg.Price)
+	}
+
+	quote("ask-2", SideSell, 102.0, 5)
+	if peg, _ := restingOrder("peg-1"); peg.Price != 100.5 {
+		t.Errorf("Expected the peg to move to 100.50, got %.2f", peg.Price)
+	}
+}
+
+func TestPeggedOrder_MarketPegRestsInsideSpread(t *testing.T) {
+	setupTest()
+
+	quote("bid-1", SideBuy, 99.0, 5)
+	quote("ask-1", SideSell, 101.0, 5)
+
+	result := pegged("peg-1", SideBuy, PegMarket, 0.5, 4)
+	if result.Price != 100.5 || result.Status != OrderStatusPending {
+		t.Fatalf("Expected the market peg to rest at 100.50, got %s at %.2f", result.Status, result.Price)
+	}
+
+	// An aggressive offer trades with the peg at the peg's price
+	quote("ask-2", SideSell, 99.2, 2)
+
+	if len(tradeStore.List("")) != 1 || tradeStore.List("")[0].MakerID != "peg-1" || tradeStore.List("")[0].Price != 100.5 {
+		t.Fatalf("Expected ask-2 to take the peg at 100.50, got %+v", tradeStore.List(""))
+	}
+
+	peg, ok := restingOrder("peg-1")
+	if !ok || peg.Quantity != 2 || peg.Price != 100.5 || peg.Status != OrderStatusPartiallyFilled {
+		t.Errorf("Expected 2 left resting at 100.50, got %+v", peg)
+	}
+}
+
+func TestPeggedOrder_RejectedWithoutReference(t *testing.T) {
+	setupTest()
+	quote("bid-1", SideBuy, 99.0, 5)
+
+	result := pegged("peg-1", SideBuy, PegMidpoint, 0, 1)
+
+	if result.Status != OrderStatusRejected || result.RejectReason != ErrCodeNoReferencePrice {
+		t.Errorf("Expected NO_REFERENCE_PRICE rejection, got %s %s", result.Status, result.RejectReason)
+	}
+}
+
+func TestPeggedOrder_IgnoresOtherPegs(t *testing.T) {
+	setupTest()
+
+	quote("bid-1", SideBuy, 99.0, 5)
+	quote("ask-1", SideSell, 101.0, 5)
+	pegged("peg-1", SideBuy, PegMarket, 0.5, 1)
+	pegged("peg-2", SideBuy, PegPrimary, 0, 1)
+
+	// peg-1 at 100.50 is the best bid, but peg-2 still tracks bid-1
+	if peg, _ := restingOrder("peg-2"); peg.Price != 99.0 {
+		t.Errorf("Expected peg-2 to track the unpegged bid at 99.00, got %.2f", peg.Price)
+	}
+}
+
+func TestPlaceOrderHandler_PeggedValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		req  PlaceOrderRequest
+	}{
+		{"no peg", PlaceOrderRequest{Side: SideBuy, Quantity: 1, Type: OrderTypePegged}},
+		{"unknown peg", PlaceOrderRequest{Side: SideBuy, Quantity: 1, Type: OrderTypePegged, Peg: "vwap"}},
+		{"negative offset", PlaceOrderRequest{Side: SideBuy, Quantity: 1, Type: OrderTypePegged, Peg: PegPrimary, PegOffset: -1}},
+		{"price set", PlaceOrderRequest{Side: SideBuy, Quantity: 1, Price: 100, Type: OrderTypePegged, Peg: PegPrimary}},
+	}
+
+	for _, test := range tests {
+		setupTest()
+
+		jsonData, _ := json.Marshal(test.req)
+		request := httptest.NewRequest("POST", "/api/v1/orders", bytes.NewBuffer(jsonData))
+		response := httptest.NewRecorder()
+		placeOrderHandler(response, request)
+
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", test.name, response.Code)
+		}
+	}
+}
+
+func TestRepricePegs_SkipsBooksWithoutPegs(t *testing.T) {
+	setupTest()
+
+	quote("bid-1", SideBuy, 99.0, 5)
+	quote("ask-1", SideSell, 101.0, 5)
+	if bookFor("").hasPegs {
+		t.Fatal("Expected a book of plain limits to have no pegs")
+	}
+
+	pegged("peg-1", SideBuy, PegPrimary, 0, 1)
+	if !bookFor("").hasPegs {
+		t.Fatal("Expected hasPegs once a pegged order rests")
+	}
+
+	// The next reprice after the peg leaves notices the book is clear
+	cancelOrder("", "peg-1")
+	quote("bid-2", SideBuy, 99.5, 5)
+	if bookFor("").hasPegs {
+		t.Error("Expected hasPegs to clear once no pegged orders rest")
+	}
+}
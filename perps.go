@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PerpetualConfig lists the perpetual swap symbols and how they pay funding
+type PerpetualConfig struct {
+	// Funding maps each perpetual symbol to its funding interval
+	Funding map[string]time.Duration
+	// RateCap bounds the funding rate of one interval either way
+	RateCap float64
+}
+
+// perpetualContract is a perpetual symbol's funding schedule
+type perpetualContract struct {
+	interval time.Duration
+	nextAt   time.Time
+}
+
+// Position is an account's holding in a perpetual symbol
+type Position struct {
+	Symbol string `json:"symbol"`
+	// Quantity is signed: positive is long and negative short
+	Quantity int `json:"quantity"`
+	// EntryPrice is the average price the open quantity was traded at
+	EntryPrice  float64 `json:"entry_price,omitempty"`
+	RealizedPnL float64 `json:"realized_pnl"`
+	// Funding is the net funding received; negative when the position paid
+	Funding float64 `json:"funding"`
+	// MarkPrice and UnrealizedPnL value the open quantity at the mark price
+	MarkPrice     float64   `json:"mark_price,omitempty"`
+	UnrealizedPnL float64   `json:"unrealized_pnl"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// PositionsResponse lists an account's perpetual positions by symbol
+type PositionsResponse struct {
+	AccountID string     `json:"account_id"`
+	Positions []Position `json:"positions"`
+	Count     int        `json:"count"`
+	// Limits are the account's use of each symbol's position limit
+	Limits []PositionLimitUsage `json:"limits,omitempty" since:"5"`
+}
+
+// FundingEvent is one funding payment between a symbol's longs and shorts
+type FundingEvent struct {
+	ID     string    `json:"id"`
+	Symbol string    `json:"symbol"`
+	At     time.Time `json:"at"`
+	// Rate is positive when longs pay shorts and negative when shorts pay longs
+	Rate       float64 `json:"rate"`
+	MarkPrice  float64 `json:"mark_price"`
+	IndexPrice float64 `json:"index_price"`
+	// Positions is how many open positions paid or received, and Paid is what
+	// the paying side paid in total
+	Positions int     `json:"positions"`
+	Paid      float64 `json:"paid"`
+}
+
+// FundingResponse is a perpetual symbol's funding schedule, the rate it
+// would pay now and its past funding events, oldest first
+type FundingResponse struct {
+	Symbol   string    `json:"symbol"`
+	Interval string    `json:"interval"`
+	NextAt   time.Time `json:"next_at"`
+	// PredictedRate is what funding would pay at the current mark and index;
+	// left out while either is missing
+	PredictedRate *float64       `json:"predicted_rate,omitempty"`
+	Events        []FundingEvent `json:"events"`
+	Count         int            `json:"count"`
+}
+
+// fundingRateCap bounds the rate of one funding payment either way
+var fundingRateCap = 0.0075
+
+// perpetuals, positions and fundingEvents are guarded by accountsMu
+var (
+	perpetuals    = make(map[string]*perpetualContract)
+	positions     = make(map[string]map[string]*Position)
+	fundingEvents []FundingEvent
+)
+
+// startPerpetuals makes each configured symbol a perpetual and pays its
+// funding every interval until ctx is done. A standby replicates no accounts,
+// so it pays no funding. On a virtual clock the simulation pays it as it
+// moves.
+func startPerpetuals(ctx context.Context, cfg PerpetualConfig) {
+	fundingRateCap = cfg.RateCap
+	for symbol, interval := range cfg.Funding {
+		m, ok := matcherFor(symbol)
+		if !ok {
+			log.Printf("perpetuals: unknown symbol %q, funding not started", symbol)
+			continue
+		}
+		accountsMu.Lock()
+		perpetuals[m.symbol] = &perpetualContract{interval: interval, nextAt: engineClock.Now().Add(interval)}
+		accountsMu.Unlock()
+
+		if simulatedClock == nil {
+			go runFunding(ctx, m, interval)
+		}
+		log.Printf("perpetuals: %s pays funding every %s", m.symbol, interval)
+	}
+}
+
+// runFunding pays the symbol's funding on every tick
+func runFunding(ctx context.Context, m *matcher, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !standby.running.Load() {
+				m.do(func() { payFunding(m.symbol, m.book) })
+			}
+		}
+	}
+}
+
+// isPerpetual reports whether a symbol trades as a perpetual swap
+func isPerpetual(symbol string) bool {
+	accountsMu.Lock()
+	defer accountsMu.Unlock()
+	_, ok := perpetuals[symbol]
+	return ok
+}
+
+// perpetualSymbols lists the symbols traded as perpetual swaps
+func perpetualSymbols() []string {
+	accountsMu.Lock()
+	defer accountsMu.Unlock()
+	symbols := make([]string, 0, len(perpetuals))
+	for symbol := range perpetuals {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+// perpetualAsset is the asset a perpetual settles in: the quote of the symbol
+// without its PERP suffix, so BTC-PERP settles in USD and BTC-USDT-PERP in USDT
+func perpetualAsset(symbol string) string {
+	for _, suffix := range []string{"-PERP", "/PERP"} {
+		if trimmed, ok := strings.CutSuffix(symbol, suffix); ok {
+			_, quote := symbolAssets(trimmed)
+			return quote
+		}
+	}
+	_, quote := symbolAssets(symbol)
+	return quote
+}
+
+// fundingRate is the premium of the mark over the index, capped either way
+func fundingRate(mark, index float64) float64 {
+	rate := (mark - index) / index
+	return math.Max(-fundingRateCap, math.Min(fundingRateCap, rate))
+}
+
+// positionLocked returns an account's position in a symbol, opening a flat
+// one if it has none. It must be called with accountsMu held.
+func positionLocked(account, symbol string) *Position {
+	held, ok := positions[account]
+	if !ok {
+		held = make(map[string]*Position)
+		positions[account] = held
+	}
+	position, ok := held[symbol]
+	if !ok {
+		position = &Position{Symbol: symbol}
+		held[symbol] = position
+	}
+	return position
+}
+
+// apply adds a signed quantity traded at price to the position and returns
+// the profit or loss it realized. Quantity that reduces the position closes
+// it at its entry price; quantity beyond that opens the other way at price.
+func (p *Position) apply(delta int, price float64) float64 {
+	if p.Quantity == 0 || (p.Quantity > 0) == (delta > 0) {
+		open := math.Abs(float64(p.Quantity))
+		added := math.Abs(float64(delta))
+		p.EntryPrice = (p.EntryPrice*open + price*added) / (open + added)
+		p.Quantity += delta
+		return 0
+	}
+
+	closed := min(abs(p.Quantity), abs(delta))
+	realized := float64(closed) * (price - p.EntryPrice)
+	if p.Quantity < 0 {
+		realized = -realized
+	}
+	p.Quantity += delta
+	switch {
+	case p.Quantity == 0:
+		p.EntryPrice = 0
+	case (p.Quantity > 0) == (delta > 0):
+		// The trade flipped the position, so what is left opened at price
+		p.EntryPrice = price
+	}
+	p.RealizedPnL += realized
+	return realized
+}
+
+// abs returns the magnitude of a quantity
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// settlePerpetualLocked moves a perpetual trade's quantity from the seller's
+// position to the buyer's. Profit or loss realized by either side is paid
+// from or to the clearing account, which carries the positions still open,
+// and posted as kind. It must be called with accountsMu held.
+func settlePerpetualLocked(trade Trade, buyer, seller string, kind BalanceChangeType) {
+	asset := perpetualAsset(trade.Symbol)
+	now := engineClock.Now()
+
+	var postings []posting
+	for _, leg := range []struct {
+		account string
+		delta   int
+	}{{buyer, trade.Quantity}, {seller, -trade.Quantity}} {
+		position := positionLocked(leg.account, trade.Symbol)
+		position.UpdatedAt = now
+		if realized := position.apply(leg.delta, trade.Price); realized != 0 {
+			postings = append(postings,
+				posting{account: leg.account, asset: asset, amount: realized},
+				posting{account: clearingAccount, asset: asset, amount: -realized})
+		}
+	}
+	if len(postings) > 0 {
+		postLocked(kind, trade.ID, postings)
+	}
+}
+
+// payFunding charges every open position in a perpetual symbol its funding
+// at the current mark and index prices, and moves the symbol's next funding
+// time on. Without both prices nothing is paid. It must run on the symbol's
+// matcher.
+func payFunding(symbol string, book *OrderBook) (FundingEvent, bool) {
+	accountsMu.Lock()
+	defer accountsMu.Unlock()
+
+	contract, ok := perpetuals[symbol]
+	if !ok {
+		return FundingEvent{}, false
+	}
+	now := engineClock.Now()
+	contract.nextAt = now.Add(contract.interval)
+
+	mark, _ := markPrice(book)
+	index := book.mark.inputs.Index
+	if mark == 0 || index == 0 {
+		log.Printf("perpetuals: %s skipped funding without both a mark and an index price", symbol)
+		return FundingEvent{}, false
+	}
+
+	event := FundingEvent{ID: generateOrderID(), Symbol: symbol, At: now, Rate: fundingRate(mark, index), MarkPrice: mark, IndexPrice: index}
+	asset := perpetualAsset(symbol)
+
+	owners := make([]string, 0, len(positions))
+	for owner := range positions {
+		owners = append(owners, owner)
+	}
+	sort.Strings(owners)
+
+	var postings []posting
+	for _, owner := range owners {
+		position := positions[owner][symbol]
+		if position == nil || position.Quantity == 0 {
+			continue
+		}
+		// Longs pay a positive rate and shorts receive it
+		amount := -float64(position.Quantity) * mark * event.Rate
+		position.Funding += amount
+		position.UpdatedAt = now
+		event.Positions++
+		if amount < 0 {
+			event.Paid -= amount
+		}
+		if amount != 0 {
+			postings = append(postings,
+				posting{account: owner, asset: asset, amount: amount},
+				posting{account: clearingAccount, asset: asset, amount: -amount})
+		}
+	}
+	if len(postings) > 0 {
+		postLocked(BalanceFunding, event.ID, postings)
+	}
+	fundingEvents = append(fundingEvents, event)
+	return event, true
+}
+
+// accountPositions returns an account's perpetual positions valued at their
+// symbols' mark prices, or false if there is no such account
+func accountPositions(id string) ([]Position, bool) {
+	// Marks are read first: the matchers take accountsMu when trades settle
+	marks := make(map[string]float64)
+	for _, m := range allMatchers() {
+		if isPerpetual(m.symbol) {
+			m.do(func() { marks[m.symbol], _ = markPrice(m.book) })
+		}
+	}
+
+	accountsMu.Lock()
+	defer accountsMu.Unlock()
+	if _, ok := accounts[id]; !ok {
+		return nil, false
+	}
+	list := make([]Position, 0, len(positions[id]))
+	for _, position := range positions[id] {
+		held := *position
+		if mark := marks[held.Symbol]; mark > 0 && held.Quantity != 0 {
+			held.MarkPrice = mark
+			held.UnrealizedPnL = float64(held.Quantity) * (mark - held.EntryPrice)
+		}
+		list = append(list, held)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Symbol < list[j].Symbol })
+	return list, true
+}
+
+// getPositionsHandler returns the perpetual positions of the account named in
+// the path, and its use of the position limits
+func getPositionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id := r.PathValue("id")
+	list, ok := accountPositions(id)
+	if !ok {
+		writeAccountNotFound(w, id)
+		return
+	}
+	json.NewEncoder(w).Encode(PositionsResponse{
+		AccountID: id,
+		Positions: list,
+		Count:     len(list),
+		Limits:    positionLimitUsage(id),
+	})
+}
+
+// getFundingHandler returns a perpetual symbol's funding schedule and history
+func getFundingHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	m, ok := matcherFor(r.URL.Query().Get("symbol"))
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrCodeUnknownSymbol, "Unknown symbol",
+			"symbol '"+r.URL.Query().Get("symbol")+"' is not traded here")
+		return
+	}
+
+	var mark, index float64
+	m.do(func() {
+		mark, _ = markPrice(m.book)
+		index = m.book.mark.inputs.Index
+	})
+
+	accountsMu.Lock()
+	contract, ok := perpetuals[m.symbol]
+	response := FundingResponse{Symbol: m.symbol, Events: make([]FundingEvent, 0)}
+	if ok {
+		response.Interval, response.NextAt = contract.interval.String(), contract.nextAt
+		for _, event := range fundingEvents {
+			if event.Symbol == m.symbol {
+				response.Events = append(response.Events, event)
+			}
+		}
+	}
+	accountsMu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusBadRequest, ErrCodeNotPerpetual, "Not a perpetual",
+			"symbol '"+m.symbol+"' is not traded as a perpetual swap")
+		return
+	}
+	if mark > 0 && index > 0 {
+		rate := fundingRate(mark, index)
+		response.PredictedRate = &rate
+	}
+	response.Count = len(response.Events)
+	json.NewEncoder(w).Encode(response)
+}
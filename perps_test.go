@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// listPerpetual makes a symbol a perpetual that pays funding hourly
+func listPerpetual(symbol string) {
+	accountsMu.Lock()
+	defer accountsMu.Unlock()
+	perpetuals[symbol] = &perpetualContract{interval: time.Hour, nextAt: engineClock.Now().Add(time.Hour)}
+}
+
+func TestPosition_Apply(t *testing.T) {
+	cases := []struct {
+		name     string
+		start    Position
+		delta    int
+		price    float64
+		quantity int
+		entry    float64
+		realized float64
+	}{
+		{"open long", Position{}, 2, 100, 2, 100, 0},
+		{"add averages the entry", Position{Quantity: 2, EntryPrice: 100}, 2, 110, 4, 105, 0},
+		{"reduce long", Position{Quantity: 4, EntryPrice: 100}, -1, 110, 3, 100, 10},
+		{"reduce short", Position{Quantity: -4, EntryPrice: 100}, 1, 110, -3, 100, -10},
+		{"close", Position{Quantity: 2, EntryPrice: 100}, -2, 90, 0, 0, -20},
+		{"flip opens at the price", Position{Quantity: 1, EntryPrice: 100}, -3, 120, -2, 120, 20},
+	}
+	for _, tc := range cases {
+		position := tc.start
+		realized := position.apply(tc.delta, tc.price)
+		if position.Quantity != tc.quantity || position.EntryPrice != tc.entry || realized != tc.realized || position.RealizedPnL != tc.realized {
+			t.Errorf("%s: expected %d at %g realizing %g, got %+v realizing %g", tc.name, tc.quantity, tc.entry, tc.realized, position, realized)
+		}
+	}
+}
+
+func TestPerpetualAsset(t *testing.T) {
+	for symbol, asset := range map[string]string{"BTC-PERP": "USD", "BTC/PERP": "USD", "BTC-USDT-PERP": "USDT", "ETH-USDC": "USDC"} {
+		if got := perpetualAsset(symbol); got != asset {
+			t.Errorf("%s: expected %s, got %s", symbol, asset, got)
+		}
+	}
+}
+
+func TestPerpetuals_TradesMovePositionsAndPayFunding(t *testing.T) {
+	setupTest()
+	resetSymbols([]string{"BTC-PERP"})
+	listPerpetual("BTC-PERP")
+	for _, id := range []string{"alice", "bob", "carol"} {
+		openFunded(t, id, map[string]float64{"USD": 1000})
+	}
+
+	m, _ := matcherFor("BTC-PERP")
+	placeOn(m, Order{ID: "ask-1", Symbol: "BTC-PERP", Side: SideSell, Price: 100.0, Quantity: 2, Owner: "bob"})
+	placeOn(m, Order{ID: "buy-1", Symbol: "BTC-PERP", Side: SideBuy, Price: 100.0, Quantity: 2, Owner: "alice"})
+	placeOn(m, Order{ID: "ask-2", Symbol: "BTC-PERP", Side: SideSell, Price: 110.0, Quantity: 1, Owner: "alice"})
+	placeOn(m, Order{ID: "buy-2", Symbol: "BTC-PERP", Side: SideBuy, Price: 110.0, Quantity: 1, Owner: "carol"})
+
+	alice, _ := lookupAccount("alice")
+	if alice.Balances["USD"] != 1010 || alice.Balances["BTC"] != 0 {
+		t.Errorf("Expected alice to realize 10 USD without holding BTC, got %+v", alice.Balances)
+	}
+	list, _ := accountPositions("alice")
+	if len(list) != 1 || list[0].Quantity != 1 || list[0].EntryPrice != 100 || list[0].RealizedPnL != 10 || list[0].UnrealizedPnL != 10 {
+		t.Errorf("Expected alice long 1 at 100, marked at 110, got %+v", list)
+	}
+	if list, _ := accountPositions("bob"); len(list) != 1 || list[0].Quantity != -2 || list[0].UnrealizedPnL != -20 {
+		t.Errorf("Expected bob short 2, got %+v", list)
+	}
+
+	// Without an index there is nothing to fund against
+	var paid bool
+	m.do(func() { _, paid = payFunding("BTC-PERP", m.book) })
+	if paid {
+		t.Fatal("Expected no funding without an index price")
+	}
+	setIndexPrice(m, 100.0, "push")
+
+	// The mark of 110 is 10% over the index, which the cap brings to 0.75%
+	var event FundingEvent
+	m.do(func() { event, _ = payFunding("BTC-PERP", m.book) })
+	if event.Rate != 0.0075 || event.MarkPrice != 110 || event.Positions != 3 || !approxEqual(event.Paid, 1.65) {
+		t.Errorf("Unexpected funding event %+v", event)
+	}
+	for id, balance := range map[string]float64{"alice": 1009.175, "bob": 1001.65, "carol": 999.175} {
+		if account, _ := lookupAccount(id); !approxEqual(account.Balances["USD"], balance) {
+			t.Errorf("%s: expected %g USD, got %+v", id, balance, account.Balances)
+		}
+	}
+	if list, _ := accountPositions("bob"); !approxEqual(list[0].Funding, 1.65) {
+		t.Errorf("Expected bob to have received 1.65 in funding, got %+v", list)
+	}
+
+	accountsMu.Lock()
+	check := checkLedgerLocked()
+	accountsMu.Unlock()
+	if !check.Balanced {
+		t.Errorf("Expected a balanced ledger, got %+v", check)
+	}
+}
+
+func TestFundingHandlers(t *testing.T) {
+	setupTest()
+	resetSymbols([]string{"BTC-PERP", "BTC-USD"})
+	listPerpetual("BTC-PERP")
+	openFunded(t, "alice", nil)
+
+	for path, status := range map[string]int{
+		"/api/v1/funding?symbol=BTC-PERP":   http.StatusOK,
+		"/api/v1/funding?symbol=BTC-USD":    http.StatusBadRequest,
+		"/api/v1/funding?symbol=XYZ":        http.StatusNotFound,
+		"/api/v1/accounts/alice/positions":  http.StatusOK,
+		"/api/v1/accounts/nobody/positions": http.StatusNotFound,
+	} {
+		if result := serve(HTTPConfig{}, httptest.NewRequest("GET", path, nil)); result.Code != status {
+			t.Errorf("%s: expected %d, got %d", path, status, result.Code)
+		}
+	}
+
+	m, _ := matcherFor("BTC-PERP")
+	m.do(func() { m.book.lastPrice = 99.0 })
+	setIndexPrice(m, 100.0, "push")
+	var funding FundingResponse
+	json.NewDecoder(serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/funding?symbol=BTC-PERP", nil)).Body).Decode(&funding)
+	if funding.Interval != "1h0m0s" || funding.PredictedRate == nil || *funding.PredictedRate != -0.0075 || funding.Count != 0 {
+		t.Errorf("Unexpected funding %+v", funding)
+	}
+
+	var symbols SymbolsResponse
+	json.NewDecoder(serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/symbols", nil)).Body).Decode(&symbols)
+	if len(symbols.Perpetuals) != 1 || symbols.Perpetuals[0] != "BTC-PERP" {
+		t.Errorf("Expected BTC-PERP listed as a perpetual, got %+v", symbols)
+	}
+}
+
+func TestLoadConfig_Perpetuals(t *testing.T) {
+	cfg, err := loadConfig([]string{"-symbols", "BTC-PERP", "-perpetuals", "btc-perp=8h", "-funding-rate-cap", "0.01"})
+	if err != nil || cfg.Perpetuals.Funding["BTC-PERP"] != 8*time.Hour || cfg.Perpetuals.RateCap != 0.01 {
+		t.Errorf("Unexpected perpetual config %+v (%v)", cfg.Perpetuals, err)
+	}
+	for _, args := range [][]string{
+		{"-perpetuals", "DEFAULT=soon"},
+		{"-perpetuals", "ETH-PERP=8h"},
+		{"-funding-rate-cap", "0"},
+	} {
+		if _, err := loadConfig(args); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// TradingPhase is where a symbol is in its trading day
+type TradingPhase string
+
+const (
+	// PhaseClosed takes no orders
+	PhaseClosed TradingPhase = "closed"
+	// PhasePreOpen collects orders for the auction that opens continuous trading
+	PhasePreOpen TradingPhase = "pre_open"
+	// PhaseContinuous matches every order the moment it arrives
+	PhaseContinuous TradingPhase = "continuous"
+	// PhaseAuction collects orders for the auction that ends a volatility
+	// interruption
+	PhaseAuction TradingPhase = "auction"
+	// PhaseHalted takes no orders until trading resumes
+	PhaseHalted TradingPhase = "halted"
+	// PhasePostClose only takes orders that rest without trading, for the
+	// next session
+	PhasePostClose TradingPhase = "post_close"
+)
+
+// phaseTransitions lists the phases each phase may move to
+var phaseTransitions = map[TradingPhase][]TradingPhase{
+	PhaseClosed:     {PhasePreOpen, PhaseContinuous},
+	PhasePreOpen:    {PhaseContinuous, PhaseHalted, PhaseClosed},
+	PhaseContinuous: {PhaseAuction, PhaseHalted, PhasePostClose, PhaseClosed},
+	PhaseAuction:    {PhaseContinuous, PhaseHalted, PhasePostClose, PhaseClosed},
+	PhaseHalted:     {PhaseContinuous, PhasePostClose, PhaseClosed},
+	PhasePostClose:  {PhaseClosed, PhasePreOpen},
+}
+
+// scheduledPhases are the phases a trading calendar may name; auctions and
+// halts only follow from fast price moves
+var scheduledPhases = []TradingPhase{PhaseClosed, PhasePreOpen, PhaseContinuous, PhasePostClose}
+
+// holdsOrders reports whether orders wait for an auction in a phase rather
+// than match
+func holdsOrders(phase TradingPhase) bool {
+	return phase == PhasePreOpen || phase == PhaseAuction || phase == PhaseHalted
+}
+
+// PhaseChange is a phase a symbol's calendar moves it to, and when
+type PhaseChange struct {
+	Phase TradingPhase `json:"phase"`
+	At    time.Time    `json:"at"`
+}
+
+// MarketStatus is a symbol's trading phase, why it is in it and what its
+// calendar has next
+type MarketStatus struct {
+	Symbol string       `json:"symbol"`
+	Phase  TradingPhase `json:"phase"`
+	// Calendar is the trading calendar the symbol follows, if any
+	Calendar string `json:"calendar,omitempty"`
+	// Since is when the symbol entered the phase, and Until when it is due
+	// to end
+	Since  *time.Time `json:"since,omitempty"`
+	Until  *time.Time `json:"until,omitempty"`
+	Reason string     `json:"reason,omitempty"`
+	// Price is the trade that interrupted trading and Reference the earlier
+	// price in the window it moved too far from
+	Price     float64 `json:"price,omitempty"`
+	Reference float64 `json:"reference,omitempty"`
+	// Auction is the auction that opened or reopened continuous trading
+	Auction *AuctionResult `json:"auction,omitempty"`
+	Next    *PhaseChange   `json:"next,omitempty"`
+}
+
+// phaseCheckInterval is how often symbols are checked for a scheduled phase
+// change or the end of a volatility pause
+const phaseCheckInterval = 100 * time.Millisecond
+
+// phase returns the book's trading phase; a book no calendar or guard has
+// touched trades continuously
+func (book *OrderBook) phase() TradingPhase {
+	if book.status.Phase == "" {
+		return PhaseContinuous
+	}
+	return book.status.Phase
+}
+
+// holding reports whether the book's orders wait for an auction rather than
+// match
+func (book *OrderBook) holding() bool {
+	return holdsOrders(book.phase())
+}
+
+// transitionPhase moves a book to the phase in status if its current phase
+// may move there. Staying in the same phase is a no-op. It must run on the
+// book's matcher.
+func transitionPhase(book *OrderBook, status MarketStatus) error {
+	from := book.phase()
+	if from == status.Phase {
+		return nil
+	}
+	if !slices.Contains(phaseTransitions[from], status.Phase) {
+		return fmt.Errorf("invalid phase transition for %s: %s -> %s", status.Symbol, from, status.Phase)
+	}
+	enterPhase(book, status)
+	return nil
+}
+
+// enterPhase puts a book in a phase and announces it. Orders start waiting
+// when the phase holds them. When it stops holding them, continuous trading
+// opens with an auction of what waited, and any other phase cancels them.
+// It must run on the book's matcher.
+func enterPhase(book *OrderBook, status MarketStatus) {
+	now := engineClock.Now()
+	from := book.phase()
+	status.Since = &now
+	book.status = status
+	book.volatility.resumeAt = time.Time{}
+	drawn := book.uncross
+	book.uncross = randomUncross{}
+
+	switch {
+	case holdsOrders(status.Phase):
+		if book.auction == nil {
+			book.auction, book.phaseAuction = &batchAuction{nextAt: now}, true
+		}
+		if status.Until != nil && book.phaseAuction {
+			book.auction.interval, book.auction.nextAt = status.Until.Sub(now), *status.Until
+		}
+	case holdsOrders(from) && book.auction != nil:
+		if status.Phase == PhaseContinuous {
+			result := runBatchAuction(book)
+			book.status.Auction = &result
+			if !drawn.at.IsZero() {
+				recordUncross(status.Symbol, drawn, now)
+			}
+		} else {
+			cancelWaiting(book)
+		}
+		if book.phaseAuction {
+			book.auction, book.phaseAuction = nil, false
+		}
+		repricePegs(book)
+	}
+
+	feed.status(book.status)
+	log.Printf("phases: %s %s -> %s, %s", status.Symbol, from, status.Phase, status.Reason)
+}
+
+// cancelWaiting cancels the orders waiting for an auction that will not run
+func cancelWaiting(book *OrderBook) {
+	for i := range book.auction.pending {
+		order := &book.auction.pending[i]
+		if err := transitionOrder(order, OrderStatusPendingCancel, "auction not held"); err != nil {
+			logTransitionError(err)
+		}
+		if err := transitionOrder(order, OrderStatusCancelled, "session ended before the auction"); err != nil {
+			logTransitionError(err)
+		}
+	}
+	book.auction.pending = book.auction.pending[:0]
+}
+
+// followSchedule moves a book into the phase its calendar has reached. It
+// only acts when the calendar moves on, so an interruption in between runs
+// its course, and an auction opening continuous trading waits out its
+// uncross window. It must run on the book's matcher.
+func followSchedule(book *OrderBook, symbol string, now time.Time) {
+	calendar := calendarFor(symbol)
+	if calendar == nil {
+		return
+	}
+	scheduled, next := calendar.at(now)
+	if scheduled.Phase == book.scheduled {
+		return
+	}
+	first := book.scheduled == ""
+	if !first && scheduled.Phase == PhaseContinuous && !uncrossDue(book, scheduledEnd(book, now), now) {
+		return
+	}
+	book.scheduled = scheduled.Phase
+
+	status := MarketStatus{Symbol: symbol, Phase: scheduled.Phase, Calendar: calendar.Name, Reason: scheduled.reason}
+	if status.Reason == "" {
+		status.Reason = "trading calendar"
+	}
+	if !next.At.IsZero() {
+		status.Until = &next.At
+	}
+	if first {
+		// The book starts in whatever phase the calendar is in
+		enterPhase(book, status)
+		return
+	}
+	if err := transitionPhase(book, status); err != nil {
+		log.Printf("phases: %v", err)
+	}
+}
+
+// scheduledEnd is when the book's current phase was due to end, or now if it
+// had no end set
+func scheduledEnd(book *OrderBook, now time.Time) time.Time {
+	if book.status.Until != nil {
+		return *book.status.Until
+	}
+	return now
+}
+
+// advancePhase moves a book on to the phase its calendar or the end of a
+// volatility pause calls for. It must run on the book's matcher.
+func advancePhase(book *OrderBook, symbol string, now time.Time) {
+	followSchedule(book, symbol, now)
+	reopenIfDue(book, now)
+}
+
+// phaseRefusal returns the code an order is refused with in the book's
+// phase, or an empty code if the phase takes it. After the close, only
+// limit orders and stops that would rest without trading are taken.
+func phaseRefusal(book *OrderBook, order Order) ErrorCode {
+	switch book.phase() {
+	case PhaseClosed:
+		return ErrCodeMarketClosed
+	case PhaseHalted:
+		return ErrCodeTradingHalted
+	case PhasePostClose:
+		if order.Type == OrderTypeMarket || order.Type == OrderTypePegged || order.TimeInForce == TimeInForceIOC ||
+			(order.Type != OrderTypeTrailingStop && availableLiquidity(book, order, 1) > 0) {
+			return ErrCodeMarketClosed
+		}
+	}
+	return ""
+}
+
+// startPhases puts every symbol in its calendar's phase, then keeps them
+// following their calendars and the volatility guard until ctx is done. On a
+// virtual clock the simulation moves them instead.
+func startPhases(ctx context.Context) {
+	if len(calendars) == 0 && defaultCalendar == nil && volatility.Move <= 0 {
+		return
+	}
+	for _, m := range allMatchers() {
+		m.do(func() { advancePhase(m.book, m.symbol, engineClock.Now()) })
+	}
+	if simulatedClock == nil {
+		go runPhases(ctx)
+	}
+}
+
+// runPhases advances every symbol's phase on each tick. A standby leaves
+// this to its primary.
+func runPhases(ctx context.Context) {
+	ticker := time.NewTicker(phaseCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if standby.running.Load() {
+				continue
+			}
+			for _, m := range allMatchers() {
+				m.do(func() { advancePhase(m.book, m.symbol, engineClock.Now()) })
+			}
+		}
+	}
+}
+
+// marketStatus returns the matcher's trading phase and its calendar's next
+// change
+func (m *matcher) marketStatus() MarketStatus {
+	var status MarketStatus
+	now := engineClock.Now()
+	m.do(func() {
+		advancePhase(m.book, m.symbol, now)
+		status = m.book.status
+	})
+	if status.Phase == "" {
+		status = MarketStatus{Symbol: m.symbol, Phase: PhaseContinuous}
+	}
+	if calendar := calendarFor(m.symbol); calendar != nil {
+		if _, next := calendar.at(now); !next.At.IsZero() {
+			status.Next = &next
+		}
+	}
+	return status
+}
+
+// getMarketStatusHandler reports a symbol's trading phase
+func getMarketStatusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	m, ok := matcherFor(r.URL.Query().Get("symbol"))
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrCodeUnknownSymbol, "Unknown symbol",
+			"symbol '"+r.URL.Query().Get("symbol")+"' is not traded here")
+		return
+	}
+	json.NewEncoder(w).Encode(m.marketStatus())
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTradingSchedule_MovesThroughTheDay(t *testing.T) {
+	setupTest()
+	clock := useDeterministicEngine(t, time.Date(2026, 1, 2, 7, 0, 0, 0, time.UTC))
+	schedule, err := parseTradingSchedule("08:00=pre_open,09:30=continuous,16:00=post_close,18:00=closed", "UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	configureCalendars(schedule)
+	m, _ := matcherFor("")
+
+	if refused := processOn(m, Order{ID: "early", Symbol: "DEFAULT", Side: SideBuy, Price: 100.0, Quantity: 1}); refused.RejectReason != ErrCodeMarketClosed {
+		t.Errorf("Expected orders refused before the pre-open, got %+v", refused)
+	}
+	status := getMarketStatus(t)
+	if status.Phase != PhaseClosed || status.Next == nil || status.Next.Phase != PhasePreOpen || !status.Next.At.Equal(time.Date(2026, 1, 2, 8, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Expected closed until the 08:00 pre-open, got %+v", status)
+	}
+
+	// The pre-open collects orders for the opening auction
+	clock.Advance(time.Hour)
+	processOn(m, Order{ID: "bid", Symbol: "DEFAULT", Side: SideBuy, Price: 101.0, Quantity: 2})
+	processOn(m, Order{ID: "ask", Symbol: "DEFAULT", Side: SideSell, Price: 99.0, Quantity: 1})
+	if trades := tradeStore.List(""); len(trades) != 0 {
+		t.Fatalf("Expected nothing to trade in the pre-open, got %+v", trades)
+	}
+	if status := getMarketStatus(t); status.Phase != PhasePreOpen || !status.Until.Equal(time.Date(2026, 1, 2, 9, 30, 0, 0, time.UTC)) {
+		t.Fatalf("Expected the pre-open until 09:30, got %+v", status)
+	}
+
+	clock.Advance(90 * time.Minute)
+	status = getMarketStatus(t)
+	if status.Phase != PhaseContinuous || status.Auction == nil || status.Auction.Volume != 1 || status.Auction.Orders != 2 {
+		t.Fatalf("Expected continuous trading opened by an auction of 1, got %+v", status)
+	}
+	if auction := m.auctionStatus(); auction.Mode != MatchingContinuous {
+		t.Errorf("Expected continuous matching after the open, got %+v", auction)
+	}
+
+	// After the close only orders that would rest are taken
+	clock.Advance(390 * time.Minute)
+	if refused := processOn(m, Order{ID: "late-sell", Symbol: "DEFAULT", Side: SideSell, Price: 100.0, Quantity: 1}); refused.RejectReason != ErrCodeMarketClosed {
+		t.Errorf("Expected an order that would trade refused after the close, got %+v", refused)
+	}
+	if rested := processOn(m, Order{ID: "late-ask", Symbol: "DEFAULT", Side: SideSell, Price: 105.0, Quantity: 1}); rested.Status != OrderStatusPending {
+		t.Errorf("Expected an order that rests taken after the close, got %+v", rested)
+	}
+
+	clock.Advance(2 * time.Hour)
+	if status := getMarketStatus(t); status.Phase != PhaseClosed {
+		t.Fatalf("Expected the symbol closed at 18:00, got %+v", status)
+	}
+	if order, code := cancelOnAnySymbol(context.Background(), "", "late-ask"); code != "" || order.Status != OrderStatusCancelled {
+		t.Errorf("Expected cancels taken while closed, got %+v (%s)", order, code)
+	}
+}
+
+func TestTransitionPhase_FollowsTheStateMachine(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+
+	var errs []error
+	m.do(func() {
+		processOrder(Order{ID: "bid", Symbol: "DEFAULT", Side: SideBuy, Price: 100.0, Quantity: 1, Status: OrderStatusPending, CreatedAt: engineClock.Now()})
+		for _, phase := range []TradingPhase{PhasePostClose, PhasePreOpen, PhasePostClose, PhaseClosed} {
+			errs = append(errs, transitionPhase(m.book, MarketStatus{Symbol: m.symbol, Phase: phase}))
+		}
+	})
+	if errs[0] != nil || errs[1] != nil {
+		t.Fatalf("Expected continuous to post-close to pre-open, got %v", errs)
+	}
+	if errs[2] == nil || errs[3] != nil {
+		t.Errorf("Expected the pre-open unable to skip to the post-close but able to close, got %v", errs)
+	}
+	if snapshot := m.depthSnapshot(0); len(snapshot.Bids) != 1 {
+		t.Errorf("Expected the resting bid kept through the phases, got %+v", snapshot)
+	}
+}
+
+func TestParseTradingSchedule(t *testing.T) {
+	schedule, err := parseTradingSchedule("09:00=continuous, 17:30=closed", "UTC")
+	if err != nil || len(schedule) != 1 || len(schedule[0].Sessions) != 2 || schedule[0].Sessions[1].Offset != 17*time.Hour+30*time.Minute {
+		t.Fatalf("Unexpected schedule %+v (%v)", schedule, err)
+	}
+	phase, next := schedule[0].at(time.Date(2026, 1, 2, 20, 0, 0, 0, time.UTC))
+	if phase.Phase != PhaseClosed || next.Phase != PhaseContinuous || !next.At.Equal(time.Date(2026, 1, 3, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected closed until 09:00 the next day, got %s and %+v", phase.Phase, next)
+	}
+
+	for _, list := range []string{"09:00", "9am=continuous", "09:00=halted", "17:00=closed,09:00=continuous", "09:00=continuous,17:00=pre_open"} {
+		if _, err := parseTradingSchedule(list, "UTC"); err == nil {
+			t.Errorf("Expected an error for %q", list)
+		}
+	}
+	if _, err := parseTradingSchedule("", "Nowhere/Special"); err == nil {
+		t.Error("Expected an error for an unknown time zone")
+	}
+	if schedule, err := parseTradingSchedule("", "UTC"); err != nil || len(schedule) != 0 {
+		t.Errorf("Expected no schedule by default, got %+v (%v)", schedule, err)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PortfolioBalance is one of an account's balances valued in the portfolio's asset
+type PortfolioBalance struct {
+	Asset   string  `json:"asset"`
+	Balance float64 `json:"balance"`
+	// Price is one unit of the asset in the portfolio's asset, or zero when
+	// no symbol prices it
+	Price float64 `json:"price"`
+	Value float64 `json:"value"`
+}
+
+// PortfolioResponse values everything an account holds in one asset
+type PortfolioResponse struct {
+	AccountID string `json:"account_id"`
+	// Asset is what every value in the portfolio is given in
+	Asset     string             `json:"asset"`
+	Balances  []PortfolioBalance `json:"balances"`
+	Positions []Position         `json:"positions"`
+	// BalanceValue is the balances' value and UnrealizedPnL the open
+	// positions' profit or loss at their marks, which together are the equity
+	BalanceValue      float64 `json:"balance_value"`
+	UnrealizedPnL     float64 `json:"unrealized_pnl"`
+	Equity            float64 `json:"equity"`
+	InitialMargin     float64 `json:"initial_margin"`
+	MaintenanceMargin float64 `json:"maintenance_margin"`
+	// MarginUsage is the initial margin as a fraction of the equity, and zero
+	// when the account has no equity
+	MarginUsage  float64      `json:"margin_usage"`
+	MarginStatus MarginStatus `json:"margin_status"`
+	// Unpriced lists the assets no symbol could value, which are left out of
+	// the totals
+	Unpriced []string  `json:"unpriced,omitempty"`
+	At       time.Time `json:"at"`
+}
+
+// portfolioPrice is the price a portfolio values a book's symbol at: the mark
+// for a perpetual, and otherwise the mid, or the mark when one side is empty.
+// It must run on the book's matcher.
+func portfolioPrice(symbol string, book *OrderBook) float64 {
+	bid, bidOK := book.bids.first()
+	ask, askOK := book.asks.first()
+	if !isPerpetual(symbol) && bidOK && askOK {
+		return (bid.Price + ask.Price) / 2
+	}
+	price, _ := markPrice(book)
+	return price
+}
+
+// conversionRateLocked is the price of one unit of an asset in another, from
+// a spot symbol trading one against the other either way round, or from an
+// option's price in its settlement asset. It returns zero when nothing prices
+// the asset. It must be called with accountsMu held.
+func conversionRateLocked(asset, into string, prices map[string]float64) float64 {
+	if asset == into {
+		return 1
+	}
+	if contract, ok := options[asset]; ok && contract.Asset != asset {
+		return prices[asset] * conversionRateLocked(contract.Asset, into, prices)
+	}
+	for symbol, price := range prices {
+		if _, perpetual := perpetuals[symbol]; price <= 0 || perpetual || options[symbol] != nil {
+			continue
+		}
+		switch base, quote := symbolAssets(symbol); {
+		case base == asset && quote == into:
+			return price
+		case base == into && quote == asset:
+			return 1 / price
+		}
+	}
+	return 0
+}
+
+// accountPortfolio values an account's balances and positions in an asset, or
+// returns false if there is no such account
+func accountPortfolio(id, asset string) (PortfolioResponse, bool) {
+	// Prices are read first: the matchers take accountsMu when trades settle
+	prices := make(map[string]float64)
+	for _, m := range allMatchers() {
+		m.do(func() { prices[m.symbol] = portfolioPrice(m.symbol, m.book) })
+	}
+
+	accountsMu.Lock()
+	defer accountsMu.Unlock()
+	if _, ok := accounts[id]; !ok {
+		return PortfolioResponse{}, false
+	}
+
+	response := PortfolioResponse{
+		AccountID: id,
+		Asset:     asset,
+		Balances:  make([]PortfolioBalance, 0, len(ledgerBalances[id])),
+		Positions: make([]Position, 0, len(positions[id])),
+		At:        engineClock.Now(),
+	}
+	unpriced := make(map[string]bool)
+	for held, balance := range ledgerBalances[id] {
+		if balance == 0 {
+			continue
+		}
+		entry := PortfolioBalance{Asset: held, Balance: balance, Price: conversionRateLocked(held, asset, prices)}
+		if entry.Price == 0 {
+			unpriced[held] = true
+		}
+		entry.Value = balance * entry.Price
+		response.BalanceValue += entry.Value
+		response.Balances = append(response.Balances, entry)
+	}
+	sort.Slice(response.Balances, func(i, j int) bool { return response.Balances[i].Asset < response.Balances[j].Asset })
+
+	for _, position := range positions[id] {
+		held := *position
+		if mark := prices[held.Symbol]; mark > 0 && held.Quantity != 0 {
+			held.MarkPrice = mark
+			held.UnrealizedPnL = float64(held.Quantity) * (mark - held.EntryPrice)
+		}
+		if held.UnrealizedPnL != 0 {
+			settlement := perpetualAsset(held.Symbol)
+			if rate := conversionRateLocked(settlement, asset, prices); rate > 0 {
+				response.UnrealizedPnL += held.UnrealizedPnL * rate
+			} else {
+				unpriced[settlement] = true
+			}
+		}
+		response.Positions = append(response.Positions, held)
+	}
+	sort.Slice(response.Positions, func(i, j int) bool { return response.Positions[i].Symbol < response.Positions[j].Symbol })
+	response.Equity = response.BalanceValue + response.UnrealizedPnL
+
+	assets, status := marginLocked(id)
+	response.MarginStatus = status
+	for _, held := range assets {
+		rate := conversionRateLocked(held.Asset, asset, prices)
+		if rate == 0 {
+			unpriced[held.Asset] = true
+		}
+		response.InitialMargin += held.InitialMargin * rate
+		response.MaintenanceMargin += held.MaintenanceMargin * rate
+	}
+	if response.Equity > 0 {
+		response.MarginUsage = response.InitialMargin / response.Equity
+	}
+
+	for held := range unpriced {
+		response.Unpriced = append(response.Unpriced, held)
+	}
+	sort.Strings(response.Unpriced)
+	return response, true
+}
+
+// getPortfolioHandler values the balances and positions of the account named
+// in the path, in the asset query parameter or the default quote asset
+func getPortfolioHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	asset := strings.ToUpper(r.URL.Query().Get("asset"))
+	if asset == "" {
+		asset = defaultQuoteAsset
+	}
+	id := r.PathValue("id")
+	response, ok := accountPortfolio(id, asset)
+	if !ok {
+		writeAccountNotFound(w, id)
+		return
+	}
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPortfolio_ValuesBalancesAndPositions(t *testing.T) {
+	setupTest()
+	resetSymbols([]string{"BTC-USD", "BTC-PERP"})
+	listPerpetual("BTC-PERP")
+	openFunded(t, "alice", map[string]float64{"USD": 1000, "BTC": 2, "DOGE": 5})
+	for _, id := range []string{"bob", "carol", "dave"} {
+		openFunded(t, id, map[string]float64{"USD": 10000})
+	}
+
+	spot, _ := matcherFor("BTC-USD")
+	placeOn(spot, Order{ID: "bid", Symbol: "BTC-USD", Side: SideBuy, Price: 100.0, Quantity: 1, Owner: "mm"})
+	placeOn(spot, Order{ID: "ask", Symbol: "BTC-USD", Side: SideSell, Price: 110.0, Quantity: 1, Owner: "mm"})
+
+	// alice is long 2 from 100, marked at 120 by carol and dave's trade
+	perp, _ := matcherFor("BTC-PERP")
+	placeOn(perp, Order{ID: "perp-ask", Symbol: "BTC-PERP", Side: SideSell, Price: 100.0, Quantity: 2, Owner: "bob"})
+	placeOn(perp, Order{ID: "long", Symbol: "BTC-PERP", Side: SideBuy, Price: 100.0, Quantity: 2, Owner: "alice"})
+	placeOn(perp, Order{ID: "mark-ask", Symbol: "BTC-PERP", Side: SideSell, Price: 120.0, Quantity: 1, Owner: "carol"})
+	placeOn(perp, Order{ID: "mark-bid", Symbol: "BTC-PERP", Side: SideBuy, Price: 120.0, Quantity: 1, Owner: "dave"})
+
+	var response PortfolioResponse
+	json.NewDecoder(serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/accounts/alice/portfolio", nil)).Body).Decode(&response)
+	if response.Asset != "USD" || len(response.Balances) != 3 || response.Balances[0].Asset != "BTC" || response.Balances[0].Value != 210 {
+		t.Fatalf("Expected BTC valued at the 105 mid, got %+v", response.Balances)
+	}
+	if len(response.Unpriced) != 1 || response.Unpriced[0] != "DOGE" || response.BalanceValue != 1210 {
+		t.Errorf("Expected DOGE left out of the balance value, got %+v", response)
+	}
+	if len(response.Positions) != 1 || response.Positions[0].MarkPrice != 120 || response.UnrealizedPnL != 40 || response.Equity != 1250 {
+		t.Errorf("Expected the position marked at 120, got %+v", response)
+	}
+	if response.InitialMargin != 24 || !approxEqual(response.MarginUsage, 24.0/1250) || response.MarginStatus != MarginHealthy {
+		t.Errorf("Unexpected margin usage %+v", response)
+	}
+
+	json.NewDecoder(serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/accounts/alice/portfolio?asset=btc", nil)).Body).Decode(&response)
+	if response.Asset != "BTC" || !approxEqual(response.Equity, 1250.0/105) {
+		t.Errorf("Expected the equity in BTC through the inverse of the mid, got %+v", response)
+	}
+
+	if result := serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/accounts/nobody/portfolio", nil)); result.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown account, got %d", result.Code)
+	}
+}
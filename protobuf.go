@@ -0,0 +1,603 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// protobufContentType is sent with every protobuf body; application/protobuf
+// is accepted as well
+const protobufContentType = "application/x-protobuf"
+
+// protoMessage is a body that can also be sent as protobuf, laid out as the
+// message of the same name in protobufSchema
+type protoMessage interface {
+	appendProto(b []byte) []byte
+}
+
+// protoDecoder is a request body that can also be read from protobuf
+type protoDecoder interface {
+	decodeProto(data []byte) error
+}
+
+// Protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// isProtobuf reports whether a media type names protobuf
+func isProtobuf(mediaType string) bool {
+	name, _, _ := strings.Cut(mediaType, ";")
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "application/x-protobuf", "application/protobuf":
+		return true
+	}
+	return false
+}
+
+// wantsProtobuf reports whether the Accept header prefers protobuf to JSON.
+// The higher quality wins, and of two equal ones the first listed.
+func wantsProtobuf(r *http.Request) bool {
+	best, bestQuality := "", 0.0
+	for _, entry := range strings.Split(r.Header.Get("Accept"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(entry), ";")
+		quality := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if value, ok := strings.CutPrefix(strings.ReplaceAll(param, " ", ""), "q="); ok {
+				quality, _ = strconv.ParseFloat(value, 64)
+			}
+		}
+
+		var kind string
+		switch name = strings.ToLower(strings.TrimSpace(name)); {
+		case isProtobuf(name):
+			kind = "protobuf"
+		case name == "application/json", name == "application/*", name == "*/*":
+			kind = "json"
+		default:
+			continue
+		}
+		if quality > bestQuality {
+			best, bestQuality = kind, quality
+		}
+	}
+	return best == "protobuf"
+}
+
+// writeBody encodes a success body as protobuf when the client asks for it
+// and the body supports it, and as JSON otherwise, by hand for the bodies
+// that can
+func writeBody(w http.ResponseWriter, r *http.Request, v interface{}) {
+	w.Header().Add("Vary", "Accept")
+	if message, ok := v.(protoMessage); ok && wantsProtobuf(r) {
+		w.Header().Set("Content-Type", protobufContentType)
+		w.Write(message.appendProto(nil))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if appender, ok := v.(jsonAppender); ok {
+		buf := jsonBuffers.Get().(*[]byte)
+		*buf = append(appender.appendJSON((*buf)[:0]), '\n')
+		w.Write(*buf)
+		jsonBuffers.Put(buf)
+		return
+	}
+	json.NewEncoder(w).Encode(v)
+}
+
+// decodeProtoRequest reads a protobuf request body for decodeRequest
+func decodeProtoRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	decoder, ok := v.(protoDecoder)
+	if !ok {
+		writeError(w, http.StatusUnsupportedMediaType, ErrCodeUnsupportedType, "Unsupported media type",
+			"this endpoint only accepts application/json")
+		return false
+	}
+	data, err := io.ReadAll(r.Body)
+	if err == nil {
+		err = decoder.decodeProto(data)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidProtobuf, "Request body is not a valid protobuf message", err.Error())
+		return false
+	}
+	return true
+}
+
+func appendProtoTag(b []byte, field, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(field)<<3|uint64(wireType))
+}
+
+// appendProtoInt appends an int64 field, leaving out zero as proto3 does
+func appendProtoInt(b []byte, field int, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	return binary.AppendUvarint(appendProtoTag(b, field, wireVarint), uint64(v))
+}
+
+func appendProtoBool(b []byte, field int, v bool) []byte {
+	if !v {
+		return b
+	}
+	return appendProtoInt(b, field, 1)
+}
+
+func appendProtoDouble(b []byte, field int, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	return binary.LittleEndian.AppendUint64(appendProtoTag(b, field, wireFixed64), math.Float64bits(v))
+}
+
+// appendProtoOptionalDouble appends an optional double, which is sent even when zero
+func appendProtoOptionalDouble(b []byte, field int, v *float64) []byte {
+	if v == nil {
+		return b
+	}
+	return binary.LittleEndian.AppendUint64(appendProtoTag(b, field, wireFixed64), math.Float64bits(*v))
+}
+
+func appendProtoString(b []byte, field int, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = binary.AppendUvarint(appendProtoTag(b, field, wireBytes), uint64(len(v)))
+	return append(b, v...)
+}
+
+// appendProtoTime appends a timestamp as nanoseconds since the Unix epoch;
+// the zero time is left out
+func appendProtoTime(b []byte, field int, t time.Time) []byte {
+	if t.IsZero() {
+		return b
+	}
+	return appendProtoInt(b, field, t.UnixNano())
+}
+
+// appendProtoOptionalTime appends an optional timestamp
+func appendProtoOptionalTime(b []byte, field int, t *time.Time) []byte {
+	if t == nil {
+		return b
+	}
+	return binary.AppendUvarint(appendProtoTag(b, field, wireVarint), uint64(t.UnixNano()))
+}
+
+// appendProtoMessage appends an embedded message. It is encoded in place and
+// its length prefix moved in front afterwards, so it needs no buffer of its own.
+func appendProtoMessage(b []byte, field int, message protoMessage) []byte {
+	b = appendProtoTag(b, field, wireBytes)
+	start := len(b)
+	b = message.appendProto(b)
+	size := len(b) - start
+	var prefix [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(prefix[:], uint64(size))
+	b = append(b, prefix[:n]...)
+	copy(b[start+n:], b[start:start+size])
+	copy(b[start:], prefix[:n])
+	return b
+}
+
+// protoField is one field read from a protobuf message
+type protoField struct {
+	number   int
+	wireType int
+	varint   uint64
+	bytes    []byte
+}
+
+func (f protoField) int() int64      { return int64(f.varint) }
+func (f protoField) bool() bool      { return f.varint != 0 }
+func (f protoField) double() float64 { return math.Float64frombits(f.varint) }
+func (f protoField) string() string  { return string(f.bytes) }
+func (f protoField) time() time.Time { return time.Unix(0, f.int()).UTC() }
+func (f protoField) doublePtr() *float64 {
+	v := f.double()
+	return &v
+}
+
+// eachProtoField calls fn for every field in a message, in wire order
+func eachProtoField(data []byte, fn func(field protoField) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errors.New("truncated field key")
+		}
+		data = data[n:]
+		field := protoField{number: int(key >> 3), wireType: int(key & 7)}
+
+		switch field.wireType {
+		case wireVarint:
+			field.varint, n = binary.Uvarint(data)
+			if n <= 0 {
+				return fmt.Errorf("field %d: truncated varint", field.number)
+			}
+		case wireFixed64:
+			if len(data) < 8 {
+				return fmt.Errorf("field %d: truncated fixed64", field.number)
+			}
+			field.varint, n = binary.LittleEndian.Uint64(data), 8
+		case wireFixed32:
+			if len(data) < 4 {
+				return fmt.Errorf("field %d: truncated fixed32", field.number)
+			}
+			field.varint, n = uint64(binary.LittleEndian.Uint32(data)), 4
+		case wireBytes:
+			size, m := binary.Uvarint(data)
+			if m <= 0 || uint64(len(data)-m) < size {
+				return fmt.Errorf("field %d: truncated bytes", field.number)
+			}
+			field.bytes, n = data[m:m+int(size)], m+int(size)
+		default:
+			return fmt.Errorf("field %d: unsupported wire type %d", field.number, field.wireType)
+		}
+		data = data[n:]
+
+		if err := fn(field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// placeOrderRequestWire is the wire type of each PlaceOrderRequest field
+var placeOrderRequestWire = map[int]int{
+	1: wireBytes, 2: wireBytes, 3: wireFixed64, 4: wireVarint, 5: wireVarint,
+	6: wireBytes, 7: wireBytes, 8: wireFixed64, 9: wireFixed64, 10: wireFixed64,
+	11: wireBytes, 12: wireVarint, 13: wireVarint, 14: wireBytes, 15: wireFixed64,
+	16: wireBytes, 17: wireFixed64,
+}
+
+func (req *PlaceOrderRequest) decodeProto(data []byte) error {
+	*req = PlaceOrderRequest{}
+	return eachProtoField(data, func(f protoField) error {
+		// Unknown fields are skipped, so newer clients can talk to older servers
+		want, known := placeOrderRequestWire[f.number]
+		if !known {
+			return nil
+		}
+		if f.wireType != want {
+			return fmt.Errorf("field %d: wire type %d, expected %d", f.number, f.wireType, want)
+		}
+
+		switch f.number {
+		case 1:
+			req.Symbol = f.string()
+		case 2:
+			req.Side = Side(f.string())
+		case 3:
+			req.Price = f.double()
+		case 4:
+			req.Quantity = int(f.int())
+		case 5:
+			expiresAt := f.time()
+			req.ExpiresAt = &expiresAt
+		case 6:
+			req.Owner = f.string()
+		case 7:
+			req.Type = OrderType(f.string())
+		case 8:
+			req.TrailAmount = f.double()
+		case 9:
+			req.TrailPercent = f.double()
+		case 10:
+			req.LimitOffset = f.doublePtr()
+		case 11:
+			req.TimeInForce = TimeInForce(f.string())
+		case 12:
+			req.MinQuantity = int(f.int())
+		case 13:
+			req.AllOrNone = f.bool()
+		case 14:
+			req.Peg = PegType(f.string())
+		case 15:
+			req.PegOffset = f.double()
+		case 16:
+			req.TriggerBy = StopTrigger(f.string())
+		case 17:
+			req.QuoteQuantity = f.double()
+		}
+		return nil
+	})
+}
+
+func (req PlaceOrderRequest) appendProto(b []byte) []byte {
+	b = appendProtoString(b, 1, req.Symbol)
+	b = appendProtoString(b, 2, string(req.Side))
+	b = appendProtoDouble(b, 3, req.Price)
+	b = appendProtoInt(b, 4, int64(req.Quantity))
+	b = appendProtoOptionalTime(b, 5, req.ExpiresAt)
+	b = appendProtoString(b, 6, req.Owner)
+	b = appendProtoString(b, 7, string(req.Type))
+	b = appendProtoDouble(b, 8, req.TrailAmount)
+	b = appendProtoDouble(b, 9, req.TrailPercent)
+	b = appendProtoOptionalDouble(b, 10, req.LimitOffset)
+	b = appendProtoString(b, 11, string(req.TimeInForce))
+	b = appendProtoInt(b, 12, int64(req.MinQuantity))
+	b = appendProtoBool(b, 13, req.AllOrNone)
+	b = appendProtoString(b, 14, string(req.Peg))
+	b = appendProtoDouble(b, 15, req.PegOffset)
+	b = appendProtoString(b, 16, string(req.TriggerBy))
+	return appendProtoDouble(b, 17, req.QuoteQuantity)
+}
+
+func (response PlaceOrderResponse) appendProto(b []byte) []byte {
+	b = appendProtoString(b, 1, response.OrderID)
+	b = appendProtoString(b, 2, string(response.Status))
+	for _, trade := range response.Trades {
+		b = appendProtoMessage(b, 3, trade)
+	}
+	if response.Latency != nil {
+		b = appendProtoMessage(b, 4, *response.Latency)
+	}
+	return b
+}
+
+func (order Order) appendProto(b []byte) []byte {
+	b = appendProtoString(b, 1, order.ID)
+	b = appendProtoString(b, 2, order.Symbol)
+	b = appendProtoString(b, 3, string(order.Side))
+	b = appendProtoInt(b, 4, int64(order.Quantity))
+	b = appendProtoDouble(b, 5, order.Price)
+	b = appendProtoString(b, 6, string(order.Status))
+	b = appendProtoTime(b, 7, order.CreatedAt)
+	b = appendProtoOptionalTime(b, 8, order.ExpiresAt)
+	b = appendProtoString(b, 9, order.Owner)
+	b = appendProtoString(b, 10, string(order.RejectReason))
+	b = appendProtoString(b, 11, string(order.Type))
+	b = appendProtoDouble(b, 12, order.TrailAmount)
+	b = appendProtoDouble(b, 13, order.TrailPercent)
+	b = appendProtoOptionalDouble(b, 14, order.LimitOffset)
+	b = appendProtoDouble(b, 15, order.TriggerPrice)
+	b = appendProtoString(b, 16, string(order.Peg))
+	b = appendProtoDouble(b, 17, order.PegOffset)
+	b = appendProtoString(b, 18, string(order.TimeInForce))
+	b = appendProtoInt(b, 19, int64(order.MinQuantity))
+	b = appendProtoBool(b, 20, order.AllOrNone)
+	if !order.Timestamps.IsZero() {
+		b = appendProtoMessage(b, 21, order.Timestamps)
+	}
+	b = appendProtoInt(b, 22, int64(order.FilledQuantity))
+	b = appendProtoInt(b, 23, order.ArrivalSequence)
+	b = appendProtoString(b, 24, string(order.TriggerBy))
+	b = appendProtoDouble(b, 25, order.QuoteQuantity)
+	b = appendProtoString(b, 26, order.BasketID)
+	return appendProtoString(b, 27, order.SpreadOrderID)
+}
+
+func (times OrderTimestamps) appendProto(b []byte) []byte {
+	b = appendProtoTime(b, 1, times.ReceivedAt)
+	b = appendProtoTime(b, 2, times.AcceptedAt)
+	return appendProtoTime(b, 3, times.MatchedAt)
+}
+
+func (trade Trade) appendProto(b []byte) []byte {
+	b = appendProtoString(b, 1, trade.ID)
+	b = appendProtoString(b, 2, trade.Symbol)
+	b = appendProtoString(b, 3, trade.MakerID)
+	b = appendProtoString(b, 4, trade.TakerID)
+	b = appendProtoDouble(b, 5, trade.Price)
+	b = appendProtoInt(b, 6, int64(trade.Quantity))
+	b = appendProtoString(b, 7, trade.Venue)
+	b = appendProtoTime(b, 8, trade.CreatedAt)
+	b = appendProtoString(b, 9, string(trade.AggressorSide))
+	b = appendProtoString(b, 10, string(trade.TickDirection))
+	if trade.Fees != nil {
+		b = appendProtoMessage(b, 11, *trade.Fees)
+	}
+	b = appendProtoString(b, 12, trade.BaseAsset)
+	b = appendProtoDouble(b, 13, trade.BaseQuantity)
+	b = appendProtoString(b, 14, trade.QuoteAsset)
+	return appendProtoDouble(b, 15, trade.QuoteNotional)
+}
+
+func (fees TradeFees) appendProto(b []byte) []byte {
+	b = appendProtoString(b, 1, fees.Asset)
+	b = appendProtoDouble(b, 2, fees.MakerBps)
+	b = appendProtoDouble(b, 3, fees.MakerFee)
+	b = appendProtoDouble(b, 4, fees.TakerBps)
+	return appendProtoDouble(b, 5, fees.TakerFee)
+}
+
+func (latency OrderLatency) appendProto(b []byte) []byte {
+	b = appendProtoString(b, 1, latency.OrderID)
+	b = appendProtoInt(b, 2, int64(latency.Queue))
+	b = appendProtoInt(b, 3, int64(latency.Match))
+	return appendProtoInt(b, 4, int64(latency.Total))
+}
+
+func (response OrdersResponse) appendProto(b []byte) []byte {
+	for _, order := range response.Orders {
+		b = appendProtoMessage(b, 1, order)
+	}
+	return appendProtoInt(b, 2, int64(response.Count))
+}
+
+func (response OrderBookResponse) appendProto(b []byte) []byte {
+	b = appendProtoString(b, 1, response.Symbol)
+	b = appendProtoMessage(b, 2, response.OrderBook)
+	b = appendProtoInt(b, 3, int64(response.BuyCount))
+	return appendProtoInt(b, 4, int64(response.SellCount))
+}
+
+func (book RestingOrders) appendProto(b []byte) []byte {
+	for _, order := range book.BuyOrders {
+		b = appendProtoMessage(b, 1, order)
+	}
+	for _, order := range book.SellOrders {
+		b = appendProtoMessage(b, 2, order)
+	}
+	return b
+}
+
+func (snapshot DepthSnapshot) appendProto(b []byte) []byte {
+	b = appendProtoString(b, 1, snapshot.Symbol)
+	b = appendProtoInt(b, 2, snapshot.Sequence)
+	for _, level := range snapshot.Bids {
+		b = appendProtoMessage(b, 3, level)
+	}
+	for _, level := range snapshot.Asks {
+		b = appendProtoMessage(b, 4, level)
+	}
+	return appendProtoString(b, 5, snapshot.Epoch)
+}
+
+func (level PriceLevel) appendProto(b []byte) []byte {
+	b = appendProtoDouble(b, 1, level.Price)
+	b = appendProtoInt(b, 2, int64(level.Quantity))
+	return appendProtoInt(b, 3, int64(level.Orders))
+}
+
+// protobufSchema describes every message the API sends or accepts as
+// protobuf. It is served at /api/v1/valhalla.proto for generating clients.
+const protobufSchema = `syntax = "proto3";
+
+package valhalla.v1;
+
+// Timestamps are nanoseconds since the Unix epoch, and enums are sent as the
+// same strings the JSON API uses.
+
+message PlaceOrderRequest {
+  string symbol = 1;
+  string side = 2;
+  double price = 3;
+  int64 quantity = 4;
+  optional int64 expires_at_unix_nano = 5;
+  string owner = 6;
+  string type = 7;
+  double trail_amount = 8;
+  double trail_percent = 9;
+  optional double limit_offset = 10;
+  string time_in_force = 11;
+  int64 min_quantity = 12;
+  bool all_or_none = 13;
+  string peg = 14;
+  double peg_offset = 15;
+  string trigger_by = 16;
+  double quote_quantity = 17;
+}
+
+message PlaceOrderResponse {
+  string order_id = 1;
+  string status = 2;
+  repeated Trade trades = 3;
+  OrderLatency latency = 4;
+}
+
+message Order {
+  string id = 1;
+  string symbol = 2;
+  string side = 3;
+  int64 quantity = 4;
+  double price = 5;
+  string status = 6;
+  int64 created_at_unix_nano = 7;
+  optional int64 expires_at_unix_nano = 8;
+  string owner = 9;
+  string reject_reason = 10;
+  string type = 11;
+  double trail_amount = 12;
+  double trail_percent = 13;
+  optional double limit_offset = 14;
+  double trigger_price = 15;
+  string peg = 16;
+  double peg_offset = 17;
+  string time_in_force = 18;
+  int64 min_quantity = 19;
+  bool all_or_none = 20;
+  OrderTimestamps timestamps = 21;
+  int64 filled_quantity = 22;
+  int64 arrival_sequence = 23;
+  string trigger_by = 24;
+  double quote_quantity = 25;
+  string basket_id = 26;
+  string spread_order_id = 27;
+}
+
+message OrderTimestamps {
+  int64 received_at_unix_nano = 1;
+  int64 accepted_at_unix_nano = 2;
+  int64 matched_at_unix_nano = 3;
+}
+
+message Trade {
+  string id = 1;
+  string symbol = 2;
+  string maker_id = 3;
+  string taker_id = 4;
+  double price = 5;
+  int64 quantity = 6;
+  string venue = 7;
+  int64 created_at_unix_nano = 8;
+  string aggressor_side = 9;
+  string tick_direction = 10;
+  TradeFees fees = 11;
+  string base_asset = 12;
+  double base_quantity = 13;
+  string quote_asset = 14;
+  double quote_notional = 15;
+}
+
+message TradeFees {
+  string asset = 1;
+  double maker_bps = 2;
+  double maker_fee = 3;
+  double taker_bps = 4;
+  double taker_fee = 5;
+}
+
+message OrderLatency {
+  string order_id = 1;
+  int64 queue_ns = 2;
+  int64 match_ns = 3;
+  int64 total_ns = 4;
+}
+
+message OrdersResponse {
+  repeated Order orders = 1;
+  int64 count = 2;
+}
+
+message OrderBook {
+  repeated Order buy_orders = 1;
+  repeated Order sell_orders = 2;
+}
+
+message OrderBookResponse {
+  string symbol = 1;
+  OrderBook orderbook = 2;
+  int64 buy_count = 3;
+  int64 sell_count = 4;
+}
+
+message PriceLevel {
+  double price = 1;
+  int64 quantity = 2;
+  int64 orders = 3;
+}
+
+message DepthSnapshot {
+  string symbol = 1;
+  int64 sequence = 2;
+  repeated PriceLevel bids = 3;
+  repeated PriceLevel asks = 4;
+  string epoch = 5;
+}
+`
+
+// protobufSchemaHandler serves protobufSchema
+func protobufSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(protobufSchema))
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// protoFields reads a message into its fields by number
+func protoFields(t *testing.T, data []byte) map[int][]protoField {
+	t.Helper()
+	fields := make(map[int][]protoField)
+	err := eachProtoField(data, func(f protoField) error {
+		fields[f.number] = append(fields[f.number], f)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected a valid protobuf message, got %v", err)
+	}
+	return fields
+}
+
+// postProtobuf places an order with a protobuf body
+func postProtobuf(req PlaceOrderRequest, accept string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest("POST", "/api/v1/orders", bytes.NewReader(req.appendProto(nil)))
+	request.Header.Set("Content-Type", protobufContentType)
+	request.Header.Set("Accept", accept)
+	return serve(HTTPConfig{}, request)
+}
+
+func TestPlaceOrder_SpeaksProtobuf(t *testing.T) {
+	setupTest()
+	offset := 0.0
+	postProtobuf(PlaceOrderRequest{Side: SideSell, Price: 100.0, Quantity: 5, Owner: "maker"}, protobufContentType)
+
+	// A zero limit offset survives, since the field is optional
+	var decoded PlaceOrderRequest
+	sent := PlaceOrderRequest{Side: SideBuy, Price: 100.0, Quantity: 3, LimitOffset: &offset, Owner: "taker"}
+	if err := decoded.decodeProto(sent.appendProto(nil)); err != nil || decoded.LimitOffset == nil || decoded.Owner != "taker" {
+		t.Fatalf("Expected the request to round-trip, got %+v (%v)", decoded, err)
+	}
+
+	response := postProtobuf(PlaceOrderRequest{Side: SideBuy, Price: 100.0, Quantity: 3}, protobufContentType)
+	if response.Code != http.StatusOK || response.Header().Get("Content-Type") != protobufContentType {
+		t.Fatalf("Expected a protobuf response, got %d %q: %s", response.Code, response.Header().Get("Content-Type"), response.Body.String())
+	}
+	fields := protoFields(t, response.Body.Bytes())
+	if fields[2][0].string() != string(OrderStatusFilled) || len(fields[3]) != 1 {
+		t.Fatalf("Expected a filled order with one trade, got %+v", fields)
+	}
+	trade := protoFields(t, fields[3][0].bytes)
+	if trade[5][0].double() != 100.0 || trade[6][0].int() != 3 || trade[4][0].string() != fields[1][0].string() {
+		t.Errorf("Unexpected trade %+v", trade)
+	}
+	if latency := protoFields(t, fields[4][0].bytes); latency[1][0].string() != fields[1][0].string() {
+		t.Errorf("Expected the latency of the placed order, got %+v", latency)
+	}
+
+	// Protobuf requests are validated like JSON ones, and errors stay JSON
+	response = postProtobuf(PlaceOrderRequest{Side: SideBuy, Price: 100.0}, protobufContentType)
+	if result := decodeError(t, response); response.Code != http.StatusBadRequest || result.Error.Code != ErrCodeValidationFailed {
+		t.Errorf("Expected a zero quantity to fail validation, got %d %s", response.Code, result.Error.Code)
+	}
+}
+
+func TestDepthSnapshot_NegotiatesTheEncoding(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "bid-1", Side: SideBuy, Price: 99.0, Quantity: 4})
+	placeOn(m, Order{ID: "bid-2", Side: SideBuy, Price: 98.0, Quantity: 2})
+
+	for accept, want := range map[string]string{
+		"":                                "application/json",
+		"*/*":                             "application/json",
+		"application/x-protobuf":          protobufContentType,
+		"application/protobuf, */*;q=0.1": protobufContentType,
+		"application/json, application/x-protobuf":             "application/json",
+		"application/x-protobuf;q=0.5, application/json;q=0.9": "application/json",
+	} {
+		request := httptest.NewRequest("GET", "/api/v1/depth/snapshot", nil)
+		request.Header.Set("Accept", accept)
+		response := serve(HTTPConfig{}, request)
+		if got := response.Header().Get("Content-Type"); got != want {
+			t.Errorf("Accept %q: expected %s, got %s", accept, want, got)
+		}
+		if !strings.Contains(response.Header().Get("Vary"), "Accept") {
+			t.Errorf("Expected the response to vary on Accept, got %q", response.Header().Get("Vary"))
+		}
+		if want != protobufContentType {
+			var snapshot DepthSnapshot
+			if err := json.NewDecoder(response.Body).Decode(&snapshot); err != nil || len(snapshot.Bids) != 2 {
+				t.Errorf("Accept %q: expected the JSON snapshot, got %+v (%v)", accept, snapshot, err)
+			}
+			continue
+		}
+
+		fields := protoFields(t, response.Body.Bytes())
+		if len(fields[3]) != 2 || len(fields[4]) != 0 {
+			t.Fatalf("Expected two bid levels and no asks, got %+v", fields)
+		}
+		level := protoFields(t, fields[3][0].bytes)
+		if level[1][0].double() != 99.0 || level[2][0].int() != 4 || level[3][0].int() != 1 {
+			t.Errorf("Unexpected best bid %+v", level)
+		}
+	}
+}
+
+func TestProtobuf_EmbedsLargeMessages(t *testing.T) {
+	// Enough orders that the message's length prefix takes several bytes
+	var response OrdersResponse
+	for range 300 {
+		response.Orders = append(response.Orders, Order{ID: strings.Repeat("x", 40), Side: SideBuy, Price: 1.5, Quantity: 7})
+	}
+	response.Count = len(response.Orders)
+	book := OrderBookResponse{Symbol: "DEFAULT", OrderBook: RestingOrders{BuyOrders: response.Orders}, BuyCount: response.Count}
+
+	fields := protoFields(t, book.appendProto(nil))
+	if fields[1][0].string() != "DEFAULT" || fields[3][0].int() != 300 {
+		t.Fatalf("Unexpected book fields %+v", fields)
+	}
+	orders := protoFields(t, fields[2][0].bytes)[1]
+	if len(orders) != 300 {
+		t.Fatalf("Expected 300 orders, got %d", len(orders))
+	}
+	if order := protoFields(t, orders[299].bytes); order[4][0].int() != 7 || order[5][0].double() != 1.5 {
+		t.Errorf("Unexpected last order %+v", order)
+	}
+}
+
+func TestDecodeRequest_RefusesUnreadableProtobuf(t *testing.T) {
+	setupTest()
+
+	request := httptest.NewRequest("POST", "/api/v1/orders", bytes.NewReader([]byte{0x0a, 0x10, 'b'}))
+	request.Header.Set("Content-Type", protobufContentType)
+	response := serve(HTTPConfig{}, request)
+	if result := decodeError(t, response); response.Code != http.StatusBadRequest || result.Error.Code != ErrCodeInvalidProtobuf {
+		t.Errorf("Expected a truncated body to be refused, got %d %s", response.Code, result.Error.Code)
+	}
+
+	// The quantity sent as a string has the wrong wire type
+	request = httptest.NewRequest("POST", "/api/v1/orders", bytes.NewReader(appendProtoString(nil, 4, "3")))
+	request.Header.Set("Content-Type", protobufContentType)
+	response = serve(HTTPConfig{}, request)
+	if result := decodeError(t, response); result.Error.Code != ErrCodeInvalidProtobuf {
+		t.Errorf("Expected a mistyped field to be refused, got %s", result.Error.Code)
+	}
+
+	request = httptest.NewRequest("POST", "/api/v1/algos", bytes.NewReader(nil))
+	request.Header.Set("Content-Type", protobufContentType)
+	response = serve(HTTPConfig{}, request)
+	if result := decodeError(t, response); response.Code != http.StatusUnsupportedMediaType || result.Error.Code != ErrCodeUnsupportedType {
+		t.Errorf("Expected protobuf to be refused where it is not supported, got %d %s", response.Code, result.Error.Code)
+	}
+}
+
+func TestOpenAPI_DocumentsProtobufBodies(t *testing.T) {
+	doc := buildOpenAPI(apiRoutes())
+	operation := doc.Paths["/api/v1/orders"]["post"]
+	if _, ok := operation.RequestBody.Content[protobufContentType]; !ok {
+		t.Errorf("Expected placeOrder to accept protobuf")
+	}
+	if _, ok := operation.Responses["200"].Content[protobufContentType]; !ok {
+		t.Errorf("Expected placeOrder to return protobuf")
+	}
+	if _, ok := doc.Paths["/api/v1/trades"]["get"].Responses["200"].Content[protobufContentType]; ok {
+		t.Errorf("Expected listTrades to stay JSON only")
+	}
+}
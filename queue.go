@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// QueuePosition is where a resting order waits within its price level. Only
+// orders at the same price are counted as ahead; better prices fill first
+// regardless.
+type QueuePosition struct {
+	OrderID string  `json:"order_id"`
+	Symbol  string  `json:"symbol"`
+	Side    Side    `json:"side"`
+	Price   float64 `json:"price"`
+	// Quantity is what the order still has resting
+	Quantity int `json:"quantity"`
+	// Position is 1 for the order that fills next at its price
+	Position      int `json:"position"`
+	OrdersAhead   int `json:"orders_ahead"`
+	QuantityAhead int `json:"quantity_ahead"`
+	// LevelOrders and LevelQuantity total the whole level, the order included
+	LevelOrders   int `json:"level_orders"`
+	LevelQuantity int `json:"level_quantity"`
+}
+
+// queuePosition finds a resting order's place in its level, or a paper
+// order's estimated place behind the exchange. It must run on the matcher.
+func (m *matcher) queuePosition(orderID string) (QueuePosition, bool) {
+	book := m.book
+	side, handle, ok := findResting(book, orderID)
+	if !ok {
+		return paperQueuePosition(book, m.symbol, orderID)
+	}
+
+	order := side.at(handle)
+	position := QueuePosition{
+		OrderID:  order.ID,
+		Symbol:   m.symbol,
+		Side:     order.Side,
+		Price:    order.Price,
+		Quantity: order.Quantity,
+		Position: side.position(handle),
+	}
+	position.OrdersAhead = position.Position - 1
+	for i, resting := range side.level(order.Price) {
+		if i < position.OrdersAhead {
+			position.QuantityAhead += resting.Quantity
+		}
+		position.LevelQuantity += resting.Quantity
+		position.LevelOrders++
+	}
+	return position, true
+}
+
+// getQueuePositionHandler reports how much rests ahead of an order at its price
+func getQueuePositionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	orderID := r.PathValue("id")
+	symbol := r.URL.Query().Get("symbol")
+	for _, m := range allMatchers() {
+		if symbol != "" && m.symbol != symbol {
+			continue
+		}
+
+		var position QueuePosition
+		var ok bool
+		m.do(func() {
+			expireOrders(m.book, engineClock.Now())
+			position, ok = m.queuePosition(orderID)
+		})
+		if ok {
+			json.NewEncoder(w).Encode(position)
+			return
+		}
+	}
+	writeOrderNotFound(w, orderID)
+}
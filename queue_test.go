@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// getQueuePosition fetches an order's queue position through the API
+func getQueuePosition(t *testing.T, orderID string) (QueuePosition, int) {
+	t.Helper()
+	response := serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/orders/"+orderID+"/queue-position", nil))
+	var position QueuePosition
+	json.NewDecoder(response.Body).Decode(&position)
+	return position, response.Code
+}
+
+func TestQueuePosition_CountsWhatIsAheadAtTheSamePrice(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "bid-better", Side: SideBuy, Price: 100.0, Quantity: 9})
+	placeOn(m, Order{ID: "bid-1", Side: SideBuy, Price: 99.0, Quantity: 4})
+	placeOn(m, Order{ID: "bid-2", Side: SideBuy, Price: 99.0, Quantity: 3})
+	placeOn(m, Order{ID: "bid-3", Side: SideBuy, Price: 99.0, Quantity: 5})
+	placeOn(m, Order{ID: "ask-1", Side: SideSell, Price: 101.0, Quantity: 2})
+
+	position, code := getQueuePosition(t, "bid-3")
+	want := QueuePosition{OrderID: "bid-3", Symbol: "DEFAULT", Side: SideBuy, Price: 99.0, Quantity: 5,
+		Position: 3, OrdersAhead: 2, QuantityAhead: 7, LevelOrders: 3, LevelQuantity: 12}
+	if code != http.StatusOK || position != want {
+		t.Fatalf("Expected %+v, got %d %+v", want, code, position)
+	}
+	if position, _ := getQueuePosition(t, "ask-1"); position.Position != 1 || position.QuantityAhead != 0 || position.LevelOrders != 1 {
+		t.Errorf("Expected ask-1 to be alone at the front, got %+v", position)
+	}
+
+	// Fills and cancels ahead move the order up; the better bid fills first
+	placeOn(m, Order{ID: "sell-1", Side: SideSell, Price: 99.0, Quantity: 13})
+	m.do(func() { cancelOrder("", "bid-2") })
+	if position, _ := getQueuePosition(t, "bid-3"); position.Position != 1 || position.QuantityAhead != 0 || position.LevelQuantity != 5 {
+		t.Errorf("Expected bid-3 alone at the front, got %+v", position)
+	}
+}
+
+func TestQueuePosition_OnlyForRestingOrders(t *testing.T) {
+	setupTest()
+	tradeAt(t, 100.0)
+	processOrder(trailingStop("stop-1", SideSell, 5, 2.0, 0))
+
+	for _, orderID := range []string{"missing", "stop-1"} {
+		response := serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/orders/"+orderID+"/queue-position", nil))
+		if result := decodeError(t, response); response.Code != http.StatusNotFound || result.Error.Code != ErrCodeOrderNotFound {
+			t.Errorf("Expected %s to have no queue position, got %d %s", orderID, response.Code, result.Error.Code)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Quote is one price level a maker wants to rest at
+type Quote struct {
+	Side     Side    `json:"side"`
+	Price    float64 `json:"price"`
+	Quantity int     `json:"quantity"`
+}
+
+// MassQuoteRequest is a maker's full desired quote set on one symbol. The
+// owner's resting limit orders there are made to match it: quotes that are
+// gone are cancelled, changed sizes are amended and new prices are inserted.
+// An empty set pulls every quote.
+type MassQuoteRequest struct {
+	Symbol string  `json:"symbol,omitempty"`
+	Owner  string  `json:"owner" validate:"required"`
+	Quotes []Quote `json:"quotes"`
+}
+
+// MassQuoteResponse shows what the engine did to reach the quote set. Every
+// order is in its state after the mass quote.
+type MassQuoteResponse struct {
+	Symbol    string  `json:"symbol"`
+	Cancelled []Order `json:"cancelled"`
+	// Amended orders keep their ID. A smaller quantity keeps the order's
+	// place in the queue; a larger one sends it to the back of its level and
+	// resets its created_at.
+	Amended   []Order `json:"amended"`
+	Inserted  []Order `json:"inserted"`
+	Unchanged int     `json:"unchanged"`
+}
+
+// quoteKey identifies a quote by its side and price
+type quoteKey struct {
+	side  Side
+	price float64
+}
+
+// validate checks each quote and that no side and price appears twice
+func (req MassQuoteRequest) validate() []FieldError {
+	var errs []FieldError
+	seen := make(map[quoteKey]bool, len(req.Quotes))
+	for i, quote := range req.Quotes {
+		field := fmt.Sprintf("quotes[%d]", i)
+		switch {
+		case quote.Side != SideBuy && quote.Side != SideSell:
+			errs = append(errs, fieldError(field+".side", "oneof", "%s.side must be one of 'buy', 'sell' (received: '%s')", field, quote.Side))
+		case quote.Price <= 0 || quote.Price > 999999999.99:
+			errs = append(errs, fieldError(field+".price", "gt", "%s.price must be a positive number up to 999,999,999.99 (received: %g)", field, quote.Price))
+		case quote.Quantity <= 0 || quote.Quantity > 999999999:
+			errs = append(errs, fieldError(field+".quantity", "gt", "%s.quantity must be between 1 and 999,999,999 (received: %d)", field, quote.Quantity))
+		case seen[quoteKey{quote.Side, quote.Price}]:
+			errs = append(errs, fieldError(field, "unique", "%s repeats the %s quote at %g", field, quote.Side, quote.Price))
+		}
+		seen[quoteKey{quote.Side, quote.Price}] = true
+	}
+	return errs
+}
+
+// isQuote reports whether a resting order is one a mass quote manages: a
+// plain limit order, rather than a stop or a pegged order
+func isQuote(order Order) bool {
+	return order.Type == "" || order.Type == OrderTypeLimit
+}
+
+// massQuote moves the owner's quotes on the matcher's symbol to the requested
+// set in one matcher command, so nothing trades against a half-updated set.
+// Cancels go first, so that new quotes cannot cross the ones they replace.
+// It changes nothing and returns errEngineBusy if the matcher's queue is
+// full, or ctx's error if ctx ends before the matcher gets to it.
+func massQuote(ctx context.Context, m *matcher, req MassQuoteRequest) (MassQuoteResponse, error) {
+	response := MassQuoteResponse{Symbol: m.symbol, Cancelled: []Order{}, Amended: []Order{}, Inserted: []Order{}}
+
+	err := m.tryDo(ctx, func() {
+		book := m.book
+		expireOrders(book, engineClock.Now())
+
+		wanted := make(map[quoteKey]int, len(req.Quotes))
+		for _, quote := range req.Quotes {
+			wanted[quoteKey{quote.Side, quote.Price}] = quote.Quantity
+		}
+
+		// Keep the first resting order at each wanted price; the rest go
+		var cancels []string
+		var amends []Order
+		kept := make(map[quoteKey]bool)
+		for _, orders := range [][]Order{book.bids.orders(), book.asks.orders()} {
+			for _, order := range orders {
+				if order.Owner != req.Owner || !isQuote(order) {
+					continue
+				}
+				key := quoteKey{order.Side, order.Price}
+				quantity, ok := wanted[key]
+				switch {
+				case !ok || kept[key]:
+					cancels = append(cancels, order.ID)
+				case quantity == order.Quantity:
+					response.Unchanged++
+				default:
+					amends = append(amends, order)
+				}
+				if ok {
+					kept[key] = true
+				}
+			}
+		}
+
+		for _, id := range cancels {
+			if order, ok := cancelOrder(m.symbol, id); ok {
+				response.Cancelled = append(response.Cancelled, order)
+			}
+		}
+		for _, order := range amends {
+			response.Amended = append(response.Amended, amendQuantity(book, order, wanted[quoteKey{order.Side, order.Price}], "mass quote"))
+		}
+		for _, quote := range req.Quotes {
+			if kept[quoteKey{quote.Side, quote.Price}] {
+				continue
+			}
+			response.Inserted = append(response.Inserted, processOrder(Order{
+				ID:        generateOrderID(),
+				Symbol:    m.symbol,
+				Side:      quote.Side,
+				Quantity:  quote.Quantity,
+				Price:     quote.Price,
+				Status:    OrderStatusPending,
+				CreatedAt: engineClock.Now(),
+				Owner:     req.Owner,
+			}))
+		}
+	})
+	return response, err
+}
+
+// amendQuantity changes a resting order's quantity, recording what asked for
+// it. Shrinking it keeps its time priority; growing it moves it behind the
+// rest of its level. It must run on the book's matcher.
+func amendQuantity(book *OrderBook, order Order, quantity int, source string) Order {
+	if side, handle, ok := findResting(book, order.ID); ok {
+		delta := quantity - order.Quantity
+		if delta > 0 {
+			_, position := takeResting(book, side, handle)
+			publishL3(book, L3Delete, order, position, -order.Quantity, "")
+			order.Quantity = quantity
+			requeue(&order)
+			_, position = side.insert(order)
+			publishL3(book, L3Add, order, position, quantity, "")
+		} else {
+			order.Quantity = quantity
+			*side.edit(handle) = order
+			publishL3(book, L3Reduce, order, l3Position(book, side, handle), delta, "")
+		}
+		adjustLevel(book, order.Side, order.Price, delta, 0)
+		reason := fmt.Sprintf("quantity amended to %d by %s", quantity, source)
+		recordOrderEvent(order.ID, order.Status, order.Status, reason)
+		feed.order(order, reason)
+		return order
+	}
+	return order
+}
+
+// massQuoteHandler replaces an owner's quotes on a symbol with a new set
+func massQuoteHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req MassQuoteRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	m, ok := matcherFor(req.Symbol)
+	if !ok {
+		writeError(w, http.StatusBadRequest, ErrCodeUnknownSymbol, "Unknown symbol",
+			"symbol '"+req.Symbol+"' is not traded here")
+		return
+	}
+
+	if !throttleAllows(req.Owner, ThrottleQuote) {
+		writeThrottled(w, req.Owner, ThrottleQuote)
+		return
+	}
+
+	response, err := massQuote(r.Context(), m, req)
+	if err == errEngineBusy {
+		writeEngineBusy(w, m)
+		return
+	} else if err != nil {
+		writeRequestTimeout(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(response)
+}
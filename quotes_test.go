@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// postMassQuote sends a mass quote and decodes a successful response
+func postMassQuote(t *testing.T, body string) MassQuoteResponse {
+	t.Helper()
+	response := serve(HTTPConfig{}, httptest.NewRequest("POST", "/api/v1/orders/mass-quote", bytes.NewBufferString(body)))
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected the mass quote to apply, got %d: %s", response.Code, response.Body.String())
+	}
+	var result MassQuoteResponse
+	json.NewDecoder(response.Body).Decode(&result)
+	return result
+}
+
+func TestMassQuote_DiffsAgainstRestingQuotes(t *testing.T) {
+	setupTest()
+	first := postMassQuote(t, `{"owner": "mm", "quotes": [
+		{"side": "buy", "price": 99, "quantity": 5},
+		{"side": "buy", "price": 98, "quantity": 5},
+		{"side": "sell", "price": 101, "quantity": 5},
+		{"side": "sell", "price": 102, "quantity": 5}]}`)
+	if len(first.Inserted) != 4 || len(first.Cancelled) != 0 {
+		t.Fatalf("Expected four new quotes, got %+v", first)
+	}
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "other-bid", Side: SideBuy, Price: 99.0, Quantity: 1, Owner: "other"})
+	bid99 := first.Inserted[0].ID
+
+	// 99 shrinks, 98 is unchanged, 101 grows, 102 is pulled and 103 is new
+	second := postMassQuote(t, `{"owner": "mm", "quotes": [
+		{"side": "buy", "price": 99, "quantity": 2},
+		{"side": "buy", "price": 98, "quantity": 5},
+		{"side": "sell", "price": 101, "quantity": 8},
+		{"side": "sell", "price": 103, "quantity": 5}]}`)
+	if second.Unchanged != 1 || len(second.Amended) != 2 || len(second.Cancelled) != 1 || len(second.Inserted) != 1 {
+		t.Fatalf("Expected 1 unchanged, 2 amended, 1 cancelled and 1 inserted, got %+v", second)
+	}
+	if second.Cancelled[0].Price != 102 || second.Inserted[0].Price != 103 {
+		t.Errorf("Expected 102 to be pulled and 103 added, got %+v", second)
+	}
+
+	book := bookFor("")
+	if bid := book.bids.orders()[0]; bid.ID != bid99 || bid.Quantity != 2 {
+		t.Errorf("Expected the shrunk bid to keep its place ahead of other-bid, got %+v", bid)
+	}
+	if ask := book.asks.orders()[0]; ask.Quantity != 8 {
+		t.Errorf("Expected the ask at 101 to grow to 8, got %+v", ask)
+	}
+	if liquidity := book.bidLiquidity.summary(); liquidity.Quantity != 8 {
+		t.Errorf("Expected 8 bid quantity to rest, got %+v", liquidity)
+	}
+
+	if pulled := postMassQuote(t, `{"owner": "mm", "quotes": []}`); len(pulled.Cancelled) != 4 {
+		t.Errorf("Expected an empty set to pull every quote, got %+v", pulled)
+	}
+	if resting := collectOrders(""); len(resting) != 1 || resting[0].ID != "other-bid" {
+		t.Errorf("Expected only the other owner's bid to rest, got %+v", resting)
+	}
+}
+
+func TestMassQuote_RejectsBadQuoteSets(t *testing.T) {
+	setupTest()
+	cases := []struct {
+		body string
+		code ErrorCode
+	}{
+		{`{"quotes": []}`, ErrCodeValidationFailed},
+		{`{"owner": "mm", "quotes": [{"side": "bid", "price": 99, "quantity": 1}]}`, ErrCodeValidationFailed},
+		{`{"owner": "mm", "quotes": [{"side": "buy", "price": 0, "quantity": 1}]}`, ErrCodeValidationFailed},
+		{`{"owner": "mm", "quotes": [{"side": "buy", "price": 99, "quantity": 0}]}`, ErrCodeValidationFailed},
+		{`{"owner": "mm", "quotes": [{"side": "buy", "price": 99, "quantity": 1}, {"side": "buy", "price": 99, "quantity": 2}]}`, ErrCodeValidationFailed},
+		{`{"owner": "mm", "symbol": "DOGE-USD", "quotes": []}`, ErrCodeUnknownSymbol},
+	}
+	for _, tc := range cases {
+		response := serve(HTTPConfig{}, httptest.NewRequest("POST", "/api/v1/orders/mass-quote", bytes.NewBufferString(tc.body)))
+		if result := decodeError(t, response); response.Code != http.StatusBadRequest || result.Error.Code != tc.code {
+			t.Errorf("%s: expected 400 %s, got %d %s", tc.body, tc.code, response.Code, result.Error.Code)
+		}
+	}
+	if resting := collectOrders(""); len(resting) != 0 {
+		t.Errorf("Expected nothing to rest after rejected quote sets, got %+v", resting)
+	}
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// projectionKind is what a projected event changes
+type projectionKind int
+
+const (
+	// projectRest is an order coming to rest, or resting with a new quantity
+	projectRest projectionKind = iota
+	// projectLeave is an order leaving the book
+	projectLeave
+	// projectStatus is a status change to an order that may be resting
+	projectStatus
+	// projectTrade is a trade being made
+	projectTrade
+	// projectArchive is the count oldest trades being archived
+	projectArchive
+	// projectBook replaces one symbol's resting orders, as when it is restored
+	projectBook
+	// projectReset replaces every resting order and the whole trade history
+	projectReset
+)
+
+// projectionEvent is one change for the read model to apply
+type projectionEvent struct {
+	kind   projectionKind
+	symbol string
+	order  Order
+	trade  Trade
+	count  int
+	orders []Order
+	trades []Trade
+}
+
+// candleWidth is the width of the candles the read model keeps. Wider
+// candles that are a multiple of it are merged from them.
+const candleWidth = time.Second
+
+// projection is the read model: copies of the resting orders, grouped by
+// owner, and of the trade history, indexed by symbol and kept as candles. The
+// matchers queue the changes they make without waiting, and the projection
+// applies them on its own goroutine, so serving reads from it never holds up
+// matching.
+type projection struct {
+	// mu guards the queue and advanced. The counts of events queued and
+	// applied are atomic so readers can check them without taking it.
+	mu      sync.Mutex
+	pending []projectionEvent
+	spare   []projectionEvent
+	queued  atomic.Int64
+	applied atomic.Int64
+	wake    chan struct{}
+	// advanced is closed, and replaced, each time events are applied
+	advanced chan struct{}
+
+	// state guards everything below
+	state  sync.RWMutex
+	orders map[string]Order
+	owners map[string]map[string]struct{}
+	// trades is the history less the archived prefix; a trade's position
+	// counts from the start of the history, so it is trades[position-dropped]
+	trades       []Trade
+	dropped      int
+	tradeIDs     map[string]int
+	symbolTrades map[string][]int
+	symbolCandle map[string][]Candle
+}
+
+// readModel serves order, trade and candle reads when it is set. Set it
+// before the matchers start, since they feed it.
+var readModel *projection
+
+// startReadModel starts a read model that applies changes until ctx is done
+func startReadModel(ctx context.Context) *projection {
+	p := &projection{
+		wake:     make(chan struct{}, 1),
+		advanced: make(chan struct{}),
+	}
+	p.clear()
+	go p.run(ctx)
+	return p
+}
+
+// clear empties the projected state. p.state must be held.
+func (p *projection) clear() {
+	p.orders = make(map[string]Order)
+	p.owners = make(map[string]map[string]struct{})
+	p.trades = nil
+	p.dropped = 0
+	p.tradeIDs = make(map[string]int)
+	p.symbolTrades = make(map[string][]int)
+	p.symbolCandle = make(map[string][]Candle)
+}
+
+// queue adds an event for the projection to apply, without waiting for it
+func (p *projection) queue(event projectionEvent) {
+	p.mu.Lock()
+	p.pending = append(p.pending, event)
+	p.queued.Add(1)
+	p.mu.Unlock()
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// rest projects an order resting with its current quantity
+func (p *projection) rest(order Order) {
+	if p != nil {
+		p.queue(projectionEvent{kind: projectRest, order: order})
+	}
+}
+
+// leave projects an order leaving the book
+func (p *projection) leave(order Order) {
+	if p != nil {
+		p.queue(projectionEvent{kind: projectLeave, order: order})
+	}
+}
+
+// status projects an order's new status, if the order is resting
+func (p *projection) status(order Order) {
+	if p != nil {
+		p.queue(projectionEvent{kind: projectStatus, order: order})
+	}
+}
+
+// trade projects a trade being added to the history
+func (p *projection) trade(trade Trade) {
+	if p != nil {
+		p.queue(projectionEvent{kind: projectTrade, trade: trade})
+	}
+}
+
+// archive projects the count oldest trades leaving the history
+func (p *projection) archive(count int) {
+	if p != nil && count > 0 {
+		p.queue(projectionEvent{kind: projectArchive, count: count})
+	}
+}
+
+// book projects a symbol's resting orders being replaced
+func (p *projection) book(symbol string, book *OrderBook) {
+	if p != nil {
+		p.queue(projectionEvent{kind: projectBook, symbol: symbol, orders: restingOrders(book)})
+	}
+}
+
+// reset projects every book and the trade history being replaced
+func (p *projection) reset(books []*OrderBook, trades []Trade) {
+	if p == nil {
+		return
+	}
+	var orders []Order
+	for _, book := range books {
+		orders = append(orders, restingOrders(book)...)
+	}
+	p.queue(projectionEvent{kind: projectReset, orders: orders, trades: trades})
+}
+
+// restingOrders copies a book's resting orders
+func restingOrders(book *OrderBook) []Order {
+	return append(append([]Order(nil), book.bids.orders()...), book.asks.orders()...)
+}
+
+// run applies queued events until ctx is done
+func (p *projection) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.wake:
+		}
+
+		p.mu.Lock()
+		batch := p.pending
+		p.pending = p.spare[:0]
+		p.mu.Unlock()
+
+		p.state.Lock()
+		for i := range batch {
+			p.apply(&batch[i])
+		}
+		p.state.Unlock()
+
+		// The batch is reused once the orders it held are let go
+		clear(batch)
+		p.applied.Add(int64(len(batch)))
+		p.mu.Lock()
+		p.spare = batch
+		close(p.advanced)
+		p.advanced = make(chan struct{})
+		p.mu.Unlock()
+	}
+}
+
+// apply applies one event. p.state must be held.
+func (p *projection) apply(event *projectionEvent) {
+	switch event.kind {
+	case projectRest:
+		p.putOrder(event.order)
+	case projectLeave:
+		p.dropOrder(event.order)
+	case projectStatus:
+		if _, ok := p.orders[event.order.ID]; ok {
+			p.orders[event.order.ID] = event.order
+		}
+	case projectTrade:
+		p.addTrade(event.trade)
+	case projectArchive:
+		p.archiveTrades(event.count)
+	case projectBook:
+		for _, order := range p.orders {
+			if order.Symbol == event.symbol {
+				p.dropOrder(order)
+			}
+		}
+		for _, order := range event.orders {
+			p.putOrder(order)
+		}
+	case projectReset:
+		p.clear()
+		for _, order := range event.orders {
+			p.putOrder(order)
+		}
+		for _, trade := range event.trades {
+			p.addTrade(trade)
+		}
+	}
+}
+
+// putOrder adds or replaces a resting order. p.state must be held.
+func (p *projection) putOrder(order Order) {
+	p.orders[order.ID] = order
+	owned, ok := p.owners[order.Owner]
+	if !ok {
+		owned = make(map[string]struct{})
+		p.owners[order.Owner] = owned
+	}
+	owned[order.ID] = struct{}{}
+}
+
+// dropOrder removes a resting order. p.state must be held.
+func (p *projection) dropOrder(order Order) {
+	delete(p.orders, order.ID)
+	if owned, ok := p.owners[order.Owner]; ok {
+		delete(owned, order.ID)
+		if len(owned) == 0 {
+			delete(p.owners, order.Owner)
+		}
+	}
+}
+
+// addTrade appends a trade to the history and its symbol's candles. p.state
+// must be held.
+func (p *projection) addTrade(trade Trade) {
+	position := p.dropped + len(p.trades)
+	p.trades = append(p.trades, trade)
+	p.tradeIDs[trade.ID] = position
+	p.symbolTrades[trade.Symbol] = append(p.symbolTrades[trade.Symbol], position)
+	p.symbolCandle[trade.Symbol] = appendCandle(p.symbolCandle[trade.Symbol], trade.Symbol, trade, candleWidth)
+}
+
+// archiveTrades drops the count oldest trades, rebuilding the candles of the
+// symbols they were on so they match what is left. p.state must be held.
+func (p *projection) archiveTrades(count int) {
+	count = min(count, len(p.trades))
+	symbols := make(map[string]struct{})
+	for _, trade := range p.trades[:count] {
+		delete(p.tradeIDs, trade.ID)
+		symbols[trade.Symbol] = struct{}{}
+	}
+	p.trades = slices.Clone(p.trades[count:])
+	p.dropped += count
+
+	for symbol := range symbols {
+		positions := p.symbolTrades[symbol]
+		kept := sort.SearchInts(positions, p.dropped)
+		p.symbolTrades[symbol] = slices.Clone(positions[kept:])
+		p.symbolCandle[symbol] = buildCandles(symbol, p.tradesOn(symbol), candleWidth, 0)
+	}
+}
+
+// tradesOn copies a symbol's trades in the order they were made. p.state must
+// be held.
+func (p *projection) tradesOn(symbol string) []Trade {
+	positions := p.symbolTrades[symbol]
+	trades := make([]Trade, len(positions))
+	for i, position := range positions {
+		trades[i] = p.trades[position-p.dropped]
+	}
+	return trades
+}
+
+// sync waits until the projection has applied every event queued before it
+// was called, so a caller reads its own writes. It gives up when ctx ends.
+func (p *projection) sync(ctx context.Context) error {
+	target := p.queued.Load()
+	for {
+		p.mu.Lock()
+		advanced := p.advanced
+		p.mu.Unlock()
+		if p.applied.Load() >= target {
+			return nil
+		}
+		select {
+		case <-advanced:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// ownerOrders returns an owner's resting orders in arrival order, optionally
+// for one symbol, leaving out any that have reached their expiry
+func (p *projection) ownerOrders(owner, symbol string, now time.Time) []Order {
+	p.state.RLock()
+	defer p.state.RUnlock()
+
+	orders := make([]Order, 0, len(p.owners[owner]))
+	for id := range p.owners[owner] {
+		order := p.orders[id]
+		if (symbol == "" || order.Symbol == symbol) && !isExpired(order, now) {
+			orders = append(orders, order)
+		}
+	}
+	sortByArrival(orders)
+	return orders
+}
+
+// sortByArrival orders a list of orders by when they arrived
+func sortByArrival(orders []Order) {
+	slices.SortFunc(orders, func(a, b Order) int { return comparePriority(&a, &b) })
+}
+
+// listTrades returns the trades in span in the order they were made,
+// optionally for one symbol
+func (p *projection) listTrades(symbol string, span timeRange) []Trade {
+	p.state.RLock()
+	defer p.state.RUnlock()
+
+	if symbol == "" {
+		trades := make([]Trade, 0, len(p.trades))
+		for _, trade := range p.trades {
+			if span.includes(trade.CreatedAt) {
+				trades = append(trades, trade)
+			}
+		}
+		return trades
+	}
+
+	// A symbol's trades are made on its matcher, so they are in time order
+	positions := p.symbolTrades[symbol]
+	at := func(i int) time.Time { return p.trades[positions[i]-p.dropped].CreatedAt }
+	first, last := 0, len(positions)
+	if !span.from.IsZero() {
+		first = sort.Search(len(positions), func(i int) bool { return !at(i).Before(span.from) })
+	}
+	if !span.to.IsZero() {
+		last = sort.Search(len(positions), func(i int) bool { return !at(i).Before(span.to) })
+	}
+	trades := make([]Trade, 0, max(last-first, 0))
+	for _, position := range positions[first:max(last, first)] {
+		trades = append(trades, p.trades[position-p.dropped])
+	}
+	return trades
+}
+
+// tradeByID returns the trade with an ID, if it is still in memory
+func (p *projection) tradeByID(id string) (Trade, bool) {
+	p.state.RLock()
+	defer p.state.RUnlock()
+
+	position, ok := p.tradeIDs[id]
+	if !ok {
+		return Trade{}, false
+	}
+	return p.trades[position-p.dropped], true
+}
+
+// candles returns a symbol's candles of the given interval, merged from the
+// projected ones when the interval is a multiple of their width and built
+// from its trades otherwise
+func (p *projection) candles(symbol string, interval time.Duration, limit int) []Candle {
+	p.state.RLock()
+	defer p.state.RUnlock()
+
+	if interval%candleWidth != 0 {
+		return buildCandles(symbol, p.tradesOn(symbol), interval, limit)
+	}
+	return mergeCandles(p.symbolCandle[symbol], interval, limit)
+}
+
+// AccountOrdersResponse lists an account's resting orders, oldest first
+type AccountOrdersResponse struct {
+	AccountID string  `json:"account_id"`
+	Orders    []Order `json:"orders"`
+	Count     int     `json:"count"`
+}
+
+// getAccountOrdersHandler returns the orders an account has resting, from the
+// read model when there is one and from the latest book views otherwise
+func getAccountOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id := r.PathValue("id")
+	if _, ok := lookupAccount(id); !ok {
+		writeAccountNotFound(w, id)
+		return
+	}
+	symbol := r.URL.Query().Get("symbol")
+	if _, ok := matcherFor(symbol); symbol != "" && !ok {
+		writeError(w, http.StatusNotFound, ErrCodeUnknownSymbol, "Unknown symbol",
+			"symbol '"+symbol+"' is not traded here")
+		return
+	}
+
+	var orders []Order
+	if readModel != nil {
+		if err := readModel.sync(r.Context()); err != nil {
+			writeRequestTimeout(w, err)
+			return
+		}
+		orders = readModel.ownerOrders(id, symbol, engineClock.Now())
+	} else {
+		orders = make([]Order, 0)
+		for _, m := range allMatchers() {
+			if symbol != "" && m.symbol != symbol {
+				continue
+			}
+			view := m.readView()
+			for _, order := range slices.Concat(view.buy, view.sell) {
+				if order.Owner == id {
+					orders = append(orders, order)
+				}
+			}
+		}
+		sortByArrival(orders)
+	}
+	json.NewEncoder(w).Encode(AccountOrdersResponse{
+		AccountID: id,
+		Orders:    orders,
+		Count:     len(orders),
+	})
+}
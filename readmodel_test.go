@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// useReadModel restarts the default symbol with a read model feeding off it
+func useReadModel(t *testing.T) *projection {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	readModel = startReadModel(ctx)
+	resetSymbols([]string{"DEFAULT"})
+	return readModel
+}
+
+// getAccountOrders fetches an account's resting orders
+func getAccountOrders(t *testing.T, id string) AccountOrdersResponse {
+	t.Helper()
+	response := serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/accounts/"+id+"/orders", nil))
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected the orders, got %d: %s", response.Code, response.Body.String())
+	}
+	var result AccountOrdersResponse
+	json.NewDecoder(response.Body).Decode(&result)
+	return result
+}
+
+func TestReadModel_ProjectsOrdersTradesAndCandles(t *testing.T) {
+	setupTest()
+	clock := useDeterministicEngine(t, time.Date(2024, 1, 1, 9, 30, 0, 0, time.UTC))
+	useReadModel(t)
+	createAccount(CreateAccountRequest{ID: "alice"})
+	m, _ := matcherFor("")
+
+	placeOn(m, Order{ID: "ask", Symbol: "DEFAULT", Owner: "alice", Side: SideSell, Price: 101, Quantity: 3})
+	placeOn(m, Order{ID: "bid", Symbol: "DEFAULT", Owner: "alice", Side: SideBuy, Price: 99, Quantity: 5})
+	placeOn(m, Order{ID: "other", Symbol: "DEFAULT", Owner: "bob", Side: SideBuy, Price: 98, Quantity: 1})
+	placeOn(m, Order{ID: "lift", Symbol: "DEFAULT", Owner: "bob", Side: SideBuy, Price: 101, Quantity: 1})
+	clock.Advance(time.Minute)
+	placeOn(m, Order{ID: "hit", Symbol: "DEFAULT", Owner: "bob", Side: SideSell, Price: 99, Quantity: 2})
+
+	orders := getAccountOrders(t, "alice")
+	var want []Order
+	for _, order := range collectOrders("") {
+		if order.Owner == "alice" {
+			want = append(want, order)
+		}
+	}
+	sortByArrival(want)
+	got, _ := json.Marshal(orders.Orders)
+	if expected, _ := json.Marshal(want); orders.Count != 2 || string(got) != string(expected) {
+		t.Errorf("Expected the orders on the book %s, got %s", expected, got)
+	}
+	if ask := orders.Orders[0]; ask.ID != "ask" || ask.Quantity != 2 || ask.Status != OrderStatusPartiallyFilled {
+		t.Errorf("Expected the partly filled ask first, got %+v", ask)
+	}
+
+	// Orders that leave the book leave the projection
+	serve(HTTPConfig{}, httptest.NewRequest("DELETE", "/api/v1/orders/ask", nil))
+	if orders := getAccountOrders(t, "alice"); orders.Count != 1 || orders.Orders[0].ID != "bid" || orders.Orders[0].Quantity != 3 {
+		t.Errorf("Expected only the rest of the bid, got %+v", orders.Orders)
+	}
+
+	var trades TradesResponse
+	json.NewDecoder(serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/trades?symbol=DEFAULT&from=2024-01-01T09:31:00Z", nil)).Body).Decode(&trades)
+	if trades.Count != 1 || trades.Trades[0].TakerID != "hit" {
+		t.Errorf("Expected the later trade, got %+v", trades.Trades)
+	}
+	id := tradeStore.List("")[0].ID
+	if response := serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/trades/"+id, nil)); response.Code != http.StatusOK {
+		t.Errorf("Expected trade %s, got %d", id, response.Code)
+	}
+
+	for _, interval := range []string{"1m", "90s"} {
+		var candles CandlesResponse
+		json.NewDecoder(serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/candles?interval="+interval, nil)).Body).Decode(&candles)
+		parsed, _ := time.ParseDuration(interval)
+		if want := buildCandles("DEFAULT", tradeStore.List("DEFAULT"), parsed, 0); !reflect.DeepEqual(candles.Candles, want) {
+			t.Errorf("%s: expected %+v, got %+v", interval, want, candles.Candles)
+		}
+	}
+}
+
+func TestReadModel_FollowsArchivingAndRestores(t *testing.T) {
+	setupTest()
+	clock := useDeterministicEngine(t, time.Date(2024, 1, 1, 9, 30, 0, 0, time.UTC))
+	p := useReadModel(t)
+	createAccount(CreateAccountRequest{ID: "alice"})
+	m, _ := matcherFor("")
+	for i := 0; i < 3; i++ {
+		placeOn(m, Order{ID: fmt.Sprintf("ask-%d", i), Symbol: "DEFAULT", Owner: "alice", Side: SideSell, Price: 100, Quantity: 1})
+		placeOn(m, Order{ID: fmt.Sprintf("bid-%d", i), Symbol: "DEFAULT", Side: SideBuy, Price: 100, Quantity: 1})
+		clock.Advance(time.Minute)
+	}
+	saved := takeSnapshot()
+	placeOn(m, Order{ID: "resting", Symbol: "DEFAULT", Owner: "alice", Side: SideSell, Price: 105, Quantity: 1})
+
+	trimArchived(selectArchivable(time.Date(2024, 1, 1, 9, 31, 30, 0, time.UTC)))
+	p.sync(context.Background())
+	if trades := p.listTrades("DEFAULT", timeRange{}); len(trades) != 1 || trades[0].MakerID != "ask-2" {
+		t.Errorf("Expected the archived trades dropped, got %+v", trades)
+	}
+	if candles := p.candles("DEFAULT", time.Minute, 0); len(candles) != 1 || candles[0].Trades != 1 {
+		t.Errorf("Expected the archived candles dropped, got %+v", candles)
+	}
+	if _, ok := p.tradeByID(saved.Trades[0].ID); ok {
+		t.Error("Expected an archived trade gone")
+	}
+
+	restoreSnapshot(saved)
+	if orders := getAccountOrders(t, "alice"); orders.Count != 0 {
+		t.Errorf("Expected the snapshot's empty book, got %+v", orders.Orders)
+	}
+	if trades := p.listTrades("", timeRange{}); len(trades) != 3 {
+		t.Errorf("Expected the snapshot's trades, got %+v", trades)
+	}
+}
+
+func TestReadModel_SyncStopsAtTheContext(t *testing.T) {
+	setupTest()
+	p := useReadModel(t)
+	m, _ := matcherFor("")
+
+	// A projection busy elsewhere holds up its readers, never the matcher
+	p.state.Lock()
+	placeOn(m, Order{ID: "bid", Symbol: "DEFAULT", Side: SideBuy, Price: 99, Quantity: 1})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := p.sync(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	p.state.Unlock()
+	if err := p.sync(context.Background()); err != nil {
+		t.Errorf("Expected the projection to catch up, got %v", err)
+	}
+}
+
+func TestGetAccountOrdersHandler(t *testing.T) {
+	setupTest()
+	createAccount(CreateAccountRequest{ID: "alice"})
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "bid", Symbol: "DEFAULT", Owner: "alice", Side: SideBuy, Price: 99, Quantity: 1})
+	placeOn(m, Order{ID: "other", Symbol: "DEFAULT", Owner: "bob", Side: SideBuy, Price: 98, Quantity: 1})
+
+	// Without a read model the orders come from the book views
+	if orders := getAccountOrders(t, "alice"); orders.AccountID != "alice" || orders.Count != 1 || orders.Orders[0].ID != "bid" {
+		t.Errorf("Unexpected orders %+v", orders)
+	}
+	response := serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/accounts/nobody/orders", nil))
+	if result := decodeError(t, response); response.Code != http.StatusNotFound || result.Error.Code != ErrCodeAccountNotFound {
+		t.Errorf("Expected 404 ACCOUNT_NOT_FOUND, got %d %s", response.Code, result.Error.Code)
+	}
+	response = serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/trades?from=yesterday", nil))
+	if result := decodeError(t, response); response.Code != http.StatusBadRequest || result.Error.Code != ErrCodeValidationFailed {
+		t.Errorf("Expected a bad time range refused, got %d %s", response.Code, result.Error.Code)
+	}
+}
+
+func TestLoadConfig_ReadModel(t *testing.T) {
+	if cfg, err := loadConfig(nil); err != nil || cfg.ReadModel {
+		t.Fatalf("Expected the read model off by default, got %v (%v)", cfg.ReadModel, err)
+	}
+	if cfg, _ := loadConfig([]string{"-read-model"}); !cfg.ReadModel {
+		t.Error("Expected -read-model to turn it on")
+	}
+}
+
+// BenchmarkReadModel_MatchingUnderReads crosses orders while other goroutines
+// read the account's orders as fast as they can, from the matchers' views or
+// from the read model
+func BenchmarkReadModel_MatchingUnderReads(b *testing.B) {
+	for _, projected := range []bool{false, true} {
+		b.Run(fmt.Sprintf("read_model=%v", projected), func(b *testing.B) {
+			setupTest()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if projected {
+				readModel = startReadModel(ctx)
+				resetSymbols([]string{"DEFAULT"})
+			}
+			createAccount(CreateAccountRequest{ID: "maker"})
+			m, _ := matcherFor("")
+			for i := 0; i < 100; i++ {
+				placeOn(m, Order{ID: fmt.Sprintf("rest-%d", i), Symbol: "DEFAULT", Owner: "maker", Side: SideBuy, Price: 90 - float64(i)*0.01, Quantity: 10})
+			}
+			handler := newServer(HTTPConfig{})
+
+			var readers sync.WaitGroup
+			for i := 0; i < 4; i++ {
+				readers.Add(1)
+				go func() {
+					defer readers.Done()
+					for ctx.Err() == nil {
+						handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/accounts/maker/orders", nil))
+					}
+				}()
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				placeOn(m, Order{ID: fmt.Sprintf("ask-%d", i), Symbol: "DEFAULT", Owner: "maker", Side: SideSell, Price: 100, Quantity: 1})
+				placeOn(m, Order{ID: fmt.Sprintf("bid-%d", i), Symbol: "DEFAULT", Side: SideBuy, Price: 100, Quantity: 1})
+			}
+			b.StopTimer()
+			cancel()
+			readers.Wait()
+		})
+	}
+}
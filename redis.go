@@ -0,0 +1,571 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RedisConfig shares the engine's state through Redis. The matcher process
+// mirrors its books, trades and order events into Redis and publishes every
+// change; reader nodes load that state and follow the changes, serving reads
+// while refusing writes.
+type RedisConfig struct {
+	// Addr is the Redis server's host:port; empty disables Redis
+	Addr     string
+	Password string
+	// Prefix namespaces every key and the change channel
+	Prefix string
+	// Reader follows the state in Redis instead of mirroring into it
+	Reader bool
+}
+
+// redisKeys names the keys one deployment keeps in Redis
+type redisKeys struct {
+	prefix string
+}
+
+// book is a hash holding one symbol's levels as bid:<price> and ask:<price>
+// fields, with its stops, last price and depth sequence
+func (k redisKeys) book(symbol string) string { return k.prefix + ":book:" + symbol }
+
+// trades, orderEvents and closedOrders are lists, oldest first
+func (k redisKeys) trades() string       { return k.prefix + ":trades" }
+func (k redisKeys) orderEvents() string  { return k.prefix + ":order-events" }
+func (k redisKeys) closedOrders() string { return k.prefix + ":closed-orders" }
+
+// meta is a hash holding the symbols, the sequences and when the state was loaded
+func (k redisKeys) meta() string { return k.prefix + ":meta" }
+
+// changes is the channel every replication message is published on
+func (k redisKeys) changes() string { return k.prefix + ":changes" }
+
+// redisError is an error reply from the server
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// errRedisEmpty means no matcher has mirrored its state into Redis yet
+var errRedisEmpty = errors.New("redis holds no engine state yet")
+
+// redisBatchSize caps how many list items go into one RPUSH
+const redisBatchSize = 1000
+
+// redisConn is a connection speaking RESP2, the Redis wire protocol
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+// dialRedis connects to the server in cfg and authenticates if a password is set
+func dialRedis(ctx context.Context, cfg RedisConfig) (*redisConn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+	if cfg.Password != "" {
+		if _, err := c.do("AUTH", cfg.Password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *redisConn) Close() error {
+	return c.conn.Close()
+}
+
+// send buffers one command
+func (c *redisConn) send(args ...string) {
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+}
+
+// receive reads one reply: a string, an int64, nil, a redisError or a []any
+// of replies
+func (c *redisConn) receive() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return redisError(body), nil
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		// A length of -1 is a nil reply
+		size, err := strconv.Atoi(body)
+		if err != nil || size < 0 {
+			return nil, err
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(body)
+		if err != nil || count < 0 {
+			return nil, err
+		}
+		replies := make([]any, count)
+		for i := range replies {
+			if replies[i], err = c.receive(); err != nil {
+				return nil, err
+			}
+		}
+		return replies, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+}
+
+// do sends one command and returns its reply, turning an error reply into an error
+func (c *redisConn) do(args ...string) (any, error) {
+	c.send(args...)
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+	reply, err := c.receive()
+	if err == nil {
+		if e, ok := reply.(redisError); ok {
+			return nil, e
+		}
+	}
+	return reply, err
+}
+
+// transact runs the commands atomically in one MULTI/EXEC round trip and
+// returns their replies
+func (c *redisConn) transact(cmds [][]string) ([]any, error) {
+	c.send("MULTI")
+	for _, cmd := range cmds {
+		c.send(cmd...)
+	}
+	c.send("EXEC")
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	// MULTI and every queued command answer first; a command the server
+	// refused to queue makes EXEC fail too
+	var refused error
+	for range len(cmds) + 1 {
+		reply, err := c.receive()
+		if err != nil {
+			return nil, err
+		}
+		if e, ok := reply.(redisError); ok && refused == nil {
+			refused = e
+		}
+	}
+	reply, err := c.receive()
+	if err != nil {
+		return nil, err
+	}
+	if refused != nil {
+		return nil, refused
+	}
+	replies, ok := reply.([]any)
+	if !ok {
+		return nil, fmt.Errorf("redis: transaction failed: %v", reply)
+	}
+	for _, reply := range replies {
+		if e, ok := reply.(redisError); ok {
+			return nil, e
+		}
+	}
+	return replies, nil
+}
+
+// startRedisMirror keeps Redis in step with the engine until ctx is
+// cancelled, starting over whenever the connection or the stream breaks
+func startRedisMirror(ctx context.Context, cfg RedisConfig) {
+	go func() {
+		for {
+			err := mirrorToRedis(ctx, cfg)
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("redis: %v; reconnecting in %s", err, standbyRetryDelay)
+			select {
+			case <-time.After(standbyRetryDelay):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// mirrorToRedis writes the engine's state into Redis, then writes and
+// publishes every change until the connection breaks or ctx is cancelled
+func mirrorToRedis(ctx context.Context, cfg RedisConfig) error {
+	conn, err := dialRedis(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	// The mirror follows the engine like any replica, so a change is in
+	// Redis only once it has been made
+	subscriber, first := subscribeReplica(replicationBufferSize)
+	defer replication.unsubscribe(subscriber)
+
+	keys := redisKeys{prefix: cfg.Prefix}
+	if err := writeRedisSnapshot(conn, keys, first); err != nil {
+		return err
+	}
+	for {
+		select {
+		case msg := <-subscriber.messages:
+			if _, err := conn.transact(redisChange(keys, msg)); err != nil {
+				return err
+			}
+		case <-subscriber.dropped:
+			return errors.New("fell behind the matchers")
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// writeRedisSnapshot replaces the state in Redis with a snapshot and
+// publishes it, so readers following the channel start over from it
+func writeRedisSnapshot(conn *redisConn, keys redisKeys, msg ReplicationMessage) error {
+	snapshot := msg.Snapshot
+	symbols := make([]string, 0, len(snapshot.Books))
+	for _, book := range snapshot.Books {
+		symbols = append(symbols, book.Symbol)
+	}
+	symbolsJSON, _ := json.Marshal(symbols)
+
+	// Books for symbols this server no longer trades are removed too
+	stale := []string{"DEL", keys.trades(), keys.orderEvents(), keys.closedOrders(), keys.meta()}
+	previous, err := conn.do("HGET", keys.meta(), "symbols")
+	if err != nil {
+		return err
+	}
+	if previous, ok := previous.(string); ok {
+		var old []string
+		json.Unmarshal([]byte(previous), &old)
+		for _, symbol := range old {
+			stale = append(stale, keys.book(symbol))
+		}
+	}
+	for _, symbol := range symbols {
+		stale = append(stale, keys.book(symbol))
+	}
+
+	cmds := [][]string{stale}
+	for _, book := range snapshot.Books {
+		cmd := []string{"HSET", keys.book(book.Symbol),
+			"sequence", strconv.FormatInt(book.Sequence, 10),
+			"last_price", formatRedisFloat(book.LastPrice)}
+		for _, level := range snapshotLevels(book) {
+			orders, _ := json.Marshal(level.Orders)
+			cmd = append(cmd, redisLevelField(level.Side, level.Price), string(orders))
+		}
+		if len(book.Stops) > 0 {
+			stops, _ := json.Marshal(book.Stops)
+			cmd = append(cmd, "stops", string(stops))
+		}
+		cmds = append(cmds, cmd)
+	}
+	cmds = append(cmds, redisPush(keys.trades(), snapshot.Trades)...)
+	cmds = append(cmds, redisPush(keys.orderEvents(), snapshot.OrderEvents)...)
+	cmds = append(cmds, redisPush(keys.closedOrders(), snapshot.ClosedOrders)...)
+
+	published, _ := json.Marshal(msg)
+	cmds = append(cmds,
+		[]string{"HSET", keys.meta(),
+			"default_symbol", snapshot.DefaultSymbol,
+			"symbols", string(symbolsJSON),
+			"sequence", strconv.FormatInt(msg.Sequence, 10),
+			"event_sequence", strconv.Itoa(snapshot.EventSequence),
+			"epoch", snapshot.Epoch,
+			"taken_at", snapshot.TakenAt.Format(time.RFC3339Nano)},
+		[]string{"PUBLISH", keys.changes(), string(published)})
+	_, err = conn.transact(cmds)
+	return err
+}
+
+// redisChange is the commands that write one replication message into Redis
+// and publish it
+func redisChange(keys redisKeys, msg ReplicationMessage) [][]string {
+	var cmds [][]string
+	switch {
+	case msg.Book != nil:
+		change := msg.Book
+		key := keys.book(change.Symbol)
+		for _, level := range change.Levels {
+			field := redisLevelField(level.Side, level.Price)
+			if len(level.Orders) == 0 {
+				cmds = append(cmds, []string{"HDEL", key, field})
+				continue
+			}
+			orders, _ := json.Marshal(level.Orders)
+			cmds = append(cmds, []string{"HSET", key, field, string(orders)})
+		}
+		if change.Stops != nil {
+			stops, _ := json.Marshal(*change.Stops)
+			cmds = append(cmds, []string{"HSET", key, "stops", string(stops)})
+		}
+		// Publishing the changed levels moves the depth sequence on by one
+		sequence := change.Sequence
+		if len(change.Levels) > 0 {
+			sequence++
+		}
+		cmds = append(cmds, []string{"HSET", key,
+			"sequence", strconv.FormatInt(sequence, 10),
+			"last_price", formatRedisFloat(change.LastPrice)})
+	case msg.Trade != nil:
+		cmds = append(cmds, redisPush(keys.trades(), []Trade{*msg.Trade})...)
+	case msg.Closed != nil:
+		cmds = append(cmds, redisPush(keys.closedOrders(), []ClosedOrder{*msg.Closed})...)
+	case msg.Event != nil:
+		cmds = append(cmds, redisPush(keys.orderEvents(), []OrderEvent{*msg.Event})...)
+		cmds = append(cmds, []string{"HSET", keys.meta(), "event_sequence", strconv.Itoa(msg.Event.Sequence)})
+	}
+
+	published, _ := json.Marshal(msg)
+	return append(cmds,
+		[]string{"HSET", keys.meta(), "sequence", strconv.FormatInt(msg.Sequence, 10)},
+		[]string{"PUBLISH", keys.changes(), string(published)})
+}
+
+// redisPush is the RPUSH commands that append items to a list as JSON
+func redisPush[T any](key string, items []T) [][]string {
+	var cmds [][]string
+	for start := 0; start < len(items); start += redisBatchSize {
+		cmd := []string{"RPUSH", key}
+		for _, item := range items[start:min(start+redisBatchSize, len(items))] {
+			data, _ := json.Marshal(item)
+			cmd = append(cmd, string(data))
+		}
+		cmds = append(cmds, cmd)
+	}
+	return cmds
+}
+
+// snapshotLevels groups a saved book's orders by price level
+func snapshotLevels(book BookSnapshot) []LevelOrders {
+	var levels []LevelOrders
+	for _, side := range []struct {
+		side   Side
+		orders []Order
+	}{{SideBuy, book.BuyOrders}, {SideSell, book.SellOrders}} {
+		for _, order := range side.orders {
+			if n := len(levels); n > 0 && levels[n-1].Side == side.side && levels[n-1].Price == order.Price {
+				levels[n-1].Orders = append(levels[n-1].Orders, order)
+				continue
+			}
+			levels = append(levels, LevelOrders{Side: side.side, Price: order.Price, Orders: []Order{order}})
+		}
+	}
+	return levels
+}
+
+// redisLevelField names a price level's field in a book hash
+func redisLevelField(side Side, price float64) string {
+	if side == SideBuy {
+		return "bid:" + formatRedisFloat(price)
+	}
+	return "ask:" + formatRedisFloat(price)
+}
+
+func formatRedisFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// startRedisReader runs the server as a read-only node following the state
+// the matcher keeps in Redis
+func startRedisReader(cfg RedisConfig) {
+	startFollowing("redis://"+cfg.Addr, func(ctx context.Context) error {
+		return followRedis(ctx, cfg)
+	})
+}
+
+// followRedis loads the state from Redis and applies the published changes
+// until the connection breaks or ctx is cancelled
+func followRedis(ctx context.Context, cfg RedisConfig) error {
+	keys := redisKeys{prefix: cfg.Prefix}
+
+	// Subscribe before loading, so no change falls between the two; changes
+	// the loaded state already holds are skipped
+	changes, err := dialRedis(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer changes.Close()
+	stop := context.AfterFunc(ctx, func() { changes.Close() })
+	defer stop()
+	if _, err := changes.do("SUBSCRIBE", keys.changes()); err != nil {
+		return err
+	}
+
+	conn, err := dialRedis(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	snapshot, sequence, err := loadRedisSnapshot(conn, keys)
+	conn.Close()
+	if err != nil {
+		return err
+	}
+
+	return followStream(snapshot, sequence, func() (ReplicationMessage, error) {
+		for {
+			reply, err := changes.receive()
+			if err != nil {
+				return ReplicationMessage{}, err
+			}
+			// Pushes are ["message", channel, payload]
+			push, ok := reply.([]any)
+			if !ok || len(push) != 3 || push[0] != "message" {
+				continue
+			}
+			payload, _ := push[2].(string)
+			var msg ReplicationMessage
+			err = json.Unmarshal([]byte(payload), &msg)
+			return msg, err
+		}
+	})
+}
+
+// loadRedisSnapshot reads the state in Redis as a snapshot and returns the
+// sequence of the last change it holds
+func loadRedisSnapshot(conn *redisConn, keys redisKeys) (Snapshot, int64, error) {
+	reply, err := conn.do("HGET", keys.meta(), "symbols")
+	if err != nil {
+		return Snapshot{}, 0, err
+	}
+	symbolsJSON, ok := reply.(string)
+	if !ok {
+		return Snapshot{}, 0, errRedisEmpty
+	}
+	var symbols []string
+	if err := json.Unmarshal([]byte(symbolsJSON), &symbols); err != nil {
+		return Snapshot{}, 0, err
+	}
+
+	cmds := [][]string{{"HGETALL", keys.meta()}}
+	for _, symbol := range symbols {
+		cmds = append(cmds, []string{"HGETALL", keys.book(symbol)})
+	}
+	cmds = append(cmds,
+		[]string{"LRANGE", keys.trades(), "0", "-1"},
+		[]string{"LRANGE", keys.orderEvents(), "0", "-1"},
+		[]string{"LRANGE", keys.closedOrders(), "0", "-1"})
+	replies, err := conn.transact(cmds)
+	if err != nil {
+		return Snapshot{}, 0, err
+	}
+
+	meta := redisHash(replies[0])
+	if meta["symbols"] != symbolsJSON {
+		return Snapshot{}, 0, errors.New("the symbols in redis changed while loading")
+	}
+	sequence, _ := strconv.ParseInt(meta["sequence"], 10, 64)
+	snapshot := Snapshot{Version: snapshotVersion, DefaultSymbol: meta["default_symbol"], Epoch: meta["epoch"]}
+	snapshot.TakenAt, _ = time.Parse(time.RFC3339Nano, meta["taken_at"])
+	snapshot.EventSequence, _ = strconv.Atoi(meta["event_sequence"])
+
+	for i, symbol := range symbols {
+		book, err := redisBook(symbol, redisHash(replies[i+1]))
+		if err != nil {
+			return Snapshot{}, 0, err
+		}
+		snapshot.Books = append(snapshot.Books, book)
+	}
+	if snapshot.Trades, err = redisList[Trade](replies[len(symbols)+1]); err != nil {
+		return Snapshot{}, 0, err
+	}
+	if snapshot.OrderEvents, err = redisList[OrderEvent](replies[len(symbols)+2]); err != nil {
+		return Snapshot{}, 0, err
+	}
+	if snapshot.ClosedOrders, err = redisList[ClosedOrder](replies[len(symbols)+3]); err != nil {
+		return Snapshot{}, 0, err
+	}
+	return snapshot, sequence, nil
+}
+
+// redisBook decodes a book hash
+func redisBook(symbol string, fields map[string]string) (BookSnapshot, error) {
+	book := BookSnapshot{Symbol: symbol}
+	book.Sequence, _ = strconv.ParseInt(fields["sequence"], 10, 64)
+	book.LastPrice, _ = strconv.ParseFloat(fields["last_price"], 64)
+	if stops := fields["stops"]; stops != "" {
+		if err := json.Unmarshal([]byte(stops), &book.Stops); err != nil {
+			return BookSnapshot{}, fmt.Errorf("%s stops: %w", symbol, err)
+		}
+	}
+	for field, value := range fields {
+		side, _, ok := strings.Cut(field, ":")
+		if !ok {
+			continue
+		}
+		var orders []Order
+		if err := json.Unmarshal([]byte(value), &orders); err != nil {
+			return BookSnapshot{}, fmt.Errorf("%s %s: %w", symbol, field, err)
+		}
+		if side == "bid" {
+			book.BuyOrders = append(book.BuyOrders, orders...)
+		} else {
+			book.SellOrders = append(book.SellOrders, orders...)
+		}
+	}
+	// Levels come back in no particular order; the orders within each are
+	// already in priority order, which the stable sort keeps
+	sortSide(book.BuyOrders, compareBids)
+	sortSide(book.SellOrders, compareAsks)
+	return book, nil
+}
+
+// redisHash turns an HGETALL reply into a map
+func redisHash(reply any) map[string]string {
+	items, _ := reply.([]any)
+	fields := make(map[string]string, len(items)/2)
+	for i := 0; i+1 < len(items); i += 2 {
+		field, _ := items[i].(string)
+		value, _ := items[i+1].(string)
+		fields[field] = value
+	}
+	return fields
+}
+
+// redisList decodes an LRANGE reply of JSON items
+func redisList[T any](reply any) ([]T, error) {
+	items, _ := reply.([]any)
+	list := make([]T, 0, len(items))
+	for _, item := range items {
+		data, _ := item.(string)
+		var value T
+		if err := json.Unmarshal([]byte(data), &value); err != nil {
+			return nil, err
+		}
+		list = append(list, value)
+	}
+	return list, nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// testRedisKeys is the key layout under the default prefix
+var testRedisKeys = redisKeys{prefix: "valhalla"}
+
+// waitUntil polls cond for up to a second
+func waitUntil(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// mirrorInto mirrors the engine into a Redis server until the returned
+// function is called, which waits for the mirror to catch up and stop
+func mirrorInto(t *testing.T, server *miniredis.Miniredis) func() {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		mirrorToRedis(ctx, RedisConfig{Addr: server.Addr(), Prefix: "valhalla"})
+	}()
+	waitUntil(t, "the mirror to subscribe", func() bool { return server.HGet(testRedisKeys.meta(), "sequence") != "" })
+
+	return func() {
+		t.Helper()
+		sequence, _ := replication.status()
+		waitUntil(t, "the mirror to catch up", func() bool {
+			return server.HGet(testRedisKeys.meta(), "sequence") == strconv.FormatInt(sequence, 10)
+		})
+		cancel()
+		<-done
+	}
+}
+
+// buildSharedBook trades, rests orders and sets a trailing stop
+func buildSharedBook(t *testing.T) {
+	t.Helper()
+	m, _ := matcherFor("")
+	tradeAt(t, 100.0)
+	placeOn(m, Order{ID: "bid-1", Side: SideBuy, Price: 99.0, Quantity: 4})
+	placeOn(m, Order{ID: "bid-2", Side: SideBuy, Price: 99.0, Quantity: 3})
+	placeOn(m, Order{ID: "bid-3", Side: SideBuy, Price: 98.5, Quantity: 2})
+	placeOn(m, Order{ID: "ask-1", Side: SideSell, Price: 101.0, Quantity: 8})
+	placeOn(m, Order{ID: "sell-1", Side: SideSell, Price: 99.0, Quantity: 5})
+	m.do(func() { cancelOrder("", "bid-3") })
+	m.do(func() { processOrder(trailingStop("stop-1", SideSell, 1, 2.0, 0)) })
+}
+
+func TestRedisMirror_KeepsTheSharedStateInStep(t *testing.T) {
+	setupTest()
+	server := miniredis.RunT(t)
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "early", Side: SideSell, Price: 105.0, Quantity: 1})
+
+	// State from before the mirror started and changes made after it both land
+	stop := mirrorInto(t, server)
+	buildSharedBook(t)
+	want := captureBookState()
+	stop()
+
+	key := testRedisKeys.book("DEFAULT")
+	var level []Order
+	json.Unmarshal([]byte(server.HGet(key, "bid:99")), &level)
+	if len(level) != 1 || level[0].ID != "bid-2" || level[0].Quantity != 2 {
+		t.Errorf("Expected the 99.00 level to hold the rest of bid-2, got %+v", level)
+	}
+	if server.HGet(key, "bid:98.5") != "" {
+		t.Errorf("Expected the cancelled level to be removed")
+	}
+	if trades, _ := server.List(testRedisKeys.trades()); len(trades) != len(want.Trades) {
+		t.Errorf("Expected %d trades in Redis, got %d", len(want.Trades), len(trades))
+	}
+
+	conn, err := dialRedis(context.Background(), RedisConfig{Addr: server.Addr()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	snapshot, _, err := loadRedisSnapshot(conn, testRedisKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setupTest()
+	restoreSnapshot(snapshot)
+	got := captureBookState()
+	wantJSON, _ := json.Marshal(want)
+	gotJSON, _ := json.Marshal(got)
+	if !bytes.Equal(wantJSON, gotJSON) {
+		t.Errorf("Expected Redis to hold the matcher's state:\nwant %s\ngot  %s", wantJSON, gotJSON)
+	}
+}
+
+func TestRedisReader_ServesTheSharedBook(t *testing.T) {
+	setupTest()
+	server := miniredis.RunT(t)
+	stop := mirrorInto(t, server)
+	buildSharedBook(t)
+	want := collectOrders("")
+	stop()
+	sequence, _ := strconv.ParseInt(server.HGet(testRedisKeys.meta(), "sequence"), 10, 64)
+
+	setupTest()
+	startRedisReader(RedisConfig{Addr: server.Addr(), Prefix: "valhalla"})
+	t.Cleanup(func() { promote() })
+	waitUntil(t, "the reader to load", func() bool { return replicationStatus().Connected })
+
+	if got := collectOrders(""); len(got) != len(want) || got[0].ID != want[0].ID {
+		t.Errorf("Expected the shared orders, got %+v", got)
+	}
+	order := `{"side":"buy","price":99,"quantity":1}`
+	response := serve(HTTPConfig{}, httptest.NewRequest("POST", "/api/v1/orders", strings.NewReader(order)))
+	if result := decodeError(t, response); response.Code != http.StatusServiceUnavailable || result.Error.Code != ErrCodeStandby {
+		t.Errorf("Expected a reader to refuse orders, got %d %s", response.Code, result.Error.Code)
+	}
+
+	// Changes published by the matcher are applied as they arrive
+	trade := Trade{ID: "trade-new", Symbol: "DEFAULT", Price: 99.0, Quantity: 1, CreatedAt: time.Now()}
+	published, _ := json.Marshal(ReplicationMessage{Sequence: sequence + 1, Trade: &trade})
+	server.Publish(testRedisKeys.changes(), string(published))
+	waitUntil(t, "the published trade", func() bool {
+		_, ok := tradeStore.Get("trade-new")
+		return ok
+	})
+	if status := replicationStatus(); status.Role != "standby" || status.Primary != "redis://"+server.Addr() || status.Sequence != sequence+1 {
+		t.Errorf("Unexpected reader status %+v", status)
+	}
+}
+
+func TestRedisReader_WaitsForAMatcher(t *testing.T) {
+	setupTest()
+	previous := standbyRetryDelay
+	standbyRetryDelay = time.Millisecond
+	t.Cleanup(func() { standbyRetryDelay = previous })
+
+	server := miniredis.RunT(t)
+	startRedisReader(RedisConfig{Addr: server.Addr(), Prefix: "valhalla"})
+	t.Cleanup(func() { promote() })
+
+	waitUntil(t, "the reader to report the empty store", func() bool {
+		return replicationStatus().LastError == errRedisEmpty.Error()
+	})
+}
+
+func TestLoadConfig_RedisFlags(t *testing.T) {
+	cfg, err := loadConfig([]string{"-redis-addr", "redis:6379", "-redis-prefix", "lob", "-redis-reader"})
+	if err != nil || cfg.Redis.Addr != "redis:6379" || cfg.Redis.Prefix != "lob" || !cfg.Redis.Reader {
+		t.Errorf("Unexpected Redis config %+v (%v)", cfg.Redis, err)
+	}
+	for _, args := range [][]string{
+		{"-redis-reader"},
+		{"-redis-addr", "redis:6379", "-redis-reader", "-replicate-from", "http://primary:8080"},
+		{"-redis-addr", "redis:6379", "-redis-reader", "-bots"},
+	} {
+		if _, err := loadConfig(args); err == nil {
+			t.Errorf("Expected %v to be refused", args)
+		}
+	}
+}
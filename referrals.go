@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// SettlementHook is shown each fee a settled trade charged and can share it
+// out to other accounts. The shares are paid out of the fee account.
+type SettlementHook interface {
+	FeeShares(fee SettledFee) []FeeShare
+}
+
+// SettlementHookFunc adapts an ordinary function to the SettlementHook interface
+type SettlementHookFunc func(fee SettledFee) []FeeShare
+
+func (f SettlementHookFunc) FeeShares(fee SettledFee) []FeeShare {
+	return f(fee)
+}
+
+// SettledFee is the fee one side of a settled trade paid
+type SettledFee struct {
+	TradeID   string    `json:"trade_id"`
+	Symbol    string    `json:"symbol"`
+	AccountID string    `json:"account_id"`
+	Liquidity Liquidity `json:"liquidity"`
+	Asset     string    `json:"asset"`
+	Amount    float64   `json:"amount"`
+}
+
+// FeeShare is part of a fee a settlement hook pays to another account
+type FeeShare struct {
+	AccountID string  `json:"account_id"`
+	Amount    float64 `json:"amount"`
+}
+
+// FeePayout is a fee share the fee account paid out
+type FeePayout struct {
+	TradeID string `json:"trade_id"`
+	// From is the account whose fee was shared, and To the one paid
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Asset  string    `json:"asset"`
+	Fee    float64   `json:"fee"`
+	Amount float64   `json:"amount"`
+	At     time.Time `json:"at"`
+}
+
+// ReferredAccount is one account a referrer brought in and the commission it earned
+type ReferredAccount struct {
+	AccountID  string             `json:"account_id"`
+	CreatedAt  time.Time          `json:"created_at"`
+	Commission map[string]float64 `json:"commission"`
+	Trades     int                `json:"trades"`
+}
+
+// ReferralReport is what an account has earned from the accounts it referred
+type ReferralReport struct {
+	AccountID string `json:"account_id"`
+	// ReferrerID is who referred the account itself
+	ReferrerID string `json:"referrer_id,omitempty"`
+	// Share is the fraction of their fees the referred accounts pass on
+	Share      float64            `json:"share"`
+	Referred   []ReferredAccount  `json:"referred"`
+	Commission map[string]float64 `json:"commission"`
+	Payouts    []FeePayout        `json:"payouts"`
+	Count      int                `json:"count"`
+}
+
+// settlementHook sees every fee a settled trade charges; nil keeps every fee
+// with the venue. It is called with accountsMu held, so it must not call
+// anything that takes it.
+var settlementHook SettlementHook
+
+// referralShare is the fraction of fees the referral hook pays referrers.
+// feePayouts is guarded by accountsMu.
+var (
+	referralShare float64
+	feePayouts    []FeePayout
+)
+
+// referralHook pays an account's referrer share of every fee the account pays
+func referralHook(share float64) SettlementHook {
+	return SettlementHookFunc(func(fee SettledFee) []FeeShare {
+		account, ok := accounts[fee.AccountID]
+		if !ok || account.ReferrerID == "" {
+			return nil
+		}
+		return []FeeShare{{AccountID: account.ReferrerID, Amount: fee.Amount * share}}
+	})
+}
+
+// shareFeeLocked offers a charged fee to the settlement hook and pays out the
+// shares it asks for, up to the whole fee, to accounts that exist. It must be
+// called with accountsMu held.
+func shareFeeLocked(fee SettledFee) {
+	if settlementHook == nil || fee.Amount <= 0 {
+		return
+	}
+	left := fee.Amount
+	var payouts []posting
+	for _, share := range settlementHook.FeeShares(fee) {
+		amount := math.Min(share.Amount, left)
+		if _, ok := accounts[share.AccountID]; !ok || amount <= 0 {
+			if amount > 0 {
+				log.Printf("settlement: dropping a fee share for unknown account %q", share.AccountID)
+			}
+			continue
+		}
+		left -= amount
+		payouts = append(payouts,
+			posting{account: feeAccount, asset: fee.Asset, amount: -amount},
+			posting{account: share.AccountID, asset: fee.Asset, amount: amount})
+		feePayouts = append(feePayouts, FeePayout{
+			TradeID: fee.TradeID,
+			From:    fee.AccountID,
+			To:      share.AccountID,
+			Asset:   fee.Asset,
+			Fee:     fee.Amount,
+			Amount:  amount,
+			At:      engineClock.Now(),
+		})
+	}
+	if len(payouts) > 0 {
+		postLocked(BalanceCommission, fee.TradeID, payouts)
+	}
+}
+
+// accountReferrals reports what an account has earned as a referrer, or
+// returns false if there is no such account
+func accountReferrals(id string) (ReferralReport, bool) {
+	accountsMu.Lock()
+	defer accountsMu.Unlock()
+
+	account, ok := accounts[id]
+	if !ok {
+		return ReferralReport{}, false
+	}
+	report := ReferralReport{
+		AccountID:  id,
+		ReferrerID: account.ReferrerID,
+		Share:      referralShare,
+		Referred:   make([]ReferredAccount, 0),
+		Commission: make(map[string]float64),
+		Payouts:    make([]FeePayout, 0),
+	}
+	referred := make(map[string]*ReferredAccount)
+	for _, other := range accounts {
+		if other.ReferrerID == id {
+			referred[other.ID] = &ReferredAccount{AccountID: other.ID, CreatedAt: other.CreatedAt, Commission: make(map[string]float64)}
+		}
+	}
+	for _, payout := range feePayouts {
+		if payout.To != id {
+			continue
+		}
+		report.Commission[payout.Asset] += payout.Amount
+		report.Payouts = append(report.Payouts, payout)
+		if from, ok := referred[payout.From]; ok {
+			from.Commission[payout.Asset] += payout.Amount
+			from.Trades++
+		}
+	}
+	for _, from := range referred {
+		report.Referred = append(report.Referred, *from)
+	}
+	sort.Slice(report.Referred, func(i, j int) bool {
+		if !report.Referred[i].CreatedAt.Equal(report.Referred[j].CreatedAt) {
+			return report.Referred[i].CreatedAt.Before(report.Referred[j].CreatedAt)
+		}
+		return report.Referred[i].AccountID < report.Referred[j].AccountID
+	})
+	report.Count = len(report.Payouts)
+	return report, true
+}
+
+// getReferralsHandler reports the referral commission of the account named in the path
+func getReferralsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id := r.PathValue("id")
+	report, ok := accountReferrals(id)
+	if !ok {
+		writeAccountNotFound(w, id)
+		return
+	}
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReferralHook_PaysReferrersAShareOfFees(t *testing.T) {
+	setupTest()
+	resetSymbols([]string{"BTC-USD"})
+	fees = FeeSchedule{MakerBps: 10, TakerBps: 20}
+	referralShare = 0.25
+	settlementHook = referralHook(referralShare)
+
+	openFunded(t, "bob", nil)
+	for body, status := range map[string]int{
+		`{"id": "alice", "referrer_id": "bob"}`:   http.StatusCreated,
+		`{"id": "erin", "referrer_id": "nobody"}`: http.StatusNotFound,
+	} {
+		if result := serve(HTTPConfig{}, httptest.NewRequest("POST", "/api/v1/accounts", bytes.NewBufferString(body))); result.Code != status {
+			t.Fatalf("%s: expected %d, got %d", body, status, result.Code)
+		}
+	}
+	adjustBalance("alice", "USD", 1000, BalanceDeposit)
+	openFunded(t, "carol", map[string]float64{"BTC": 5})
+
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "ask", Symbol: "BTC-USD", Side: SideSell, Price: 100.0, Quantity: 2, Owner: "carol"})
+	placeOn(m, Order{ID: "buy", Symbol: "BTC-USD", Side: SideBuy, Price: 100.0, Quantity: 2, Owner: "alice"})
+
+	var report ReferralReport
+	json.NewDecoder(serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/accounts/bob/referrals", nil)).Body).Decode(&report)
+	if !approxEqual(report.Commission["USD"], 0.1) || report.Count != 1 || report.Payouts[0].From != "alice" || !approxEqual(report.Payouts[0].Fee, 0.4) {
+		t.Fatalf("Expected a quarter of alice's taker fee, got %+v", report)
+	}
+	if len(report.Referred) != 1 || report.Referred[0].AccountID != "alice" || report.Referred[0].Trades != 1 {
+		t.Errorf("Expected alice listed as referred, got %+v", report.Referred)
+	}
+
+	history, _ := balanceHistory("bob", "USD")
+	if len(history) != 1 || history[0].Type != BalanceCommission || history[0].Reference != report.Payouts[0].TradeID {
+		t.Errorf("Expected a commission referencing the trade, got %+v", history)
+	}
+	accountsMu.Lock()
+	kept, check := ledgerBalances[feeAccount]["USD"], checkLedgerLocked()
+	accountsMu.Unlock()
+	if !approxEqual(kept, 0.5) || !check.Balanced {
+		t.Errorf("Expected the fee account to keep 0.50 USD in a balanced ledger, got %g, %+v", kept, check)
+	}
+	if result := serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/accounts/nobody/referrals", nil)); result.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown account, got %d", result.Code)
+	}
+}
+
+func TestSettlementHook_SharesAreCappedAtTheFee(t *testing.T) {
+	setupTest()
+	resetSymbols([]string{"BTC-USD"})
+	fees = FeeSchedule{TakerBps: 10}
+	settlementHook = SettlementHookFunc(func(fee SettledFee) []FeeShare {
+		return []FeeShare{{AccountID: "nobody", Amount: 1}, {AccountID: "partner", Amount: 1000}, {AccountID: "other", Amount: 1}}
+	})
+	openFunded(t, "partner", nil)
+	openFunded(t, "other", nil)
+	openFunded(t, "maker", map[string]float64{"BTC": 1})
+	openFunded(t, "taker", map[string]float64{"USD": 1000})
+
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "ask", Symbol: "BTC-USD", Side: SideSell, Price: 100.0, Quantity: 1, Owner: "maker"})
+	placeOn(m, Order{ID: "buy", Symbol: "BTC-USD", Side: SideBuy, Price: 100.0, Quantity: 1, Owner: "taker"})
+
+	partner, _ := lookupAccount("partner")
+	other, _ := lookupAccount("other")
+	if !approxEqual(partner.Balances["USD"], 0.1) || other.Balances["USD"] != 0 {
+		t.Errorf("Expected the partner paid the whole fee and nothing left for others, got %+v and %+v", partner.Balances, other.Balances)
+	}
+}
+
+func TestLoadConfig_ReferralShare(t *testing.T) {
+	if cfg, err := loadConfig([]string{"-referral-share", "0.2"}); err != nil || cfg.ReferralShare != 0.2 {
+		t.Errorf("Unexpected referral share %g (%v)", cfg.ReferralShare, err)
+	}
+	if _, err := loadConfig([]string{"-referral-share", "1.5"}); err == nil {
+		t.Error("Expected an error for a share above 1")
+	}
+}
@@ -0,0 +1,573 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// replayOwner owns the orders a market data replay rests in the books
+const replayOwner = "replay"
+
+// Replay formats -replay-format takes
+const (
+	ReplayITCH = "itch"
+	ReplayPcap = "pcap"
+	ReplayCSV  = "csv"
+)
+
+// ReplayConfig is a historical market data file replayed through the books
+type ReplayConfig struct {
+	Path string
+	// Format is itch, pcap or csv; empty picks it from the file's extension
+	Format string
+	// Speed is how many times faster than it was recorded the file replays;
+	// 0 replays it as fast as the engine takes it
+	Speed float64
+}
+
+// ReplayStatus is how far a replay has got
+type ReplayStatus struct {
+	Path    string  `json:"path,omitempty"`
+	Format  string  `json:"format,omitempty"`
+	Speed   float64 `json:"speed"`
+	Running bool    `json:"running"`
+	// Events counts the events replayed, and Skipped those for symbols the
+	// engine does not trade or orders it does not hold
+	Events  int64 `json:"events"`
+	Skipped int64 `json:"skipped"`
+	// At is the recorded time of the last event replayed
+	At         *time.Time `json:"at,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// replayKind is what a replayed event does to an order
+type replayKind string
+
+const (
+	replayAdd     replayKind = "add"
+	replayExecute replayKind = "execute"
+	replayCancel  replayKind = "cancel"
+	replayDelete  replayKind = "delete"
+	replayReplace replayKind = "replace"
+)
+
+// replayEvent is one order event read from a market data file. Events after
+// an add may leave out the symbol, which the replay remembers by order.
+type replayEvent struct {
+	at       time.Time
+	kind     replayKind
+	symbol   string
+	order    string
+	newOrder string
+	side     Side
+	price    float64
+	quantity int
+}
+
+// replayReader returns a file's events in order, then io.EOF
+type replayReader func() (replayEvent, error)
+
+// replay holds the status of the replay running, if any
+var replay struct {
+	mu     sync.Mutex
+	status ReplayStatus
+}
+
+// replayFormat is the format a replay file is read in
+func replayFormat(cfg ReplayConfig) string {
+	if cfg.Format != "" {
+		return strings.ToLower(cfg.Format)
+	}
+	switch strings.ToLower(filepath.Ext(cfg.Path)) {
+	case ".csv":
+		return ReplayCSV
+	case ".pcap", ".cap":
+		return ReplayPcap
+	}
+	return ReplayITCH
+}
+
+// checkReplay makes sure a replay file can be read in its format
+func checkReplay(cfg ReplayConfig) error {
+	if cfg.Path == "" {
+		return nil
+	}
+	switch replayFormat(cfg) {
+	case ReplayITCH, ReplayPcap, ReplayCSV:
+	default:
+		return fmt.Errorf("-replay-format must be itch, pcap or csv (received: %q)", cfg.Format)
+	}
+	if cfg.Speed < 0 {
+		return errors.New("-replay-speed must not be negative")
+	}
+	if _, err := os.Stat(cfg.Path); err != nil {
+		return fmt.Errorf("-replay: %v", err)
+	}
+	return nil
+}
+
+// newReplayReader reads a replay file's events in the given format
+func newReplayReader(format string, r io.Reader) (replayReader, error) {
+	switch format {
+	case ReplayITCH:
+		return newITCHReader(r), nil
+	case ReplayPcap:
+		return newPcapReader(r)
+	case ReplayCSV:
+		return newReplayCSVReader(r)
+	}
+	return nil, fmt.Errorf("unknown replay format %q", format)
+}
+
+// startReplay replays the configured file in the background until it ends or
+// ctx is cancelled
+func startReplay(ctx context.Context, cfg ReplayConfig) {
+	if cfg.Path == "" {
+		return
+	}
+	format := replayFormat(cfg)
+	replay.mu.Lock()
+	replay.status = ReplayStatus{Path: cfg.Path, Format: format, Speed: cfg.Speed}
+	replay.mu.Unlock()
+
+	file, err := os.Open(cfg.Path)
+	if err != nil {
+		finishReplay(err)
+		return
+	}
+	go func() {
+		defer file.Close()
+		next, err := newReplayReader(format, bufio.NewReaderSize(file, 1<<16))
+		if err == nil {
+			log.Printf("replay: replaying %s as %s at %gx", cfg.Path, format, cfg.Speed)
+			err = runReplay(ctx, next, cfg.Speed)
+		}
+		finishReplay(err)
+	}()
+}
+
+// runReplay applies a file's events to the books, waiting out the recorded
+// gaps between them divided by speed
+func runReplay(ctx context.Context, next replayReader, speed float64) error {
+	started := time.Now()
+	replay.mu.Lock()
+	replay.status.Running, replay.status.StartedAt = true, &started
+	replay.mu.Unlock()
+
+	symbols := make(map[string]string)
+	var first time.Time
+	for count := 0; ; count++ {
+		event, err := next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if count == 0 {
+			first = event.at
+		}
+		if speed > 0 {
+			due := started.Add(time.Duration(float64(event.at.Sub(first)) / speed))
+			if wait := time.Until(due); wait > 0 {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(wait):
+				}
+			}
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if event.symbol == "" {
+			event.symbol = symbols[event.order]
+		}
+		applied := false
+		if m, ok := matcherFor(event.symbol); ok {
+			event.symbol = m.symbol
+			m.do(func() { applied = applyReplayEvent(m.book, event) })
+		}
+		switch event.kind {
+		case replayAdd:
+			symbols[event.order] = event.symbol
+		case replayDelete:
+			delete(symbols, event.order)
+		case replayReplace:
+			symbols[event.newOrder] = event.symbol
+			delete(symbols, event.order)
+		}
+
+		at := event.at
+		replay.mu.Lock()
+		if applied {
+			replay.status.Events++
+		} else {
+			replay.status.Skipped++
+		}
+		replay.status.At = &at
+		replay.mu.Unlock()
+	}
+}
+
+// finishReplay notes that the replay has ended, and why if it failed
+func finishReplay(err error) {
+	finished := time.Now()
+	replay.mu.Lock()
+	defer replay.mu.Unlock()
+	replay.status.Running, replay.status.FinishedAt = false, &finished
+	if err != nil && !errors.Is(err, context.Canceled) {
+		replay.status.Error = err.Error()
+		log.Printf("replay: %v", err)
+		return
+	}
+	log.Printf("replay: finished after %d events, %d skipped", replay.status.Events, replay.status.Skipped)
+}
+
+// replayOrderID is the engine's ID for an order in a replayed file
+func replayOrderID(order string) string {
+	return replayOwner + "-" + order
+}
+
+// applyReplayEvent replays one event as the orders and cancels that have the
+// same effect on the book, so its trades and feeds come out of matching as
+// they would live. An execution sends an IOC order against the executed
+// order's price. It reports whether the book had what the event acts on. It
+// must run on the book's matcher.
+func applyReplayEvent(book *OrderBook, event replayEvent) bool {
+	if event.kind == replayAdd {
+		placeReplayOrder(event.symbol, event.order, event.side, event.price, event.quantity, replayOwner)
+		return true
+	}
+
+	resting, ok := bookedOrder(book, replayOrderID(event.order))
+	if !ok {
+		return false
+	}
+	switch event.kind {
+	case replayExecute:
+		side := SideBuy
+		if resting.Side == SideBuy {
+			side = SideSell
+		}
+		placeReplayOrder(event.symbol, "", side, resting.Price, min(event.quantity, resting.Quantity), "")
+	case replayCancel:
+		if left := resting.Quantity - event.quantity; left > 0 {
+			amendQuantity(book, resting, left, "replay")
+		} else {
+			cancelOrder(event.symbol, resting.ID)
+		}
+	case replayDelete:
+		cancelOrder(event.symbol, resting.ID)
+	case replayReplace:
+		cancelOrder(event.symbol, resting.ID)
+		placeReplayOrder(event.symbol, event.newOrder, resting.Side, event.price, event.quantity, replayOwner)
+	}
+	return true
+}
+
+// placeReplayOrder processes a replayed order. One with no ID in the file is
+// an execution's IOC, which never rests.
+func placeReplayOrder(symbol, order string, side Side, price float64, quantity int, owner string) {
+	if quantity <= 0 || price <= 0 {
+		return
+	}
+	placed := Order{
+		ID:        generateOrderID(),
+		Symbol:    symbol,
+		Side:      side,
+		Price:     price,
+		Quantity:  quantity,
+		Status:    OrderStatusPending,
+		CreatedAt: engineClock.Now(),
+		Owner:     owner,
+	}
+	if order != "" {
+		placed.ID = replayOrderID(order)
+	} else {
+		placed.TimeInForce = TimeInForceIOC
+	}
+	processOrder(placed)
+}
+
+// ITCH 5.0 message lengths, type byte included, for the messages a replay
+// reads; every message starts with a stock locate, tracking number and a
+// 6-byte timestamp in nanoseconds since midnight
+var itchLengths = map[byte]int{'A': 36, 'F': 40, 'E': 31, 'C': 36, 'X': 23, 'D': 19, 'U': 35}
+
+// decodeITCH reads the order event in one ITCH 5.0 message, reporting false
+// for the message types a replay has no use for
+func decodeITCH(msg []byte) (replayEvent, bool, error) {
+	if len(msg) == 0 {
+		return replayEvent{}, false, nil
+	}
+	length, ok := itchLengths[msg[0]]
+	if !ok {
+		return replayEvent{}, false, nil
+	}
+	if len(msg) < length {
+		return replayEvent{}, false, fmt.Errorf("ITCH %q message is %d bytes, want %d", msg[0], len(msg), length)
+	}
+
+	nanos := uint64(msg[5])<<40 | uint64(binary.BigEndian.Uint32(msg[6:10]))<<8 | uint64(msg[10])
+	event := replayEvent{
+		at:    time.Time{}.Add(time.Duration(nanos)),
+		order: strconv.FormatUint(binary.BigEndian.Uint64(msg[11:19]), 10),
+	}
+	switch msg[0] {
+	case 'A', 'F':
+		event.kind, event.side = replayAdd, SideBuy
+		if msg[19] == 'S' {
+			event.side = SideSell
+		}
+		event.quantity = int(binary.BigEndian.Uint32(msg[20:24]))
+		event.symbol = strings.TrimSpace(string(msg[24:32]))
+		event.price = float64(binary.BigEndian.Uint32(msg[32:36])) / 10000
+	case 'E', 'C':
+		event.kind, event.quantity = replayExecute, int(binary.BigEndian.Uint32(msg[19:23]))
+	case 'X':
+		event.kind, event.quantity = replayCancel, int(binary.BigEndian.Uint32(msg[19:23]))
+	case 'D':
+		event.kind = replayDelete
+	case 'U':
+		event.kind = replayReplace
+		event.newOrder = strconv.FormatUint(binary.BigEndian.Uint64(msg[19:27]), 10)
+		event.quantity = int(binary.BigEndian.Uint32(msg[27:31]))
+		event.price = float64(binary.BigEndian.Uint32(msg[31:35])) / 10000
+	}
+	return event, true, nil
+}
+
+// newITCHReader reads ITCH 5.0 messages each framed by a 2-byte big-endian
+// length, as NASDAQ publishes its daily files
+func newITCHReader(r io.Reader) replayReader {
+	var header [2]byte
+	buf := make([]byte, 64)
+	return func() (replayEvent, error) {
+		for {
+			if _, err := io.ReadFull(r, header[:]); err != nil {
+				if err == io.ErrUnexpectedEOF {
+					return replayEvent{}, errors.New("ITCH file ends partway through a message")
+				}
+				return replayEvent{}, err
+			}
+			length := int(binary.BigEndian.Uint16(header[:]))
+			if length > len(buf) {
+				buf = make([]byte, length)
+			}
+			if _, err := io.ReadFull(r, buf[:length]); err != nil {
+				return replayEvent{}, errors.New("ITCH file ends partway through a message")
+			}
+			event, ok, err := decodeITCH(buf[:length])
+			if ok || err != nil {
+				return event, err
+			}
+		}
+	}
+}
+
+// newPcapReader reads ITCH 5.0 messages from a libpcap capture of MoldUDP64
+// packets over UDP, IPv4 and Ethernet, as NASDAQ's TotalView feed sends them.
+// Packets that are not UDP, and MoldUDP64 heartbeats, are passed over.
+func newPcapReader(r io.Reader) (replayReader, error) {
+	var header [24]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, errors.New("pcap file has no global header")
+	}
+	var order binary.ByteOrder
+	switch magic := binary.LittleEndian.Uint32(header[:4]); magic {
+	case 0xa1b2c3d4, 0xa1b23c4d:
+		order = binary.LittleEndian
+	case 0xd4c3b2a1, 0x4d3cb2a1:
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("not a pcap file (magic %#x); pcapng is not supported", magic)
+	}
+	if link := order.Uint32(header[20:24]); link != 1 {
+		return nil, fmt.Errorf("pcap link type %d is not Ethernet", link)
+	}
+
+	var pending [][]byte
+	return func() (replayEvent, error) {
+		for {
+			for len(pending) > 0 {
+				msg := pending[0]
+				pending = pending[1:]
+				event, ok, err := decodeITCH(msg)
+				if ok || err != nil {
+					return event, err
+				}
+			}
+
+			var record [16]byte
+			if _, err := io.ReadFull(r, record[:]); err != nil {
+				if err == io.ErrUnexpectedEOF {
+					return replayEvent{}, errors.New("pcap file ends partway through a packet")
+				}
+				return replayEvent{}, err
+			}
+			packet := make([]byte, order.Uint32(record[8:12]))
+			if _, err := io.ReadFull(r, packet); err != nil {
+				return replayEvent{}, errors.New("pcap file ends partway through a packet")
+			}
+			pending = moldMessages(udpPayload(packet))
+		}
+	}, nil
+}
+
+// udpPayload is the UDP payload of an Ethernet frame carrying IPv4, or nil
+func udpPayload(frame []byte) []byte {
+	if len(frame) < 14 {
+		return nil
+	}
+	etherType, ip := binary.BigEndian.Uint16(frame[12:14]), frame[14:]
+	if etherType == 0x8100 && len(frame) >= 18 {
+		etherType, ip = binary.BigEndian.Uint16(frame[16:18]), frame[18:]
+	}
+	if etherType != 0x0800 || len(ip) < 20 || ip[9] != 17 {
+		return nil
+	}
+	headerLength := int(ip[0]&0x0f) * 4
+	if len(ip) < headerLength+8 {
+		return nil
+	}
+	return ip[headerLength+8:]
+}
+
+// moldMessages splits a MoldUDP64 packet into its messages: after a 10-byte
+// session, 8-byte sequence number and 2-byte count, each message is framed
+// by a 2-byte length
+func moldMessages(packet []byte) [][]byte {
+	if len(packet) < 20 {
+		return nil
+	}
+	count := int(binary.BigEndian.Uint16(packet[18:20]))
+	if count == 0xffff {
+		return nil
+	}
+	var messages [][]byte
+	for rest := packet[20:]; len(messages) < count && len(rest) >= 2; {
+		length := int(binary.BigEndian.Uint16(rest[:2]))
+		if len(rest) < 2+length {
+			break
+		}
+		messages = append(messages, rest[2:2+length])
+		rest = rest[2+length:]
+	}
+	return messages
+}
+
+// newReplayCSVReader reads events from CSV rows under a
+// time,type,symbol,order_id,side,price,quantity,new_order_id header, where
+// time is RFC 3339 and type is add, execute, cancel, delete or replace.
+// Fields an event type does not use may be left empty.
+func newReplayCSVReader(r io.Reader) (replayReader, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.New("missing the time,type,symbol,order_id,side,price,quantity header")
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"time", "type", "order_id"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("header has no %s column", name)
+		}
+	}
+	field := func(row []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	line := 1
+	return func() (replayEvent, error) {
+		row, err := reader.Read()
+		if err != nil {
+			return replayEvent{}, err
+		}
+		line++
+
+		event := replayEvent{
+			kind:     replayKind(strings.ToLower(field(row, "type"))),
+			symbol:   field(row, "symbol"),
+			order:    field(row, "order_id"),
+			newOrder: field(row, "new_order_id"),
+		}
+		if event.at, err = time.Parse(time.RFC3339Nano, field(row, "time")); err != nil {
+			return replayEvent{}, fmt.Errorf("line %d: time must be RFC 3339 (received: %q)", line, field(row, "time"))
+		}
+		if event.order == "" {
+			return replayEvent{}, fmt.Errorf("line %d: order_id is required", line)
+		}
+		var priceErr, quantityErr error
+		if price := field(row, "price"); price != "" {
+			event.price, priceErr = strconv.ParseFloat(price, 64)
+		}
+		if quantity := field(row, "quantity"); quantity != "" {
+			event.quantity, quantityErr = strconv.Atoi(quantity)
+		}
+		if priceErr != nil || quantityErr != nil {
+			return replayEvent{}, fmt.Errorf("line %d: price and quantity must be numbers", line)
+		}
+
+		switch event.kind {
+		case replayAdd:
+			switch strings.ToLower(field(row, "side")) {
+			case "buy", "bid", "b":
+				event.side = SideBuy
+			case "sell", "ask", "s":
+				event.side = SideSell
+			default:
+				return replayEvent{}, fmt.Errorf("line %d: side must be buy or sell (received: %q)", line, field(row, "side"))
+			}
+			if event.symbol == "" {
+				return replayEvent{}, fmt.Errorf("line %d: an add needs a symbol", line)
+			}
+		case replayExecute, replayCancel, replayDelete:
+		case replayReplace:
+			if event.newOrder == "" {
+				return replayEvent{}, fmt.Errorf("line %d: a replace needs a new_order_id", line)
+			}
+		default:
+			return replayEvent{}, fmt.Errorf("line %d: type must be add, execute, cancel, delete or replace (received: %q)", line, field(row, "type"))
+		}
+		return event, nil
+	}, nil
+}
+
+// replayStatus reports the replay's progress
+func replayStatus() ReplayStatus {
+	replay.mu.Lock()
+	defer replay.mu.Unlock()
+	return replay.status
+}
+
+// getReplayHandler reports how far the market data replay has got
+func getReplayHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(replayStatus())
+}
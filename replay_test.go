@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// itchMessage builds an ITCH 5.0 message of the given type, recorded at
+// nanos since midnight, with body following the timestamp
+func itchMessage(kind byte, nanos uint64, body ...any) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(kind)
+	buf.Write(make([]byte, 4))
+	buf.Write([]byte{byte(nanos >> 40), byte(nanos >> 32), byte(nanos >> 24), byte(nanos >> 16), byte(nanos >> 8), byte(nanos)})
+	for _, field := range body {
+		switch field := field.(type) {
+		case string:
+			buf.WriteString(field)
+		default:
+			binary.Write(&buf, binary.BigEndian, field)
+		}
+	}
+	return buf.Bytes()
+}
+
+// itchSession is an add, an execution, a partial cancel, a replace and a
+// delete on AAPL, with an add for a symbol the engine does not trade
+func itchSession() [][]byte {
+	return [][]byte{
+		itchMessage('A', 1000, uint64(1), byte('B'), uint32(300), "AAPL    ", uint32(1005000)),
+		itchMessage('F', 2000, uint64(2), byte('S'), uint32(200), "AAPL    ", uint32(1010000), "MPID"),
+		itchMessage('A', 2500, uint64(3), byte('S'), uint32(10), "MSFT    ", uint32(4000000)),
+		itchMessage('S', 2600, byte('Q')),
+		itchMessage('E', 3000, uint64(1), uint32(100), uint64(77)),
+		itchMessage('X', 4000, uint64(2), uint32(50)),
+		itchMessage('U', 5000, uint64(1), uint64(4), uint32(400), uint32(1002500)),
+		itchMessage('D', 6000, uint64(2)),
+	}
+}
+
+// checkITCHSession checks the books after itchSession has replayed
+func checkITCHSession(t *testing.T) {
+	t.Helper()
+	trades := tradeStore.List("AAPL")
+	if len(trades) != 1 || trades[0].Price != 100.5 || trades[0].Quantity != 100 || trades[0].MakerID != "replay-1" || trades[0].AggressorSide != SideSell {
+		t.Fatalf("Expected the execution replayed as a trade against order 1, got %+v", trades)
+	}
+	m, _ := matcherFor("AAPL")
+	if book := m.book; len(book.asks.orders()) != 0 || len(book.bids.orders()) != 1 || book.bids.orders()[0].ID != "replay-4" ||
+		book.bids.orders()[0].Price != 100.25 || book.bids.orders()[0].Quantity != 400 {
+		t.Errorf("Expected only the replaced bid resting, got %+v", book)
+	}
+	if status := replayStatus(); status.Events != 6 || status.Skipped != 1 || status.At.Sub(time.Time{}) != 6*time.Microsecond {
+		t.Errorf("Expected 6 events replayed and the MSFT add skipped, got %+v", status)
+	}
+}
+
+func TestReplay_ITCH(t *testing.T) {
+	setupTest()
+	resetSymbols([]string{"AAPL"})
+	var file bytes.Buffer
+	for _, msg := range itchSession() {
+		binary.Write(&file, binary.BigEndian, uint16(len(msg)))
+		file.Write(msg)
+	}
+	if err := runReplay(context.Background(), newITCHReader(&file), 0); err != nil {
+		t.Fatal(err)
+	}
+	checkITCHSession(t)
+
+	truncated := bytes.NewReader([]byte{0, 36, 'A', 0})
+	if err := runReplay(context.Background(), newITCHReader(truncated), 0); err == nil {
+		t.Error("Expected an error for a truncated message")
+	}
+}
+
+func TestReplay_Pcap(t *testing.T) {
+	setupTest()
+	resetSymbols([]string{"AAPL"})
+
+	// Two MoldUDP64 packets over UDP, IPv4 and Ethernet, with a heartbeat between
+	packet := func(messages [][]byte) []byte {
+		var mold bytes.Buffer
+		mold.WriteString("SESSION001")
+		binary.Write(&mold, binary.BigEndian, uint64(1))
+		binary.Write(&mold, binary.BigEndian, uint16(len(messages)))
+		for _, msg := range messages {
+			binary.Write(&mold, binary.BigEndian, uint16(len(msg)))
+			mold.Write(msg)
+		}
+		frame := append(make([]byte, 12), 0x08, 0x00)
+		ip := make([]byte, 20)
+		ip[0], ip[9] = 0x45, 17
+		frame = append(append(frame, ip...), make([]byte, 8)...)
+		return append(frame, mold.Bytes()...)
+	}
+	var file bytes.Buffer
+	binary.Write(&file, binary.LittleEndian, []uint32{0xa1b2c3d4, 0x00040002, 0, 0, 65535, 1})
+	session := itchSession()
+	for _, frame := range [][]byte{packet(session[:3]), packet(nil), packet(session[3:])} {
+		binary.Write(&file, binary.LittleEndian, []uint32{0, 0, uint32(len(frame)), uint32(len(frame))})
+		file.Write(frame)
+	}
+
+	next, err := newPcapReader(&file)
+	if err == nil {
+		err = runReplay(context.Background(), next, 0)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkITCHSession(t)
+
+	if _, err := newPcapReader(strings.NewReader("\n\r\r\n" + strings.Repeat("x", 20))); err == nil {
+		t.Error("Expected pcapng refused")
+	}
+}
+
+func TestReplay_CSVAtSpeed(t *testing.T) {
+	setupTest()
+	resetSymbols([]string{"BTC-USD"})
+	path := writeSeedFile(t, "ticks.csv", "time,type,symbol,order_id,side,price,quantity,new_order_id\n"+
+		"2024-01-02T09:30:00Z,add,BTC-USD,a,buy,100,5,\n"+
+		"2024-01-02T09:30:00.200Z,execute,,a,,,2,\n")
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	started := time.Now()
+	startReplay(ctx, ReplayConfig{Path: path, Speed: 10})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for status := replayStatus(); status.FinishedAt == nil; status = replayStatus() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the replay to finish, got %+v", status)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if elapsed := time.Since(started); elapsed < 20*time.Millisecond {
+		t.Errorf("Expected the 200ms gap replayed in 20ms at 10x, took %s", elapsed)
+	}
+	if status := replayStatus(); status.Format != ReplayCSV || status.Events != 2 || status.Error != "" {
+		t.Errorf("Unexpected replay status %+v", status)
+	}
+	m, _ := matcherFor("BTC-USD")
+	if trades := tradeStore.List("BTC-USD"); len(trades) != 1 || m.book.bids.orders()[0].Quantity != 3 {
+		t.Errorf("Expected 2 of 5 executed, got %+v and %+v", trades, m.book.bids.orders())
+	}
+}
+
+func TestReplay_CSVErrors(t *testing.T) {
+	for name, contents := range map[string]string{
+		"no order column": "time,type,symbol\n",
+		"bad time":        "time,type,symbol,order_id,side,price,quantity\nyesterday,add,BTC-USD,a,buy,100,5\n",
+		"bad type":        "time,type,symbol,order_id,side,price,quantity\n2024-01-02T09:30:00Z,modify,BTC-USD,a,buy,100,5\n",
+		"bad side":        "time,type,symbol,order_id,side,price,quantity\n2024-01-02T09:30:00Z,add,BTC-USD,a,long,100,5\n",
+		"bad quantity":    "time,type,symbol,order_id,side,price,quantity\n2024-01-02T09:30:00Z,add,BTC-USD,a,buy,100,five\n",
+		"no new order":    "time,type,symbol,order_id,side,price,quantity\n2024-01-02T09:30:00Z,replace,,a,,100,5\n",
+	} {
+		next, err := newReplayCSVReader(strings.NewReader(contents))
+		if err == nil {
+			_, err = next()
+		}
+		if err == nil {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+}
+
+func TestLoadConfig_Replay(t *testing.T) {
+	path := writeSeedFile(t, "day.itch", "")
+	cfg, err := loadConfig([]string{"-replay", path, "-replay-speed", "0"})
+	if err != nil || cfg.Replay.Path != path || cfg.Replay.Speed != 0 || replayFormat(cfg.Replay) != ReplayITCH {
+		t.Fatalf("Unexpected replay config %+v (%v)", cfg.Replay, err)
+	}
+	for _, args := range [][]string{
+		{"-replay", filepath.Join(t.TempDir(), "missing.itch")},
+		{"-replay", path, "-replay-format", "fix"},
+		{"-replay", path, "-replay-speed", "-1"},
+		{"-replay", path, "-replicate-from", "http://primary:8080"},
+	} {
+		if _, err := loadConfig(args); err == nil {
+			t.Errorf("Expected an error for %v", args)
+		}
+	}
+}
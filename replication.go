@@ -0,0 +1,586 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ReplicationConfig makes the server a hot standby that follows a primary
+type ReplicationConfig struct {
+	// PrimaryURL is the primary's base URL; empty runs as a primary
+	PrimaryURL string
+	// APIKey is sent to the primary when it requires one
+	APIKey string
+}
+
+// ReplicationMessage is one message on the replication stream. The first
+// carries a snapshot of the primary's state; each later one carries the next
+// sequence number and one change made after it.
+type ReplicationMessage struct {
+	Sequence int64        `json:"sequence"`
+	Snapshot *Snapshot    `json:"snapshot,omitempty"`
+	Book     *BookChange  `json:"book,omitempty"`
+	Trade    *Trade       `json:"trade,omitempty"`
+	Event    *OrderEvent  `json:"event,omitempty"`
+	Closed   *ClosedOrder `json:"closed,omitempty"`
+	// Consumer is a consumer group's commit or deletion
+	Consumer *ConsumerOffset `json:"consumer,omitempty"`
+}
+
+// BookChange is what one matcher command changed on a symbol's book
+type BookChange struct {
+	Symbol string `json:"symbol"`
+	// Sequence is the book's depth sequence before the change
+	Sequence int64 `json:"sequence"`
+	// Levels holds every price level the command touched, in full
+	Levels []LevelOrders `json:"levels,omitempty"`
+	// Stops replaces the untriggered stops when they changed
+	Stops     *[]StopSnapshot `json:"stops,omitempty"`
+	LastPrice float64         `json:"last_price,omitempty"`
+	Mark      MarkInputs      `json:"mark"`
+}
+
+// LevelOrders is every order resting at one price on one side, in priority
+// order; no orders means the level has emptied
+type LevelOrders struct {
+	Side   Side    `json:"side"`
+	Price  float64 `json:"price"`
+	Orders []Order `json:"orders"`
+}
+
+// ReplicationStatus reports the server's role and how replication is going
+type ReplicationStatus struct {
+	// Role is "primary" or "standby"
+	Role string `json:"role"`
+	// Primary, Connected and LastError describe a standby's link to its primary
+	Primary   string `json:"primary,omitempty"`
+	Connected bool   `json:"connected"`
+	LastError string `json:"last_error,omitempty"`
+	// Sequence is the newest replication message sent, or on a standby applied
+	Sequence int64 `json:"sequence"`
+	// Epoch is the run of sequence numbers the server's feeds are in; a
+	// standby takes its primary's
+	Epoch string `json:"epoch,omitempty"`
+	// Replicas counts the standbys following this server
+	Replicas int `json:"replicas"`
+}
+
+// replicationBufferSize is how many messages a replica may fall behind by
+// before it is disconnected and has to start over from a snapshot
+const replicationBufferSize = 4096
+
+// replicaSubscriber is one standby's queue of pending messages
+type replicaSubscriber struct {
+	messages chan ReplicationMessage
+	// dropped is closed when the replica fell behind or must resync
+	dropped chan struct{}
+}
+
+// replicationHub numbers every change and fans it out to the connected
+// replicas. Publishing costs one atomic load while none are connected.
+type replicationHub struct {
+	active atomic.Bool
+
+	mu          sync.Mutex
+	sequence    int64
+	subscribers map[*replicaSubscriber]struct{}
+}
+
+var replication = &replicationHub{subscribers: make(map[*replicaSubscriber]struct{})}
+
+// publishing reports whether any replica is listening
+func (h *replicationHub) publishing() bool {
+	return h.active.Load()
+}
+
+// publish numbers a message and queues it for every replica
+func (h *replicationHub) publish(msg ReplicationMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.publishLocked(msg)
+}
+
+// publishLocked is publish for a caller already holding h.mu
+func (h *replicationHub) publishLocked(msg ReplicationMessage) {
+	h.sequence++
+	msg.Sequence = h.sequence
+	for subscriber := range h.subscribers {
+		select {
+		case subscriber.messages <- msg:
+		default:
+			h.dropLocked(subscriber)
+		}
+	}
+}
+
+// subscribe adds a replica and returns the sequence its snapshot is taken at.
+// It must be called with every matcher held, so no change falls between the
+// snapshot and the first message.
+func (h *replicationHub) subscribe(subscriber *replicaSubscriber) int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subscribers[subscriber] = struct{}{}
+	h.active.Store(true)
+	return h.sequence
+}
+
+func (h *replicationHub) unsubscribe(subscriber *replicaSubscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subscribers[subscriber]; ok {
+		h.dropLocked(subscriber)
+	}
+}
+
+// dropAll disconnects every replica, as when the state they copied is replaced
+func (h *replicationHub) dropAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for subscriber := range h.subscribers {
+		h.dropLocked(subscriber)
+	}
+}
+
+func (h *replicationHub) dropLocked(subscriber *replicaSubscriber) {
+	delete(h.subscribers, subscriber)
+	close(subscriber.dropped)
+	h.active.Store(len(h.subscribers) > 0)
+}
+
+func (h *replicationHub) status() (int64, int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.sequence, len(h.subscribers)
+}
+
+// subscribeReplica holds every matcher, snapshots the engine and subscribes a
+// replica with room for size messages. The returned message carries the
+// snapshot; the replica's first queued message follows it.
+func subscribeReplica(size int) (*replicaSubscriber, ReplicationMessage) {
+	subscriber := &replicaSubscriber{
+		messages: make(chan ReplicationMessage, size),
+		dropped:  make(chan struct{}),
+	}
+	var first ReplicationMessage
+	matchers := allMatchers()
+	holdMatchers(matchers, func() {
+		consumersMu.Lock()
+		defer consumersMu.Unlock()
+		snapshot := captureSnapshot(matchers)
+		first = ReplicationMessage{Sequence: replication.subscribe(subscriber), Snapshot: &snapshot}
+	}, nil)
+	return subscriber, first
+}
+
+// recordTrade adds a trade to the history and, with replicas connected, to
+// the replication stream in the same order
+func recordTrade(trade Trade) {
+	noteStrategyTrade(trade)
+	readModel.trade(trade)
+	if !replication.publishing() {
+		tradeStore.Add(trade)
+		return
+	}
+	replication.mu.Lock()
+	defer replication.mu.Unlock()
+	tradeStore.Add(trade)
+	// Copied so only a published trade is moved to the heap
+	published := trade
+	replication.publishLocked(ReplicationMessage{Trade: &published})
+}
+
+// recordClosedOrder adds an order that has left the book to the order history
+// and publishes it for replication
+func recordClosedOrder(order Order) {
+	closed := ClosedOrder{Order: order, ClosedAt: engineClock.Now()}
+	if !replication.publishing() {
+		closedStore.Add(closed)
+		return
+	}
+	replication.mu.Lock()
+	defer replication.mu.Unlock()
+	closedStore.Add(closed)
+	replication.publishLocked(ReplicationMessage{Closed: &closed})
+}
+
+// replicate publishes what the last command changed on the matcher's book.
+// It runs on the matcher after each command, before the depth is published
+// and the dirty levels are cleared.
+func (m *matcher) replicate() {
+	if !replication.publishing() {
+		return
+	}
+
+	book := m.book
+	change := BookChange{Symbol: m.symbol, Sequence: book.sequence, LastPrice: book.lastPrice, Mark: book.mark.inputs}
+	for price := range book.dirtyBids {
+		change.Levels = append(change.Levels, LevelOrders{Side: SideBuy, Price: price, Orders: book.bids.level(price)})
+	}
+	for price := range book.dirtyAsks {
+		change.Levels = append(change.Levels, LevelOrders{Side: SideSell, Price: price, Orders: book.asks.level(price)})
+	}
+	if !slices.Equal(book.stops, m.replicatedStops) {
+		stops := make([]StopSnapshot, 0, len(book.stops))
+		for _, stop := range book.stops {
+			stops = append(stops, StopSnapshot{Order: stop, Anchor: stop.anchor})
+		}
+		change.Stops = &stops
+		m.replicatedStops = append(m.replicatedStops[:0], book.stops...)
+	}
+
+	if len(change.Levels) == 0 && change.Stops == nil && change.LastPrice == m.replicatedLastPrice && change.Mark == m.replicatedMark {
+		return
+	}
+	m.replicatedLastPrice, m.replicatedMark = change.LastPrice, change.Mark
+	replication.publish(ReplicationMessage{Book: &change})
+}
+
+// applyBookChange brings the matcher's book in line with a change from the
+// primary. Levels are marked dirty as they are replaced, so the standby's
+// own depth stream and replicas see the same change. It must run on the matcher.
+func (m *matcher) applyBookChange(change BookChange) {
+	book := m.book
+	for _, level := range change.Levels {
+		replaceLevel(book, level)
+	}
+
+	if change.Stops != nil {
+		book.stops = book.stops[:0]
+		for _, stop := range *change.Stops {
+			order := stop.Order
+			order.anchor = stop.Anchor
+			noteExpiry(book, order)
+			noteArrival(order)
+			book.stops = append(book.stops, order)
+		}
+	}
+	book.lastPrice = change.LastPrice
+	book.mark.inputs = change.Mark
+	book.sequence = change.Sequence
+}
+
+// replaceLevel swaps the orders at one price for the primary's, keeping the
+// liquidity totals in step
+func replaceLevel(book *OrderBook, level LevelOrders) {
+	side := book.sideOf(level.Side)
+	previous := side.level(level.Price)
+	publishLevelL3(book, previous, level)
+	for _, order := range previous {
+		adjustLevel(book, level.Side, level.Price, -order.Quantity, -1)
+	}
+	for _, order := range level.Orders {
+		adjustLevel(book, level.Side, level.Price, order.Quantity, 1)
+		noteExpiry(book, order)
+		noteArrival(order)
+		if order.Type == OrderTypePegged {
+			book.hasPegs = true
+		}
+	}
+	// A level that emptied still needs publishing
+	markDirty(book, level.Side, level.Price)
+	side.replaceLevel(level.Price, level.Orders)
+}
+
+// applyReplication applies one message from the primary after its snapshot
+func applyReplication(msg ReplicationMessage) error {
+	switch {
+	case msg.Book != nil:
+		m, ok := matcherFor(msg.Book.Symbol)
+		if !ok || msg.Book.Symbol == "" {
+			return fmt.Errorf("change to unknown symbol %q", msg.Book.Symbol)
+		}
+		m.do(func() {
+			m.applyBookChange(*msg.Book)
+		})
+	case msg.Trade != nil:
+		recordTrade(*msg.Trade)
+	case msg.Closed != nil:
+		closedStore.Add(*msg.Closed)
+	case msg.Event != nil:
+		historyMu.Lock()
+		logOrderEvent(*msg.Event)
+		historyMu.Unlock()
+	case msg.Consumer != nil:
+		applyConsumerOffset(*msg.Consumer)
+	}
+	return nil
+}
+
+// standby tracks a server following a primary. While running is set, writes
+// other than promotion are refused and expiry is left to the primary.
+var standby struct {
+	running atomic.Bool
+
+	mu        sync.Mutex
+	primary   string
+	connected bool
+	sequence  int64
+	lastError string
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+// standbyRetryDelay is how long a standby waits before reconnecting
+var standbyRetryDelay = time.Second
+
+// startStandby follows the primary in cfg until promote is called
+func startStandby(cfg ReplicationConfig) {
+	startFollowing(cfg.PrimaryURL, func(ctx context.Context) error {
+		return followPrimary(ctx, cfg)
+	})
+}
+
+// startFollowing runs the server as a standby of source, calling follow
+// again whenever it fails, until promote is called
+func startFollowing(source string, follow func(ctx context.Context) error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	standby.mu.Lock()
+	standby.primary = source
+	standby.cancel = cancel
+	standby.done = make(chan struct{})
+	done := standby.done
+	standby.mu.Unlock()
+	standby.running.Store(true)
+
+	go func() {
+		defer close(done)
+		for {
+			err := follow(ctx)
+			standby.mu.Lock()
+			standby.connected = false
+			if err != nil && ctx.Err() == nil {
+				standby.lastError = err.Error()
+			}
+			standby.mu.Unlock()
+
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("replication: %v; reconnecting in %s", err, standbyRetryDelay)
+			select {
+			case <-time.After(standbyRetryDelay):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// followPrimary loads the primary's snapshot and applies its changes until
+// the stream breaks or ctx is cancelled
+func followPrimary(ctx context.Context, cfg ReplicationConfig) error {
+	endpoint, err := url.Parse(strings.TrimSuffix(cfg.PrimaryURL, "/") + apiPrefix + "/admin/replication/stream")
+	if err != nil {
+		return err
+	}
+	endpoint.Scheme = strings.Replace(endpoint.Scheme, "http", "ws", 1)
+
+	header := make(http.Header)
+	if cfg.APIKey != "" {
+		header.Set("Authorization", "Bearer "+cfg.APIKey)
+	}
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, endpoint.String(), header)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	var first ReplicationMessage
+	if err := conn.ReadJSON(&first); err != nil {
+		return err
+	}
+	if first.Snapshot == nil {
+		return errors.New("primary did not start with a snapshot")
+	}
+	return followStream(*first.Snapshot, first.Sequence, func() (ReplicationMessage, error) {
+		var msg ReplicationMessage
+		err := conn.ReadJSON(&msg)
+		return msg, err
+	})
+}
+
+// followStream loads a snapshot taken at sequence, then applies the messages
+// next returns until it fails or a message is missing. Messages from before
+// the snapshot are skipped, and a later snapshot starts over from it.
+func followStream(snapshot Snapshot, sequence int64, next func() (ReplicationMessage, error)) error {
+	if err := loadStreamSnapshot(snapshot, sequence); err != nil {
+		return err
+	}
+	for {
+		msg, err := next()
+		if err != nil {
+			return err
+		}
+		switch {
+		case msg.Snapshot != nil:
+			if err := loadStreamSnapshot(*msg.Snapshot, msg.Sequence); err != nil {
+				return err
+			}
+		case msg.Sequence <= sequence:
+			continue
+		case msg.Sequence != sequence+1:
+			return fmt.Errorf("expected message %d, got %d", sequence+1, msg.Sequence)
+		default:
+			if err := applyReplication(msg); err != nil {
+				return err
+			}
+		}
+		sequence = msg.Sequence
+
+		standby.mu.Lock()
+		standby.sequence = sequence
+		standby.mu.Unlock()
+	}
+}
+
+// loadStreamSnapshot restores the snapshot a stream starts from
+func loadStreamSnapshot(snapshot Snapshot, sequence int64) error {
+	if unknown := snapshot.unknownSymbols(); len(unknown) > 0 {
+		return fmt.Errorf("primary trades symbols not configured here: %s", strings.Join(unknown, ", "))
+	}
+	restoreSnapshot(snapshot)
+	// The standby carries on the primary's sequences, so after a promotion
+	// its clients are still in the same epoch
+	if snapshot.Epoch != "" {
+		setEpoch(snapshot.Epoch)
+	}
+
+	standby.mu.Lock()
+	standby.connected, standby.sequence, standby.lastError = true, sequence, ""
+	standby.mu.Unlock()
+	return nil
+}
+
+// promote stops following the primary and starts taking writes. It reports
+// false if the server was not a standby.
+func promote() bool {
+	standby.mu.Lock()
+	cancel, done := standby.cancel, standby.done
+	standby.cancel = nil
+	standby.mu.Unlock()
+	if cancel == nil {
+		return false
+	}
+
+	// Let the last change finish applying before the first write is accepted
+	cancel()
+	<-done
+	standby.running.Store(false)
+	log.Printf("replication: promoted to primary")
+	return true
+}
+
+// replicationStatus describes the server's current role
+func replicationStatus() ReplicationStatus {
+	sequence, replicas := replication.status()
+	status := ReplicationStatus{Role: "primary", Sequence: sequence, Epoch: currentEpoch(), Replicas: replicas}
+	if !standby.running.Load() {
+		return status
+	}
+
+	standby.mu.Lock()
+	defer standby.mu.Unlock()
+	status.Role = "standby"
+	status.Primary = standby.primary
+	status.Connected = standby.connected
+	status.Sequence = standby.sequence
+	status.LastError = standby.lastError
+	return status
+}
+
+// refuseOnStandby rejects writes while the server follows a primary
+func refuseOnStandby(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if standby.running.Load() {
+			writeError(w, http.StatusServiceUnavailable, ErrCodeStandby, "Server is a standby",
+				"send writes to the primary, or promote this server first")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// replicationStreamHandler streams a snapshot and then every change to a standby
+func replicationStreamHandler(w http.ResponseWriter, r *http.Request) {
+	conn, err := depthUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already written an error response
+		return
+	}
+	defer conn.Close()
+
+	subscriber, first := subscribeReplica(replicationBufferSize)
+	defer replication.unsubscribe(subscriber)
+	session := openSession(SessionReplication, r, []string{"replication"}, replicationBufferSize,
+		func() int { return len(subscriber.messages) })
+	defer session.close()
+
+	if err := conn.WriteJSON(first); err != nil {
+		return
+	}
+	session.sent.Add(1)
+
+	// The stream is one-way; reading only notices when the standby goes away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case msg := <-subscriber.messages:
+			if err := conn.WriteJSON(msg); err != nil {
+				return
+			}
+			session.sent.Add(1)
+		case <-session.kicked:
+			session.writeKicked(conn)
+			return
+		case <-subscriber.dropped:
+			// Tell the standby to start over from a new snapshot
+			session.resyncs.Add(1)
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "resync from a new snapshot"), time.Now().Add(time.Second))
+			return
+		case <-closed:
+			return
+		}
+	}
+}
+
+// getReplicationHandler reports the server's role and replication progress
+func getReplicationHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(replicationStatus())
+}
+
+// promoteHandler turns a standby into a primary
+func promoteHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if !promote() {
+		writeError(w, http.StatusConflict, ErrCodeNotStandby, "Server is not a standby",
+			"only a server started with -replicate-from can be promoted")
+		return
+	}
+	json.NewEncoder(w).Encode(replicationStatus())
+}
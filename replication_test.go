@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// followLocally subscribes a replica the way the stream handler does and
+// returns the snapshot it starts from
+func followLocally(t *testing.T, size int) (*replicaSubscriber, ReplicationMessage) {
+	t.Helper()
+	subscriber, first := subscribeReplica(size)
+	t.Cleanup(func() { replication.unsubscribe(subscriber) })
+	return subscriber, first
+}
+
+// drain returns every message queued for a replica
+func drain(subscriber *replicaSubscriber) []ReplicationMessage {
+	var messages []ReplicationMessage
+	for {
+		select {
+		case msg := <-subscriber.messages:
+			messages = append(messages, msg)
+		default:
+			return messages
+		}
+	}
+}
+
+// bookState is what a standby must agree with its primary on
+type bookState struct {
+	Orders    []Order
+	Stops     []StopSnapshot
+	Sequence  int64
+	BidLevels int
+	AskTotal  int
+	Trades    []Trade
+	Closed    []ClosedOrder
+	Events    int
+	LastEvent int
+}
+
+// captureBookState reads the default book and the history
+func captureBookState() bookState {
+	var state bookState
+	m, _ := matcherFor("")
+	m.do(func() {
+		saved := m.snapshotBook()
+		state.Orders = append(saved.BuyOrders, saved.SellOrders...)
+		state.Stops = saved.Stops
+		state.Sequence = m.book.sequence
+		state.BidLevels = m.book.bidLiquidity.levelCount()
+		state.AskTotal = m.book.askLiquidity.quantity
+	})
+	state.Trades = tradeStore.List("")
+	state.Closed = closedStore.List(OrderHistoryFilter{})
+	historyMu.Lock()
+	state.Events, state.LastEvent = len(orderEvents), lastEventSequence
+	historyMu.Unlock()
+	return state
+}
+
+func TestReplication_StandbyConvergesOnPrimary(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "bid-1", Side: SideBuy, Price: 99.0, Quantity: 4})
+	subscriber, first := followLocally(t, replicationBufferSize)
+
+	// Rest, trade partly through a level, cancel, and move a trailing stop
+	placeOn(m, Order{ID: "bid-2", Side: SideBuy, Price: 99.0, Quantity: 3})
+	placeOn(m, Order{ID: "bid-3", Side: SideBuy, Price: 98.0, Quantity: 2})
+	placeOn(m, Order{ID: "ask-1", Side: SideSell, Price: 101.0, Quantity: 8})
+	placeOn(m, Order{ID: "sell-1", Side: SideSell, Price: 99.0, Quantity: 5})
+	m.do(func() { cancelOrder("", "bid-3") })
+	m.do(func() { processOrder(trailingStop("stop-1", SideSell, 1, 2.0, 0)) })
+	placeOn(m, Order{ID: "buy-1", Side: SideBuy, Price: 101.0, Quantity: 2})
+
+	want := captureBookState()
+	messages := drain(subscriber)
+	if len(messages) == 0 {
+		t.Fatal("Expected the changes to be published")
+	}
+
+	// A fresh engine that loads the snapshot and applies the stream ends up identical
+	setupTest()
+	restoreSnapshot(*first.Snapshot)
+	sequence := first.Sequence
+	for _, msg := range messages {
+		if msg.Sequence != sequence+1 {
+			t.Fatalf("Expected message %d, got %d", sequence+1, msg.Sequence)
+		}
+		if err := applyReplication(msg); err != nil {
+			t.Fatal(err)
+		}
+		sequence = msg.Sequence
+	}
+
+	got := captureBookState()
+	wantJSON, _ := json.Marshal(want)
+	gotJSON, _ := json.Marshal(got)
+	if !bytes.Equal(wantJSON, gotJSON) {
+		t.Errorf("Expected the standby to match the primary:\nwant %s\ngot  %s", wantJSON, gotJSON)
+	}
+}
+
+func TestReplication_DropsReplicasThatFallBehind(t *testing.T) {
+	setupTest()
+	subscriber, _ := followLocally(t, 1)
+
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "bid-1", Side: SideBuy, Price: 99.0, Quantity: 4})
+
+	select {
+	case <-subscriber.dropped:
+	default:
+		t.Fatal("Expected a replica with a full queue to be dropped")
+	}
+	if replication.publishing() {
+		t.Errorf("Expected publishing to stop once no replica is left")
+	}
+}
+
+func TestReplicationStream_StartsWithASnapshot(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "bid-1", Side: SideBuy, Price: 99.0, Quantity: 4})
+
+	server := httptest.NewServer(newServer(HTTPConfig{}))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/v1/admin/replication/stream"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Expected to connect, got %v", err)
+	}
+	defer conn.Close()
+
+	var first ReplicationMessage
+	if err := conn.ReadJSON(&first); err != nil || first.Snapshot == nil || len(first.Snapshot.Books[0].BuyOrders) != 1 {
+		t.Fatalf("Expected a snapshot holding bid-1, got %+v (%v)", first, err)
+	}
+
+	placeOn(m, Order{ID: "ask-1", Side: SideSell, Price: 101.0, Quantity: 2})
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		var msg ReplicationMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("Expected the new ask to be streamed, got %v", err)
+		}
+		if msg.Book != nil {
+			if len(msg.Book.Levels) != 1 || msg.Book.Levels[0].Orders[0].ID != "ask-1" {
+				t.Errorf("Unexpected book change %+v", msg.Book)
+			}
+			break
+		}
+	}
+
+	if status := replicationStatus(); status.Role != "primary" || status.Replicas != 1 {
+		t.Errorf("Expected a primary with one replica, got %+v", status)
+	}
+}
+
+func TestStandby_RefusesWritesUntilPromoted(t *testing.T) {
+	setupTest()
+	previous := standbyRetryDelay
+	standbyRetryDelay = time.Millisecond
+	t.Cleanup(func() { standbyRetryDelay = previous })
+
+	// The primary is unreachable, so the standby keeps retrying
+	startStandby(ReplicationConfig{PrimaryURL: "http://127.0.0.1:1"})
+	t.Cleanup(func() { promote() })
+
+	order := `{"side":"buy","price":99,"quantity":1}`
+	response := serve(HTTPConfig{}, httptest.NewRequest("POST", "/api/v1/orders", strings.NewReader(order)))
+	if result := decodeError(t, response); response.Code != http.StatusServiceUnavailable || result.Error.Code != ErrCodeStandby {
+		t.Fatalf("Expected a standby to refuse orders, got %d %s", response.Code, result.Error.Code)
+	}
+	if code := serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/orders", nil)).Code; code != http.StatusOK {
+		t.Errorf("Expected reads to work on a standby, got %d", code)
+	}
+	if status := replicationStatus(); status.Role != "standby" || status.Connected {
+		t.Errorf("Expected a disconnected standby, got %+v", status)
+	}
+
+	response = serve(HTTPConfig{}, httptest.NewRequest("POST", "/api/v1/admin/promote", nil))
+	var status ReplicationStatus
+	json.NewDecoder(response.Body).Decode(&status)
+	if response.Code != http.StatusOK || status.Role != "primary" {
+		t.Fatalf("Expected promotion to succeed, got %d %+v", response.Code, status)
+	}
+	response = serve(HTTPConfig{}, httptest.NewRequest("POST", "/api/v1/orders", strings.NewReader(order)))
+	if response.Code != http.StatusOK {
+		t.Errorf("Expected orders once promoted, got %d", response.Code)
+	}
+
+	response = serve(HTTPConfig{}, httptest.NewRequest("POST", "/api/v1/admin/promote", nil))
+	if result := decodeError(t, response); result.Error.Code != ErrCodeNotStandby {
+		t.Errorf("Expected a primary to refuse promotion, got %s", result.Error.Code)
+	}
+}
+
+func TestLoadConfig_ReplicationFlags(t *testing.T) {
+	cfg, err := loadConfig([]string{"-replicate-from", "http://primary:8080", "-replicate-api-key", "secret"})
+	if err != nil || cfg.Replication.PrimaryURL != "http://primary:8080" || cfg.Replication.APIKey != "secret" {
+		t.Errorf("Unexpected replication config %+v (%v)", cfg.Replication, err)
+	}
+	if _, err := loadConfig([]string{"-replicate-from", "http://primary:8080", "-bots"}); err == nil {
+		t.Errorf("Expected bots on a standby to be refused")
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// OrderRepository keeps each symbol's order book. A book is only changed on
+// its symbol's matcher, so the books it hands out need no locking of their
+// own; Book itself may be called from any goroutine.
+type OrderRepository interface {
+	// Book returns symbol's book, creating an empty one the first time
+	Book(symbol string) *OrderBook
+}
+
+// TradeRepository keeps the trade history every matcher appends to, in the
+// order the trades were made. Implementations must be safe for concurrent use.
+type TradeRepository interface {
+	Add(trade Trade)
+	// List returns a copy of the history, optionally for one symbol
+	List(symbol string) []Trade
+	Get(id string) (Trade, bool)
+	// TakerFills returns the newest trades an order took, up to filled quantity
+	TakerFills(orderID string, filled int) []Trade
+	// Before returns the oldest trades, up to the first made at or after cutoff
+	Before(cutoff time.Time) []Trade
+	// DropOldest removes the n oldest trades
+	DropOldest(n int)
+	// Replace swaps the whole history for trades, as when a snapshot is restored
+	Replace(trades []Trade)
+}
+
+// ClosedOrderRepository keeps the orders that have left the book for good, in
+// the order they closed. Implementations must be safe for concurrent use.
+type ClosedOrderRepository interface {
+	Add(order ClosedOrder)
+	// List returns a copy of the orders that match filter
+	List(filter OrderHistoryFilter) []ClosedOrder
+	// Before returns the oldest orders, up to the first closed at or after cutoff
+	Before(cutoff time.Time) []ClosedOrder
+	// DropOldest removes the n oldest orders
+	DropOldest(n int)
+	// Replace swaps the whole history for orders, as when a snapshot is restored
+	Replace(orders []ClosedOrder)
+}
+
+// orderStore, tradeStore and closedStore hold the engine's state. They
+// default to memory; a persistence backend or a test swaps them before the
+// matchers start.
+var (
+	orderStore  OrderRepository       = newMemoryOrderRepository()
+	tradeStore  TradeRepository       = newMemoryTradeRepository(0)
+	closedStore ClosedOrderRepository = newMemoryClosedOrderRepository()
+)
+
+// memoryOrderRepository keeps books in a map for the life of the process
+type memoryOrderRepository struct {
+	mu    sync.Mutex
+	books map[string]*OrderBook
+}
+
+func newMemoryOrderRepository() *memoryOrderRepository {
+	return &memoryOrderRepository{books: make(map[string]*OrderBook)}
+}
+
+func (r *memoryOrderRepository) Book(symbol string) *OrderBook {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	book, ok := r.books[symbol]
+	if !ok {
+		book = &OrderBook{
+			bids: newBookSide(SideBuy, initialBookCapacity),
+			asks: newBookSide(SideSell, initialBookCapacity),
+			mark: markState{source: MarkLastTrade},
+		}
+		r.books[symbol] = book
+	}
+	return book
+}
+
+// memoryTradeRepository keeps trades in one slice behind a mutex
+type memoryTradeRepository struct {
+	mu     sync.Mutex
+	trades []Trade
+}
+
+// newMemoryTradeRepository returns an empty history with room for capacity
+// trades before it has to grow
+func newMemoryTradeRepository(capacity int) *memoryTradeRepository {
+	return &memoryTradeRepository{trades: make([]Trade, 0, capacity)}
+}
+
+func (r *memoryTradeRepository) Add(trade Trade) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.trades = append(r.trades, trade)
+}
+
+func (r *memoryTradeRepository) List(symbol string) []Trade {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := make([]Trade, 0, len(r.trades))
+	for _, trade := range r.trades {
+		if symbol == "" || trade.Symbol == symbol {
+			result = append(result, trade)
+		}
+	}
+	return result
+}
+
+func (r *memoryTradeRepository) Get(id string) (Trade, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, trade := range r.trades {
+		if trade.ID == id {
+			return trade, true
+		}
+	}
+	return Trade{}, false
+}
+
+// TakerFills scans back from the end of the history, where an order's fills are
+func (r *memoryTradeRepository) TakerFills(orderID string, filled int) []Trade {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var fills []Trade
+	for i := len(r.trades) - 1; i >= 0 && filled > 0; i-- {
+		if r.trades[i].TakerID == orderID {
+			fills = append(fills, r.trades[i])
+			filled -= r.trades[i].Quantity
+		}
+	}
+	return fills
+}
+
+func (r *memoryTradeRepository) Before(cutoff time.Time) []Trade {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var result []Trade
+	for _, trade := range r.trades {
+		if !trade.CreatedAt.Before(cutoff) {
+			break
+		}
+		result = append(result, trade)
+	}
+	return result
+}
+
+// DropOldest copies the rest of the history so the old backing array can be freed
+func (r *memoryTradeRepository) DropOldest(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.trades = append(make([]Trade, 0, len(r.trades)-n), r.trades[n:]...)
+}
+
+func (r *memoryTradeRepository) Replace(trades []Trade) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.trades = append(make([]Trade, 0, max(len(trades), cap(r.trades))), trades...)
+}
+
+// memoryClosedOrderRepository keeps closed orders in one slice behind a mutex
+type memoryClosedOrderRepository struct {
+	mu     sync.Mutex
+	orders []ClosedOrder
+}
+
+func newMemoryClosedOrderRepository() *memoryClosedOrderRepository {
+	return &memoryClosedOrderRepository{}
+}
+
+func (r *memoryClosedOrderRepository) Add(order ClosedOrder) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.orders = append(r.orders, order)
+}
+
+func (r *memoryClosedOrderRepository) List(filter OrderHistoryFilter) []ClosedOrder {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := make([]ClosedOrder, 0)
+	for _, order := range r.orders {
+		if filter.matches(order) {
+			result = append(result, order)
+		}
+	}
+	return result
+}
+
+func (r *memoryClosedOrderRepository) Before(cutoff time.Time) []ClosedOrder {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var result []ClosedOrder
+	for _, order := range r.orders {
+		if !order.ClosedAt.Before(cutoff) {
+			break
+		}
+		result = append(result, order)
+	}
+	return result
+}
+
+// DropOldest copies the rest of the history so the old backing array can be freed
+func (r *memoryClosedOrderRepository) DropOldest(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.orders = append(make([]ClosedOrder, 0, len(r.orders)-n), r.orders[n:]...)
+}
+
+func (r *memoryClosedOrderRepository) Replace(orders []ClosedOrder) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.orders = append([]ClosedOrder(nil), orders...)
+}
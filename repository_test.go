@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// countingTrades records how many trades the engine hands it
+type countingTrades struct {
+	TradeRepository
+	added int
+}
+
+func (c *countingTrades) Add(trade Trade) {
+	c.added++
+	c.TradeRepository.Add(trade)
+}
+
+func TestMemoryTradeRepository(t *testing.T) {
+	start := time.Date(2024, 1, 1, 9, 30, 0, 0, time.UTC)
+	repo := newMemoryTradeRepository(0)
+	repo.Add(Trade{ID: "t1", Symbol: "BTC-USD", TakerID: "buy-1", Quantity: 2, CreatedAt: start})
+	repo.Add(Trade{ID: "t2", Symbol: "ETH-USD", TakerID: "buy-2", Quantity: 1, CreatedAt: start.Add(time.Minute)})
+	repo.Add(Trade{ID: "t3", Symbol: "BTC-USD", TakerID: "buy-1", Quantity: 3, CreatedAt: start.Add(2 * time.Minute)})
+
+	if got := repo.List("BTC-USD"); len(got) != 2 || got[1].ID != "t3" {
+		t.Errorf("Expected the BTC-USD trades in order, got %+v", got)
+	}
+	if trade, ok := repo.Get("t2"); !ok || trade.Symbol != "ETH-USD" {
+		t.Errorf("Expected to find t2, got %+v", trade)
+	}
+	if _, ok := repo.Get("missing"); ok {
+		t.Errorf("Expected no trade for an unknown ID")
+	}
+
+	// Only as many fills as the quantity asked for, newest first
+	if fills := repo.TakerFills("buy-1", 3); len(fills) != 1 || fills[0].ID != "t3" {
+		t.Errorf("Expected only the newest fill, got %+v", fills)
+	}
+
+	old := repo.Before(start.Add(90 * time.Second))
+	if len(old) != 2 || old[1].ID != "t2" {
+		t.Fatalf("Expected the two older trades, got %+v", old)
+	}
+	repo.DropOldest(len(old))
+	if got := repo.List(""); len(got) != 1 || got[0].ID != "t3" {
+		t.Errorf("Expected only t3 left, got %+v", got)
+	}
+}
+
+func TestOrderRepository_BooksAreServedByMatchers(t *testing.T) {
+	setupTest()
+	book := orderStore.Book("BTC-USD")
+	restOn(&book.asks, Order{ID: "sell-1", Symbol: "BTC-USD", Side: SideSell, Price: 100.0, Quantity: 5})
+	resetSymbols([]string{"BTC-USD"})
+
+	m, _ := matcherFor("BTC-USD")
+	placeOn(m, Order{ID: "buy-1", Symbol: "BTC-USD", Side: SideBuy, Price: 100.0, Quantity: 5})
+
+	if trades := tradeStore.List("BTC-USD"); len(trades) != 1 || trades[0].MakerID != "sell-1" {
+		t.Errorf("Expected the stored ask to fill the order, got %+v", trades)
+	}
+	if len(book.asks.orders()) != 0 {
+		t.Errorf("Expected the matcher to work on the stored book, got %+v", book.asks.orders())
+	}
+}
+
+func TestTradeRepository_CanBeSwapped(t *testing.T) {
+	setupTest()
+	counting := &countingTrades{TradeRepository: newMemoryTradeRepository(0)}
+	tradeStore = counting
+
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "sell-1", Side: SideSell, Price: 100.0, Quantity: 5})
+	placeOn(m, Order{ID: "buy-1", Side: SideBuy, Price: 100.0, Quantity: 5})
+
+	if counting.added != 1 {
+		t.Errorf("Expected the trade to go to the swapped repository, got %d", counting.added)
+	}
+	if trades := tradeStore.List(""); len(trades) != 1 || trades[0].TakerID != "buy-1" {
+		t.Errorf("Expected the trade to be listed, got %+v", trades)
+	}
+}
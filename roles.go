@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Role is a kind of access an API key is granted. Every route needs one.
+type Role string
+
+const (
+	// RoleRead reads orders, trades, market data and accounts, and follows
+	// the streams that carry them
+	RoleRead Role = "read"
+	// RoleTrade places, amends and cancels orders and moves balances
+	RoleTrade Role = "trade"
+	// RoleAdmin calls the /admin endpoints, such as restores and promotion
+	RoleAdmin Role = "admin"
+	// RoleSurveillance follows the drop copy and reads surveillance alerts
+	RoleSurveillance Role = "surveillance"
+)
+
+// allRoles lists every role, which keys get when no roles are configured
+var allRoles = []Role{RoleRead, RoleTrade, RoleAdmin, RoleSurveillance}
+
+// requiredRole is the role a route needs: the one it names, or else
+// surveillance for compliance routes, admin under /admin, read for GETs,
+// which covers the streams, and trade for everything else
+func (route apiRoute) requiredRole() Role {
+	switch {
+	case route.role != "":
+		return route.role
+	case route.compliance:
+		return RoleSurveillance
+	case strings.HasPrefix(route.path, apiPrefix+"/admin/"):
+		return RoleAdmin
+	case route.method == "GET":
+		return RoleRead
+	}
+	return RoleTrade
+}
+
+// rolesFor is the roles of an API key under cfg
+func (cfg HTTPConfig) rolesFor(key string) []Role {
+	if roles, ok := cfg.KeyRoles[key]; ok && key != "" {
+		return roles
+	}
+	if len(cfg.DefaultRoles) > 0 {
+		return cfg.DefaultRoles
+	}
+	return allRoles
+}
+
+// complianceKeys are the keys the compliance routes are served to: those in
+// -drop-copy-keys and those granted the surveillance role
+func (cfg HTTPConfig) complianceKeys() []string {
+	keys := slices.Clone(cfg.DropCopyKeys)
+	for key, roles := range cfg.KeyRoles {
+		if slices.Contains(roles, RoleSurveillance) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// requireRole rejects callers whose API key or token lacks role
+func requireRole(cfg HTTPConfig, role Role) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			roles, holder := cfg.rolesFor(requestAPIKey(r)), "API key"
+			if identity, ok := tokenFrom(r.Context()); ok {
+				holder = "token"
+				if identity.roles != nil {
+					roles = identity.roles
+				}
+			}
+			if !slices.Contains(roles, role) {
+				writeError(w, http.StatusForbidden, ErrCodeForbidden, "Forbidden",
+					fmt.Sprintf("this endpoint needs the %s role; the %s has %s", role, holder, joinRoles(roles)))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// joinRoles lists roles for an error message
+func joinRoles(roles []Role) string {
+	names := make([]string, len(roles))
+	for i, role := range roles {
+		names[i] = string(role)
+	}
+	return strings.Join(names, "+")
+}
+
+// parseRoles reads the -key-roles list of key=role+role entries and the
+// -default-roles list into cfg
+func (cfg *HTTPConfig) parseRoles(list, defaults string) error {
+	for _, item := range splitList(list) {
+		key, granted, ok := strings.Cut(item, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return fmt.Errorf("-key-roles entry %q must be key=role+role", item)
+		}
+		roles, err := parseRoleList(granted)
+		if err != nil {
+			return err
+		}
+		if cfg.KeyRoles == nil {
+			cfg.KeyRoles = make(map[string][]Role)
+		}
+		cfg.KeyRoles[strings.TrimSpace(key)] = roles
+	}
+
+	if defaults != "" {
+		roles, err := parseRoleList(defaults)
+		if err != nil {
+			return err
+		}
+		cfg.DefaultRoles = roles
+	}
+	return nil
+}
+
+// parseRoleList reads roles joined with +, such as read+trade
+func parseRoleList(list string) ([]Role, error) {
+	var roles []Role
+	for _, name := range strings.Split(list, "+") {
+		role := Role(strings.TrimSpace(name))
+		if !slices.Contains(allRoles, role) {
+			return nil, fmt.Errorf("role must be read, trade, admin or surveillance (received: %q)", name)
+		}
+		if !slices.Contains(roles, role) {
+			roles = append(roles, role)
+		}
+	}
+	return roles, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// roleConfig grants one key each kind of access and everyone else read only
+var roleConfig = HTTPConfig{
+	APIKeys: []string{"reader", "trader", "ops", "compliance", "other"},
+	KeyRoles: map[string][]Role{
+		"reader":     {RoleRead},
+		"trader":     {RoleRead, RoleTrade},
+		"ops":        {RoleAdmin},
+		"compliance": {RoleSurveillance},
+	},
+	DefaultRoles: []Role{RoleRead},
+}
+
+// callAs sends a request with an API key
+func callAs(cfg HTTPConfig, key, method, path, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	request.Header.Set("X-API-Key", key)
+	return serve(cfg, request)
+}
+
+func TestRoles_GateEveryEndpoint(t *testing.T) {
+	setupTest()
+	order := `{"side":"buy","price":100,"quantity":1}`
+	cases := []struct {
+		key, method, path, body string
+		allowed                 bool
+	}{
+		{"reader", "GET", "/api/v1/orders", "", true},
+		{"reader", "POST", "/api/v1/orders", order, false},
+		{"reader", "DELETE", "/api/v1/orders/missing", "", false},
+		{"reader", "POST", "/api/v1/graphql", `{"query":"{ symbols }"}`, true},
+		{"reader", "GET", "/api/v1/admin/queues", "", false},
+		{"other", "POST", "/api/v1/orders/cancel-batch", `{"owner":"alice"}`, false},
+		{"trader", "POST", "/api/v1/orders", order, true},
+		{"trader", "POST", "/api/v1/admin/snapshot", "", false},
+		{"ops", "GET", "/api/v1/admin/queues", "", true},
+		{"ops", "GET", "/api/v1/orders", "", false},
+		{"compliance", "GET", "/api/v1/surveillance/alerts", "", true},
+		{"trader", "GET", "/api/v1/surveillance/alerts", "", false},
+	}
+	for _, c := range cases {
+		response := callAs(roleConfig, c.key, c.method, c.path, c.body)
+		if forbidden := response.Code == http.StatusForbidden; forbidden == c.allowed {
+			t.Errorf("%s %s %s: expected allowed=%v, got %d %s", c.key, c.method, c.path, c.allowed, response.Code, response.Body)
+		}
+	}
+
+	result := decodeError(t, callAs(roleConfig, "reader", "POST", "/api/v1/orders", order))
+	if result.Error.Code != ErrCodeForbidden || result.Error.Details != "this endpoint needs the trade role; the API key has read" {
+		t.Errorf("Expected FORBIDDEN naming the roles, got %+v", result.Error)
+	}
+}
+
+func TestRoles_GateWebSocketChannels(t *testing.T) {
+	setupTest()
+	// A refused handshake never reaches the upgrade
+	cases := []struct {
+		key, path string
+		allowed   bool
+	}{
+		{"reader", "/api/v1/depth/stream", true},
+		{"reader", "/api/v1/rpc", false},
+		{"trader", "/api/v1/rpc", true},
+		{"reader", "/api/v1/feed/drop-copy", false},
+		{"compliance", "/api/v1/feed/drop-copy", true},
+		{"trader", "/api/v1/admin/replication/stream", false},
+	}
+	for _, c := range cases {
+		response := callAs(roleConfig, c.key, "GET", c.path, "")
+		if forbidden := response.Code == http.StatusForbidden; forbidden == c.allowed {
+			t.Errorf("%s %s: expected allowed=%v, got %d %s", c.key, c.path, c.allowed, response.Code, response.Body)
+		}
+	}
+}
+
+func TestLoadConfig_KeyRoles(t *testing.T) {
+	cfg, err := loadConfig([]string{"-key-roles", "a=read+trade, b=admin+admin", "-default-roles", "read"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]Role{"a": {RoleRead, RoleTrade}, "b": {RoleAdmin}}
+	if !reflect.DeepEqual(cfg.HTTP.KeyRoles, want) || !reflect.DeepEqual(cfg.HTTP.DefaultRoles, []Role{RoleRead}) {
+		t.Errorf("Expected %v and read, got %v and %v", want, cfg.HTTP.KeyRoles, cfg.HTTP.DefaultRoles)
+	}
+	for _, args := range [][]string{{"-key-roles", "a"}, {"-key-roles", "a=write"}, {"-key-roles", "a="}, {"-default-roles", "root"}} {
+		if _, err := loadConfig(args); err == nil {
+			t.Errorf("Expected %v refused", args)
+		}
+	}
+}
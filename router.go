@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Router forwards the part of an order the local book could not fill to an
+// external venue and reports back what it filled there
+type Router interface {
+	Route(order Order) ([]RoutedFill, error)
+}
+
+// RouterFunc adapts an ordinary function to the Router interface
+type RouterFunc func(order Order) ([]RoutedFill, error)
+
+func (f RouterFunc) Route(order Order) ([]RoutedFill, error) {
+	return f(order)
+}
+
+// RoutedFill is one execution reported by an external venue
+type RoutedFill struct {
+	Venue string `json:"venue"`
+	// ExternalID is the venue's ID for the order that took the other side
+	ExternalID string  `json:"external_id,omitempty"`
+	Price      float64 `json:"price"`
+	Quantity   int     `json:"quantity"`
+}
+
+// orderRouter receives every unfilled remainder; nil keeps all orders local.
+// It is called on the symbol's matcher, so a slow venue holds up that symbol.
+var orderRouter Router
+
+// routeRemainder offers an order's unfilled quantity to the router and books
+// whatever the venue filled as trades against the order, appending them to
+// executedTrades
+func routeRemainder(order Order, executedTrades []Trade) (Order, []Trade) {
+	// Pegged orders are meant to rest, so they stay local
+	if orderRouter == nil || order.Quantity == 0 || order.Type == OrderTypePegged {
+		return order, executedTrades
+	}
+
+	fills, err := orderRouter.Route(order)
+	if err != nil {
+		log.Printf("router: order %s stays local: %v", order.ID, err)
+		return order, executedTrades
+	}
+
+	for _, fill := range fills {
+		if err := checkRoutedFill(order, fill); err != nil {
+			log.Printf("router: dropping fill for order %s: %v", order.ID, err)
+			continue
+		}
+
+		trade := Trade{
+			ID:        generateTradeID(),
+			Symbol:    order.Symbol,
+			MakerID:   fill.ExternalID,
+			TakerID:   order.ID,
+			Price:     fill.Price,
+			Quantity:  fill.Quantity,
+			Venue:     fill.Venue,
+			CreatedAt: engineClock.Now(),
+
+			AggressorSide: order.Side,
+		}
+		setTradeAmounts(&trade)
+		executedTrades = append(executedTrades, trade)
+		recordTrade(trade)
+
+		order.Quantity -= fill.Quantity
+		order.FilledQuantity += fill.Quantity
+		status, reason := OrderStatusPartiallyFilled, "partially filled on "+fill.Venue
+		if order.Quantity == 0 {
+			status, reason = OrderStatusFilled, "fully filled on "+fill.Venue
+		}
+		if err := transitionOrder(&order, status, reason); err != nil {
+			logTransitionError(err)
+		}
+	}
+	return order, executedTrades
+}
+
+// checkRoutedFill makes sure a venue's fill fits what is left of the order
+// and respects its limit price
+func checkRoutedFill(order Order, fill RoutedFill) error {
+	switch {
+	case fill.Venue == "":
+		return fmt.Errorf("fill has no venue")
+	case fill.Quantity <= 0:
+		return fmt.Errorf("%s reported quantity %d", fill.Venue, fill.Quantity)
+	case fill.Quantity > order.Quantity:
+		return fmt.Errorf("%s filled %d but only %d was routed", fill.Venue, fill.Quantity, order.Quantity)
+	case fill.Price <= 0:
+		return fmt.Errorf("%s reported price %.2f", fill.Venue, fill.Price)
+	case order.Type != OrderTypeMarket && order.Side == SideBuy && fill.Price > order.Price:
+		return fmt.Errorf("%s bought at %.2f above the %.2f limit", fill.Venue, fill.Price, order.Price)
+	case order.Type != OrderTypeMarket && order.Side == SideSell && fill.Price < order.Price:
+		return fmt.Errorf("%s sold at %.2f below the %.2f limit", fill.Venue, fill.Price, order.Price)
+	}
+	return nil
+}
+
+// RouteRequest is the body the webhook router posts for each routed order
+type RouteRequest struct {
+	OrderID  string    `json:"order_id"`
+	Symbol   string    `json:"symbol,omitempty"`
+	Side     Side      `json:"side"`
+	Type     OrderType `json:"type,omitempty"`
+	Price    float64   `json:"price"`
+	Quantity int       `json:"quantity"`
+}
+
+// RouteResponse is the body a webhook venue adapter answers with
+type RouteResponse struct {
+	Fills []RoutedFill `json:"fills"`
+}
+
+// WebhookRouter routes orders by posting them to an external venue adapter
+type WebhookRouter struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewWebhookRouter creates a router that posts to url and gives up after timeout
+func NewWebhookRouter(url string, timeout time.Duration) *WebhookRouter {
+	return &WebhookRouter{URL: url, Client: &http.Client{Timeout: timeout}}
+}
+
+func (r *WebhookRouter) Route(order Order) ([]RoutedFill, error) {
+	body, err := json.Marshal(RouteRequest{
+		OrderID:  order.ID,
+		Symbol:   order.Symbol,
+		Side:     order.Side,
+		Type:     order.Type,
+		Price:    order.Price,
+		Quantity: order.Quantity,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := r.Client.Post(r.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("venue adapter returned %s", resp.Status)
+	}
+
+	var route RouteResponse
+	if err := json.NewDecoder(resp.Body).Decode(&route); err != nil {
+		return nil, fmt.Errorf("decoding venue adapter response: %w", err)
+	}
+	return route.Fills, nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// useRouter installs a router for the duration of a test
+func useRouter(t *testing.T, router Router) {
+	t.Helper()
+
+	previous := orderRouter
+	orderRouter = router
+	t.Cleanup(func() {
+		orderRouter = previous
+	})
+}
+
+// venueFill returns a router that fills routed orders at price, up to quantity each
+func venueFill(price float64, quantity int, routed *[]Order) Router {
+	return RouterFunc(func(order Order) ([]RoutedFill, error) {
+		*routed = append(*routed, order)
+		return []RoutedFill{{Venue: "EXT", ExternalID: "ext-1", Price: price, Quantity: min(quantity, order.Quantity)}}, nil
+	})
+}
+
+func TestRouter_FillsRemainderExternally(t *testing.T) {
+	setupTest()
+	var routed []Order
+	useRouter(t, venueFill(99.9, 100, &routed))
+
+	quote("ask-1", SideSell, 100.0, 4)
+	routed = nil
+	result := quote("buy-1", SideBuy, 100.0, 10)
+
+	if len(routed) != 1 || routed[0].Quantity != 6 || routed[0].Price != 100.0 {
+		t.Fatalf("Expected the 6 unfilled to be routed at 100.00, got %+v", routed)
+	}
+
+	if result.Status != OrderStatusFilled || result.Quantity != 0 {
+		t.Errorf("Expected buy-1 to be filled, got %s with %d left", result.Status, result.Quantity)
+	}
+
+	if len(tradeStore.List("")) != 2 {
+		t.Fatalf("Expected a local and an external trade, got %+v", tradeStore.List(""))
+	}
+	external := tradeStore.List("")[1]
+	if external.Venue != "EXT" || external.MakerID != "ext-1" || external.TakerID != "buy-1" || external.Quantity != 6 || external.Price != 99.9 {
+		t.Errorf("Unexpected external trade %+v", external)
+	}
+	if external.AggressorSide != SideBuy || external.TickDirection != "" {
+		t.Errorf("Expected a routed buy with no tick direction, got %+v", external)
+	}
+	if tradeStore.List("")[0].Venue != "" {
+		t.Errorf("Expected the local trade to have no venue, got %q", tradeStore.List("")[0].Venue)
+	}
+
+	if len(bookFor("").bids.orders()) != 0 {
+		t.Errorf("Expected nothing to rest, got %+v", bookFor("").bids.orders())
+	}
+}
+
+func TestRouter_RestsWhatTheVenueLeaves(t *testing.T) {
+	setupTest()
+	var routed []Order
+	useRouter(t, venueFill(100.0, 3, &routed))
+
+	result := quote("buy-1", SideBuy, 100.0, 10)
+
+	if result.Status != OrderStatusPartiallyFilled || result.Quantity != 7 {
+		t.Fatalf("Expected 7 left partially filled, got %s with %d", result.Status, result.Quantity)
+	}
+	if len(bookFor("").bids.orders()) != 1 || bookFor("").bids.orders()[0].Quantity != 7 {
+		t.Errorf("Expected the remaining 7 to rest, got %+v", bookFor("").bids.orders())
+	}
+}
+
+func TestRouter_ErrorKeepsOrderLocal(t *testing.T) {
+	setupTest()
+	useRouter(t, RouterFunc(func(Order) ([]RoutedFill, error) {
+		return nil, errors.New("venue down")
+	}))
+
+	result := processOrder(Order{ID: "ioc-1", Side: SideBuy, Price: 100.0, Quantity: 5, TimeInForce: TimeInForceIOC, Status: OrderStatusPending, CreatedAt: time.Now()})
+
+	if result.Status != OrderStatusRejected || result.RejectReason != ErrCodeNoLiquidity {
+		t.Errorf("Expected the IOC to be rejected for no liquidity, got %s %s", result.Status, result.RejectReason)
+	}
+	if len(tradeStore.List("")) != 0 {
+		t.Errorf("Expected no trades, got %+v", tradeStore.List(""))
+	}
+}
+
+func TestRouter_DropsInvalidFills(t *testing.T) {
+	setupTest()
+	useRouter(t, RouterFunc(func(order Order) ([]RoutedFill, error) {
+		return []RoutedFill{
+			{Venue: "EXT", Price: 100.5, Quantity: 1},
+			{Venue: "EXT", Price: 99.0, Quantity: 99},
+			{Venue: "EXT", Price: 99.0, Quantity: 0},
+			{Price: 99.0, Quantity: 1},
+			{Venue: "EXT", Price: 99.0, Quantity: 2},
+		}, nil
+	}))
+
+	result := quote("buy-1", SideBuy, 100.0, 5)
+
+	if len(tradeStore.List("")) != 1 || tradeStore.List("")[0].Quantity != 2 || tradeStore.List("")[0].Price != 99.0 {
+		t.Fatalf("Expected only the valid 2 @ 99.00 fill to book, got %+v", tradeStore.List(""))
+	}
+	if result.Quantity != 3 {
+		t.Errorf("Expected 3 left, got %d", result.Quantity)
+	}
+}
+
+func TestRouter_SkipsPeggedOrders(t *testing.T) {
+	setupTest()
+	var routed []Order
+	quote("bid-1", SideBuy, 99.0, 5)
+	quote("ask-1", SideSell, 101.0, 5)
+	useRouter(t, venueFill(100.0, 100, &routed))
+
+	pegged("peg-1", SideBuy, PegMidpoint, 0, 5)
+
+	if len(routed) != 0 {
+		t.Errorf("Expected the pegged order to stay local, got %+v", routed)
+	}
+}
+
+func TestRouter_ExternalFillsDoNotTriggerStops(t *testing.T) {
+	setupTest()
+	tradeAt(t, 100.0)
+	stop := processOrder(trailingStop("stop-1", SideSell, 5, 1.0, 0))
+
+	var routed []Order
+	useRouter(t, venueFill(90.0, 100, &routed))
+	processOrder(Order{ID: "sell-1", Side: SideSell, Price: 90.0, Quantity: 5, Status: OrderStatusPending, CreatedAt: time.Now()})
+
+	if _, ok := findStop(stop.ID); !ok {
+		t.Error("Expected the stop to keep waiting after an external fill through its trigger")
+	}
+}
+
+func TestWebhookRouter_PostsOrderAndReadsFills(t *testing.T) {
+	var got RouteRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		json.NewEncoder(w).Encode(RouteResponse{Fills: []RoutedFill{{Venue: "EXT", ExternalID: "x-9", Price: 100.0, Quantity: 2}}})
+	}))
+	defer server.Close()
+
+	router := NewWebhookRouter(server.URL, time.Second)
+	fills, err := router.Route(Order{ID: "buy-1", Symbol: "BTC-USD", Side: SideBuy, Price: 100.0, Quantity: 5})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if got.OrderID != "buy-1" || got.Symbol != "BTC-USD" || got.Side != SideBuy || got.Quantity != 5 {
+		t.Errorf("Unexpected route request %+v", got)
+	}
+	if len(fills) != 1 || fills[0].ExternalID != "x-9" || fills[0].Quantity != 2 {
+		t.Errorf("Unexpected fills %+v", fills)
+	}
+}
+
+func TestWebhookRouter_ReportsAdapterErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	if _, err := NewWebhookRouter(server.URL, time.Second).Route(Order{ID: "buy-1"}); err == nil {
+		t.Error("Expected an error for a non-200 response")
+	}
+}
+
+func TestLoadConfig_RouterFlags(t *testing.T) {
+	cfg, err := loadConfig([]string{"-router-url", "http://venue.local/route", "-router-timeout", "500ms"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if cfg.RouterURL != "http://venue.local/route" || cfg.RouterTimeout != 500*time.Millisecond {
+		t.Errorf("Unexpected router config %q %s", cfg.RouterURL, cfg.RouterTimeout)
+	}
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// RPCRequest is one JSON-RPC 2.0 call. A call without an ID is a
+// notification: it runs, but nothing is sent back.
+type RPCRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	// Seq is the call's client sequence number on a sequenced session
+	Seq int64 `json:"seq,omitempty"`
+}
+
+// RPCResponse answers one call with either a result or an error. The ID is
+// the call's, or null when the call could not be read.
+type RPCResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+	// Seq is the sequence number of the call answered on a sequenced
+	// session, and Resent marks an answer sent before for a duplicate of it
+	Seq    int64 `json:"seq,omitempty"`
+	Resent bool  `json:"resent,omitempty"`
+}
+
+// RPCError is a JSON-RPC error. Its data carries the same error code the REST
+// endpoints would have returned.
+type RPCError struct {
+	Code    int           `json:"code"`
+	Message string        `json:"message"`
+	Data    *RPCErrorData `json:"data,omitempty"`
+}
+
+// RPCErrorData explains an RPCError in the terms of the REST error envelope
+type RPCErrorData struct {
+	Code    ErrorCode   `json:"code"`
+	Details interface{} `json:"details,omitempty"`
+	OrderID string      `json:"order_id,omitempty"`
+}
+
+// JSON-RPC error codes. The engine's own refusals share rpcRefused and are
+// told apart by the error code in their data.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcRefused        = -32000
+)
+
+// RPCCancelParams names the resting order order.cancel removes
+type RPCCancelParams struct {
+	OrderID string `json:"order_id" validate:"required"`
+	// Symbol is where the order rests; every symbol is searched when empty
+	Symbol string `json:"symbol,omitempty"`
+}
+
+// AmendOrderRequest changes a resting limit order's quantity, price or both.
+// Zero leaves that part as it is.
+type AmendOrderRequest struct {
+	OrderID  string  `json:"order_id" validate:"required"`
+	Symbol   string  `json:"symbol,omitempty"`
+	Quantity int     `json:"quantity,omitempty" validate:"min=0,max=999999999"`
+	Price    float64 `json:"price,omitempty" validate:"min=0,max=999999999.99"`
+}
+
+// validate requires something to change
+func (req AmendOrderRequest) validate() []FieldError {
+	if req.Quantity == 0 && req.Price == 0 {
+		return []FieldError{fieldError("quantity", "required", "give a new quantity, a new price or both")}
+	}
+	return nil
+}
+
+// AmendOrderResponse carries the amended order in its new state
+type AmendOrderResponse struct {
+	Order Order `json:"order"`
+}
+
+// rpcMethods maps each JSON-RPC method to the function that runs it
+var rpcMethods = map[string]func(ctx context.Context, params json.RawMessage) (interface{}, *RPCError){
+	"order.place":    rpcPlaceOrder,
+	"order.cancel":   rpcCancelOrder,
+	"order.amend":    rpcAmendOrder,
+	rpcSessionStatus: rpcGetSessionStatus,
+}
+
+// rpcFailure builds an error from a REST error code
+func rpcFailure(code int, errCode ErrorCode, message string, details interface{}) *RPCError {
+	return &RPCError{Code: code, Message: message, Data: &RPCErrorData{Code: errCode, Details: details}}
+}
+
+// decodeParams reads a call's params into v and validates them, like
+// decodeRequest does for a REST body
+func decodeParams(params json.RawMessage, v interface{}) *RPCError {
+	if len(params) == 0 || bytes.Equal(params, []byte("null")) {
+		params = []byte("{}")
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return rpcFailure(rpcInvalidParams, ErrCodeInvalidJSON, "Invalid params", err.Error())
+	}
+	if errs := validateRequest(v); len(errs) > 0 {
+		return rpcFailure(rpcInvalidParams, ErrCodeValidationFailed, "Validation failed", errs)
+	}
+	return nil
+}
+
+// unknownSymbol refuses a call for a symbol that is not traded here
+func unknownSymbol(symbol string) *RPCError {
+	return rpcFailure(rpcRefused, ErrCodeUnknownSymbol, "Unknown symbol", "symbol '"+symbol+"' is not traded here")
+}
+
+// orderNotFound refuses a call for an order that is not resting
+func orderNotFound(orderID string) *RPCError {
+	return rpcFailure(rpcRefused, ErrCodeOrderNotFound, "Order not found", "No resting order with id '"+orderID+"'")
+}
+
+// throttledCall refuses a call on an order whose owner's throttle has no
+// weight left for it
+func throttledCall(orderID string) *RPCError {
+	failure := rpcFailure(rpcRefused, ErrCodeThrottled, rejectMessages[ErrCodeThrottled], nil)
+	failure.Data.OrderID = orderID
+	return failure
+}
+
+// rpcPlaceOrder places an order. Unlike the REST response, the result only
+// lists the trades this order took.
+func rpcPlaceOrder(ctx context.Context, params json.RawMessage) (interface{}, *RPCError) {
+	receivedAt := engineClock.Now()
+
+	var req PlaceOrderRequest
+	if err := decodeParams(params, &req); err != nil {
+		return nil, err
+	}
+	m, ok := matcherFor(req.Symbol)
+	if !ok {
+		return nil, unknownSymbol(req.Symbol)
+	}
+
+	order, err := submitOrder(ctx, m, newOrder(m.symbol, req, receivedAt))
+	if err == errEngineBusy {
+		failure := rpcFailure(rpcRefused, ErrCodeEngineBusy, "Engine busy", nil)
+		failure.Data.Details = busyDetails(m)
+		return nil, failure
+	} else if err != nil {
+		return nil, rpcFailure(rpcRefused, ErrCodeRequestTimeout, "Request timed out", timeoutDetails(err))
+	}
+	traced := spanFrom(ctx)
+	traced.set("order.id", order.ID)
+	traced.set("order.status", string(order.Status))
+	if order.Status == OrderStatusRejected {
+		failure := rpcFailure(rpcRefused, order.RejectReason, rejectMessages[order.RejectReason], nil)
+		failure.Data.OrderID = order.ID
+		return nil, failure
+	}
+
+	trades, _ := storeCall(ctx, func() []Trade { return tradeStore.TakerFills(order.ID, order.FilledQuantity) })
+	latency := latencyOf(order)
+	return PlaceOrderResponse{
+		OrderID: order.ID,
+		Status:  order.Status,
+		Trades:  trades,
+		Latency: &latency,
+	}, nil
+}
+
+// rpcCancelOrder cancels a resting order or untriggered stop
+func rpcCancelOrder(ctx context.Context, params json.RawMessage) (interface{}, *RPCError) {
+	var req RPCCancelParams
+	if err := decodeParams(params, &req); err != nil {
+		return nil, err
+	}
+	if req.Symbol != "" {
+		if _, ok := matcherFor(req.Symbol); !ok {
+			return nil, unknownSymbol(req.Symbol)
+		}
+	}
+
+	order, code := cancelOnAnySymbol(ctx, req.Symbol, req.OrderID)
+	switch code {
+	case ErrCodeOrderNotFound:
+		return nil, orderNotFound(req.OrderID)
+	case ErrCodeThrottled:
+		return nil, throttledCall(req.OrderID)
+	case ErrCodeRequestTimeout:
+		return nil, rpcFailure(rpcRefused, ErrCodeRequestTimeout, "Request timed out", timeoutDetails(ctx.Err()))
+	}
+	return CancelOrderResponse{Order: order}, nil
+}
+
+// rpcAmendOrder changes a resting order's quantity or price in place
+func rpcAmendOrder(ctx context.Context, params json.RawMessage) (interface{}, *RPCError) {
+	var req AmendOrderRequest
+	if err := decodeParams(params, &req); err != nil {
+		return nil, err
+	}
+	if req.Symbol != "" {
+		if _, ok := matcherFor(req.Symbol); !ok {
+			return nil, unknownSymbol(req.Symbol)
+		}
+	}
+
+	for _, m := range allMatchers() {
+		if req.Symbol != "" && m.symbol != req.Symbol {
+			continue
+		}
+
+		var order Order
+		var failure *RPCError
+		found := false
+		if err := m.doContext(ctx, func() {
+			expireOrders(m.book, engineClock.Now())
+			order, failure, found = amendOrder(m.book, req)
+		}); err != nil {
+			return nil, rpcFailure(rpcRefused, ErrCodeRequestTimeout, "Request timed out", timeoutDetails(err))
+		}
+		if found {
+			if failure != nil {
+				return nil, failure
+			}
+			return AmendOrderResponse{Order: order}, nil
+		}
+	}
+	return nil, orderNotFound(req.OrderID)
+}
+
+// amendOrder applies an amendment to the resting order it names, reporting
+// whether the order rests in this book. A new quantity alone is a mass-quote
+// style amendment. A new price moves the order to the back of its new level,
+// and is refused if it would cross the book: amending never trades. Every
+// amendment is charged to the owner's throttle. It must run on the book's
+// matcher.
+func amendOrder(book *OrderBook, req AmendOrderRequest) (Order, *RPCError, bool) {
+	side, handle, ok := findResting(book, req.OrderID)
+	if !ok {
+		return Order{}, nil, false
+	}
+
+	order := side.at(handle)
+	if !throttleAllows(order.Owner, ThrottleAmend) {
+		return order, throttledCall(order.ID), true
+	}
+	if req.Price == 0 || req.Price == order.Price {
+		if req.Quantity == 0 || req.Quantity == order.Quantity {
+			return order, nil, true
+		}
+		return amendQuantity(book, order, req.Quantity, "amend request"), nil, true
+	}
+
+	if order.Type == OrderTypePegged {
+		return order, rpcFailure(rpcInvalidParams, ErrCodeValidationFailed, "Validation failed",
+			[]FieldError{fieldError("price", "pegged", "pegged orders take their price from the book")}), true
+	}
+	amended := order
+	amended.Price = req.Price
+	if req.Quantity > 0 {
+		amended.Quantity = req.Quantity
+	}
+	if crossesBook(book, amended) {
+		return order, rpcFailure(rpcRefused, ErrCodeWouldCross, rejectMessages[ErrCodeWouldCross], nil), true
+	}
+
+	_, position := takeResting(book, side, handle)
+	adjustLevel(book, order.Side, order.Price, -order.Quantity, -1)
+	publishL3(book, L3Delete, order, position, -order.Quantity, "")
+	requeue(&amended)
+	addToOrderBook(amended)
+	reason := fmt.Sprintf("amended to %d at %g by amend request", amended.Quantity, amended.Price)
+	recordOrderEvent(amended.ID, amended.Status, amended.Status, reason)
+	feed.order(amended, reason)
+	repricePegs(book)
+	return amended, nil, true
+}
+
+// crossesBook reports whether an order would trade against the other side
+func crossesBook(book *OrderBook, order Order) bool {
+	opposite := &book.asks
+	if order.Side == SideSell {
+		opposite = &book.bids
+	}
+	best, ok := opposite.first()
+	return ok && priceCrosses(order, best)
+}
+
+// callRPC runs one call within ctx, returning nil for a notification. On a
+// sequenced session, only the call next in sequence runs.
+func callRPC(ctx context.Context, raw json.RawMessage) *RPCResponse {
+	var req RPCRequest
+	if err := json.Unmarshal(raw, &req); err != nil || req.JSONRPC != "2.0" || req.Method == "" {
+		return &RPCResponse{JSONRPC: "2.0", ID: req.ID,
+			Error: &RPCError{Code: rpcInvalidRequest, Message: "Invalid request: expected a JSON-RPC 2.0 object with a method"}}
+	}
+	session := sequencedSessionFrom(ctx)
+	if reply, ok := session.admit(req); !ok {
+		return reply
+	}
+
+	var result interface{}
+	var failure *RPCError
+	if method, ok := rpcMethods[req.Method]; !ok {
+		failure = &RPCError{Code: rpcMethodNotFound, Message: "Method not found: " + req.Method}
+	} else if standby.running.Load() {
+		failure = rpcFailure(rpcRefused, ErrCodeStandby, "Server is a standby",
+			"send writes to the primary, or promote this server first")
+	} else {
+		callCtx, cancel := callContext(ctx)
+		callCtx, called := startSpan(callCtx, req.Method, spanKindServer)
+		called.set("rpc.system", "jsonrpc")
+		called.set("rpc.method", req.Method)
+		result, failure = method(callCtx, req.Params)
+		if failure != nil {
+			called.set("rpc.jsonrpc.error_code", failure.Code)
+			called.fail(failure.Message)
+		}
+		called.finish()
+		cancel()
+	}
+
+	var response *RPCResponse
+	if len(req.ID) != 0 {
+		response = &RPCResponse{JSONRPC: "2.0", ID: req.ID, Result: result, Error: failure, Seq: req.Seq}
+	}
+	session.record(req, response)
+	return response
+}
+
+// handleRPC answers one WebSocket message, which holds a call or a batch of
+// them, and returns what to send back; nil when there is nothing to send.
+// Each call gets the connection's request timeout.
+func handleRPC(ctx context.Context, message []byte) interface{} {
+	message = bytes.TrimSpace(message)
+	if !json.Valid(message) {
+		return RPCResponse{JSONRPC: "2.0", Error: &RPCError{Code: rpcParseError, Message: "Parse error: the message is not JSON"}}
+	}
+	if message[0] != '[' {
+		if response := callRPC(ctx, message); response != nil {
+			return response
+		}
+		return nil
+	}
+
+	var batch []json.RawMessage
+	json.Unmarshal(message, &batch)
+	if len(batch) == 0 {
+		return RPCResponse{JSONRPC: "2.0", Error: &RPCError{Code: rpcInvalidRequest, Message: "Invalid request: the batch is empty"}}
+	}
+	// Calls in a batch run in order, so a cancel can follow the place it undoes
+	var responses []*RPCResponse
+	for _, raw := range batch {
+		if response := callRPC(ctx, raw); response != nil {
+			responses = append(responses, response)
+		}
+	}
+	if len(responses) == 0 {
+		return nil
+	}
+	return responses
+}
+
+// rpcHandler takes order entry calls over a WebSocket using JSON-RPC 2.0.
+// Calls on one connection run one at a time, in the order they arrive. A
+// connection opened with ?session= carries on the caller's sequenced session
+// of that name, which only one connection may have at a time.
+func rpcHandler(w http.ResponseWriter, r *http.Request) {
+	if standby.running.Load() {
+		writeError(w, http.StatusServiceUnavailable, ErrCodeStandby, "Server is a standby",
+			"send writes to the primary, or promote this server first")
+		return
+	}
+	subscriptions := []string{"rpc"}
+	var sequenced *sequencedSession
+	if name := r.URL.Query().Get("session"); name != "" {
+		if sequenced = connectSequenced(requestAPIKey(r), name); sequenced == nil {
+			writeError(w, http.StatusConflict, ErrCodeSessionInUse, "Session in use",
+				"order entry session '"+name+"' is already connected")
+			return
+		}
+		subscriptions = append(subscriptions, "session:"+name)
+	}
+	ctx := withSequencedSession(r.Context(), sequenced)
+	conn, err := depthUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already written an error response
+		sequenced.disconnect()
+		return
+	}
+	defer conn.Close()
+
+	session := openSession(SessionRPC, r, subscriptions, 0, func() int { return 0 })
+	defer session.close()
+	// The sequenced session is free again before the connection leaves the
+	// session list
+	defer sequenced.disconnect()
+
+	// A kick closes the connection, which ends the read below
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-session.kicked:
+			session.writeKicked(conn)
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		response := handleRPC(ctx, message)
+		if response == nil {
+			continue
+		}
+		if err := conn.WriteJSON(response); err != nil {
+			return
+		}
+		session.sent.Add(1)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// callOver sends one JSON-RPC message and returns the reply
+func callOver(t *testing.T, conn *websocket.Conn, message string) RPCResponse {
+	t.Helper()
+	conn.WriteMessage(websocket.TextMessage, []byte(message))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var response RPCResponse
+	if err := conn.ReadJSON(&response); err != nil {
+		t.Fatalf("Expected a reply to %s, got %v", message, err)
+	}
+	return response
+}
+
+// rpcResult decodes a successful response's result into v
+func rpcResult(t *testing.T, response RPCResponse, v interface{}) {
+	t.Helper()
+	if response.Error != nil {
+		t.Fatalf("Expected a result, got %+v", response.Error)
+	}
+	raw, _ := json.Marshal(response.Result)
+	json.Unmarshal(raw, v)
+}
+
+func TestRPC_OrderEntry(t *testing.T) {
+	setupTest()
+	server := httptest.NewServer(newServer(HTTPConfig{}))
+	defer server.Close()
+	conn := dialFeed(t, server, "/api/v1/rpc", "")
+	defer conn.Close()
+
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "earlier", Symbol: "DEFAULT", Side: SideSell, Price: 101.0, Quantity: 1})
+	placeOn(m, Order{ID: "ask-1", Symbol: "DEFAULT", Side: SideSell, Price: 101.0, Quantity: 2})
+
+	var placed PlaceOrderResponse
+	response := callOver(t, conn, `{"jsonrpc": "2.0", "id": 1, "method": "order.place", "params": {"side": "buy", "price": 101.0, "quantity": 1}}`)
+	rpcResult(t, response, &placed)
+	if string(response.ID) != "1" || placed.Status != OrderStatusFilled || len(placed.Trades) != 1 || placed.Trades[0].MakerID != "earlier" {
+		t.Errorf("Expected a fill against the earlier ask alone, got %+v", placed)
+	}
+
+	response = callOver(t, conn, `{"jsonrpc": "2.0", "id": "bid", "method": "order.place", "params": {"side": "buy", "price": 99.0, "quantity": 5}}`)
+	rpcResult(t, response, &placed)
+	bid := placed.OrderID
+
+	// Amending never trades
+	response = callOver(t, conn, `{"jsonrpc": "2.0", "id": 2, "method": "order.amend", "params": {"order_id": "`+bid+`", "price": 101.0}}`)
+	if response.Error == nil || response.Error.Code != rpcRefused || response.Error.Data.Code != ErrCodeWouldCross {
+		t.Errorf("Expected the crossing amend to be refused, got %+v", response.Error)
+	}
+
+	var amended AmendOrderResponse
+	response = callOver(t, conn, `{"jsonrpc": "2.0", "id": 3, "method": "order.amend", "params": {"order_id": "`+bid+`", "quantity": 3}}`)
+	rpcResult(t, response, &amended)
+	if amended.Order.Quantity != 3 || amended.Order.Price != 99.0 {
+		t.Errorf("Expected 3 at 99, got %+v", amended.Order)
+	}
+	response = callOver(t, conn, `{"jsonrpc": "2.0", "id": 4, "method": "order.amend", "params": {"order_id": "`+bid+`", "price": 100.0, "quantity": 4}}`)
+	rpcResult(t, response, &amended)
+	if snapshot := m.depthSnapshot(0); len(snapshot.Bids) != 1 || snapshot.Bids[0].Price != 100.0 || snapshot.Bids[0].Quantity != 4 {
+		t.Errorf("Expected the bid to move to 4 at 100, got %+v", snapshot.Bids)
+	}
+
+	var cancelled CancelOrderResponse
+	response = callOver(t, conn, `{"jsonrpc": "2.0", "id": 5, "method": "order.cancel", "params": {"order_id": "`+bid+`"}}`)
+	rpcResult(t, response, &cancelled)
+	if cancelled.Order.Status != OrderStatusCancelled {
+		t.Errorf("Expected the bid to be cancelled, got %+v", cancelled.Order)
+	}
+	response = callOver(t, conn, `{"jsonrpc": "2.0", "id": 6, "method": "order.cancel", "params": {"order_id": "`+bid+`"}}`)
+	if response.Error == nil || response.Error.Data.Code != ErrCodeOrderNotFound {
+		t.Errorf("Expected ORDER_NOT_FOUND, got %+v", response.Error)
+	}
+
+	if sessions := sessionSnapshots(); len(sessions) != 1 || sessions[0].Kind != SessionRPC || sessions[0].Sent != 7 {
+		t.Errorf("Expected one RPC session that sent 7 replies, got %+v", sessions)
+	}
+}
+
+func TestHandleRPC_Framing(t *testing.T) {
+	setupTest()
+	encode := func(message string) string {
+		raw, _ := json.Marshal(handleRPC(context.Background(), []byte(message)))
+		return string(raw)
+	}
+
+	cases := []struct {
+		message string
+		want    string
+	}{
+		{`{"jsonrpc": "2.0", "id": 1, "method"`, `{"jsonrpc":"2.0","id":null,"error":{"code":-32700,`},
+		{`{"id": 1, "method": "order.place"}`, `{"jsonrpc":"2.0","id":1,"error":{"code":-32600,`},
+		{`{"jsonrpc": "2.0", "id": 1, "method": "order.fill"}`, `{"jsonrpc":"2.0","id":1,"error":{"code":-32601,`},
+		{`{"jsonrpc": "2.0", "id": 1, "method": "order.place", "params": {"side": "up", "quantity": 1}}`, `"code":-32602,"message":"Validation failed","data":{"code":"VALIDATION_FAILED"`},
+		{`{"jsonrpc": "2.0", "id": 1, "method": "order.amend", "params": {"order_id": "x"}}`, `give a new quantity, a new price or both`},
+		{`{"jsonrpc": "2.0", "id": 1, "method": "order.place", "params": {"symbol": "XYZ", "side": "buy", "price": 1, "quantity": 1}}`, `"code":-32000,"message":"Unknown symbol","data":{"code":"UNKNOWN_SYMBOL"`},
+		{`[]`, `{"jsonrpc":"2.0","id":null,"error":{"code":-32600,`},
+	}
+	for _, tc := range cases {
+		if got := encode(tc.message); !strings.Contains(got, tc.want) {
+			t.Errorf("%s: expected %s, got %s", tc.message, tc.want, got)
+		}
+	}
+
+	// Notifications run without a reply, and a batch answers its calls in order
+	if got := handleRPC(context.Background(), []byte(`{"jsonrpc": "2.0", "method": "order.place", "params": {"side": "sell", "price": 100, "quantity": 1}}`)); got != nil {
+		t.Errorf("Expected no reply to a notification, got %+v", got)
+	}
+	got := encode(`[{"jsonrpc": "2.0", "id": "a", "method": "order.place", "params": {"side": "buy", "price": 100, "quantity": 1}},
+		{"jsonrpc": "2.0", "method": "order.cancel", "params": {"order_id": "x"}}, 7]`)
+	if !strings.HasPrefix(got, `[{"jsonrpc":"2.0","id":"a","result":{"order_id":`) || !strings.Contains(got, `"status":"filled"`) ||
+		!strings.HasSuffix(got, `{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"Invalid request: expected a JSON-RPC 2.0 object with a method"}}]`) {
+		t.Errorf("Unexpected batch reply %s", got)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+)
+
+// sequencedSession is a named JSON-RPC order entry session whose calls carry
+// client sequence numbers. It outlives its connections, so a client that
+// reconnects and resends the calls it is unsure of has the ones already run
+// answered again instead of run twice.
+type sequencedSession struct {
+	name string
+
+	mu        sync.Mutex
+	connected bool
+	// next is the sequence number the next call must carry
+	next int64
+	// replies holds the responses to the latest calls, by sequence number;
+	// nil for a notification
+	replies map[int64]*RPCResponse
+}
+
+// SequenceDetails explains a call refused for its sequence number
+type SequenceDetails struct {
+	Expected int64 `json:"expected_seq"`
+	Received int64 `json:"received_seq"`
+}
+
+// SessionStatusResponse is where a sequenced session has got to
+type SessionStatusResponse struct {
+	Session string `json:"session"`
+	NextSeq int64  `json:"next_seq"`
+}
+
+// sequenceReplayLimit is how many of a session's latest responses are kept
+// to answer duplicates with
+const sequenceReplayLimit = 1000
+
+// rpcSessionStatus reports a session's next sequence number. It is not
+// sequenced itself, so a client that lost count can ask.
+const rpcSessionStatus = "session.status"
+
+// sequencedSessions is guarded by sequencedMu and keyed by the API key's
+// audit ID and the session's name, so clients cannot take over each other's
+// sessions
+var (
+	sequencedMu       sync.Mutex
+	sequencedSessions = make(map[string]*sequencedSession)
+)
+
+// connectSequenced opens the caller's named session on a new connection,
+// creating it on first use. It returns nil while another connection has it.
+func connectSequenced(key, name string) *sequencedSession {
+	sequencedMu.Lock()
+	id := auditKeyID(key) + "/" + name
+	s, ok := sequencedSessions[id]
+	if !ok {
+		s = &sequencedSession{name: name, next: 1, replies: make(map[int64]*RPCResponse)}
+		sequencedSessions[id] = s
+	}
+	sequencedMu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.connected {
+		return nil
+	}
+	s.connected = true
+	return s
+}
+
+// disconnect frees the session for the client's next connection
+func (s *sequencedSession) disconnect() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connected = false
+}
+
+// sequencedSessionKey is the context key for a connection's sequenced session
+type sequencedSessionKey struct{}
+
+// withSequencedSession returns ctx carrying the connection's session, if any
+func withSequencedSession(ctx context.Context, s *sequencedSession) context.Context {
+	if s == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, sequencedSessionKey{}, s)
+}
+
+// sequencedSessionFrom returns the connection's sequenced session, or nil
+func sequencedSessionFrom(ctx context.Context) *sequencedSession {
+	s, _ := ctx.Value(sequencedSessionKey{}).(*sequencedSession)
+	return s
+}
+
+// admit checks a call's sequence number before it runs. The next number in
+// line runs. One ahead of it leaves a gap and is refused, so the client can
+// resend from the number expected. One already run is answered with its
+// first response, marked as resent, without running again, or refused once
+// that response is no longer kept. It returns false and what to answer, nil
+// for a notification, when the call must not run.
+func (s *sequencedSession) admit(req RPCRequest) (*RPCResponse, bool) {
+	refuse := func(failure *RPCError) (*RPCResponse, bool) {
+		if len(req.ID) == 0 {
+			return nil, false
+		}
+		return &RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: failure}, false
+	}
+	if s == nil {
+		if req.Seq != 0 {
+			return refuse(&RPCError{Code: rpcInvalidRequest, Message: "Invalid request: seq needs a sequenced session, opened with ?session="})
+		}
+		return nil, true
+	}
+	if req.Method == rpcSessionStatus {
+		return nil, true
+	}
+	if req.Seq <= 0 {
+		return refuse(&RPCError{Code: rpcInvalidRequest, Message: "Invalid request: calls on a sequenced session need a positive seq"})
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	details := SequenceDetails{Expected: s.next, Received: req.Seq}
+	switch {
+	case req.Seq == s.next:
+		return nil, true
+	case req.Seq > s.next:
+		return refuse(rpcFailure(rpcRefused, ErrCodeSequenceGap, "Sequence gap", details))
+	}
+	// A notification's duplicate has no first response to be answered with
+	reply := s.replies[req.Seq]
+	if reply == nil {
+		return refuse(rpcFailure(rpcRefused, ErrCodeDuplicateSequence, "Duplicate sequence number", details))
+	}
+	if len(req.ID) == 0 {
+		return nil, false
+	}
+	resent := *reply
+	resent.ID, resent.Resent = req.ID, true
+	return &resent, false
+}
+
+// record keeps the response to a call that ran and moves the session on to
+// the next sequence number
+func (s *sequencedSession) record(req RPCRequest, response *RPCResponse) {
+	if s == nil || req.Method == rpcSessionStatus {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.replies[req.Seq] = response
+	delete(s.replies, req.Seq-sequenceReplayLimit)
+	s.next = req.Seq + 1
+}
+
+// rpcGetSessionStatus reports the sequence number the connection's session
+// expects next
+func rpcGetSessionStatus(ctx context.Context, params json.RawMessage) (interface{}, *RPCError) {
+	s := sequencedSessionFrom(ctx)
+	if s == nil {
+		return nil, &RPCError{Code: rpcInvalidRequest, Message: "Invalid request: the connection has no sequenced session, opened with ?session="}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return SessionStatusResponse{Session: s.name, NextSeq: s.next}, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// sequenceDetails decodes the sequence numbers a refused call names
+func sequenceDetails(response RPCResponse) SequenceDetails {
+	var details SequenceDetails
+	raw, _ := json.Marshal(response.Error.Data.Details)
+	json.Unmarshal(raw, &details)
+	return details
+}
+
+func TestSequencedSession_GapsAndDuplicates(t *testing.T) {
+	setupTest()
+	server := httptest.NewServer(newServer(HTTPConfig{}))
+	defer server.Close()
+	conn := dialFeed(t, server, "/api/v1/rpc?session=algo", "")
+	defer conn.Close()
+
+	var placed PlaceOrderResponse
+	response := callOver(t, conn, `{"jsonrpc": "2.0", "id": 1, "seq": 1, "method": "order.place", "params": {"side": "buy", "price": 99.0, "quantity": 1}}`)
+	rpcResult(t, response, &placed)
+	if response.Seq != 1 || response.Resent {
+		t.Errorf("Expected seq 1 answered, got %+v", response)
+	}
+
+	// Seq 2 went missing, so 3 is not run
+	response = callOver(t, conn, `{"jsonrpc": "2.0", "id": 3, "seq": 3, "method": "order.place", "params": {"side": "buy", "price": 98.0, "quantity": 1}}`)
+	if response.Error == nil || response.Error.Data.Code != ErrCodeSequenceGap || sequenceDetails(response) != (SequenceDetails{Expected: 2, Received: 3}) {
+		t.Fatalf("Expected a gap from 2, got %+v", response.Error)
+	}
+
+	// A resent seq 1 gets its first answer without placing again
+	var resent PlaceOrderResponse
+	response = callOver(t, conn, `{"jsonrpc": "2.0", "id": "again", "seq": 1, "method": "order.place", "params": {"side": "buy", "price": 99.0, "quantity": 1}}`)
+	rpcResult(t, response, &resent)
+	if !response.Resent || string(response.ID) != `"again"` || resent.OrderID != placed.OrderID {
+		t.Errorf("Expected the first answer resent, got %+v", response)
+	}
+	if bids := bookFor("").bids.orders(); len(bids) != 1 {
+		t.Fatalf("Expected one bid placed, got %+v", bids)
+	}
+
+	callOver(t, conn, `{"jsonrpc": "2.0", "id": 2, "seq": 2, "method": "order.place", "params": {"side": "buy", "price": 97.0, "quantity": 1}}`)
+	callOver(t, conn, `{"jsonrpc": "2.0", "id": 3, "seq": 3, "method": "order.place", "params": {"side": "buy", "price": 98.0, "quantity": 1}}`)
+	if bids := bookFor("").bids.orders(); len(bids) != 3 {
+		t.Errorf("Expected the resent calls placed in order, got %+v", bids)
+	}
+}
+
+func TestSequencedSession_OutlivesItsConnection(t *testing.T) {
+	setupTest()
+	cfg := HTTPConfig{APIKeys: []string{"desk", "algo"}}
+	server := httptest.NewServer(newServer(cfg))
+	defer server.Close()
+	conn := dialFeed(t, server, "/api/v1/rpc?session=main", "desk")
+	callOver(t, conn, `{"jsonrpc": "2.0", "id": 1, "seq": 1, "method": "order.cancel", "params": {"order_id": "missing"}}`)
+
+	// Only one connection may have the session at a time
+	header := http.Header{"X-API-Key": {"desk"}}
+	if _, handshake, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/api/v1/rpc?session=main", header); err == nil || handshake.StatusCode != http.StatusConflict {
+		t.Fatalf("Expected a second connection refused, got %v", err)
+	}
+	conn.Close()
+	waitForFeeds(t, 0)
+
+	conn = dialFeed(t, server, "/api/v1/rpc?session=main", "desk")
+	defer conn.Close()
+	var status SessionStatusResponse
+	rpcResult(t, callOver(t, conn, `{"jsonrpc": "2.0", "id": 1, "method": "session.status"}`), &status)
+	if status.Session != "main" || status.NextSeq != 2 {
+		t.Errorf("Expected the session to carry on at seq 2, got %+v", status)
+	}
+
+	// Another key's session of the same name is its own
+	other := dialFeed(t, server, "/api/v1/rpc?session=main", "algo")
+	defer other.Close()
+	rpcResult(t, callOver(t, other, `{"jsonrpc": "2.0", "id": 1, "method": "session.status"}`), &status)
+	if status.NextSeq != 1 {
+		t.Errorf("Expected another key's session to start at seq 1, got %+v", status)
+	}
+}
+
+func TestSequencedSession_Refusals(t *testing.T) {
+	setupTest()
+	if got := callRPC(context.Background(), json.RawMessage(`{"jsonrpc": "2.0", "id": 1, "seq": 1, "method": "order.cancel", "params": {"order_id": "x"}}`)); got.Error == nil || got.Error.Code != rpcInvalidRequest {
+		t.Errorf("Expected seq refused without a sequenced session, got %+v", got)
+	}
+
+	session := connectSequenced("", "replay")
+	ctx := withSequencedSession(context.Background(), session)
+	if got := callRPC(ctx, json.RawMessage(`{"jsonrpc": "2.0", "id": 1, "method": "order.cancel", "params": {"order_id": "x"}}`)); got.Error == nil || got.Error.Code != rpcInvalidRequest {
+		t.Errorf("Expected a call without seq refused on a sequenced session, got %+v", got)
+	}
+
+	// Answers are only kept for the latest calls
+	for seq := 1; seq <= sequenceReplayLimit+1; seq++ {
+		callRPC(ctx, json.RawMessage(`{"jsonrpc": "2.0", "id": 1, "seq": `+strconv.Itoa(seq)+`, "method": "no.such"}`))
+	}
+	got := callRPC(ctx, json.RawMessage(`{"jsonrpc": "2.0", "id": 1, "seq": 1, "method": "no.such"}`))
+	if got.Error == nil || got.Error.Data == nil || got.Error.Data.Code != ErrCodeDuplicateSequence {
+		t.Errorf("Expected a duplicate too old to answer refused, got %+v", got.Error)
+	}
+	if got := callRPC(ctx, json.RawMessage(`{"jsonrpc": "2.0", "id": 2, "seq": 2, "method": "no.such"}`)); got.Error == nil || got.Error.Code != rpcMethodNotFound || !got.Resent {
+		t.Errorf("Expected a kept answer resent, got %+v", got)
+	}
+}
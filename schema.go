@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// currentSchemaVersion is the version of the JSON bodies the API serves
+// unless a client asks for an older one. A field added to a body is tagged
+// since:"N" with the version it arrived in, and the version bumped, so a
+// client pinned to an older version keeps getting the bodies it was written
+// against.
+//
+//   - 1: the bodies before they carried schema_version
+//   - 2: every JSON body and stream message is an object starting with
+//     schema_version
+//   - 3: trades and fills carry their base quantity and quote notional, and
+//     symbols list their pairs
+//   - 4: orders carry the quote quantity of notional buys
+//   - 5: positions list the account's use of its position limits
+//   - 6: accounts carry their parent, whether they share its balances and
+//     their own position limits
+//   - 7: orders and fills carry the basket they were placed in
+//   - 8: leg orders carry the spread order they were implied from
+//   - 9: the public feed and auction status give a call auction's
+//     indicative uncross
+const (
+	currentSchemaVersion = 9
+	minSchemaVersion     = 1
+	// stampedSchemaVersion is the first version bodies are stamped in
+	stampedSchemaVersion = 2
+)
+
+// schemaVersionHeader names the version a client wants, and the one it got
+const schemaVersionHeader = "Schema-Version"
+
+// schemaVersionKey is the request context key for the schema version the
+// client asked for
+type schemaVersionKey struct{}
+
+// schemaVersionFrom returns the schema version a request's bodies are written
+// in, the current one unless the client asked for another
+func schemaVersionFrom(ctx context.Context) int {
+	if version, ok := ctx.Value(schemaVersionKey{}).(int); ok {
+		return version
+	}
+	return currentSchemaVersion
+}
+
+// requestedSchemaVersion reads the version from the Schema-Version header or,
+// for browsers opening a WebSocket, the schema_version query parameter
+func requestedSchemaVersion(r *http.Request) (int, error) {
+	raw := r.Header.Get(schemaVersionHeader)
+	if raw == "" {
+		raw = r.URL.Query().Get("schema_version")
+	}
+	if raw == "" {
+		return currentSchemaVersion, nil
+	}
+	version, err := strconv.Atoi(strings.TrimSpace(raw))
+	if err != nil || version < minSchemaVersion || version > currentSchemaVersion {
+		return 0, fmt.Errorf("schema version must be an integer from %d to %d (received: '%s')", minSchemaVersion, currentSchemaVersion, raw)
+	}
+	return version, nil
+}
+
+// versionPayloads writes a route's JSON bodies in the schema version the
+// client asked for. Streams only note the version, for their messages to be
+// written in; other routes have their bodies stamped and, for older
+// versions, stripped of the fields added since.
+func versionPayloads(route apiRoute) middleware {
+	var success reflect.Type
+	if _, ok := route.response.(oneOf); !ok && route.response != nil {
+		success = reflect.TypeOf(route.response)
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			version, err := requestedSchemaVersion(r)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, ErrCodeValidationFailed, "Validation failed", []string{err.Error()})
+				return
+			}
+			w.Header().Set(schemaVersionHeader, strconv.Itoa(version))
+			r = r.WithContext(context.WithValue(r.Context(), schemaVersionKey{}, version))
+			if route.websocket {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			sw := &schemaWriter{ResponseWriter: w, version: version, success: success}
+			next.ServeHTTP(sw, r)
+			sw.finish()
+		})
+	}
+}
+
+// errorResponseType is the body of every error
+var errorResponseType = reflect.TypeOf(ErrorResponse{})
+
+// schemaWriter versions a JSON body as the handler writes it. A body with
+// nothing to strip for the version has its stamp added to the first write and
+// goes straight out; one with fields to strip is held until the handler
+// returns. Bodies other than JSON pass through untouched.
+type schemaWriter struct {
+	http.ResponseWriter
+	version int
+	success reflect.Type
+	status  int
+	started bool
+	holding bool
+	held    []byte
+}
+
+// WriteHeader adds the Vary the version calls for after the handler's own
+func (w *schemaWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+		w.Header().Add("Vary", schemaVersionHeader)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *schemaWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.started {
+		if w.holding {
+			w.held = append(w.held, b...)
+			return len(b), nil
+		}
+		return w.ResponseWriter.Write(b)
+	}
+	w.started = true
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		return w.ResponseWriter.Write(b)
+	}
+
+	if hasNewerFields(w.bodyType(), w.version) {
+		w.holding = true
+		w.held = append(w.held, b...)
+		return len(b), nil
+	}
+	if _, err := w.ResponseWriter.Write(stampSchemaVersion(nil, b, w.version)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Flush sends what has been written so far, unless the body is being held
+func (w *schemaWriter) Flush() {
+	if !w.holding {
+		http.NewResponseController(w.ResponseWriter).Flush()
+	}
+}
+
+func (w *schemaWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// bodyType is the Go type of the body being written
+func (w *schemaWriter) bodyType() reflect.Type {
+	if w.status >= 400 {
+		return errorResponseType
+	}
+	return w.success
+}
+
+// finish writes out a held body in the client's version
+func (w *schemaWriter) finish() {
+	if w.holding {
+		w.ResponseWriter.Write(versionJSON(w.held, w.bodyType(), w.version))
+	}
+}
+
+// versionJSON rewrites one JSON value of type t, encoded in the current
+// schema, in version
+func versionJSON(b []byte, t reflect.Type, version int) []byte {
+	if hasNewerFields(t, version) {
+		var tree interface{}
+		decoder := json.NewDecoder(bytes.NewReader(b))
+		decoder.UseNumber()
+		if decoder.Decode(&tree) == nil {
+			stripNewerFields(tree, t, version)
+			if data, err := json.Marshal(tree); err == nil {
+				if bytes.HasSuffix(b, []byte("\n")) {
+					data = append(data, '\n')
+				}
+				b = data
+			}
+		}
+	}
+	return stampSchemaVersion(nil, b, version)
+}
+
+// stampSchemaVersion appends b to dst with schema_version as the first field
+// of the object it holds, for the versions that carry it. Anything but an
+// object is appended as it is.
+func stampSchemaVersion(dst, b []byte, version int) []byte {
+	if version < stampedSchemaVersion || len(b) == 0 || b[0] != '{' {
+		return append(dst, b...)
+	}
+	dst = append(dst, `{"schema_version":`...)
+	dst = strconv.AppendInt(dst, int64(version), 10)
+	if rest := bytes.TrimLeft(b[1:], " \t\r\n"); len(rest) == 0 || rest[0] != '}' {
+		dst = append(dst, ',')
+	}
+	return append(dst, b[1:]...)
+}
+
+// fieldSince returns the schema version a struct field was added in, 0 for
+// a field as old as the schema
+func fieldSince(field reflect.StructField) int {
+	since, _ := strconv.Atoi(field.Tag.Get("since"))
+	return since
+}
+
+// jsonFieldName returns the key a struct field is encoded under, or "" for
+// one that is never encoded
+func jsonFieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	switch {
+	case name == "-" || !field.IsExported():
+		return ""
+	case name == "":
+		return field.Name
+	}
+	return name
+}
+
+// newerFields caches whether a type holds, at any depth, fields added after
+// a version
+var newerFields sync.Map
+
+type newerFieldsKey struct {
+	t       reflect.Type
+	version int
+}
+
+// hasNewerFields reports whether values of t can hold fields added after
+// version, so a body of t written in version needs rewriting
+func hasNewerFields(t reflect.Type, version int) bool {
+	if t == nil || version >= currentSchemaVersion {
+		return false
+	}
+	key := newerFieldsKey{t, version}
+	if cached, ok := newerFields.Load(key); ok {
+		return cached.(bool)
+	}
+	found := findNewerFields(t, version, make(map[reflect.Type]bool))
+	newerFields.Store(key, found)
+	return found
+}
+
+func findNewerFields(t reflect.Type, version int, seen map[reflect.Type]bool) bool {
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || seen[t] {
+		return false
+	}
+	seen[t] = true
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if fieldSince(field) > version || findNewerFields(field.Type, version, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// stripNewerFields deletes from a decoded JSON value of type t every field
+// added after version. Embedded structs share their parent's object.
+func stripNewerFields(value interface{}, t reflect.Type, version int) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return
+		}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.Anonymous && field.Tag.Get("json") == "" {
+				stripNewerFields(object, field.Type, version)
+				continue
+			}
+			name := jsonFieldName(field)
+			if name == "" {
+				continue
+			}
+			if fieldSince(field) > version {
+				delete(object, name)
+			} else if child, ok := object[name]; ok {
+				stripNewerFields(child, field.Type, version)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		items, _ := value.([]interface{})
+		for _, item := range items {
+			stripNewerFields(item, t.Elem(), version)
+		}
+	case reflect.Map:
+		entries, _ := value.(map[string]interface{})
+		for _, entry := range entries {
+			stripNewerFields(entry, t.Elem(), version)
+		}
+	}
+}
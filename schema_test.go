@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// versionedGet fetches path asking for a schema version; "" asks for none
+func versionedGet(path, version string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	if version != "" {
+		req.Header.Set(schemaVersionHeader, version)
+	}
+	return serve(HTTPConfig{}, req)
+}
+
+// currentStamp is how a body in the current schema version starts
+var currentStamp = `{"schema_version":` + strconv.Itoa(currentSchemaVersion) + `,`
+
+// unknownSchemaVersion is the first version no client can ask for yet
+var unknownSchemaVersion = strconv.Itoa(currentSchemaVersion + 1)
+
+func TestSchemaVersion_StampsEveryBody(t *testing.T) {
+	setupTest()
+	response := versionedGet("/api/v1/orders", "")
+	if body := response.Body.String(); !strings.HasPrefix(body, currentStamp+`"orders":`) {
+		t.Errorf("Expected the orders stamped with the current version, got %s", body)
+	}
+	if got := response.Header().Get(schemaVersionHeader); got != strconv.Itoa(currentSchemaVersion) {
+		t.Errorf("Expected the version in the header, got %q", got)
+	}
+	if vary := response.Header().Values("Vary"); !strings.Contains(strings.Join(vary, ","), schemaVersionHeader) {
+		t.Errorf("Expected the response to vary on the version, got %v", vary)
+	}
+	// Errors are bodies like any other
+	if body := versionedGet("/api/v1/orders/missing", "").Body.String(); !strings.HasPrefix(body, currentStamp+`"error":`) {
+		t.Errorf("Expected the error stamped, got %s", body)
+	}
+
+	// A client written before bodies were versioned gets them as they were
+	if body := versionedGet("/api/v1/orders", "1").Body.String(); strings.Contains(body, "schema_version") {
+		t.Errorf("Expected version 1 without schema_version, got %s", body)
+	}
+	response = versionedGet("/api/v1/orders", unknownSchemaVersion)
+	if result := decodeError(t, response); response.Code != http.StatusBadRequest || result.Error.Code != ErrCodeValidationFailed {
+		t.Errorf("Expected an unknown version refused, got %d %s", response.Code, result.Error.Code)
+	}
+	// GraphQL answers in its own envelope
+	if body := versionedGet("/api/v1/graphql/schema", "").Body.String(); strings.Contains(body, "schema_version") {
+		t.Errorf("Expected the GraphQL schema untouched, got %.40s", body)
+	}
+}
+
+// schemaTestLeg and schemaTestBody grew fields in version 2
+type schemaTestLeg struct {
+	Price float64 `json:"price"`
+	Venue string  `json:"venue" since:"2"`
+}
+
+type schemaTestBody struct {
+	ID   string          `json:"id"`
+	Legs []schemaTestLeg `json:"legs"`
+	Note string          `json:"note,omitempty" since:"2"`
+	schemaTestTimes
+}
+
+type schemaTestTimes struct {
+	Quantity  json.Number `json:"quantity"`
+	ExpiresAt time.Time   `json:"expires_at" since:"2"`
+}
+
+func TestSchemaVersion_OlderVersionsLoseNewerFields(t *testing.T) {
+	body := schemaTestBody{ID: "a", Legs: []schemaTestLeg{{Price: 1.5, Venue: "EXT"}}, Note: "new",
+		schemaTestTimes: schemaTestTimes{Quantity: "12345678901234567890", ExpiresAt: time.Unix(0, 0).UTC()}}
+	data, _ := json.Marshal(body)
+	bodyType := reflect.TypeOf(body)
+
+	if got := string(versionJSON(data, bodyType, 2)); got != `{"schema_version":2,`+string(data[1:]) {
+		t.Errorf("Expected version 2 only stamped, got %s", got)
+	}
+	want := `{"id":"a","legs":[{"price":1.5}],"quantity":12345678901234567890}`
+	if got := string(versionJSON(data, bodyType, 1)); got != want {
+		t.Errorf("Expected version 1 without the newer fields\n got: %s\nwant: %s", got, want)
+	}
+	if hasNewerFields(errorResponseType, 1) {
+		t.Error("Expected no error field to be newer than version 1")
+	}
+	if !hasNewerFields(reflect.TypeOf(Order{}), 1) {
+		t.Error("Expected orders to have fields newer than version 1")
+	}
+}
+
+func TestSchemaVersion_StreamsAndWebhooks(t *testing.T) {
+	setupTest()
+	server := httptest.NewServer(newServer(HTTPConfig{}))
+	defer server.Close()
+	current := dialFeed(t, server, "/api/v1/feed/public", "")
+	defer current.Close()
+	pinned := dialFeed(t, server, "/api/v1/feed/public?schema_version=1", "")
+	defer pinned.Close()
+	waitForFeeds(t, 2)
+
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "bid", Side: SideBuy, Price: 99, Quantity: 1})
+	if _, raw := readFeed(t, current, FeedMessageDepth); !strings.HasPrefix(raw, currentStamp+`"channel":"public"`) {
+		t.Errorf("Expected the depth message stamped, got %s", raw)
+	}
+	if _, raw := readFeed(t, pinned, FeedMessageDepth); strings.Contains(raw, "schema_version") {
+		t.Errorf("Expected the pinned client's message unstamped, got %s", raw)
+	}
+
+	if _, err := loadConfig([]string{"-webhook-schema-version", unknownSchemaVersion}); err == nil {
+		t.Error("Expected an unknown webhook schema version refused")
+	}
+}
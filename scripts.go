@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// defaultScriptTimeout is how long one script may take over one order
+const defaultScriptTimeout = 10 * time.Millisecond
+
+// ScriptConfig is the Lua scripts every order is checked by before it trades
+type ScriptConfig struct {
+	Paths []string
+	// Timeout is how long a script may take over one order before the order
+	// is refused
+	Timeout time.Duration
+}
+
+// ScriptStatus is a loaded order script and how it has ruled
+type ScriptStatus struct {
+	Name     string    `json:"name"`
+	Path     string    `json:"path"`
+	LoadedAt time.Time `json:"loaded_at"`
+	Checked  int64     `json:"checked"`
+	Rejected int64     `json:"rejected"`
+	// Errors counts the orders refused because the script failed or ran out
+	// of time, and LastError is the latest failure
+	Errors    int64  `json:"errors"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// ScriptsResponse lists the order scripts in the order they run
+type ScriptsResponse struct {
+	Scripts []ScriptStatus `json:"scripts"`
+}
+
+// orderScript is one loaded script. Its Lua state is only used under mu, so
+// globals the script keeps carry over from one order to the next.
+type orderScript struct {
+	mu     sync.Mutex
+	state  *lua.LState
+	check  *lua.LFunction
+	status ScriptStatus
+
+	checked, rejected, failed atomic.Int64
+	lastError                 atomic.Value
+}
+
+// scripts holds the loaded order scripts; active is set while there are any,
+// so orders skip the lock when no script is loaded
+var scripts struct {
+	mu     sync.RWMutex
+	cfg    ScriptConfig
+	list   []*orderScript
+	active atomic.Bool
+}
+
+// loadOrderScript compiles a script in a state with only the base, table,
+// string and math libraries, and finds its check function
+func loadOrderScript(path string) (*orderScript, error) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	state := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{{lua.BaseLibName, lua.OpenBase}, {lua.TabLibName, lua.OpenTable}, {lua.StringLibName, lua.OpenString}, {lua.MathLibName, lua.OpenMath}} {
+		state.Push(state.NewFunction(lib.open))
+		state.Push(lua.LString(lib.name))
+		state.Call(1, 0)
+	}
+	for _, unsafe := range []string{"dofile", "loadfile", "load", "loadstring", "require"} {
+		state.SetGlobal(unsafe, lua.LNil)
+	}
+
+	if err := state.DoString(string(source)); err != nil {
+		state.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	check, ok := state.GetGlobal("check").(*lua.LFunction)
+	if !ok {
+		state.Close()
+		return nil, fmt.Errorf("%s: defines no check(order, market) function", path)
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return &orderScript{
+		state:  state,
+		check:  check,
+		status: ScriptStatus{Name: name, Path: path, LoadedAt: time.Now()},
+	}, nil
+}
+
+// configureOrderScripts loads the configured scripts, replacing any loaded
+// before only if every one of them loads
+func configureOrderScripts(cfg ScriptConfig) error {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultScriptTimeout
+	}
+	var list []*orderScript
+	for _, path := range cfg.Paths {
+		script, err := loadOrderScript(path)
+		if err != nil {
+			for _, loaded := range list {
+				loaded.state.Close()
+			}
+			return err
+		}
+		list = append(list, script)
+	}
+
+	scripts.mu.Lock()
+	previous := scripts.list
+	scripts.cfg, scripts.list = cfg, list
+	scripts.active.Store(len(list) > 0)
+	scripts.mu.Unlock()
+	for _, script := range previous {
+		script.mu.Lock()
+		script.state.Close()
+		script.mu.Unlock()
+	}
+	if len(list) > 0 {
+		log.Printf("scripts: checking orders with %d scripts", len(list))
+	}
+	return nil
+}
+
+// scriptRefusal runs an order past every script in turn, returning why the
+// first to refuse it did. A script that fails or runs out of time refuses the
+// order. It must run on the book's matcher.
+func scriptRefusal(book *OrderBook, order Order) (string, bool) {
+	if !scripts.active.Load() {
+		return "", false
+	}
+	scripts.mu.RLock()
+	defer scripts.mu.RUnlock()
+	for _, script := range scripts.list {
+		reason, refused, err := script.run(book, order, scripts.cfg.Timeout)
+		script.checked.Add(1)
+		switch {
+		case err != nil:
+			script.failed.Add(1)
+			script.lastError.Store(err.Error())
+			return fmt.Sprintf("refused by script %s, which failed: %v", script.status.Name, err), true
+		case refused:
+			script.rejected.Add(1)
+			return fmt.Sprintf("refused by script %s: %s", script.status.Name, reason), true
+		}
+	}
+	return "", false
+}
+
+// run calls the script's check(order, market). Returning nothing or true
+// lets the order through; false, or a string, refuses it, a string or a
+// second return value giving the reason.
+func (s *orderScript) run(book *OrderBook, order Order, timeout time.Duration) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	L := s.state
+	L.SetContext(ctx)
+	defer L.RemoveContext()
+
+	err := L.CallByParam(lua.P{Fn: s.check, NRet: 2, Protect: true}, orderTable(L, order), marketTable(L, book))
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", false, fmt.Errorf("took longer than %s", timeout)
+		}
+		return "", false, err
+	}
+	verdict, reason := L.Get(-2), L.Get(-1)
+	L.Pop(2)
+	switch verdict := verdict.(type) {
+	case lua.LString:
+		return string(verdict), true, nil
+	case lua.LBool:
+		if !verdict {
+			if reason == lua.LNil {
+				return "order refused", true, nil
+			}
+			return reason.String(), true, nil
+		}
+	}
+	if verdict == lua.LNil || verdict == lua.LTrue {
+		return "", false, nil
+	}
+	return "", false, fmt.Errorf("check returned a %s; want nothing, a boolean or a string", verdict.Type())
+}
+
+// orderTable is the order as scripts see it
+func orderTable(L *lua.LState, order Order) *lua.LTable {
+	table := L.NewTable()
+	orderType := order.Type
+	if orderType == "" {
+		orderType = OrderTypeLimit
+	}
+	timeInForce := order.TimeInForce
+	if timeInForce == "" {
+		timeInForce = TimeInForceGTC
+	}
+	for key, value := range map[string]lua.LValue{
+		"id":            lua.LString(order.ID),
+		"symbol":        lua.LString(order.Symbol),
+		"side":          lua.LString(order.Side),
+		"type":          lua.LString(orderType),
+		"time_in_force": lua.LString(timeInForce),
+		"price":         lua.LNumber(order.Price),
+		"quantity":      lua.LNumber(order.Quantity),
+		"min_quantity":  lua.LNumber(order.MinQuantity),
+		"all_or_none":   lua.LBool(order.AllOrNone),
+		"owner":         lua.LString(order.Owner),
+	} {
+		L.SetField(table, key, value)
+	}
+	return table
+}
+
+// marketTable is the order's book as scripts see it: the best prices, zero
+// on an empty side, and the last trade price
+func marketTable(L *lua.LState, book *OrderBook) *lua.LTable {
+	table := L.NewTable()
+	bid, _ := book.bids.first()
+	ask, _ := book.asks.first()
+	L.SetField(table, "best_bid", lua.LNumber(bid.Price))
+	L.SetField(table, "best_ask", lua.LNumber(ask.Price))
+	L.SetField(table, "last_price", lua.LNumber(book.lastPrice))
+	return table
+}
+
+// snapshot returns the script's status
+func (s *orderScript) snapshot() ScriptStatus {
+	status := s.status
+	status.Checked, status.Rejected, status.Errors = s.checked.Load(), s.rejected.Load(), s.failed.Load()
+	status.LastError, _ = s.lastError.Load().(string)
+	return status
+}
+
+// scriptStatuses returns the loaded scripts in the order they run
+func scriptStatuses() []ScriptStatus {
+	scripts.mu.RLock()
+	defer scripts.mu.RUnlock()
+	list := make([]ScriptStatus, 0, len(scripts.list))
+	for _, script := range scripts.list {
+		list = append(list, script.snapshot())
+	}
+	return list
+}
+
+// getScriptsHandler lists the order scripts and how they have ruled
+func getScriptsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ScriptsResponse{Scripts: scriptStatuses()})
+}
+
+// reloadScriptsHandler reads the order scripts from their files again. If
+// one no longer loads, the scripts already running stay.
+func reloadScriptsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	scripts.mu.RLock()
+	cfg := scripts.cfg
+	scripts.mu.RUnlock()
+	if err := configureOrderScripts(cfg); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, ErrCodeScriptInvalid, "Script does not load", err.Error())
+		return
+	}
+	json.NewEncoder(w).Encode(ScriptsResponse{Scripts: scriptStatuses()})
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// complianceScript caps notional, keeps buys near the offer and counts each
+// owner's orders across calls
+const complianceScript = `
+local placed = {}
+
+function check(order, market)
+  if order.price * order.quantity > 10000 then
+    return false, "notional over 10000"
+  end
+  if order.side == "buy" and market.best_ask > 0 and order.price > market.best_ask * 1.1 then
+    return "buy more than 10% through the offer"
+  end
+  placed[order.owner] = (placed[order.owner] or 0) + 1
+  if placed[order.owner] > 2 then
+    return "more than 2 orders from " .. order.owner
+  end
+  return true
+end
+`
+
+func TestOrderScripts_RefuseOrders(t *testing.T) {
+	setupTest()
+	resetSymbols([]string{"BTC-USD"})
+	if err := configureOrderScripts(ScriptConfig{Paths: []string{writeSeedFile(t, "compliance.lua", complianceScript)}}); err != nil {
+		t.Fatal(err)
+	}
+	m, _ := matcherFor("BTC-USD")
+
+	if placed := processOn(m, Order{ID: "ask", Symbol: "BTC-USD", Side: SideSell, Price: 100, Quantity: 10, Owner: "alice"}); placed.Status != OrderStatusPending {
+		t.Fatalf("Expected the ask through, got %+v", placed)
+	}
+	for _, order := range []Order{
+		{ID: "large", Symbol: "BTC-USD", Side: SideBuy, Price: 100, Quantity: 101, Owner: "bob"},
+		{ID: "through", Symbol: "BTC-USD", Side: SideBuy, Price: 111, Quantity: 1, Owner: "bob"},
+	} {
+		if placed := processOn(m, order); placed.Status != OrderStatusRejected || placed.RejectReason != ErrCodeScriptRejected {
+			t.Errorf("Expected %s refused, got %+v", order.ID, placed)
+		}
+	}
+	processOn(m, Order{ID: "second", Symbol: "BTC-USD", Side: SideSell, Price: 101, Quantity: 1, Owner: "alice"})
+
+	// The script's globals carry over: alice's third order is one too many
+	body := `{"symbol": "BTC-USD", "side": "sell", "price": 102, "quantity": 1, "owner": "alice"}`
+	response := serve(HTTPConfig{}, httptest.NewRequest("POST", "/api/v1/orders", strings.NewReader(body)))
+	var rejection ErrorResponse
+	json.NewDecoder(response.Body).Decode(&rejection)
+	if response.Code != http.StatusUnprocessableEntity || rejection.Error.Code != ErrCodeScriptRejected {
+		t.Fatalf("Expected the third order refused, got %d: %+v", response.Code, rejection)
+	}
+	response = serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/orders/"+rejection.OrderID+"/events", nil))
+	if !strings.Contains(response.Body.String(), "refused by script compliance: more than 2 orders from alice") {
+		t.Errorf("Expected the script's reason in the order's events, got %s", response.Body.String())
+	}
+
+	statuses := scriptStatuses()
+	if len(statuses) != 1 || statuses[0].Checked != 5 || statuses[0].Rejected != 3 || statuses[0].Errors != 0 {
+		t.Errorf("Unexpected script status %+v", statuses)
+	}
+}
+
+func TestOrderScripts_RefuseWhenAScriptFails(t *testing.T) {
+	setupTest()
+	resetSymbols([]string{"BTC-USD"})
+	err := configureOrderScripts(ScriptConfig{
+		Paths: []string{
+			writeSeedFile(t, "pass.lua", "function check(order) end"),
+			writeSeedFile(t, "spin.lua", "function check(order) if order.owner == 'loop' then while true do end end return 42 end"),
+		},
+		Timeout: 20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, _ := matcherFor("BTC-USD")
+
+	if placed := processOn(m, Order{ID: "loop", Symbol: "BTC-USD", Side: SideBuy, Price: 100, Quantity: 1, Owner: "loop"}); placed.Status != OrderStatusRejected {
+		t.Errorf("Expected an order refused when its script times out, got %+v", placed)
+	}
+	if placed := processOn(m, Order{ID: "answer", Symbol: "BTC-USD", Side: SideBuy, Price: 100, Quantity: 1}); placed.Status != OrderStatusRejected {
+		t.Errorf("Expected an order refused when its script returns a number, got %+v", placed)
+	}
+	if statuses := scriptStatuses(); statuses[0].Errors != 0 || statuses[1].Errors != 2 || !strings.Contains(statuses[1].LastError, "number") {
+		t.Errorf("Expected both failures on the second script, got %+v", statuses)
+	}
+}
+
+func TestOrderScripts_Reload(t *testing.T) {
+	setupTest()
+	resetSymbols([]string{"BTC-USD"})
+	path := writeSeedFile(t, "gate.lua", "function check(order) return 'closed' end")
+	if err := configureOrderScripts(ScriptConfig{Paths: []string{path}}); err != nil {
+		t.Fatal(err)
+	}
+	m, _ := matcherFor("BTC-USD")
+	if placed := processOn(m, Order{ID: "first", Symbol: "BTC-USD", Side: SideBuy, Price: 100, Quantity: 1}); placed.Status != OrderStatusRejected {
+		t.Fatalf("Expected the gate closed, got %+v", placed)
+	}
+
+	os.WriteFile(path, []byte("function check(order) return true end"), 0o644)
+	if response := serve(HTTPConfig{}, httptest.NewRequest("POST", "/api/v1/admin/scripts/reload", nil)); response.Code != http.StatusOK {
+		t.Fatalf("Expected the script reloaded, got %d: %s", response.Code, response.Body.String())
+	}
+	if placed := processOn(m, Order{ID: "second", Symbol: "BTC-USD", Side: SideBuy, Price: 100, Quantity: 1}); placed.Status != OrderStatusPending {
+		t.Errorf("Expected the gate open after the reload, got %+v", placed)
+	}
+
+	os.WriteFile(path, []byte("function check(order"), 0o644)
+	response := serve(HTTPConfig{}, httptest.NewRequest("POST", "/api/v1/admin/scripts/reload", nil))
+	if response.Code != http.StatusUnprocessableEntity || !strings.Contains(response.Body.String(), string(ErrCodeScriptInvalid)) {
+		t.Errorf("Expected a broken script refused, got %d: %s", response.Code, response.Body.String())
+	}
+	if placed := processOn(m, Order{ID: "third", Symbol: "BTC-USD", Side: SideBuy, Price: 100, Quantity: 1}); placed.Status != OrderStatusPending {
+		t.Errorf("Expected the loaded script kept, got %+v", placed)
+	}
+}
+
+func TestLoadConfig_OrderScripts(t *testing.T) {
+	path := writeSeedFile(t, "ok.lua", "function check(order) end")
+	cfg, err := loadConfig([]string{"-order-scripts", path, "-order-script-timeout", "5ms"})
+	if err != nil || len(cfg.Scripts.Paths) != 1 || cfg.Scripts.Timeout != 5*time.Millisecond {
+		t.Fatalf("Unexpected script config %+v (%v)", cfg.Scripts, err)
+	}
+	for name, source := range map[string]string{
+		"syntax.lua":   "function check(order",
+		"nocheck.lua":  "local x = 1",
+		"sandbox.lua":  "local f = io.open('/etc/passwd')\nfunction check(order) end",
+		"require.lua":  "require('os')\nfunction check(order) end",
+		"notfunc.lua":  "check = 1",
+		"runtime.lua":  "error('not today')",
+		"os.lua":       "os.exit(1)",
+		"dofile.lua":   "dofile('/etc/passwd')",
+		"loadfile.lua": "loadfile('/etc/passwd')",
+	} {
+		if _, err := loadConfig([]string{"-order-scripts", writeSeedFile(t, name, source)}); err == nil {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+	if _, err := loadConfig([]string{"-order-scripts", path, "-order-script-timeout", "0s"}); err == nil {
+		t.Error("Expected an error for a zero timeout")
+	}
+}
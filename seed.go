@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// seedOwner owns the orders a book is seeded with at startup
+const seedOwner = "seed"
+
+// loadSeedDepth reads the depth a -seed-depth file seeds the books with. A
+// .csv file has a symbol,side,price,quantity header, with an optional orders
+// column; anything else is JSON, either one depth snapshot or a list of
+// them, as /depth/snapshot returns.
+func loadSeedDepth(path string) ([]DepthSnapshot, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("-seed-depth: %v", err)
+	}
+
+	var books []DepthSnapshot
+	switch {
+	case strings.EqualFold(filepath.Ext(path), ".csv"):
+		books, err = parseSeedCSV(data)
+	case bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")):
+		err = json.Unmarshal(data, &books)
+	default:
+		var book DepthSnapshot
+		err = json.Unmarshal(data, &book)
+		books = []DepthSnapshot{book}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("-seed-depth %s: %v", path, err)
+	}
+	return books, nil
+}
+
+// parseSeedCSV reads depth levels from CSV rows, one level per row
+func parseSeedCSV(data []byte) ([]DepthSnapshot, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.New("missing the symbol,side,price,quantity header")
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"symbol", "side", "price", "quantity"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("header has no %s column", name)
+		}
+	}
+	field := func(row []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	var books []DepthSnapshot
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return books, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		level := PriceLevel{Orders: 1}
+		var priceErr, quantityErr, ordersErr error
+		level.Price, priceErr = strconv.ParseFloat(field(row, "price"), 64)
+		level.Quantity, quantityErr = strconv.Atoi(field(row, "quantity"))
+		if orders := field(row, "orders"); orders != "" {
+			level.Orders, ordersErr = strconv.Atoi(orders)
+		}
+		if err := errors.Join(priceErr, quantityErr, ordersErr); err != nil {
+			return nil, fmt.Errorf("line %d: price, quantity and orders must be numbers", line)
+		}
+
+		symbol := field(row, "symbol")
+		i := slices.IndexFunc(books, func(book DepthSnapshot) bool { return book.Symbol == symbol })
+		if i < 0 {
+			books, i = append(books, DepthSnapshot{Symbol: symbol}), len(books)
+		}
+		switch strings.ToLower(field(row, "side")) {
+		case "buy", "bid":
+			books[i].Bids = append(books[i].Bids, level)
+		case "sell", "ask":
+			books[i].Asks = append(books[i].Asks, level)
+		default:
+			return nil, fmt.Errorf("line %d: side must be buy or sell (received: %q)", line, field(row, "side"))
+		}
+	}
+}
+
+// checkSeedDepth makes sure every seeded book is for a traded symbol and has
+// sensible levels that do not cross. A book with no symbol seeds the default
+// one, which is the first of symbols.
+func checkSeedDepth(symbols []string, books []DepthSnapshot) error {
+	bestBid, bestAsk := make(map[string]float64), make(map[string]float64)
+	for i := range books {
+		book := &books[i]
+		if book.Symbol == "" {
+			book.Symbol = symbols[0]
+		}
+		if !slices.Contains(symbols, book.Symbol) {
+			return fmt.Errorf("-seed-depth symbol %q is not in -symbols", book.Symbol)
+		}
+		for _, level := range append(slices.Clone(book.Bids), book.Asks...) {
+			if level.Price <= 0 || level.Quantity <= 0 || level.Orders < 0 || level.Orders > level.Quantity {
+				return fmt.Errorf("-seed-depth %s level %g x %d must have a positive price and quantity, and no more orders than quantity", book.Symbol, level.Price, level.Quantity)
+			}
+		}
+		for _, level := range book.Bids {
+			bestBid[book.Symbol] = math.Max(bestBid[book.Symbol], level.Price)
+		}
+		for _, level := range book.Asks {
+			if best, ok := bestAsk[book.Symbol]; !ok || level.Price < best {
+				bestAsk[book.Symbol] = level.Price
+			}
+		}
+	}
+	for symbol, bid := range bestBid {
+		if ask, ok := bestAsk[symbol]; ok && bid >= ask {
+			return fmt.Errorf("-seed-depth %s is crossed: best bid %g is not below best ask %g", symbol, bid, ask)
+		}
+	}
+	return nil
+}
+
+// seedBooks rests the seeded depth in the books, owned by seedOwner. A level
+// of several orders is split between them as evenly as its quantity allows.
+func seedBooks(books []DepthSnapshot) {
+	orders := 0
+	for _, seeded := range books {
+		m, ok := matcherFor(seeded.Symbol)
+		if !ok {
+			continue
+		}
+		m.do(func() {
+			for _, side := range []Side{SideBuy, SideSell} {
+				levels := seeded.Bids
+				if side == SideSell {
+					levels = seeded.Asks
+				}
+				for _, level := range levels {
+					count := max(level.Orders, 1)
+					for i := 0; i < count; i++ {
+						quantity := level.Quantity / count
+						if i < level.Quantity%count {
+							quantity++
+						}
+						processOrder(Order{
+							ID:        generateOrderID(),
+							Symbol:    m.symbol,
+							Side:      side,
+							Price:     level.Price,
+							Quantity:  quantity,
+							Status:    OrderStatusPending,
+							CreatedAt: engineClock.Now(),
+							Owner:     seedOwner,
+						})
+						orders++
+					}
+				}
+			}
+		})
+	}
+	if orders > 0 {
+		log.Printf("seed: rested %d orders on %d books", orders, len(books))
+	}
+}
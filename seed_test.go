@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeSeedFile writes a -seed-depth file and returns its path
+func writeSeedFile(t *testing.T, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSeedBooks_FromCSV(t *testing.T) {
+	setupTest()
+	resetSymbols([]string{"BTC-USD", "ETH-USD"})
+	books, err := loadSeedDepth(writeSeedFile(t, "depth.csv", "symbol,side,price,quantity,orders\n"+
+		"BTC-USD,bid,99.50,7,3\nBTC-USD,bid,99.00,5,\nBTC-USD,ask,100.50,8,1\nETH-USD,sell,2000,1,1\n"))
+	if err == nil {
+		err = checkSeedDepth([]string{"BTC-USD", "ETH-USD"}, books)
+	}
+	if err != nil || len(books) != 2 {
+		t.Fatalf("Unexpected seed depth %+v (%v)", books, err)
+	}
+	seedBooks(books)
+
+	m, _ := matcherFor("BTC-USD")
+	snapshot := m.depthSnapshot(0)
+	if len(snapshot.Bids) != 2 || snapshot.Bids[0] != (PriceLevel{Price: 99.5, Quantity: 7, Orders: 3}) || snapshot.Bids[1].Quantity != 5 ||
+		len(snapshot.Asks) != 1 || snapshot.Asks[0].Price != 100.5 {
+		t.Fatalf("Expected the seeded BTC-USD depth, got %+v", snapshot)
+	}
+	if orders := m.book.bids.orders(); orders[0].Owner != seedOwner || orders[0].Quantity != 3 || orders[2].Quantity != 2 {
+		t.Errorf("Expected 7 split 3, 2, 2 between seed orders, got %+v", orders)
+	}
+	eth, _ := matcherFor("ETH-USD")
+	if snapshot := eth.depthSnapshot(0); len(snapshot.Asks) != 1 || len(snapshot.Bids) != 0 {
+		t.Errorf("Expected one seeded ETH-USD ask, got %+v", snapshot)
+	}
+	if trades := tradeStore.List(""); len(trades) != 0 {
+		t.Errorf("Expected seeding to trade nothing, got %+v", trades)
+	}
+}
+
+func TestLoadSeedDepth_JSON(t *testing.T) {
+	snapshot := `{"symbol": "", "sequence": 42, "bids": [{"price": 99, "quantity": 4, "orders": 2}], "asks": [{"price": 101, "quantity": 1, "orders": 1}]}`
+	books, err := loadSeedDepth(writeSeedFile(t, "snapshot.json", snapshot))
+	if err == nil {
+		err = checkSeedDepth([]string{"BTC-USD"}, books)
+	}
+	if err != nil || len(books) != 1 || books[0].Symbol != "BTC-USD" || books[0].Bids[0].Orders != 2 {
+		t.Fatalf("Expected one snapshot for the default symbol, got %+v (%v)", books, err)
+	}
+	books, err = loadSeedDepth(writeSeedFile(t, "books.json", "["+snapshot+","+snapshot+"]"))
+	if err != nil || len(books) != 2 {
+		t.Errorf("Expected a list of snapshots, got %+v (%v)", books, err)
+	}
+}
+
+func TestLoadConfig_SeedDepth(t *testing.T) {
+	path := writeSeedFile(t, "depth.csv", "symbol,side,price,quantity\nBTC-USD,buy,99,1\n")
+	if cfg, err := loadConfig([]string{"-symbols", "BTC-USD", "-seed-depth", path}); err != nil || len(cfg.SeedDepth) != 1 {
+		t.Fatalf("Unexpected seed depth %+v (%v)", cfg.SeedDepth, err)
+	}
+
+	for name, contents := range map[string]string{
+		"no-header.csv":   "",
+		"no-price.csv":    "symbol,side,quantity\nBTC-USD,buy,1\n",
+		"bad-number.csv":  "symbol,side,price,quantity\nBTC-USD,buy,cheap,1\n",
+		"bad-side.csv":    "symbol,side,price,quantity\nBTC-USD,long,99,1\n",
+		"unknown.csv":     "symbol,side,price,quantity\nDOGE-USD,buy,99,1\n",
+		"zero.csv":        "symbol,side,price,quantity\nBTC-USD,buy,99,0\n",
+		"many-orders.csv": "symbol,side,price,quantity,orders\nBTC-USD,buy,99,2,3\n",
+		"crossed.csv":     "symbol,side,price,quantity\nBTC-USD,buy,101,1\nBTC-USD,sell,100,1\n",
+		"bad.json":        `{"bids": "none"}`,
+	} {
+		if _, err := loadConfig([]string{"-symbols", "BTC-USD", "-seed-depth", writeSeedFile(t, name, contents)}); err == nil {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+	if _, err := loadConfig([]string{"-seed-depth", filepath.Join(t.TempDir(), "missing.csv")}); err == nil {
+		t.Error("Expected an error for a missing depth file")
+	}
+	if _, err := loadConfig([]string{"-symbols", "BTC-USD", "-seed-depth", path, "-replicate-from", "http://primary:8080"}); err == nil {
+		t.Error("Expected a standby refused a seed")
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// epoch names the run of sequence numbers the engine is handing out. It only
+// changes when they start over, so a client that sees a new epoch knows the
+// sequences it was following are gone rather than missed.
+var epoch atomic.Pointer[string]
+
+// currentEpoch returns the epoch sequences are numbered in, or "" before one
+// has started
+func currentEpoch() string {
+	if e := epoch.Load(); e != nil {
+		return *e
+	}
+	return ""
+}
+
+// setEpoch makes e the current epoch
+func setEpoch(e string) {
+	epoch.Store(&e)
+}
+
+// startEpoch starts a new epoch and returns it
+func startEpoch() string {
+	e := uuid.New().String()
+	setEpoch(e)
+	return e
+}
+
+// SequenceState is what the engine saves to its sequence file so the
+// sequences and IDs it hands out carry on where they stopped after a restart
+type SequenceState struct {
+	Epoch string `json:"epoch"`
+	// Clean is set once the server stopped and saved everything it had handed
+	// out. A server that stopped without saving leaves it unset.
+	Clean   bool      `json:"clean"`
+	SavedAt time.Time `json:"saved_at"`
+	// EventSequence is the sequence of the newest order event
+	EventSequence int `json:"event_sequence"`
+	// Replication is the newest replication message sent
+	Replication int64 `json:"replication"`
+	// Arrivals is the last arrival sequence handed out
+	Arrivals int64            `json:"arrivals"`
+	Books    []BookSequences  `json:"books"`
+	IDs      *SequentialState `json:"ids,omitempty"`
+	// Consumers are the consumer groups' offsets, which only mean anything
+	// in the epoch they were committed in
+	Consumers []ConsumerOffset `json:"consumers,omitempty"`
+}
+
+// BookSequences are one symbol's depth and L3 sequences
+type BookSequences struct {
+	Symbol string `json:"symbol"`
+	Depth  int64  `json:"depth"`
+	L3     int64  `json:"l3"`
+}
+
+// SequentialState is the last order and trade numbers a
+// SequentialIDGenerator issued
+type SequentialState struct {
+	Orders int `json:"orders"`
+	Trades int `json:"trades"`
+}
+
+// readSequences reads a sequence file, reporting false if there is none
+func readSequences(path string) (SequenceState, bool, error) {
+	var state SequenceState
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, false, nil
+	}
+	if err != nil {
+		return state, false, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, false, err
+	}
+	return state, true, nil
+}
+
+// writeSequences replaces a sequence file, through a temporary file so a
+// half-written one is never left behind
+func writeSequences(path string, state SequenceState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".tmp", data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// captureSequences copies every sequence the engine hands out. The matchers
+// and consumersMu must be held.
+func captureSequences(matchers []*matcher) SequenceState {
+	state := SequenceState{Epoch: currentEpoch(), SavedAt: engineClock.Now(), Arrivals: arrivals.Load(), Consumers: consumerOffsetsLocked()}
+	for _, m := range matchers {
+		state.Books = append(state.Books, BookSequences{Symbol: m.symbol, Depth: m.book.sequence, L3: m.book.l3.sequence})
+	}
+	state.Replication, _ = replication.status()
+
+	historyMu.Lock()
+	state.EventSequence = lastEventSequence
+	historyMu.Unlock()
+
+	if g, ok := idGenerator.(*SequentialIDGenerator); ok {
+		g.mu.Lock()
+		state.IDs = &SequentialState{Orders: g.orders, Trades: g.trades}
+		g.mu.Unlock()
+	}
+	return state
+}
+
+// resumeSequences carries on from the sequences in state, starting from them
+// rather than from zero. Symbols the state does not list start from zero.
+func resumeSequences(state SequenceState) {
+	books := make(map[string]BookSequences, len(state.Books))
+	for _, book := range state.Books {
+		books[book.Symbol] = book
+	}
+
+	holdMatchers(allMatchers(), func() {
+		setEpoch(state.Epoch)
+		arrivals.Store(state.Arrivals)
+		replication.mu.Lock()
+		replication.sequence = state.Replication
+		replication.mu.Unlock()
+
+		historyMu.Lock()
+		lastEventSequence = state.EventSequence
+		historyMu.Unlock()
+		replaceConsumerOffsets(state.Consumers)
+
+		if g, ok := idGenerator.(*SequentialIDGenerator); ok && state.IDs != nil {
+			g.mu.Lock()
+			g.orders, g.trades = state.IDs.Orders, state.IDs.Trades
+			g.mu.Unlock()
+		}
+	}, func(m *matcher) {
+		saved := books[m.symbol]
+		m.book.sequence = saved.Depth
+		m.book.l3.sequence = saved.L3
+		m.view.Store(nil)
+	})
+}
+
+// openSequences resumes the sequences saved in path when the server that
+// saved them stopped cleanly, and starts a new epoch otherwise. Either way
+// the file is marked as in use, so a crash before the next clean stop starts
+// over rather than reusing sequences handed out since.
+func openSequences(path string) error {
+	state, ok, err := readSequences(path)
+	if err != nil {
+		return err
+	}
+	switch {
+	case ok && state.Clean:
+		resumeSequences(state)
+		log.Printf("sequences: resuming epoch %s from %s", state.Epoch, path)
+	case ok:
+		log.Printf("sequences: %s was not saved cleanly; starting epoch %s", path, startEpoch())
+	default:
+		log.Printf("sequences: starting epoch %s", startEpoch())
+	}
+
+	matchers := allMatchers()
+	holdMatchers(matchers, func() {
+		consumersMu.Lock()
+		defer consumersMu.Unlock()
+		err = writeSequences(path, captureSequences(matchers))
+	}, nil)
+	return err
+}
+
+// saveSequencesOnSignal saves the sequences to path and exits when the server
+// is interrupted or terminated
+func saveSequencesOnSignal(path string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		saveSequences(path, func(err error) {
+			if err != nil {
+				log.Fatalf("sequences: %v", err)
+			}
+			log.Printf("sequences: saved epoch %s to %s", currentEpoch(), path)
+			os.Exit(0)
+		})
+	}()
+}
+
+// saveSequences holds every matcher, saves the sequences they reached to path
+// as a clean stop, and runs stop while they are still held so nothing more is
+// handed out after the save
+func saveSequences(path string, stop func(error)) {
+	matchers := allMatchers()
+	holdMatchers(matchers, func() {
+		consumersMu.Lock()
+		defer consumersMu.Unlock()
+		state := captureSequences(matchers)
+		state.Clean = true
+		stop(writeSequences(path, state))
+	}, nil)
+}
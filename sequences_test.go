@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// getDepth fetches the default symbol's depth snapshot
+func getDepth(t *testing.T) DepthSnapshot {
+	t.Helper()
+	var snapshot DepthSnapshot
+	json.NewDecoder(serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/depth/snapshot", nil)).Body).Decode(&snapshot)
+	return snapshot
+}
+
+func TestSequences_ResumeAfterACleanStop(t *testing.T) {
+	setupTest()
+	path := filepath.Join(t.TempDir(), "sequences.json")
+	if err := openSequences(path); err != nil {
+		t.Fatal(err)
+	}
+	started := currentEpoch()
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "ask", Side: SideSell, Price: 101, Quantity: 2})
+	placeOn(m, Order{ID: "lift", Side: SideBuy, Price: 101, Quantity: 1})
+	before := getDepth(t)
+	events, arrived := lastEventSequence, arrivals.Load()
+
+	var saved error
+	saveSequences(path, func(err error) { saved = err })
+	if saved != nil {
+		t.Fatal(saved)
+	}
+
+	// A restarted server starts from nothing, then picks up where it stopped
+	setupTest()
+	if err := openSequences(path); err != nil {
+		t.Fatal(err)
+	}
+	if currentEpoch() != started || lastEventSequence != events || arrivals.Load() != arrived {
+		t.Errorf("Expected epoch %s at event %d and arrival %d, got %s at %d and %d",
+			started, events, arrived, currentEpoch(), lastEventSequence, arrivals.Load())
+	}
+	m, _ = matcherFor("")
+	placeOn(m, Order{ID: "bid", Side: SideBuy, Price: 99, Quantity: 1})
+	if after := getDepth(t); after.Epoch != started || after.Sequence != before.Sequence+1 {
+		t.Errorf("Expected depth to go on from %d in epoch %s, got %+v", before.Sequence, started, after)
+	}
+	if event := orderEvents[0]; event.Sequence != events+1 {
+		t.Errorf("Expected the next order event to be %d, got %+v", events+1, event)
+	}
+}
+
+func TestSequences_StartOverAfterACrash(t *testing.T) {
+	setupTest()
+	path := filepath.Join(t.TempDir(), "sequences.json")
+	if err := openSequences(path); err != nil {
+		t.Fatal(err)
+	}
+	started := currentEpoch()
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "bid", Side: SideBuy, Price: 99, Quantity: 1})
+
+	// Without a clean stop the sequences handed out since are unknown
+	setupTest()
+	if err := openSequences(path); err != nil {
+		t.Fatal(err)
+	}
+	if currentEpoch() == started || currentEpoch() == "" || lastEventSequence != 0 {
+		t.Errorf("Expected a new epoch from zero, got %s at %d", currentEpoch(), lastEventSequence)
+	}
+	if state, _, _ := readSequences(path); state.Clean || state.Epoch != currentEpoch() {
+		t.Errorf("Expected the file marked in use by the new epoch, got %+v", state)
+	}
+}
+
+func TestSequences_RestoresStartANewEpoch(t *testing.T) {
+	setupTest()
+	started := startEpoch()
+	snapshot := takeSnapshot()
+	if snapshot.Epoch != started {
+		t.Errorf("Expected the snapshot taken in epoch %s, got %s", started, snapshot.Epoch)
+	}
+
+	restoreSnapshot(snapshot)
+	if restored := currentEpoch(); restored == started || getDepth(t).Epoch != restored {
+		t.Errorf("Expected a restore to start a new epoch, got %s", restored)
+	}
+
+	// A standby numbers its feeds in its primary's epoch
+	if err := loadStreamSnapshot(snapshot, 1); err != nil {
+		t.Fatal(err)
+	}
+	if currentEpoch() != started || replicationStatus().Epoch != started {
+		t.Errorf("Expected the primary's epoch %s, got %s", started, currentEpoch())
+	}
+}
+
+func TestLoadConfig_SequenceFile(t *testing.T) {
+	if cfg, _ := loadConfig(nil); cfg.SequenceFile != "" {
+		t.Errorf("Expected no sequence file by default, got %q", cfg.SequenceFile)
+	}
+	if cfg, _ := loadConfig([]string{"-sequence-file", "seq.json"}); cfg.SequenceFile != "seq.json" {
+		t.Errorf("Expected seq.json, got %q", cfg.SequenceFile)
+	}
+}
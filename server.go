@@ -0,0 +1,94 @@
+package main
+
+import (
+	"log"
+	"net/http"
+)
+
+// newServer registers every route from apiRoutes on one mux. Each path gets
+// a single pattern that dispatches on the method, so a wrong method gets the
+// JSON error envelope and preflight requests see every method the path
+// accepts. Logging and recovery see every request; tracing, CORS, auth, IP
+// allowlists, entitlements, request signatures, standby write refusal,
+// request timeouts, compression and schema versions are configured per route.
+func newServer(cfg HTTPConfig) http.Handler {
+	var paths []string
+	handlers := make(map[string]map[string]http.Handler)
+	allowed := make(map[string][]string)
+	nonces := newNonceCache()
+	for _, route := range apiRoutes() {
+		if handlers[route.path] == nil {
+			paths = append(paths, route.path)
+			handlers[route.path] = make(map[string]http.Handler)
+		}
+
+		// Compression goes outside versioning and versioning outside the rest,
+		// so refusals from the checks are versioned and compressed too
+		var perRoute []middleware
+		if cfg.Compress && !route.websocket {
+			perRoute = append(perRoute, compress)
+		}
+		if !route.hidden && !route.unversioned {
+			perRoute = append(perRoute, versionPayloads(route))
+		}
+		if tracer != nil && !route.websocket {
+			perRoute = append(perRoute, traceRoute(route.method, route.path))
+		}
+		if (len(cfg.APIKeys) > 0 || cfg.JWT != nil) && !route.public {
+			perRoute = append(perRoute, requireAPIKey(cfg.APIKeys, cfg.JWT))
+		}
+		if len(cfg.KeyAllowlists) > 0 && !route.public {
+			perRoute = append(perRoute, requireAllowedIP(cfg))
+		}
+		if (len(cfg.Entitlements) > 0 || cfg.DefaultEntitlement != "") && !route.public {
+			perRoute = append(perRoute, checkEntitlement(cfg, route.data))
+		}
+		if (len(cfg.KeyRoles) > 0 || len(cfg.DefaultRoles) > 0 || cfg.JWT != nil) && !route.public {
+			perRoute = append(perRoute, requireRole(cfg, route.requiredRole()))
+		}
+		if len(cfg.APISecrets) > 0 && route.requiredRole() == RoleTrade && !route.websocket && !route.public {
+			perRoute = append(perRoute, requireSignature(cfg, nonces))
+		}
+		if (len(cfg.KeyOwners) > 0 || cfg.JWT != nil) && !route.public {
+			perRoute = append(perRoute, identifyOwner(cfg.KeyOwners))
+		}
+		if route.compliance {
+			perRoute = append(perRoute, requireDropCopyKey(cfg.complianceKeys()))
+		}
+		if route.method != "GET" && !route.standby {
+			perRoute = append(perRoute, refuseOnStandby)
+		}
+		if cfg.RequestTimeout > 0 {
+			perRoute = append(perRoute, withRequestTimeout(cfg.RequestTimeout, route.websocket))
+		}
+		handlers[route.path][route.method] = chain(route.handler, perRoute...)
+		allowed[route.path] = append(allowed[route.path], route.method)
+	}
+
+	mux := http.NewServeMux()
+	for _, path := range paths {
+		mux.Handle(path, chain(routeByMethod(handlers[path], allowed[path]), cors(cfg.CORSOrigins, allowed[path])))
+	}
+
+	var shared []middleware
+	if cfg.LogRequests {
+		shared = append(shared, logRequests(log.Default()))
+	}
+	return chain(mux, append(shared, recoverPanics)...)
+}
+
+// routeByMethod calls the handler registered for the request method. GET
+// handlers also answer HEAD.
+func routeByMethod(handlers map[string]http.Handler, allowed []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler, ok := handlers[r.Method]
+		if !ok && r.Method == "HEAD" {
+			handler, ok = handlers["GET"]
+		}
+		if !ok {
+			writeMethodNotAllowed(w, allowed)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
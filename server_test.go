@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServer_OrderAndTradeResources(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "ask-1", Side: SideSell, Price: 100.0, Quantity: 10})
+	placeOn(m, Order{ID: "buy-1", Side: SideBuy, Price: 100.0, Quantity: 4})
+
+	response := serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/orders/ask-1", nil))
+	var order Order
+	json.NewDecoder(response.Body).Decode(&order)
+	if response.Code != http.StatusOK || order.ID != "ask-1" || order.Quantity != 6 {
+		t.Errorf("Expected the partly filled ask, got %d %+v", response.Code, order)
+	}
+
+	// buy-1 filled in full, so it no longer rests
+	response = serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/orders/buy-1", nil))
+	if result := decodeError(t, response); response.Code != http.StatusNotFound || result.Error.Code != ErrCodeOrderNotFound {
+		t.Errorf("Expected 404 %s for a filled order, got %d %s", ErrCodeOrderNotFound, response.Code, result.Error.Code)
+	}
+
+	tradeID := tradeStore.List("")[0].ID
+	response = serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/trades/"+tradeID, nil))
+	var trade Trade
+	json.NewDecoder(response.Body).Decode(&trade)
+	if response.Code != http.StatusOK || trade.ID != tradeID || trade.MakerID != "ask-1" {
+		t.Errorf("Expected the trade, got %d %+v", response.Code, trade)
+	}
+
+	response = serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/trades/missing", nil))
+	if result := decodeError(t, response); response.Code != http.StatusNotFound || result.Error.Code != ErrCodeTradeNotFound {
+		t.Errorf("Expected 404 %s, got %d %s", ErrCodeTradeNotFound, response.Code, result.Error.Code)
+	}
+}
+
+func TestServer_LiteralPathsBeatPathValues(t *testing.T) {
+	setupTest()
+
+	response := serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/trades/export", nil))
+	if response.Code != http.StatusOK || response.Header().Get("Content-Type") != "text/csv" {
+		t.Errorf("Expected the CSV export, got %d %s", response.Code, response.Header().Get("Content-Type"))
+	}
+}
+
+func TestServer_RoutesByMethod(t *testing.T) {
+	setupTest()
+
+	if code := serve(HTTPConfig{}, httptest.NewRequest("HEAD", "/api/v1/symbols", nil)).Code; code != http.StatusOK {
+		t.Errorf("Expected GET routes to answer HEAD, got %d", code)
+	}
+
+	response := serve(HTTPConfig{}, httptest.NewRequest("PATCH", "/api/v1/algos/algo-1", nil))
+	if response.Code != http.StatusMethodNotAllowed || response.Header().Get("Allow") != "GET, DELETE" {
+		t.Errorf("Expected 405 allowing GET and DELETE, got %d %q", response.Code, response.Header().Get("Allow"))
+	}
+
+	// The unversioned paths are gone
+	if code := serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/orders", nil)).Code; code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unversioned path, got %d", code)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// SessionKind is the stream a session is connected to
+type SessionKind string
+
+const (
+	SessionDepth       SessionKind = "depth"
+	SessionReplication SessionKind = "replication"
+	SessionPublicFeed  SessionKind = "public_feed"
+	SessionPrivateFeed SessionKind = "private_feed"
+	SessionDropCopy    SessionKind = "drop_copy"
+	SessionL3          SessionKind = "l3"
+	SessionGraphQL     SessionKind = "graphql"
+	SessionRPC         SessionKind = "rpc"
+)
+
+// StreamSession describes one connected streaming client
+type StreamSession struct {
+	ID            string      `json:"id"`
+	Kind          SessionKind `json:"kind"`
+	RemoteAddr    string      `json:"remote_addr"`
+	Subscriptions []string    `json:"subscriptions"`
+	Entitlement   Entitlement `json:"entitlement"`
+	ConnectedAt   time.Time   `json:"connected_at"`
+	// QueueLength is how many messages are waiting to be written, out of
+	// QueueCapacity before the session is dropped and told to resync
+	QueueLength   int   `json:"queue_length"`
+	QueueCapacity int   `json:"queue_capacity"`
+	Sent          int64 `json:"sent"`
+	Resyncs       int64 `json:"resyncs"`
+	// Conflated counts the depth updates folded into others while the
+	// session was behind
+	Conflated int64 `json:"conflated"`
+}
+
+// SessionsResponse lists the connected sessions, oldest first
+type SessionsResponse struct {
+	Sessions []StreamSession `json:"sessions"`
+	Count    int             `json:"count"`
+}
+
+// session is a connected stream client. The stream handler counts what it
+// sends and watches kicked, which closes when an operator disconnects it.
+type session struct {
+	info      StreamSession
+	queue     func() int
+	sent      atomic.Int64
+	resyncs   atomic.Int64
+	conflated atomic.Int64
+
+	kicked   chan struct{}
+	kickOnce sync.Once
+	// slow is set when the session was dropped for falling behind rather
+	// than by an operator
+	slow atomic.Bool
+}
+
+var (
+	sessionsMu sync.Mutex
+	sessions   = make(map[string]*session)
+)
+
+// openSession registers a stream connection. queue reports how many messages
+// the session has waiting, out of capacity.
+func openSession(kind SessionKind, r *http.Request, subscriptions []string, capacity int, queue func() int) *session {
+	s := &session{
+		info: StreamSession{
+			ID:            uuid.New().String(),
+			Kind:          kind,
+			RemoteAddr:    r.RemoteAddr,
+			Subscriptions: subscriptions,
+			Entitlement:   entitlementFrom(r.Context()),
+			ConnectedAt:   engineClock.Now(),
+			QueueCapacity: capacity,
+		},
+		queue:  queue,
+		kicked: make(chan struct{}),
+	}
+
+	sessionsMu.Lock()
+	sessions[s.info.ID] = s
+	sessionsMu.Unlock()
+	return s
+}
+
+// close removes the session once its connection has ended
+func (s *session) close() {
+	sessionsMu.Lock()
+	delete(sessions, s.info.ID)
+	sessionsMu.Unlock()
+}
+
+// kick asks the session's stream handler to disconnect it
+func (s *session) kick() {
+	s.kickOnce.Do(func() { close(s.kicked) })
+}
+
+// drop disconnects a session that has fallen too far behind to catch up
+func (s *session) drop() {
+	s.slow.Store(true)
+	s.kick()
+}
+
+// writeKicked tells a kicked client why its connection is closing
+func (s *session) writeKicked(conn *websocket.Conn) {
+	message := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "disconnected by an operator")
+	if s.slow.Load() {
+		message = websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow: fell behind for "+streamConfig.SlowTimeout.String())
+	}
+	conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+}
+
+// snapshot returns the session's description with its current counters
+func (s *session) snapshot() StreamSession {
+	info := s.info
+	info.Subscriptions = append([]string{}, s.info.Subscriptions...)
+	if s.queue != nil {
+		info.QueueLength = s.queue()
+	}
+	info.Sent = s.sent.Load()
+	info.Resyncs = s.resyncs.Load()
+	info.Conflated = s.conflated.Load()
+	return info
+}
+
+// sessionSnapshots returns every connected session, oldest first
+func sessionSnapshots() []StreamSession {
+	sessionsMu.Lock()
+	list := make([]StreamSession, 0, len(sessions))
+	for _, s := range sessions {
+		list = append(list, s.snapshot())
+	}
+	sessionsMu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].ConnectedAt.Equal(list[j].ConnectedAt) {
+			return list[i].ConnectedAt.Before(list[j].ConnectedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// getSessionsHandler returns every connected streaming session
+func getSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	list := sessionSnapshots()
+	json.NewEncoder(w).Encode(SessionsResponse{
+		Sessions: list,
+		Count:    len(list),
+	})
+}
+
+// kickSessionHandler disconnects the session named in the path
+func kickSessionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id := r.PathValue("id")
+	sessionsMu.Lock()
+	s, ok := sessions[id]
+	sessionsMu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrCodeSessionNotFound, "Session not found",
+			"no connected session with ID '"+id+"'")
+		return
+	}
+
+	s.kick()
+	json.NewEncoder(w).Encode(s.snapshot())
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// listSessions fetches the connected sessions
+func listSessions(t *testing.T) SessionsResponse {
+	t.Helper()
+	response := serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/admin/sessions", nil))
+	var result SessionsResponse
+	json.NewDecoder(response.Body).Decode(&result)
+	return result
+}
+
+func TestSessions_TrackAndKickDepthStreams(t *testing.T) {
+	setupTest()
+	server := httptest.NewServer(newServer(HTTPConfig{}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/api/v1/depth/stream", nil)
+	if err != nil {
+		t.Fatalf("Expected to connect, got %v", err)
+	}
+	m, _ := matcherFor("")
+	defer closeStream(t, m, conn)
+	waitForSubscribers(t, m, 1)
+
+	placeOn(m, Order{ID: "buy-1", Side: SideBuy, Price: 99.5, Quantity: 4})
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var update DepthUpdate
+	if err := conn.ReadJSON(&update); err != nil {
+		t.Fatalf("Expected an update, got %v", err)
+	}
+
+	list := listSessions(t)
+	if list.Count != 1 {
+		t.Fatalf("Expected one session, got %+v", list)
+	}
+	session := list.Sessions[0]
+	if session.Kind != SessionDepth || len(session.Subscriptions) != 1 || session.Subscriptions[0] != "depth:DEFAULT" {
+		t.Errorf("Unexpected session %+v", session)
+	}
+	if session.Sent != 1 || session.QueueCapacity != depthBufferSize {
+		t.Errorf("Expected one message sent out of a %d queue, got %+v", depthBufferSize, session)
+	}
+
+	response := serve(HTTPConfig{}, httptest.NewRequest("DELETE", "/api/v1/admin/sessions/"+session.ID, nil))
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected the session to be kicked, got %d: %s", response.Code, response.Body.String())
+	}
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Errorf("Expected an operator close, got %v", err)
+	}
+	waitForSubscribers(t, m, 0)
+	if list := listSessions(t); list.Count != 0 {
+		t.Errorf("Expected the session to be gone, got %+v", list)
+	}
+}
+
+func TestSessions_CountResyncs(t *testing.T) {
+	setupTest()
+	m, subscriber := subscribeDepth(t, 1)
+	subscriber.session = openSession(SessionDepth, httptest.NewRequest("GET", "/", nil), []string{"depth:DEFAULT"}, 1,
+		func() int { return len(subscriber.updates) })
+	defer subscriber.session.close()
+
+	for i, price := range []float64{101.0, 102.0} {
+		placeOn(m, Order{ID: fmt.Sprintf("sell-%d", i+1), Side: SideSell, Price: price, Quantity: 1})
+	}
+	if session := subscriber.session.snapshot(); session.Resyncs != 1 || session.QueueLength != 1 {
+		t.Errorf("Expected one resync with the resync message queued, got %+v", session)
+	}
+}
+
+func TestSessions_KickUnknown(t *testing.T) {
+	setupTest()
+	response := serve(HTTPConfig{}, httptest.NewRequest("DELETE", "/api/v1/admin/sessions/missing", nil))
+	if result := decodeError(t, response); response.Code != http.StatusNotFound || result.Error.Code != ErrCodeSessionNotFound {
+		t.Errorf("Expected 404 SESSION_NOT_FOUND, got %d %s", response.Code, result.Error.Code)
+	}
+}
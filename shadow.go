@@ -0,0 +1,481 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// shadowOwner owns the orders a shadow book mirrors from its exchange
+const shadowOwner = "shadow"
+
+// ShadowConfig mirrors outside exchanges' public feeds into read-only symbols
+type ShadowConfig struct {
+	// Feeds maps each shadow symbol to the exchange product it mirrors
+	Feeds map[string]ShadowFeed
+	// Lot is how much of the exchange's quantity one unit of engine quantity
+	// stands for, e.g. 0.0001 BTC
+	Lot float64
+	// URLs maps each exchange to the WebSocket endpoint its feed is read from
+	URLs map[string]string
+	// Paper fills orders on shadow symbols against the mirrored liquidity
+	// instead of refusing them
+	Paper bool
+}
+
+// ShadowFeed is an exchange product a shadow symbol mirrors
+type ShadowFeed struct {
+	Exchange string
+	Product  string
+}
+
+// ShadowStatus reports how a shadow symbol's feed is going
+type ShadowStatus struct {
+	Symbol    string `json:"symbol"`
+	Exchange  string `json:"exchange"`
+	Product   string `json:"product"`
+	Connected bool   `json:"connected"`
+	LastError string `json:"last_error,omitempty"`
+	// LastUpdate is when the exchange last sent depth or a trade
+	LastUpdate *time.Time `json:"last_update,omitempty"`
+	// Updates and Trades count the depth messages and trades mirrored
+	Updates int64 `json:"updates"`
+	Trades  int64 `json:"trades"`
+}
+
+// ShadowResponse lists the shadow symbols and their feeds
+type ShadowResponse struct {
+	Feeds []ShadowStatus `json:"feeds"`
+}
+
+// shadowUpdate is one exchange message in the engine's terms. A snapshot
+// replaces the whole book; otherwise only the levels given change, and a
+// level with no size empties.
+type shadowUpdate struct {
+	snapshot bool
+	levels   []shadowLevel
+	trades   []shadowTrade
+}
+
+// shadowLevel is an exchange price level and its total size
+type shadowLevel struct {
+	side  Side
+	price float64
+	size  float64
+}
+
+// shadowTrade is a trade printed on the exchange
+type shadowTrade struct {
+	price     float64
+	size      float64
+	aggressor Side
+}
+
+// shadowExchange is how one exchange's public feed is read: where to connect
+// for a product, what to send once connected, and how its messages decode
+type shadowExchange struct {
+	endpoint func(base, product string) string
+	// subscribe is sent once connected; nil sends nothing
+	subscribe func(product string) any
+	decode    func(data []byte) (shadowUpdate, error)
+}
+
+// shadowExchanges are the exchanges a shadow symbol can mirror
+var shadowExchanges = map[string]shadowExchange{
+	"binance": {
+		endpoint: func(base, product string) string {
+			product = strings.ToLower(product)
+			return strings.TrimSuffix(base, "/") + "/stream?streams=" + product + "@depth20@100ms/" + product + "@trade"
+		},
+		decode: decodeBinance,
+	},
+	"coinbase": {
+		endpoint: func(base, product string) string { return base },
+		subscribe: func(product string) any {
+			return map[string]any{"type": "subscribe", "product_ids": []string{product}, "channels": []string{"level2_batch", "matches"}}
+		},
+		decode: decodeCoinbase,
+	},
+}
+
+// defaultShadowURLs are the exchanges' public WebSocket endpoints
+var defaultShadowURLs = map[string]string{
+	"binance":  "wss://stream.binance.com:9443",
+	"coinbase": "wss://ws-feed.exchange.coinbase.com",
+}
+
+// shadowSymbols are the symbols that mirror an exchange and take no orders.
+// It is set before the server starts and only read after.
+var shadowSymbols map[string]bool
+
+// shadows tracks each shadow symbol's feed
+var shadows struct {
+	mu    sync.Mutex
+	feeds map[string]*ShadowStatus
+}
+
+// shadowRetryDelay is how long a shadow feed waits before reconnecting
+var shadowRetryDelay = time.Second
+
+// shadowed reports whether a symbol mirrors an exchange
+func shadowed(symbol string) bool {
+	return shadowSymbols[symbol]
+}
+
+// startShadows mirrors each configured exchange feed into its symbol until
+// ctx is done
+func startShadows(ctx context.Context, cfg ShadowConfig) {
+	shadowSymbols = make(map[string]bool)
+	paperTrading = cfg.Paper
+	shadows.mu.Lock()
+	shadows.feeds = make(map[string]*ShadowStatus)
+	shadows.mu.Unlock()
+
+	for symbol, feed := range cfg.Feeds {
+		m, ok := matcherFor(symbol)
+		if !ok {
+			log.Printf("shadow: unknown symbol %q, feed not started", symbol)
+			continue
+		}
+		shadowSymbols[m.symbol] = true
+		shadows.mu.Lock()
+		shadows.feeds[m.symbol] = &ShadowStatus{Symbol: m.symbol, Exchange: feed.Exchange, Product: feed.Product}
+		shadows.mu.Unlock()
+
+		go runShadow(ctx, m, feed, cfg.URLs[feed.Exchange], cfg.Lot, shadowRetryDelay)
+		log.Printf("shadow: mirroring %s %s into %s", feed.Exchange, feed.Product, m.symbol)
+	}
+}
+
+// runShadow follows the exchange feed, reconnecting whenever it fails. A
+// standby takes its shadow books from the primary, so it does not connect.
+func runShadow(ctx context.Context, m *matcher, feed ShadowFeed, base string, lot float64, retry time.Duration) {
+	for {
+		if !standby.running.Load() {
+			err := followShadow(ctx, m, feed, base, lot)
+			updateShadow(m.symbol, func(status *ShadowStatus) {
+				status.Connected = false
+				if err != nil && ctx.Err() == nil {
+					status.LastError = err.Error()
+				}
+			})
+			if ctx.Err() == nil {
+				log.Printf("shadow: %s: %v; reconnecting in %s", m.symbol, err, retry)
+			}
+		}
+		select {
+		case <-time.After(retry):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// followShadow mirrors the exchange's depth and trades into the symbol until
+// the connection fails or ctx is cancelled
+func followShadow(ctx context.Context, m *matcher, feed ShadowFeed, base string, lot float64) error {
+	exchange := shadowExchanges[feed.Exchange]
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, exchange.endpoint(base, feed.Product), nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if exchange.subscribe != nil {
+		if err := conn.WriteJSON(exchange.subscribe(feed.Product)); err != nil {
+			return err
+		}
+	}
+	updateShadow(m.symbol, func(status *ShadowStatus) { status.Connected, status.LastError = true, "" })
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		update, err := exchange.decode(data)
+		if err != nil {
+			return fmt.Errorf("%s: %v", feed.Exchange, err)
+		}
+		if !update.snapshot && len(update.levels) == 0 && len(update.trades) == 0 {
+			continue
+		}
+		m.do(func() {
+			mirrorDepth(m, update, lot)
+			for _, trade := range update.trades {
+				mirrorTrade(m, trade, lot)
+			}
+		})
+
+		now := engineClock.Now()
+		updateShadow(m.symbol, func(status *ShadowStatus) {
+			status.LastUpdate = &now
+			status.Trades += int64(len(update.trades))
+			if update.snapshot || len(update.levels) > 0 {
+				status.Updates++
+			}
+		})
+	}
+}
+
+// updateShadow changes a shadow symbol's status under the lock
+func updateShadow(symbol string, change func(status *ShadowStatus)) {
+	shadows.mu.Lock()
+	defer shadows.mu.Unlock()
+	if status, ok := shadows.feeds[symbol]; ok {
+		change(status)
+	}
+}
+
+// levelKey names one price level on one side of a book
+type levelKey struct {
+	side  Side
+	price float64
+}
+
+// mirrorDepth sets the shadow book's levels to the exchange's, each as one
+// order of the size in lots. Levels smaller than a lot are left out. Paper
+// orders then catch up with the levels that changed. It must run on the
+// book's matcher.
+func mirrorDepth(m *matcher, update shadowUpdate, lot float64) {
+	book := m.book
+	levels := make(map[levelKey]int, len(update.levels))
+	for _, level := range update.levels {
+		levels[levelKey{level.side, level.price}] = int(math.Round(level.size / lot))
+	}
+	if update.snapshot {
+		for _, order := range append(append([]Order(nil), book.bids.orders()...), book.asks.orders()...) {
+			if _, ok := levels[levelKey{order.Side, order.Price}]; !ok {
+				levels[levelKey{order.Side, order.Price}] = 0
+			}
+		}
+	}
+
+	now := engineClock.Now()
+	var changed []levelKey
+	for key, quantity := range levels {
+		resting := book.sideOf(key.side).level(key.price)
+		if (len(resting) == 1 && resting[0].Quantity == quantity) || (len(resting) == 0 && quantity <= 0) {
+			continue
+		}
+		level := LevelOrders{Side: key.side, Price: key.price, Orders: []Order{}}
+		if quantity > 0 {
+			level.Orders = append(level.Orders, Order{
+				ID:        fmt.Sprintf("%s-%s-%s-%s", shadowOwner, m.symbol, key.side, strconv.FormatFloat(key.price, 'f', -1, 64)),
+				Symbol:    m.symbol,
+				Side:      key.side,
+				Price:     key.price,
+				Quantity:  quantity,
+				Status:    OrderStatusPending,
+				CreatedAt: now,
+				Owner:     shadowOwner,
+			})
+		}
+		replaceLevel(book, level)
+		changed = append(changed, key)
+	}
+	if len(changed) > 0 {
+		followPaperDepth(book, changed)
+	}
+}
+
+// mirrorTrade records an exchange trade as the shadow symbol's own, for the
+// trade history, candles and public feed. It must run on the book's matcher.
+func mirrorTrade(m *matcher, printed shadowTrade, lot float64) {
+	quantity := int(math.Round(printed.size / lot))
+	if quantity <= 0 {
+		return
+	}
+	trade := Trade{
+		ID:            generateTradeID(),
+		Symbol:        m.symbol,
+		Price:         printed.price,
+		Quantity:      quantity,
+		CreatedAt:     engineClock.Now(),
+		AggressorSide: printed.aggressor,
+	}
+	tickTrade(m.book, &trade)
+	setTradeAmounts(&trade)
+	recordTrade(trade)
+	feed.publicTrade(trade)
+	followPaperTrade(m.book, trade)
+}
+
+// decodeBinance reads a message from a Binance combined stream of partial
+// book depth and trades
+func decodeBinance(data []byte) (shadowUpdate, error) {
+	var msg struct {
+		Stream string `json:"stream"`
+		Data   struct {
+			Bids  [][2]string `json:"bids"`
+			Asks  [][2]string `json:"asks"`
+			Price string      `json:"p"`
+			Size  string      `json:"q"`
+			// BuyerMaker is set when the seller was the aggressor
+			BuyerMaker bool `json:"m"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return shadowUpdate{}, err
+	}
+
+	var update shadowUpdate
+	switch {
+	case strings.Contains(msg.Stream, "@depth"):
+		update.snapshot = true
+		for _, side := range []struct {
+			side   Side
+			levels [][2]string
+		}{{SideBuy, msg.Data.Bids}, {SideSell, msg.Data.Asks}} {
+			for _, raw := range side.levels {
+				level, err := parseShadowLevel(side.side, raw[0], raw[1])
+				if err != nil {
+					return shadowUpdate{}, err
+				}
+				update.levels = append(update.levels, level)
+			}
+		}
+	case strings.HasSuffix(msg.Stream, "@trade"):
+		aggressor := SideBuy
+		if msg.Data.BuyerMaker {
+			aggressor = SideSell
+		}
+		trade, err := parseShadowTrade(aggressor, msg.Data.Price, msg.Data.Size)
+		if err != nil {
+			return shadowUpdate{}, err
+		}
+		update.trades = append(update.trades, trade)
+	}
+	return update, nil
+}
+
+// decodeCoinbase reads a message from the Coinbase Exchange feed's
+// level2_batch and matches channels
+func decodeCoinbase(data []byte) (shadowUpdate, error) {
+	var msg struct {
+		Type    string      `json:"type"`
+		Message string      `json:"message"`
+		Bids    [][2]string `json:"bids"`
+		Asks    [][2]string `json:"asks"`
+		Changes [][3]string `json:"changes"`
+		Price   string      `json:"price"`
+		Size    string      `json:"size"`
+		// Side is the maker's side of a match
+		Side string `json:"side"`
+	}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return shadowUpdate{}, err
+	}
+
+	var update shadowUpdate
+	switch msg.Type {
+	case "error":
+		return shadowUpdate{}, errors.New(msg.Message)
+	case "snapshot":
+		update.snapshot = true
+		for _, side := range []struct {
+			side   Side
+			levels [][2]string
+		}{{SideBuy, msg.Bids}, {SideSell, msg.Asks}} {
+			for _, raw := range side.levels {
+				level, err := parseShadowLevel(side.side, raw[0], raw[1])
+				if err != nil {
+					return shadowUpdate{}, err
+				}
+				update.levels = append(update.levels, level)
+			}
+		}
+	case "l2update":
+		for _, change := range msg.Changes {
+			level, err := parseShadowLevel(Side(change[0]), change[1], change[2])
+			if err != nil {
+				return shadowUpdate{}, err
+			}
+			update.levels = append(update.levels, level)
+		}
+	case "match", "last_match":
+		aggressor := SideBuy
+		if msg.Side == string(SideBuy) {
+			aggressor = SideSell
+		}
+		trade, err := parseShadowTrade(aggressor, msg.Price, msg.Size)
+		if err != nil {
+			return shadowUpdate{}, err
+		}
+		update.trades = append(update.trades, trade)
+	}
+	return update, nil
+}
+
+// parseShadowLevel reads a level whose price and size an exchange sent as
+// strings
+func parseShadowLevel(side Side, price, size string) (shadowLevel, error) {
+	level := shadowLevel{side: side}
+	var priceErr, sizeErr error
+	level.price, priceErr = strconv.ParseFloat(price, 64)
+	level.size, sizeErr = strconv.ParseFloat(size, 64)
+	if priceErr != nil || sizeErr != nil || level.price <= 0 || level.size < 0 || (side != SideBuy && side != SideSell) {
+		return shadowLevel{}, fmt.Errorf("bad %s level %q x %q", side, price, size)
+	}
+	return level, nil
+}
+
+// parseShadowTrade reads a trade whose price and size an exchange sent as
+// strings
+func parseShadowTrade(aggressor Side, price, size string) (shadowTrade, error) {
+	trade := shadowTrade{aggressor: aggressor}
+	var priceErr, sizeErr error
+	trade.price, priceErr = strconv.ParseFloat(price, 64)
+	trade.size, sizeErr = strconv.ParseFloat(size, 64)
+	if priceErr != nil || sizeErr != nil || trade.price <= 0 || trade.size <= 0 {
+		return shadowTrade{}, fmt.Errorf("bad trade %q x %q", price, size)
+	}
+	return trade, nil
+}
+
+// parseShadowFeeds reads symbol=exchange:product pairs, such as
+// BTC-USD=binance:btcusdt
+func parseShadowFeeds(list string) (map[string]ShadowFeed, error) {
+	feeds := make(map[string]ShadowFeed)
+	for _, item := range splitList(list) {
+		symbol, source, ok := strings.Cut(item, "=")
+		exchange, product, hasProduct := strings.Cut(strings.TrimSpace(source), ":")
+		exchange = strings.ToLower(strings.TrimSpace(exchange))
+		if _, known := shadowExchanges[exchange]; !ok || !hasProduct || !known || strings.TrimSpace(symbol) == "" || strings.TrimSpace(product) == "" {
+			return nil, fmt.Errorf("-shadow entry %q must be symbol=exchange:product with exchange binance or coinbase", item)
+		}
+		feeds[strings.ToUpper(strings.TrimSpace(symbol))] = ShadowFeed{Exchange: exchange, Product: strings.TrimSpace(product)}
+	}
+	return feeds, nil
+}
+
+// shadowStatuses returns every shadow symbol's status, by symbol
+func shadowStatuses() []ShadowStatus {
+	shadows.mu.Lock()
+	defer shadows.mu.Unlock()
+	list := make([]ShadowStatus, 0, len(shadows.feeds))
+	for _, status := range shadows.feeds {
+		list = append(list, *status)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Symbol < list[j].Symbol })
+	return list
+}
+
+// getShadowHandler reports each shadow symbol's feed
+func getShadowHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ShadowResponse{Feeds: shadowStatuses()})
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeExchange serves a WebSocket feed that sends messages once the client
+// has connected and, if read is set, sent its subscription
+func fakeExchange(t *testing.T, read bool, messages ...string) (*httptest.Server, chan *http.Request, chan map[string]any) {
+	t.Helper()
+	requests, subscriptions := make(chan *http.Request, 1), make(chan map[string]any, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := depthUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		requests <- r
+		if read {
+			var subscription map[string]any
+			conn.ReadJSON(&subscription)
+			subscriptions <- subscription
+		}
+		for _, msg := range messages {
+			conn.WriteMessage(websocket.TextMessage, []byte(msg))
+		}
+		conn.ReadMessage()
+	}))
+	t.Cleanup(server.Close)
+	return server, requests, subscriptions
+}
+
+// waitForShadowTrades waits until the symbol's feed has mirrored count trades
+func waitForShadowTrades(t *testing.T, symbol string, count int64) ShadowStatus {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		for _, status := range shadowStatuses() {
+			if status.Symbol == symbol && status.Trades >= count {
+				return status
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d trades mirrored into %s, got %+v", count, symbol, shadowStatuses())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestShadow_MirrorsBinance(t *testing.T) {
+	setupTest()
+	resetSymbols([]string{"BTC-USD"})
+	exchange, requests, _ := fakeExchange(t, false,
+		`{"stream": "btcusdt@depth20@100ms", "data": {"lastUpdateId": 1, "bids": [["100.50", "0.25"], ["100.00", "0.00001"]], "asks": [["101.00", "1.5"]]}}`,
+		`{"stream": "btcusdt@trade", "data": {"e": "trade", "p": "100.75", "q": "0.01", "m": true}}`)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	startShadows(ctx, ShadowConfig{
+		Feeds: map[string]ShadowFeed{"BTC-USD": {Exchange: "binance", Product: "BTCUSDT"}},
+		Lot:   0.0001,
+		URLs:  map[string]string{"binance": "ws" + strings.TrimPrefix(exchange.URL, "http")},
+	})
+
+	if r := <-requests; r.URL.Query().Get("streams") != "btcusdt@depth20@100ms/btcusdt@trade" {
+		t.Errorf("Expected the depth and trade streams, got %s", r.URL)
+	}
+	status := waitForShadowTrades(t, "BTC-USD", 1)
+	if !status.Connected || status.Updates != 1 || status.LastUpdate == nil {
+		t.Errorf("Expected a connected feed with one depth update, got %+v", status)
+	}
+
+	// Sizes become lots, and a level smaller than a lot is left out
+	m, _ := matcherFor("BTC-USD")
+	snapshot := m.depthSnapshot(0)
+	if len(snapshot.Bids) != 1 || snapshot.Bids[0] != (PriceLevel{Price: 100.5, Quantity: 2500, Orders: 1}) ||
+		len(snapshot.Asks) != 1 || snapshot.Asks[0].Quantity != 15000 {
+		t.Fatalf("Expected the exchange's book in lots, got %+v", snapshot)
+	}
+	trades := tradeStore.List("BTC-USD")
+	if len(trades) != 1 || trades[0].Price != 100.75 || trades[0].Quantity != 100 || trades[0].AggressorSide != SideSell {
+		t.Errorf("Expected the exchange's trade sold into the bid, got %+v", trades)
+	}
+
+	order := `{"symbol": "BTC-USD", "side": "buy", "price": 101.0, "quantity": 1}`
+	response := serve(HTTPConfig{}, httptest.NewRequest("POST", "/api/v1/orders", strings.NewReader(order)))
+	if response.Code != http.StatusUnprocessableEntity || !strings.Contains(response.Body.String(), string(ErrCodeShadowSymbol)) {
+		t.Errorf("Expected orders refused on a shadow symbol, got %d: %s", response.Code, response.Body.String())
+	}
+
+	response = serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/shadow", nil))
+	var feeds ShadowResponse
+	json.NewDecoder(response.Body).Decode(&feeds)
+	if len(feeds.Feeds) != 1 || feeds.Feeds[0].Exchange != "binance" || feeds.Feeds[0].Product != "BTCUSDT" {
+		t.Errorf("Expected the Binance feed listed, got %+v", feeds)
+	}
+}
+
+func TestShadow_MirrorsCoinbase(t *testing.T) {
+	setupTest()
+	resetSymbols([]string{"ETH-USD", "BTC-USD"})
+	exchange, _, subscriptions := fakeExchange(t, true,
+		`{"type": "subscriptions", "channels": []}`,
+		`{"type": "snapshot", "product_id": "ETH-USD", "bids": [["2000.00", "1.0"], ["1999.50", "2.0"]], "asks": [["2001.00", "0.5"]]}`,
+		`{"type": "l2update", "product_id": "ETH-USD", "changes": [["buy", "2000.00", "0"], ["sell", "2001.00", "0.75"], ["sell", "2002.00", "1"]]}`,
+		`{"type": "match", "product_id": "ETH-USD", "price": "2001.00", "size": "0.25", "side": "sell"}`)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	startShadows(ctx, ShadowConfig{
+		Feeds: map[string]ShadowFeed{"ETH-USD": {Exchange: "coinbase", Product: "ETH-USD"}},
+		Lot:   0.01,
+		URLs:  map[string]string{"coinbase": "ws" + strings.TrimPrefix(exchange.URL, "http")},
+	})
+
+	if subscription := <-subscriptions; subscription["type"] != "subscribe" || subscription["product_ids"].([]any)[0] != "ETH-USD" {
+		t.Errorf("Expected a subscription to ETH-USD, got %+v", subscription)
+	}
+	waitForShadowTrades(t, "ETH-USD", 1)
+
+	m, _ := matcherFor("ETH-USD")
+	snapshot := m.depthSnapshot(0)
+	if len(snapshot.Bids) != 1 || snapshot.Bids[0].Price != 1999.5 || snapshot.Bids[0].Quantity != 200 ||
+		len(snapshot.Asks) != 2 || snapshot.Asks[0].Quantity != 75 || snapshot.Asks[1].Price != 2002.0 {
+		t.Fatalf("Expected the snapshot with the updates applied, got %+v", snapshot)
+	}
+	if trades := tradeStore.List("ETH-USD"); len(trades) != 1 || trades[0].AggressorSide != SideBuy || trades[0].Quantity != 25 {
+		t.Errorf("Expected a buy lifting the sell maker, got %+v", trades)
+	}
+	btc, _ := matcherFor("BTC-USD")
+	if placed := processOn(btc, Order{ID: "real", Symbol: "BTC-USD", Side: SideBuy, Price: 100.0, Quantity: 1}); placed.Status != OrderStatusPending {
+		t.Errorf("Expected other symbols to keep taking orders, got %+v", placed)
+	}
+}
+
+func TestShadow_ReconnectsAfterAnError(t *testing.T) {
+	setupTest()
+	resetSymbols([]string{"ETH-USD"})
+	shadowRetryDelay = 10 * time.Millisecond
+	t.Cleanup(func() { shadowRetryDelay = time.Second })
+	exchange, requests, _ := fakeExchange(t, false, `{"type": "error", "message": "Failed to subscribe"}`)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	startShadows(ctx, ShadowConfig{
+		Feeds: map[string]ShadowFeed{"ETH-USD": {Exchange: "coinbase", Product: "ETH-USD"}},
+		Lot:   0.01,
+		URLs:  map[string]string{"coinbase": "ws" + strings.TrimPrefix(exchange.URL, "http")},
+	})
+
+	<-requests
+	<-requests
+	if status := shadowStatuses()[0]; status.LastError != "coinbase: Failed to subscribe" {
+		t.Errorf("Expected the exchange's error kept, got %+v", status)
+	}
+}
+
+func TestLoadConfig_Shadow(t *testing.T) {
+	cfg, err := loadConfig([]string{"-symbols", "BTC-USD,ETH-USD", "-shadow", "btc-usd=binance:btcusdt, ETH-USD=Coinbase:ETH-USD", "-shadow-lot", "0.001"})
+	if err != nil || cfg.Shadow.Feeds["BTC-USD"] != (ShadowFeed{Exchange: "binance", Product: "btcusdt"}) ||
+		cfg.Shadow.Feeds["ETH-USD"].Exchange != "coinbase" || cfg.Shadow.Lot != 0.001 || cfg.Shadow.URLs["binance"] != defaultShadowURLs["binance"] {
+		t.Fatalf("Unexpected shadow config %+v (%v)", cfg.Shadow, err)
+	}
+	for _, args := range [][]string{
+		{"-shadow", "BTC-USD=binance"},
+		{"-shadow", "BTC-USD=kraken:XBTUSD"},
+		{"-shadow", "=binance:btcusdt"},
+		{"-shadow", "SOL-USD=binance:solusdt"},
+		{"-shadow-lot", "0"},
+	} {
+		if _, err := loadConfig(append([]string{"-symbols", "BTC-USD"}, args...)); err == nil {
+			t.Errorf("Expected an error for %v", args)
+		}
+	}
+}
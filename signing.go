@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Headers a signed request carries
+const (
+	signatureTimestampHeader = "X-Signature-Timestamp"
+	signatureNonceHeader     = "X-Signature-Nonce"
+	signatureHeader          = "X-Signature"
+)
+
+// signaturePayload is what a request's HMAC covers: its timestamp, nonce,
+// method, path with query and body, each on its own line, so a signature
+// cannot be moved to another endpoint or body
+func signaturePayload(timestamp, nonce, method, uri string, body []byte) []byte {
+	payload := []byte(timestamp + "\n" + nonce + "\n" + method + "\n" + uri + "\n")
+	return append(payload, body...)
+}
+
+// requestSignature is the hex HMAC-SHA256 of a payload under secret
+func requestSignature(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// nonceCache remembers the nonces seen inside the replay window. Nonces are
+// kept in the order they arrive, which is the order they expire in.
+type nonceCache struct {
+	mu    sync.Mutex
+	seen  map[string]bool
+	queue []seenNonce
+}
+
+// seenNonce is a nonce's key and when it may be forgotten
+type seenNonce struct {
+	id      string
+	expires time.Time
+}
+
+func newNonceCache() *nonceCache {
+	return &nonceCache{seen: make(map[string]bool)}
+}
+
+// claim records an API key's nonce, reporting false if it was already used.
+// A nonce is kept for twice the window, which outlasts any timestamp the
+// window would still accept with it.
+func (c *nonceCache) claim(key, nonce string, now time.Time, window time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for len(c.queue) > 0 && now.After(c.queue[0].expires) {
+		delete(c.seen, c.queue[0].id)
+		c.queue = c.queue[1:]
+	}
+	id := key + "\n" + nonce
+	if c.seen[id] {
+		return false
+	}
+	c.seen[id] = true
+	c.queue = append(c.queue, seenNonce{id: id, expires: now.Add(2 * window)})
+	return true
+}
+
+// requireSignature refuses requests from API keys with a secret unless they
+// are signed with it, within the window of the server's wall clock and with
+// a nonce not used before. Keys without a secret, and tokens, are let through.
+func requireSignature(cfg HTTPConfig, nonces *nonceCache) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := requestAPIKey(r)
+			secret, ok := cfg.APISecrets[key]
+			if !ok || key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			timestamp, nonce, signature := r.Header.Get(signatureTimestampHeader), r.Header.Get(signatureNonceHeader), r.Header.Get(signatureHeader)
+			if timestamp == "" || nonce == "" || signature == "" {
+				writeError(w, http.StatusUnauthorized, ErrCodeInvalidSignature, "Invalid signature",
+					"requests from this API key must be signed with the X-Signature-Timestamp, X-Signature-Nonce and X-Signature headers")
+				return
+			}
+			millis, err := strconv.ParseInt(timestamp, 10, 64)
+			if err != nil {
+				writeError(w, http.StatusUnauthorized, ErrCodeInvalidSignature, "Invalid signature",
+					"X-Signature-Timestamp must be Unix milliseconds")
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, ErrCodeValidationFailed, "Validation failed",
+					[]string{"request body could not be read"})
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			want := requestSignature(secret, signaturePayload(timestamp, nonce, r.Method, r.URL.RequestURI(), body))
+			if !hmac.Equal([]byte(signature), []byte(want)) {
+				writeError(w, http.StatusUnauthorized, ErrCodeInvalidSignature, "Invalid signature",
+					"the signature does not match the request")
+				return
+			}
+
+			// The signature is checked first, so only the key's holder can
+			// spend its nonces. Clients sign with their wall clock, so the
+			// window is kept on it even when the engine runs on a virtual one.
+			now := time.Now()
+			if skew := now.Sub(time.UnixMilli(millis)).Abs(); skew > cfg.SignatureWindow {
+				writeError(w, http.StatusUnauthorized, ErrCodeReplayedRequest, "Replayed request",
+					fmt.Sprintf("the request was signed %s from the server's clock, outside the %s window", skew.Round(time.Millisecond), cfg.SignatureWindow))
+				return
+			}
+			if !nonces.claim(key, nonce, now, cfg.SignatureWindow) {
+				writeError(w, http.StatusUnauthorized, ErrCodeReplayedRequest, "Replayed request",
+					"the nonce "+nonce+" was already used")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signingConfig requires desk to sign its trading requests and lets reader,
+// which has no secret, through unsigned
+var signingConfig = HTTPConfig{
+	APIKeys:         []string{"desk", "reader"},
+	APISecrets:      map[string]string{"desk": "s3cret"},
+	SignatureWindow: 5 * time.Second,
+}
+
+// signedRequest builds a request from desk signed now with nonce
+func signedRequest(method, path, body, nonce string) *http.Request {
+	return signedRequestAt(method, path, body, nonce, time.Now())
+}
+
+// signedRequestAt builds a request from desk signed at the wall clock time at
+// with nonce
+func signedRequestAt(method, path, body, nonce string, at time.Time) *http.Request {
+	timestamp := strconv.FormatInt(at.UnixMilli(), 10)
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	request.Header.Set("X-API-Key", "desk")
+	request.Header.Set("X-Signature-Timestamp", timestamp)
+	request.Header.Set("X-Signature-Nonce", nonce)
+	request.Header.Set("X-Signature", requestSignature("s3cret", signaturePayload(timestamp, nonce, method, request.URL.RequestURI(), []byte(body))))
+	return request
+}
+
+func TestSignature_RequiredOnTradingRequests(t *testing.T) {
+	setupTest()
+	useDeterministicEngine(t, time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC))
+	order := `{"side":"buy","price":100,"quantity":1}`
+
+	if response := serve(signingConfig, signedRequest("POST", "/api/v1/orders", order, "n-1")); response.Code != http.StatusOK {
+		t.Fatalf("Expected the signed order placed, got %d %s", response.Code, response.Body)
+	}
+	if response := serve(signingConfig, signedRequest("POST", "/api/v1/orders/cancel-batch", `{"owner":"alice"}`, "n-2")); response.Code != http.StatusOK {
+		t.Errorf("Expected the signed cancel accepted, got %d %s", response.Code, response.Body)
+	}
+
+	unsigned := httptest.NewRequest("POST", "/api/v1/orders", strings.NewReader(order))
+	unsigned.Header.Set("X-API-Key", "desk")
+	moved := signedRequest("POST", "/api/v1/orders", order, "n-3")
+	moved.URL.Path = "/api/v1/orders/cancel-batch"
+	altered := signedRequest("POST", "/api/v1/orders", order, "n-4")
+	altered.Body = http.NoBody
+	for name, request := range map[string]*http.Request{"unsigned": unsigned, "other endpoint": moved, "other body": altered} {
+		if result := decodeError(t, serve(signingConfig, request)); result.Error.Code != ErrCodeInvalidSignature {
+			t.Errorf("%s: expected INVALID_SIGNATURE, got %+v", name, result.Error)
+		}
+	}
+
+	// Reads, and keys without a secret, need no signature
+	if response := callAs(signingConfig, "desk", "GET", "/api/v1/orders", ""); response.Code != http.StatusOK {
+		t.Errorf("Expected an unsigned read accepted, got %d", response.Code)
+	}
+	if response := callAs(signingConfig, "reader", "POST", "/api/v1/orders", order); response.Code != http.StatusOK {
+		t.Errorf("Expected a key without a secret let through, got %d %s", response.Code, response.Body)
+	}
+}
+
+func TestSignature_ReplayWindow(t *testing.T) {
+	setupTest()
+	clock := useDeterministicEngine(t, time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC))
+	server := newServer(signingConfig)
+	send := func(request *http.Request) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+		return response
+	}
+	cancel := `{"owner":"alice"}`
+
+	if response := send(signedRequest("POST", "/api/v1/orders/cancel-batch", cancel, "once")); response.Code != http.StatusOK {
+		t.Fatalf("Expected the first request accepted, got %d %s", response.Code, response.Body)
+	}
+	result := decodeError(t, send(signedRequest("POST", "/api/v1/orders/cancel-batch", cancel, "once")))
+	if result.Error.Code != ErrCodeReplayedRequest || result.Error.Details != "the nonce once was already used" {
+		t.Errorf("Expected the reused nonce refused, got %+v", result.Error)
+	}
+
+	stale := signedRequestAt("POST", "/api/v1/orders/cancel-batch", cancel, "late", time.Now().Add(-time.Minute))
+	result = decodeError(t, send(stale))
+	details, _ := result.Error.Details.(string)
+	if result.Error.Code != ErrCodeReplayedRequest || !strings.HasPrefix(details, "the request was signed 1m0") || !strings.HasSuffix(details, "from the server's clock, outside the 5s window") {
+		t.Errorf("Expected the stale request refused, got %+v", result.Error)
+	}
+
+	// Nonces are forgotten once no timestamp inside the window could use them
+	nonces := newNonceCache()
+	start := clock.Now()
+	if !nonces.claim("desk", "n", start, time.Second) || nonces.claim("desk", "n", start.Add(time.Second), time.Second) {
+		t.Error("Expected a nonce claimed once inside the window")
+	}
+	if !nonces.claim("other", "n", start, time.Second) {
+		t.Error("Expected nonces kept per API key")
+	}
+	if !nonces.claim("desk", "n", start.Add(3*time.Second), time.Second) || len(nonces.seen) != 1 {
+		t.Errorf("Expected expired nonces dropped, got %v", nonces.seen)
+	}
+}
+
+func TestLoadConfig_APISecrets(t *testing.T) {
+	cfg, err := loadConfig([]string{"-api-secrets", "desk=c2Vj==, algo=x", "-signature-window", "2s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"desk": "c2Vj==", "algo": "x"}
+	if !reflect.DeepEqual(cfg.HTTP.APISecrets, want) || cfg.HTTP.SignatureWindow != 2*time.Second {
+		t.Errorf("Expected %v within 2s, got %v within %s", want, cfg.HTTP.APISecrets, cfg.HTTP.SignatureWindow)
+	}
+	for _, args := range [][]string{{"-api-secrets", "desk"}, {"-api-secrets", "desk="}, {"-signature-window", "0s"}} {
+		if _, err := loadConfig(args); err == nil {
+			t.Errorf("Expected %v refused", args)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// simulatedClock is the engine's virtual clock when the server simulates; nil
+// when it runs on the wall clock. The loops that pay funding, run batch
+// auctions, settle options and move trading phases on wall time are left
+// to it then.
+var simulatedClock *SimulatedClock
+
+// simulationMu keeps one drive of the engine at a time, so a step cannot
+// interleave with the running clock's ticks
+var simulationMu sync.Mutex
+
+// simulationTickInterval is how often a running virtual clock drives the
+// engine
+const simulationTickInterval = 100 * time.Millisecond
+
+// SimulationClock is where the virtual clock stands
+type SimulationClock struct {
+	Now    time.Time `json:"now"`
+	Paused bool      `json:"paused"`
+	Rate   float64   `json:"rate"`
+	// Stops is how many due instants a step or fast-forward stopped at on the
+	// way, to drive the engine at each
+	Stops int `json:"stops,omitempty"`
+}
+
+// StepClockRequest moves the virtual clock forward by a duration
+type StepClockRequest struct {
+	Duration string `json:"duration" validate:"required,duration"`
+}
+
+// FastForwardRequest moves the virtual clock forward to an instant
+type FastForwardRequest struct {
+	To time.Time `json:"to" validate:"required"`
+}
+
+// startSimulation runs the engine on a virtual clock paused at start, and
+// drives the engine from it whenever it runs, until ctx is done
+func startSimulation(ctx context.Context, start time.Time) {
+	simulatedClock = NewSimulatedClock(start)
+	engineClock = simulatedClock
+	log.Printf("simulation: virtual clock paused at %s", start.Format(time.RFC3339Nano))
+
+	go func() {
+		ticker := time.NewTicker(simulationTickInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, paused, _ := simulatedClock.State(); !paused {
+					simulationMu.Lock()
+					driveSimulation()
+					simulationMu.Unlock()
+				}
+			}
+		}
+	}()
+}
+
+// driveSimulation does what the virtual clock has made due on every symbol:
+// it expires orders, moves trading phases, runs batch auctions, pays funding
+// and settles expired options. A standby leaves this to its primary.
+func driveSimulation() {
+	if standby.running.Load() {
+		return
+	}
+	now := engineClock.Now()
+	for _, m := range allMatchers() {
+		m.do(func() {
+			expireOrders(m.book, now)
+			advancePhase(m.book, m.symbol, now)
+			if auction := m.book.auction; auction != nil && !m.book.phaseAuction && !now.Before(auction.nextAt) && m.book.phase() == PhaseContinuous {
+				runBatchAuction(m.book)
+			}
+			if fundingDue(m.symbol, now) {
+				payFunding(m.symbol, m.book)
+			}
+		})
+	}
+
+	accountsMu.Lock()
+	var expired []OptionContract
+	for _, contract := range options {
+		if contract.Status != OptionSettled && !now.Before(contract.Expiry) {
+			expired = append(expired, *contract)
+		}
+	}
+	accountsMu.Unlock()
+	for _, contract := range expired {
+		m, ok := matcherFor(contract.Symbol)
+		underlying, underlyingOK := matcherFor(contract.Underlying)
+		if !ok || !underlyingOK {
+			continue
+		}
+		var mark float64
+		underlying.do(func() { mark, _ = markPrice(underlying.book) })
+		m.do(func() { settleOption(m, mark) })
+	}
+}
+
+// fundingDue reports whether a perpetual's next funding time has come
+func fundingDue(symbol string, now time.Time) bool {
+	accountsMu.Lock()
+	defer accountsMu.Unlock()
+	contract, ok := perpetuals[symbol]
+	return ok && !now.Before(contract.nextAt)
+}
+
+// nextDue returns the earliest instant after now at which the engine has
+// something to do, or the zero time if it has nothing
+func nextDue(now time.Time) time.Time {
+	var next time.Time
+	consider := func(at time.Time) {
+		if at.After(now) && (next.IsZero() || at.Before(next)) {
+			next = at
+		}
+	}
+
+	accountsMu.Lock()
+	for _, contract := range perpetuals {
+		consider(contract.nextAt)
+	}
+	for _, contract := range options {
+		if contract.Status == OptionActive {
+			consider(contract.Expiry)
+		}
+	}
+	accountsMu.Unlock()
+
+	for _, m := range allMatchers() {
+		m.do(func() {
+			consider(m.book.nextExpiry)
+			if m.book.auction != nil && !m.book.phaseAuction {
+				consider(m.book.auction.nextAt)
+			}
+			consider(m.book.volatility.resumeAt)
+			consider(m.book.uncross.at)
+		})
+		if calendar := calendarFor(m.symbol); calendar != nil {
+			_, change := calendar.at(now)
+			consider(change.At)
+		}
+	}
+	return next
+}
+
+// advanceSimulation moves the virtual clock forward to to, stopping at each
+// instant something falls due on the way and driving the engine there, so
+// nothing that would have happened in between is skipped. It returns how many
+// times it stopped.
+func advanceSimulation(to time.Time) int {
+	simulationMu.Lock()
+	defer simulationMu.Unlock()
+
+	stops := 0
+	driveSimulation()
+	for {
+		next := nextDue(simulatedClock.Now())
+		if next.IsZero() || next.After(to) {
+			break
+		}
+		simulatedClock.MoveTo(next)
+		driveSimulation()
+		stops++
+	}
+	simulatedClock.MoveTo(to)
+	driveSimulation()
+	return stops
+}
+
+// simulationState reports the virtual clock
+func simulationState(stops int) SimulationClock {
+	now, paused, rate := simulatedClock.State()
+	return SimulationClock{Now: now, Paused: paused, Rate: rate, Stops: stops}
+}
+
+// requireSimulation refuses clock control on a server that is not simulating
+func requireSimulation(w http.ResponseWriter) bool {
+	if simulatedClock == nil {
+		writeError(w, http.StatusConflict, ErrCodeNotSimulating, "Server is not simulating",
+			"start the server with -sim-clock to control its clock")
+		return false
+	}
+	return true
+}
+
+// getClockHandler reports the virtual clock
+func getClockHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !requireSimulation(w) {
+		return
+	}
+	writeBody(w, r, simulationState(0))
+}
+
+// pauseClockHandler stops the virtual clock
+func pauseClockHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !requireSimulation(w) {
+		return
+	}
+	simulatedClock.Pause()
+	writeBody(w, r, simulationState(0))
+}
+
+// resumeClockHandler runs the virtual clock again, at the rate given in the
+// query if there is one
+func resumeClockHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !requireSimulation(w) {
+		return
+	}
+	var rate float64
+	if raw := r.URL.Query().Get("rate"); raw != "" {
+		parsed, err := strconv.ParseFloat(raw, 64)
+		if err != nil || parsed <= 0 {
+			writeError(w, http.StatusBadRequest, ErrCodeValidationFailed, "Validation failed", []FieldError{
+				fieldError("rate", "gt", "rate must be a number greater than 0 (received: '%s')", raw)})
+			return
+		}
+		rate = parsed
+	}
+	simulatedClock.Resume(rate)
+	writeBody(w, r, simulationState(0))
+}
+
+// stepClockHandler moves the virtual clock forward by a duration
+func stepClockHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !requireSimulation(w) {
+		return
+	}
+	var req StepClockRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	duration, _ := time.ParseDuration(req.Duration)
+	stops := advanceSimulation(simulatedClock.Now().Add(duration))
+	writeBody(w, r, simulationState(stops))
+}
+
+// fastForwardClockHandler moves the virtual clock forward to an instant
+func fastForwardClockHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !requireSimulation(w) {
+		return
+	}
+	var req FastForwardRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if now := simulatedClock.Now(); !req.To.After(now) {
+		writeError(w, http.StatusBadRequest, ErrCodeValidationFailed, "Validation failed", []FieldError{
+			fieldError("to", "min", "to must be after the virtual clock's %s (received: %s)", now.Format(time.RFC3339Nano), req.To.Format(time.RFC3339Nano))})
+		return
+	}
+	stops := advanceSimulation(req.To)
+	writeBody(w, r, simulationState(stops))
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// simulate runs the engine on a virtual clock paused at start for the test
+func simulate(t *testing.T, start time.Time) *SimulatedClock {
+	t.Helper()
+	clock := NewSimulatedClock(start)
+	previous := engineClock
+	engineClock, simulatedClock = clock, clock
+	t.Cleanup(func() {
+		engineClock, simulatedClock = previous, nil
+	})
+	return clock
+}
+
+// moveClock posts to one of the clock endpoints and decodes where it stands
+func moveClock(t *testing.T, path, body string) SimulationClock {
+	t.Helper()
+	response := serve(HTTPConfig{}, httptest.NewRequest("POST", "/api/v1/admin/clock/"+path, strings.NewReader(body)))
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected %s to move the clock, got %d %s", path, response.Code, response.Body)
+	}
+	var clock SimulationClock
+	json.NewDecoder(response.Body).Decode(&clock)
+	return clock
+}
+
+func TestSimulation_StepRunsEachBatchAuctionOnTheWay(t *testing.T) {
+	setupTest()
+	start := time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC)
+	simulate(t, start)
+	m := batchMatcher(t)
+
+	placeOn(m, Order{ID: "ask-1", Symbol: "DEFAULT", Side: SideSell, Price: 100.0, Quantity: 2})
+	placeOn(m, Order{ID: "bid-1", Symbol: "DEFAULT", Side: SideBuy, Price: 100.0, Quantity: 2})
+
+	// Paused, the clock and the auction wait for the test
+	if trades := tradeStore.List(""); len(trades) != 0 {
+		t.Fatalf("Expected nothing traded before the first auction, got %+v", trades)
+	}
+	clock := moveClock(t, "step", `{"duration":"2500ms"}`)
+	if !clock.Now.Equal(start.Add(2500*time.Millisecond)) || !clock.Paused || clock.Stops != 2 {
+		t.Errorf("Expected the clock paused 2.5s on after stopping at both auctions, got %+v", clock)
+	}
+	trades := tradeStore.List("")
+	if len(trades) != 1 || !trades[0].CreatedAt.Equal(start.Add(time.Second)) {
+		t.Fatalf("Expected the first auction to trade at its virtual instant, got %+v", trades)
+	}
+
+	// The next auction is due a second after the one that ran last
+	var nextAt time.Time
+	m.do(func() { nextAt = m.book.auction.nextAt })
+	if !nextAt.Equal(start.Add(3 * time.Second)) {
+		t.Errorf("Expected the next auction at +3s, got %s", nextAt)
+	}
+}
+
+func TestSimulation_FastForwardPaysFundingAndExpiresOptions(t *testing.T) {
+	start := time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC)
+	setupSymbols("BTC-PERP", "BTC-USD", "BTC-70000-C")
+	simulate(t, start)
+	listPerpetual("BTC-PERP")
+	contract := listOption("BTC-70000-C", OptionSpec{Underlying: "BTC-USD", Type: OptionCall, Strike: 70000, Expiry: start.Add(90 * time.Minute)})
+
+	clock := moveClock(t, "fast-forward", `{"to":"2024-01-02T12:05:00Z"}`)
+	if !clock.Now.Equal(start.Add(3*time.Hour+5*time.Minute)) || clock.Stops != 4 {
+		t.Errorf("Expected stops at three funding times and the expiry, got %+v", clock)
+	}
+
+	accountsMu.Lock()
+	nextAt, status := perpetuals["BTC-PERP"].nextAt, contract.Status
+	accountsMu.Unlock()
+	if !nextAt.Equal(start.Add(4 * time.Hour)) {
+		t.Errorf("Expected funding next due at 13:00, got %s", nextAt)
+	}
+	// Without a mark on the underlying the option expires but cannot settle
+	if status != OptionExpired {
+		t.Errorf("Expected the option expired, got %s", status)
+	}
+}
+
+func TestSimulation_SignedRequestsKeepToTheWallClock(t *testing.T) {
+	setupTest()
+	simulate(t, time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC))
+
+	// The clients sign with their own clock, years from the paused virtual one
+	order := `{"side":"buy","price":100,"quantity":1}`
+	if response := serve(signingConfig, signedRequest("POST", "/api/v1/orders", order, "sim-1")); response.Code != http.StatusOK {
+		t.Fatalf("Expected the signed order placed on a paused virtual clock, got %d %s", response.Code, response.Body)
+	}
+	stale := signedRequestAt("POST", "/api/v1/orders", order, "sim-2", engineClock.Now())
+	if result := decodeError(t, serve(signingConfig, stale)); result.Error.Code != ErrCodeReplayedRequest {
+		t.Errorf("Expected a request signed at the virtual time refused, got %+v", result.Error)
+	}
+}
+
+func TestSimulation_ClockControl(t *testing.T) {
+	setupTest()
+	response := serve(HTTPConfig{}, httptest.NewRequest("POST", "/api/v1/admin/clock/pause", nil))
+	if result := decodeError(t, response); response.Code != http.StatusConflict || result.Error.Code != ErrCodeNotSimulating {
+		t.Errorf("Expected 409 NOT_SIMULATING on the wall clock, got %d %+v", response.Code, result.Error)
+	}
+
+	start := time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC)
+	simulate(t, start)
+	if clock := moveClock(t, "resume?rate=60", ""); clock.Paused || clock.Rate != 60 {
+		t.Errorf("Expected the clock running at 60x, got %+v", clock)
+	}
+	if clock := moveClock(t, "pause", ""); !clock.Paused || clock.Rate != 60 || clock.Now.Before(start) {
+		t.Errorf("Expected the clock paused, got %+v", clock)
+	}
+
+	for _, tc := range []struct{ path, body string }{
+		{"resume?rate=0", ""},
+		{"step", `{"duration":"-1m"}`},
+		{"step", `{}`},
+		{"fast-forward", `{"to":"2024-01-01T00:00:00Z"}`},
+	} {
+		response := serve(HTTPConfig{}, httptest.NewRequest("POST", "/api/v1/admin/clock/"+tc.path, strings.NewReader(tc.body)))
+		if result := decodeError(t, response); response.Code != http.StatusBadRequest || result.Error.Code != ErrCodeValidationFailed {
+			t.Errorf("%s %s: expected 400 VALIDATION_FAILED, got %d %+v", tc.path, tc.body, response.Code, result.Error)
+		}
+	}
+
+	cfg, err := loadConfig([]string{"-sim-clock", "2024-01-02T09:00:00Z"})
+	if err != nil || !cfg.SimulationStart.Equal(start) {
+		t.Errorf("Unexpected -sim-clock config %s (%v)", cfg.SimulationStart, err)
+	}
+	for _, args := range [][]string{
+		{"-sim-clock", "09:00"},
+		{"-sim-clock", "2024-01-02T09:00:00Z", "-replicate-from", "http://primary:8080"},
+	} {
+		if _, err := loadConfig(args); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
@@ -0,0 +1,520 @@
+package main
+
+import (
+	"slices"
+	"sort"
+)
+
+// noSlot ends a level's queue of slots
+const noSlot = ^uint32(0)
+
+// orderHandle names a slot in a bookSide: the slot's index in the low 32
+// bits and its generation in the high 32, so a handle kept past the slot
+// being freed and reused no longer matches it
+type orderHandle uint64
+
+// orderSlot holds one resting order and links it to the orders either side
+// of it in its level's queue
+type orderSlot struct {
+	order      Order
+	level      *restingLevel
+	prev, next uint32
+	generation uint32
+}
+
+// restingLevel is the queue of orders resting at one price, first in
+// priority at the head
+type restingLevel struct {
+	price      float64
+	head, tail uint32
+	orders     int
+}
+
+// bookSide holds the orders resting on one side of a book. The orders live
+// in the slots of one slice, reused through a free list, so churn does not
+// allocate per order and a side never grows past the most orders that have
+// rested on it at once. Each price level queues its slots in a linked list,
+// so taking an order off unlinks it without moving any other. On a symbol
+// with a tick ladder the levels on its grid are held by tick, so finding,
+// adding or emptying one takes no search; other levels are kept sorted, and
+// one emptying shifts those behind it. Everything that reads the side in
+// priority order does so through orders, which is built again only after
+// the side changes.
+type bookSide struct {
+	side    Side
+	slots   []orderSlot
+	free    []uint32
+	handles map[string]orderHandle
+	// levels are the prices with orders resting off the grid, best first,
+	// and spare the levels that emptied, for reuse
+	levels []*restingLevel
+	spare  []*restingLevel
+	// ticks holds the levels on the grid by tick, nil without a ladder, and
+	// bestTick is the best of the onTicks occupied
+	grid     LadderConfig
+	ticks    []*restingLevel
+	bestTick int
+	onTicks  int
+	count    int
+	// view is the side in priority order as of its last change, once built,
+	// and top the orders of its best levels, read by the last call to head
+	view  []Order
+	built bool
+	top   []Order
+}
+
+// newBookSide returns an empty side with room for capacity orders
+func newBookSide(side Side, capacity int) bookSide {
+	return bookSide{
+		side:    side,
+		slots:   make([]orderSlot, 0, capacity),
+		handles: make(map[string]orderHandle, capacity),
+	}
+}
+
+// len returns how many orders rest on the side
+func (s *bookSide) len() int {
+	return s.count
+}
+
+// handle returns the handle naming the slot at index
+func (s *bookSide) handle(index uint32) orderHandle {
+	return orderHandle(uint64(s.slots[index].generation)<<32 | uint64(index))
+}
+
+// slot returns the slot a handle names, if it still does
+func (s *bookSide) slot(handle orderHandle) (*orderSlot, bool) {
+	index := uint32(handle)
+	if int(index) >= len(s.slots) || s.slots[index].generation != uint32(handle>>32) {
+		return nil, false
+	}
+	return &s.slots[index], true
+}
+
+// find returns the handle of the order resting on the side with an ID
+func (s *bookSide) find(orderID string) (orderHandle, bool) {
+	handle, ok := s.handles[orderID]
+	return handle, ok
+}
+
+// use returns the slot a handle names. A handle kept past its slot being
+// freed is a bug, so it panics rather than reach whatever order took the
+// slot over.
+func (s *bookSide) use(handle orderHandle) *orderSlot {
+	slot, ok := s.slot(handle)
+	if !ok {
+		panic("bookSide: stale order handle")
+	}
+	return slot
+}
+
+// at returns the order a handle names
+func (s *bookSide) at(handle orderHandle) Order {
+	return s.use(handle).order
+}
+
+// edit returns the order a handle names to be changed in place. Its price
+// and priority must stay as they are.
+func (s *bookSide) edit(handle orderHandle) *Order {
+	slot := s.use(handle)
+	s.built = false
+	return &slot.order
+}
+
+// best returns the handle of the order first in priority
+func (s *bookSide) best() (orderHandle, bool) {
+	var best *restingLevel
+	s.eachLevel(func(level *restingLevel) bool {
+		best = level
+		return false
+	})
+	if best == nil {
+		return 0, false
+	}
+	return s.handle(best.head), true
+}
+
+// first returns the order first in priority
+func (s *bookSide) first() (Order, bool) {
+	handle, ok := s.best()
+	if !ok {
+		return Order{}, false
+	}
+	return s.at(handle), true
+}
+
+// orders returns the side's orders in priority order. The slice is never
+// written to once returned, so it may be kept after the side changes.
+func (s *bookSide) orders() []Order {
+	if !s.built {
+		view := make([]Order, 0, s.count)
+		s.eachLevel(func(level *restingLevel) bool {
+			for i := level.head; i != noSlot; i = s.slots[i].next {
+				view = append(view, s.slots[i].order)
+			}
+			return true
+		})
+		s.view, s.built = view, true
+	}
+	return s.view
+}
+
+// head returns the orders of the side's best levels in priority order, or
+// more. The slice is only good until the next call, but reading it does not
+// build the whole side.
+func (s *bookSide) head(levels int) []Order {
+	if s.built || levels >= len(s.levels)+s.onTicks {
+		return s.orders()
+	}
+	s.top = s.top[:0]
+	s.eachLevel(func(level *restingLevel) bool {
+		for i := level.head; i != noSlot; i = s.slots[i].next {
+			s.top = append(s.top, s.slots[i].order)
+		}
+		levels--
+		return levels > 0
+	})
+	return s.top
+}
+
+// each visits the side's orders in priority order until visit returns false
+func (s *bookSide) each(visit func(order *Order) bool) {
+	s.eachLevel(func(level *restingLevel) bool {
+		for i := level.head; i != noSlot; i = s.slots[i].next {
+			if !visit(&s.slots[i].order) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// eachLevel visits the side's levels best first, merging those on the grid
+// with the sorted ones, until visit returns false. visit may empty the level
+// it is given but no other.
+func (s *bookSide) eachLevel(visit func(level *restingLevel) bool) {
+	tick, l := -1, 0
+	if s.onTicks > 0 {
+		tick = s.bestTick
+	}
+	for {
+		var level *restingLevel
+		onGrid := tick >= 0 && (l >= len(s.levels) || !s.better(s.levels[l].price, s.ticks[tick].price))
+		switch {
+		case onGrid:
+			level = s.ticks[tick]
+		case l < len(s.levels):
+			level = s.levels[l]
+		default:
+			return
+		}
+		if !visit(level) {
+			return
+		}
+		if onGrid {
+			tick = s.nextTick(tick)
+		} else if l < len(s.levels) && s.levels[l] == level {
+			// A level that emptied has gone, leaving the next one at l
+			l++
+		}
+	}
+}
+
+// better reports whether price a ranks ahead of price b on the side
+func (s *bookSide) better(a, b float64) bool {
+	if s.side == SideBuy {
+		return a > b
+	}
+	return a < b
+}
+
+// nextTick returns the first occupied tick worse than i, or -1
+func (s *bookSide) nextTick(i int) int {
+	step := 1
+	if s.side == SideBuy {
+		step = -1
+	}
+	for i += step; i >= 0 && i < len(s.ticks); i += step {
+		if s.ticks[i] != nil {
+			return i
+		}
+	}
+	return -1
+}
+
+// useGrid holds the side's levels on config's grid, or all of them sorted
+// when config is zero, keeping its orders as they are
+func (s *bookSide) useGrid(config LadderConfig) {
+	orders := s.orders()
+	s.grid, s.ticks = config, nil
+	if config.TickSize > 0 {
+		s.ticks = make([]*restingLevel, config.ticks())
+	}
+	s.reset(orders)
+}
+
+// lookup returns price's level, or nil when no order rests at price
+func (s *bookSide) lookup(price float64) *restingLevel {
+	if i, ok := s.grid.tick(price); ok && s.ticks[i] != nil && s.ticks[i].price == price {
+		return s.ticks[i]
+	}
+	if l, ok := s.search(price); ok {
+		return s.levels[l]
+	}
+	return nil
+}
+
+// level copies the orders resting at price, first in priority first
+func (s *bookSide) level(price float64) []Order {
+	orders := []Order{}
+	if level := s.lookup(price); level != nil {
+		for i := level.head; i != noSlot; i = s.slots[i].next {
+			orders = append(orders, s.slots[i].order)
+		}
+	}
+	return orders
+}
+
+// search returns where price's level is, or would go, among the side's
+// sorted levels
+func (s *bookSide) search(price float64) (int, bool) {
+	l := sort.Search(len(s.levels), func(i int) bool {
+		if s.side == SideBuy {
+			return s.levels[i].price <= price
+		}
+		return s.levels[i].price >= price
+	})
+	return l, l < len(s.levels) && s.levels[l].price == price
+}
+
+// levelAt returns price's level, adding it in place if it has no orders yet.
+// A price on the grid goes on its tick unless another price rounding onto
+// the same tick holds it, which leaves it sorted.
+func (s *bookSide) levelAt(price float64) *restingLevel {
+	if level := s.lookup(price); level != nil {
+		return level
+	}
+	var level *restingLevel
+	if n := len(s.spare); n > 0 {
+		level, s.spare = s.spare[n-1], s.spare[:n-1]
+	} else {
+		level = &restingLevel{}
+	}
+	*level = restingLevel{price: price, head: noSlot, tail: noSlot}
+	if i, ok := s.grid.tick(price); ok && s.ticks[i] == nil {
+		s.ticks[i] = level
+		if s.onTicks == 0 || s.better(price, s.ticks[s.bestTick].price) {
+			s.bestTick = i
+		}
+		s.onTicks++
+		return level
+	}
+	l, _ := s.search(price)
+	s.levels = slices.Insert(s.levels, l, level)
+	return level
+}
+
+// dropLevel takes a level that has emptied off the side, for reuse
+func (s *bookSide) dropLevel(level *restingLevel) {
+	if i, ok := s.grid.tick(level.price); ok && s.ticks[i] == level {
+		s.ticks[i] = nil
+		if s.onTicks--; i == s.bestTick && s.onTicks > 0 {
+			s.bestTick = s.nextTick(i)
+		}
+	} else {
+		l, _ := s.search(level.price)
+		s.levels = slices.Delete(s.levels, l, l+1)
+	}
+	s.spare = append(s.spare, level)
+}
+
+// alloc puts an order in a free slot, growing the slots only when none is
+// free, and indexes it by ID
+func (s *bookSide) alloc(order Order) uint32 {
+	if s.handles == nil {
+		s.handles = make(map[string]orderHandle)
+	}
+	var index uint32
+	if n := len(s.free); n > 0 {
+		index, s.free = s.free[n-1], s.free[:n-1]
+	} else {
+		index = uint32(len(s.slots))
+		s.slots = append(s.slots, orderSlot{})
+	}
+	s.slots[index].order = order
+	s.handles[order.ID] = s.handle(index)
+	s.count++
+	s.built = false
+	return index
+}
+
+// link queues the slot at index in level after the slot at after, or at
+// the head if after is noSlot
+func (s *bookSide) link(level *restingLevel, index, after uint32) {
+	slot := &s.slots[index]
+	slot.level, slot.prev = level, after
+	if after == noSlot {
+		slot.next, level.head = level.head, index
+	} else {
+		slot.next, s.slots[after].next = s.slots[after].next, index
+	}
+	if slot.next == noSlot {
+		level.tail = index
+	} else {
+		s.slots[slot.next].prev = index
+	}
+	level.orders++
+}
+
+// insert rests an order behind every order at its price that ranks ahead of
+// or level with it, so equal orders keep arrival order. It returns the
+// order's handle and its place in the level, 1 filling next. Orders mostly
+// arrive last in their level, so the search back from its tail is normally
+// no search at all.
+func (s *bookSide) insert(order Order) (orderHandle, int) {
+	index := s.alloc(order)
+	level := s.levelAt(order.Price)
+	after, position := level.tail, level.orders+1
+	for after != noSlot && comparePriority(&order, &s.slots[after].order) < 0 {
+		after = s.slots[after].prev
+		position--
+	}
+	s.link(level, index, after)
+	return s.handle(index), position
+}
+
+// push rests an order last in its level, whatever its priority
+func (s *bookSide) push(order Order) {
+	index := s.alloc(order)
+	level := s.levelAt(order.Price)
+	s.link(level, index, level.tail)
+}
+
+// remove takes the order a handle names off the side and frees its slot
+func (s *bookSide) remove(handle orderHandle) Order {
+	index := uint32(handle)
+	slot := s.use(handle)
+	level := slot.level
+	if slot.prev == noSlot {
+		level.head = slot.next
+	} else {
+		s.slots[slot.prev].next = slot.next
+	}
+	if slot.next == noSlot {
+		level.tail = slot.prev
+	} else {
+		s.slots[slot.next].prev = slot.prev
+	}
+	if level.orders--; level.orders == 0 {
+		s.dropLevel(level)
+	}
+
+	order := slot.order
+	if s.handles[order.ID] == handle {
+		delete(s.handles, order.ID)
+	}
+	*slot = orderSlot{generation: slot.generation + 1}
+	s.free = append(s.free, index)
+	s.count--
+	s.built = false
+	return order
+}
+
+// position returns the place in its level of the order a handle names, 1
+// filling next
+func (s *bookSide) position(handle orderHandle) int {
+	position := 1
+	for i := s.use(handle).prev; i != noSlot; i = s.slots[i].prev {
+		position++
+	}
+	return position
+}
+
+// sweep visits the side's orders in priority order, with each one's place
+// in its level among the orders kept so far, and takes off the side those
+// visit returns true for. visit may change only the orders it takes off, and
+// must not rest orders on the side.
+func (s *bookSide) sweep(visit func(order *Order, position int) bool) {
+	s.eachLevel(func(level *restingLevel) bool {
+		position := 1
+		for i := level.head; i != noSlot; {
+			next := s.slots[i].next
+			if visit(&s.slots[i].order, position) {
+				s.remove(s.handle(i))
+			} else {
+				position++
+			}
+			i = next
+		}
+		return true
+	})
+}
+
+// replaceLevel swaps the orders resting at price for others, which rest in
+// the order given
+func (s *bookSide) replaceLevel(price float64, orders []Order) {
+	if level := s.lookup(price); level != nil {
+		for level.orders > 0 {
+			s.remove(s.handle(level.head))
+		}
+	}
+	for _, order := range orders {
+		s.push(order)
+	}
+}
+
+// reset empties the side and rests orders on it, which need not be in
+// priority order. Every slot is freed under a new generation rather than
+// dropped, so no handle from before the reset names a slot after it.
+func (s *bookSide) reset(orders []Order) {
+	s.spare = append(s.spare, s.levels...)
+	s.free, s.levels = s.free[:0], s.levels[:0]
+	for i := len(s.slots) - 1; i >= 0; i-- {
+		s.slots[i] = orderSlot{generation: s.slots[i].generation + 1}
+		s.free = append(s.free, uint32(i))
+	}
+	clear(s.ticks)
+	s.onTicks = 0
+	clear(s.handles)
+	s.count, s.built = 0, false
+	for _, order := range orders {
+		s.insert(order)
+	}
+}
+
+// sideOf returns the book's side that orders on side rest on
+func (b *OrderBook) sideOf(side Side) *bookSide {
+	if side == SideSell {
+		return &b.asks
+	}
+	return &b.bids
+}
+
+// findResting finds an order resting on either side of the book. It must
+// run on the book's matcher.
+func findResting(book *OrderBook, orderID string) (*bookSide, orderHandle, bool) {
+	if handle, ok := book.bids.find(orderID); ok {
+		return &book.bids, handle, true
+	}
+	if handle, ok := book.asks.find(orderID); ok {
+		return &book.asks, handle, true
+	}
+	return nil, 0, false
+}
+
+// l3Position returns the place in its level of the order a handle names.
+// Only L3 stream clients are told it, so it is only counted while some are
+// connected.
+func l3Position(book *OrderBook, side *bookSide, handle orderHandle) int {
+	if len(book.l3.subscribers) == 0 {
+		return 0
+	}
+	return side.position(handle)
+}
+
+// takeResting takes an order off its side, returning it and, for L3 stream
+// clients, the place in its level it left from
+func takeResting(book *OrderBook, side *bookSide, handle orderHandle) (Order, int) {
+	position := l3Position(book, side, handle)
+	return side.remove(handle), position
+}
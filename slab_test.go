@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestBookSide_StaysTheSizeOfTheBook(t *testing.T) {
+	setupTest()
+	useDeterministicEngine(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	book := bookFor("")
+
+	// Ten orders rest at a time while a thousand come and go
+	for i := 0; i < 1000; i++ {
+		processOrder(Order{ID: fmt.Sprintf("bid-%d", i), Side: SideBuy, Price: 90 + float64(i%10), Quantity: 1, Status: OrderStatusPending, CreatedAt: engineClock.Now()})
+		if i >= 10 {
+			if _, ok := cancelOrder("", fmt.Sprintf("bid-%d", i-10)); !ok {
+				t.Fatalf("Expected bid-%d cancelled", i-10)
+			}
+		}
+	}
+	if book.bids.len() != 10 || len(book.bids.handles) != 10 || len(book.bids.slots) > 11 {
+		t.Errorf("Expected the side to reuse its slots, got %d orders, %d handles and %d slots",
+			book.bids.len(), len(book.bids.handles), len(book.bids.slots))
+	}
+
+	// Fills free their slots too
+	processOrder(Order{ID: "sweep", Side: SideSell, Price: 90, Quantity: 10, Status: OrderStatusPending, CreatedAt: engineClock.Now()})
+	if book.bids.len() != 0 || len(book.bids.handles) != 0 || len(book.bids.free) != len(book.bids.slots) || len(book.bids.levels) != 0 {
+		t.Errorf("Expected every slot freed after the sweep, got %+v", book.bids)
+	}
+}
+
+func TestBookSide_StaleHandles(t *testing.T) {
+	side := newBookSide(SideBuy, 0)
+	handle, _ := side.insert(Order{ID: "a", Side: SideBuy, Price: 100})
+	side.remove(handle)
+	reused, _ := side.insert(Order{ID: "b", Side: SideBuy, Price: 101})
+
+	if uint32(reused) != uint32(handle) || reused == handle {
+		t.Fatal("Expected the freed slot reused under a new generation")
+	}
+	if _, ok := side.slot(handle); ok {
+		t.Error("Expected the freed handle to no longer name its slot")
+	}
+	if slot, ok := side.slot(reused); !ok || slot.order.ID != "b" || slot.level.price != 101 {
+		t.Errorf("Expected b's slot, got %+v", slot)
+	}
+
+	// A reset keeps the slots, so their generations carry on
+	side.reset([]Order{{ID: "c", Side: SideBuy, Price: 102}})
+	if len(side.slots) != 1 || side.handles["c"] == reused {
+		t.Errorf("Expected c in the same slot under a new generation, got %d slots", len(side.slots))
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected reading through a stale handle to panic")
+		}
+	}()
+	side.at(reused)
+}
+
+func TestBookSide_QueuesEachLevelInPriority(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(id string, price float64, seconds int) Order {
+		return Order{ID: id, Side: SideBuy, Price: price, Quantity: 1, CreatedAt: start.Add(time.Duration(seconds) * time.Second)}
+	}
+	side := newBookSide(SideBuy, 0)
+	for _, order := range []Order{at("a", 100, 1), at("b", 100, 2), at("c", 100, 3), at("d", 101, 4)} {
+		side.insert(order)
+	}
+	// An order older than the rest of its level goes in ahead of them
+	if _, position := side.insert(at("e", 100, 0)); position != 1 {
+		t.Errorf("Expected e first in its level, got position %d", position)
+	}
+
+	ids := func() string {
+		var ids string
+		for _, order := range side.orders() {
+			ids += order.ID
+		}
+		return ids
+	}
+	if got := ids(); got != "deabc" {
+		t.Fatalf("Expected the best price first, then time order, got %s", got)
+	}
+	held := side.orders()
+
+	// Taking orders off leaves the rest of their level in place
+	b, _ := side.find("b")
+	if position := side.position(b); position != 3 {
+		t.Errorf("Expected b third at 100, got %d", position)
+	}
+	side.remove(b)
+	d, _ := side.find("d")
+	side.remove(d)
+	if got := ids(); got != "eac" || len(side.levels) != 1 || side.levels[0].orders != 3 {
+		t.Errorf("Expected e, a and c left at one level, got %s over %d levels", got, len(side.levels))
+	}
+	if first, _ := side.first(); first.ID != "e" {
+		t.Errorf("Expected e first, got %s", first.ID)
+	}
+	if len(held) != 5 {
+		t.Errorf("Expected a view already read to stay as it was, got %+v", held)
+	}
+	if _, ok := side.find("b"); ok {
+		t.Error("Expected b no longer found")
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// snapshotVersion is bumped whenever the snapshot format changes in a way an
+// older server could not restore
+const snapshotVersion = 1
+
+// Snapshot is the engine's complete state at one moment: every book with its
+// resting and stop orders, the trade and order event history, and the
+// sequence counters clients resume from. Running algos are not included.
+type Snapshot struct {
+	Version       int            `json:"version"`
+	TakenAt       time.Time      `json:"taken_at"`
+	DefaultSymbol string         `json:"default_symbol"`
+	Books         []BookSnapshot `json:"books"`
+	Trades        []Trade        `json:"trades"`
+	OrderEvents   []OrderEvent   `json:"order_events"`
+	ClosedOrders  []ClosedOrder  `json:"closed_orders,omitempty"`
+	// EventSequence is the sequence of the newest order event, archived or not
+	EventSequence int `json:"event_sequence"`
+	// Epoch is the run of sequence numbers the snapshot was taken in
+	Epoch string `json:"epoch,omitempty"`
+	// ConsumerGroups are the offsets consumers have acknowledged
+	ConsumerGroups []ConsumerOffset `json:"consumer_groups,omitempty"`
+}
+
+// BookSnapshot is one symbol's book
+type BookSnapshot struct {
+	Symbol     string         `json:"symbol"`
+	BuyOrders  []Order        `json:"buy_orders"`
+	SellOrders []Order        `json:"sell_orders"`
+	Stops      []StopSnapshot `json:"stops,omitempty"`
+	LastPrice  float64        `json:"last_price,omitempty"`
+	Mark       MarkInputs     `json:"mark"`
+	// Sequence is the book's depth sequence, so stream clients can tell
+	// whether a restored server is showing them the book they had
+	Sequence int64 `json:"sequence"`
+}
+
+// StopSnapshot is an untriggered stop and the price its trigger trails
+type StopSnapshot struct {
+	Order
+	Anchor float64 `json:"anchor,omitempty"`
+}
+
+// RestoreResult counts what a restore loaded
+type RestoreResult struct {
+	Symbols      int `json:"symbols"`
+	Orders       int `json:"orders"`
+	Trades       int `json:"trades"`
+	OrderEvents  int `json:"order_events"`
+	ClosedOrders int `json:"closed_orders"`
+}
+
+// holdMu serializes holdMatchers
+var holdMu sync.Mutex
+
+// holdMatchers parks every matcher between commands and runs fn while they
+// are held, so no book or history changes until it returns. Each matcher
+// then runs after, if given, on its own goroutine before its next command.
+// Holds are taken one at a time, since two that share matchers could each
+// park some of them and wait forever on the rest.
+func holdMatchers(matchers []*matcher, fn func(), after func(m *matcher)) {
+	holdMu.Lock()
+	defer holdMu.Unlock()
+
+	release := make(chan struct{})
+	var held, finished sync.WaitGroup
+	held.Add(len(matchers))
+	finished.Add(len(matchers))
+	for _, m := range matchers {
+		go func() {
+			defer finished.Done()
+			m.do(func() {
+				held.Done()
+				<-release
+				if after != nil {
+					after(m)
+				}
+			})
+		}()
+	}
+
+	held.Wait()
+	fn()
+	close(release)
+	finished.Wait()
+}
+
+// takeSnapshot captures the engine's state with every matcher held, so the
+// books and the history agree with each other
+func takeSnapshot() Snapshot {
+	matchers := allMatchers()
+
+	var snapshot Snapshot
+	holdMatchers(matchers, func() {
+		consumersMu.Lock()
+		defer consumersMu.Unlock()
+		snapshot = captureSnapshot(matchers)
+	}, nil)
+	return snapshot
+}
+
+// captureSnapshot copies the engine's state. The matchers must be held, and
+// consumersMu too, since consumer groups commit off the matchers.
+func captureSnapshot(matchers []*matcher) Snapshot {
+	snapshot := Snapshot{Version: snapshotVersion, TakenAt: engineClock.Now(), Epoch: currentEpoch()}
+
+	symbolsMu.RLock()
+	snapshot.DefaultSymbol = defaultSymbol
+	symbolsMu.RUnlock()
+
+	for _, m := range matchers {
+		snapshot.Books = append(snapshot.Books, m.snapshotBook())
+	}
+	snapshot.Trades = tradeStore.List("")
+	snapshot.ClosedOrders = closedStore.List(OrderHistoryFilter{})
+	snapshot.ConsumerGroups = consumerOffsetsLocked()
+
+	historyMu.Lock()
+	snapshot.OrderEvents = append([]OrderEvent(nil), orderEvents...)
+	snapshot.EventSequence = lastEventSequence
+	historyMu.Unlock()
+	return snapshot
+}
+
+// snapshotBook copies the matcher's book. The matcher must be held or running it.
+func (m *matcher) snapshotBook() BookSnapshot {
+	book := m.book
+	saved := BookSnapshot{
+		Symbol:     m.symbol,
+		BuyOrders:  append([]Order(nil), book.bids.orders()...),
+		SellOrders: append([]Order(nil), book.asks.orders()...),
+		LastPrice:  book.lastPrice,
+		Mark:       book.mark.inputs,
+		Sequence:   book.sequence,
+	}
+	for _, stop := range book.stops {
+		saved.Stops = append(saved.Stops, StopSnapshot{Order: stop, Anchor: stop.anchor})
+	}
+	return saved
+}
+
+// validate rejects a snapshot written in a format this server cannot read
+func (s Snapshot) validate() []FieldError {
+	if s.Version != snapshotVersion {
+		return []FieldError{fieldError("version", "eq", "version must be %d", snapshotVersion)}
+	}
+	return nil
+}
+
+// unknownSymbols lists the snapshot's symbols this server does not trade
+func (s Snapshot) unknownSymbols() []string {
+	var unknown []string
+	for _, book := range s.Books {
+		if _, ok := matcherFor(book.Symbol); !ok || book.Symbol == "" {
+			unknown = append(unknown, book.Symbol)
+		}
+	}
+	return unknown
+}
+
+// restoreSnapshot replaces the engine's state with a snapshot's. Books for
+// symbols the snapshot leaves out are emptied.
+func restoreSnapshot(s Snapshot) RestoreResult {
+	books := make(map[string]BookSnapshot, len(s.Books))
+	result := RestoreResult{Symbols: len(s.Books), Trades: len(s.Trades), OrderEvents: len(s.OrderEvents), ClosedOrders: len(s.ClosedOrders)}
+	for _, book := range s.Books {
+		books[book.Symbol] = book
+		result.Orders += len(book.BuyOrders) + len(book.SellOrders) + len(book.Stops)
+	}
+
+	holdMatchers(allMatchers(), func() {
+		// Replicas copied the state being replaced, so they start over, and
+		// so do the sequences clients were following
+		replication.dropAll()
+		startEpoch()
+		tradeStore.Replace(s.Trades)
+		readModel.reset(nil, s.Trades)
+		closedStore.Replace(s.ClosedOrders)
+		replaceConsumerOffsets(s.ConsumerGroups)
+
+		historyMu.Lock()
+		orderEvents = append(make([]OrderEvent, 0, max(len(s.OrderEvents), cap(orderEvents))), s.OrderEvents...)
+		lastEventSequence = s.EventSequence
+		historyMu.Unlock()
+	}, func(m *matcher) {
+		m.restoreBook(books[m.symbol])
+	})
+	return result
+}
+
+// restoreBook replaces the matcher's book with a saved one, rebuilds what the
+// engine derives from the orders, and tells stream clients to resync. It must
+// run on the matcher.
+func (m *matcher) restoreBook(saved BookSnapshot) {
+	book := m.book
+	book.bids.reset(saved.BuyOrders)
+	book.asks.reset(saved.SellOrders)
+
+	book.nextExpiry = time.Time{}
+	book.bidLiquidity.reset()
+	book.askLiquidity.reset()
+	book.pegBid, book.pegAsk, book.hasPegs = 0, 0, false
+	for _, orders := range [][]Order{book.bids.orders(), book.asks.orders()} {
+		for _, order := range orders {
+			noteExpiry(book, order)
+			noteArrival(order)
+			adjustLevel(book, order.Side, order.Price, order.Quantity, 1)
+			if order.Type == OrderTypePegged {
+				book.hasPegs = true
+			}
+		}
+	}
+
+	book.stops = book.stops[:0]
+	for _, stop := range saved.Stops {
+		order := stop.Order
+		order.anchor = stop.Anchor
+		noteExpiry(book, order)
+		noteArrival(order)
+		book.stops = append(book.stops, order)
+	}
+	book.lastPrice = saved.LastPrice
+	book.mark.inputs = saved.Mark
+	readModel.book(m.symbol, book)
+
+	// The restored book is not a change on top of the old one, so rather than
+	// publishing levels the sequence jumps and every client starts over
+	clear(book.dirtyBids)
+	clear(book.dirtyAsks)
+	book.sequence = saved.Sequence
+	m.view.Store(nil)
+	m.refreshPrices()
+	for subscriber := range m.subscribers {
+		subscriber.send(DepthUpdate{Type: DepthMessageResync, Symbol: m.symbol, Sequence: book.sequence})
+	}
+}
+
+// algosRunning reports whether any parent order is still sending slices
+func algosRunning() bool {
+	for _, order := range algoSnapshots() {
+		if order.Status == AlgoStatusRunning {
+			return true
+		}
+	}
+	return false
+}
+
+// snapshotHandler downloads the engine's state as a JSON file
+func snapshotHandler(w http.ResponseWriter, r *http.Request) {
+	snapshot := takeSnapshot()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition",
+		`attachment; filename="valhalla-snapshot-`+snapshot.TakenAt.UTC().Format("20060102T150405Z")+`.json"`)
+	json.NewEncoder(w).Encode(snapshot)
+}
+
+// restoreHandler loads a snapshot file in place of the engine's state
+func restoreHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var snapshot Snapshot
+	if !decodeRequest(w, r, &snapshot) {
+		return
+	}
+	if unknown := snapshot.unknownSymbols(); len(unknown) > 0 {
+		writeError(w, http.StatusBadRequest, ErrCodeUnknownSymbol, "Unknown symbol",
+			"symbols not traded here: "+strings.Join(unknown, ", "))
+		return
+	}
+	// A running algo would keep working orders the restore has replaced
+	if algosRunning() {
+		writeError(w, http.StatusConflict, ErrCodeAlgosRunning, "Algos are running",
+			"cancel or wait for every running algo before restoring")
+		return
+	}
+
+	json.NewEncoder(w).Encode(restoreSnapshot(snapshot))
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// downloadSnapshot takes a snapshot through the admin endpoint
+func downloadSnapshot(t *testing.T) []byte {
+	t.Helper()
+
+	response := serve(HTTPConfig{}, httptest.NewRequest("POST", "/api/v1/admin/snapshot", nil))
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", response.Code)
+	}
+	if !strings.HasPrefix(response.Header().Get("Content-Disposition"), `attachment; filename="valhalla-snapshot-`) {
+		t.Errorf("Expected the snapshot as a download, got %q", response.Header().Get("Content-Disposition"))
+	}
+	return response.Body.Bytes()
+}
+
+// uploadSnapshot restores a snapshot through the admin endpoint
+func uploadSnapshot(body []byte) *httptest.ResponseRecorder {
+	return serve(HTTPConfig{}, httptest.NewRequest("POST", "/api/v1/admin/restore", bytes.NewReader(body)))
+}
+
+func TestSnapshot_RestoresIntoAFreshServer(t *testing.T) {
+	setupTest()
+	tradeAt(t, 100.0)
+	processOrder(trailingStop("stop-1", SideSell, 5, 2.0, 0))
+	tradeAt(t, 103.0)
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "bid-1", Side: SideBuy, Price: 99.0, Quantity: 4})
+	placeOn(m, Order{ID: "ask-1", Side: SideSell, Price: 105.0, Quantity: 6})
+
+	var sequence int64
+	m.do(func() { sequence = m.book.sequence })
+	orders, trades, eventSequence := collectOrders(""), tradeStore.List(""), lastEventSequence
+	body := downloadSnapshot(t)
+
+	setupTest()
+	response := uploadSnapshot(body)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", response.Code, response.Body.String())
+	}
+	var result RestoreResult
+	json.NewDecoder(response.Body).Decode(&result)
+	if result.Symbols != 1 || result.Orders != 3 || result.Trades != len(trades) {
+		t.Errorf("Unexpected restore result %+v", result)
+	}
+
+	if got := collectOrders(""); len(got) != len(orders) || got[0].ID != orders[0].ID {
+		t.Errorf("Expected the open orders back, got %+v", got)
+	}
+	if got := tradeStore.List(""); len(got) != len(trades) || got[len(got)-1].ID != trades[len(trades)-1].ID {
+		t.Errorf("Expected the trade history back, got %+v", got)
+	}
+	m, _ = matcherFor("")
+	m.do(func() {
+		if m.book.sequence != sequence || lastEventSequence != eventSequence {
+			t.Errorf("Expected sequences %d and %d, got %d and %d", sequence, eventSequence, m.book.sequence, lastEventSequence)
+		}
+		if m.book.bidLiquidity.quantity != 4 || m.book.askLiquidity.orders != 1 {
+			t.Errorf("Expected the liquidity totals to be rebuilt, got %+v and %+v", m.book.bidLiquidity, m.book.askLiquidity)
+		}
+	})
+
+	// The stop kept its anchor, so it still trails from 103.00
+	if stop, ok := findStop("stop-1"); !ok || stop.TriggerPrice != 101.0 || stop.anchor != 103.0 {
+		t.Errorf("Expected stop-1 anchored at 103.00, got %+v", stop)
+	}
+
+	// The restored book matches like any other
+	placeOn(m, Order{ID: "sell-1", Side: SideSell, Price: 99.0, Quantity: 4})
+	if fills := tradeStore.TakerFills("sell-1", 4); len(fills) != 1 || fills[0].MakerID != "bid-1" {
+		t.Errorf("Expected sell-1 to fill against the restored bid, got %+v", fills)
+	}
+}
+
+func TestRestore_TellsStreamClientsToResync(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "bid-1", Side: SideBuy, Price: 99.0, Quantity: 4})
+	body := downloadSnapshot(t)
+
+	placeOn(m, Order{ID: "bid-2", Side: SideBuy, Price: 98.0, Quantity: 1})
+	_, subscriber := subscribeDepth(t, 4)
+	if response := uploadSnapshot(body); response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", response.Code)
+	}
+
+	update := nextUpdate(t, subscriber)
+	if update.Type != DepthMessageResync || update.Sequence != 1 {
+		t.Errorf("Expected a resync at the restored sequence, got %+v", update)
+	}
+	if orders := collectOrders(""); len(orders) != 1 || orders[0].ID != "bid-1" {
+		t.Errorf("Expected only bid-1 after the restore, got %+v", orders)
+	}
+}
+
+func TestRestore_RejectsSnapshotsItCannotLoad(t *testing.T) {
+	setupTest()
+
+	response := uploadSnapshot([]byte(`{"version":99}`))
+	if result := decodeError(t, response); response.Code != http.StatusBadRequest || result.Error.Code != ErrCodeValidationFailed {
+		t.Errorf("Expected a version mismatch to fail validation, got %d %s", response.Code, result.Error.Code)
+	}
+
+	response = uploadSnapshot([]byte(`{"version":1,"books":[{"symbol":"DOGE-USD"}]}`))
+	if result := decodeError(t, response); response.Code != http.StatusBadRequest || result.Error.Code != ErrCodeUnknownSymbol {
+		t.Errorf("Expected an unknown symbol to be refused, got %d %s", response.Code, result.Error.Code)
+	}
+
+	algos["algo-1"] = &algo{order: AlgoOrder{ID: "algo-1", Status: AlgoStatusRunning}}
+	response = uploadSnapshot([]byte(`{"version":1}`))
+	if result := decodeError(t, response); response.Code != http.StatusConflict || result.Error.Code != ErrCodeAlgosRunning {
+		t.Errorf("Expected a restore under a running algo to conflict, got %d %s", response.Code, result.Error.Code)
+	}
+}
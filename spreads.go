@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Spread is a synthetic symbol over two others. Buying it buys BuyLeg and
+// sells SellLeg, so its price is BuyLeg's less SellLeg's.
+type Spread struct {
+	Symbol  string `json:"symbol"`
+	BuyLeg  string `json:"buy_leg"`
+	SellLeg string `json:"sell_leg"`
+	// ImpliedBid and ImpliedAsk are where the legs' books would fill a
+	// spread order now; zero when a leg has nothing on that side
+	ImpliedBid float64 `json:"implied_bid,omitempty"`
+	ImpliedAsk float64 `json:"implied_ask,omitempty"`
+}
+
+// SpreadsResponse lists the spread symbols
+type SpreadsResponse struct {
+	Spreads []Spread `json:"spreads"`
+	Count   int      `json:"count"`
+}
+
+// spreads is guarded by symbolsMu
+var spreads = make(map[string]Spread)
+
+// listSpread registers a spread symbol
+func listSpread(spread Spread) {
+	symbolsMu.Lock()
+	defer symbolsMu.Unlock()
+	spreads[spread.Symbol] = spread
+}
+
+// spreadFor returns the spread a symbol trades, if it is one
+func spreadFor(symbol string) (Spread, bool) {
+	symbolsMu.RLock()
+	defer symbolsMu.RUnlock()
+	spread, ok := spreads[symbol]
+	return spread, ok
+}
+
+// startSpreads lists each configured spread whose symbols are all traded
+func startSpreads(configured map[string]Spread) {
+	for symbol, spread := range configured {
+		_, ok := matcherFor(symbol)
+		_, buyOK := matcherFor(spread.BuyLeg)
+		_, sellOK := matcherFor(spread.SellLeg)
+		if !ok || !buyOK || !sellOK {
+			log.Printf("spreads: unknown symbol %q or legs %q and %q, spread not listed", symbol, spread.BuyLeg, spread.SellLeg)
+			continue
+		}
+		spread.Symbol = symbol
+		listSpread(spread)
+		log.Printf("spreads: %s buys %s and sells %s", symbol, spread.BuyLeg, spread.SellLeg)
+	}
+}
+
+// submitOrder processes an order placed from outside the engine on its
+// symbol's matcher. A spread order waits for its legs' matchers as well as
+// its own, so it cannot be turned away as busy or given up when ctx ends.
+func submitOrder(ctx context.Context, m *matcher, order Order) (Order, error) {
+	if spread, ok := spreadFor(m.symbol); ok {
+		return placeSpreadOrder(spread, order), nil
+	}
+	err := m.tryDo(ctx, func() {
+		order = processOrder(order)
+	})
+	return order, err
+}
+
+// placeSpreadOrder processes a spread order with the spread's and both
+// legs' matchers held, so the legs it trades cannot move under it
+func placeSpreadOrder(spread Spread, order Order) Order {
+	m, _ := matcherFor(spread.Symbol)
+	buyLeg, _ := matcherFor(spread.BuyLeg)
+	sellLeg, _ := matcherFor(spread.SellLeg)
+	holdMatchers([]*matcher{m, buyLeg, sellLeg}, func() {
+		order = processSpreadOrder(spread, m.book, buyLeg.book, sellLeg.book, order)
+	}, nil)
+	return order
+}
+
+// processSpreadOrder screens a spread order, fills what it can from the
+// prices its legs imply, and then matches what is left on the spread's own
+// book. Implied prices are taken while they are better than the spread
+// book's own.
+func processSpreadOrder(spread Spread, book, buyLeg, sellLeg *OrderBook, order Order) Order {
+	now := engineClock.Now()
+	order.Timestamps.AcceptedAt = now
+	stampArrival(&order)
+
+	order, admitted := screenOrder(book, order, now, true)
+	if admitted && ((order.Type != "" && order.Type != OrderTypeLimit && order.Type != OrderTypeMarket) || requiredFill(order) > 0 || order.QuoteQuantity > 0) {
+		rejectOrder(&order, ErrCodeSpreadUnsupported)
+		admitted = false
+	}
+	if admitted {
+		for order.Quantity > 0 && impliedTurn(book, buyLeg, sellLeg, order) {
+			price, quantity := impliedQuote(order.Side, buyLeg, sellLeg)
+			filled := fillLegs(spread, buyLeg, sellLeg, order, min(order.Quantity, quantity), now)
+			if filled == 0 {
+				break
+			}
+			fillImplied(&order, filled, price)
+		}
+		if order.Quantity > 0 {
+			order = enterOrder(book, order)
+		}
+	}
+
+	stampMatched(&order)
+	book.latency.add(latencyOf(order))
+	return order
+}
+
+// impliedTurn reports whether the legs' books may fill the order next: they
+// trade continuously, imply a price the order reaches, and the spread's own
+// book has nothing as good
+func impliedTurn(book, buyLeg, sellLeg *OrderBook, order Order) bool {
+	for _, leg := range []*OrderBook{book, buyLeg, sellLeg} {
+		if leg.auction != nil || leg.holding() {
+			return false
+		}
+	}
+	price, quantity := impliedQuote(order.Side, buyLeg, sellLeg)
+	if quantity == 0 {
+		return false
+	}
+	implied := Order{Price: price}
+	if !priceCrosses(order, implied) {
+		return false
+	}
+
+	opposite := &book.asks
+	if order.Side == SideSell {
+		opposite = &book.bids
+	}
+	best, ok := opposite.first()
+	if !ok {
+		return true
+	}
+	if order.Side == SideBuy {
+		return price < best.Price
+	}
+	return price > best.Price
+}
+
+// impliedQuote returns the price and quantity the legs' best levels offer
+// an order of side on the spread. Buying the spread lifts the buy leg's offer
+// and hits the sell leg's bid; selling it does the reverse.
+func impliedQuote(side Side, buyLeg, sellLeg *OrderBook) (float64, int) {
+	first, second := &buyLeg.asks, &sellLeg.bids
+	if side == SideSell {
+		first, second = &buyLeg.bids, &sellLeg.asks
+	}
+	a, b := aggregateLevels(first.head(1), 1), aggregateLevels(second.head(1), 1)
+	if len(a) == 0 || len(b) == 0 {
+		return 0, 0
+	}
+	return spreadPrice(a[0].Price - b[0].Price), min(a[0].Quantity, b[0].Quantity)
+}
+
+// spreadPrice drops the rounding error left by subtracting two leg prices
+func spreadPrice(price float64) float64 {
+	return math.Round(price*1e8) / 1e8
+}
+
+// fillLegs sends an IOC order to each leg's best level for quantity on
+// behalf of a spread order. Both legs are screened and sized to what both
+// can fill before either trades; if one is refused, neither is sent. The
+// second leg then takes only what the first filled, so the owner is never
+// left holding one leg without the other. It returns the quantity that
+// filled on both legs.
+func fillLegs(spread Spread, buyLeg, sellLeg *OrderBook, order Order, quantity int, now time.Time) int {
+	var legs [2]Order
+	books := [2]*OrderBook{buyLeg, sellLeg}
+	symbols := [2]string{spread.BuyLeg, spread.SellLeg}
+	sides := [2]Side{SideBuy, SideSell}
+	if order.Side == SideSell {
+		sides = [2]Side{SideSell, SideBuy}
+	}
+	for i, book := range books {
+		top, _ := book.asks.first()
+		if sides[i] == SideSell {
+			top, _ = book.bids.first()
+		}
+		legs[i] = Order{
+			ID:            generateOrderID(),
+			Symbol:        symbols[i],
+			Side:          sides[i],
+			Type:          OrderTypeLimit,
+			Price:         top.Price,
+			Quantity:      quantity,
+			Status:        OrderStatusPending,
+			CreatedAt:     now,
+			Owner:         order.Owner,
+			TimeInForce:   TimeInForceIOC,
+			SpreadOrderID: order.ID,
+			Timestamps:    OrderTimestamps{ReceivedAt: now, AcceptedAt: now},
+		}
+		stampArrival(&legs[i])
+	}
+
+	for i, book := range books {
+		var admitted bool
+		if legs[i], admitted = screenOrder(book, legs[i], now, false); admitted && crossesOwnOrder(book, legs[i]) {
+			rejectOrder(&legs[i], ErrCodeSelfTrade)
+			admitted = false
+		}
+		if !admitted {
+			if i == 1 {
+				rejectOrder(&legs[0], ErrCodeLegRefused)
+			}
+			return 0
+		}
+	}
+
+	// A position limit that trims one leg trims the other with it, and
+	// neither may take more than its level rests
+	quantity = min(legs[0].Quantity, legs[1].Quantity)
+	for i, book := range books {
+		quantity = min(quantity, availableLiquidity(book, legs[i], quantity))
+	}
+	legs[0].Quantity, legs[1].Quantity = quantity, quantity
+
+	for i, book := range books {
+		// A first leg cut short, as by a volatility halt, shrinks the second
+		if i == 1 {
+			if legs[1].Quantity = legs[0].FilledQuantity; legs[1].Quantity == 0 {
+				rejectOrder(&legs[1], ErrCodeLegRefused)
+				return 0
+			}
+		}
+		legs[i] = executeOrder(book, legs[i])
+		repricePegs(book)
+		stampMatched(&legs[i])
+	}
+	return min(legs[0].FilledQuantity, legs[1].FilledQuantity)
+}
+
+// fillImplied records quantity of a spread order as filled from its legs at
+// price
+func fillImplied(order *Order, quantity int, price float64) {
+	order.Quantity -= quantity
+	order.FilledQuantity += quantity
+	status := OrderStatusPartiallyFilled
+	if order.Quantity == 0 {
+		status = OrderStatusFilled
+	}
+	if err := transitionOrder(order, status, fmt.Sprintf("implied fill of %d at %g", quantity, price)); err != nil {
+		logTransitionError(err)
+	}
+}
+
+// parseSpreads reads the -spreads list of symbol=buy-leg/sell-leg entries
+func parseSpreads(list string) (map[string]Spread, error) {
+	configured := make(map[string]Spread)
+	for _, item := range splitList(list) {
+		symbol, legs, ok := strings.Cut(item, "=")
+		buyLeg, sellLeg, legsOK := strings.Cut(legs, "/")
+		buyLeg, sellLeg = strings.ToUpper(strings.TrimSpace(buyLeg)), strings.ToUpper(strings.TrimSpace(sellLeg))
+		if !ok || !legsOK || strings.TrimSpace(symbol) == "" || buyLeg == "" || sellLeg == "" {
+			return nil, fmt.Errorf("-spreads entry %q must be symbol=buy-leg/sell-leg", item)
+		}
+		symbol = strings.ToUpper(strings.TrimSpace(symbol))
+		configured[symbol] = Spread{Symbol: symbol, BuyLeg: buyLeg, SellLeg: sellLeg}
+	}
+	return configured, nil
+}
+
+// getSpreadsHandler lists the spread symbols with the prices their legs
+// imply now, read from the legs' latest views
+func getSpreadsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	symbolsMu.RLock()
+	list := make([]Spread, 0, len(spreads))
+	for _, spread := range spreads {
+		list = append(list, spread)
+	}
+	symbolsMu.RUnlock()
+
+	for i, spread := range list {
+		buyLeg, buyOK := matcherFor(spread.BuyLeg)
+		sellLeg, sellOK := matcherFor(spread.SellLeg)
+		if !buyOK || !sellOK {
+			continue
+		}
+		first, second := buyLeg.readView().depth(spread.BuyLeg, 1), sellLeg.readView().depth(spread.SellLeg, 1)
+		if len(first.Bids) > 0 && len(second.Asks) > 0 {
+			list[i].ImpliedBid = spreadPrice(first.Bids[0].Price - second.Asks[0].Price)
+		}
+		if len(first.Asks) > 0 && len(second.Bids) > 0 {
+			list[i].ImpliedAsk = spreadPrice(first.Asks[0].Price - second.Bids[0].Price)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Symbol < list[j].Symbol })
+
+	writeBody(w, r, SpreadsResponse{Spreads: list, Count: len(list)})
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// setupSpread trades BTC-CAL as BTC-JUN less BTC-MAR
+func setupSpread() (spread, jun, mar *matcher) {
+	setupSymbols("BTC-JUN", "BTC-MAR", "BTC-CAL")
+	startSpreads(map[string]Spread{"BTC-CAL": {BuyLeg: "BTC-JUN", SellLeg: "BTC-MAR"}})
+	spread, _ = matcherFor("BTC-CAL")
+	jun, _ = matcherFor("BTC-JUN")
+	mar, _ = matcherFor("BTC-MAR")
+	return spread, jun, mar
+}
+
+// legsOf returns the closed leg orders implied from a spread order
+func legsOf(id string) []Order {
+	var legs []Order
+	for _, closed := range closedStore.List(OrderHistoryFilter{}) {
+		if closed.SpreadOrderID == id {
+			legs = append(legs, closed.Order)
+		}
+	}
+	return legs
+}
+
+func TestSpread_ImpliesFillsIntoTheLegs(t *testing.T) {
+	_, jun, mar := setupSpread()
+	placeOn(jun, Order{ID: "jun-ask", Symbol: "BTC-JUN", Side: SideSell, Price: 105, Quantity: 3, Owner: "bob"})
+	placeOn(mar, Order{ID: "mar-bid", Symbol: "BTC-MAR", Side: SideBuy, Price: 100, Quantity: 2, Owner: "carol"})
+
+	var quotes SpreadsResponse
+	json.NewDecoder(serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/spreads", nil)).Body).Decode(&quotes)
+	if quotes.Count != 1 || quotes.Spreads[0].ImpliedAsk != 5 || quotes.Spreads[0].ImpliedBid != 0 {
+		t.Errorf("Expected BTC-CAL implied offered at 5 with no bid, got %+v", quotes)
+	}
+
+	// The legs imply 2 at 5, and the rest waits on the spread's book
+	var placed PlaceOrderResponse
+	response := placeViaHandler(PlaceOrderRequest{Symbol: "BTC-CAL", Side: SideBuy, Price: 6, Quantity: 3, Owner: "alice"})
+	json.NewDecoder(response.Body).Decode(&placed)
+	if response.Code != http.StatusOK || placed.Status != OrderStatusPartiallyFilled || len(placed.Trades) != 2 {
+		t.Fatalf("Expected 2 implied from the legs, got %d %+v", response.Code, placed)
+	}
+	if bids := bookFor("BTC-CAL").bids.orders(); len(bids) != 1 || bids[0].Quantity != 1 || bids[0].FilledQuantity != 2 {
+		t.Errorf("Expected the remainder of 1 resting on BTC-CAL, got %+v", bids)
+	}
+
+	legs := legsOf(placed.OrderID)
+	if len(legs) != 2 {
+		t.Fatalf("Expected a leg order on each book, got %+v", legs)
+	}
+	for _, leg := range legs {
+		want := map[string]Side{"BTC-JUN": SideBuy, "BTC-MAR": SideSell}[leg.Symbol]
+		if leg.Side != want || leg.Owner != "alice" || leg.FilledQuantity != 2 || leg.Status != OrderStatusFilled {
+			t.Errorf("Expected alice to %s 2 %s, got %+v", want, leg.Symbol, leg)
+		}
+	}
+	if asks := bookFor("BTC-JUN").asks.orders(); len(asks) != 1 || asks[0].Quantity != 1 {
+		t.Errorf("Expected 1 left of the BTC-JUN ask, got %+v", asks)
+	}
+	if bids := bookFor("BTC-MAR").bids.orders(); len(bids) != 0 {
+		t.Errorf("Expected the BTC-MAR bid taken, got %+v", bids)
+	}
+}
+
+func TestSpread_OutrightPricesAndRefusedLegs(t *testing.T) {
+	spread, jun, mar := setupSpread()
+	placeOn(jun, Order{ID: "jun-ask", Symbol: "BTC-JUN", Side: SideSell, Price: 105, Quantity: 5, Owner: "bob"})
+	placeOn(mar, Order{ID: "mar-bid", Symbol: "BTC-MAR", Side: SideBuy, Price: 100, Quantity: 5, Owner: "carol"})
+	placeOn(spread, Order{ID: "cal-ask", Symbol: "BTC-CAL", Side: SideSell, Price: 4, Quantity: 1, Owner: "dave"})
+
+	// The spread's own offer at 4 beats the 5 the legs imply
+	var placed PlaceOrderResponse
+	json.NewDecoder(placeViaHandler(PlaceOrderRequest{Symbol: "BTC-CAL", Side: SideBuy, Price: 6, Quantity: 1, Owner: "alice"}).Body).Decode(&placed)
+	if placed.Status != OrderStatusFilled || len(legsOf(placed.OrderID)) != 0 || bookFor("BTC-JUN").asks.orders()[0].Quantity != 5 {
+		t.Errorf("Expected the outright offer taken and the legs untouched, got %+v", placed)
+	}
+
+	// A leg its owner may not trade keeps the other from being sent
+	positionLimits = PositionLimitConfig{Limits: map[string]int{"BTC-MAR": 1}}
+	openFunded(t, "erin", map[string]float64{"USD": 10000})
+	json.NewDecoder(placeViaHandler(PlaceOrderRequest{Symbol: "BTC-CAL", Side: SideBuy, Price: 6, Quantity: 2, Owner: "erin"}).Body).Decode(&placed)
+	if placed.Status != OrderStatusPending || bookFor("BTC-JUN").asks.orders()[0].Quantity != 5 || bookFor("BTC-MAR").bids.orders()[0].Quantity != 5 {
+		t.Errorf("Expected erin's spread order to rest with the legs untouched, got %+v", placed)
+	}
+	reasons := map[string]ErrorCode{}
+	for _, leg := range legsOf(placed.OrderID) {
+		reasons[leg.Symbol] = leg.RejectReason
+	}
+	if reasons["BTC-JUN"] != ErrCodeLegRefused || reasons["BTC-MAR"] != ErrCodePositionLimit {
+		t.Errorf("Expected both legs rejected for the BTC-MAR limit, got %v", reasons)
+	}
+
+	response := placeViaHandler(PlaceOrderRequest{Symbol: "BTC-CAL", Side: SideBuy, Price: 6, Quantity: 1, MinQuantity: 1})
+	if result := decodeError(t, response); result.Error.Code != ErrCodeSpreadUnsupported {
+		t.Errorf("Expected a minimum quantity refused on a spread, got %d %+v", response.Code, result.Error)
+	}
+}
+
+func TestSpread_SizesTheSecondLegToTheFirst(t *testing.T) {
+	_, jun, mar := setupSpread()
+	useDeterministicEngine(t, time.Date(2026, 1, 2, 9, 0, 0, 0, time.UTC))
+	volatility = VolatilityConfig{Move: 0.05, Window: 30 * time.Second, Pause: time.Minute, Action: VolatilityHalt}
+	placeOn(jun, Order{ID: "jun-ask-1", Symbol: "BTC-JUN", Side: SideSell, Price: 100, Quantity: 1, Owner: "bob"})
+	placeOn(jun, Order{ID: "jun-bid-1", Symbol: "BTC-JUN", Side: SideBuy, Price: 100, Quantity: 1, Owner: "carol"})
+	placeOn(jun, Order{ID: "jun-ask-2", Symbol: "BTC-JUN", Side: SideSell, Price: 106, Quantity: 1, Owner: "bob"})
+	placeOn(jun, Order{ID: "jun-ask-3", Symbol: "BTC-JUN", Side: SideSell, Price: 106, Quantity: 1, Owner: "bob"})
+	placeOn(mar, Order{ID: "mar-bid", Symbol: "BTC-MAR", Side: SideBuy, Price: 100, Quantity: 2, Owner: "carol"})
+
+	// The first BTC-JUN fill halts its book, so only 1 of BTC-MAR is sold
+	var placed PlaceOrderResponse
+	json.NewDecoder(placeViaHandler(PlaceOrderRequest{Symbol: "BTC-CAL", Side: SideBuy, Price: 7, Quantity: 2, Owner: "alice"}).Body).Decode(&placed)
+	if placed.Status != OrderStatusPartiallyFilled {
+		t.Errorf("Expected 1 of the spread filled, got %+v", placed)
+	}
+	filled := map[string]int{}
+	for _, leg := range legsOf(placed.OrderID) {
+		filled[leg.Symbol] = leg.FilledQuantity
+	}
+	if filled["BTC-JUN"] != 1 || filled["BTC-MAR"] != 1 {
+		t.Errorf("Expected 1 bought and 1 sold, got %v", filled)
+	}
+	if bids := bookFor("BTC-MAR").bids.orders(); len(bids) != 1 || bids[0].Quantity != 1 {
+		t.Errorf("Expected 1 left of the BTC-MAR bid, got %+v", bids)
+	}
+}
+
+func TestLoadConfig_Spreads(t *testing.T) {
+	cfg, err := loadConfig([]string{"-symbols", "BTC-JUN,BTC-MAR,BTC-CAL", "-spreads", "btc-cal=btc-jun/btc-mar"})
+	if spread := cfg.Spreads["BTC-CAL"]; err != nil || spread.BuyLeg != "BTC-JUN" || spread.SellLeg != "BTC-MAR" {
+		t.Errorf("Unexpected spreads config %+v (%v)", cfg.Spreads, err)
+	}
+	for _, args := range [][]string{
+		{"-symbols", "BTC-JUN,BTC-MAR,BTC-CAL", "-spreads", "BTC-CAL=BTC-JUN"},
+		{"-symbols", "BTC-JUN,BTC-MAR", "-spreads", "BTC-CAL=BTC-JUN/BTC-MAR"},
+		{"-symbols", "BTC-JUN,BTC-CAL", "-spreads", "BTC-CAL=BTC-JUN/BTC-MAR"},
+		{"-symbols", "BTC-JUN,BTC-CAL", "-spreads", "BTC-CAL=BTC-JUN/BTC-JUN"},
+		{"-symbols", "A,B,C,D", "-spreads", "C=A/B,D=C/A"},
+	} {
+		if _, err := loadConfig(args); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import "fmt"
+
+// validateTrailingStop checks the fields that only apply to trailing stop requests
+func validateTrailingStop(req PlaceOrderRequest) []FieldError {
+	var errs []FieldError
+
+	if req.Price != 0 {
+		errs = append(errs, fieldError("price", "excluded", "price is not used by trailing_stop orders; set limit_offset to get a limit order on trigger"))
+	}
+
+	switch {
+	case req.TrailAmount == 0 && req.TrailPercent == 0:
+		errs = append(errs, fieldError("trail_amount", "required", "trailing_stop orders need either trail_amount or trail_percent"))
+	case req.TrailAmount != 0 && req.TrailPercent != 0:
+		errs = append(errs, fieldError("trail_percent", "exclusive", "set only one of trail_amount and trail_percent"))
+	case req.TrailAmount < 0:
+		errs = append(errs, fieldError("trail_amount", "gt", "trail_amount must be a positive number (received: %.2f)", req.TrailAmount))
+	case req.TrailPercent < 0 || req.TrailPercent >= 100:
+		errs = append(errs, fieldError("trail_percent", "range", "trail_percent must be between 0 and 100 (received: %.2f)", req.TrailPercent))
+	}
+
+	if req.LimitOffset != nil && *req.LimitOffset < 0 {
+		errs = append(errs, fieldError("limit_offset", "min", "limit_offset cannot be negative (received: %.2f)", *req.LimitOffset))
+	}
+
+	return errs
+}
+
+// addStop parks an untriggered stop on its book, anchored at the last trade
+// price, or the mark price for a stop that follows the mark, if there is one
+func addStop(book *OrderBook, order Order) Order {
+	price := book.lastPrice
+	if order.TriggerBy == StopTriggerMark {
+		price, _ = markPrice(book)
+	}
+	if price > 0 {
+		anchorStop(&order, price)
+	}
+	noteExpiry(book, order)
+	book.stops = append(book.stops, order)
+	return order
+}
+
+// anchorStop moves a trailing stop's anchor to price when that is a
+// favourable move (up for a sell stop, down for a buy stop) and recomputes
+// its trigger
+func anchorStop(order *Order, price float64) {
+	favourable := order.anchor == 0 ||
+		(order.Side == SideSell && price > order.anchor) ||
+		(order.Side == SideBuy && price < order.anchor)
+	if !favourable {
+		return
+	}
+
+	order.anchor = price
+	trail := order.TrailAmount
+	if order.TrailPercent > 0 {
+		trail = price * order.TrailPercent / 100
+	}
+	if order.Side == SideSell {
+		order.TriggerPrice = price - trail
+	} else {
+		order.TriggerPrice = price + trail
+	}
+}
+
+// stopTriggered reports whether a price has retraced far enough to fire a stop
+func stopTriggered(order Order, price float64) bool {
+	if order.anchor == 0 {
+		return false
+	}
+	if order.Side == SideSell {
+		return price <= order.TriggerPrice
+	}
+	return price >= order.TriggerPrice
+}
+
+// triggerStop converts a fired stop into the order it is executed as. It
+// takes its time priority from the moment it fires.
+func triggerStop(order *Order) {
+	order.Type = OrderTypeMarket
+	order.Price = 0
+	if order.LimitOffset != nil {
+		price := order.TriggerPrice + *order.LimitOffset
+		if order.Side == SideSell {
+			price = order.TriggerPrice - *order.LimitOffset
+		}
+		if price > 0 {
+			order.Type = OrderTypeLimit
+			order.Price = price
+		}
+	}
+	requeue(order)
+
+	reason := fmt.Sprintf("trailing stop triggered at %.2f", order.TriggerPrice)
+	if order.TriggerBy == StopTriggerMark {
+		reason = fmt.Sprintf("trailing stop triggered at a mark price of %.2f", order.TriggerPrice)
+	}
+	if err := transitionOrder(order, OrderStatusPending, reason); err != nil {
+		logTransitionError(err)
+	}
+}
+
+// stopTrigger returns the price a stop follows; stops that name none follow trades
+func (order Order) stopTrigger() StopTrigger {
+	if order.TriggerBy == StopTriggerMark {
+		return StopTriggerMark
+	}
+	return StopTriggerTrade
+}
+
+// trailStops feeds each trade price to the book's stops that follow trades,
+// in the order the trades happened. Stops that fire are removed and returned
+// ready to execute; the rest are re-anchored.
+func trailStops(book *OrderBook, fills []Trade) []Order {
+	var triggered []Order
+	for _, fill := range fills {
+		book.lastPrice = fill.Price
+		triggered = trailTo(book, StopTriggerTrade, fill.Price, triggered)
+	}
+	return triggered
+}
+
+// trailMarkStops feeds a new mark price to the book's stops that follow the
+// mark, removing and returning those that fire
+func trailMarkStops(book *OrderBook, mark float64) []Order {
+	return trailTo(book, StopTriggerMark, mark, nil)
+}
+
+// trailTo moves the stops that follow one kind of price to price, appending
+// the ones it fires to triggered
+func trailTo(book *OrderBook, trigger StopTrigger, price float64, triggered []Order) []Order {
+	kept := book.stops[:0]
+	for _, stop := range book.stops {
+		switch {
+		case stop.stopTrigger() != trigger:
+		case stopTriggered(stop, price):
+			triggerStop(&stop)
+			triggered = append(triggered, stop)
+			continue
+		default:
+			anchorStop(&stop, price)
+		}
+		kept = append(kept, stop)
+	}
+	book.stops = kept
+	return triggered
+}
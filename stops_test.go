@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// tradeAt prints a one-lot trade at price on the default book
+func tradeAt(t *testing.T, price float64) {
+	t.Helper()
+
+	id := fmt.Sprintf("%.2f-%d", price, len(tradeStore.List("")))
+	processOrder(Order{ID: "ask-" + id, Side: SideSell, Price: price, Quantity: 1, Status: OrderStatusPending, CreatedAt: time.Now()})
+	processOrder(Order{ID: "bid-" + id, Side: SideBuy, Price: price, Quantity: 1, Status: OrderStatusPending, CreatedAt: time.Now()})
+}
+
+// trailingStop builds an untriggered trailing stop order
+func trailingStop(id string, side Side, quantity int, amount, percent float64) Order {
+	return Order{
+		ID:           id,
+		Side:         side,
+		Quantity:     quantity,
+		Status:       OrderStatusUntriggered,
+		CreatedAt:    time.Now(),
+		Type:         OrderTypeTrailingStop,
+		TrailAmount:  amount,
+		TrailPercent: percent,
+	}
+}
+
+// findStop returns the untriggered stop with the given ID
+func findStop(id string) (Order, bool) {
+	for _, stop := range bookFor("").stops {
+		if stop.ID == id {
+			return stop, true
+		}
+	}
+	return Order{}, false
+}
+
+func TestTrailingStop_SellFollowsPriceAndTriggers(t *testing.T) {
+	setupTest()
+	tradeAt(t, 100.0)
+
+	result := processOrder(trailingStop("stop-1", SideSell, 5, 2.0, 0))
+	if result.Status != OrderStatusUntriggered || result.TriggerPrice != 98.0 {
+		t.Fatalf("Expected an untriggered stop at 98.00, got %s at %.2f", result.Status, result.TriggerPrice)
+	}
+
+	// A favourable move re-anchors the trigger
+	tradeAt(t, 103.0)
+	stop, ok := findStop("stop-1")
+	if !ok || stop.TriggerPrice != 101.0 {
+		t.Fatalf("Expected the trigger to follow up to 101.00, got %+v", stop)
+	}
+
+	// A move against it that stays above the trigger changes nothing
+	tradeAt(t, 102.0)
+	if stop, _ := findStop("stop-1"); stop.TriggerPrice != 101.0 {
+		t.Errorf("Expected the trigger to stay at 101.00, got %.2f", stop.TriggerPrice)
+	}
+
+	restOn(&bookFor("").bids,
+		Order{ID: "bid-resting", Side: SideBuy, Price: 95.0, Quantity: 10, Status: OrderStatusPending, CreatedAt: time.Now()})
+	tradesBefore := len(tradeStore.List(""))
+	tradeAt(t, 101.0)
+
+	if _, ok := findStop("stop-1"); ok {
+		t.Fatal("Expected stop-1 to trigger")
+	}
+
+	// The stop sold at market into the resting bid
+	fills := tradeStore.List("")[tradesBefore+1:]
+	if len(fills) != 1 || fills[0].TakerID != "stop-1" || fills[0].Price != 95.0 || fills[0].Quantity != 5 {
+		t.Errorf("Expected stop-1 to sell 5 at 95.00, got %+v", fills)
+	}
+}
+
+func TestTrailingStop_BuyPercent(t *testing.T) {
+	setupTest()
+	tradeAt(t, 100.0)
+
+	processOrder(trailingStop("stop-1", SideBuy, 1, 0, 10))
+	tradeAt(t, 90.0)
+
+	stop, ok := findStop("stop-1")
+	if !ok || stop.TriggerPrice != 99.0 {
+		t.Fatalf("Expected the trigger to follow down to 99.00, got %+v", stop)
+	}
+
+	restOn(&bookFor("").asks,
+		Order{ID: "ask-resting", Side: SideSell, Price: 105.0, Quantity: 1, Status: OrderStatusPending, CreatedAt: time.Now()})
+	tradeAt(t, 99.0)
+
+	if _, ok := findStop("stop-1"); ok {
+		t.Error("Expected stop-1 to trigger at 99.00")
+	}
+}
+
+func TestTrailingStop_LimitOffsetRests(t *testing.T) {
+	setupTest()
+	tradeAt(t, 100.0)
+
+	stop := trailingStop("stop-1", SideSell, 3, 1.0, 0)
+	offset := 0.5
+	stop.LimitOffset = &offset
+	processOrder(stop)
+
+	tradeAt(t, 99.0)
+
+	if len(bookFor("").asks.orders()) != 1 || bookFor("").asks.orders()[0].ID != "stop-1" {
+		t.Fatalf("Expected stop-1 to rest as a limit, got %+v", bookFor("").asks.orders())
+	}
+
+	resting := bookFor("").asks.orders()[0]
+	if resting.Type != OrderTypeLimit || resting.Price != 98.5 || resting.Status != OrderStatusPending {
+		t.Errorf("Expected a pending limit at 98.50, got %s %s at %.2f", resting.Status, resting.Type, resting.Price)
+	}
+}
+
+func TestTrailingStop_MarketWithoutLiquidityIsRejected(t *testing.T) {
+	setupTest()
+	tradeAt(t, 100.0)
+
+	processOrder(trailingStop("stop-1", SideSell, 3, 1.0, 0))
+	tradeAt(t, 99.0)
+
+	var last OrderEvent
+	for _, event := range orderEvents {
+		if event.OrderID == "stop-1" {
+			last = event
+		}
+	}
+
+	if last.To != OrderStatusRejected {
+		t.Errorf("Expected stop-1 to end rejected, got %+v", last)
+	}
+
+	if len(bookFor("").asks.orders()) != 0 {
+		t.Errorf("Expected a market order never to rest, got %+v", bookFor("").asks.orders())
+	}
+}
+
+func TestTrailingStop_WaitsForFirstTrade(t *testing.T) {
+	setupTest()
+
+	result := processOrder(trailingStop("stop-1", SideSell, 1, 1.0, 0))
+	if result.TriggerPrice != 0 {
+		t.Errorf("Expected no trigger before the symbol trades, got %.2f", result.TriggerPrice)
+	}
+
+	tradeAt(t, 50.0)
+	if stop, ok := findStop("stop-1"); !ok || stop.TriggerPrice != 49.0 {
+		t.Errorf("Expected the first trade to anchor the stop at 49.00, got %+v", stop)
+	}
+}
+
+func TestTrailingStop_Cancel(t *testing.T) {
+	setupTest()
+	processOrder(trailingStop("stop-1", SideSell, 1, 1.0, 0))
+
+	order, ok := cancelOrder("", "stop-1")
+	if !ok || order.Status != OrderStatusCancelled {
+		t.Errorf("Expected the stop to be cancelled, got %+v", order)
+	}
+
+	if len(bookFor("").stops) != 0 {
+		t.Errorf("Expected no stops left, got %d", len(bookFor("").stops))
+	}
+}
+
+func TestTrailingStop_Expires(t *testing.T) {
+	setupTest()
+
+	soon := time.Now().Add(time.Minute)
+	stop := trailingStop("stop-1", SideSell, 1, 1.0, 0)
+	stop.ExpiresAt = &soon
+	processOrder(stop)
+
+	expired := expireOrders(bookFor(""), soon)
+	if len(expired) != 1 || expired[0].ID != "stop-1" || expired[0].Status != OrderStatusExpired {
+		t.Errorf("Expected stop-1 to expire, got %+v", expired)
+	}
+}
+
+func TestPlaceOrderHandler_TrailingStop(t *testing.T) {
+	setupTest()
+
+	jsonData, _ := json.Marshal(PlaceOrderRequest{Side: SideSell, Quantity: 5, Type: OrderTypeTrailingStop, TrailPercent: 1.5})
+	request := httptest.NewRequest("POST", "/api/v1/orders", bytes.NewBuffer(jsonData))
+	response := httptest.NewRecorder()
+	placeOrderHandler(response, request)
+
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", response.Code, response.Body.String())
+	}
+
+	var result PlaceOrderResponse
+	json.Unmarshal(response.Body.Bytes(), &result)
+
+	if result.Status != OrderStatusUntriggered {
+		t.Errorf("Expected untriggered status, got %s", result.Status)
+	}
+
+	if orders := getAllOrders(); len(orders) != 1 || orders[0].Type != OrderTypeTrailingStop {
+		t.Errorf("Expected the stop in the order list, got %+v", orders)
+	}
+}
+
+func TestPlaceOrderHandler_TrailingStopValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		req  PlaceOrderRequest
+	}{
+		{"no trail", PlaceOrderRequest{Side: SideSell, Quantity: 1, Type: OrderTypeTrailingStop}},
+		{"both trails", PlaceOrderRequest{Side: SideSell, Quantity: 1, Type: OrderTypeTrailingStop, TrailAmount: 1, TrailPercent: 1}},
+		{"percent too high", PlaceOrderRequest{Side: SideSell, Quantity: 1, Type: OrderTypeTrailingStop, TrailPercent: 100}},
+		{"price set", PlaceOrderRequest{Side: SideSell, Quantity: 1, Price: 100, Type: OrderTypeTrailingStop, TrailAmount: 1}},
+		{"unknown type", PlaceOrderRequest{Side: SideSell, Quantity: 1, Price: 100, Type: "iceberg"}},
+	}
+
+	for _, test := range tests {
+		setupTest()
+
+		jsonData, _ := json.Marshal(test.req)
+		request := httptest.NewRequest("POST", "/api/v1/orders", bytes.NewBuffer(jsonData))
+		response := httptest.NewRecorder()
+		placeOrderHandler(response, request)
+
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", test.name, response.Code)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Strategy is a trading strategy run inside the engine on one symbol. Its
+// callbacks run on the symbol's matcher, one at a time, so the book they see
+// holds still and the orders they place are matched before they return.
+type Strategy interface {
+	// OnTrade is called with every trade on the symbol, the strategy's own
+	// fills included
+	OnTrade(h *StrategyHandle, trade Trade)
+	// OnBookUpdate is called when the symbol's depth has changed
+	OnBookUpdate(h *StrategyHandle, depth DepthSnapshot)
+	// OnTimer is called every -strategy-interval
+	OnTimer(h *StrategyHandle, now time.Time)
+}
+
+// StrategyFactory makes a strategy from the parameters it was started with
+type StrategyFactory func(params map[string]string) (Strategy, error)
+
+// StrategySpec is a registered strategy to run on a symbol
+type StrategySpec struct {
+	Name   string
+	Symbol string
+	Params map[string]string
+}
+
+// StrategyConfig is the strategies started with the server
+type StrategyConfig struct {
+	Strategies []StrategySpec
+	// Interval is how often each strategy's OnTimer is called
+	Interval time.Duration
+	// Depth is how many levels a side OnBookUpdate's depth has
+	Depth int
+}
+
+// StrategyStatus is a running strategy and what it has seen and done
+type StrategyStatus struct {
+	Name        string `json:"name"`
+	Symbol      string `json:"symbol"`
+	Owner       string `json:"owner"`
+	Trades      int64  `json:"trades"`
+	BookUpdates int64  `json:"book_updates"`
+	Timers      int64  `json:"timers"`
+	Orders      int64  `json:"orders"`
+	Cancels     int64  `json:"cancels"`
+}
+
+// StrategiesResponse lists the registered strategies and the running ones
+type StrategiesResponse struct {
+	Registered []string         `json:"registered"`
+	Running    []StrategyStatus `json:"running"`
+}
+
+// StrategyHandle is a strategy's way into the engine: order entry under its
+// own owner and a view of its book. It is only good inside the strategy's
+// callbacks.
+type StrategyHandle struct {
+	m      *matcher
+	owner  string
+	placed map[string]bool
+	status StrategyStatus
+	counts struct{ trades, bookUpdates, timers, orders, cancels atomic.Int64 }
+	// stopped is closed once the strategy's timer stops calling it, after
+	// its context is cancelled; nil if it has no timer
+	stopped chan struct{}
+}
+
+// runningStrategy is a strategy attached to its symbol's matcher
+type runningStrategy struct {
+	strategy Strategy
+	handle   *StrategyHandle
+}
+
+// strategyRounds caps how many times in one command strategies are told of
+// what their own orders did, so two strategies cannot trade back and forth
+// forever
+const strategyRounds = 16
+
+var (
+	strategyFactoriesMu sync.Mutex
+	strategyFactories   = make(map[string]StrategyFactory)
+
+	// strategiesRunning is set once any strategy is attached, so recordTrade
+	// only looks for them while one is
+	strategiesRunning atomic.Bool
+
+	runningStrategiesMu sync.Mutex
+	runningStrategies   []*StrategyHandle
+)
+
+// RegisterStrategy makes a strategy available to -strategies under name.
+// Strategies register themselves from an init function in their own file.
+func RegisterStrategy(name string, factory StrategyFactory) {
+	strategyFactoriesMu.Lock()
+	defer strategyFactoriesMu.Unlock()
+	strategyFactories[strings.ToLower(name)] = factory
+}
+
+// registeredStrategies returns the names strategies are registered under
+func registeredStrategies() []string {
+	strategyFactoriesMu.Lock()
+	defer strategyFactoriesMu.Unlock()
+	names := make([]string, 0, len(strategyFactories))
+	for name := range strategyFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// startStrategies makes each configured strategy, attaches it to its
+// symbol's matcher and calls its timer until ctx is cancelled
+func startStrategies(ctx context.Context, cfg StrategyConfig) error {
+	for _, spec := range cfg.Strategies {
+		strategyFactoriesMu.Lock()
+		factory, ok := strategyFactories[spec.Name]
+		strategyFactoriesMu.Unlock()
+		m, known := matcherFor(spec.Symbol)
+		if !ok || !known {
+			return fmt.Errorf("strategy %s on %q: not registered, or not a symbol", spec.Name, spec.Symbol)
+		}
+		strategy, err := factory(spec.Params)
+		if err != nil {
+			return fmt.Errorf("strategy %s on %s: %v", spec.Name, spec.Symbol, err)
+		}
+		attachStrategy(ctx, m, spec.Name, strategy, cfg)
+		log.Printf("strategy: running %s on %s", spec.Name, m.symbol)
+	}
+	return nil
+}
+
+// attachStrategy runs a strategy on a matcher, trading as strategy-<name>
+func attachStrategy(ctx context.Context, m *matcher, name string, strategy Strategy, cfg StrategyConfig) *StrategyHandle {
+	handle := &StrategyHandle{m: m, owner: "strategy-" + name, placed: make(map[string]bool)}
+	handle.status = StrategyStatus{Name: name, Symbol: m.symbol, Owner: handle.owner}
+	running := &runningStrategy{strategy: strategy, handle: handle}
+	strategiesRunning.Store(true)
+	m.do(func() {
+		m.strategies = append(m.strategies, running)
+		m.strategyDepth = max(m.strategyDepth, cfg.Depth)
+	})
+	runningStrategiesMu.Lock()
+	runningStrategies = append(runningStrategies, handle)
+	runningStrategiesMu.Unlock()
+
+	if cfg.Interval > 0 {
+		handle.stopped = make(chan struct{})
+		go func() {
+			defer close(handle.stopped)
+			runBot(ctx, m, cfg.Interval, func() {
+				handle.counts.timers.Add(1)
+				strategy.OnTimer(handle, engineClock.Now())
+			})
+		}()
+	}
+	return handle
+}
+
+// noteStrategyTrade keeps a trade for the strategies on its symbol, which
+// hear of it once the command that made it is done. It runs on the symbol's
+// matcher.
+func noteStrategyTrade(trade Trade) {
+	if !strategiesRunning.Load() {
+		return
+	}
+	if m, ok := matcherFor(trade.Symbol); ok && len(m.strategies) > 0 {
+		m.strategyTrades = append(m.strategyTrades, trade)
+	}
+}
+
+// notifyStrategies tells the matcher's strategies of the trades and depth
+// change the last command made, reporting whether it told them anything
+func (m *matcher) notifyStrategies(depthChanged bool) bool {
+	if len(m.strategies) == 0 || (len(m.strategyTrades) == 0 && !depthChanged) {
+		return false
+	}
+	trades := m.strategyTrades
+	m.strategyTrades = nil
+	for _, trade := range trades {
+		for _, running := range m.strategies {
+			running.handle.counts.trades.Add(1)
+			running.strategy.OnTrade(running.handle, trade)
+		}
+	}
+	if depthChanged {
+		depth := m.depthSnapshot(m.strategyDepth)
+		for _, running := range m.strategies {
+			running.handle.counts.bookUpdates.Add(1)
+			running.strategy.OnBookUpdate(running.handle, depth)
+		}
+	}
+	return true
+}
+
+// Symbol is the symbol the strategy trades
+func (h *StrategyHandle) Symbol() string {
+	return h.m.symbol
+}
+
+// Owner is the owner the strategy's orders are placed under
+func (h *StrategyHandle) Owner() string {
+	return h.owner
+}
+
+// Place sends an order for the strategy's symbol and returns it as matched.
+// The engine assigns its ID and owner.
+func (h *StrategyHandle) Place(order Order) Order {
+	order.ID = generateOrderID()
+	order.Symbol = h.m.symbol
+	order.Owner = h.owner
+	order.Status = OrderStatusPending
+	order.CreatedAt = engineClock.Now()
+	order.Timestamps = OrderTimestamps{}
+	h.counts.orders.Add(1)
+	h.placed[order.ID] = true
+	return processOrder(order)
+}
+
+// Cancel cancels one of the strategy's resting orders
+func (h *StrategyHandle) Cancel(orderID string) (Order, bool) {
+	if order, ok := bookedOrder(h.m.book, orderID); !ok || order.Owner != h.owner {
+		return Order{}, false
+	}
+	h.counts.cancels.Add(1)
+	return cancelOrder(h.m.symbol, orderID)
+}
+
+// Orders returns the strategy's resting orders
+func (h *StrategyHandle) Orders() []Order {
+	var orders []Order
+	for _, side := range [][]Order{h.m.book.bids.orders(), h.m.book.asks.orders(), h.m.book.stops, h.m.book.paper} {
+		for _, order := range side {
+			if order.Owner == h.owner {
+				orders = append(orders, order)
+			}
+		}
+	}
+	return orders
+}
+
+// Owns reports whether an order, such as one side of a trade, is the strategy's
+func (h *StrategyHandle) Owns(orderID string) bool {
+	return h.placed[orderID]
+}
+
+// Depth returns the top levels of the strategy's book; 0 returns all of them
+func (h *StrategyHandle) Depth(levels int) DepthSnapshot {
+	return h.m.depthSnapshot(levels)
+}
+
+// snapshot returns the strategy's status
+func (h *StrategyHandle) snapshot() StrategyStatus {
+	status := h.status
+	status.Trades = h.counts.trades.Load()
+	status.BookUpdates = h.counts.bookUpdates.Load()
+	status.Timers = h.counts.timers.Load()
+	status.Orders = h.counts.orders.Load()
+	status.Cancels = h.counts.cancels.Load()
+	return status
+}
+
+// parseStrategies reads -strategies symbol=name entries and the
+// -strategy-params name.key=value entries that go with them
+func parseStrategies(list, params string) ([]StrategySpec, error) {
+	byName := make(map[string]map[string]string)
+	for _, item := range splitList(params) {
+		key, value, ok := strings.Cut(item, "=")
+		name, param, named := strings.Cut(strings.TrimSpace(key), ".")
+		if !ok || !named || name == "" || param == "" {
+			return nil, fmt.Errorf("-strategy-params entry %q must be name.key=value", item)
+		}
+		name = strings.ToLower(name)
+		if byName[name] == nil {
+			byName[name] = make(map[string]string)
+		}
+		byName[name][param] = strings.TrimSpace(value)
+	}
+
+	registered := registeredStrategies()
+	var specs []StrategySpec
+	for _, item := range splitList(list) {
+		symbol, name, ok := strings.Cut(item, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !ok || strings.TrimSpace(symbol) == "" || name == "" {
+			return nil, fmt.Errorf("-strategies entry %q must be symbol=name", item)
+		}
+		if !slices.Contains(registered, name) {
+			return nil, fmt.Errorf("-strategies: no strategy is registered as %q (registered: %s)", name, strings.Join(registered, ", "))
+		}
+		specs = append(specs, StrategySpec{Name: name, Symbol: strings.ToUpper(strings.TrimSpace(symbol)), Params: byName[name]})
+	}
+	return specs, nil
+}
+
+// strategyStatuses returns the running strategies, by symbol and then name
+func strategyStatuses() []StrategyStatus {
+	runningStrategiesMu.Lock()
+	defer runningStrategiesMu.Unlock()
+	list := make([]StrategyStatus, 0, len(runningStrategies))
+	for _, handle := range runningStrategies {
+		list = append(list, handle.snapshot())
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].Symbol != list[j].Symbol {
+			return list[i].Symbol < list[j].Symbol
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// getStrategiesHandler lists the registered and running strategies
+func getStrategiesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(StrategiesResponse{Registered: registeredStrategies(), Running: strategyStatuses()})
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// fader sells into the best bid after every trade that is not its own
+type fader struct {
+	quantity int
+	depths   []DepthSnapshot
+	trades   []Trade
+	own      int
+	timers   int
+}
+
+func (f *fader) OnTrade(h *StrategyHandle, trade Trade) {
+	f.trades = append(f.trades, trade)
+	if h.Owns(trade.TakerID) || h.Owns(trade.MakerID) {
+		f.own++
+		return
+	}
+	if depth := h.Depth(1); len(depth.Bids) > 0 {
+		h.Place(Order{Side: SideSell, Price: depth.Bids[0].Price, Quantity: f.quantity, TimeInForce: TimeInForceIOC})
+	}
+}
+
+func (f *fader) OnBookUpdate(h *StrategyHandle, depth DepthSnapshot) {
+	f.depths = append(f.depths, depth)
+}
+
+func (f *fader) OnTimer(h *StrategyHandle, now time.Time) {
+	f.timers++
+}
+
+func init() {
+	RegisterStrategy("fader", func(params map[string]string) (Strategy, error) {
+		quantity, err := strconv.Atoi(params["quantity"])
+		if err != nil || quantity <= 0 {
+			return nil, errors.New("quantity must be a positive number")
+		}
+		return &fader{quantity: quantity}, nil
+	})
+}
+
+func TestStrategy_TradesOnTheEngine(t *testing.T) {
+	setupTest()
+	resetSymbols([]string{"BTC-USD"})
+	ctx, cancel := context.WithCancel(context.Background())
+	m, _ := matcherFor("BTC-USD")
+	strategy := &fader{quantity: 1}
+	handle := attachStrategy(ctx, m, "fader", strategy, StrategyConfig{Interval: time.Millisecond, Depth: 1})
+	// The timer must stop before the next test closes the matcher
+	t.Cleanup(func() {
+		cancel()
+		<-handle.stopped
+	})
+
+	processOn(m, Order{ID: "bid", Symbol: "BTC-USD", Side: SideBuy, Price: 100, Quantity: 5, Owner: "alice"})
+	processOn(m, Order{ID: "ask", Symbol: "BTC-USD", Side: SideSell, Price: 101, Quantity: 2, Owner: "bob"})
+	processOn(m, Order{ID: "lift", Symbol: "BTC-USD", Side: SideBuy, Price: 101, Quantity: 2, Owner: "carol"})
+
+	// The strategy's sell traded before the lifting order's command finished
+	trades := tradeStore.List("BTC-USD")
+	if len(trades) != 2 || trades[1].MakerID != "bid" || trades[1].Quantity != 1 || trades[1].Price != 100 {
+		t.Fatalf("Expected the strategy to sell 1 into the bid, got %+v", trades)
+	}
+	var seen []Trade
+	var depths []DepthSnapshot
+	var own int
+	m.do(func() { seen, depths, own = strategy.trades, strategy.depths, strategy.own })
+	if len(seen) != 2 || own != 1 {
+		t.Errorf("Expected the strategy to see both trades, one its own, got %+v", seen)
+	}
+	if len(depths) == 0 || len(depths[len(depths)-1].Bids) != 1 || depths[len(depths)-1].Bids[0].Quantity != 4 {
+		t.Errorf("Expected book updates one level deep ending with 4 bid, got %+v", depths)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for handle.snapshot().Timers == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the strategy's timer to run")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	response := serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/strategies", nil))
+	var list StrategiesResponse
+	json.NewDecoder(response.Body).Decode(&list)
+	if len(list.Running) != 1 || list.Running[0].Owner != "strategy-fader" || list.Running[0].Orders != 1 || list.Running[0].Trades != 2 {
+		t.Errorf("Expected the running strategy listed, got %+v", list)
+	}
+}
+
+func TestStrategy_CancelsOnlyItsOwnOrders(t *testing.T) {
+	setupTest()
+	resetSymbols([]string{"BTC-USD"})
+	m, _ := matcherFor("BTC-USD")
+	handle := attachStrategy(context.Background(), m, "fader", &fader{quantity: 1}, StrategyConfig{})
+	processOn(m, Order{ID: "other", Symbol: "BTC-USD", Side: SideBuy, Price: 99, Quantity: 1, Owner: "alice"})
+
+	var own Order
+	var cancelledOther, cancelledOwn bool
+	m.do(func() {
+		own = handle.Place(Order{Side: SideBuy, Price: 100, Quantity: 3})
+		_, cancelledOther = handle.Cancel("other")
+		if orders := handle.Orders(); len(orders) != 1 || orders[0].ID != own.ID {
+			t.Errorf("Expected only the strategy's order, got %+v", orders)
+		}
+		_, cancelledOwn = handle.Cancel(own.ID)
+	})
+	if own.Owner != "strategy-fader" || cancelledOther || !cancelledOwn {
+		t.Errorf("Expected the strategy to cancel only its own order, got %+v", own)
+	}
+}
+
+func TestLoadConfig_Strategies(t *testing.T) {
+	cfg, err := loadConfig([]string{"-symbols", "BTC-USD", "-strategies", "btc-usd=Fader", "-strategy-params", "fader.quantity=3", "-strategy-interval", "0"})
+	if err != nil || len(cfg.Strategies.Strategies) != 1 || cfg.Strategies.Strategies[0].Params["quantity"] != "3" ||
+		cfg.Strategies.Strategies[0].Symbol != "BTC-USD" || cfg.Strategies.Interval != 0 || cfg.Strategies.Depth != 10 {
+		t.Fatalf("Unexpected strategy config %+v (%v)", cfg.Strategies, err)
+	}
+	for _, args := range [][]string{
+		{"-strategies", "BTC-USD=unknown"},
+		{"-strategies", "fader"},
+		{"-strategies", "ETH-USD=fader"},
+		{"-strategy-params", "quantity=3"},
+		{"-strategy-depth", "-1"},
+	} {
+		if _, err := loadConfig(append([]string{"-symbols", "BTC-USD"}, args...)); err == nil {
+			t.Errorf("Expected an error for %v", args)
+		}
+	}
+
+	setupTest()
+	resetSymbols([]string{"BTC-USD"})
+	if err := startStrategies(context.Background(), StrategyConfig{Strategies: []StrategySpec{{Name: "fader", Symbol: "BTC-USD"}}}); err == nil {
+		t.Error("Expected the factory's error for a missing quantity")
+	}
+}
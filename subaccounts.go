@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// TransferRequest is the body for moving a balance between an account and
+// its parent or one of its own sub-accounts
+type TransferRequest struct {
+	To     string  `json:"to" validate:"required"`
+	Asset  string  `json:"asset" validate:"required"`
+	Amount float64 `json:"amount" validate:"gt=0"`
+}
+
+// SubAccountReport adds up an account and every account under it
+type SubAccountReport struct {
+	AccountID string `json:"account_id"`
+	// SubAccounts are every account under this one, each before its own
+	// sub-accounts
+	SubAccounts []Account `json:"sub_accounts"`
+	// Balances, Positions and OpenOrders are the account's together with
+	// those of every account under it. Positions are summed by symbol and
+	// valued at the mark, without an entry price.
+	Balances   map[string]float64 `json:"balances"`
+	Positions  []Position         `json:"positions"`
+	OpenOrders int                `json:"open_orders"`
+}
+
+// fundingAccountLocked returns the account whose balances, positions and
+// limits an owner trades on: its own, or for a sub-account that shares its
+// parent's, the nearest account above it that keeps its own. It must be
+// called with accountsMu held.
+func fundingAccountLocked(owner string) string {
+	for account := accounts[owner]; account != nil && account.SharesParent; account = accounts[owner] {
+		owner = account.ParentID
+	}
+	return owner
+}
+
+// riskGroupLocked returns the owners whose orders count against the same
+// funding account as the owner's: the owner, its funding account and every
+// sub-account sharing that account. It must be called with accountsMu held.
+func riskGroupLocked(owner string) map[string]bool {
+	funding := fundingAccountLocked(owner)
+	group := map[string]bool{owner: true, funding: true}
+	for id, account := range accounts {
+		if account.SharesParent && fundingAccountLocked(id) == funding {
+			group[id] = true
+		}
+	}
+	return group
+}
+
+// subAccountsLocked returns every account under id, each before its own
+// sub-accounts and siblings oldest first. It must be called with accountsMu
+// held.
+func subAccountsLocked(id string) []*Account {
+	var children []*Account
+	for _, account := range accounts {
+		if account.ParentID == id {
+			children = append(children, account)
+		}
+	}
+	sort.Slice(children, func(i, j int) bool {
+		if !children[i].CreatedAt.Equal(children[j].CreatedAt) {
+			return children[i].CreatedAt.Before(children[j].CreatedAt)
+		}
+		return children[i].ID < children[j].ID
+	})
+
+	var tree []*Account
+	for _, child := range children {
+		tree = append(tree, child)
+		tree = append(tree, subAccountsLocked(child.ID)...)
+	}
+	return tree
+}
+
+// accountTree returns the IDs of an account and every account under it, or
+// false if there is no such account
+func accountTree(id string) (map[string]bool, bool) {
+	accountsMu.Lock()
+	defer accountsMu.Unlock()
+	if _, ok := accounts[id]; !ok {
+		return nil, false
+	}
+	tree := map[string]bool{id: true}
+	for _, account := range subAccountsLocked(id) {
+		tree[account.ID] = true
+	}
+	return tree, true
+}
+
+// transferBalance moves amount of asset from one account to its parent or
+// one of its sub-accounts. Sub-accounts sharing their parent's balances keep
+// none to move.
+func transferBalance(from, to, asset string, amount float64) (BalanceChange, ErrorCode) {
+	accountsMu.Lock()
+	defer accountsMu.Unlock()
+
+	source, ok := accounts[from]
+	if !ok {
+		return BalanceChange{}, ErrCodeAccountNotFound
+	}
+	target, ok := accounts[to]
+	if !ok {
+		return BalanceChange{}, ErrCodeAccountNotFound
+	}
+	if source.ParentID != to && target.ParentID != from {
+		return BalanceChange{}, ErrCodeNotSubAccount
+	}
+	if source.SharesParent || target.SharesParent {
+		return BalanceChange{}, ErrCodeSharedSubAccount
+	}
+	if ledgerBalances[from][asset] < amount {
+		return BalanceChange{}, ErrCodeInsufficientFunds
+	}
+
+	entries := postLocked(BalanceTransfer, "", []posting{
+		{account: from, asset: asset, amount: -amount},
+		{account: to, asset: asset, amount: amount},
+	})
+	return entries[0].balanceChange(), ""
+}
+
+// subAccountReport adds up an account and every account under it, or
+// returns false if there is no such account
+func subAccountReport(id string) (SubAccountReport, bool) {
+	accountsMu.Lock()
+	if _, ok := accounts[id]; !ok {
+		accountsMu.Unlock()
+		return SubAccountReport{}, false
+	}
+	report := SubAccountReport{AccountID: id, SubAccounts: []Account{}, Balances: make(map[string]float64), Positions: []Position{}}
+	tree := map[string]bool{id: true}
+	for _, account := range subAccountsLocked(id) {
+		report.SubAccounts = append(report.SubAccounts, account.snapshot())
+		tree[account.ID] = true
+	}
+	for owner := range tree {
+		for asset, balance := range ledgerBalances[owner] {
+			report.Balances[asset] += balance
+		}
+	}
+	accountsMu.Unlock()
+
+	bySymbol := make(map[string]*Position)
+	for owner := range tree {
+		held, _ := accountPositions(owner)
+		for _, position := range held {
+			total, ok := bySymbol[position.Symbol]
+			if !ok {
+				total = &Position{Symbol: position.Symbol, MarkPrice: position.MarkPrice}
+				bySymbol[position.Symbol] = total
+			}
+			total.Quantity += position.Quantity
+			total.RealizedPnL += position.RealizedPnL
+			total.Funding += position.Funding
+			total.UnrealizedPnL += position.UnrealizedPnL
+			if position.UpdatedAt.After(total.UpdatedAt) {
+				total.UpdatedAt = position.UpdatedAt
+			}
+		}
+	}
+	for _, position := range bySymbol {
+		report.Positions = append(report.Positions, *position)
+	}
+	sort.Slice(report.Positions, func(i, j int) bool { return report.Positions[i].Symbol < report.Positions[j].Symbol })
+
+	for _, m := range allMatchers() {
+		m.do(func() {
+			for _, orders := range [][]Order{m.book.bids.orders(), m.book.asks.orders(), m.book.stops} {
+				for _, order := range orders {
+					if tree[order.Owner] {
+						report.OpenOrders++
+					}
+				}
+			}
+		})
+	}
+	return report, true
+}
+
+// transferHandler moves a balance from the account named in the path to its
+// parent or one of its sub-accounts
+func transferHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req TransferRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	id := r.PathValue("id")
+	change, code := transferBalance(id, req.To, req.Asset, req.Amount)
+	switch code {
+	case ErrCodeAccountNotFound:
+		if _, ok := lookupAccount(id); ok {
+			id = req.To
+		}
+		writeAccountNotFound(w, id)
+		return
+	case ErrCodeNotSubAccount:
+		writeError(w, http.StatusUnprocessableEntity, ErrCodeNotSubAccount, "Not a sub-account",
+			"'"+req.To+"' is neither the parent nor a sub-account of '"+id+"'")
+		return
+	case ErrCodeSharedSubAccount:
+		writeSharedSubAccount(w)
+		return
+	case ErrCodeInsufficientFunds:
+		writeError(w, http.StatusUnprocessableEntity, ErrCodeInsufficientFunds, "Insufficient funds",
+			"the account does not hold enough "+req.Asset+" to transfer")
+		return
+	}
+	json.NewEncoder(w).Encode(change)
+}
+
+// getSubAccountsHandler returns the accounts under the one named in the path
+// and what they hold together
+func getSubAccountsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id := r.PathValue("id")
+	report, ok := subAccountReport(id)
+	if !ok {
+		writeAccountNotFound(w, id)
+		return
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// cancelAccountOrdersHandler cancels every resting order and untriggered
+// stop of the account named in the path and of every account under it
+func cancelAccountOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id := r.PathValue("id")
+	tree, ok := accountTree(id)
+	if !ok {
+		writeAccountNotFound(w, id)
+		return
+	}
+
+	// The whole tree's cancel costs the parent a single cancel
+	if !throttleAllows(id, ThrottleCancel) {
+		writeThrottled(w, id, ThrottleCancel)
+		return
+	}
+
+	orders := cancelMatching("", func(order Order) bool { return tree[order.Owner] })
+	if orders == nil {
+		orders = []Order{}
+	}
+	json.NewEncoder(w).Encode(CancelBatchResponse{Orders: orders, Count: len(orders)})
+}
+
+// writeSharedSubAccount reports a balance movement on a sub-account that
+// trades on its parent's balances
+func writeSharedSubAccount(w http.ResponseWriter) {
+	writeError(w, http.StatusUnprocessableEntity, ErrCodeSharedSubAccount, "Shared sub-account",
+		"the sub-account trades on its parent's balances and keeps none of its own")
+}
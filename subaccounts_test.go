@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestSubAccounts_SharedSettleOnTheParent(t *testing.T) {
+	setupTest()
+	positionLimits = PositionLimitConfig{Limits: map[string]int{defaultSymbol: 5}}
+	openFunded(t, "desk", map[string]float64{"USD": 1000})
+	openFunded(t, "bob", map[string]float64{defaultSymbol: 10})
+	if response := postAccount("", `{"id":"trader","parent_id":"desk","shares_parent":true}`); response.Code != http.StatusCreated {
+		t.Fatalf("Expected the sub-account opened, got %d %s", response.Code, response.Body)
+	}
+	m, _ := matcherFor("")
+
+	placeOn(m, Order{ID: "ask", Symbol: defaultSymbol, Side: SideSell, Price: 100, Quantity: 2, Owner: "bob"})
+	if placed := processOn(m, Order{ID: "buy", Symbol: defaultSymbol, Side: SideBuy, Price: 100, Quantity: 2, Owner: "trader"}); placed.Status != OrderStatusFilled {
+		t.Fatalf("Expected the sub-account's buy filled, got %+v", placed)
+	}
+	if usd, base := ledgerBalances["desk"]["USD"], ledgerBalances["desk"][defaultSymbol]; usd != 800 || base != 2 {
+		t.Errorf("Expected the parent to pay 200 for 2, got %g USD and %g", usd, base)
+	}
+	if len(ledgerBalances["trader"]) != 0 {
+		t.Errorf("Expected nothing kept on the sub-account, got %v", ledgerBalances["trader"])
+	}
+
+	// The parent's position and its own resting orders count against the
+	// sub-account's order
+	processOn(m, Order{ID: "desk-bid", Symbol: defaultSymbol, Side: SideBuy, Price: 90, Quantity: 2, Owner: "desk"})
+	if placed := processOn(m, Order{ID: "more", Symbol: defaultSymbol, Side: SideBuy, Price: 90, Quantity: 2, Owner: "trader"}); placed.RejectReason != ErrCodePositionLimit {
+		t.Errorf("Expected POSITION_LIMIT_EXCEEDED across the parent and sub-account, got %+v", placed)
+	}
+
+	response := postAccount("/trader/deposit", `{"asset":"USD","amount":10}`)
+	if result := decodeError(t, response); response.Code != http.StatusUnprocessableEntity || result.Error.Code != ErrCodeSharedSubAccount {
+		t.Errorf("Expected 422 SHARED_SUB_ACCOUNT, got %d %s", response.Code, result.Error.Code)
+	}
+}
+
+func TestSubAccounts_CarvedOutTransfersReportAndCancel(t *testing.T) {
+	setupTest()
+	openFunded(t, "desk", map[string]float64{"USD": 1000})
+	openFunded(t, "bob", nil)
+	postAccount("", `{"id":"algo","parent_id":"desk","position_limits":{"`+defaultSymbol+`":1}}`)
+	postAccount("", `{"id":"algo-2","parent_id":"algo","shares_parent":true}`)
+
+	response := postAccount("/desk/transfer", `{"to":"algo","asset":"USD","amount":300}`)
+	var change BalanceChange
+	json.NewDecoder(response.Body).Decode(&change)
+	if response.Code != http.StatusOK || change.Type != BalanceTransfer || change.Balance != 700 || ledgerBalances["algo"]["USD"] != 300 {
+		t.Fatalf("Expected 300 carved out to algo, got %d %+v", response.Code, change)
+	}
+	for _, tt := range []struct {
+		path, body string
+		want       ErrorCode
+	}{
+		{"/algo/transfer", `{"to":"bob","asset":"USD","amount":1}`, ErrCodeNotSubAccount},
+		{"/algo/transfer", `{"to":"desk","asset":"USD","amount":301}`, ErrCodeInsufficientFunds},
+		{"/algo/transfer", `{"to":"algo-2","asset":"USD","amount":1}`, ErrCodeSharedSubAccount},
+		{"/algo/transfer", `{"to":"nobody","asset":"USD","amount":1}`, ErrCodeAccountNotFound},
+	} {
+		if result := decodeError(t, postAccount(tt.path, tt.body)); result.Error.Code != tt.want {
+			t.Errorf("%s %s: expected %s, got %s", tt.path, tt.body, tt.want, result.Error.Code)
+		}
+	}
+
+	// The carved-out limit replaces the exchange's, which has none here
+	m, _ := matcherFor("")
+	if placed := processOn(m, Order{ID: "big", Symbol: defaultSymbol, Side: SideBuy, Price: 90, Quantity: 2, Owner: "algo-2"}); placed.RejectReason != ErrCodePositionLimit {
+		t.Errorf("Expected algo's own limit to refuse the order, got %+v", placed)
+	}
+	processOn(m, Order{ID: "desk-bid", Symbol: defaultSymbol, Side: SideBuy, Price: 90, Quantity: 5, Owner: "desk"})
+	processOn(m, Order{ID: "algo-bid", Symbol: defaultSymbol, Side: SideBuy, Price: 89, Quantity: 1, Owner: "algo-2"})
+	processOn(m, Order{ID: "bob-bid", Symbol: defaultSymbol, Side: SideBuy, Price: 88, Quantity: 1, Owner: "bob"})
+
+	var report SubAccountReport
+	json.NewDecoder(serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/accounts/desk/sub-accounts", nil)).Body).Decode(&report)
+	if len(report.SubAccounts) != 2 || report.SubAccounts[0].ID != "algo" || report.SubAccounts[1].ID != "algo-2" || !report.SubAccounts[1].SharesParent {
+		t.Errorf("Expected algo then algo-2, got %+v", report.SubAccounts)
+	}
+	if report.Balances["USD"] != 1000 || report.OpenOrders != 2 {
+		t.Errorf("Expected 1000 USD and 2 open orders across the tree, got %+v", report)
+	}
+
+	var cancelled CancelBatchResponse
+	json.NewDecoder(serve(HTTPConfig{}, httptest.NewRequest("DELETE", "/api/v1/accounts/desk/orders", nil)).Body).Decode(&cancelled)
+	if cancelled.Count != 2 {
+		t.Errorf("Expected the parent's and sub-account's orders cancelled, got %+v", cancelled)
+	}
+	if book := bookFor(defaultSymbol); len(book.bids.orders()) != 1 || book.bids.orders()[0].Owner != "bob" {
+		t.Errorf("Expected only bob's bid left, got %+v", book.bids.orders())
+	}
+}
+
+func TestSubAccounts_OpeningValidation(t *testing.T) {
+	setupTest()
+	openFunded(t, "desk", nil)
+	tests := []struct {
+		name string
+		req  CreateAccountRequest
+		want []string
+	}{
+		{"shared without a parent", CreateAccountRequest{SharesParent: true}, []string{"parent_id:required"}},
+		{"shared with limits", CreateAccountRequest{ParentID: "desk", SharesParent: true, PositionLimits: map[string]int{defaultSymbol: 5}}, []string{"position_limits:excluded"}},
+		{"non-positive limit", CreateAccountRequest{ParentID: "desk", PositionLimits: map[string]int{defaultSymbol: 0}}, []string{"position_limits:gt"}},
+	}
+	for _, tt := range tests {
+		if got := fieldRules(fieldErrors(t, createAccountHandler, tt.req)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+
+	if result := decodeError(t, postAccount("", `{"id":"orphan","parent_id":"nobody"}`)); result.Error.Code != ErrCodeAccountNotFound {
+		t.Errorf("Expected an unknown parent refused, got %s", result.Error.Code)
+	}
+	if result := decodeError(t, postAccount("", `{"id":"odd","parent_id":"desk","position_limits":{"ETH-USD":5}}`)); result.Error.Code != ErrCodeUnknownSymbol {
+		t.Errorf("Expected a limit on an unknown symbol refused, got %s", result.Error.Code)
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SurveillanceConfig sets when owners' trading raises surveillance alerts
+type SurveillanceConfig struct {
+	Enabled bool
+	// Window is how far back trades, fills and cancels are looked at, and how
+	// long an alert is held back from being raised again
+	Window time.Duration
+	// CancelRatio is the number of cancels per fill that raises an alert
+	CancelRatio float64
+	// LayerLevels is how many price levels one owner may hold on a side
+	// within LayerDistance of the best price, as a fraction of it, before an
+	// alert is raised
+	LayerLevels   int
+	LayerDistance float64
+}
+
+// AlertKind is the pattern a surveillance alert found
+type AlertKind string
+
+const (
+	// AlertWashTrade is two owners trading with each other in both directions
+	AlertWashTrade AlertKind = "wash_trade"
+	// AlertCancelRatio is an owner cancelling far more orders than it fills
+	AlertCancelRatio AlertKind = "cancel_ratio"
+	// AlertLayering is an owner stacking orders at several levels near the touch
+	AlertLayering AlertKind = "layering"
+)
+
+// AlertSeverity is how strongly an alert points at manipulation
+type AlertSeverity string
+
+const (
+	SeverityMedium AlertSeverity = "medium"
+	SeverityHigh   AlertSeverity = "high"
+)
+
+// EvidenceType is what an alert's evidence refers to
+type EvidenceType string
+
+const (
+	EvidenceTrade EvidenceType = "trade"
+	EvidenceOrder EvidenceType = "order"
+)
+
+// AlertEvidence is a trade or order an alert was raised on
+type AlertEvidence struct {
+	Type EvidenceType `json:"type"`
+	ID   string       `json:"id"`
+}
+
+// SurveillanceAlert is one suspicious pattern in an owner's trading
+type SurveillanceAlert struct {
+	ID       string          `json:"id"`
+	Kind     AlertKind       `json:"kind"`
+	Severity AlertSeverity   `json:"severity"`
+	Symbol   string          `json:"symbol"`
+	Owners   []string        `json:"owners"`
+	Message  string          `json:"message"`
+	Evidence []AlertEvidence `json:"evidence"`
+	At       time.Time       `json:"at"`
+}
+
+// SurveillanceAlertsResponse lists surveillance alerts, newest first
+type SurveillanceAlertsResponse struct {
+	Alerts []SurveillanceAlert `json:"alerts"`
+	Count  int                 `json:"count"`
+}
+
+const (
+	// surveillanceMinCancels is how many cancels an owner must make in the
+	// window before its cancel ratio is judged
+	surveillanceMinCancels = 10
+	// surveillanceEvidenceLimit caps the evidence kept on one alert
+	surveillanceEvidenceLimit = 20
+	// surveillanceAlertLimit is how many alerts are kept before the oldest go
+	surveillanceAlertLimit = 1000
+)
+
+// surveilledOrder is a fill or cancel of one of an owner's orders
+type surveilledOrder struct {
+	at      time.Time
+	orderID string
+	side    Side
+}
+
+// surveilledTrade is a trade between two owners, with the one that sold
+type surveilledTrade struct {
+	at       time.Time
+	tradeID  string
+	seller   string
+	quantity int
+}
+
+// surveillance is the configuration trading is surveilled under
+var surveillance SurveillanceConfig
+
+// surveillanceAlerts and what they are raised from are guarded by
+// surveillanceMu. Fills and cancels are keyed by owner and symbol, trades by
+// the pair of owners and symbol, and raised by each alert's kind, symbol and
+// owners.
+var (
+	surveillanceMu      sync.Mutex
+	surveillanceAlerts  []SurveillanceAlert
+	surveillanceFills   = make(map[string][]surveilledOrder)
+	surveillanceCancels = make(map[string][]surveilledOrder)
+	surveillanceTrades  = make(map[string][]surveilledTrade)
+	surveillanceRaised  = make(map[string]time.Time)
+)
+
+// withinWindow drops the entries of a list that are older than the window
+func withinWindow[T any](list []T, since time.Time, at func(T) time.Time) []T {
+	kept := list[:0]
+	for _, entry := range list {
+		if at(entry).After(since) {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+// raiseLocked records an alert unless the same one was raised within the
+// window. It must be called with surveillanceMu held.
+func raiseLocked(alert SurveillanceAlert) {
+	key := string(alert.Kind) + "\x00" + alert.Symbol + "\x00" + strings.Join(alert.Owners, "\x00")
+	if last, ok := surveillanceRaised[key]; ok && alert.At.Sub(last) < surveillance.Window {
+		return
+	}
+	surveillanceRaised[key] = alert.At
+	if len(alert.Evidence) > surveillanceEvidenceLimit {
+		alert.Evidence = alert.Evidence[len(alert.Evidence)-surveillanceEvidenceLimit:]
+	}
+	alert.ID = generateOrderID()
+	surveillanceAlerts = append(surveillanceAlerts, alert)
+	if n := len(surveillanceAlerts); n > surveillanceAlertLimit {
+		surveillanceAlerts = slices.Delete(surveillanceAlerts, 0, n-surveillanceAlertLimit)
+	}
+}
+
+// surveilTrade counts a trade's fills and raises a wash trade alert when its
+// two owners have traded with each other both ways within the window
+func surveilTrade(trade Trade, maker, taker Order) {
+	if !surveillance.Enabled || maker.Owner == "" || taker.Owner == "" {
+		return
+	}
+	surveillanceMu.Lock()
+	defer surveillanceMu.Unlock()
+
+	now := engineClock.Now()
+	since := now.Add(-surveillance.Window)
+	for _, order := range []Order{maker, taker} {
+		key := order.Owner + "\x00" + trade.Symbol
+		fills := withinWindow(surveillanceFills[key], since, func(o surveilledOrder) time.Time { return o.at })
+		surveillanceFills[key] = append(fills, surveilledOrder{at: now, orderID: order.ID, side: order.Side})
+	}
+
+	seller := maker.Owner
+	if maker.Side == SideBuy {
+		seller = taker.Owner
+	}
+	owners := []string{maker.Owner, taker.Owner}
+	slices.Sort(owners)
+	key := strings.Join(owners, "\x00") + "\x00" + trade.Symbol
+	trades := withinWindow(surveillanceTrades[key], since, func(t surveilledTrade) time.Time { return t.at })
+	trades = append(trades, surveilledTrade{at: now, tradeID: trade.ID, seller: seller, quantity: trade.Quantity})
+	surveillanceTrades[key] = trades
+
+	sold := make(map[string]int)
+	evidence := make([]AlertEvidence, 0, len(trades))
+	for _, t := range trades {
+		sold[t.seller] += t.quantity
+		evidence = append(evidence, AlertEvidence{Type: EvidenceTrade, ID: t.tradeID})
+	}
+	if maker.Owner == taker.Owner || (sold[owners[0]] > 0 && sold[owners[1]] > 0) {
+		severity := SeverityMedium
+		message := fmt.Sprintf("%s and %s traded %s with each other in both directions", owners[0], owners[1], trade.Symbol)
+		if maker.Owner == taker.Owner {
+			severity, message = SeverityHigh, fmt.Sprintf("%s traded %s with itself", maker.Owner, trade.Symbol)
+		} else if sold[owners[0]] == sold[owners[1]] {
+			// Trading the same quantity back and forth leaves neither owner with a position
+			severity = SeverityHigh
+		}
+		raiseLocked(SurveillanceAlert{
+			Kind:     AlertWashTrade,
+			Severity: severity,
+			Symbol:   trade.Symbol,
+			Owners:   slices.Compact(owners),
+			Message:  message,
+			Evidence: evidence,
+			At:       now,
+		})
+	}
+}
+
+// surveilCancel counts a cancelled resting order and raises an alert when its
+// owner has cancelled at least CancelRatio orders per fill within the window,
+// or has cancelled surveillanceMinCancels without a fill
+func surveilCancel(order Order) {
+	if !surveillance.Enabled || order.Owner == "" {
+		return
+	}
+	surveillanceMu.Lock()
+	defer surveillanceMu.Unlock()
+
+	now := engineClock.Now()
+	since := now.Add(-surveillance.Window)
+	key := order.Owner + "\x00" + order.Symbol
+	at := func(o surveilledOrder) time.Time { return o.at }
+	cancels := append(withinWindow(surveillanceCancels[key], since, at), surveilledOrder{at: now, orderID: order.ID, side: order.Side})
+	surveillanceCancels[key] = cancels
+	fills := withinWindow(surveillanceFills[key], since, at)
+	surveillanceFills[key] = fills
+
+	if len(cancels) < surveillanceMinCancels || (len(fills) > 0 && float64(len(cancels))/float64(len(fills)) < surveillance.CancelRatio) {
+		return
+	}
+	evidence := make([]AlertEvidence, 0, len(cancels))
+	for _, cancel := range cancels {
+		evidence = append(evidence, AlertEvidence{Type: EvidenceOrder, ID: cancel.orderID})
+	}
+	raiseLocked(SurveillanceAlert{
+		Kind:     AlertCancelRatio,
+		Severity: SeverityMedium,
+		Symbol:   order.Symbol,
+		Owners:   []string{order.Owner},
+		Message:  fmt.Sprintf("%s cancelled %d orders on %s against %d fills", order.Owner, len(cancels), order.Symbol, len(fills)),
+		Evidence: evidence,
+		At:       now,
+	})
+}
+
+// surveilLayering raises an alert when the owner of an order that has just
+// rested holds orders at LayerLevels or more prices near the best one on its
+// side. It is high severity if the owner was filled on the other side within
+// the window, as when the layers push the price towards its real order. It
+// must run on the book's matcher.
+func surveilLayering(book *OrderBook, order Order) {
+	if !surveillance.Enabled || order.Owner == "" {
+		return
+	}
+	orders := book.sideOf(order.Side).orders()
+	if len(orders) == 0 {
+		return
+	}
+
+	best := orders[0].Price
+	var evidence []AlertEvidence
+	levels := 0
+	lastPrice := math.NaN()
+	for _, resting := range orders {
+		if math.Abs(resting.Price-best) > math.Abs(best)*surveillance.LayerDistance {
+			break
+		}
+		if resting.Owner != order.Owner {
+			continue
+		}
+		if resting.Price != lastPrice {
+			levels++
+			lastPrice = resting.Price
+		}
+		evidence = append(evidence, AlertEvidence{Type: EvidenceOrder, ID: resting.ID})
+	}
+	if levels < surveillance.LayerLevels {
+		return
+	}
+
+	surveillanceMu.Lock()
+	defer surveillanceMu.Unlock()
+	now := engineClock.Now()
+	severity := SeverityMedium
+	for _, fill := range surveillanceFills[order.Owner+"\x00"+order.Symbol] {
+		if fill.side != order.Side && fill.at.After(now.Add(-surveillance.Window)) {
+			severity = SeverityHigh
+			evidence = append(evidence, AlertEvidence{Type: EvidenceOrder, ID: fill.orderID})
+		}
+	}
+	raiseLocked(SurveillanceAlert{
+		Kind:     AlertLayering,
+		Severity: severity,
+		Symbol:   order.Symbol,
+		Owners:   []string{order.Owner},
+		Message:  fmt.Sprintf("%s holds %s orders at %d price levels near the best %s on %s", order.Owner, order.Side, levels, order.Side, order.Symbol),
+		Evidence: evidence,
+		At:       now,
+	})
+}
+
+// getSurveillanceAlertsHandler lists surveillance alerts, newest first,
+// optionally filtered by symbol, owner, kind and severity
+func getSurveillanceAlertsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := r.URL.Query()
+	symbol, owner := query.Get("symbol"), query.Get("owner")
+	kind, severity := AlertKind(query.Get("kind")), AlertSeverity(query.Get("severity"))
+
+	surveillanceMu.Lock()
+	alerts := make([]SurveillanceAlert, 0)
+	for i := len(surveillanceAlerts) - 1; i >= 0; i-- {
+		alert := surveillanceAlerts[i]
+		if (symbol == "" || alert.Symbol == symbol) && (owner == "" || slices.Contains(alert.Owners, owner)) &&
+			(kind == "" || alert.Kind == kind) && (severity == "" || alert.Severity == severity) {
+			alerts = append(alerts, alert)
+		}
+	}
+	surveillanceMu.Unlock()
+
+	json.NewEncoder(w).Encode(SurveillanceAlertsResponse{Alerts: alerts, Count: len(alerts)})
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// enableSurveillance turns surveillance on with the default thresholds
+func enableSurveillance() {
+	surveillance = SurveillanceConfig{Enabled: true, Window: 10 * time.Minute, CancelRatio: 20, LayerLevels: 3, LayerDistance: 0.005}
+}
+
+// listAlerts fetches surveillance alerts with a compliance key
+func listAlerts(t *testing.T, query string) SurveillanceAlertsResponse {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/api/v1/surveillance/alerts?"+query, nil)
+	req.Header.Set("X-API-Key", "compliance-key")
+	result := serve(HTTPConfig{DropCopyKeys: []string{"compliance-key"}}, req)
+	if result.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", result.Code, result.Body.String())
+	}
+	var response SurveillanceAlertsResponse
+	json.NewDecoder(result.Body).Decode(&response)
+	return response
+}
+
+func TestSurveillance_FlagsOwnersTradingBackAndForth(t *testing.T) {
+	setupTest()
+	enableSurveillance()
+
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "ask-1", Side: SideSell, Price: 100.0, Quantity: 2, Owner: "alice"})
+	placeOn(m, Order{ID: "buy-1", Side: SideBuy, Price: 100.0, Quantity: 2, Owner: "bob"})
+	if alerts := listAlerts(t, ""); alerts.Count != 0 {
+		t.Fatalf("Expected no alert for one trade, got %+v", alerts)
+	}
+	placeOn(m, Order{ID: "ask-2", Side: SideSell, Price: 100.0, Quantity: 2, Owner: "bob"})
+	placeOn(m, Order{ID: "buy-2", Side: SideBuy, Price: 100.0, Quantity: 2, Owner: "alice"})
+
+	alerts := listAlerts(t, "owner=bob")
+	if alerts.Count != 1 || alerts.Alerts[0].Kind != AlertWashTrade || alerts.Alerts[0].Severity != SeverityHigh {
+		t.Fatalf("Expected a high wash trade alert, got %+v", alerts)
+	}
+	if evidence := alerts.Alerts[0].Evidence; len(evidence) != 2 || evidence[0].Type != EvidenceTrade {
+		t.Errorf("Expected both trades as evidence, got %+v", evidence)
+	}
+}
+
+func TestSurveillance_FlagsCancelRatioAndLayering(t *testing.T) {
+	setupTest()
+	enableSurveillance()
+
+	m, _ := matcherFor("")
+	for i := 0; i < surveillanceMinCancels; i++ {
+		id := fmt.Sprintf("far-%d", i)
+		placeOn(m, Order{ID: id, Side: SideBuy, Price: 50.0, Quantity: 1, Owner: "alice"})
+		m.do(func() { cancelOrder("DEFAULT", id) })
+	}
+	alerts := listAlerts(t, "kind=cancel_ratio")
+	if alerts.Count != 1 || len(alerts.Alerts[0].Evidence) != surveillanceMinCancels || alerts.Alerts[0].Owners[0] != "alice" {
+		t.Fatalf("Expected a cancel ratio alert with every cancel, got %+v", alerts)
+	}
+
+	// Bids stacked near the touch after selling look like a push on the price
+	placeOn(m, Order{ID: "ask-1", Side: SideSell, Price: 100.0, Quantity: 1, Owner: "alice"})
+	placeOn(m, Order{ID: "buy-1", Side: SideBuy, Price: 100.0, Quantity: 1, Owner: "bob"})
+	for i, price := range []float64{99.9, 99.8, 99.7, 99.6} {
+		placeOn(m, Order{ID: fmt.Sprintf("layer-%d", i), Side: SideBuy, Price: price, Quantity: 5, Owner: "alice"})
+	}
+	alerts = listAlerts(t, "kind=layering")
+	if alerts.Count != 1 || alerts.Alerts[0].Severity != SeverityHigh || len(alerts.Alerts[0].Evidence) != 4 {
+		t.Fatalf("Expected one high layering alert, got %+v", alerts)
+	}
+	if alerts := listAlerts(t, "severity=medium"); alerts.Count != 1 {
+		t.Errorf("Expected only the cancel ratio alert at medium, got %+v", alerts)
+	}
+}
+
+func TestSurveillance_OffAndRefused(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+	for i, price := range []float64{99.9, 99.8, 99.7} {
+		placeOn(m, Order{ID: fmt.Sprintf("layer-%d", i), Side: SideBuy, Price: price, Quantity: 5, Owner: "alice"})
+	}
+	if alerts := listAlerts(t, ""); alerts.Count != 0 {
+		t.Errorf("Expected no alerts with surveillance off, got %+v", alerts)
+	}
+	if result := serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/surveillance/alerts", nil)); result.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 without a compliance key, got %d", result.Code)
+	}
+	if _, err := loadConfig([]string{"-layering-levels", "1"}); err == nil {
+		t.Error("Expected a single layering level to be refused")
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// defaultSymbol receives orders that do not name a symbol
+var defaultSymbol = "DEFAULT"
+
+// matcher owns one symbol's book and applies every change to it on a single goroutine
+type matcher struct {
+	symbol   string
+	book     *OrderBook
+	commands chan command
+
+	// subscribers receive depth updates; only the matcher goroutine touches it
+	subscribers map[*depthSubscriber]struct{}
+
+	// prices are the analytics for the book's current sequence
+	prices PriceAnalytics
+
+	// history is the book's recorded depth, oldest first, when book history
+	// is on, and historySnapshots the indexes of its full snapshots
+	history          []recordedDepth
+	historySnapshots []int
+
+	// replicatedStops, replicatedLastPrice and replicatedMark are what
+	// replicas were last sent, so a command that leaves them alone sends nothing
+	replicatedStops     []Order
+	replicatedLastPrice float64
+	replicatedMark      MarkInputs
+
+	// strategies run on the matcher, hearing of the trades kept in
+	// strategyTrades and of depth changes, strategyDepth levels a side
+	strategies     []*runningStrategy
+	strategyTrades []Trade
+	strategyDepth  int
+
+	// refused counts the commands turned away because the queue was full
+	refused atomic.Int64
+
+	// view is the book as of the latest sequence for readers off the
+	// matcher, or nil while nobody is reading. viewReads counts those reads;
+	// viewSeenReads and viewUnread are how many the matcher has seen and the
+	// changes since one, and viewBids and viewAsks which sides changed since
+	// the view was published.
+	view          atomic.Pointer[bookView]
+	viewReads     atomic.Int64
+	viewSeenReads int64
+	viewUnread    int
+	viewBids      bool
+	viewAsks      bool
+}
+
+// command is a unit of work for a matcher and the channel its caller waits on
+type command struct {
+	fn   func()
+	done chan struct{}
+	// claim, for a command sent with a context, is where the matcher and the
+	// caller race to start or abandon it
+	claim *atomic.Int32
+	// trace, for a traced command, is its span in the queue; the matcher
+	// ends it on picking the command up and starts the engine's spans after it
+	trace *span
+}
+
+// donePool recycles the completion channels handed out by matcher.do
+var donePool = sync.Pool{
+	New: func() interface{} {
+		return make(chan struct{}, 1)
+	},
+}
+
+// initialBookCapacity is how many orders each side of a new book has room for
+// before it has to grow
+const initialBookCapacity = 1024
+
+var (
+	symbolsMu sync.RWMutex
+	matchers  map[string]*matcher
+)
+
+// historyMu guards the order event log, which every matcher appends to
+var historyMu sync.Mutex
+
+// resetSymbols stops any running matchers and starts a fresh one per symbol
+// over its book in orderStore. The first symbol becomes the default.
+func resetSymbols(symbols []string) {
+	symbolsMu.Lock()
+	defer symbolsMu.Unlock()
+
+	for _, m := range matchers {
+		close(m.commands)
+	}
+
+	defaultSymbol = symbols[0]
+	matchers = make(map[string]*matcher, len(symbols))
+	if readModel != nil {
+		books := make([]*OrderBook, 0, len(symbols))
+		for _, symbol := range symbols {
+			books = append(books, orderStore.Book(symbol))
+		}
+		readModel.reset(books, tradeStore.List(""))
+	}
+	for _, symbol := range symbols {
+		m := &matcher{
+			symbol:      symbol,
+			book:        orderStore.Book(symbol),
+			commands:    make(chan command, queueConfig.Depth),
+			subscribers: make(map[*depthSubscriber]struct{}),
+			prices:      PriceAnalytics{Symbol: symbol},
+		}
+		useLadder(m.book, tickLadders[symbol])
+		matchers[symbol] = m
+		go m.run()
+	}
+}
+
+// run applies commands in arrival order until the matcher is stopped,
+// publishing any depth change each one makes, then a view of the book and
+// where a call auction under way would uncross. Strategies hear of what the
+// command did before it finishes, and what their own orders did in turn.
+func (m *matcher) run() {
+	for cmd := range m.commands {
+		if !cmd.claimed() {
+			continue
+		}
+		cmd.trace.finish()
+		matched := cmd.trace.next("match")
+		m.book.trace = matched
+		cmd.fn()
+		m.book.trace = nil
+		matched.finish()
+
+		published := matched.next("publish")
+		for round := 0; ; round++ {
+			sequence := m.book.sequence
+			m.refreshMark()
+			m.replicate()
+			m.publishDepth()
+			if round == strategyRounds || !m.notifyStrategies(m.book.sequence != sequence) {
+				break
+			}
+		}
+		m.publishView()
+		m.publishIndicative()
+		published.finish()
+		cmd.done <- struct{}{}
+	}
+}
+
+// do runs fn on the matcher's goroutine and waits for it to finish.
+// fn must not call do on the same matcher.
+func (m *matcher) do(fn func()) {
+	done := donePool.Get().(chan struct{})
+	m.commands <- command{fn: fn, done: done}
+	<-done
+	donePool.Put(done)
+}
+
+// matcherFor returns the matcher for a symbol, treating an empty symbol as the default
+func matcherFor(symbol string) (*matcher, bool) {
+	symbolsMu.RLock()
+	defer symbolsMu.RUnlock()
+
+	if symbol == "" {
+		symbol = defaultSymbol
+	}
+	m, ok := matchers[symbol]
+	return m, ok
+}
+
+// allMatchers returns every matcher ordered by symbol
+func allMatchers() []*matcher {
+	symbolsMu.RLock()
+	defer symbolsMu.RUnlock()
+
+	result := make([]*matcher, 0, len(matchers))
+	for _, m := range matchers {
+		result = append(result, m)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].symbol < result[j].symbol
+	})
+	return result
+}
+
+// listSymbols returns every configured symbol in sorted order
+func listSymbols() []string {
+	var symbols []string
+	for _, m := range allMatchers() {
+		symbols = append(symbols, m.symbol)
+	}
+	return symbols
+}
+
+// expireAllSymbols drops expired orders from every book
+func expireAllSymbols() {
+	for _, m := range allMatchers() {
+		m.do(func() {
+			expireOrders(m.book, engineClock.Now())
+		})
+	}
+}
+
+// bookFor returns the book for a symbol. Callers must be running on that
+// symbol's matcher goroutine.
+func bookFor(symbol string) *OrderBook {
+	if m, ok := matcherFor(symbol); ok {
+		return m.book
+	}
+	return orderStore.Book(defaultSymbol)
+}
+
+// parseSymbols splits a comma-separated symbol list, dropping blanks and duplicates
+func parseSymbols(list string) []string {
+	seen := make(map[string]bool)
+	var symbols []string
+	for _, symbol := range strings.Split(list, ",") {
+		symbol = strings.ToUpper(strings.TrimSpace(symbol))
+		if symbol == "" || seen[symbol] {
+			continue
+		}
+		seen[symbol] = true
+		symbols = append(symbols, symbol)
+	}
+	return symbols
+}
+
+// SymbolsResponse lists the tradable symbols and the one used when an order
+// names none
+type SymbolsResponse struct {
+	Symbols []string `json:"symbols"`
+	Default string   `json:"default"`
+	Count   int      `json:"count"`
+	// Perpetuals are the symbols that trade as perpetual swaps
+	Perpetuals []string `json:"perpetuals,omitempty"`
+	// Pairs say what each symbol trades, in the order of Symbols
+	Pairs []Pair `json:"pairs" since:"3"`
+}
+
+// getSymbolsHandler lists the symbols the server accepts orders for
+func getSymbolsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	symbols := listSymbols()
+	json.NewEncoder(w).Encode(SymbolsResponse{
+		Symbols:    symbols,
+		Default:    defaultSymbol,
+		Count:      len(symbols),
+		Perpetuals: perpetualSymbols(),
+		Pairs:      symbolPairs(symbols),
+	})
+}
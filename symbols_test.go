@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// setupSymbols resets the engine with the given symbols, the first being the default
+func setupSymbols(symbols ...string) {
+	setupTest()
+	resetSymbols(symbols)
+}
+
+// placeViaHandler places an order through the HTTP handler and returns the recorder
+func placeViaHandler(req PlaceOrderRequest) *httptest.ResponseRecorder {
+	jsonData, _ := json.Marshal(req)
+	request := httptest.NewRequest("POST", "/api/v1/orders", bytes.NewBuffer(jsonData))
+	response := httptest.NewRecorder()
+	placeOrderHandler(response, request)
+	return response
+}
+
+func TestParseSymbols(t *testing.T) {
+	symbols := parseSymbols(" btc-usd, ETH-USD,,btc-usd ")
+
+	if len(symbols) != 2 || symbols[0] != "BTC-USD" || symbols[1] != "ETH-USD" {
+		t.Errorf("Unexpected symbols %v", symbols)
+	}
+}
+
+func TestLoadConfig_Symbols(t *testing.T) {
+	cfg, err := loadConfig([]string{"-symbols", "BTC-USD,ETH-USD"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(cfg.Symbols) != 2 || cfg.Symbols[0] != "BTC-USD" {
+		t.Errorf("Unexpected symbols %v", cfg.Symbols)
+	}
+
+	if _, err := loadConfig([]string{"-symbols", " , "}); err == nil {
+		t.Error("Expected error for an empty symbol list")
+	}
+}
+
+func TestPlaceOrderHandler_DefaultSymbol(t *testing.T) {
+	setupSymbols("BTC-USD", "ETH-USD")
+
+	response := placeViaHandler(PlaceOrderRequest{Side: SideBuy, Price: 100.0, Quantity: 10})
+
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", response.Code)
+	}
+
+	if len(bookFor("").bids.orders()) != 1 || bookFor("").bids.orders()[0].Symbol != "BTC-USD" {
+		t.Errorf("Expected order on the default symbol's book, got %+v", bookFor("").bids.orders())
+	}
+}
+
+func TestPlaceOrderHandler_UnknownSymbol(t *testing.T) {
+	setupSymbols("BTC-USD")
+
+	response := placeViaHandler(PlaceOrderRequest{Symbol: "DOGE-USD", Side: SideBuy, Price: 100.0, Quantity: 10})
+
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", response.Code)
+	}
+
+	if result := decodeError(t, response); result.Error.Code != ErrCodeUnknownSymbol {
+		t.Errorf("Expected code %s, got %s", ErrCodeUnknownSymbol, result.Error.Code)
+	}
+}
+
+func TestSymbols_BooksAreIsolated(t *testing.T) {
+	setupSymbols("BTC-USD", "ETH-USD")
+
+	placeViaHandler(PlaceOrderRequest{Symbol: "ETH-USD", Side: SideSell, Price: 100.0, Quantity: 10})
+	placeViaHandler(PlaceOrderRequest{Symbol: "BTC-USD", Side: SideBuy, Price: 101.0, Quantity: 10})
+
+	if len(tradeStore.List("")) != 0 {
+		t.Errorf("Expected no trades across symbols, got %d", len(tradeStore.List("")))
+	}
+
+	placeViaHandler(PlaceOrderRequest{Symbol: "ETH-USD", Side: SideBuy, Price: 101.0, Quantity: 4})
+
+	history := tradeStore.List("ETH-USD")
+	if len(history) != 1 || history[0].Symbol != "ETH-USD" {
+		t.Errorf("Expected one ETH-USD trade, got %+v", history)
+	}
+
+	if len(tradeStore.List("BTC-USD")) != 0 {
+		t.Error("Expected no BTC-USD trades")
+	}
+}
+
+func TestGetOrderBookHandler_Symbol(t *testing.T) {
+	setupSymbols("BTC-USD", "ETH-USD")
+
+	placeViaHandler(PlaceOrderRequest{Symbol: "ETH-USD", Side: SideSell, Price: 100.0, Quantity: 10})
+
+	request := httptest.NewRequest("GET", "/api/v1/orderbook?symbol=ETH-USD", nil)
+	response := httptest.NewRecorder()
+	getOrderBookHandler(response, request)
+
+	var result map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &result)
+
+	if result["symbol"] != "ETH-USD" || result["sell_count"].(float64) != 1 {
+		t.Errorf("Unexpected ETH-USD book %v", result)
+	}
+
+	request = httptest.NewRequest("GET", "/api/v1/orderbook?symbol=XRP-USD", nil)
+	response = httptest.NewRecorder()
+	getOrderBookHandler(response, request)
+
+	if response.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown symbol, got %d", response.Code)
+	}
+}
+
+func TestGetOrdersHandler_SymbolFilter(t *testing.T) {
+	setupSymbols("BTC-USD", "ETH-USD")
+
+	placeViaHandler(PlaceOrderRequest{Symbol: "BTC-USD", Side: SideBuy, Price: 99.0, Quantity: 1})
+	placeViaHandler(PlaceOrderRequest{Symbol: "ETH-USD", Side: SideBuy, Price: 99.0, Quantity: 1})
+	placeViaHandler(PlaceOrderRequest{Symbol: "ETH-USD", Side: SideSell, Price: 101.0, Quantity: 1})
+
+	for query, expected := range map[string]float64{"": 3, "?symbol=ETH-USD": 2, "?symbol=BTC-USD": 1} {
+		request := httptest.NewRequest("GET", "/api/v1/orders"+query, nil)
+		response := httptest.NewRecorder()
+		getOrdersHandler(response, request)
+
+		var result map[string]interface{}
+		json.Unmarshal(response.Body.Bytes(), &result)
+		if result["count"].(float64) != expected {
+			t.Errorf("%q: expected %.0f orders, got %.0f", query, expected, result["count"].(float64))
+		}
+	}
+}
+
+func TestCancelOrderHandler_FindsOrderOnAnySymbol(t *testing.T) {
+	setupSymbols("BTC-USD", "ETH-USD")
+
+	response := placeViaHandler(PlaceOrderRequest{Symbol: "ETH-USD", Side: SideBuy, Price: 99.0, Quantity: 1})
+	var placed PlaceOrderResponse
+	json.Unmarshal(response.Body.Bytes(), &placed)
+
+	request := httptest.NewRequest("DELETE", "/api/v1/orders/"+placed.OrderID, nil)
+	cancelResponse := serve(HTTPConfig{}, request)
+
+	if cancelResponse.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", cancelResponse.Code)
+	}
+
+	if len(collectOrders("ETH-USD")) != 0 {
+		t.Error("Expected the ETH-USD order to be cancelled")
+	}
+}
+
+func TestGetSymbolsHandler(t *testing.T) {
+	setupSymbols("ETH-USD", "BTC-USD")
+
+	request := httptest.NewRequest("GET", "/api/v1/symbols", nil)
+	response := httptest.NewRecorder()
+	getSymbolsHandler(response, request)
+
+	var result map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &result)
+
+	if result["default"] != "ETH-USD" || result["count"].(float64) != 2 {
+		t.Errorf("Unexpected symbols response %v", result)
+	}
+}
+
+func TestMatchers_SymbolsMatchInParallel(t *testing.T) {
+	symbols := []string{"AAA", "BBB", "CCC", "DDD"}
+	setupSymbols(symbols...)
+
+	const ordersPerSymbol = 200
+	var wg sync.WaitGroup
+	for _, symbol := range symbols {
+		for _, side := range []Side{SideBuy, SideSell} {
+			wg.Add(1)
+			go func(symbol string, side Side) {
+				defer wg.Done()
+				m, _ := matcherFor(symbol)
+				for i := 0; i < ordersPerSymbol; i++ {
+					order := Order{
+						ID:        fmt.Sprintf("%s-%s-%d", symbol, side, i),
+						Symbol:    symbol,
+						Side:      side,
+						Price:     100.0,
+						Quantity:  1,
+						Status:    OrderStatusPending,
+						CreatedAt: time.Now(),
+					}
+					m.do(func() { processOrder(order) })
+				}
+			}(symbol, side)
+		}
+	}
+	wg.Wait()
+
+	// Every buy meets a sell at the same price, so each symbol trades out completely
+	for _, symbol := range symbols {
+		if got := len(tradeStore.List(symbol)); got != ordersPerSymbol {
+			t.Errorf("%s: expected %d trades, got %d", symbol, ordersPerSymbol, got)
+		}
+		if got := len(collectOrders(symbol)); got != 0 {
+			t.Errorf("%s: expected an empty book, got %d orders", symbol, got)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ThrottleAction is a kind of order message an account's throttle charges for
+type ThrottleAction string
+
+const (
+	ThrottlePlace  ThrottleAction = "place"
+	ThrottleAmend  ThrottleAction = "amend"
+	ThrottleCancel ThrottleAction = "cancel"
+	// ThrottleQuote is charged once for a whole mass quote
+	ThrottleQuote ThrottleAction = "quote"
+)
+
+// ThrottleConfig limits the weight of order messages each account may send
+type ThrottleConfig struct {
+	// Rate is the weight an account may send per second; zero turns
+	// throttling off
+	Rate float64
+	// Burst is the most weight an account can save up while it is quiet
+	Burst float64
+	// Weights is what each kind of message costs
+	Weights map[ThrottleAction]float64
+}
+
+// ThrottleState is an account's throttle as of now
+type ThrottleState struct {
+	Owner   string                     `json:"owner"`
+	Enabled bool                       `json:"enabled"`
+	Rate    float64                    `json:"rate"`
+	Burst   float64                    `json:"burst"`
+	Weights map[ThrottleAction]float64 `json:"weights"`
+	// Available is the weight the account can send right now
+	Available float64 `json:"available"`
+	// Refused counts the messages refused for lack of weight
+	Refused int       `json:"refused"`
+	At      time.Time `json:"at"`
+}
+
+// throttleBucket is an account's saved weight as of at
+type throttleBucket struct {
+	available float64
+	at        time.Time
+	refused   int
+}
+
+// defaultThrottleWeights makes cancels cheaper than the messages that add orders
+var defaultThrottleWeights = map[ThrottleAction]float64{
+	ThrottlePlace:  1,
+	ThrottleAmend:  1,
+	ThrottleCancel: 0.5,
+	ThrottleQuote:  1,
+}
+
+// throttle is the configuration account order messages are throttled under
+var throttle = ThrottleConfig{Weights: defaultThrottleWeights}
+
+// throttleBuckets is guarded by throttleMu
+var (
+	throttleMu      sync.Mutex
+	throttleBuckets = make(map[string]*throttleBucket)
+)
+
+// refillLocked tops the bucket up for the time since it was last charged. It
+// must be called with throttleMu held.
+func (b *throttleBucket) refillLocked(now time.Time) {
+	if elapsed := now.Sub(b.at).Seconds(); elapsed > 0 {
+		b.available = math.Min(throttle.Burst, b.available+elapsed*throttle.Rate)
+	}
+	b.at = now
+}
+
+// throttleAllows charges an owner's throttle for one message, or reports false
+// and charges nothing if the owner has not saved up its weight. Orders
+// without an owner, and every order while throttling is off, are allowed.
+func throttleAllows(owner string, action ThrottleAction) bool {
+	if throttle.Rate <= 0 || owner == "" {
+		return true
+	}
+	now := engineClock.Now()
+
+	throttleMu.Lock()
+	defer throttleMu.Unlock()
+	bucket, ok := throttleBuckets[owner]
+	if !ok {
+		bucket = &throttleBucket{available: throttle.Burst, at: now}
+		throttleBuckets[owner] = bucket
+	}
+	bucket.refillLocked(now)
+	weight := throttle.Weights[action]
+	if bucket.available < weight {
+		bucket.refused++
+		return false
+	}
+	bucket.available -= weight
+	return true
+}
+
+// throttleState describes an owner's throttle without charging it
+func throttleState(owner string) ThrottleState {
+	now := engineClock.Now()
+	state := ThrottleState{
+		Owner:     owner,
+		Enabled:   throttle.Rate > 0,
+		Rate:      throttle.Rate,
+		Burst:     throttle.Burst,
+		Weights:   throttle.Weights,
+		Available: throttle.Burst,
+		At:        now,
+	}
+
+	throttleMu.Lock()
+	defer throttleMu.Unlock()
+	if bucket, ok := throttleBuckets[owner]; ok {
+		bucket.refillLocked(now)
+		state.Available, state.Refused = bucket.available, bucket.refused
+	}
+	return state
+}
+
+// writeThrottled reports a message refused by the owner's throttle
+func writeThrottled(w http.ResponseWriter, owner string, action ThrottleAction) {
+	writeError(w, http.StatusTooManyRequests, ErrCodeThrottled, "Throttled",
+		fmt.Sprintf("owner '%s' has sent more order messages than its throttle allows; a %s costs %g", owner, action, throttle.Weights[action]))
+}
+
+// parseThrottleWeights reads action=weight pairs, such as cancel=0.5, over the
+// default weights
+func parseThrottleWeights(list string) (map[ThrottleAction]float64, error) {
+	weights := make(map[ThrottleAction]float64, len(defaultThrottleWeights))
+	for action, weight := range defaultThrottleWeights {
+		weights[action] = weight
+	}
+	for _, item := range splitList(list) {
+		name, raw, ok := strings.Cut(item, "=")
+		action := ThrottleAction(strings.ToLower(strings.TrimSpace(name)))
+		weight, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+		if _, known := defaultThrottleWeights[action]; !ok || !known || err != nil || weight < 0 {
+			return nil, fmt.Errorf("-throttle-weights entry %q must be action=weight with an action of place, amend, cancel or quote and a weight of at least 0", item)
+		}
+		weights[action] = weight
+	}
+	return weights, nil
+}
+
+// getThrottleHandler returns the throttle of the owner named in the path
+func getThrottleHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(throttleState(r.PathValue("owner")))
+}
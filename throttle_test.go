@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// placeAs places a resting buy over the REST API for an owner, returning the
+// order ID and the response status
+func placeAs(owner string, price float64) (string, int) {
+	body, _ := json.Marshal(PlaceOrderRequest{Side: SideBuy, Price: price, Quantity: 1, Owner: owner})
+	result := serve(HTTPConfig{}, httptest.NewRequest("POST", "/api/v1/orders", bytes.NewReader(body)))
+	var response struct {
+		OrderID string   `json:"order_id"`
+		Error   APIError `json:"error"`
+	}
+	json.NewDecoder(result.Body).Decode(&response)
+	return response.OrderID, result.Code
+}
+
+func TestThrottle_ChargesOrderMessagesByWeight(t *testing.T) {
+	setupTest()
+	clock := useDeterministicEngine(t, time.Date(2024, 1, 1, 9, 30, 0, 0, time.UTC))
+	throttle = ThrottleConfig{Rate: 2, Burst: 2, Weights: defaultThrottleWeights}
+
+	first, _ := placeAs("alice", 100)
+	second, _ := placeAs("alice", 99)
+	if _, status := placeAs("alice", 98); status != http.StatusTooManyRequests {
+		t.Fatalf("Expected a third order in the burst to be throttled, got %d", status)
+	}
+	if _, status := placeAs("bob", 98); status != http.StatusOK {
+		t.Errorf("Expected bob's throttle to be his own, got %d", status)
+	}
+
+	// Half a second buys back one order or two cancels
+	clock.Advance(500 * time.Millisecond)
+	if result := serve(HTTPConfig{}, httptest.NewRequest("DELETE", "/api/v1/orders/"+first, nil)); result.Code != http.StatusOK {
+		t.Fatalf("Expected the cancel to pass, got %d: %s", result.Code, result.Body)
+	}
+	if _, status := placeAs("alice", 97); status != http.StatusTooManyRequests {
+		t.Errorf("Expected an order to cost more than the weight left, got %d", status)
+	}
+	if result := serve(HTTPConfig{}, httptest.NewRequest("DELETE", "/api/v1/orders/"+second, nil)); result.Code != http.StatusOK {
+		t.Errorf("Expected a second cancel to pass, got %d", result.Code)
+	}
+
+	// Orders from inside the engine are not throttled
+	m, _ := matcherFor("")
+	if placed := processOn(m, Order{ID: "engine", Symbol: m.symbol, Side: SideBuy, Price: 90.0, Quantity: 1, Owner: "alice"}); placed.Status != OrderStatusPending {
+		t.Errorf("Expected an engine order to rest, got %+v", placed)
+	}
+	if result := serve(HTTPConfig{}, httptest.NewRequest("DELETE", "/api/v1/orders/engine", nil)); result.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the cancel to be throttled, got %d", result.Code)
+	}
+	if _, ok := restingOrder("engine"); !ok {
+		t.Error("Expected a throttled cancel to leave the order resting")
+	}
+
+	var state ThrottleState
+	json.NewDecoder(serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/throttle/alice", nil)).Body).Decode(&state)
+	if !state.Enabled || state.Available != 0 || state.Refused != 3 {
+		t.Errorf("Unexpected throttle %+v", state)
+	}
+	clock.Advance(time.Hour)
+	if state := throttleState("alice"); state.Available != 2 {
+		t.Errorf("Expected the saved weight capped at the burst, got %g", state.Available)
+	}
+}
+
+func TestThrottle_RefusesAmendsAndQuotes(t *testing.T) {
+	setupTest()
+	useDeterministicEngine(t, time.Date(2024, 1, 1, 9, 30, 0, 0, time.UTC))
+	throttle = ThrottleConfig{Rate: 1, Burst: 1, Weights: defaultThrottleWeights}
+
+	id, _ := placeAs("alice", 100)
+	response := callRPC(context.Background(), json.RawMessage(`{"jsonrpc": "2.0", "id": 1, "method": "order.amend", "params": {"order_id": "`+id+`", "quantity": 5}}`))
+	if response.Error == nil || response.Error.Data.Code != ErrCodeThrottled {
+		t.Errorf("Expected the amend to be throttled, got %+v", response)
+	}
+
+	body, _ := json.Marshal(MassQuoteRequest{Owner: "alice", Quotes: []Quote{{Side: SideSell, Price: 110, Quantity: 1}}})
+	if result := serve(HTTPConfig{}, httptest.NewRequest("POST", "/api/v1/orders/mass-quote", bytes.NewReader(body))); result.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the mass quote to be throttled, got %d", result.Code)
+	}
+}
+
+func TestLoadConfig_Throttle(t *testing.T) {
+	cfg, err := loadConfig([]string{"-order-rate", "10", "-throttle-weights", "cancel=0.1"})
+	if err != nil || cfg.Throttle.Burst != 10 || cfg.Throttle.Weights[ThrottleCancel] != 0.1 || cfg.Throttle.Weights[ThrottlePlace] != 1 {
+		t.Errorf("Unexpected throttle config %+v (%v)", cfg.Throttle, err)
+	}
+	for _, args := range [][]string{
+		{"-throttle-weights", "modify=1"},
+		{"-throttle-weights", "cancel=-1"},
+		{"-order-rate", "1", "-order-burst", "0.5"},
+		{"-order-rate", "-1"},
+	} {
+		if _, err := loadConfig(args); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
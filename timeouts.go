@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultRequestTimeout is how long a request may take by default
+const defaultRequestTimeout = 10 * time.Second
+
+// errEngineBusy is tryDo's refusal when the matcher's queue is full
+var errEngineBusy = errors.New("matcher queue is full")
+
+// A command sent with a context starts queued, and either the matcher claims
+// it to run or its caller abandons it, whichever comes first
+const (
+	commandQueued int32 = iota
+	commandStarted
+	commandAbandoned
+)
+
+// pendingCommand is a command sent with a context and what its caller waits on
+type pendingCommand struct {
+	done  chan struct{}
+	state atomic.Int32
+}
+
+// pendingPool recycles the pendingCommands of commands that ran
+var pendingPool = sync.Pool{
+	New: func() interface{} {
+		return &pendingCommand{done: make(chan struct{}, 1)}
+	},
+}
+
+// doContext runs fn on the matcher's goroutine and waits for it to finish or
+// for ctx to end. If ctx ends before the matcher starts fn, fn never runs
+// and ctx's error is returned; once fn has started it runs to completion and
+// is waited for. fn must not call do on the same matcher.
+func (m *matcher) doContext(ctx context.Context, fn func()) error {
+	p := pendingPool.Get().(*pendingCommand)
+	p.state.Store(commandQueued)
+	queued := m.queueSpan(ctx)
+	select {
+	case m.commands <- command{fn: fn, done: p.done, claim: &p.state, trace: queued}:
+	case <-ctx.Done():
+		pendingPool.Put(p)
+		queued.fail("abandoned")
+		queued.finish()
+		return ctx.Err()
+	}
+	return m.await(ctx, p, queued)
+}
+
+// tryDo is doContext that refuses at once with errEngineBusy, rather than
+// waiting, when the matcher's queue is full
+func (m *matcher) tryDo(ctx context.Context, fn func()) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	p := pendingPool.Get().(*pendingCommand)
+	p.state.Store(commandQueued)
+	queued := m.queueSpan(ctx)
+	select {
+	case m.commands <- command{fn: fn, done: p.done, claim: &p.state, trace: queued}:
+	default:
+		pendingPool.Put(p)
+		m.refused.Add(1)
+		queued.fail("engine busy")
+		queued.finish()
+		return errEngineBusy
+	}
+	return m.await(ctx, p, queued)
+}
+
+// await waits for a queued command to finish, abandoning it if ctx ends
+// before the matcher claims it
+func (m *matcher) await(ctx context.Context, p *pendingCommand, queued *span) error {
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		if p.state.CompareAndSwap(commandQueued, commandAbandoned) {
+			// The matcher never picks the command up to end its queue span
+			queued.fail("abandoned")
+			queued.finish()
+			// The matcher drops it unrun, so p is left to the collector
+			return ctx.Err()
+		}
+		<-p.done
+	}
+	pendingPool.Put(p)
+	return nil
+}
+
+// queueSpan starts the span a command traced by ctx spends in m's queue
+func (m *matcher) queueSpan(ctx context.Context) *span {
+	queued := spanFrom(ctx).child("queue")
+	queued.set("symbol", m.symbol)
+	queued.set("queue.length", len(m.commands))
+	return queued
+}
+
+// claimed reports whether the matcher should run cmd, marking it started; a
+// command whose caller has given up is skipped
+func (cmd command) claimed() bool {
+	return cmd.claim == nil || cmd.claim.CompareAndSwap(commandQueued, commandStarted)
+}
+
+// storeCall runs a call into a store, which takes no context of its own, on
+// another goroutine and waits for it or for ctx to end. A call cut short
+// carries on in the background, but its caller stops waiting for it.
+func storeCall[T any](ctx context.Context, fn func() T) (T, error) {
+	persisted := spanFrom(ctx).child("persist")
+	persisted.set("operation", "read")
+	defer persisted.finish()
+	if ctx.Done() == nil {
+		return fn(), nil
+	}
+	result := make(chan T, 1)
+	go func() {
+		result <- fn()
+	}()
+	select {
+	case value := <-result:
+		return value, nil
+	case <-ctx.Done():
+		persisted.fail(timeoutDetails(ctx.Err()))
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// requestTimeoutKey is the request context key for how long each call on a
+// streaming connection may take
+type requestTimeoutKey struct{}
+
+// requestTimeoutFrom returns how long each call on a streaming connection
+// may take, or zero when there is no limit
+func requestTimeoutFrom(ctx context.Context) time.Duration {
+	timeout, _ := ctx.Value(requestTimeoutKey{}).(time.Duration)
+	return timeout
+}
+
+// withRequestTimeout gives each request timeout to finish. A streaming
+// connection lives longer than any one request, so it is instead told the
+// timeout for each call it carries.
+func withRequestTimeout(timeout time.Duration, streaming bool) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if streaming {
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestTimeoutKey{}, timeout)))
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// callContext bounds one call on a streaming connection by the connection's
+// request timeout
+func callContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if timeout := requestTimeoutFrom(ctx); timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// timeoutDetails says why a request ended before the engine answered it
+func timeoutDetails(err error) string {
+	if errors.Is(err, context.Canceled) {
+		return "the request was cancelled before the engine answered"
+	}
+	return "the engine did not answer within the request timeout"
+}
+
+// writeRequestTimeout reports a request that ended before the engine
+// answered it. A command it sent that had not started by then never runs.
+func writeRequestTimeout(w http.ResponseWriter, err error) {
+	writeError(w, http.StatusGatewayTimeout, ErrCodeRequestTimeout, "Request timed out", timeoutDetails(err))
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parkMatcher holds a matcher on a command, returning a func that lets it go
+func parkMatcher(m *matcher) func() {
+	release, started := make(chan struct{}), make(chan struct{})
+	go m.do(func() {
+		close(started)
+		<-release
+	})
+	<-started
+	return func() { close(release) }
+}
+
+func TestRequestTimeout_AbandonsQueuedCommands(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "resting", Side: SideBuy, Price: 99, Quantity: 1})
+	release := parkMatcher(m)
+	cfg := HTTPConfig{RequestTimeout: 20 * time.Millisecond}
+
+	order := `{"side": "buy", "price": 100, "quantity": 1}`
+	response := serve(cfg, httptest.NewRequest("POST", "/api/v1/orders", strings.NewReader(order)))
+	if result := decodeError(t, response); response.Code != http.StatusGatewayTimeout || result.Error.Code != ErrCodeRequestTimeout {
+		t.Errorf("Expected the order to time out, got %d %s", response.Code, result.Error.Code)
+	}
+	response = serve(cfg, httptest.NewRequest("DELETE", "/api/v1/orders/resting", nil))
+	if result := decodeError(t, response); response.Code != http.StatusGatewayTimeout || result.Error.Code != ErrCodeRequestTimeout {
+		t.Errorf("Expected the cancel to time out, got %d %s", response.Code, result.Error.Code)
+	}
+
+	// Once the matcher is free the abandoned commands are skipped
+	release()
+	m.do(func() {
+		if len(m.book.bids.orders()) != 1 || m.book.bids.orders()[0].ID != "resting" || m.book.sequence != 1 {
+			t.Errorf("Expected only the resting bid, got %+v at sequence %d", m.book.bids.orders(), m.book.sequence)
+		}
+	})
+}
+
+func TestRequestTimeout_WaitsForStartedCommands(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+	ctx, cancel := context.WithCancel(context.Background())
+	ran := false
+	err := m.doContext(ctx, func() {
+		cancel()
+		time.Sleep(10 * time.Millisecond)
+		ran = true
+	})
+	if err != nil || !ran {
+		t.Errorf("Expected a started command waited for, got %v (ran %v)", err, ran)
+	}
+
+	if err := m.doContext(ctx, func() { t.Error("Expected no command sent on an ended context") }); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestRequestTimeout_RPCCalls(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+	release := parkMatcher(m)
+	defer release()
+
+	ctx := context.WithValue(context.Background(), requestTimeoutKey{}, 20*time.Millisecond)
+	raw, _ := json.Marshal(handleRPC(ctx, []byte(`{"jsonrpc": "2.0", "id": 1, "method": "order.place", "params": {"side": "buy", "price": 100, "quantity": 1}}`)))
+	if !strings.Contains(string(raw), `"data":{"code":"REQUEST_TIMEOUT"`) {
+		t.Errorf("Expected the call to time out, got %s", raw)
+	}
+
+	// The connection outlives the call
+	if ctx.Err() != nil {
+		t.Error("Expected the connection's context left running")
+	}
+}
+
+func TestStoreCall_StopsWaitingWhenTheContextEnds(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	unblock := make(chan struct{})
+	defer close(unblock)
+
+	start := time.Now()
+	if _, err := storeCall(ctx, func() int { <-unblock; return 1 }); err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the call abandoned at the deadline, took %v", elapsed)
+	}
+	if value, err := storeCall(context.Background(), func() int { return 2 }); value != 2 || err != nil {
+		t.Errorf("Expected 2, got %d (%v)", value, err)
+	}
+}
+
+func TestLoadConfig_RequestTimeout(t *testing.T) {
+	cfg, err := loadConfig(nil)
+	if err != nil || cfg.HTTP.RequestTimeout != defaultRequestTimeout {
+		t.Fatalf("Expected the default timeout, got %v (%v)", cfg.HTTP.RequestTimeout, err)
+	}
+	if cfg, _ := loadConfig([]string{"-request-timeout", "0"}); cfg.HTTP.RequestTimeout != 0 {
+		t.Errorf("Expected 0 to disable the timeout, got %v", cfg.HTTP.RequestTimeout)
+	}
+	if _, err := loadConfig([]string{"-request-timeout", "-1s"}); err == nil {
+		t.Error("Expected an error for a negative timeout")
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// TLSConfig controls HTTPS termination. Certificates come either from files
+// or from Let's Encrypt; neither leaves the server on plain HTTP.
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+
+	// AutocertDomains are the host names to request certificates for
+	AutocertDomains []string
+	// AutocertCache is the directory issued certificates are kept in
+	AutocertCache string
+	AutocertEmail string
+
+	// RedirectAddr serves plain HTTP that redirects to HTTPS and, with
+	// autocert, answers the ACME HTTP-01 challenge; empty disables it
+	RedirectAddr string
+}
+
+// enabled reports whether the server should terminate TLS itself
+func (c TLSConfig) enabled() bool {
+	return c.CertFile != "" || len(c.AutocertDomains) > 0
+}
+
+// validate rejects settings that cannot be served
+func (c TLSConfig) validate() error {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return errors.New("-tls-cert and -tls-key must be given together")
+	}
+	if c.CertFile != "" && len(c.AutocertDomains) > 0 {
+		return errors.New("-tls-cert and -autocert-domains cannot be combined")
+	}
+	return nil
+}
+
+// newHTTPServer builds the API server for cfg and, when TLS is on and a
+// redirect address is set, the plain-HTTP server that sends clients to it.
+// HTTP/2 is negotiated over TLS; the WebSocket stream keeps using HTTP/1.1,
+// which clients pick for the upgrade.
+func newHTTPServer(cfg Config) (*http.Server, *http.Server, error) {
+	server := &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           newServer(cfg.HTTP),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if !cfg.TLS.enabled() {
+		return server, nil, nil
+	}
+
+	var challenge http.Handler
+	if len(cfg.TLS.AutocertDomains) > 0 {
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.TLS.AutocertDomains...),
+			Cache:      autocert.DirCache(cfg.TLS.AutocertCache),
+			Email:      cfg.TLS.AutocertEmail,
+		}
+		server.TLSConfig = manager.TLSConfig()
+		challenge = manager.HTTPHandler(nil)
+	} else {
+		certs := &certReloader{certFile: cfg.TLS.CertFile, keyFile: cfg.TLS.KeyFile}
+		if _, err := certs.load(); err != nil {
+			return nil, nil, err
+		}
+		server.TLSConfig = &tls.Config{
+			GetCertificate: certs.GetCertificate,
+			NextProtos:     []string{"h2", "http/1.1"},
+		}
+		challenge = http.HandlerFunc(redirectToHTTPS)
+	}
+	server.TLSConfig.MinVersion = tls.VersionTLS12
+
+	var redirect *http.Server
+	if cfg.TLS.RedirectAddr != "" {
+		redirect = &http.Server{
+			Addr:              cfg.TLS.RedirectAddr,
+			Handler:           challenge,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+	}
+	return server, redirect, nil
+}
+
+// listenAndServe runs the API server, and the redirect server if there is
+// one, until either fails
+func listenAndServe(server, redirect *http.Server) error {
+	errs := make(chan error, 2)
+	if redirect != nil {
+		go func() { errs <- redirect.ListenAndServe() }()
+	}
+	go func() {
+		if server.TLSConfig != nil {
+			// The certificate comes from TLSConfig, so no files are passed here
+			errs <- server.ListenAndServeTLS("", "")
+			return
+		}
+		errs <- server.ListenAndServe()
+	}()
+	return <-errs
+}
+
+// redirectToHTTPS sends a plain-HTTP request to the same URL over HTTPS
+func redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
+
+// certReloader serves a certificate from files, reloading it when the files
+// change so a renewed certificate is picked up without a restart
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	modified time.Time
+	checked  time.Time
+}
+
+// certCheckInterval is how often the certificate files are checked for changes
+const certCheckInterval = time.Minute
+
+// GetCertificate implements tls.Config.GetCertificate
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.checked) < certCheckInterval {
+		return c.cert, nil
+	}
+	c.checked = time.Now()
+
+	info, err := os.Stat(c.certFile)
+	if err != nil || !info.ModTime().After(c.modified) {
+		return c.cert, nil
+	}
+	if _, err := c.loadLocked(); err != nil {
+		// Keep serving the old certificate until the new pair is complete
+		log.Printf("tls: keeping the current certificate: %v", err)
+	}
+	return c.cert, nil
+}
+
+// load reads the certificate and key files
+func (c *certReloader) load() (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.loadLocked()
+}
+
+func (c *certReloader) loadLocked() (*tls.Certificate, error) {
+	info, err := os.Stat(c.certFile)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	c.cert, c.modified, c.checked = &cert, info.ModTime(), time.Now()
+	return c.cert, nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// writeCert writes a self-signed certificate for 127.0.0.1 and its key into
+// dir and returns their paths and a pool that trusts the certificate
+func writeCert(t *testing.T, dir, name string) (string, string, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return certFile, keyFile, pool
+}
+
+// startTLS serves cfg's API over TLS on a free local port
+func startTLS(t *testing.T, cfg Config) string {
+	t.Helper()
+
+	server, _, err := newHTTPServer(cfg)
+	if err != nil {
+		t.Fatalf("Expected a server, got %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.ServeTLS(listener, "", "")
+	t.Cleanup(func() { server.Close() })
+	return listener.Addr().String()
+}
+
+func TestNewHTTPServer_ServesHTTP2OverTLS(t *testing.T) {
+	setupTest()
+	certFile, keyFile, pool := writeCert(t, t.TempDir(), "valhalla")
+	addr := startTLS(t, Config{TLS: TLSConfig{CertFile: certFile, KeyFile: keyFile}})
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: pool},
+		ForceAttemptHTTP2: true,
+	}}
+	response, err := client.Get("https://" + addr + "/api/v1/symbols")
+	if err != nil {
+		t.Fatalf("Expected an HTTPS response, got %v", err)
+	}
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusOK || response.ProtoMajor != 2 {
+		t.Errorf("Expected 200 over HTTP/2, got %d over %s", response.StatusCode, response.Proto)
+	}
+}
+
+func TestNewHTTPServer_StreamsDepthOverTLS(t *testing.T) {
+	setupTest()
+	certFile, keyFile, pool := writeCert(t, t.TempDir(), "valhalla")
+	addr := startTLS(t, Config{TLS: TLSConfig{CertFile: certFile, KeyFile: keyFile}})
+
+	dialer := websocket.Dialer{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	conn, _, err := dialer.Dial("wss://"+addr+"/api/v1/depth/stream", nil)
+	if err != nil {
+		t.Fatalf("Expected to connect over wss, got %v", err)
+	}
+
+	m, _ := matcherFor("")
+	defer closeStream(t, m, conn)
+	waitForSubscribers(t, m, 1)
+}
+
+func TestNewHTTPServer_Autocert(t *testing.T) {
+	cfg := Config{Addr: ":443", TLS: TLSConfig{
+		AutocertDomains: []string{"lob.example.com"},
+		AutocertCache:   t.TempDir(),
+		RedirectAddr:    ":80",
+	}}
+	server, redirect, err := newHTTPServer(cfg)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if server.TLSConfig == nil || !slices.Contains(server.TLSConfig.NextProtos, "h2") {
+		t.Errorf("Expected HTTP/2 to be offered, got %+v", server.TLSConfig)
+	}
+	if redirect == nil || redirect.Addr != ":80" {
+		t.Fatalf("Expected a redirect server on :80, got %+v", redirect)
+	}
+
+	// Anything but an ACME challenge is sent to HTTPS
+	response := httptest.NewRecorder()
+	redirect.Handler.ServeHTTP(response, httptest.NewRequest("GET", "http://lob.example.com/api/v1/orders", nil))
+	if location := response.Header().Get("Location"); location != "https://lob.example.com/api/v1/orders" {
+		t.Errorf("Expected a redirect to HTTPS, got %d %q", response.Code, location)
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	request := httptest.NewRequest("GET", "http://lob.example.com:8080/api/v1/trades?symbol=BTC-USD", nil)
+	response := httptest.NewRecorder()
+	redirectToHTTPS(response, request)
+
+	if response.Code != http.StatusMovedPermanently || response.Header().Get("Location") != "https://lob.example.com/api/v1/trades?symbol=BTC-USD" {
+		t.Errorf("Unexpected redirect %d %q", response.Code, response.Header().Get("Location"))
+	}
+}
+
+func TestCertReloader_PicksUpRenewedCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, _ := writeCert(t, dir, "first")
+	certs := &certReloader{certFile: certFile, keyFile: keyFile}
+	first, err := certs.load()
+	if err != nil {
+		t.Fatalf("Expected the certificate to load, got %v", err)
+	}
+
+	writeCert(t, dir, "second")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+
+	// Within the check interval the loaded certificate is reused
+	if cert, _ := certs.GetCertificate(nil); cert != first {
+		t.Errorf("Expected the first certificate before the next check")
+	}
+
+	certs.checked = time.Time{}
+	cert, _ := certs.GetCertificate(nil)
+	leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+	if leaf.Subject.CommonName != "second" {
+		t.Errorf("Expected the renewed certificate, got %s", leaf.Subject.CommonName)
+	}
+}
+
+func TestLoadConfig_TLSFlags(t *testing.T) {
+	cfg, err := loadConfig([]string{"-addr", ":443", "-autocert-domains", "a.example, b.example", "-http-redirect-addr", ":80"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !cfg.TLS.enabled() || strings.Join(cfg.TLS.AutocertDomains, ",") != "a.example,b.example" || cfg.TLS.AutocertCache == "" {
+		t.Errorf("Unexpected TLS config %+v", cfg.TLS)
+	}
+
+	invalid := [][]string{
+		{"-tls-cert", "cert.pem"},
+		{"-tls-cert", "cert.pem", "-tls-key", "key.pem", "-autocert-domains", "a.example"},
+	}
+	for _, args := range invalid {
+		if _, err := loadConfig(args); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
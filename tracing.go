@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// traceBatchSize is the most spans posted to the collector in one request
+const traceBatchSize = 512
+
+// traceQueueSize is how many finished spans wait for the exporter before
+// new ones are dropped
+const traceQueueSize = 4096
+
+// TracingConfig controls the spans exported to an OpenTelemetry collector
+type TracingConfig struct {
+	// Endpoint is the OTLP/HTTP traces URL spans are posted to, such as
+	// http://localhost:4318/v1/traces; empty turns tracing off
+	Endpoint string
+	// Service is the service.name every span is exported under
+	Service string
+	// SampleRatio is the fraction of new traces recorded. A request that
+	// carries a traceparent follows its caller's choice instead.
+	SampleRatio float64
+	Interval    time.Duration
+	Timeout     time.Duration
+}
+
+// OTLP span kinds
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+)
+
+// span is one timed step of a request. A nil span records nothing, so code
+// on the engine's hot path can start and finish spans whether or not the
+// request is traced.
+type span struct {
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	kind     int
+	sampled  bool
+	start    time.Time
+	end      time.Time
+	attrs    []spanAttr
+	failure  string
+	// exporter is the tracer the span's trace started under
+	exporter *spanExporter
+}
+
+// spanAttr is one attribute of a span: a string, int, int64, float64 or bool
+type spanAttr struct {
+	key   string
+	value interface{}
+}
+
+// spanExporter batches finished spans and posts them to the collector
+type spanExporter struct {
+	cfg     TracingConfig
+	client  *http.Client
+	spans   chan *span
+	dropped atomic.Int64
+}
+
+// tracer exports every sampled span; nil while tracing is off
+var tracer *spanExporter
+
+// defaultOTLPEndpoint is the traces URL the standard OpenTelemetry variables
+// name, or empty when they name none
+func defaultOTLPEndpoint() string {
+	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"); endpoint != "" {
+		return endpoint
+	}
+	if base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); base != "" {
+		return strings.TrimSuffix(base, "/") + "/v1/traces"
+	}
+	return ""
+}
+
+// defaultOTLPService is $OTEL_SERVICE_NAME, or valhalla
+func defaultOTLPService() string {
+	if service := os.Getenv("OTEL_SERVICE_NAME"); service != "" {
+		return service
+	}
+	return "valhalla"
+}
+
+// startTracing starts exporting spans when cfg has an endpoint
+func startTracing(ctx context.Context, cfg TracingConfig) {
+	if cfg.Endpoint == "" {
+		return
+	}
+	tracer = newSpanExporter(cfg)
+	go tracer.run(ctx)
+	log.Printf("tracing: exporting %g of traces to %s as %s", cfg.SampleRatio, cfg.Endpoint, cfg.Service)
+}
+
+func newSpanExporter(cfg TracingConfig) *spanExporter {
+	return &spanExporter{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+		spans:  make(chan *span, traceQueueSize),
+	}
+}
+
+// spanKey is the context key for the span a request is in
+type spanKey struct{}
+
+// spanFrom returns the span ctx is in, or nil
+func spanFrom(ctx context.Context) *span {
+	s, _ := ctx.Value(spanKey{}).(*span)
+	return s
+}
+
+// startSpan starts a span under the one ctx is in, or a new trace when ctx is
+// in none, returning a context in the new span. With tracing off it returns
+// ctx and a nil span.
+func startSpan(ctx context.Context, name string, kind int) (context.Context, *span) {
+	if tracer == nil {
+		return ctx, nil
+	}
+	s := spanFrom(ctx).child(name)
+	if s == nil {
+		s = &span{name: name, start: time.Now(), sampled: rand.Float64() < tracer.cfg.SampleRatio, exporter: tracer}
+		putRandom(s.traceID[:])
+		putRandom(s.spanID[:])
+	}
+	s.kind = kind
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// child starts a span under s, or returns nil when s is
+func (s *span) child(name string) *span {
+	if s == nil {
+		return nil
+	}
+	c := &span{traceID: s.traceID, parentID: s.spanID, name: name, kind: spanKindInternal, sampled: s.sampled, start: time.Now(), exporter: s.exporter}
+	putRandom(c.spanID[:])
+	return c
+}
+
+// next starts a span beside s, under the same parent, or returns nil when s is
+func (s *span) next(name string) *span {
+	if s == nil {
+		return nil
+	}
+	n := &span{traceID: s.traceID, parentID: s.parentID, name: name, kind: spanKindInternal, sampled: s.sampled, start: time.Now(), exporter: s.exporter}
+	putRandom(n.spanID[:])
+	return n
+}
+
+// set records an attribute on s
+func (s *span) set(key string, value interface{}) {
+	if s != nil && s.sampled {
+		s.attrs = append(s.attrs, spanAttr{key, value})
+	}
+}
+
+// fail marks s as having ended in an error
+func (s *span) fail(message string) {
+	if s != nil {
+		s.failure = message
+	}
+}
+
+// finish ends s and hands it to the exporter. A span must not be changed
+// once finished.
+func (s *span) finish() {
+	if s == nil || !s.sampled {
+		return
+	}
+	s.end = time.Now()
+	select {
+	case s.exporter.spans <- s:
+	default:
+		s.exporter.dropped.Add(1)
+	}
+}
+
+// putRandom fills an ID, 8 or 16 bytes long, with random bytes. OTLP treats
+// an all-zero ID as missing, so the last word is never zero.
+func putRandom(id []byte) {
+	for i := 0; i < len(id); i += 8 {
+		binary.BigEndian.PutUint64(id[i:], rand.Uint64()|1)
+	}
+}
+
+// parseTraceparent reads a W3C traceparent header into the remote span a
+// request continues, reporting false if the header is missing or malformed
+func parseTraceparent(header string) (*span, bool) {
+	fields := strings.Split(strings.TrimSpace(header), "-")
+	if len(fields) < 4 || len(fields[0]) != 2 || fields[0] == "ff" || len(fields[1]) != 32 || len(fields[2]) != 16 || len(fields[3]) != 2 {
+		return nil, false
+	}
+	remote := &span{}
+	var flags [1]byte
+	_, traceErr := hex.Decode(remote.traceID[:], []byte(fields[1]))
+	_, spanErr := hex.Decode(remote.spanID[:], []byte(fields[2]))
+	_, flagsErr := hex.Decode(flags[:], []byte(fields[3]))
+	if traceErr != nil || spanErr != nil || flagsErr != nil || remote.traceID == [16]byte{} || remote.spanID == [8]byte{} {
+		return nil, false
+	}
+	remote.sampled = flags[0]&1 == 1
+	return remote, true
+}
+
+// traceRoute puts each request to a route in a server span named after the
+// route, continuing the caller's trace when it sends a traceparent
+func traceRoute(method, path string) middleware {
+	name := method + " " + path
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			if remote, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+				remote.exporter = tracer
+				ctx = context.WithValue(ctx, spanKey{}, remote)
+			}
+			ctx, s := startSpan(ctx, name, spanKindServer)
+			s.set("http.request.method", r.Method)
+			s.set("http.route", path)
+			s.set("url.path", r.URL.Path)
+
+			recorder := recorderFor(w)
+			next.ServeHTTP(recorder, r.WithContext(ctx))
+			status := recorder.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			s.set("http.response.status_code", status)
+			if status >= http.StatusInternalServerError {
+				s.fail(http.StatusText(status))
+			}
+			s.finish()
+		})
+	}
+}
+
+// run posts batches of finished spans until ctx ends, sending a partial
+// batch every interval
+func (e *spanExporter) run(ctx context.Context) {
+	ticker := time.NewTicker(e.cfg.Interval)
+	defer ticker.Stop()
+
+	batch := make([]*span, 0, traceBatchSize)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case s := <-e.spans:
+			if batch = append(batch, s); len(batch) < traceBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		if err := e.export(batch); err != nil {
+			log.Printf("tracing: dropping %d spans: %v", len(batch), err)
+		}
+		batch = batch[:0]
+		if dropped := e.dropped.Swap(0); dropped > 0 {
+			log.Printf("tracing: dropped %d spans the exporter had no room for", dropped)
+		}
+	}
+}
+
+// export posts spans to the collector as an OTLP/HTTP JSON request
+func (e *spanExporter) export(spans []*span) error {
+	body, err := json.Marshal(otlpRequest(e.cfg.Service, spans))
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.cfg.Endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector answered %s", resp.Status)
+	}
+	return nil
+}
+
+// OTLP's JSON encoding of an export request, trimmed to what the engine sends
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID      string          `json:"traceId"`
+	SpanID       string          `json:"spanId"`
+	ParentSpanID string          `json:"parentSpanId,omitempty"`
+	Name         string          `json:"name"`
+	Kind         int             `json:"kind"`
+	Start        string          `json:"startTimeUnixNano"`
+	End          string          `json:"endTimeUnixNano"`
+	Attributes   []otlpAttribute `json:"attributes,omitempty"`
+	Status       *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+// otlpValue holds exactly one of its fields. OTLP sends 64-bit integers as
+// JSON strings.
+type otlpValue struct {
+	String *string  `json:"stringValue,omitempty"`
+	Int    *string  `json:"intValue,omitempty"`
+	Double *float64 `json:"doubleValue,omitempty"`
+	Bool   *bool    `json:"boolValue,omitempty"`
+}
+
+type otlpStatus struct {
+	// Code 2 is STATUS_CODE_ERROR
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// otlpRequest encodes spans as one export request from service
+func otlpRequest(service string, spans []*span) otlpTraces {
+	encoded := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		encoded[i] = otlpSpan{
+			TraceID: hex.EncodeToString(s.traceID[:]),
+			SpanID:  hex.EncodeToString(s.spanID[:]),
+			Name:    s.name,
+			Kind:    s.kind,
+			Start:   strconv.FormatInt(s.start.UnixNano(), 10),
+			End:     strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parentID != [8]byte{} {
+			encoded[i].ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		for _, attr := range s.attrs {
+			encoded[i].Attributes = append(encoded[i].Attributes, otlpAttr(attr.key, attr.value))
+		}
+		if s.failure != "" {
+			encoded[i].Status = &otlpStatus{Code: 2, Message: s.failure}
+		}
+	}
+	return otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: []otlpAttribute{otlpAttr("service.name", service)}},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "valhalla"}, Spans: encoded}},
+	}}}
+}
+
+// otlpAttr encodes one attribute, writing any type it does not know as a string
+func otlpAttr(key string, value interface{}) otlpAttribute {
+	var v otlpValue
+	switch value := value.(type) {
+	case string:
+		v.String = &value
+	case int:
+		s := strconv.Itoa(value)
+		v.Int = &s
+	case int64:
+		s := strconv.FormatInt(value, 10)
+		v.Int = &s
+	case float64:
+		v.Double = &value
+	case bool:
+		v.Bool = &value
+	default:
+		s := fmt.Sprint(value)
+		v.String = &s
+	}
+	return otlpAttribute{Key: key, Value: v}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// traceCollector starts tracing to a test OTLP endpoint, returning the spans
+// it receives
+func traceCollector(t *testing.T, ratio float64) <-chan otlpSpan {
+	t.Helper()
+	spans := make(chan otlpSpan, 256)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request otlpTraces
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" || json.NewDecoder(r.Body).Decode(&request) != nil {
+			t.Errorf("Unexpected export %s %s", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		for _, resource := range request.ResourceSpans {
+			if service := resource.Resource.Attributes[0]; service.Key != "service.name" || *service.Value.String != "valhalla-test" {
+				t.Errorf("Unexpected resource %+v", resource.Resource)
+			}
+			for _, scope := range resource.ScopeSpans {
+				for _, s := range scope.Spans {
+					spans <- s
+				}
+			}
+		}
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		collector.Close()
+	})
+	startTracing(ctx, TracingConfig{Endpoint: collector.URL + "/v1/traces", Service: "valhalla-test", SampleRatio: ratio, Interval: 10 * time.Millisecond, Timeout: time.Second})
+	return spans
+}
+
+// collectSpans waits for spans until one named last arrives
+func collectSpans(t *testing.T, spans <-chan otlpSpan, last string) map[string][]otlpSpan {
+	t.Helper()
+	byName := make(map[string][]otlpSpan)
+	for {
+		select {
+		case s := <-spans:
+			byName[s.Name] = append(byName[s.Name], s)
+			if s.Name == last {
+				return byName
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected a %s span, got %+v", last, byName)
+		}
+	}
+}
+
+// attr returns a span attribute's value as a string
+func attr(s otlpSpan, key string) string {
+	for _, a := range s.Attributes {
+		if a.Key != key {
+			continue
+		}
+		switch {
+		case a.Value.String != nil:
+			return *a.Value.String
+		case a.Value.Int != nil:
+			return *a.Value.Int
+		}
+	}
+	return ""
+}
+
+func TestTracing_OrderPlacementSpans(t *testing.T) {
+	setupTest()
+	spans := traceCollector(t, 0)
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "ask", Side: SideSell, Price: 100, Quantity: 5})
+
+	// The caller's trace is followed even though new traces are not sampled
+	request := httptest.NewRequest("POST", "/api/v1/orders", strings.NewReader(`{"side": "buy", "price": 100, "quantity": 2}`))
+	request.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	serve(HTTPConfig{}, request)
+
+	byName := collectSpans(t, spans, "POST /api/v1/orders")
+	server := byName["POST /api/v1/orders"][0]
+	if server.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || server.ParentSpanID != "00f067aa0ba902b7" || server.Kind != spanKindServer {
+		t.Errorf("Expected the server span to continue the caller's trace, got %+v", server)
+	}
+	if attr(server, "http.response.status_code") != "200" || attr(server, "http.route") != "/api/v1/orders" || attr(server, "order.status") != "filled" {
+		t.Errorf("Unexpected server span attributes %+v", server.Attributes)
+	}
+
+	for _, name := range []string{"validate", "queue", "match", "publish", "persist"} {
+		if len(byName[name]) == 0 {
+			t.Errorf("Expected a %s span, got %+v", name, byName)
+			continue
+		}
+		s := byName[name][0]
+		if s.TraceID != server.TraceID || s.Kind != spanKindInternal || s.End < s.Start {
+			t.Errorf("Unexpected %s span %+v", name, s)
+		}
+	}
+	match := byName["match"][0]
+	for _, s := range byName["persist"] {
+		switch attr(s, "operation") {
+		case "write":
+			if s.ParentSpanID != match.SpanID || attr(s, "trade.id") == "" {
+				t.Errorf("Expected the trade written under the match span, got %+v", s)
+			}
+		case "read":
+			if s.ParentSpanID != server.SpanID {
+				t.Errorf("Expected the trade read under the server span, got %+v", s)
+			}
+		}
+	}
+	if queued := byName["queue"][0]; queued.ParentSpanID != server.SpanID || attr(queued, "symbol") != "DEFAULT" {
+		t.Errorf("Unexpected queue span %+v", queued)
+	}
+}
+
+func TestTracing_FailuresAndSampling(t *testing.T) {
+	setupTest()
+	spans := traceCollector(t, 1)
+
+	serve(HTTPConfig{}, httptest.NewRequest("POST", "/api/v1/orders", strings.NewReader(`{"side": "up", "quantity": 1}`)))
+	byName := collectSpans(t, spans, "POST /api/v1/orders")
+	if validated := byName["validate"]; len(validated) != 1 || validated[0].Status == nil || validated[0].Status.Code != 2 || attr(validated[0], "validation.errors") == "" {
+		t.Errorf("Expected a failed validate span, got %+v", validated)
+	}
+	if len(byName["queue"]) != 0 {
+		t.Error("Expected an invalid order never queued")
+	}
+
+	// A caller that did not sample its trace gets nothing recorded
+	request := httptest.NewRequest("GET", "/api/v1/orderbook", nil)
+	request.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	serve(HTTPConfig{}, request)
+	handleRPC(context.Background(), []byte(`{"jsonrpc": "2.0", "id": 1, "method": "order.cancel", "params": {"order_id": "missing"}}`))
+	byName = collectSpans(t, spans, "order.cancel")
+	if len(byName["GET /api/v1/orderbook"]) != 0 {
+		t.Errorf("Expected the unsampled request left out, got %+v", byName)
+	}
+	if called := byName["order.cancel"][0]; called.Kind != spanKindServer || attr(called, "rpc.system") != "jsonrpc" || called.Status == nil {
+		t.Errorf("Expected a failed server span for the call, got %+v", called)
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	remote, ok := parseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if !ok || !remote.sampled || remote.spanID != [8]byte{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7} {
+		t.Fatalf("Unexpected remote span %+v (%v)", remote, ok)
+	}
+	for _, header := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e47zz-00f067aa0ba902b7-01",
+	} {
+		if _, ok := parseTraceparent(header); ok {
+			t.Errorf("Expected %q refused", header)
+		}
+	}
+}
+
+func TestLoadConfig_Tracing(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4318/")
+	cfg, err := loadConfig(nil)
+	if err != nil || cfg.Tracing.Endpoint != "http://collector:4318/v1/traces" || cfg.Tracing.Service != "valhalla" || cfg.Tracing.SampleRatio != 1 {
+		t.Fatalf("Unexpected tracing config %+v (%v)", cfg.Tracing, err)
+	}
+	for _, args := range [][]string{{"-otlp-sample", "1.5"}, {"-otlp-interval", "0"}, {"-otlp-timeout", "-1s"}} {
+		if _, err := loadConfig(args); err == nil {
+			t.Errorf("Expected an error for %v", args)
+		}
+	}
+}
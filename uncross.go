@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// uncrossWindow is how long after its scheduled end a call auction may run
+// on; zero uncrosses it on schedule
+var uncrossWindow time.Duration
+
+// uncrossDelay picks how far into the window an auction uncrosses. Tests
+// replace it to pin the instant.
+var uncrossDelay = func(window time.Duration) time.Duration {
+	return rand.N(window)
+}
+
+// randomUncross is the instant a call auction was drawn to uncross at, after
+// the end it was scheduled for
+type randomUncross struct {
+	scheduled time.Time
+	at        time.Time
+}
+
+// uncrossDue reports whether a call auction scheduled to end at scheduled may
+// uncross now. With an uncross window, the first check draws the instant in
+// the window it waits for, which is kept from participants so the end cannot
+// be traded against. It must run on the book's matcher.
+func uncrossDue(book *OrderBook, scheduled, now time.Time) bool {
+	if uncrossWindow <= 0 || !book.holding() {
+		return true
+	}
+	if book.uncross.at.IsZero() {
+		book.uncross = randomUncross{scheduled: scheduled, at: scheduled.Add(uncrossDelay(uncrossWindow))}
+	}
+	return !now.Before(book.uncross.at)
+}
+
+// recordUncross audits when a call auction with a random end uncrossed
+func recordUncross(symbol string, drawn randomUncross, now time.Time) {
+	recordEngineAudit(AuditAuctionUncross, fmt.Sprintf("%s auction uncrossed at %s, %s after its scheduled end at %s (drawn for %s)",
+		symbol, now.Format(time.RFC3339Nano), now.Sub(drawn.scheduled), drawn.scheduled.Format(time.RFC3339Nano), drawn.at.Format(time.RFC3339Nano)))
+}
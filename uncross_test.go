@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pinUncross sets an uncross window and the delay drawn within it
+func pinUncross(t *testing.T, window, delay time.Duration) {
+	t.Helper()
+	previous := uncrossDelay
+	uncrossWindow = window
+	uncrossDelay = func(time.Duration) time.Duration { return delay }
+	t.Cleanup(func() { uncrossDelay = previous })
+}
+
+// uncrossAudits lists the audited auction uncrosses
+func uncrossAudits(t *testing.T) []AuditEvent {
+	t.Helper()
+	var audit AuditEventsResponse
+	json.NewDecoder(serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/admin/audit?type=auction_uncross", nil)).Body).Decode(&audit)
+	return audit.Events
+}
+
+func TestUncrossWindow_OpeningAuctionRunsOnToTheDrawnInstant(t *testing.T) {
+	setupTest()
+	clock := useDeterministicEngine(t, time.Date(2026, 1, 2, 9, 0, 0, 0, time.UTC))
+	pinUncross(t, 30*time.Second, 12*time.Second)
+	schedule, err := parseTradingSchedule("08:00=pre_open,09:30=continuous,16:00=post_close", "UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	configureCalendars(schedule)
+	m, _ := matcherFor("")
+	processOn(m, Order{ID: "bid", Symbol: "DEFAULT", Side: SideBuy, Price: 101.0, Quantity: 2})
+	processOn(m, Order{ID: "ask", Symbol: "DEFAULT", Side: SideSell, Price: 99.0, Quantity: 1})
+
+	// Past the scheduled end the auction still collects orders
+	clock.Set(time.Date(2026, 1, 2, 9, 30, 11, 0, time.UTC))
+	if status := getMarketStatus(t); status.Phase != PhasePreOpen {
+		t.Fatalf("Expected the pre-open to run on into its window, got %+v", status)
+	}
+	processOn(m, Order{ID: "late-ask", Symbol: "DEFAULT", Side: SideSell, Price: 100.0, Quantity: 1})
+	if trades := tradeStore.List(""); len(trades) != 0 {
+		t.Fatalf("Expected nothing traded before the uncross, got %+v", trades)
+	}
+
+	clock.Set(time.Date(2026, 1, 2, 9, 30, 12, 0, time.UTC))
+	status := getMarketStatus(t)
+	if status.Phase != PhaseContinuous || status.Auction == nil || status.Auction.Volume != 2 || status.Auction.Orders != 3 {
+		t.Fatalf("Expected the opening auction of 2 at the drawn instant, got %+v", status)
+	}
+	audits := uncrossAudits(t)
+	if len(audits) != 1 || !audits[0].At.Equal(clock.Now()) || !strings.Contains(audits[0].Message, "12s after its scheduled end") {
+		t.Fatalf("Expected the realized uncross audited, got %+v", audits)
+	}
+	if audits[0].ClientIP != "" || audits[0].KeyID != "" {
+		t.Errorf("Expected the engine's event to have no caller, got %+v", audits[0])
+	}
+}
+
+func TestUncrossWindow_VolatilityAuctionAndOnSchedule(t *testing.T) {
+	setupTest()
+	clock := useDeterministicEngine(t, time.Date(2026, 1, 2, 9, 0, 0, 0, time.UTC))
+	volatility = VolatilityConfig{Move: 0.05, Window: 30 * time.Second, Pause: time.Minute, Action: VolatilityAuction}
+	pinUncross(t, 20*time.Second, 5*time.Second)
+	m, _ := matcherFor("")
+	processOn(m, Order{ID: "ask-1", Symbol: "DEFAULT", Side: SideSell, Price: 100.0, Quantity: 1})
+	processOn(m, Order{ID: "ask-2", Symbol: "DEFAULT", Side: SideSell, Price: 110.0, Quantity: 1})
+	processOn(m, Order{ID: "buy", Symbol: "DEFAULT", Side: SideBuy, Price: 110.0, Quantity: 2})
+	if status := getMarketStatus(t); status.Phase != PhaseAuction {
+		t.Fatalf("Expected a volatility auction, got %+v", status)
+	}
+
+	clock.Advance(time.Minute + 4*time.Second)
+	if status := getMarketStatus(t); status.Phase != PhaseAuction {
+		t.Fatalf("Expected the pause to run on into its window, got %+v", status)
+	}
+	clock.Advance(time.Second)
+	if status := getMarketStatus(t); status.Phase != PhaseContinuous {
+		t.Fatalf("Expected trading to reopen at the drawn instant, got %+v", status)
+	}
+	if audits := uncrossAudits(t); len(audits) != 1 || !audits[0].At.Equal(clock.Now()) {
+		t.Errorf("Expected the reopening audited, got %+v", audits)
+	}
+
+	// Without a window the auction uncrosses on schedule and nothing is audited
+	uncrossWindow = 0
+	processOn(m, Order{ID: "ask-3", Symbol: "DEFAULT", Side: SideSell, Price: 120.0, Quantity: 1})
+	processOn(m, Order{ID: "ask-4", Symbol: "DEFAULT", Side: SideSell, Price: 130.0, Quantity: 1})
+	processOn(m, Order{ID: "buy-2", Symbol: "DEFAULT", Side: SideBuy, Price: 130.0, Quantity: 2})
+	if status := getMarketStatus(t); status.Phase != PhaseAuction {
+		t.Fatalf("Expected a second volatility auction, got %+v", status)
+	}
+	clock.Advance(time.Minute)
+	if status := getMarketStatus(t); status.Phase != PhaseContinuous || len(uncrossAudits(t)) != 1 {
+		t.Errorf("Expected trading to reopen on schedule unaudited, got %+v", status)
+	}
+}
+
+func TestLoadConfig_UncrossWindow(t *testing.T) {
+	if cfg, err := loadConfig([]string{"-uncross-window", "30s"}); err != nil || cfg.UncrossWindow != 30*time.Second {
+		t.Errorf("Expected a 30s uncross window, got %v (%v)", cfg.UncrossWindow, err)
+	}
+	if _, err := loadConfig([]string{"-uncross-window", "-1s"}); err == nil {
+		t.Error("Expected a negative uncross window refused")
+	}
+}
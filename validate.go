@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FieldError is one problem with one field of a request body. Every write
+// endpoint reports validation failures as a list of these.
+type FieldError struct {
+	// Field is the JSON name of the offending field
+	Field string `json:"field"`
+	// Rule names the check that failed, e.g. "required" or "gt"
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// fieldError builds a FieldError with a formatted message
+func fieldError(field, rule, format string, args ...interface{}) FieldError {
+	return FieldError{Field: field, Rule: rule, Message: fmt.Sprintf(format, args...)}
+}
+
+// crossFieldValidator is implemented by requests with rules that depend on
+// more than one field. It runs after the field tags are checked.
+type crossFieldValidator interface {
+	validate() []FieldError
+}
+
+// validateRequest checks the validate tags on each field of the struct v
+// points to, then its cross-field rules.
+//
+// Tags hold comma-separated rules:
+//
+//	unless=F   skip the remaining rules when the field named F is set
+//	required   the field must not be empty; strings must not be blank
+//	omitempty  skip the remaining rules when the field is empty
+//	gt=N       numbers must be greater than N
+//	min=N      numbers must be at least N
+//	max=N      numbers must be at most N
+//	oneof=A B  strings must be one of the space-separated values
+//	duration   strings must parse as a positive Go duration
+func validateRequest(v interface{}) []FieldError {
+	value := reflect.ValueOf(v).Elem()
+	var errs []FieldError
+
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		tag := field.Tag.Get("validate")
+		if tag == "" {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" {
+			name = field.Name
+		}
+		rules := strings.Split(tag, ",")
+		if other, ok := strings.CutPrefix(rules[0], "unless="); ok {
+			if fieldSet(value, other) {
+				continue
+			}
+			rules = rules[1:]
+		}
+		if err, failed := checkField(name, value.Field(i), rules); failed {
+			errs = append(errs, err)
+		}
+	}
+
+	if validator, ok := v.(crossFieldValidator); ok {
+		errs = append(errs, validator.validate()...)
+	}
+	return errs
+}
+
+// fieldSet reports whether the field of a struct encoded as name is set
+func fieldSet(value reflect.Value, name string) bool {
+	for i := 0; i < value.NumField(); i++ {
+		if jsonFieldName(value.Type().Field(i)) == name {
+			return !value.Field(i).IsZero()
+		}
+	}
+	panic("validate: unknown field " + name)
+}
+
+// checkField applies rules to one field, stopping at the first that fails
+func checkField(name string, value reflect.Value, rules []string) (FieldError, bool) {
+	empty := value.IsZero()
+	if value.Kind() == reflect.String {
+		empty = strings.TrimSpace(value.String()) == ""
+	}
+
+	for _, rule := range rules {
+		rule, arg, _ := strings.Cut(rule, "=")
+		switch rule {
+		case "required":
+			if empty {
+				return fieldError(name, rule, "%s is required and cannot be empty", name), true
+			}
+		case "omitempty":
+			if empty {
+				return FieldError{}, false
+			}
+		case "gt", "min", "max":
+			limit, _ := strconv.ParseFloat(arg, 64)
+			number := numberOf(value)
+			switch {
+			case rule == "gt" && !(number > limit):
+				return fieldError(name, rule, "%s must be greater than %s (received: %s)", name, arg, formatValue(value)), true
+			case rule == "min" && !(number >= limit):
+				return fieldError(name, rule, "%s must be at least %s (received: %s)", name, arg, formatValue(value)), true
+			case rule == "max" && !(number <= limit):
+				return fieldError(name, rule, "%s must be at most %s (received: %s)", name, arg, formatValue(value)), true
+			}
+		case "oneof":
+			options := strings.Fields(arg)
+			if !slices.Contains(options, value.String()) {
+				return fieldError(name, rule, "%s must be one of '%s' (received: '%s')", name, strings.Join(options, "', '"), value.String()), true
+			}
+		case "duration":
+			if d, err := time.ParseDuration(value.String()); err != nil || d <= 0 {
+				return fieldError(name, rule, "%s must be a positive duration such as '30m' (received: '%s')", name, value.String()), true
+			}
+		default:
+			panic("validate: unknown rule " + rule + " on " + name)
+		}
+	}
+	return FieldError{}, false
+}
+
+// numberOf reads an integer or float field as a float64
+func numberOf(value reflect.Value) float64 {
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int())
+	case reflect.Float32, reflect.Float64:
+		return value.Float()
+	}
+	panic("validate: " + value.Kind().String() + " is not a number")
+}
+
+// formatValue prints a field's value for an error message
+func formatValue(value reflect.Value) string {
+	if value.Kind() == reflect.Float32 || value.Kind() == reflect.Float64 {
+		return strconv.FormatFloat(value.Float(), 'f', -1, 64)
+	}
+	return fmt.Sprint(value.Interface())
+}
+
+// decodeRequest reads a JSON or protobuf body into v, going by its
+// Content-Type, and validates it. On failure it writes the error response and
+// returns false.
+func decodeRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	validated := spanFrom(r.Context()).child("validate")
+	defer validated.finish()
+
+	if isProtobuf(r.Header.Get("Content-Type")) {
+		if !decodeProtoRequest(w, r, v) {
+			validated.fail("invalid protobuf body")
+			return false
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		errorMessage := "Invalid JSON format in request body"
+		if strings.Contains(err.Error(), "unexpected end of JSON input") || err.Error() == "EOF" {
+			errorMessage = "Request body is empty or incomplete"
+		} else if strings.Contains(err.Error(), "invalid character") {
+			errorMessage = "Request body contains invalid JSON syntax"
+		} else if strings.Contains(err.Error(), "cannot unmarshal") {
+			errorMessage = "Request body contains invalid data types"
+		}
+
+		validated.fail(errorMessage)
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidJSON, errorMessage, err.Error())
+		return false
+	}
+
+	if errs := validateRequest(v); len(errs) > 0 {
+		validated.fail("Validation failed")
+		validated.set("validation.errors", len(errs))
+		writeError(w, http.StatusBadRequest, ErrCodeValidationFailed, "Validation failed", errs)
+		return false
+	}
+	return true
+}
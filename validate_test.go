@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// taggedRequest exercises every validate rule
+type taggedRequest struct {
+	Name     string  `json:"name" validate:"required"`
+	Count    int     `json:"count" validate:"gt=0,max=10"`
+	Ratio    float64 `json:"ratio,omitempty" validate:"min=0.5"`
+	Kind     string  `json:"kind,omitempty" validate:"omitempty,oneof=a b"`
+	Horizon  string  `json:"horizon" validate:"duration"`
+	Untagged int     `json:"untagged"`
+}
+
+// fieldErrors posts body to handler and returns the field errors it reports
+func fieldErrors(t *testing.T, handler http.HandlerFunc, body interface{}) []FieldError {
+	t.Helper()
+
+	jsonData, _ := json.Marshal(body)
+	request := httptest.NewRequest("POST", "/", bytes.NewBuffer(jsonData))
+	response := httptest.NewRecorder()
+	handler(response, request)
+
+	var result struct {
+		Error struct {
+			Code    ErrorCode    `json:"code"`
+			Details []FieldError `json:"details"`
+		} `json:"error"`
+	}
+	json.NewDecoder(response.Body).Decode(&result)
+	if response.Code != http.StatusBadRequest || result.Error.Code != ErrCodeValidationFailed {
+		t.Fatalf("Expected a 400 validation failure, got %d %s", response.Code, result.Error.Code)
+	}
+	return result.Error.Details
+}
+
+// fieldRules reduces errors to field:rule pairs for comparison
+func fieldRules(errs []FieldError) []string {
+	var rules []string
+	for _, err := range errs {
+		rules = append(rules, err.Field+":"+err.Rule)
+	}
+	return rules
+}
+
+func TestValidateRequest_Rules(t *testing.T) {
+	tests := []struct {
+		name string
+		req  taggedRequest
+		want []string
+	}{
+		{"valid", taggedRequest{Name: "x", Count: 10, Ratio: 0.5, Kind: "b", Horizon: "1m"}, nil},
+		{"blank is missing", taggedRequest{Name: "  ", Count: 1, Ratio: 1, Horizon: "1m"}, []string{"name:required"}},
+		{"bounds", taggedRequest{Name: "x", Count: 11, Ratio: 0.25, Horizon: "1m"}, []string{"count:max", "ratio:min"}},
+		{"greater than", taggedRequest{Name: "x", Count: 0, Ratio: 1, Horizon: "1m"}, []string{"count:gt"}},
+		{"one of", taggedRequest{Name: "x", Count: 1, Ratio: 1, Kind: "c", Horizon: "1m"}, []string{"kind:oneof"}},
+		{"duration", taggedRequest{Name: "x", Count: 1, Ratio: 1, Horizon: "-1m"}, []string{"horizon:duration"}},
+	}
+
+	for _, tt := range tests {
+		if got := fieldRules(validateRequest(&tt.req)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestPlaceOrderHandler_FieldErrors(t *testing.T) {
+	setupTest()
+
+	errs := fieldErrors(t, placeOrderHandler, map[string]interface{}{"side": "", "quantity": 0, "price": 0, "time_in_force": "FOK"})
+
+	want := []string{"side:required", "quantity:gt", "time_in_force:oneof", "price:gt"}
+	if got := fieldRules(errs); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if errs[1].Message != "quantity must be greater than 0 (received: 0)" {
+		t.Errorf("Unexpected message %q", errs[1].Message)
+	}
+}
+
+func TestPlaceOrderHandler_CrossFieldErrors(t *testing.T) {
+	setupTest()
+
+	errs := fieldErrors(t, placeOrderHandler, PlaceOrderRequest{
+		Side: SideBuy, Quantity: 5, Type: OrderTypePegged, Peg: "best", MinQuantity: 6,
+	})
+
+	want := []string{"peg:oneof", "min_quantity:lte"}
+	if got := fieldRules(errs); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestWriteEndpoints_ShareFieldErrors(t *testing.T) {
+	setupTest()
+
+	errs := fieldErrors(t, startAlgoHandler, AlgoRequest{Side: SideSell, Quantity: 4, LimitPrice: 100, Horizon: "soon", VolumeProfile: []float64{1, 1}})
+	if got := fieldRules(errs); !reflect.DeepEqual(got, []string{"horizon:duration", "volume_profile:len"}) {
+		t.Errorf("Unexpected algo errors %v", got)
+	}
+}
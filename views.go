@@ -0,0 +1,102 @@
+package main
+
+import (
+	"time"
+)
+
+// viewIdleChanges is how many book changes a matcher keeps publishing views
+// through after the last read of one
+const viewIdleChanges = 1024
+
+// bookView is a book's resting orders as of one sequence number. Once
+// published a view never changes; a side that did not change since the view
+// before is shared with it rather than copied.
+type bookView struct {
+	sequence   int64
+	l3Sequence int64
+	buy        []Order
+	sell       []Order
+	// nextExpiry is the book's, so a reader can tell an order in the view
+	// may have expired since
+	nextExpiry time.Time
+}
+
+// readView returns the latest view of the matcher's book without waiting on
+// the matcher. Only when the matcher is not keeping views, or an order in
+// the view has reached its expiry, does it wait for the matcher to expire
+// orders and publish a current one.
+func (m *matcher) readView() *bookView {
+	m.viewReads.Add(1)
+	view := m.view.Load()
+	if view != nil && (view.nextExpiry.IsZero() || engineClock.Now().Before(view.nextExpiry)) {
+		return view
+	}
+	m.do(func() {
+		expireOrders(m.book, engineClock.Now())
+		m.publishDepth()
+		view = m.currentView()
+	})
+	return view
+}
+
+// currentView returns a view of the book as it stands, publishing one if the
+// latest is missing or behind. It must run on the matcher.
+func (m *matcher) currentView() *bookView {
+	book := m.book
+	if view := m.view.Load(); view != nil && view.sequence == book.sequence &&
+		view.l3Sequence == book.l3.sequence && view.nextExpiry.Equal(book.nextExpiry) {
+		return view
+	}
+	view := &bookView{
+		sequence:   book.sequence,
+		l3Sequence: book.l3.sequence,
+		buy:        book.bids.orders(),
+		sell:       book.asks.orders(),
+		nextExpiry: book.nextExpiry,
+	}
+	m.view.Store(view)
+	m.viewBids, m.viewAsks = false, false
+	m.viewSeenReads, m.viewUnread = m.viewReads.Load(), 0
+	return view
+}
+
+// publishView replaces the latest view once a command has changed the book,
+// reading again only the sides whose levels changed. A matcher nobody has read a
+// view from for viewIdleChanges changes stops keeping them until the next
+// read. It runs on the matcher goroutine after each command.
+func (m *matcher) publishView() {
+	previous := m.view.Load()
+	book := m.book
+	if previous == nil || (previous.sequence == book.sequence &&
+		previous.l3Sequence == book.l3.sequence && previous.nextExpiry.Equal(book.nextExpiry)) {
+		return
+	}
+
+	if reads := m.viewReads.Load(); reads != m.viewSeenReads {
+		m.viewSeenReads, m.viewUnread = reads, 0
+	} else if m.viewUnread++; m.viewUnread > viewIdleChanges {
+		m.view.Store(nil)
+		return
+	}
+
+	// A change that marked no level, such as a restore, may have touched either side
+	bids, asks := m.viewBids, m.viewAsks
+	if !bids && !asks {
+		bids, asks = true, true
+	}
+	view := &bookView{
+		sequence:   book.sequence,
+		l3Sequence: book.l3.sequence,
+		buy:        previous.buy,
+		sell:       previous.sell,
+		nextExpiry: book.nextExpiry,
+	}
+	if bids {
+		view.buy = book.bids.orders()
+	}
+	if asks {
+		view.sell = book.asks.orders()
+	}
+	m.view.Store(view)
+	m.viewBids, m.viewAsks = false, false
+}
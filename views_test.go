@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBookView_ReadsDoNotWaitOnTheMatcher(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "bid", Side: SideBuy, Price: 100, Quantity: 5})
+	placeOn(m, Order{ID: "ask", Side: SideSell, Price: 101, Quantity: 3})
+	m.readView()
+
+	release, started := make(chan struct{}), make(chan struct{})
+	go m.do(func() {
+		close(started)
+		<-release
+	})
+	<-started
+	defer close(release)
+
+	read := make(chan struct{})
+	go func() {
+		defer close(read)
+		response := serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/depth/snapshot", nil))
+		var snapshot DepthSnapshot
+		json.NewDecoder(response.Body).Decode(&snapshot)
+		if snapshot.Sequence != 2 || len(snapshot.Bids) != 1 || len(snapshot.Asks) != 1 || snapshot.Asks[0].Quantity != 3 {
+			t.Errorf("Unexpected depth %+v", snapshot)
+		}
+
+		response = serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/orderbook", nil))
+		var book OrderBookResponse
+		json.NewDecoder(response.Body).Decode(&book)
+		if book.BuyCount != 1 || book.SellCount != 1 || book.OrderBook.BuyOrders[0].ID != "bid" {
+			t.Errorf("Unexpected order book %+v", book)
+		}
+
+		response = serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/l3/snapshot", nil))
+		var l3 L3Snapshot
+		json.NewDecoder(response.Body).Decode(&l3)
+		if l3.Sequence != 2 || len(l3.Bids) != 1 || len(l3.Asks) != 1 {
+			t.Errorf("Unexpected L3 snapshot %+v", l3)
+		}
+	}()
+	select {
+	case <-read:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected reads served while the matcher was busy")
+	}
+}
+
+func TestBookView_CopiesOnlyTheSideThatChanged(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "bid", Side: SideBuy, Price: 100, Quantity: 5})
+	placeOn(m, Order{ID: "ask", Side: SideSell, Price: 101, Quantity: 3})
+	before := m.readView()
+
+	placeOn(m, Order{ID: "ask-2", Side: SideSell, Price: 102, Quantity: 1})
+	after := m.readView()
+	if after == before || after.sequence != before.sequence+1 {
+		t.Fatalf("Expected a new view one sequence on, got %d then %d", before.sequence, after.sequence)
+	}
+	if &after.buy[0] != &before.buy[0] {
+		t.Error("Expected the unchanged bids shared with the previous view")
+	}
+	if len(before.sell) != 1 || len(after.sell) != 2 {
+		t.Errorf("Expected the earlier view left as it was, got %d asks then %d", len(before.sell), len(after.sell))
+	}
+
+	// A command that changes nothing publishes nothing
+	m.do(func() {})
+	if m.readView() != after {
+		t.Error("Expected the same view while the book is unchanged")
+	}
+}
+
+func TestBookView_ExpiredOrdersLeaveTheView(t *testing.T) {
+	setupTest()
+	clock := useDeterministicEngine(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	m, _ := matcherFor("")
+	soon := clock.Now().Add(time.Minute)
+	m.do(func() {
+		processOrder(Order{ID: "bid", Side: SideBuy, Price: 100, Quantity: 5, Status: OrderStatusPending, CreatedAt: clock.Now(), ExpiresAt: &soon})
+	})
+	if view := m.readView(); len(view.buy) != 1 {
+		t.Fatalf("Expected the bid in the view, got %+v", view.buy)
+	}
+
+	clock.Advance(time.Minute)
+	if view := m.readView(); len(view.buy) != 0 || view.sequence != 2 {
+		t.Errorf("Expected the expired bid gone at sequence 2, got %d orders at %d", len(view.buy), view.sequence)
+	}
+}
+
+func TestBookView_StopsWhenUnread(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+	m.readView()
+	for i := 0; i <= viewIdleChanges; i++ {
+		placeOn(m, Order{Side: SideBuy, Price: 100, Quantity: 1})
+	}
+	if m.view.Load() != nil {
+		t.Fatal("Expected the matcher to stop keeping views nobody reads")
+	}
+	if view := m.readView(); view.sequence != viewIdleChanges+1 || len(view.buy) != viewIdleChanges+1 {
+		t.Errorf("Expected the next read to publish the current book, got %d orders at %d", len(view.buy), view.sequence)
+	}
+}
+
+func TestBookView_ConsistentUnderConcurrentOrders(t *testing.T) {
+	setupTest()
+	m, _ := matcherFor("")
+	m.readView()
+
+	// Each bid rests on a new level, so the sequence counts the bids
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			placeOn(m, Order{Side: SideBuy, Price: 100 - float64(i)*0.01, Quantity: 1})
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				snapshot := m.readView().depth(m.symbol, 0)
+				if int64(len(snapshot.Bids)) != snapshot.Sequence {
+					t.Errorf("Expected %d levels at sequence %d", snapshot.Sequence, len(snapshot.Bids))
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
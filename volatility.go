@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+// VolatilityAction is what a symbol does when its price moves too far too fast
+type VolatilityAction string
+
+const (
+	// VolatilityAuction collects orders during the pause and uncrosses them
+	// in an auction at its end
+	VolatilityAuction VolatilityAction = "auction"
+	// VolatilityHalt refuses new orders during the pause, then reopens with
+	// an auction of the orders already on the book
+	VolatilityHalt VolatilityAction = "halt"
+)
+
+// VolatilityConfig sets when a fast price move interrupts continuous trading
+type VolatilityConfig struct {
+	// Move is how far the trade price may move within Window, as a fraction
+	// of the earlier price, before trading is interrupted; zero turns the
+	// guard off
+	Move   float64
+	Window time.Duration
+	// Pause is how long an interruption lasts
+	Pause  time.Duration
+	Action VolatilityAction
+}
+
+// volatility is the volatility guard continuous symbols trade under
+var volatility VolatilityConfig
+
+// volatilityGuard is a book's recent trade prices and, while it has
+// interrupted trading, when the pause ends
+type volatilityGuard struct {
+	prices   []tradedPrice
+	resumeAt time.Time
+}
+
+// tradedPrice is the price of a local trade and when it printed
+type tradedPrice struct {
+	at    time.Time
+	price float64
+}
+
+// guardVolatility interrupts trading on a continuous book when a trade's
+// price has moved further than the guard allows from any trade within the
+// window. It must run on the book's matcher.
+func guardVolatility(book *OrderBook, trade Trade) {
+	if volatility.Move <= 0 || book.auction != nil {
+		return
+	}
+	guard := &book.volatility
+	guard.prices = withinWindow(guard.prices, trade.CreatedAt.Add(-volatility.Window), func(p tradedPrice) time.Time { return p.at })
+	for _, earlier := range guard.prices {
+		if math.Abs(trade.Price-earlier.price) > earlier.price*volatility.Move {
+			interruptTrading(book, trade, earlier.price)
+			return
+		}
+	}
+	guard.prices = append(guard.prices, tradedPrice{at: trade.CreatedAt, price: trade.Price})
+}
+
+// interruptTrading pauses a book for the configured time. Orders that reach
+// it meanwhile wait for the auction that reopens it, or are refused while it
+// is halted. It must run on the book's matcher.
+func interruptTrading(book *OrderBook, trade Trade, reference float64) {
+	until := engineClock.Now().Add(volatility.Pause)
+	phase := PhaseAuction
+	if volatility.Action == VolatilityHalt {
+		phase = PhaseHalted
+	}
+	err := transitionPhase(book, MarketStatus{
+		Symbol:    trade.Symbol,
+		Phase:     phase,
+		Until:     &until,
+		Price:     trade.Price,
+		Reference: reference,
+		Reason: fmt.Sprintf("price moved %.2f%% from %g to %g within %s",
+			math.Abs(trade.Price-reference)/reference*100, reference, trade.Price, volatility.Window),
+	})
+	if err != nil {
+		log.Printf("volatility: %v", err)
+		return
+	}
+	book.volatility.prices, book.volatility.resumeAt = nil, until
+}
+
+// holdForAuction keeps an order that reached a book in a phase that holds
+// orders for the auction that ends it. Market orders and orders that need a
+// minimum fill cannot take part, so they end unfilled, as do spread legs,
+// which must not fill later without their other leg.
+func holdForAuction(book *OrderBook, order Order) Order {
+	stampMatched(&order)
+	if order.Type == OrderTypeMarket || requiredFill(order) > 0 || order.SpreadOrderID != "" {
+		cancelUnfilled(&order)
+		return order
+	}
+	book.auction.pending = append(book.auction.pending, order)
+	return order
+}
+
+// reopenIfDue ends a book's interruption once its pause, and any uncross
+// window after it, is over, with an auction of everything that waited for
+// it. The guard starts again from the reopening price. It must run on the
+// book's matcher.
+func reopenIfDue(book *OrderBook, now time.Time) {
+	guard := &book.volatility
+	if guard.resumeAt.IsZero() || now.Before(guard.resumeAt) || !uncrossDue(book, guard.resumeAt, now) {
+		return
+	}
+	err := transitionPhase(book, MarketStatus{Symbol: book.status.Symbol, Phase: PhaseContinuous, Reason: "volatility pause over"})
+	if err != nil {
+		log.Printf("volatility: %v", err)
+		return
+	}
+	if auction := book.status.Auction; auction != nil && auction.Price > 0 {
+		guard.prices = []tradedPrice{{at: auction.At, price: auction.Price}}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// getMarketStatus fetches a symbol's market state over the API
+func getMarketStatus(t *testing.T) MarketStatus {
+	t.Helper()
+	result := serve(HTTPConfig{}, httptest.NewRequest("GET", "/api/v1/market-status", nil))
+	if result.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", result.Code, result.Body.String())
+	}
+	var status MarketStatus
+	json.NewDecoder(result.Body).Decode(&status)
+	return status
+}
+
+func TestVolatilityGuard_AuctionsAfterAFastMove(t *testing.T) {
+	setupTest()
+	clock := useDeterministicEngine(t, time.Date(2026, 1, 2, 9, 0, 0, 0, time.UTC))
+	volatility = VolatilityConfig{Move: 0.05, Window: 30 * time.Second, Pause: time.Minute, Action: VolatilityAuction}
+	server := httptest.NewServer(newServer(HTTPConfig{}))
+	defer server.Close()
+	public := dialFeed(t, server, "/api/v1/feed/public", "")
+	defer public.Close()
+	waitForFeeds(t, 1)
+
+	m, _ := matcherFor("")
+	processOn(m, Order{ID: "ask-1", Symbol: "DEFAULT", Side: SideSell, Price: 100.0, Quantity: 1})
+	processOn(m, Order{ID: "ask-2", Symbol: "DEFAULT", Side: SideSell, Price: 110.0, Quantity: 1})
+	processOn(m, Order{ID: "ask-3", Symbol: "DEFAULT", Side: SideSell, Price: 110.0, Quantity: 1})
+	if status := getMarketStatus(t); status.Phase != PhaseContinuous {
+		t.Fatalf("Expected the symbol trading continuously, got %+v", status)
+	}
+
+	// The second trade moves 10% from the first, so the rest of the buy waits
+	buy := processOn(m, Order{ID: "buy", Symbol: "DEFAULT", Side: SideBuy, Price: 110.0, Quantity: 3})
+	if trades := tradeStore.List(""); len(trades) != 2 || buy.FilledQuantity != 2 {
+		t.Fatalf("Expected trading to stop after the trade at 110, got %+v and %+v", trades, buy)
+	}
+	status := getMarketStatus(t)
+	if status.Phase != PhaseAuction || status.Price != 110.0 || status.Reference != 100.0 || !status.Until.Equal(clock.Now().Add(time.Minute)) {
+		t.Fatalf("Expected a volatility auction until a minute from now, got %+v", status)
+	}
+	msg, raw := readFeed(t, public, FeedMessageStatus)
+	if msg.Status == nil || msg.Status.Phase != PhaseAuction {
+		t.Errorf("Expected the interruption on the public feed, got %s", raw)
+	}
+
+	// Orders during the pause are collected rather than matched
+	processOn(m, Order{ID: "ask-4", Symbol: "DEFAULT", Side: SideSell, Price: 105.0, Quantity: 1})
+	if trades := tradeStore.List(""); len(trades) != 2 {
+		t.Fatalf("Expected nothing to trade during the auction, got %+v", trades)
+	}
+	if auction := m.auctionStatus(); auction.Pending != 2 {
+		t.Errorf("Expected the buy's remainder and the new ask waiting, got %+v", auction)
+	}
+
+	clock.Advance(time.Minute)
+	status = getMarketStatus(t)
+	if status.Phase != PhaseContinuous || status.Auction == nil || status.Auction.Price != 105.0 || status.Auction.Volume != 1 {
+		t.Fatalf("Expected trading to reopen with 1 at 105, got %+v", status)
+	}
+	if msg, raw := readFeed(t, public, FeedMessageStatus); msg.Status.Phase != PhaseContinuous {
+		t.Errorf("Expected the reopening on the public feed, got %s", raw)
+	}
+	if auction := m.auctionStatus(); auction.Mode != MatchingContinuous {
+		t.Errorf("Expected continuous matching again, got %+v", auction)
+	}
+	if snapshot := m.depthSnapshot(0); len(snapshot.Bids) != 0 || len(snapshot.Asks) != 1 || snapshot.Asks[0].Price != 110.0 {
+		t.Errorf("Expected only ask-3 left, got %+v", snapshot)
+	}
+}
+
+func TestVolatilityGuard_HaltRefusesOrders(t *testing.T) {
+	setupTest()
+	clock := useDeterministicEngine(t, time.Date(2026, 1, 2, 9, 0, 0, 0, time.UTC))
+	volatility = VolatilityConfig{Move: 0.05, Window: 30 * time.Second, Pause: time.Minute, Action: VolatilityHalt}
+
+	m, _ := matcherFor("")
+	processOn(m, Order{ID: "ask-1", Symbol: "DEFAULT", Side: SideSell, Price: 100.0, Quantity: 1})
+	processOn(m, Order{ID: "buy-1", Symbol: "DEFAULT", Side: SideBuy, Price: 100.0, Quantity: 1})
+	processOn(m, Order{ID: "ask-2", Symbol: "DEFAULT", Side: SideSell, Price: 104.0, Quantity: 1})
+
+	// A move within the guard trades as usual, once its window has passed
+	clock.Advance(10 * time.Second)
+	processOn(m, Order{ID: "buy-2", Symbol: "DEFAULT", Side: SideBuy, Price: 104.0, Quantity: 1})
+	clock.Advance(31 * time.Second)
+	processOn(m, Order{ID: "ask-3", Symbol: "DEFAULT", Side: SideSell, Price: 98.0, Quantity: 1})
+	processOn(m, Order{ID: "buy-3", Symbol: "DEFAULT", Side: SideBuy, Price: 98.0, Quantity: 1})
+	if status := getMarketStatus(t); status.Phase != PhaseContinuous {
+		t.Fatalf("Expected trading open after moves inside the window, got %+v", status)
+	}
+
+	processOn(m, Order{ID: "ask-4", Symbol: "DEFAULT", Side: SideSell, Price: 92.0, Quantity: 1})
+	processOn(m, Order{ID: "buy-4", Symbol: "DEFAULT", Side: SideBuy, Price: 92.0, Quantity: 1})
+	if status := getMarketStatus(t); status.Phase != PhaseHalted {
+		t.Fatalf("Expected a halt after the move from 98 to 92, got %+v", status)
+	}
+	refused := processOn(m, Order{ID: "buy-5", Symbol: "DEFAULT", Side: SideBuy, Price: 92.0, Quantity: 1})
+	if refused.Status != OrderStatusRejected || refused.RejectReason != ErrCodeTradingHalted {
+		t.Errorf("Expected orders refused while halted, got %+v", refused)
+	}
+
+	clock.Advance(time.Minute)
+	if accepted := processOn(m, Order{ID: "buy-6", Symbol: "DEFAULT", Side: SideBuy, Price: 91.0, Quantity: 1}); accepted.Status != OrderStatusPending {
+		t.Errorf("Expected orders taken again once the halt is over, got %+v", accepted)
+	}
+}
+
+func TestLoadConfig_VolatilityFlags(t *testing.T) {
+	cfg, err := loadConfig([]string{"-volatility-move", "0.1", "-volatility-window", "5s", "-volatility-action", "halt"})
+	if err != nil || cfg.Volatility.Move != 0.1 || cfg.Volatility.Window != 5*time.Second || cfg.Volatility.Pause != time.Minute || cfg.Volatility.Action != VolatilityHalt {
+		t.Errorf("Unexpected volatility config %+v (%v)", cfg.Volatility, err)
+	}
+	for _, args := range [][]string{{"-volatility-move", "-0.1"}, {"-volatility-pause", "0s"}, {"-volatility-action", "pause"}} {
+		if _, err := loadConfig(args); err == nil {
+			t.Errorf("Expected an error for %v", args)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"time"
+)
+
+// webhookQueueSize bounds the fills waiting for the webhook. A fill with no
+// room is dead-lettered rather than holding up the matcher.
+const webhookQueueSize = 4096
+
+// WebhookConfig posts every fill to an HTTP endpoint
+type WebhookConfig struct {
+	// URL receives each fill as a JSON POST; empty turns the fill webhook off
+	URL     string
+	Timeout time.Duration
+	// Attempts is how many times a fill is posted before it is dead-lettered
+	Attempts int
+	// RetryDelay is the wait before the first retry, doubling after each one
+	RetryDelay time.Duration
+	// DeadLetters caps the dead letters kept; the oldest are dropped first
+	DeadLetters int
+	// SchemaVersion is the version fills are posted in
+	SchemaVersion int
+}
+
+// WebhookEvent is the body posted to the fill webhook
+type WebhookEvent struct {
+	// ID is the same on every delivery of a fill, so a receiver can ignore
+	// the ones it already has
+	ID   string          `json:"id"`
+	Type FeedMessageType `json:"type"`
+	Fill *Fill           `json:"fill"`
+}
+
+// webhookPublisher posts fills to the webhook in the order they happened,
+// retrying each one before dead-lettering it
+type webhookPublisher struct {
+	cfg    WebhookConfig
+	client *http.Client
+	events chan WebhookEvent
+}
+
+// fillWebhook receives every fill; nil while the fill webhook is off
+var fillWebhook *webhookPublisher
+
+// errPermanent marks a delivery the endpoint refused outright, which a retry
+// would not change
+var errPermanent = errors.New("permanent failure")
+
+func startWebhooks(ctx context.Context, cfg WebhookConfig) {
+	deadLetterLimit = cfg.DeadLetters
+	if cfg.URL == "" {
+		return
+	}
+	fillWebhook = newWebhookPublisher(cfg)
+	go fillWebhook.run(ctx)
+	log.Printf("webhooks: posting fills to %s", cfg.URL)
+}
+
+func newWebhookPublisher(cfg WebhookConfig) *webhookPublisher {
+	return &webhookPublisher{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+		events: make(chan WebhookEvent, webhookQueueSize),
+	}
+}
+
+// fillEventID names a fill by its trade and which side of it the order was
+func fillEventID(fill *Fill) string {
+	return fill.TradeID + "-" + string(fill.Liquidity)
+}
+
+// publish queues a fill without blocking the matcher
+func (p *webhookPublisher) publish(fill *Fill) {
+	event := WebhookEvent{ID: fillEventID(fill), Type: FeedMessageFill, Fill: fill}
+	select {
+	case p.events <- event:
+	default:
+		addDeadLetter(event, p.cfg.URL, 0, "webhook queue full")
+	}
+}
+
+// run delivers the queued fills until ctx ends
+func (p *webhookPublisher) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-p.events:
+			if attempts, err := p.deliver(ctx, event); err != nil {
+				addDeadLetter(event, p.cfg.URL, attempts, err.Error())
+			}
+		}
+	}
+}
+
+// deliver posts an event until the endpoint takes it, it refuses it outright
+// or the attempts run out, returning how many posts it made
+func (p *webhookPublisher) deliver(ctx context.Context, event WebhookEvent) (int, error) {
+	delay := p.cfg.RetryDelay
+	for attempt := 1; ; attempt++ {
+		err := p.post(event)
+		if err == nil || errors.Is(err, errPermanent) || attempt >= p.cfg.Attempts {
+			return attempt, err
+		}
+		select {
+		case <-ctx.Done():
+			return attempt, err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// post makes one delivery. Any 2xx answer takes the event. A 4xx other than
+// 408 or 429 is permanent; everything else is worth retrying.
+func (p *webhookPublisher) post(event WebhookEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	body = versionJSON(body, reflect.TypeOf(event), p.cfg.SchemaVersion)
+	resp, err := p.client.Post(p.cfg.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode/100 == 2:
+		return nil
+	case resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests:
+		return fmt.Errorf("%w: webhook answered %s", errPermanent, resp.Status)
+	}
+	return fmt.Errorf("webhook answered %s", resp.Status)
+}
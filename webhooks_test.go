@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// webhookReceiver records the fills posted to it, answering each post with
+// the status answer returns
+type webhookReceiver struct {
+	mu     sync.Mutex
+	events []WebhookEvent
+	posts  atomic.Int64
+	answer func(post int64) int
+}
+
+func (rcv *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var event WebhookEvent
+	json.NewDecoder(r.Body).Decode(&event)
+	status := rcv.answer(rcv.posts.Add(1))
+	if status == http.StatusOK {
+		rcv.mu.Lock()
+		rcv.events = append(rcv.events, event)
+		rcv.mu.Unlock()
+	}
+	w.WriteHeader(status)
+}
+
+func (rcv *webhookReceiver) received() []WebhookEvent {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	return append([]WebhookEvent(nil), rcv.events...)
+}
+
+// useWebhook posts fills to a receiver answering with answer, retrying up to
+// attempts times, until the test ends
+func useWebhook(t *testing.T, attempts int, answer func(post int64) int) *webhookReceiver {
+	t.Helper()
+	receiver := &webhookReceiver{answer: answer}
+	server := httptest.NewServer(receiver)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		server.Close()
+		fillWebhook = nil
+	})
+	fillWebhook = newWebhookPublisher(WebhookConfig{URL: server.URL, Timeout: time.Second, Attempts: attempts, RetryDelay: time.Millisecond, SchemaVersion: currentSchemaVersion})
+	go fillWebhook.run(ctx)
+	return receiver
+}
+
+// deadLetterCount returns how many dead letters are held
+func deadLetterCount() int {
+	deadLettersMu.Lock()
+	defer deadLettersMu.Unlock()
+	return len(deadLetters)
+}
+
+func TestWebhooks_PostEveryFillRetryingFailures(t *testing.T) {
+	setupTest()
+	// The first two posts fail, as if the receiver were restarting
+	receiver := useWebhook(t, 3, func(post int64) int {
+		if post <= 2 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	})
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "ask", Side: SideSell, Price: 100, Quantity: 1})
+	placeOn(m, Order{ID: "bid", Side: SideBuy, Price: 100, Quantity: 1})
+
+	waitUntil(t, "both fills", func() bool { return len(receiver.received()) == 2 })
+	events := receiver.received()
+	if maker := events[0]; maker.Type != FeedMessageFill || maker.Fill.OrderID != "ask" || maker.ID != maker.Fill.TradeID+"-maker" {
+		t.Errorf("Expected the maker's fill first, got %+v", maker)
+	}
+	if taker := events[1]; taker.Fill.OrderID != "bid" || taker.Fill.Liquidity != LiquidityTaker {
+		t.Errorf("Expected the taker's fill second, got %+v", taker)
+	}
+	if count := deadLetterCount(); count != 0 {
+		t.Errorf("Expected nothing dead-lettered, got %d", count)
+	}
+}
+
+func TestWebhooks_DeadLetterWhatTheEndpointRefuses(t *testing.T) {
+	setupTest()
+	receiver := useWebhook(t, 3, func(int64) int { return http.StatusBadRequest })
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "ask", Side: SideSell, Price: 100, Quantity: 1})
+	placeOn(m, Order{ID: "bid", Side: SideBuy, Price: 100, Quantity: 1})
+
+	waitUntil(t, "both fills dead-lettered", func() bool { return deadLetterCount() == 2 })
+	// A 400 will not change on a retry, so each fill was posted only once
+	if posts := receiver.posts.Load(); posts != 2 {
+		t.Errorf("Expected one post per fill, got %d", posts)
+	}
+	if letter := deadLetters[0]; letter.Attempts != 1 || letter.Event.Fill.OrderID != "ask" || letter.ID != letter.Event.ID {
+		t.Errorf("Expected the maker's fill dead-lettered after one attempt, got %+v", letter)
+	}
+}
+
+func TestWebhooks_DeadLetterOnceTheAttemptsRunOut(t *testing.T) {
+	setupTest()
+	receiver := useWebhook(t, 2, func(int64) int { return http.StatusBadGateway })
+	m, _ := matcherFor("")
+	placeOn(m, Order{ID: "ask", Side: SideSell, Price: 100, Quantity: 1, Owner: "alice"})
+	placeOn(m, Order{ID: "bid", Side: SideBuy, Price: 100, Quantity: 1, Owner: "bob"})
+
+	waitUntil(t, "both fills dead-lettered", func() bool { return deadLetterCount() == 2 })
+	if posts := receiver.posts.Load(); posts != 4 {
+		t.Errorf("Expected two posts per fill, got %d", posts)
+	}
+	if letter, _ := lookupDeadLetter(deadLetters[1].ID); letter.Attempts != 2 || letter.Event.Fill.Owner != "bob" || letter.Error == "" {
+		t.Errorf("Expected the taker's fill kept with its last error, got %+v", letter)
+	}
+}
+
+func TestLoadConfig_WebhookFlags(t *testing.T) {
+	cfg, err := loadConfig([]string{"-webhook-url", "http://hooks.local/fills", "-webhook-attempts", "3"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.Webhooks.URL != "http://hooks.local/fills" || cfg.Webhooks.Attempts != 3 || cfg.Webhooks.DeadLetters != defaultDeadLetterLimit {
+		t.Errorf("Unexpected webhook config %+v", cfg.Webhooks)
+	}
+	if _, err := loadConfig([]string{"-webhook-attempts", "0"}); err == nil {
+		t.Error("Expected -webhook-attempts 0 to be refused")
+	}
+}